@@ -0,0 +1,133 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPresetSpec(t *testing.T) {
+	for _, p := range presets {
+		spec := p.spec()
+		if spec.Directs+spec.Groups != p.Conversations {
+			t.Errorf("%s: %d directs and %d groups, want %d conversations", p.Name, spec.Directs, spec.Groups, p.Conversations)
+		}
+		if spec.Users != p.Users || spec.Messages != p.Messages {
+			t.Errorf("%s: spec has %d users and %d messages, want %d and %d", p.Name, spec.Users, spec.Messages, p.Users, p.Messages)
+		}
+		if err := spec.Validate(); err != nil {
+			t.Errorf("%s: %v", p.Name, err)
+		}
+	}
+
+	spec := preset{Users: 10, Conversations: 10, Messages: 100}.spec()
+	if spec.Directs != 7 || spec.Groups != 3 {
+		t.Errorf("10 conversations split into %d directs and %d groups, want 7 and 3", spec.Directs, spec.Groups)
+	}
+}
+
+func TestFindPreset(t *testing.T) {
+	if p, ok := findPreset("medium"); !ok || p.Name != "medium" {
+		t.Errorf("findPreset(medium) = %+v, %v", p, ok)
+	}
+	if _, ok := findPreset("huge"); ok {
+		t.Error("findPreset found a preset that doesn't exist")
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	var samples []time.Duration
+	for i := 100; i >= 1; i-- {
+		samples = append(samples, time.Duration(i)*time.Millisecond)
+	}
+	got := summarize(samples)
+	want := Latency{Mean: 50.5, P50: 51, P95: 96, P99: 100}
+	if got != want {
+		t.Errorf("summarize = %+v, want %+v", got, want)
+	}
+	if got := summarize(nil); got != (Latency{}) {
+		t.Errorf("summarize(nil) = %+v, want zero", got)
+	}
+}
+
+func result(name string, users int, bytesPer1k float64, read, write float64) PresetResult {
+	return PresetResult{
+		preset:             preset{Name: name, Users: users},
+		BytesPer1kMessages: bytesPer1k,
+		Latencies: map[string]Latency{
+			opList:   {Mean: read},
+			opFetch:  {Mean: read},
+			opSearch: {Mean: read},
+			opSend:   {Mean: write},
+		},
+	}
+}
+
+func TestProject(t *testing.T) {
+	results := []PresetResult{
+		result("small", 100, 100_000, 1, 2),
+		result("medium", 1000, 200_000, 2, 4),
+		result("large", 5000, 300_000, 3, 6),
+	}
+	a := Assumptions{Users: 500, MessagesPerUserDay: 10, RetentionDays: 100, PeakOnline: 0.5, OpsPerUserSecond: 1, WriteShare: 0.5}
+	p := project(a, results)
+
+	if p.BasedOn != "medium" {
+		t.Errorf("based on %s, want the smallest preset at least as large, medium", p.BasedOn)
+	}
+	if p.MessagesRetained != 500_000 {
+		t.Errorf("retained %d messages, want 500000", p.MessagesRetained)
+	}
+	if p.StorageBytes != 100_000_000 || p.RecommendedDiskBytes != 200_000_000 {
+		t.Errorf("storage %d, disk %d, want 100000000 and double", p.StorageBytes, p.RecommendedDiskBytes)
+	}
+	if p.PeakOpsPerSec != 250 {
+		t.Errorf("peak %v ops/s, want 250", p.PeakOpsPerSec)
+	}
+	// 250 ops/s at a 2ms read / 4ms write mix is 0.75 busy seconds a second
+	if p.CPUCores != 2 {
+		t.Errorf("%d cores, want 2", p.CPUCores)
+	}
+	if p.WriteUtilization != 0.5 {
+		t.Errorf("write utilization %v, want 0.5", p.WriteUtilization)
+	}
+	if want := int64(baseMemory + 250*connectionMemory + 10_000_000); p.MemoryBytes != want {
+		t.Errorf("memory %d, want %d", p.MemoryBytes, want)
+	}
+	if len(p.Warnings) != 0 {
+		t.Errorf("unexpected warnings %v", p.Warnings)
+	}
+}
+
+func TestProjectWarnings(t *testing.T) {
+	results := []PresetResult{result("small", 100, 100_000, 1, 10)}
+	p := project(Assumptions{Users: 1000, PeakOnline: 1, OpsPerUserSecond: 1, WriteShare: 0.5}, results)
+	if p.BasedOn != "small" {
+		t.Errorf("based on %s, want the largest measured, small", p.BasedOn)
+	}
+	// 500 sends a second at 10ms each is five times what one writer can do
+	if len(p.Warnings) != 2 || !strings.Contains(p.Warnings[0], "as large") || !strings.Contains(p.Warnings[1], "writer") {
+		t.Errorf("warnings = %v, want the size and writer warnings", p.Warnings)
+	}
+
+	if p := project(defaultAssumptions(), nil); p.BasedOn != "" || p.StorageBytes != 0 {
+		t.Errorf("projection without results = %+v", p)
+	}
+}
+
+func TestRunPreset(t *testing.T) {
+	p := preset{Name: "tiny", Users: 10, Conversations: 10, Messages: 200}
+	r, err := runPreset(filepath.Join(t.TempDir(), "tiny.db"), p, 5, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.DBBytes == 0 || r.BytesPer1kMessages <= 0 {
+		t.Errorf("measured %d bytes, %v per 1k messages", r.DBBytes, r.BytesPer1kMessages)
+	}
+	for _, op := range operations {
+		if l, ok := r.Latencies[op]; !ok || l.P99 < l.P50 {
+			t.Errorf("%s latency %+v", op, l)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// Command capacity answers "how big a server do I need for N users". It
+// builds synthetic datasets of increasing size in a disposable SQLite
+// database, times the operations clients issue most (listing conversations,
+// fetching history, searching users, sending), and writes a JSON report with
+// storage per 1k messages, latencies at each size and projected needs for a
+// target user count.
+//
+// The measurements go straight to the database layer, which is where
+// latency grows with the dataset; HTTP and websocket overhead is roughly
+// constant per request and is what cmd/loadtest measures.
+//
+//	go run ./cmd/capacity -presets small,medium -users 500 -out report.json
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"messager/internal/synth"
+)
+
+// preset is one dataset size to generate and measure
+type preset struct {
+	Name          string `json:"name"`
+	Users         int    `json:"users"`
+	Conversations int    `json:"conversations"`
+	Messages      int    `json:"messages"`
+}
+
+var presets = []preset{
+	{Name: "small", Users: 100, Conversations: 200, Messages: 20000},
+	{Name: "medium", Users: 1000, Conversations: 2000, Messages: 200000},
+	{Name: "large", Users: 5000, Conversations: 10000, Messages: 1000000},
+}
+
+const (
+	// directShare is the fraction of conversations that are direct
+	directShare = 0.7
+	// maxGroupSize caps generated groups at the server default
+	maxGroupSize = 256
+	// history spreads generated messages over this much time
+	history = 90 * 24 * time.Hour
+)
+
+// spec is the dataset p generates
+func (p preset) spec() synth.Spec {
+	directs := int(math.Round(float64(p.Conversations) * directShare))
+	return synth.Spec{
+		Users:        p.Users,
+		Directs:      directs,
+		Groups:       p.Conversations - directs,
+		MaxGroupSize: maxGroupSize,
+		Messages:     p.Messages,
+		History:      history,
+		NamePrefix:   "capacity_user_",
+	}
+}
+
+func findPreset(name string) (preset, bool) {
+	for _, p := range presets {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return preset{}, false
+}
+
+func main() {
+	presetNames := flag.String("presets", "small,medium,large", "comma-separated dataset sizes to measure (small, medium, large)")
+	dir := flag.String("dir", "", "directory for the disposable databases (default: a new temp directory)")
+	keep := flag.Bool("keep", false, "keep the generated databases")
+	out := flag.String("out", "-", "where to write the JSON report, - for stdout")
+	iterations := flag.Int("iterations", 200, "timed calls per operation and dataset")
+	seed := flag.Int64("seed", 1, "random seed, so runs are repeatable")
+	assume := defaultAssumptions()
+	flag.IntVar(&assume.Users, "users", assume.Users, "user count to project resource needs for")
+	flag.Float64Var(&assume.MessagesPerUserDay, "messages-per-user-day", assume.MessagesPerUserDay, "messages each user sends per day")
+	flag.IntVar(&assume.RetentionDays, "retention-days", assume.RetentionDays, "days of history kept")
+	flag.Float64Var(&assume.PeakOnline, "peak-online", assume.PeakOnline, "fraction of users online at peak")
+	flag.Parse()
+
+	var selected []preset
+	for _, name := range strings.Split(*presetNames, ",") {
+		p, ok := findPreset(strings.TrimSpace(name))
+		if !ok {
+			log.Fatalf("Unknown preset %q", name)
+		}
+		selected = append(selected, p)
+	}
+
+	workDir := *dir
+	if workDir == "" {
+		tmp, err := os.MkdirTemp("", "messager-capacity-")
+		if err != nil {
+			log.Fatalf("Failed to create temp directory: %v", err)
+		}
+		workDir = tmp
+		if !*keep {
+			defer os.RemoveAll(tmp)
+		}
+	}
+
+	report := Report{
+		GeneratedAt: time.Now().UTC(),
+		Iterations:  *iterations,
+		Seed:        *seed,
+	}
+	for _, p := range selected {
+		path := filepath.Join(workDir, "capacity-"+p.Name+".db")
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Fatalf("Failed to clear %s: %v", path, err)
+		}
+
+		log.Printf("Generating %s dataset: %d users, %d conversations, %d messages", p.Name, p.Users, p.Conversations, p.Messages)
+		result, err := runPreset(path, p, *iterations, *seed)
+		if err != nil {
+			log.Fatalf("Preset %s failed: %v", p.Name, err)
+		}
+		log.Printf("%s: %.0f bytes per 1k messages, generated in %.1fs", p.Name, result.BytesPer1kMessages, result.GenerateSeconds)
+		for _, op := range operations {
+			l := result.Latencies[op]
+			log.Printf("  %-6s p50 %.2fms  p95 %.2fms  p99 %.2fms", op, l.P50, l.P95, l.P99)
+		}
+		report.Presets = append(report.Presets, result)
+
+		if !*keep {
+			os.Remove(path)
+		}
+	}
+	if *keep {
+		log.Printf("Databases kept in %s", workDir)
+	}
+
+	report.Projection = project(assume, report.Presets)
+	log.Printf("Projection for %d users (from %s): %.1f GB storage, %.0f ops/s at peak, %d cores, %d MB memory",
+		assume.Users, report.Projection.BasedOn, float64(report.Projection.StorageBytes)/1e9,
+		report.Projection.PeakOpsPerSec, report.Projection.CPUCores, report.Projection.MemoryBytes>>20)
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatalf("Failed to create report: %v", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		log.Fatalf("Failed to write report: %v", err)
+	}
+	if *out != "-" {
+		fmt.Fprintf(os.Stderr, "Report written to %s\n", *out)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+
+	"messager/internal/db"
+	"messager/internal/synth"
+)
+
+// Operations timed for every dataset
+const (
+	opList   = "list"   // a user's conversation list
+	opFetch  = "fetch"  // the newest page of a conversation
+	opSearch = "search" // a username search
+	opSend   = "send"   // storing a message
+)
+
+var operations = []string{opList, opFetch, opSearch, opSend}
+
+// pageSize matches the page clients fetch when opening a conversation
+const pageSize = 50
+
+// runPreset generates p into a fresh database at path and times each
+// operation iterations times against it
+func runPreset(path string, p preset, iterations int, seed int64) (PresetResult, error) {
+	result := PresetResult{preset: p, Latencies: make(map[string]Latency)}
+
+	d, err := db.NewDB(path)
+	if err != nil {
+		return result, err
+	}
+	defer d.Close()
+
+	ctx := context.Background()
+	rng := rand.New(rand.NewSource(seed))
+	gen := synth.New(d, rng, time.Now())
+	spec := p.spec()
+	started := time.Now()
+	ds, err := gen.Populate(ctx, spec)
+	if err != nil {
+		return result, err
+	}
+	before, err := fileSize(path)
+	if err != nil {
+		return result, err
+	}
+	if err := gen.AddHistory(ctx, ds, spec); err != nil {
+		return result, err
+	}
+	result.GenerateSeconds = time.Since(started).Seconds()
+
+	result.DBBytes, err = fileSize(path)
+	if err != nil {
+		return result, err
+	}
+	result.BytesPer1kMessages = float64(result.DBBytes-before) / float64(p.Messages) * 1000
+
+	runs := map[string]func() error{
+		opList: func() error {
+			_, err := d.GetUserConversations(ctx, ds.Users[rng.Intn(len(ds.Users))].ID)
+			return err
+		},
+		opFetch: func() error {
+			_, err := d.GetConversationMessagesBefore(ctx, ds.Pick().ID, nil, pageSize)
+			return err
+		},
+		opSearch: func() error {
+			// A partial name, the way people type into the search box
+			name := ds.Users[rng.Intn(len(ds.Users))].Username
+			_, err := d.SearchUsers(ctx, name[:len(name)-1])
+			return err
+		},
+		opSend: func() error {
+			conv := ds.Pick()
+			_, err := d.CreateMessage(ctx, conv.ID, conv.Participants[rng.Intn(len(conv.Participants))], synth.Content(rng))
+			return err
+		},
+	}
+	for _, op := range operations {
+		samples := make([]time.Duration, 0, iterations)
+		for i := 0; i < iterations; i++ {
+			start := time.Now()
+			if err := runs[op](); err != nil {
+				return result, fmt.Errorf("%s failed: %v", op, err)
+			}
+			samples = append(samples, time.Since(start))
+		}
+		result.Latencies[op] = summarize(samples)
+	}
+	return result, nil
+}
+
+// summarize reduces samples to milliseconds at a few percentiles
+func summarize(samples []time.Duration) Latency {
+	if len(samples) == 0 {
+		return Latency{}
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+
+	var total time.Duration
+	for _, s := range samples {
+		total += s
+	}
+	percentile := func(p float64) float64 {
+		i := int(float64(len(samples)) * p)
+		if i >= len(samples) {
+			i = len(samples) - 1
+		}
+		return ms(samples[i])
+	}
+	return Latency{
+		Mean: ms(total / time.Duration(len(samples))),
+		P50:  percentile(0.50),
+		P95:  percentile(0.95),
+		P99:  percentile(0.99),
+	}
+}
+
+func ms(d time.Duration) float64 {
+	return math.Round(float64(d)/float64(time.Microsecond)) / 1000
+}
+
+func fileSize(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// Report is the machine-readable output. Latencies are in milliseconds and
+// sizes in bytes.
+type Report struct {
+	GeneratedAt time.Time      `json:"generated_at"`
+	Iterations  int            `json:"iterations"`
+	Seed        int64          `json:"seed"`
+	Presets     []PresetResult `json:"presets"`
+	Projection  Projection     `json:"projection"`
+}
+
+// PresetResult is what was measured for one dataset size
+type PresetResult struct {
+	preset
+	DBBytes            int64              `json:"db_bytes"`
+	BytesPer1kMessages float64            `json:"bytes_per_1k_messages"`
+	GenerateSeconds    float64            `json:"generate_seconds"`
+	Latencies          map[string]Latency `json:"latencies"`
+}
+
+// Latency summarizes one operation's timings in milliseconds
+type Latency struct {
+	Mean float64 `json:"mean_ms"`
+	P50  float64 `json:"p50_ms"`
+	P95  float64 `json:"p95_ms"`
+	P99  float64 `json:"p99_ms"`
+}
+
+// Assumptions describe the deployment being projected. The traffic defaults
+// follow cmd/loadtest: each online user issues about one request a second,
+// half of them sends.
+type Assumptions struct {
+	Users              int     `json:"users"`
+	MessagesPerUserDay float64 `json:"messages_per_user_day"`
+	RetentionDays      int     `json:"retention_days"`
+	PeakOnline         float64 `json:"peak_online"`
+	OpsPerUserSecond   float64 `json:"ops_per_user_second"`
+	WriteShare         float64 `json:"write_share"`
+}
+
+func defaultAssumptions() Assumptions {
+	return Assumptions{
+		Users:              500,
+		MessagesPerUserDay: 100,
+		RetentionDays:      365,
+		PeakOnline:         0.3,
+		OpsPerUserSecond:   1,
+		WriteShare:         0.5,
+	}
+}
+
+// Projection estimates what a deployment described by Assumptions needs
+type Projection struct {
+	Assumptions
+	// BasedOn names the preset whose measurements were used: the smallest
+	// one at least as large as the deployment, or the largest measured
+	BasedOn              string  `json:"based_on"`
+	MessagesRetained     int64   `json:"messages_retained"`
+	StorageBytes         int64   `json:"storage_bytes"`
+	RecommendedDiskBytes int64   `json:"recommended_disk_bytes"`
+	PeakOpsPerSec        float64 `json:"peak_ops_per_sec"`
+	CPUCores             int     `json:"cpu_cores"`
+	// WriteUtilization is the share of time the database spends writing at
+	// peak. SQLite runs one write at a time, so sends start queueing well
+	// before this reaches 1.
+	WriteUtilization float64  `json:"write_utilization"`
+	MemoryBytes      int64    `json:"memory_bytes"`
+	Warnings         []string `json:"warnings,omitempty"`
+}
+
+const (
+	// baseMemory covers the process itself and its caches
+	baseMemory = 128 << 20
+	// connectionMemory covers a websocket's buffers and queued frames
+	connectionMemory = 64 << 10
+	// maxPageCache bounds the share of the database worth keeping hot
+	maxPageCache = 4 << 30
+)
+
+func project(a Assumptions, results []PresetResult) Projection {
+	p := Projection{Assumptions: a}
+	if len(results) == 0 {
+		return p
+	}
+
+	basis := results[len(results)-1]
+	for _, r := range results {
+		if r.Users >= a.Users && r.Users < basis.Users {
+			basis = r
+		}
+	}
+	p.BasedOn = basis.Name
+	if basis.Users < a.Users {
+		p.Warnings = append(p.Warnings, "no measured preset is as large as the deployment; latencies are likely optimistic")
+	}
+
+	p.MessagesRetained = int64(float64(a.Users) * a.MessagesPerUserDay * float64(a.RetentionDays))
+	p.StorageBytes = int64(float64(p.MessagesRetained) / 1000 * basis.BytesPer1kMessages)
+	// Room for VACUUM, which rewrites the whole file, and for growth
+	p.RecommendedDiskBytes = p.StorageBytes * 2
+
+	online := float64(a.Users) * a.PeakOnline
+	p.PeakOpsPerSec = online * a.OpsPerUserSecond
+
+	read := (basis.Latencies[opList].Mean + basis.Latencies[opFetch].Mean + basis.Latencies[opSearch].Mean) / 3
+	write := basis.Latencies[opSend].Mean
+	busy := p.PeakOpsPerSec * ((1-a.WriteShare)*read + a.WriteShare*write) / 1000
+	// Keep half of each core free for request handling and bursts
+	p.CPUCores = int(math.Max(1, math.Ceil(busy*2)))
+	p.WriteUtilization = math.Round(p.PeakOpsPerSec*a.WriteShare*write/1000*1000) / 1000
+	if p.WriteUtilization > 0.7 {
+		p.Warnings = append(p.Warnings, "peak sends would keep the database writer busy most of the time; expect queueing")
+	}
+
+	p.MemoryBytes = baseMemory + int64(online)*connectionMemory + int64(math.Min(float64(p.StorageBytes)/10, maxPageCache))
+	return p
+}
@@ -0,0 +1,76 @@
+// Command reencrypt rewrites stored message content under the current
+// MESSAGE_ENCRYPTION_KEY, to finish a key rotation or to encrypt history
+// written before encryption was turned on. With no current key it decrypts
+// everything back to plaintext instead. Content sealed with an old key is
+// read using MESSAGE_ENCRYPTION_PREVIOUS_KEYS, which must still list it.
+// Content sealed before it was bound to its message ID is rewritten too,
+// even under the current key.
+//
+// It can run while the server is up, a batch per transaction. Once it
+// reports nothing left to rewrite, old keys can be dropped from
+// MESSAGE_ENCRYPTION_PREVIOUS_KEYS.
+//
+//	MESSAGE_ENCRYPTION_KEY=... MESSAGE_ENCRYPTION_PREVIOUS_KEYS=... go run ./cmd/reencrypt
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"time"
+
+	"messager/internal/config"
+	"messager/internal/db"
+)
+
+func main() {
+	dbURL := flag.String("db", "", "database URL or path (default: DATABASE_URL)")
+	batch := flag.Int("batch", 500, "messages to look at per transaction")
+	pause := flag.Duration("pause", 50*time.Millisecond, "pause between batches, to leave room for other writers")
+	flag.Parse()
+
+	if *batch < 1 {
+		log.Fatalf("-batch must be at least 1")
+	}
+
+	cfg := config.Load()
+	if *dbURL != "" {
+		cfg.DatabaseURL = *dbURL
+	}
+	d, err := db.NewDB(cfg.CleanDatabasePath())
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer d.Close()
+
+	current, previous, err := cfg.MessageEncryptionKeys()
+	if err == nil {
+		err = d.SetContentKeys(current, previous...)
+	}
+	if err != nil {
+		log.Fatalf("Invalid message encryption configuration: %v", err)
+	}
+	if current == nil {
+		log.Printf("MESSAGE_ENCRYPTION_KEY is unset; decrypting message content to plaintext")
+	}
+
+	ctx := context.Background()
+	afterID, batches, rewritten := int64(0), 0, 0
+	for {
+		lastID, n, err := d.ReencryptMessages(ctx, afterID, *batch)
+		if err != nil {
+			log.Fatalf("Failed to re-encrypt messages after ID %d: %v", afterID, err)
+		}
+		if lastID == 0 {
+			break
+		}
+		rewritten += n
+		batches++
+		if batches%20 == 0 {
+			log.Printf("Rewrote %d messages so far, up to ID %d", rewritten, lastID)
+		}
+		afterID = lastID
+		time.Sleep(*pause)
+	}
+	log.Printf("Rewrote %d messages; nothing left to rewrite", rewritten)
+}
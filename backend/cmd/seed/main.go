@@ -0,0 +1,169 @@
+// Command seed fills a development database with users, direct and group
+// conversations, and a few weeks of message history, so a fresh checkout
+// has something to click through. Everything goes through the db package,
+// the same code paths the server uses.
+//
+// Every user logs in with the -password given. Seeding is skipped when the
+// first seed user already exists; -wipe empties every table first.
+//
+//	go run ./cmd/seed -users 30 -groups 8 -weeks 4
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"messager/internal/config"
+	"messager/internal/db"
+	"messager/internal/synth"
+)
+
+// names are given to the first users, in order; the rest are numbered.
+// The first one doubles as the marker that the database is already seeded.
+var names = []string{
+	"alice", "bob", "carol", "dave", "erin", "frank", "grace", "heidi",
+	"ivan", "judy", "mallory", "niaj", "olivia", "peggy", "rupert", "sybil",
+	"trent", "victor", "walter", "yolanda",
+}
+
+func main() {
+	dbURL := flag.String("db", "", "database URL or path (default: DATABASE_URL)")
+	users := flag.Int("users", 20, "users to create")
+	directs := flag.Int("directs", 15, "direct conversations to create")
+	groups := flag.Int("groups", 6, "group conversations to create")
+	maxGroup := flag.Int("max-group", 8, "largest group size")
+	messages := flag.Int("messages", 40, "average messages per conversation")
+	weeks := flag.Int("weeks", 4, "weeks of history to spread messages over")
+	password := flag.String("password", "password123", "password every seeded user logs in with")
+	seedFlag := flag.Int64("seed", 1, "random seed, so runs are repeatable")
+	wipe := flag.Bool("wipe", false, "empty every table before seeding")
+	flag.Parse()
+
+	if *users < 2 {
+		log.Fatalf("-users must be at least 2")
+	}
+	if *directs > *users*(*users-1)/2 {
+		log.Fatalf("-directs must be at most %d for %d users", *users*(*users-1)/2, *users)
+	}
+	if *maxGroup > *users {
+		*maxGroup = *users
+	}
+
+	cfg := config.Load()
+	if *dbURL != "" {
+		cfg.DatabaseURL = *dbURL
+	}
+	d, err := db.NewDB(cfg.CleanDatabasePath())
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer d.Close()
+
+	ctx := context.Background()
+	if *wipe {
+		tables, err := truncate(ctx, d)
+		if err != nil {
+			log.Fatalf("Failed to wipe database: %v", err)
+		}
+		log.Printf("Wiped %d tables", tables)
+	}
+
+	_, err = d.GetUserByUsername(ctx, names[0])
+	if err == nil {
+		log.Printf("Already seeded (user %s exists); pass -wipe to start over", names[0])
+		return
+	}
+	if !errors.Is(err, db.ErrNotFound) {
+		log.Fatalf("Failed to check for seed data: %v", err)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(*password), bcrypt.DefaultCost)
+	if err != nil {
+		log.Fatalf("Failed to hash password: %v", err)
+	}
+	ds, err := seed(ctx, d, rand.New(rand.NewSource(*seedFlag)), time.Now(), synth.Spec{
+		Users:        *users,
+		Directs:      *directs,
+		Groups:       *groups,
+		MaxGroupSize: *maxGroup,
+		Messages:     *messages * (*directs + *groups),
+		History:      time.Duration(*weeks) * 7 * 24 * time.Hour,
+		Names:        names,
+		NamePrefix:   "user",
+		PasswordHash: string(hash),
+	})
+	if err != nil {
+		log.Fatalf("Seeding failed: %v", err)
+	}
+	log.Printf("Seeded %d users, %d conversations and %d messages; log in as %s / %s",
+		len(ds.Users), len(ds.Conversations), ds.Messages, names[0], *password)
+}
+
+// seed generates spec into d
+func seed(ctx context.Context, d *db.DB, rng *rand.Rand, now time.Time, spec synth.Spec) (*synth.Dataset, error) {
+	gen := synth.New(d, rng, now)
+	ds, err := gen.Populate(ctx, spec)
+	if err != nil {
+		return nil, err
+	}
+	if err := gen.AddHistory(ctx, ds, spec); err != nil {
+		return nil, err
+	}
+	return ds, nil
+}
+
+// truncate empties every table except the migration history, and resets
+// the ID counters so a reseed starts from 1 again
+func truncate(ctx context.Context, d *db.DB) (int, error) {
+	conn, err := d.DB.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	rows, err := conn.QueryContext(ctx, `
+		SELECT name FROM sqlite_master
+		WHERE type = 'table' AND name != 'schema_migrations' AND name NOT LIKE 'sqlite_%'
+	`)
+	if err != nil {
+		return 0, err
+	}
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		tables = append(tables, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	// The order of the deletes would otherwise have to follow the foreign
+	// keys; the pragma only affects this connection
+	if _, err := conn.ExecContext(ctx, `PRAGMA foreign_keys = OFF`); err != nil {
+		return 0, err
+	}
+	defer conn.ExecContext(ctx, `PRAGMA foreign_keys = ON`)
+	statements := make([]string, 0, len(tables)+1)
+	for _, table := range tables {
+		statements = append(statements, fmt.Sprintf(`DELETE FROM "%s"`, strings.ReplaceAll(table, `"`, `""`)))
+	}
+	statements = append(statements, `DELETE FROM sqlite_sequence`)
+	for _, stmt := range statements {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return 0, fmt.Errorf("%s: %v", stmt, err)
+		}
+	}
+	return len(tables), nil
+}
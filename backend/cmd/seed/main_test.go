@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"messager/internal/db"
+	"messager/internal/db/testdb"
+	"messager/internal/synth"
+)
+
+func count(t *testing.T, d *db.DB, query string, args ...any) int {
+	t.Helper()
+	var n int
+	if err := d.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestSeed(t *testing.T) {
+	d := testdb.Open(t)
+	ctx := context.Background()
+	now := time.Now().UTC()
+	spec := synth.Spec{
+		Users:        6,
+		Directs:      4,
+		Groups:       2,
+		MaxGroupSize: 4,
+		Messages:     30,
+		History:      7 * 24 * time.Hour,
+		Names:        names,
+		NamePrefix:   "user",
+		PasswordHash: "hash",
+	}
+
+	ds, err := seed(ctx, d, rand.New(rand.NewSource(1)), now, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ds.Users) != 6 || len(ds.Conversations) != 6 || ds.Messages != 30 {
+		t.Errorf("dataset has %d users, %d conversations and %d messages, want 6, 6 and 30", len(ds.Users), len(ds.Conversations), ds.Messages)
+	}
+	for table, want := range map[string]int{"users": 6, "conversations": 6, "messages": 30} {
+		if n := count(t, d, `SELECT COUNT(*) FROM `+table); n != want {
+			t.Errorf("%d rows in %s, want %d", n, table, want)
+		}
+	}
+	if n := count(t, d, `SELECT COUNT(*) FROM conversations WHERE type = 'direct'`); n != 4 {
+		t.Errorf("%d direct conversations, want 4", n)
+	}
+	if n := count(t, d, `SELECT MAX(c) FROM (SELECT COUNT(*) AS c FROM conversation_participants GROUP BY conversation_id)`); n > 4 {
+		t.Errorf("a conversation has %d members, more than the largest group of 4", n)
+	}
+	if _, err := d.GetUserByUsername(ctx, names[0]); err != nil {
+		t.Errorf("the first seed user isn't %s: %v", names[0], err)
+	}
+	if n := count(t, d, `SELECT COUNT(*) FROM messages
+		WHERE unixepoch(created_at, 'subsec') > unixepoch(?, 'subsec') OR unixepoch(created_at, 'subsec') < unixepoch(?, 'subsec')`,
+		now, now.Add(-spec.History)); n != 0 {
+		t.Errorf("%d messages outside the %s of history", n, spec.History)
+	}
+
+	// Wiping empties everything and a reseed starts the IDs over
+	tables, err := truncate(ctx, d)
+	if err != nil || tables == 0 {
+		t.Fatalf("truncate: %d tables, %v", tables, err)
+	}
+	if n := count(t, d, `SELECT COUNT(*) FROM users`); n != 0 {
+		t.Errorf("%d users after wiping", n)
+	}
+	ds, err = seed(ctx, d, rand.New(rand.NewSource(1)), now, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ds.Users[0].ID != 1 || ds.Users[0].Username != names[0] {
+		t.Errorf("first user after a reseed is %d %s, want 1 %s", ds.Users[0].ID, ds.Users[0].Username, names[0])
+	}
+}
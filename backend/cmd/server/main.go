@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"net/http"
@@ -11,8 +12,18 @@ import (
 	"time"
 
 	"messager/internal/api"
+	"messager/internal/bus"
+	"messager/internal/chaos"
 	"messager/internal/config"
 	"messager/internal/db"
+	"messager/internal/errsink"
+	"messager/internal/mirror"
+	"messager/internal/logsafe"
+	"messager/internal/mail"
+	"messager/internal/models"
+	"messager/internal/retention"
+	"messager/internal/storage"
+	"messager/internal/version"
 	"messager/internal/websocket"
 )
 
@@ -26,7 +37,7 @@ func main() {
 	flag.Parse()
 
 	logger := setupLogger()
-	logger.Println("Starting server...")
+	logger.Printf("Starting server %s", version.Get())
 
 	// Load configuration
 	cfg := config.Load()
@@ -49,7 +60,8 @@ func main() {
 		logger.Printf("Using load testing database: %s", loadTestPath)
 	}
 
-	logger.Printf("Loaded configuration: %+v\n", cfg)
+	logger.Printf("Loaded configuration: %s", cfg.BannerSummary())
+	logsafe.SetLogContent(cfg.LogMessageContent)
 
 	// Initialize database with clean path
 	database, err := db.NewDB(cfg.CleanDatabasePath())
@@ -57,15 +69,143 @@ func main() {
 		logger.Fatalf("Failed to connect to database: %v", err)
 	}
 	defer database.Close()
+	database.SetRecoveryProbeInterval(cfg.DBRecoveryProbeInterval)
+	database.SetStatementTimeout(cfg.DBStatementTimeout)
+	database.SetSlowQueryThreshold(cfg.DBSlowQueryThreshold)
+	database.SetMembershipCacheTTL(cfg.DBMembershipCacheTTL)
+	currentKey, previousKeys, err := cfg.MessageEncryptionKeys()
+	if err == nil {
+		err = database.SetContentKeys(currentKey, previousKeys...)
+	}
+	if err != nil {
+		logger.Fatalf("Invalid message encryption configuration: %v", err)
+	}
+	database.SetPool(db.PoolOptions{
+		MaxOpenConns:    cfg.DBMaxOpenConns,
+		MaxIdleConns:    cfg.DBMaxIdleConns,
+		ConnMaxLifetime: cfg.DBConnMaxLifetime,
+	})
 	logger.Println("Database connection established")
 
+	for _, readURL := range cfg.ReadDatabaseURLs {
+		if err := database.OpenReader(config.CleanSQLitePath(readURL)); err != nil {
+			logger.Fatalf("Failed to connect to read database: %v", err)
+		}
+	}
+	if len(cfg.ReadDatabaseURLs) > 0 {
+		logger.Printf("Read-only database connections established: %d", len(cfg.ReadDatabaseURLs))
+	}
+
+	// Resolve file storage roots up front so a bad STORAGE_DIR fails at boot
+	store := storage.New(storage.OSFS{})
+	for _, root := range []string{storage.RootAvatars, storage.RootAttachments, storage.RootExports, storage.RootBackups} {
+		if err := store.AddRoot(root, filepath.Join(cfg.StorageDir, root), cfg.StorageQuotaBytes); err != nil {
+			logger.Fatalf("Failed to initialize storage: %v", err)
+		}
+	}
+	logger.Printf("File storage ready under %s", cfg.StorageDir)
+
 	// Initialize WebSocket hub
-	hub := websocket.NewHub(database)
+	hub := websocket.NewHub(database, cfg)
+
+	// Fault injection for resilience testing; inert until a profile is set
+	var injector *chaos.Injector
+	if cfg.ChaosEnabled {
+		injector = chaos.New()
+		database.SetChaos(injector)
+		hub.SetChaos(injector)
+		logger.Println("WARNING: fault injection is enabled, do not run this in production")
+	}
+
+	// Swallowed errors are always counted; strict mode also reports them
+	errs := errsink.New(errsink.Options{Strict: cfg.DevStrict, StrictPanic: cfg.DevStrictPanic})
+	hub.SetErrSink(errs)
+	if cfg.DevStrict {
+		logger.Println("WARNING: strict mode is enabled, swallowed errors are sent to clients")
+	}
+
+	// Optionally mirror opted-in conversations to a NATS broker
+	if cfg.NATSURL != "" {
+		publisher, err := mirror.NewNATSPublisher(cfg.NATSURL)
+		if err != nil {
+			logger.Fatalf("Failed to configure NATS mirror: %v", err)
+		}
+		m := mirror.New(publisher, cfg.MirrorTopic, cfg.DeliveryLimits())
+		defer m.Close()
+		hub.SetMirror(m)
+		logger.Printf("Mirroring opted-in conversations to %s", cfg.MirrorTopic)
+	}
+
+	// Replicas share websocket events over the bus; without one the hub
+	// only serves its own connections
+	eventBus, err := bus.Open(cfg.BusURL, cfg.BusChannel)
+	if err != nil {
+		logger.Fatalf("Failed to configure event bus: %v", err)
+	}
+	defer eventBus.Close()
+	hub.SetBus(eventBus)
+	if cfg.BusURL != "" {
+		logger.Printf("Sharing websocket events on %s", cfg.BusChannel)
+	}
+
 	go hub.Run()
 	logger.Println("WebSocket hub initialized")
 
+	// Tell connected clients to disable composers while writes are failing
+	database.OnModeChange(func(readOnly bool) {
+		hub.BroadcastMessage(models.WebSocketMessage{
+			Type:    "server_status",
+			Payload: map[string]interface{}{"read_only": readOnly},
+		})
+	})
+
+	// Outbound email is queued in the database and drained in the background
+	renderer, err := mail.NewRenderer()
+	if err != nil {
+		logger.Fatalf("Failed to load email templates: %v", err)
+	}
+	var sender mail.Sender = mail.NopSender{Logger: logger}
+	if cfg.MailSMTPAddr != "" {
+		smtpSender, err := mail.NewSMTPSender(cfg.MailSMTPAddr, cfg.MailSMTPUsername, cfg.MailSMTPPassword, cfg.MailFrom)
+		if err != nil {
+			logger.Fatalf("Failed to configure mail: %v", err)
+		}
+		sender = smtpSender
+		logger.Printf("Sending mail through %s", cfg.MailSMTPAddr)
+	} else {
+		logger.Println("MAIL_SMTP_ADDR is unset, outgoing mail will be dropped")
+	}
+	mailQueue := mail.NewQueue(database, sender, renderer, mail.Options{
+		MaxAttempts: cfg.MailMaxAttempts,
+		Interval:    cfg.MailDrainInterval,
+		RatePerHour: cfg.MailRatePerHour,
+		Burst:       cfg.MailBurst,
+	})
+	mailQueue.Start()
+	defer mailQueue.Close()
+
+	janitor := retention.NewJanitor(database, retention.Options{
+		Interval:    cfg.RetentionSweepInterval,
+		BatchSize:   cfg.RetentionBatchSize,
+		BatchPause:  cfg.RetentionBatchPause,
+		DefaultDays: cfg.MessageRetentionDays,
+		DryRun:      cfg.RetentionDryRun,
+		ArchiveDays: cfg.MessageArchiveAfterDays,
+	})
+	janitor.Start()
+	defer janitor.Close()
+
+	if cfg.DBStatsLogInterval > 0 {
+		go logDBStats(logger, database, cfg.DBStatsLogInterval)
+	}
+
 	// Initialize API handlers
-	handlers := api.NewHandlers(database, hub)
+	handlers := api.NewHandlers(database, hub, cfg)
+	hub.SetTokenValidator(handlers.ValidateSessionToken)
+	handlers.SetStorage(store)
+	handlers.SetChaos(injector)
+	handlers.SetErrSink(errs)
+	handlers.SetMailer(mailQueue)
 	logger.Println("API handlers initialized")
 
 	// Set up HTTP routes
@@ -79,14 +219,69 @@ func main() {
 	mux.HandleFunc("/api/auth/login", logRequest(logger, handlers.HandleLogin))
 	mux.HandleFunc("/api/auth/verify", logRequest(logger, handlers.HandleVerify))
 	mux.HandleFunc("/api/auth/logout", logRequest(logger, handlers.HandleLogout))
+	mux.HandleFunc("/api/auth/verify-email", logRequest(logger, handlers.HandleVerifyEmail))
 
 	// Conversation endpoints
 	mux.HandleFunc("/api/conversations", logRequest(logger, handlers.HandleConversations))
 	mux.HandleFunc("/api/conversations/create", logRequest(logger, handlers.HandleCreateConversation))
+	mux.HandleFunc("/api/conversations/validate", logRequest(logger, handlers.HandleValidateConversation))
+	mux.HandleFunc("/api/conversations/participants", logRequest(logger, handlers.HandleConversationParticipants))
+	mux.HandleFunc("/api/conversations/participants/role", logRequest(logger, handlers.HandleParticipantRole))
+	mux.HandleFunc("/api/conversations/transfer-ownership", logRequest(logger, handlers.HandleTransferOwnership))
+	mux.HandleFunc("/api/conversations/participants/me", logRequest(logger, handlers.HandleCustomName))
+	mux.HandleFunc("/api/conversations/settings", logRequest(logger, handlers.HandleConversationSettings))
+	mux.HandleFunc("/api/conversations/mute", logRequest(logger, handlers.HandleConversationMute))
+	mux.HandleFunc("/api/conversations/typing", logRequest(logger, handlers.HandleTyping))
+	mux.HandleFunc("/api/conversations/pin", logRequest(logger, handlers.HandlePinConversation))
+	mux.HandleFunc("/api/conversations/unpin", logRequest(logger, handlers.HandleUnpinConversation))
 	mux.HandleFunc("/api/conversations/messages", logRequest(logger, handlers.HandleMessages))
+	mux.HandleFunc("/api/conversations/messages/", logRequest(logger, handlers.HandleMessageRoutes))
+	mux.HandleFunc("/api/conversations/messages/receipts", logRequest(logger, handlers.HandleMessageReceipts))
+	mux.HandleFunc("/api/conversations/read", logRequest(logger, handlers.HandleMarkRead))
+	mux.HandleFunc("/api/conversations/export", logRequest(logger, handlers.HandleExportConversation))
+	mux.HandleFunc("/api/conversations/mirror", logRequest(logger, handlers.HandleConversationMirror))
 
 	// User endpoints
 	mux.HandleFunc("/api/users", logRequest(logger, handlers.HandleUsers))
+	mux.HandleFunc("/api/users/online", logRequest(logger, handlers.HandleOnlineUsers))
+	mux.HandleFunc("/api/users/privacy", logRequest(logger, handlers.HandleUserPrivacy))
+	mux.HandleFunc("/api/users/me/devices", logRequest(logger, handlers.HandleDevices))
+	mux.HandleFunc("/api/users/me/email", logRequest(logger, handlers.HandleEmail))
+	mux.HandleFunc("/api/notifications", logRequest(logger, handlers.HandleNotifications))
+	mux.HandleFunc("/api/notifications/read", logRequest(logger, handlers.HandleMarkNotificationsRead))
+	mux.HandleFunc("/api/bots/commands", logRequest(logger, handlers.HandleBotCommands))
+
+	// Long-polling fallback for clients that can't use the websocket
+	mux.HandleFunc("/api/events/poll", logRequest(logger, handlers.HandleEventsPoll))
+	// Server-Sent Events stream, long-lived like /ws so not logged
+	mux.HandleFunc("/api/events/stream", handlers.HandleEventsStream)
+
+	// Health endpoints
+	mux.HandleFunc("/readyz", handlers.HandleReadyz)
+	mux.HandleFunc("/healthz", handlers.HandleHealthz)
+
+	// Server metadata endpoints
+	mux.HandleFunc("/api/version", handlers.HandleVersion)
+	mux.HandleFunc("/api/capabilities", logRequest(logger, handlers.HandleCapabilities))
+
+	// Admin endpoints
+	mux.HandleFunc("/api/admin/stats", logRequest(logger, handlers.HandleAdminStats))
+	mux.HandleFunc("/api/admin/db-stats", logRequest(logger, handlers.HandleAdminDBStats))
+	mux.HandleFunc("/api/admin/integrity", logRequest(logger, handlers.HandleAdminIntegrity))
+	mux.HandleFunc("/api/admin/ws-stats", logRequest(logger, handlers.HandleAdminWSStats))
+	mux.HandleFunc("/api/admin/connections", logRequest(logger, handlers.HandleAdminConnections))
+	mux.HandleFunc("/api/admin/broadcast", logRequest(logger, handlers.HandleAdminBroadcast))
+	mux.HandleFunc("/api/admin/reports", logRequest(logger, handlers.HandleAdminReports))
+	mux.HandleFunc("/api/admin/reports/", logRequest(logger, handlers.HandleAdminReportRoutes))
+	mux.HandleFunc("/api/admin/bots", logRequest(logger, handlers.HandleAdminBots))
+	mux.HandleFunc("/api/admin/unread/repair", logRequest(logger, handlers.HandleAdminRepairUnread))
+	mux.HandleFunc("/api/admin/conversations/last-message", logRequest(logger, handlers.HandleAdminLastMessages))
+	if cfg.ChaosEnabled {
+		mux.HandleFunc("/api/debug/chaos", logRequest(logger, handlers.HandleChaos))
+	}
+	if cfg.DevStrict {
+		mux.HandleFunc("/api/debug/errors", logRequest(logger, handlers.HandleDebugErrors))
+	}
 
 	// Create a wrapped handler that skips CORS for WebSocket
 	wrappedHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -102,6 +297,22 @@ func main() {
 		Addr:    cfg.ServerAddress,
 		Handler: wrappedHandler,
 	}
+	// Event streams and long polls never go idle on their own
+	server.RegisterOnShutdown(hub.ReleaseListeners)
+
+	// Warm caches and the connection pool before taking traffic: either
+	// before the listener opens, or behind a 503 from /readyz
+	if cfg.Warmup {
+		if cfg.WarmupHoldReadiness {
+			handlers.SetWarmingUp(true)
+			go func() {
+				runWarmup(logger, database, hub, cfg)
+				handlers.SetWarmingUp(false)
+			}()
+		} else {
+			runWarmup(logger, database, hub, cfg)
+		}
+	}
 
 	// Start server in a goroutine
 	go func() {
@@ -118,12 +329,59 @@ func main() {
 	logger.Printf("Received signal: %v", sig)
 
 	logger.Println("Server shutting down...")
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Printf("HTTP server shutdown: %v", err)
+	}
+	// Websockets are hijacked, so server.Shutdown leaves them to the hub
+	if err := hub.Shutdown(ctx); err != nil {
+		logger.Printf("WebSocket hub shutdown: %v", err)
+	}
+	logger.Println("Server stopped")
+}
+
+// runWarmup primes the database and the hub's participant cache and logs
+// how long it took. A failed warmup only costs latency, so it is logged
+// rather than fatal.
+func runWarmup(logger *log.Logger, database *db.DB, hub *websocket.Hub, cfg *config.Config) {
+	start := time.Now()
+	report, err := database.Warmup(context.Background(), db.WarmupOptions{
+		Conversations: cfg.WarmupConversations,
+		Connections:   cfg.WarmupConnections,
+	})
+	if err != nil {
+		logger.Printf("Warmup failed after %v: %v", time.Since(start), err)
+		return
+	}
+	logger.Printf("Warmup done in %v: %d statements, %d connections, %d conversations, %d users cached",
+		report.Duration, report.Statements, report.Connections, report.Conversations, report.Users)
+	if _, err := hub.PrimeParticipants(context.Background(), report.ConversationIDs); err != nil {
+		logger.Printf("Priming participant cache failed: %v", err)
+	}
+}
+
+// logDBStats logs the database's row counts and sizes every interval, for
+// tracking growth between capacity reviews
+func logDBStats(logger *log.Logger, database *db.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		stats, err := database.Stats(context.Background())
+		if err != nil {
+			logger.Printf("Failed to get database stats: %v", err)
+			continue
+		}
+		logger.Printf("Database stats: users=%d conversations=%d participants=%d messages=%d file_bytes=%d wal_bytes=%d open_conns=%d in_use=%d wait_count=%d",
+			stats.Tables["users"], stats.Tables["conversations"], stats.Tables["participants"], stats.Tables["messages"],
+			stats.FileBytes, stats.WALBytes, stats.Pool.OpenConnections, stats.Pool.InUse, stats.Pool.WaitCount)
+	}
 }
 
 func logRequest(logger *log.Logger, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		logger.Printf("Started %s %s", r.Method, r.URL.Path)
+		logger.Printf("Started %s %s", logsafe.String(r.Method), logsafe.String(r.URL.Path))
 		
 		// Create a custom response writer to capture the status code
 		lrw := newLoggingResponseWriter(w)
@@ -131,7 +389,7 @@ func logRequest(logger *log.Logger, next http.HandlerFunc) http.HandlerFunc {
 		next.ServeHTTP(lrw, r)
 		
 		logger.Printf("Completed %s %s %d %s in %v",
-			r.Method, r.URL.Path, lrw.statusCode,
+			logsafe.String(r.Method), logsafe.String(r.URL.Path), lrw.statusCode,
 			http.StatusText(lrw.statusCode),
 			time.Since(start))
 	}
@@ -149,4 +407,11 @@ func newLoggingResponseWriter(w http.ResponseWriter) *loggingResponseWriter {
 func (lrw *loggingResponseWriter) WriteHeader(code int) {
 	lrw.statusCode = code
 	lrw.ResponseWriter.WriteHeader(code)
+}
+
+// Flush lets streaming handlers push partial responses through the wrapper
+func (lrw *loggingResponseWriter) Flush() {
+	if flusher, ok := lrw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
 } 
\ No newline at end of file
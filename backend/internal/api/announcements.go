@@ -0,0 +1,75 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"messager/internal/httpx"
+	"messager/internal/models"
+)
+
+// HandleAdminBroadcast sends an announcement to every connected client as
+// a "system" event, and with notify_offline stores it as a notification
+// for everyone else (admins only)
+func (h *Handlers) HandleAdminBroadcast(w http.ResponseWriter, r *http.Request) {
+	if !httpx.AllowMethods(w, r, http.MethodPost) {
+		return
+	}
+	admin, ok := h.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.AnnouncementRequest
+	if err := httpx.DecodeJSON(r, &req); err != nil {
+		httpx.WriteDecodeError(w, err)
+		return
+	}
+	if req.Severity == "" {
+		req.Severity = models.SeverityInfo
+	}
+
+	payload := map[string]interface{}{
+		"announcement": true,
+		"message":      req.Message,
+		"severity":     req.Severity,
+		"sent_at":      time.Now().UTC(),
+	}
+	if req.ExpiresAt != nil {
+		payload["expires_at"] = req.ExpiresAt.UTC()
+	}
+	if err := h.hub.BroadcastMessage(models.WebSocketMessage{Type: "system", Payload: payload}); err != nil {
+		log.Printf("Failed to broadcast announcement: %v", err)
+		http.Error(w, "Failed to broadcast announcement, try again shortly", http.StatusServiceUnavailable)
+		return
+	}
+	log.Printf("Admin %d broadcast a %s announcement", admin.ID, req.Severity)
+
+	response := map[string]interface{}{
+		"connected_clients": h.hub.ClientCount(),
+	}
+	if req.NotifyOffline {
+		data := map[string]interface{}{"severity": req.Severity}
+		if req.ExpiresAt != nil {
+			data["expires_at"] = req.ExpiresAt.UTC()
+		}
+		notified, err := h.hub.NotifyOffline(models.Notification{
+			Category: models.NotificationAnnouncement,
+			Title:    "Announcement",
+			Body:     req.Message,
+			Data:     data,
+		})
+		if err != nil {
+			if h.writeReadOnlyError(w, err) {
+				return
+			}
+			log.Printf("Failed to store announcement notifications: %v", err)
+			http.Error(w, "Announcement sent but not stored for offline users", http.StatusInternalServerError)
+			return
+		}
+		response["notified_offline"] = notified
+	}
+
+	httpx.WriteJSON(w, http.StatusOK, response)
+}
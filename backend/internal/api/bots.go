@@ -0,0 +1,196 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"messager/internal/db"
+	"messager/internal/httpx"
+	"messager/internal/models"
+	"messager/internal/websocket"
+)
+
+const (
+	// botAuthScheme prefixes a bot's API key in the Authorization header
+	botAuthScheme  = "Bot "
+	botKeyPrefix   = "bot_"
+	maxBotCommands = 20
+	maxCommandDesc = 200
+)
+
+// botAPIKey returns the API key a request authenticates with, if any
+func botAPIKey(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, botAuthScheme) {
+		return "", false
+	}
+	return strings.TrimSpace(strings.TrimPrefix(header, botAuthScheme)), true
+}
+
+// hashAPIKey is how keys are stored; the key itself is only ever shown once
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func newAPIKey() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return botKeyPrefix + hex.EncodeToString(buf), nil
+}
+
+// authenticateBot resolves a bot API key to its user
+func (h *Handlers) authenticateBot(ctx context.Context, key string) (*models.User, error) {
+	return h.db.GetBotByAPIKeyHash(ctx, hashAPIKey(key))
+}
+
+// HandleAdminBots lists (GET) or creates (POST) bot accounts. The API key
+// of a new bot is returned once and can't be recovered.
+func (h *Handlers) HandleAdminBots(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireAdmin(w, r); !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		bots, err := h.db.ListBots(r.Context())
+		if err != nil {
+			log.Printf("Failed to list bots: %v", err)
+			http.Error(w, "Failed to list bots", http.StatusInternalServerError)
+			return
+		}
+		if bots == nil {
+			bots = []*models.Bot{}
+		}
+		httpx.WriteJSON(w, http.StatusOK, bots)
+	case http.MethodPost:
+		h.createBot(w, r)
+	default:
+		httpx.AllowMethods(w, r, http.MethodGet, http.MethodPost)
+	}
+}
+
+func (h *Handlers) createBot(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateBotRequest
+	if err := httpx.DecodeJSON(r, &req); err != nil {
+		httpx.WriteDecodeError(w, err)
+		return
+	}
+	req.Username = strings.TrimSpace(req.Username)
+
+	key, err := newAPIKey()
+	if err != nil {
+		http.Error(w, "Failed to generate API key", http.StatusInternalServerError)
+		return
+	}
+
+	bot, err := h.db.CreateBot(r.Context(), req.Username, req.Avatar, req.WebhookURL, hashAPIKey(key))
+	if err != nil {
+		if h.writeReadOnlyError(w, err) {
+			return
+		}
+		if errors.Is(err, db.ErrDuplicateUsername) {
+			http.Error(w, "Username already exists", http.StatusConflict)
+			return
+		}
+		log.Printf("Failed to create bot: %v", err)
+		http.Error(w, "Failed to create bot", http.StatusInternalServerError)
+		return
+	}
+
+	httpx.WriteJSON(w, http.StatusCreated, models.CreateBotResponse{Bot: bot, APIKey: key})
+}
+
+// HandleBotCommands lists every registered command (GET, any user, for
+// autocomplete) or replaces the calling bot's commands (PUT, bots only).
+// Command names are global: claiming one another bot holds is a 409 naming
+// the holder, and nothing is changed.
+func (h *Handlers) HandleBotCommands(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(userContextKey).(*models.User)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		if !user.IsBot {
+			http.Error(w, "Only bots can register commands", http.StatusForbidden)
+			return
+		}
+		if !h.setBotCommands(w, r, user) {
+			return
+		}
+	default:
+		httpx.AllowMethods(w, r, http.MethodGet, http.MethodPut)
+		return
+	}
+
+	commands, err := h.db.ListBotCommands(r.Context())
+	if err != nil {
+		log.Printf("Failed to list bot commands: %v", err)
+		http.Error(w, "Failed to list commands", http.StatusInternalServerError)
+		return
+	}
+	httpx.WriteJSON(w, http.StatusOK, commands)
+}
+
+// setBotCommands validates and stores the request, writing the error
+// response itself when it returns false
+func (h *Handlers) setBotCommands(w http.ResponseWriter, r *http.Request, bot *models.User) bool {
+	var req models.SetBotCommandsRequest
+	if err := httpx.DecodeJSON(r, &req); err != nil {
+		httpx.WriteDecodeError(w, err)
+		return false
+	}
+	if len(req.Commands) > maxBotCommands {
+		http.Error(w, fmt.Sprintf("A bot can register at most %d commands", maxBotCommands), http.StatusBadRequest)
+		return false
+	}
+	seen := make(map[string]bool)
+	for i, command := range req.Commands {
+		name := strings.ToLower(strings.TrimPrefix(command.Command, "/"))
+		if !websocket.CommandPattern.MatchString(name) {
+			http.Error(w, fmt.Sprintf("Invalid command %q: use 1-32 lowercase letters, digits or underscores", command.Command), http.StatusBadRequest)
+			return false
+		}
+		if seen[name] {
+			http.Error(w, fmt.Sprintf("Duplicate command %q", name), http.StatusBadRequest)
+			return false
+		}
+		if len(command.Description) > maxCommandDesc {
+			http.Error(w, fmt.Sprintf("Description of %q is too long", name), http.StatusBadRequest)
+			return false
+		}
+		seen[name] = true
+		req.Commands[i].Command = name
+	}
+
+	conflicts, err := h.db.SetBotCommands(r.Context(), bot.ID, req.Commands)
+	if err != nil {
+		if h.writeReadOnlyError(w, err) {
+			return false
+		}
+		log.Printf("Failed to set commands for bot %d: %v", bot.ID, err)
+		http.Error(w, "Failed to register commands", http.StatusInternalServerError)
+		return false
+	}
+	if len(conflicts) > 0 {
+		httpx.WriteJSON(w, http.StatusConflict, map[string]interface{}{
+			"error":     "command_conflict",
+			"conflicts": conflicts,
+		})
+		return false
+	}
+	return true
+}
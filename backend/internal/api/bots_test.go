@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"messager/internal/models"
+)
+
+// createBot creates a bot through the admin endpoint, returning it and its
+// API key
+func createBot(t *testing.T, env *testEnv, admin *models.User, username string) (*models.User, string) {
+	t.Helper()
+	var resp models.CreateBotResponse
+	decode(t, call(t, env.h.HandleAdminBots, admin, http.MethodPost, "/api/admin/bots",
+		models.CreateBotRequest{Username: username}), http.StatusCreated, &resp)
+	if !strings.HasPrefix(resp.APIKey, botKeyPrefix) {
+		t.Fatalf("api key = %q", resp.APIKey)
+	}
+	bot, err := env.h.authenticateBot(context.Background(), resp.APIKey)
+	if err != nil || bot.ID != resp.Bot.ID || !bot.IsBot {
+		t.Fatalf("the new key authenticates as %+v, %v", bot, err)
+	}
+	return bot, resp.APIKey
+}
+
+func TestCreateBotAuthenticatesWithItsKey(t *testing.T) {
+	env := newTestEnv(t, asAdmin("carol"))
+	if rec := call(t, env.h.HandleAdminBots, env.f.Alice, http.MethodPost, "/api/admin/bots",
+		models.CreateBotRequest{Username: "sneaky"}); rec.Code != http.StatusForbidden {
+		t.Errorf("non-admin creating a bot: status %d, want 403", rec.Code)
+	}
+	bot, key := createBot(t, env, env.f.Carol, "remindbot")
+	if rec := call(t, env.h.HandleAdminBots, env.f.Carol, http.MethodPost, "/api/admin/bots",
+		models.CreateBotRequest{Username: "remindbot"}); rec.Code != http.StatusConflict {
+		t.Errorf("duplicate username: status %d, want 409", rec.Code)
+	}
+
+	// The middleware accepts the key in place of a session
+	var seen *models.User
+	protected := env.h.WithAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = r.Context().Value(userContextKey).(*models.User)
+	}))
+	for key, want := range map[string]int{key: http.StatusOK, key + "x": http.StatusUnauthorized} {
+		req := httptest.NewRequest(http.MethodGet, "/api/conversations", nil)
+		req.Header.Set("Authorization", botAuthScheme+key)
+		rec := httptest.NewRecorder()
+		protected.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("key %q: status %d, want %d", key, rec.Code, want)
+		}
+	}
+	if seen == nil || seen.ID != bot.ID {
+		t.Errorf("request ran as %+v, want the bot", seen)
+	}
+}
+
+func TestBotCommandRegistration(t *testing.T) {
+	env := newTestEnv(t, asAdmin("carol"))
+	remind, _ := createBot(t, env, env.f.Carol, "remindbot")
+	other, _ := createBot(t, env, env.f.Carol, "otherbot")
+	register := func(bot *models.User, commands ...string) *httptest.ResponseRecorder {
+		var req models.SetBotCommandsRequest
+		for _, command := range commands {
+			req.Commands = append(req.Commands, models.BotCommand{Command: command, Description: "does " + command})
+		}
+		return call(t, env.h.HandleBotCommands, bot, http.MethodPut, "/api/bots/commands", req)
+	}
+
+	var registered []models.RegisteredCommand
+	decode(t, register(remind, "/Remind", "snooze"), http.StatusOK, &registered)
+	if len(registered) != 2 || registered[0].Command != "remind" || registered[0].BotID != remind.ID {
+		t.Fatalf("registered = %+v", registered)
+	}
+
+	// Claiming a held command names the holder and changes nothing
+	var conflict struct {
+		Error     string                     `json:"error"`
+		Conflicts []models.RegisteredCommand `json:"conflicts"`
+	}
+	decode(t, register(other, "poll", "remind"), http.StatusConflict, &conflict)
+	if len(conflict.Conflicts) != 1 || conflict.Conflicts[0].BotUsername != "remindbot" {
+		t.Errorf("conflicts = %+v, want remind held by remindbot", conflict.Conflicts)
+	}
+	decode(t, call(t, env.h.HandleBotCommands, env.f.Alice, http.MethodGet, "/api/bots/commands", nil), http.StatusOK, &registered)
+	if len(registered) != 2 {
+		t.Errorf("after the conflict %d commands are registered, want the 2 from before", len(registered))
+	}
+
+	// Re-registering replaces the bot's set, freeing what it dropped
+	decode(t, register(remind, "remind"), http.StatusOK, nil)
+	decode(t, register(other, "snooze"), http.StatusOK, nil)
+
+	for _, tc := range []struct {
+		name     string
+		bot      *models.User
+		commands []string
+		want     int
+	}{
+		{"a user registering", env.f.Alice, []string{"mine"}, http.StatusForbidden},
+		{"invalid name", remind, []string{"no spaces"}, http.StatusBadRequest},
+		{"duplicate in one request", remind, []string{"remind", "/REMIND"}, http.StatusBadRequest},
+	} {
+		if rec := register(tc.bot, tc.commands...); rec.Code != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, rec.Code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"messager/internal/chaos"
+	"messager/internal/httpx"
+	"messager/internal/logsafe"
+)
+
+// SetChaos attaches the fault injector controlled by HandleChaos; nil when
+// fault injection is disabled
+func (h *Handlers) SetChaos(injector *chaos.Injector) {
+	h.chaos = injector
+}
+
+// HandleChaos shows (GET), replaces (PUT) or clears (DELETE) the active
+// fault profile. Admin only, and only routed when CHAOS_ENABLED is set.
+func (h *Handlers) HandleChaos(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	if h.chaos == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var profile chaos.Profile
+		if err := httpx.DecodeJSON(r, &profile); err != nil {
+			httpx.WriteDecodeError(w, err)
+			return
+		}
+		if err := h.chaos.SetProfile(profile); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Fault profile replaced by %s", logsafe.String(user.Username))
+	case http.MethodDelete:
+		h.chaos.SetProfile(chaos.Profile{})
+		log.Printf("Fault profile cleared by %s", logsafe.String(user.Username))
+	default:
+		httpx.AllowMethods(w, r, http.MethodGet, http.MethodPut, http.MethodDelete)
+		return
+	}
+
+	httpx.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"profile": h.chaos.Profile(),
+		"stats":   h.chaos.Stats(),
+	})
+}
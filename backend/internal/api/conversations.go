@@ -0,0 +1,507 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"messager/internal/db"
+	"messager/internal/httpx"
+	"messager/internal/models"
+)
+
+const (
+	maxConversationNameLength  = 100
+	maxConversationTopicLength = 500
+)
+
+// validateCreateConversation runs every check a new conversation must pass
+// and normalizes req in place: the name is trimmed and participants are
+// deduplicated, with the creator dropped from a direct request and added to
+// a group. Create and validate both go through here so a draft that
+// validates is one that creates. An empty result means req is valid.
+func (h *Handlers) validateCreateConversation(ctx context.Context, user *models.User, req *models.CreateConversationRequest) ([]models.FieldError, error) {
+	var errs []models.FieldError
+
+	seen := map[int64]bool{user.ID: true}
+	var others []int64
+	var includesSelf bool
+	for _, id := range req.Participants {
+		if id == user.ID {
+			includesSelf = true
+		} else if !seen[id] {
+			seen[id] = true
+			others = append(others, id)
+		}
+	}
+	req.Name = strings.TrimSpace(req.Name)
+
+	switch req.Type {
+	case "direct":
+		if len(others) == 0 && includesSelf {
+			errs = append(errs, models.FieldError{
+				Field:   "participants",
+				Code:    "self_conversation",
+				Message: "You can't start a direct conversation with yourself",
+			})
+		} else if len(others) != 1 {
+			errs = append(errs, models.FieldError{
+				Field:   "participants",
+				Code:    "direct_needs_one_participant",
+				Message: "Direct conversations need exactly one other participant",
+			})
+		}
+		req.Participants = others
+	case "group":
+		if n := utf8.RuneCountInString(req.Name); n < 1 || n > maxConversationNameLength {
+			errs = append(errs, models.FieldError{
+				Field:   "name",
+				Code:    "invalid_name",
+				Message: fmt.Sprintf("Name must be between 1 and %d characters", maxConversationNameLength),
+			})
+		}
+		if len(others)+1 > h.cfg.MaxGroupParticipants {
+			errs = append(errs, models.FieldError{
+				Field:   "participants",
+				Code:    "too_many_participants",
+				Message: fmt.Sprintf("Groups can have at most %d participants", h.cfg.MaxGroupParticipants),
+			})
+		}
+		req.Participants = append(others, user.ID)
+	default:
+		errs = append(errs, models.FieldError{
+			Field:   "type",
+			Code:    "invalid_type",
+			Message: `Type must be "direct" or "group"`,
+		})
+	}
+
+	// Only look users up when the list is small enough to be accepted
+	if len(others) > 0 && len(others) < h.cfg.MaxGroupParticipants {
+		missing, err := h.db.MissingUserIDs(ctx, others)
+		if err != nil {
+			return nil, err
+		}
+		if len(missing) > 0 {
+			errs = append(errs, models.FieldError{
+				Field:   "participants",
+				Code:    "unknown_users",
+				Message: "Some participants don't exist",
+				UserIDs: missing,
+			})
+		}
+	}
+
+	return errs, nil
+}
+
+// HandleValidateConversation checks a would-be CreateConversation request
+// without creating anything, so a client can report problems as the user
+// fills in each step. Invalid drafts get the same 400 the create would.
+func (h *Handlers) HandleValidateConversation(w http.ResponseWriter, r *http.Request) {
+	if !httpx.AllowMethods(w, r, http.MethodPost) {
+		return
+	}
+	user, ok := r.Context().Value(userContextKey).(*models.User)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.CreateConversationRequest
+	if err := httpx.DecodeJSON(r, &req); err != nil {
+		httpx.WriteDecodeError(w, err)
+		return
+	}
+
+	errs, err := h.validateCreateConversation(r.Context(), user, &req)
+	if err != nil {
+		log.Printf("Failed to validate conversation: %v", err)
+		http.Error(w, "Failed to validate conversation", http.StatusInternalServerError)
+		return
+	}
+	if len(errs) > 0 {
+		httpx.WriteValidationErrors(w, errs)
+		return
+	}
+
+	httpx.WriteJSON(w, http.StatusOK, map[string]interface{}{"valid": true})
+}
+
+// getConversation returns one conversation as the caller sees it: the same
+// shape as an entry of the conversation list, which is also the payload of
+// "conversation_added", plus who is typing
+func (h *Handlers) getConversation(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(userContextKey).(*models.User)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	conversationID, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid conversation ID", http.StatusBadRequest)
+		return
+	}
+
+	conversation, err := h.db.GetConversationForViewer(r.Context(), conversationID, user.ID)
+	if errors.Is(err, db.ErrNotFound) {
+		// Tell a missing conversation apart from one the caller isn't in
+		if _, err := h.db.GetConversationByID(r.Context(), conversationID); errors.Is(err, db.ErrNotFound) {
+			http.Error(w, "Conversation not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to fetch conversation %d: %v", conversationID, err)
+		http.Error(w, "Failed to fetch conversation", http.StatusInternalServerError)
+		return
+	}
+	conversation.Typing = h.hub.TypingUsers(conversation.ID)
+
+	httpx.WriteJSON(w, http.StatusOK, conversation)
+}
+
+// announceCreated sends "conversation_created" to the participants of a new
+// conversation, each getting it as they'd see it from getConversation.
+// The creator's connections are included when NotifyCreator is set.
+// Offline participants pick it up on their next list fetch. Everyone the
+// creator put in a group also gets an "added_to_group" notification.
+func (h *Handlers) announceCreated(ctx context.Context, conversationID, creatorID int64) {
+	ctx = announceContext(ctx)
+	// Read back from the primary since it was just written
+	ctx = db.ReadYourWrites(ctx)
+	participants, err := h.db.GetConversationParticipantIDs(ctx, conversationID)
+	if err != nil {
+		log.Printf("Failed to get conversation participants: %v", err)
+		return
+	}
+	for _, id := range participants {
+		if id == creatorID && !h.cfg.NotifyCreator {
+			continue
+		}
+		view, err := h.db.GetConversationForViewer(ctx, conversationID, id)
+		if err != nil {
+			log.Printf("Failed to load conversation %d for user %d: %v", conversationID, id, err)
+			continue
+		}
+		h.hub.SendToUser(id, models.WebSocketMessage{
+			Type:    "conversation_created",
+			Payload: view,
+		})
+		if id != creatorID && view.Type == "group" {
+			h.notifyAddedToGroup(ctx, view, creatorID, id)
+		}
+	}
+}
+
+// notifyAddedToGroup tells userID that actorID put them in a group
+func (h *Handlers) notifyAddedToGroup(ctx context.Context, conversation *models.Conversation, actorID, userID int64) {
+	actor, err := h.db.Username(ctx, actorID)
+	if err != nil {
+		log.Printf("Failed to look up user %d: %v", actorID, err)
+		return
+	}
+	title := fmt.Sprintf("%s added you to a group", actor)
+	if conversation.Name != "" {
+		title = fmt.Sprintf("%s added you to %s", actor, conversation.Name)
+	}
+	_, err = h.hub.NotifyUser(userID, models.Notification{
+		Category: models.NotificationAddedToGroup,
+		Title:    title,
+		Data: map[string]interface{}{
+			"conversation_id": conversation.ID,
+			"added_by":        actorID,
+		},
+	})
+	if err != nil {
+		log.Printf("Failed to notify user %d of being added to conversation %d: %v", userID, conversation.ID, err)
+	}
+}
+
+// updateConversation renames a group, sets its topic and/or, for the owner,
+// its message retention. Only fields present in the request are touched, and
+// a request that changes nothing succeeds without emitting events.
+func (h *Handlers) updateConversation(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(userContextKey).(*models.User)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.UpdateConversationRequest
+	if err := httpx.DecodeJSON(r, &req); err != nil {
+		httpx.WriteDecodeError(w, err)
+		return
+	}
+
+	conversation, err := h.db.GetConversationByID(r.Context(), req.ConversationID)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch conversation", http.StatusInternalServerError)
+		return
+	}
+
+	isParticipant, err := h.db.IsConversationParticipant(r.Context(), conversation.ID, user.ID)
+	if err != nil {
+		http.Error(w, "Failed to check membership", http.StatusInternalServerError)
+		return
+	}
+	if !isParticipant {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	name, topic := conversation.Name, conversation.Topic
+	if req.Name != nil {
+		if conversation.Type == "direct" {
+			http.Error(w, "Direct conversations can't be renamed", http.StatusBadRequest)
+			return
+		}
+		name = strings.TrimSpace(*req.Name)
+		if n := utf8.RuneCountInString(name); n < 1 || n > maxConversationNameLength {
+			http.Error(w, fmt.Sprintf("Name must be between 1 and %d characters", maxConversationNameLength), http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Topic != nil {
+		topic = strings.TrimSpace(*req.Topic)
+		if utf8.RuneCountInString(topic) > maxConversationTopicLength {
+			http.Error(w, fmt.Sprintf("Topic must be at most %d characters", maxConversationTopicLength), http.StatusBadRequest)
+			return
+		}
+	}
+
+	retention := 0
+	if conversation.RetentionDays != nil {
+		retention = *conversation.RetentionDays
+	}
+	previousRetention := retention
+	if req.RetentionDays != nil {
+		if conversation.Type == "direct" {
+			http.Error(w, "Direct conversations have no retention setting", http.StatusBadRequest)
+			return
+		}
+		retention = *req.RetentionDays
+	}
+
+	renamed := name != conversation.Name
+	topicChanged := topic != conversation.Topic
+	retentionChanged := retention != previousRetention
+	if renamed {
+		if _, ok := h.authorize(r.Context(), w, conversation.ID, user, actionRename); !ok {
+			return
+		}
+	}
+	if retentionChanged {
+		if _, ok := h.authorize(r.Context(), w, conversation.ID, user, actionSetRetention); !ok {
+			return
+		}
+	}
+	if renamed || topicChanged {
+		if err := h.db.UpdateConversationDetails(r.Context(), conversation.ID, name, topic); err != nil {
+			if h.writeReadOnlyError(w, err) {
+				return
+			}
+			log.Printf("Failed to update conversation %d: %v", conversation.ID, err)
+			http.Error(w, "Failed to update conversation", http.StatusInternalServerError)
+			return
+		}
+		conversation.Name, conversation.Topic = name, topic
+	}
+	if retentionChanged {
+		if err := h.db.SetConversationRetention(r.Context(), conversation.ID, retention); err != nil {
+			if h.writeReadOnlyError(w, err) {
+				return
+			}
+			log.Printf("Failed to set retention for conversation %d: %v", conversation.ID, err)
+			http.Error(w, "Failed to update conversation", http.StatusInternalServerError)
+			return
+		}
+		conversation.RetentionDays = nil
+		if retention > 0 {
+			conversation.RetentionDays = &retention
+		}
+	}
+	if renamed || topicChanged || retentionChanged {
+		h.announceConversationUpdated(r.Context(), conversation, user, renamed, topicChanged, retentionChanged)
+	}
+
+	if err := h.db.ApplyDisplayName(r.Context(), conversation, user.ID); err != nil {
+		log.Printf("Failed to resolve conversation name: %v", err)
+	}
+	httpx.WriteJSON(w, http.StatusOK, conversation)
+}
+
+// deleteConversation deletes a conversation and its history for everyone.
+// A group can only be deleted by its owner; either side of a direct
+// conversation may delete it.
+func (h *Handlers) deleteConversation(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(userContextKey).(*models.User)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.DeleteConversationRequest
+	if err := httpx.DecodeJSON(r, &req); err != nil {
+		httpx.WriteDecodeError(w, err)
+		return
+	}
+
+	conversation, err := h.db.GetConversationByID(r.Context(), req.ConversationID)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch conversation", http.StatusInternalServerError)
+		return
+	}
+
+	if conversation.Type == "group" {
+		if _, ok := h.authorize(r.Context(), w, conversation.ID, user, actionDeleteConversation); !ok {
+			return
+		}
+	} else {
+		isParticipant, err := h.db.IsConversationParticipant(r.Context(), conversation.ID, user.ID)
+		if err != nil {
+			http.Error(w, "Failed to check membership", http.StatusInternalServerError)
+			return
+		}
+		if !isParticipant {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
+	participants, err := h.db.DeleteConversation(r.Context(), conversation.ID)
+	if errors.Is(err, db.ErrNotFound) {
+		// Someone else deleted it first
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		if h.writeReadOnlyError(w, err) {
+			return
+		}
+		log.Printf("Failed to delete conversation %d: %v", conversation.ID, err)
+		http.Error(w, "Failed to delete conversation", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %d deleted conversation %d", user.ID, conversation.ID)
+
+	h.hub.ForgetMembership(conversation.ID, participants...)
+	h.hub.SendToConversation(conversation.ID, models.WebSocketMessage{
+		Type: "conversation_deleted",
+		Payload: map[string]interface{}{
+			"conversation_id": conversation.ID,
+			"deleted_by":      user.ID,
+		},
+	}, participants)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handlers) announceConversationUpdated(ctx context.Context, conversation *models.Conversation, actor *models.User, renamed, topicChanged, retentionChanged bool) {
+	ctx = announceContext(ctx)
+	participants, err := h.db.GetConversationParticipantIDs(ctx, conversation.ID)
+	if err != nil {
+		log.Printf("Failed to get conversation participants: %v", err)
+		return
+	}
+
+	payload := map[string]interface{}{
+		"conversation_id": conversation.ID,
+		"topic":           conversation.Topic,
+		"retention_days":  conversation.RetentionDays,
+		"updated_by":      actor.ID,
+	}
+	// A direct conversation's name differs per viewer, so it's left out
+	if conversation.Type == "group" {
+		payload["name"] = conversation.Name
+	}
+	h.hub.SendToConversation(conversation.ID, models.WebSocketMessage{
+		Type:    "conversation_updated",
+		Payload: payload,
+	}, participants)
+
+	if renamed {
+		h.postSystemEvent(ctx, conversation.ID, models.SystemEvent{
+			Event:   "conversation_renamed",
+			ActorID: actor.ID,
+			Name:    conversation.Name,
+			Text:    fmt.Sprintf("%s renamed the group to %q", actor.Username, conversation.Name),
+		})
+	}
+	if topicChanged {
+		text := fmt.Sprintf("%s changed the topic to %q", actor.Username, conversation.Topic)
+		if conversation.Topic == "" {
+			text = fmt.Sprintf("%s cleared the topic", actor.Username)
+		}
+		h.postSystemEvent(ctx, conversation.ID, models.SystemEvent{
+			Event:   "topic_changed",
+			ActorID: actor.ID,
+			Text:    text,
+		})
+	}
+	if retentionChanged {
+		text := fmt.Sprintf("%s set messages to be kept forever", actor.Username)
+		if days := h.cfg.MessageRetentionDays; days > 0 {
+			text = fmt.Sprintf("%s set messages to be deleted after the default %d days", actor.Username, days)
+		}
+		if conversation.RetentionDays != nil {
+			text = fmt.Sprintf("%s set messages to be deleted after %d days", actor.Username, *conversation.RetentionDays)
+		}
+		h.postSystemEvent(ctx, conversation.ID, models.SystemEvent{
+			Event:   "retention_changed",
+			ActorID: actor.ID,
+			Text:    text,
+		})
+	}
+}
+
+// HandleAdminLastMessages checks every conversation's stored latest
+// message against its history: GET reports the ones that disagree, POST
+// also fixes them (admins only)
+func (h *Handlers) HandleAdminLastMessages(w http.ResponseWriter, r *http.Request) {
+	if !httpx.AllowMethods(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+	admin, ok := h.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	fix := r.Method == http.MethodPost
+	drift, err := h.db.CheckLastMessages(r.Context(), fix)
+	if err != nil {
+		if h.writeReadOnlyError(w, err) {
+			return
+		}
+		log.Printf("Failed to check latest messages: %v", err)
+		http.Error(w, "Failed to check latest messages", http.StatusInternalServerError)
+		return
+	}
+	if fix && len(drift) > 0 {
+		log.Printf("Admin %d fixed the latest message of %d conversations", admin.ID, len(drift))
+	}
+	if drift == nil {
+		drift = []db.LastMessageDrift{}
+	}
+
+	httpx.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"drift": drift,
+		"fixed": fix,
+	})
+}
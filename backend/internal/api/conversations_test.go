@@ -0,0 +1,154 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"messager/internal/db"
+	"messager/internal/models"
+)
+
+func strPtr(s string) *string { return &s }
+
+// systemEvents returns the events recorded in a conversation's history,
+// oldest first
+func systemEvents(t *testing.T, env *testEnv, conversationID int64) []models.SystemEvent {
+	t.Helper()
+	messages, err := env.db.GetConversationMessages(context.Background(), conversationID, 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []models.SystemEvent
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].IsSystem() {
+			var event models.SystemEvent
+			if err := json.Unmarshal([]byte(messages[i].Content), &event); err != nil {
+				t.Fatal(err)
+			}
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+func TestRenameGroupAndSetTopic(t *testing.T) {
+	env := newTestEnv(t, nil)
+	group := env.f.Group.ID
+
+	var updated models.Conversation
+	decode(t, call(t, env.h.HandleConversations, env.f.Alice, http.MethodPatch, "/api/conversations",
+		models.UpdateConversationRequest{ConversationID: group, Name: strPtr("  Core team "), Topic: strPtr("Q3 launch")}), http.StatusOK, &updated)
+	if updated.Name != "Core team" || updated.Topic != "Q3 launch" {
+		t.Fatalf("updated = %q / %q", updated.Name, updated.Topic)
+	}
+	stored, err := env.db.GetConversationByID(context.Background(), group)
+	if err != nil || stored.Name != "Core team" || stored.Topic != "Q3 launch" {
+		t.Fatalf("stored = %+v, %v", stored, err)
+	}
+	events := systemEvents(t, env, group)
+	if len(events) != 2 {
+		t.Fatalf("got %d system events, want a rename and a topic change", len(events))
+	}
+
+	// Sending the same values again changes nothing and records nothing
+	decode(t, call(t, env.h.HandleConversations, env.f.Alice, http.MethodPatch, "/api/conversations",
+		models.UpdateConversationRequest{ConversationID: group, Name: strPtr("Core team")}), http.StatusOK, nil)
+	if got := len(systemEvents(t, env, group)); got != 2 {
+		t.Errorf("a no-op update recorded %d more events", got-2)
+	}
+}
+
+func TestUpdateConversationRules(t *testing.T) {
+	env := newTestEnv(t, nil)
+	for _, tc := range []struct {
+		name string
+		user *models.User
+		req  models.UpdateConversationRequest
+		want int
+	}{
+		{"member renaming", env.f.Bob, models.UpdateConversationRequest{ConversationID: env.f.Group.ID, Name: strPtr("Mine now")}, http.StatusForbidden},
+		{"member setting the topic", env.f.Bob, models.UpdateConversationRequest{ConversationID: env.f.Group.ID, Topic: strPtr("anyone may")}, http.StatusOK},
+		{"blank name", env.f.Alice, models.UpdateConversationRequest{ConversationID: env.f.Group.ID, Name: strPtr("   ")}, http.StatusBadRequest},
+		{"renaming a direct conversation", env.f.Alice, models.UpdateConversationRequest{ConversationID: env.f.Direct.ID, Name: strPtr("Us")}, http.StatusBadRequest},
+		{"non-member", env.f.Carol, models.UpdateConversationRequest{ConversationID: env.f.Direct.ID, Topic: strPtr("hi")}, http.StatusForbidden},
+	} {
+		if rec := call(t, env.h.HandleConversations, tc.user, http.MethodPatch, "/api/conversations", tc.req); rec.Code != tc.want {
+			t.Errorf("%s: status %d, want %d: %s", tc.name, rec.Code, tc.want, rec.Body.String())
+		}
+	}
+}
+
+func TestGetSingleConversation(t *testing.T) {
+	env := newTestEnv(t, nil)
+	get := func(user *models.User, id int64) *httptest.ResponseRecorder {
+		return call(t, env.h.HandleConversations, user, http.MethodGet, fmt.Sprintf("/api/conversations?id=%d", id), nil)
+	}
+
+	var conv models.Conversation
+	decode(t, get(env.f.Bob, env.f.Direct.ID), http.StatusOK, &conv)
+	if conv.ID != env.f.Direct.ID || conv.Name != "alice" {
+		t.Errorf("bob's view of the direct conversation: %+v, want it named after alice", conv)
+	}
+	if conv.Membership == nil || conv.Membership.JoinedAt.IsZero() {
+		t.Errorf("membership = %+v, want bob's", conv.Membership)
+	}
+	if conv.TotalParticipants != 2 || conv.LastMessage == nil || conv.LastMessage.ID != env.f.Messages[1].ID {
+		t.Errorf("want the list entry shape, got %d participants and preview %+v", conv.TotalParticipants, conv.LastMessage)
+	}
+
+	if rec := get(env.f.Carol, env.f.Direct.ID); rec.Code != http.StatusForbidden {
+		t.Errorf("non-member: status %d, want 403", rec.Code)
+	}
+	if rec := get(env.f.Alice, 9999); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown conversation: status %d, want 404", rec.Code)
+	}
+}
+
+func TestConversationListPageTokens(t *testing.T) {
+	env := newTestEnv(t, nil)
+
+	for _, limit := range []string{"0", "201", "ten"} {
+		if rec := call(t, env.h.HandleConversations, env.f.Alice, http.MethodGet, "/api/conversations?limit="+limit, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("limit %s: status %d, want 400", limit, rec.Code)
+		}
+	}
+
+	// The last page has no token
+	rec := call(t, env.h.HandleConversations, env.f.Alice, http.MethodGet, "/api/conversations?limit=1", nil)
+	var page []*models.Conversation
+	decode(t, rec, http.StatusOK, &page)
+	token := rec.Header().Get(nextPageTokenHeader)
+	if len(page) != 1 || page[0].ID != env.f.Group.ID || token == "" {
+		t.Fatalf("first page: %d conversations, token %q", len(page), token)
+	}
+	rec = call(t, env.h.HandleConversations, env.f.Alice, http.MethodGet, "/api/conversations?limit=5&page_token="+url.QueryEscape(token), nil)
+	decode(t, rec, http.StatusOK, &page)
+	if len(page) != 1 || page[0].ID != env.f.Direct.ID || rec.Header().Get(nextPageTokenHeader) != "" {
+		t.Errorf("second page: %d conversations, token %q", len(page), rec.Header().Get(nextPageTokenHeader))
+	}
+
+	// A token is only good for the user it was issued to, and only on this
+	// listing
+	if rec := call(t, env.h.HandleConversations, env.f.Bob, http.MethodGet, "/api/conversations?page_token="+url.QueryEscape(token), nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("someone else's token: status %d, want 400", rec.Code)
+	}
+	messagesToken := env.h.cursors.Encode(messagesCursorKind, env.f.Alice.ID, db.ConversationPosition(page[0]))
+	if rec := call(t, env.h.HandleConversations, env.f.Alice, http.MethodGet, "/api/conversations?page_token="+url.QueryEscape(messagesToken), nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("a message history token: status %d, want 400", rec.Code)
+	}
+
+	// Nobody's conversations come back as an empty list, not null
+	dave, err := env.db.CreateUser(context.Background(), "dave", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec = call(t, env.h.HandleConversations, dave, http.MethodGet, "/api/conversations", nil)
+	if body := rec.Body.String(); rec.Code != http.StatusOK || body != "[]\n" {
+		t.Errorf("no conversations: status %d, body %q", rec.Code, body)
+	}
+}
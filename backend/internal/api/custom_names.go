@@ -0,0 +1,77 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"messager/internal/db"
+	"messager/internal/httpx"
+	"messager/internal/models"
+)
+
+// HandleCustomName sets (PATCH) the name the caller sees for a conversation
+// in place of its shared name. It's private: only the caller's own devices
+// hear about the change. Clearing it reverts to the shared name, or for a
+// direct conversation the other participant's name.
+func (h *Handlers) HandleCustomName(w http.ResponseWriter, r *http.Request) {
+	if !httpx.AllowMethods(w, r, http.MethodPatch) {
+		return
+	}
+	user, ok := r.Context().Value(userContextKey).(*models.User)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.CustomNameRequest
+	if err := httpx.DecodeJSON(r, &req); err != nil {
+		httpx.WriteDecodeError(w, err)
+		return
+	}
+
+	var name string
+	if req.CustomName != nil {
+		name = strings.TrimSpace(*req.CustomName)
+	}
+	if utf8.RuneCountInString(name) > maxConversationNameLength {
+		http.Error(w, fmt.Sprintf("Name must be at most %d characters", maxConversationNameLength), http.StatusBadRequest)
+		return
+	}
+
+	err := h.db.SetCustomName(r.Context(), req.ConversationID, user.ID, name)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		if h.writeReadOnlyError(w, err) {
+			return
+		}
+		log.Printf("Failed to set custom name for conversation %d: %v", req.ConversationID, err)
+		http.Error(w, "Failed to set custom name", http.StatusInternalServerError)
+		return
+	}
+
+	conversation, err := h.db.GetConversationForViewer(r.Context(), req.ConversationID, user.ID)
+	if err != nil {
+		log.Printf("Failed to load conversation %d: %v", req.ConversationID, err)
+		http.Error(w, "Failed to fetch conversation", http.StatusInternalServerError)
+		return
+	}
+
+	// Keep the user's other devices in sync
+	h.hub.SendToUser(user.ID, models.WebSocketMessage{
+		Type: "conversation_custom_name_updated",
+		Payload: map[string]interface{}{
+			"conversation_id": conversation.ID,
+			"custom_name":     conversation.Membership.CustomName,
+			"name":            conversation.Name,
+		},
+	})
+
+	httpx.WriteJSON(w, http.StatusOK, conversation)
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"messager/internal/models"
+)
+
+func setCustomName(t *testing.T, env *testEnv, user *models.User, conversationID int64, name *string) *httptest.ResponseRecorder {
+	t.Helper()
+	return call(t, env.h.HandleCustomName, user, http.MethodPatch, "/api/conversations/participants/me",
+		models.CustomNameRequest{ConversationID: conversationID, CustomName: name})
+}
+
+func TestCustomNameIsPrivate(t *testing.T) {
+	env := newTestEnv(t, nil)
+	ctx := context.Background()
+
+	var conv models.Conversation
+	decode(t, setCustomName(t, env, env.f.Alice, env.f.Group.ID, strPtr("  Work  ")), http.StatusOK, &conv)
+	if conv.Name != "Work" || conv.Membership.CustomName != "Work" {
+		t.Fatalf("after renaming: name %q, custom name %q, want Work", conv.Name, conv.Membership.CustomName)
+	}
+
+	// Alice sees her name everywhere; bob still sees the shared one
+	list, err := env.db.GetUserConversations(ctx, env.f.Alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range list {
+		if c.ID == env.f.Group.ID && c.Name != "Work" {
+			t.Errorf("alice's list names the group %q, want Work", c.Name)
+		}
+	}
+	bobs, err := env.db.GetConversationForViewer(ctx, env.f.Group.ID, env.f.Bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bobs.Name != "Team" || bobs.Membership.CustomName != "" {
+		t.Errorf("bob sees %q (custom %q), want the shared name", bobs.Name, bobs.Membership.CustomName)
+	}
+	if name, err := env.db.DisplayName(ctx, env.f.Group, env.f.Alice.ID); err != nil || name != "Work" {
+		t.Errorf("DisplayName for alice = %q, %v", name, err)
+	}
+	if name, err := env.db.DisplayName(ctx, env.f.Group, env.f.Bob.ID); err != nil || name != "Team" {
+		t.Errorf("DisplayName for bob = %q, %v", name, err)
+	}
+}
+
+func TestCustomNameClearReverts(t *testing.T) {
+	env := newTestEnv(t, nil)
+
+	// A direct conversation goes back to the other participant's name
+	setCustomName(t, env, env.f.Alice, env.f.Direct.ID, strPtr("Bobby"))
+	for _, name := range []*string{nil, strPtr("   ")} {
+		var conv models.Conversation
+		decode(t, setCustomName(t, env, env.f.Alice, env.f.Direct.ID, name), http.StatusOK, &conv)
+		if conv.Name != "bob" || conv.Membership.CustomName != "" {
+			t.Errorf("after clearing: name %q, custom name %q, want bob", conv.Name, conv.Membership.CustomName)
+		}
+	}
+}
+
+func TestCustomNameRejected(t *testing.T) {
+	env := newTestEnv(t, nil)
+
+	long := strings.Repeat("é", maxConversationNameLength+1)
+	if rec := setCustomName(t, env, env.f.Alice, env.f.Group.ID, &long); rec.Code != http.StatusBadRequest {
+		t.Errorf("overlong name: status %d, want 400", rec.Code)
+	}
+	exact := strings.Repeat("é", maxConversationNameLength)
+	if rec := setCustomName(t, env, env.f.Alice, env.f.Group.ID, &exact); rec.Code != http.StatusOK {
+		t.Errorf("name at the limit: status %d, want 200", rec.Code)
+	}
+	if rec := setCustomName(t, env, env.f.Carol, env.f.Direct.ID, strPtr("Theirs")); rec.Code != http.StatusForbidden {
+		t.Errorf("renaming someone else's conversation: status %d, want 403", rec.Code)
+	}
+	if rec := call(t, env.h.HandleCustomName, env.f.Alice, http.MethodPost, "/api/conversations/participants/me",
+		models.CustomNameRequest{ConversationID: env.f.Group.ID}); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: status %d, want 405", rec.Code)
+	}
+}
@@ -0,0 +1,27 @@
+package api
+
+import (
+	"net/http"
+
+	"messager/internal/errsink"
+	"messager/internal/httpx"
+)
+
+// SetErrSink attaches the sink that counts swallowed errors. It must be
+// called before the server starts serving.
+func (h *Handlers) SetErrSink(sink *errsink.Sink) {
+	h.errs = sink
+}
+
+// HandleDebugErrors shows how many errors were swallowed, by category, and
+// the most recent ones. Admin only, and only routed when DEV_STRICT is set.
+func (h *Handlers) HandleDebugErrors(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireAdmin(w, r); !ok {
+		return
+	}
+	if !httpx.AllowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	httpx.WriteJSON(w, http.StatusOK, h.errs.Snapshot())
+}
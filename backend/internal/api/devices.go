@@ -0,0 +1,184 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/golang-jwt/jwt"
+
+	"messager/internal/db"
+	"messager/internal/httpx"
+	"messager/internal/models"
+)
+
+// A client identifies its device at login, or when opening a websocket,
+// with these headers. Browsers can't set headers on a websocket, so /ws
+// also accepts them as the query parameters device_id, device_name and
+// device_platform.
+const (
+	deviceIDHeader       = "X-Device-ID"
+	deviceNameHeader     = "X-Device-Name"
+	devicePlatformHeader = "X-Device-Platform"
+
+	// deviceClaim binds a session token to a device registration
+	deviceClaim = "device"
+
+	maxDeviceNameLength     = 64
+	maxDevicePlatformLength = 32
+)
+
+var deviceIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// errSessionRevoked means the device a session was issued to has been revoked
+var errSessionRevoked = errors.New("session revoked")
+
+// deviceRegistration is what a request says about the device it comes from
+type deviceRegistration struct {
+	id       string
+	name     string
+	platform string
+}
+
+// deviceFromRequest reads the device headers, falling back to query
+// parameters when allowQuery is set. It returns nil when the request names
+// no device.
+func deviceFromRequest(r *http.Request, allowQuery bool) (*deviceRegistration, error) {
+	get := func(header, param string) string {
+		value := r.Header.Get(header)
+		if value == "" && allowQuery {
+			value = r.URL.Query().Get(param)
+		}
+		return strings.TrimSpace(value)
+	}
+
+	device := &deviceRegistration{
+		id:       get(deviceIDHeader, "device_id"),
+		name:     get(deviceNameHeader, "device_name"),
+		platform: get(devicePlatformHeader, "device_platform"),
+	}
+	if device.id == "" {
+		return nil, nil
+	}
+	if !deviceIDPattern.MatchString(device.id) {
+		return nil, fmt.Errorf("device ID must be 1-128 letters, digits or . _ : -")
+	}
+	if utf8.RuneCountInString(device.name) > maxDeviceNameLength {
+		return nil, fmt.Errorf("device name must be at most %d characters", maxDeviceNameLength)
+	}
+	if utf8.RuneCountInString(device.platform) > maxDevicePlatformLength {
+		return nil, fmt.Errorf("device platform must be at most %d characters", maxDevicePlatformLength)
+	}
+	return device, nil
+}
+
+// sessionDevice returns the device registration a session token is bound
+// to, 0 for tokens issued without a device, or errSessionRevoked once that
+// device has been revoked
+func (h *Handlers) sessionDevice(ctx context.Context, claims jwt.MapClaims, userID int64) (int64, error) {
+	value, ok := claims[deviceClaim].(float64)
+	if !ok {
+		return 0, nil
+	}
+	registered, err := h.db.DeviceRegistered(ctx, userID, int64(value))
+	if err != nil {
+		return 0, err
+	}
+	if !registered {
+		return 0, errSessionRevoked
+	}
+	return int64(value), nil
+}
+
+// HandleDevices lists (GET), renames (PATCH) or revokes (DELETE) the
+// caller's devices. Revoking a device ends its sessions and closes its
+// websockets.
+func (h *Handlers) HandleDevices(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(userContextKey).(*models.User)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	current, _ := r.Context().Value(deviceContextKey).(int64)
+
+	switch r.Method {
+	case http.MethodGet:
+		devices, err := h.db.ListDevices(r.Context(), user.ID)
+		if err != nil {
+			log.Printf("Failed to list devices: %v", err)
+			http.Error(w, "Failed to list devices", http.StatusInternalServerError)
+			return
+		}
+		for i := range devices {
+			devices[i].Current = devices[i].ID == current
+		}
+		httpx.WriteJSON(w, http.StatusOK, devices)
+	case http.MethodPatch:
+		h.renameDevice(w, r, user, current)
+	case http.MethodDelete:
+		h.revokeDevice(w, r, user)
+	default:
+		httpx.AllowMethods(w, r, http.MethodGet, http.MethodPatch, http.MethodDelete)
+	}
+}
+
+func (h *Handlers) renameDevice(w http.ResponseWriter, r *http.Request, user *models.User, current int64) {
+	var req models.DeviceRequest
+	if err := httpx.DecodeJSON(r, &req); err != nil {
+		httpx.WriteDecodeError(w, err)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if n := utf8.RuneCountInString(req.Name); n < 1 || n > maxDeviceNameLength {
+		http.Error(w, fmt.Sprintf("Name must be between 1 and %d characters", maxDeviceNameLength), http.StatusBadRequest)
+		return
+	}
+
+	device, err := h.db.RenameDevice(r.Context(), user.ID, req.DeviceID, req.Name)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "Device not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		if h.writeReadOnlyError(w, err) {
+			return
+		}
+		log.Printf("Failed to rename device: %v", err)
+		http.Error(w, "Failed to rename device", http.StatusInternalServerError)
+		return
+	}
+	device.Current = device.ID == current
+
+	httpx.WriteJSON(w, http.StatusOK, device)
+}
+
+func (h *Handlers) revokeDevice(w http.ResponseWriter, r *http.Request, user *models.User) {
+	var req models.DeviceRequest
+	if err := httpx.DecodeJSON(r, &req); err != nil {
+		httpx.WriteDecodeError(w, err)
+		return
+	}
+
+	id, err := h.db.RevokeDevice(r.Context(), user.ID, req.DeviceID)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "Device not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		if h.writeReadOnlyError(w, err) {
+			return
+		}
+		log.Printf("Failed to revoke device: %v", err)
+		http.Error(w, "Failed to revoke device", http.StatusInternalServerError)
+		return
+	}
+
+	closed := h.hub.DisconnectDevice(user.ID, id)
+	log.Printf("User %d revoked device %d, closed %d connections", user.ID, id, closed)
+	w.WriteHeader(http.StatusNoContent)
+}
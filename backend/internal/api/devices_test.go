@@ -0,0 +1,149 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	gorilla "github.com/gorilla/websocket"
+	"messager/internal/config"
+	"messager/internal/db/testdb"
+	"messager/internal/models"
+	"messager/internal/websocket"
+)
+
+// loginFrom logs user in from a named device, returning the session token
+func loginFrom(t *testing.T, env *testEnv, user *models.User, deviceID, name string) string {
+	t.Helper()
+	body, _ := json.Marshal(models.LoginRequest{Username: user.Username, Password: testdb.Password})
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/login", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(deviceIDHeader, deviceID)
+	req.Header.Set(deviceNameHeader, name)
+	rec := httptest.NewRecorder()
+	env.h.HandleLogin(rec, req)
+	var resp models.LoginResponse
+	decode(t, rec, http.StatusOK, &resp)
+	if resp.Device == nil || resp.Device.DeviceID != deviceID || !resp.Device.Current {
+		t.Fatalf("logged in with device %+v, want %s", resp.Device, deviceID)
+	}
+	return resp.Token
+}
+
+// withSession runs handler behind WithAuth for a request carrying token
+func withSession(t *testing.T, env *testEnv, handler http.HandlerFunc, token, method string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	req := httptest.NewRequest(method, "/api/users/me/devices", bytes.NewReader(data))
+	req.AddCookie(&http.Cookie{Name: "auth_token", Value: token})
+	rec := httptest.NewRecorder()
+	env.h.WithAuth(handler).ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRevokingADeviceEndsOnlyItsSessions(t *testing.T) {
+	env := newTestEnv(t, func(cfg *config.Config) { cfg.AllowEmptyOrigin = true })
+	phone := loginFrom(t, env, env.f.Alice, "phone-1", "Phone")
+	laptop := loginFrom(t, env, env.f.Alice, "laptop-1", "Laptop")
+
+	// Each session sees both devices and knows which one it is
+	var devices []models.Device
+	decode(t, withSession(t, env, env.h.HandleDevices, phone, http.MethodGet, nil), http.StatusOK, &devices)
+	current := map[string]bool{}
+	for _, d := range devices {
+		current[d.DeviceID] = d.Current
+	}
+	if len(devices) != 2 || !current["phone-1"] || current["laptop-1"] {
+		t.Fatalf("devices from the phone = %+v", devices)
+	}
+
+	// The laptop has a socket open when the phone revokes it
+	srv := httptest.NewServer(http.HandlerFunc(env.h.HandleWebSocket))
+	t.Cleanup(srv.Close)
+	header := http.Header{"Cookie": {"auth_token=" + laptop}}
+	conn, _, err := gorilla.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if rec := withSession(t, env, env.h.HandleDevices, phone, http.MethodDelete, models.DeviceRequest{DeviceID: "laptop-1"}); rec.Code != http.StatusNoContent {
+		t.Fatalf("revoke: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var closeErr *gorilla.CloseError
+	for err == nil {
+		_, _, err = conn.ReadMessage()
+	}
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseSessionRevoked {
+		t.Errorf("laptop socket ended with %v, want close %d", err, websocket.CloseSessionRevoked)
+	}
+
+	if rec := withSession(t, env, env.h.HandleDevices, laptop, http.MethodGet, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("revoked session: status %d, want 401", rec.Code)
+	}
+	if _, _, err := gorilla.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), header); err == nil {
+		t.Error("the revoked session opened a new socket")
+	}
+	decode(t, withSession(t, env, env.h.HandleDevices, phone, http.MethodGet, nil), http.StatusOK, &devices)
+	if len(devices) != 1 || devices[0].DeviceID != "phone-1" {
+		t.Errorf("devices after revoking the laptop = %+v", devices)
+	}
+
+	// Devices are per user: bob can't see or revoke alice's
+	bob := loginFrom(t, env, env.f.Bob, "phone-1", "Bob's phone")
+	if rec := withSession(t, env, env.h.HandleDevices, bob, http.MethodDelete, models.DeviceRequest{DeviceID: "phone-1"}); rec.Code != http.StatusNoContent {
+		t.Fatalf("bob revoking his own phone-1: status %d", rec.Code)
+	}
+	if rec := withSession(t, env, env.h.HandleDevices, phone, http.MethodGet, nil); rec.Code != http.StatusOK {
+		t.Errorf("alice's phone after bob revoked his: status %d, want 200", rec.Code)
+	}
+}
+
+func TestRenameDevice(t *testing.T) {
+	env := newTestEnv(t, nil)
+	phone := loginFrom(t, env, env.f.Alice, "phone-1", "Phone")
+
+	var device models.Device
+	decode(t, withSession(t, env, env.h.HandleDevices, phone, http.MethodPatch,
+		models.DeviceRequest{DeviceID: "phone-1", Name: "  Work phone "}), http.StatusOK, &device)
+	if device.Name != "Work phone" || !device.Current {
+		t.Errorf("renamed = %+v", device)
+	}
+	// Logging in again without a name keeps it
+	loginFrom(t, env, env.f.Alice, "phone-1", "")
+	devices, err := env.db.ListDevices(context.Background(), env.f.Alice.ID)
+	if err != nil || len(devices) != 1 || devices[0].Name != "Work phone" {
+		t.Errorf("devices = %+v, %v", devices, err)
+	}
+	for req, want := range map[models.DeviceRequest]int{
+		{DeviceID: "phone-1", Name: " "}:    http.StatusBadRequest,
+		{DeviceID: "tablet-1", Name: "Tab"}: http.StatusNotFound,
+	} {
+		if rec := withSession(t, env, env.h.HandleDevices, phone, http.MethodPatch, req); rec.Code != want {
+			t.Errorf("rename %+v: status %d, want %d", req, rec.Code, want)
+		}
+	}
+}
+
+func TestDeletedUsersSessionIsRefused(t *testing.T) {
+	env := newTestEnv(t, func(cfg *config.Config) { cfg.AllowEmptyOrigin = true })
+	token := loginFrom(t, env, env.f.Bob, "phone-1", "Phone")
+	if rec := withSession(t, env, env.h.HandleDevices, token, http.MethodGet, nil); rec.Code != http.StatusOK {
+		t.Fatalf("before deleting: status %d", rec.Code)
+	}
+
+	if err := env.db.DeleteUser(context.Background(), env.f.Bob.ID); err != nil {
+		t.Fatal(err)
+	}
+	if rec := withSession(t, env, env.h.HandleDevices, token, http.MethodGet, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("still-valid token of a deleted user: status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
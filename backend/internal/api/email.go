@@ -0,0 +1,144 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	netmail "net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"messager/internal/db"
+	"messager/internal/httpx"
+	"messager/internal/mail"
+	"messager/internal/models"
+)
+
+const (
+	// emailVerificationTTL is how long a verification link stays valid
+	emailVerificationTTL = 24 * time.Hour
+	maxEmailLength       = 254
+)
+
+// SetMailer attaches the outbound mail queue. Without one, email
+// addresses can't be set. It must be called before the server starts
+// serving.
+func (h *Handlers) SetMailer(queue *mail.Queue) {
+	h.mailer = queue
+}
+
+// HandleEmail returns (GET) or changes (PUT) the caller's email address.
+// A new address starts unverified and is sent a verification link.
+func (h *Handlers) HandleEmail(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(userContextKey).(*models.User)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		if !h.setEmail(w, r, user) {
+			return
+		}
+	default:
+		httpx.AllowMethods(w, r, http.MethodGet, http.MethodPut)
+		return
+	}
+
+	status, err := h.db.GetEmailStatus(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("Failed to load email for user %d: %v", user.ID, err)
+		http.Error(w, "Failed to load email", http.StatusInternalServerError)
+		return
+	}
+	httpx.WriteJSON(w, http.StatusOK, status)
+}
+
+// setEmail stores the address and queues its verification email, writing
+// the error response itself when it returns false
+func (h *Handlers) setEmail(w http.ResponseWriter, r *http.Request, user *models.User) bool {
+	if h.mailer == nil {
+		http.Error(w, "Email is not available on this server", http.StatusNotImplemented)
+		return false
+	}
+
+	var req models.SetEmailRequest
+	if err := httpx.DecodeJSON(r, &req); err != nil {
+		httpx.WriteDecodeError(w, err)
+		return false
+	}
+	// A bare address only; display names and comments aren't stored
+	address, err := netmail.ParseAddress(strings.TrimSpace(req.Email))
+	if err != nil || address.Name != "" || len(address.Address) > maxEmailLength {
+		http.Error(w, "Invalid email address", http.StatusBadRequest)
+		return false
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return false
+	}
+	token := hex.EncodeToString(buf)
+
+	if err := h.db.SetEmail(r.Context(), user.ID, address.Address, token, time.Now().UTC().Add(emailVerificationTTL)); err != nil {
+		if h.writeReadOnlyError(w, err) {
+			return false
+		}
+		log.Printf("Failed to set email for user %d: %v", user.ID, err)
+		http.Error(w, "Failed to set email", http.StatusInternalServerError)
+		return false
+	}
+
+	err = h.mailer.Enqueue(r.Context(), address.Address, mail.TemplateVerifyEmail, map[string]string{
+		"Username":  user.Username,
+		"Email":     address.Address,
+		"Link":      h.cfg.PublicURL + "/api/auth/verify-email?token=" + url.QueryEscape(token),
+		"ExpiresIn": "24 hours",
+	})
+	if err != nil {
+		// The address is saved; setting it again sends a fresh link
+		if h.writeReadOnlyError(w, err) {
+			return false
+		}
+		log.Printf("Failed to queue verification email for user %d: %v", user.ID, err)
+		http.Error(w, "Failed to send verification email", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// HandleVerifyEmail consumes the token from a verification link. It needs
+// no session, since the link is usually opened from a mail client.
+func (h *Handlers) HandleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	if !httpx.AllowMethods(w, r, http.MethodGet) {
+		return
+	}
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.Error(w, "token is required", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := h.db.VerifyEmail(r.Context(), token)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "This link is invalid or has expired", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		if h.writeReadOnlyError(w, err) {
+			return
+		}
+		log.Printf("Failed to verify email: %v", err)
+		http.Error(w, "Failed to verify email", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %d verified their email", userID)
+
+	httpx.WriteJSON(w, http.StatusOK, map[string]bool{"verified": true})
+}
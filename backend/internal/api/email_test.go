@@ -0,0 +1,94 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"messager/internal/mail"
+	"messager/internal/models"
+)
+
+// withMailer attaches a queue sending into the returned capture
+func withMailer(t *testing.T, env *testEnv) (*mail.Queue, *mail.CaptureSender) {
+	t.Helper()
+	renderer, err := mail.NewRenderer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := &mail.CaptureSender{}
+	queue := mail.NewQueue(env.db, sender, renderer, mail.Options{MaxAttempts: 3})
+	env.h.SetMailer(queue)
+	return queue, sender
+}
+
+var verifyLink = regexp.MustCompile(`/api/auth/verify-email\?token=(\S+)`)
+
+func TestSetAndVerifyEmail(t *testing.T) {
+	env := newTestEnv(t, nil)
+	queue, sender := withMailer(t, env)
+	setEmail := func(address string) *models.EmailStatus {
+		t.Helper()
+		var status models.EmailStatus
+		decode(t, call(t, env.h.HandleEmail, env.f.Alice, http.MethodPut, "/api/users/me/email",
+			models.SetEmailRequest{Email: address}), http.StatusOK, &status)
+		return &status
+	}
+	verify := func(token string) int {
+		return call(t, env.h.HandleVerifyEmail, nil, http.MethodGet, "/api/auth/verify-email?token="+url.QueryEscape(token), nil).Code
+	}
+	sentToken := func() string {
+		t.Helper()
+		queue.Drain(context.Background())
+		messages := sender.Messages()
+		if len(messages) == 0 {
+			t.Fatal("no verification email was sent")
+		}
+		match := verifyLink.FindStringSubmatch(messages[len(messages)-1].Text)
+		if match == nil {
+			t.Fatalf("no link in %s", messages[len(messages)-1].Text)
+		}
+		token, _ := url.QueryUnescape(match[1])
+		return token
+	}
+
+	if status := setEmail(" alice@example.com "); status.Email != "alice@example.com" || status.Verified {
+		t.Fatalf("after setting: %+v", status)
+	}
+	first := sentToken()
+
+	// Changing the address again invalidates the first link
+	setEmail("alice@work.example.com")
+	second := sentToken()
+	if code := verify(first); code != http.StatusBadRequest {
+		t.Errorf("superseded link: status %d, want 400", code)
+	}
+	if code := verify(second); code != http.StatusOK {
+		t.Fatalf("verify: status %d", code)
+	}
+	var status models.EmailStatus
+	decode(t, call(t, env.h.HandleEmail, env.f.Alice, http.MethodGet, "/api/users/me/email", nil), http.StatusOK, &status)
+	if status.Email != "alice@work.example.com" || !status.Verified || status.VerifiedAt == nil {
+		t.Errorf("after verifying: %+v", status)
+	}
+	if code := verify(second); code != http.StatusBadRequest {
+		t.Errorf("reused link: status %d, want 400", code)
+	}
+
+	for _, address := range []string{"not an address", "Alice <alice@example.com>"} {
+		if rec := call(t, env.h.HandleEmail, env.f.Alice, http.MethodPut, "/api/users/me/email",
+			models.SetEmailRequest{Email: address}); rec.Code != http.StatusBadRequest {
+			t.Errorf("%q: status %d, want 400", address, rec.Code)
+		}
+	}
+}
+
+func TestSetEmailWithoutMailer(t *testing.T) {
+	env := newTestEnv(t, nil)
+	if rec := call(t, env.h.HandleEmail, env.f.Alice, http.MethodPut, "/api/users/me/email",
+		models.SetEmailRequest{Email: "alice@example.com"}); rec.Code != http.StatusNotImplemented {
+		t.Errorf("status %d, want 501", rec.Code)
+	}
+}
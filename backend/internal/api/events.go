@@ -0,0 +1,171 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"messager/internal/httpx"
+	"messager/internal/models"
+	"messager/internal/websocket"
+)
+
+const (
+	defaultPollTimeout = 25 * time.Second
+	// maxPollTimeout stays under the 60s idle timeout common in proxies
+	maxPollTimeout = 55 * time.Second
+	// streamKeepalive is how often an idle event stream gets a comment, so
+	// proxies and the browser don't give up on it
+	streamKeepalive = 15 * time.Second
+)
+
+// HandleEventsPoll is the long-polling fallback for clients that can't keep
+// a websocket open. It waits up to timeout (default 25s) for events after
+// since_event_id and answers with the same frames the socket would carry.
+func (h *Handlers) HandleEventsPoll(w http.ResponseWriter, r *http.Request) {
+	if !httpx.AllowMethods(w, r, http.MethodGet) {
+		return
+	}
+	user := r.Context().Value(userContextKey).(*models.User)
+
+	var sinceID int64
+	if raw := r.URL.Query().Get("since_event_id"); raw != "" {
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || id < 0 {
+			http.Error(w, "Invalid since_event_id", http.StatusBadRequest)
+			return
+		}
+		sinceID = id
+	}
+	timeout := defaultPollTimeout
+	if raw := r.URL.Query().Get("timeout"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			http.Error(w, "Invalid timeout", http.StatusBadRequest)
+			return
+		}
+		timeout = min(d, maxPollTimeout)
+	}
+
+	result, err := h.hub.Poll(r.Context(), user.ID, sinceID, timeout)
+	switch {
+	case err == nil:
+		w.Header().Set("Cache-Control", "no-store")
+		httpx.WriteJSON(w, http.StatusOK, result)
+	case errors.Is(err, websocket.ErrPollConnected):
+		http.Error(w, "A websocket is already open for this user", http.StatusConflict)
+	case errors.Is(err, websocket.ErrTooManyPolls):
+		http.Error(w, "Too many concurrent polls", http.StatusTooManyRequests)
+	case errors.Is(err, websocket.ErrPollUnavailable):
+		http.Error(w, "Long polling is disabled on this server", http.StatusNotImplemented)
+	case errors.Is(err, r.Context().Err()):
+		// The client went away; there's no one to answer
+	default:
+		log.Printf("Failed to poll events for user %d: %v", user.ID, err)
+		http.Error(w, "Failed to poll events", http.StatusInternalServerError)
+	}
+}
+
+// HandleEventsStream streams the user's events as Server-Sent Events, for
+// read-mostly clients that don't need a websocket. It authenticates like
+// /ws. Each frame is sent as "data:" with the event_id as its "id:", so a
+// reconnecting EventSource resumes through Last-Event-ID; when that can't
+// be covered from memory the stream opens with a "resync_required" event.
+// The stream ends when the session lapses or the subscriber falls behind.
+func (h *Handlers) HandleEventsStream(w http.ResponseWriter, r *http.Request) {
+	if !httpx.AllowMethods(w, r, http.MethodGet) {
+		return
+	}
+	user, _, expiresAt, ok := h.authenticateWebSocket(w, r)
+	if !ok {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	// Subscribe before looking back, so nothing falls in between
+	events, cancel := h.hub.Subscribe(user.ID)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	var lastID int64
+	if raw := r.Header.Get("Last-Event-ID"); raw != "" {
+		if id, err := strconv.ParseInt(raw, 10, 64); err == nil && id > 0 {
+			frames, newest, ok := h.hub.EventsSince(user.ID, id)
+			if !ok {
+				// Carry the newest ID so the next reconnect resumes from
+				// after the resync instead of failing again
+				if newest > 0 {
+					fmt.Fprintf(w, "id: %d\n", newest)
+				}
+				fmt.Fprintf(w, "event: resync_required\ndata: {\"last_event_id\":%d}\n\n", newest)
+				lastID = newest
+			}
+			for _, frame := range frames {
+				lastID, _ = writeStreamEvent(w, frame)
+			}
+		}
+	}
+	flusher.Flush()
+	log.Printf("Event stream opened for user %d", user.ID)
+
+	keepalive := time.NewTicker(streamKeepalive)
+	defer keepalive.Stop()
+	var expired <-chan time.Time
+	if !expiresAt.IsZero() {
+		timer := time.NewTimer(time.Until(expiresAt))
+		defer timer.Stop()
+		expired = timer.C
+	}
+	for {
+		select {
+		case frame, ok := <-events:
+			if !ok {
+				return
+			}
+			// Events replayed above may also have been queued
+			if id, numbered := websocket.EventID(frame); numbered && id <= lastID {
+				continue
+			}
+			id, err := writeStreamEvent(w, frame)
+			if err != nil {
+				return
+			}
+			if id > 0 {
+				lastID = id
+			}
+			flusher.Flush()
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-expired:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeStreamEvent writes frame as one SSE event and returns its event_id
+func writeStreamEvent(w http.ResponseWriter, frame []byte) (int64, error) {
+	id, numbered := websocket.EventID(frame)
+	if numbered {
+		if _, err := fmt.Fprintf(w, "id: %d\n", id); err != nil {
+			return 0, err
+		}
+	}
+	_, err := fmt.Fprintf(w, "data: %s\n\n", frame)
+	return id, err
+}
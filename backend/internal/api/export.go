@@ -0,0 +1,152 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"messager/internal/db"
+	"messager/internal/httpx"
+	"messager/internal/models"
+)
+
+// exportFlushEvery controls how many rows are written between flushes so
+// large histories reach the client progressively instead of piling up in
+// the response buffer
+const exportFlushEvery = 500
+
+var unsafeFilenameChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// HandleExportConversation streams a conversation's full message history as
+// newline-delimited JSON or CSV
+func (h *Handlers) HandleExportConversation(w http.ResponseWriter, r *http.Request) {
+	if !httpx.AllowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	user, ok := r.Context().Value(userContextKey).(*models.User)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	conversationID, err := strconv.ParseInt(r.URL.Query().Get("conversation_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid conversation ID", http.StatusBadRequest)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		http.Error(w, "Invalid format, expected json or csv", http.StatusBadRequest)
+		return
+	}
+
+	conversation, err := h.db.GetConversationByID(r.Context(), conversationID)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch conversation", http.StatusInternalServerError)
+		return
+	}
+
+	isParticipant, err := h.db.IsConversationParticipant(r.Context(), conversationID, user.ID)
+	if err != nil {
+		http.Error(w, "Failed to check membership", http.StatusInternalServerError)
+		return
+	}
+	if !isParticipant {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if err := h.db.ApplyDisplayName(r.Context(), conversation, user.ID); err != nil {
+		log.Printf("Failed to resolve conversation name: %v", err)
+	}
+
+	filename := exportFilename(conversation, format, time.Now())
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	flusher, _ := w.(http.Flusher)
+	var write func(*models.ExportedMessage) error
+	var flush func()
+
+	if format == "csv" {
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"id", "sender_id", "sender_username", "message_type", "content", "created_at"}); err != nil {
+			return
+		}
+		write = func(msg *models.ExportedMessage) error {
+			// System messages have no sender
+			senderID := ""
+			if msg.SenderID != nil {
+				senderID = strconv.FormatInt(*msg.SenderID, 10)
+			}
+			return cw.Write([]string{
+				strconv.FormatInt(msg.ID, 10),
+				senderID,
+				msg.SenderUsername,
+				msg.MessageType,
+				msg.Content,
+				msg.CreatedAt.Format(time.RFC3339Nano),
+			})
+		}
+		flush = cw.Flush
+	} else {
+		enc := json.NewEncoder(w)
+		write = func(msg *models.ExportedMessage) error {
+			return enc.Encode(msg)
+		}
+		flush = func() {}
+	}
+
+	count := 0
+	err = h.db.StreamConversationMessages(r.Context(), conversationID, func(msg *models.ExportedMessage) error {
+		if err := write(msg); err != nil {
+			return err
+		}
+		count++
+		if count%exportFlushEvery == 0 {
+			flush()
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		return nil
+	})
+	flush()
+
+	// Headers are already sent at this point, so a failure mid-stream can
+	// only be logged; the client sees a truncated file
+	if err != nil {
+		log.Printf("Export of conversation %d failed after %d messages: %v", conversationID, count, err)
+		return
+	}
+
+	log.Printf("Exported %d messages from conversation %d for user %d", count, conversationID, user.ID)
+}
+
+// exportFilename builds a download name from the conversation name and date
+func exportFilename(conversation *models.Conversation, format string, now time.Time) string {
+	name := unsafeFilenameChars.ReplaceAllString(conversation.Name, "_")
+	if name == "" || name == "_" {
+		name = fmt.Sprintf("conversation_%d", conversation.ID)
+	}
+	return fmt.Sprintf("%s_%s.%s", name, now.Format("2006-01-02"), format)
+}
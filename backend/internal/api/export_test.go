@@ -0,0 +1,123 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// exportRow is what both export formats carry for a message, as text
+type exportRow struct {
+	ID, SenderID, CreatedAt string
+}
+
+func exportJSON(t *testing.T, env *testEnv, conversationID int64) []exportRow {
+	t.Helper()
+	rec := call(t, env.h.HandleExportConversation, env.f.Alice, http.MethodGet,
+		fmt.Sprintf("/api/conversations/export?conversation_id=%d", conversationID), nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var rows []exportRow
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		var raw map[string]json.RawMessage
+		if err := json.Unmarshal(scanner.Bytes(), &raw); err != nil {
+			t.Fatalf("decode %s: %v", scanner.Text(), err)
+		}
+		var createdAt string
+		json.Unmarshal(raw["created_at"], &createdAt)
+		rows = append(rows, exportRow{ID: string(raw["id"]), SenderID: string(raw["sender_id"]), CreatedAt: createdAt})
+	}
+	return rows
+}
+
+func exportCSV(t *testing.T, env *testEnv, conversationID int64) []exportRow {
+	t.Helper()
+	rec := call(t, env.h.HandleExportConversation, env.f.Alice, http.MethodGet,
+		fmt.Sprintf("/api/conversations/export?conversation_id=%d&format=csv", conversationID), nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(records[0], ","); got != "id,sender_id,sender_username,message_type,content,created_at" {
+		t.Fatalf("header = %s", got)
+	}
+	var rows []exportRow
+	for _, r := range records[1:] {
+		rows = append(rows, exportRow{ID: r[0], SenderID: r[1], CreatedAt: r[5]})
+	}
+	return rows
+}
+
+func TestExportKeepsSubsecondTimestamps(t *testing.T) {
+	env := newTestEnv(t, nil)
+	group := env.f.Group.ID
+	messages := env.f.Messages[2:] // the group's
+
+	jsonRows, csvRows := exportJSON(t, env, group), exportCSV(t, env, group)
+	if len(jsonRows) != len(messages) || len(csvRows) != len(messages) {
+		t.Fatalf("exported %d JSON and %d CSV rows, want %d", len(jsonRows), len(csvRows), len(messages))
+	}
+	for i, msg := range messages {
+		want := msg.CreatedAt.UTC().Format(time.RFC3339Nano)
+		if jsonRows[i].CreatedAt != want {
+			t.Errorf("JSON row %d created_at = %s, want %s", i, jsonRows[i].CreatedAt, want)
+		}
+		if csvRows[i].CreatedAt != want {
+			t.Errorf("CSV row %d created_at = %s, want %s", i, csvRows[i].CreatedAt, want)
+		}
+	}
+}
+
+func TestExportRequiresMembership(t *testing.T) {
+	env := newTestEnv(t, nil)
+	rec := call(t, env.h.HandleExportConversation, env.f.Carol, http.MethodGet,
+		fmt.Sprintf("/api/conversations/export?conversation_id=%d", env.f.Direct.ID), nil)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status %d, want 403", rec.Code)
+	}
+	rec = call(t, env.h.HandleExportConversation, env.f.Alice, http.MethodGet,
+		fmt.Sprintf("/api/conversations/export?conversation_id=%d&format=xml", env.f.Direct.ID), nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("format=xml: status %d, want 400", rec.Code)
+	}
+}
+
+func TestExportSystemMessagesHaveNoSender(t *testing.T) {
+	env := newTestEnv(t, nil)
+	group := env.f.Group.ID
+	if _, err := env.db.CreateSystemMessage(context.Background(), group, `{"event":"topic_changed","text":"alice changed the topic"}`); err != nil {
+		t.Fatal(err)
+	}
+
+	for format, rows := range map[string][]exportRow{
+		"json": exportJSON(t, env, group),
+		"csv":  exportCSV(t, env, group),
+	} {
+		if len(rows) != 3 {
+			t.Fatalf("%s: exported %d rows, want 3", format, len(rows))
+		}
+		want := strconv.FormatInt(env.f.Alice.ID, 10)
+		if rows[0].SenderID != want {
+			t.Errorf("%s: user message sender_id = %s, want %s", format, rows[0].SenderID, want)
+		}
+		want = ""
+		if format == "json" {
+			want = "null"
+		}
+		if rows[2].SenderID != want {
+			t.Errorf("%s: system message sender_id = %q, want %q", format, rows[2].SenderID, want)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"messager/internal/config"
+	"messager/internal/models"
+)
+
+func TestSendMessageRateLimited(t *testing.T) {
+	env := newTestEnv(t, func(cfg *config.Config) {
+		cfg.MessageRatePerSec = 0.1
+		cfg.MessageRateBurst = 2
+	})
+	send := func(user *models.User, n int) int {
+		req := models.SendMessageRequest{ConversationID: env.f.Group.ID, Content: fmt.Sprintf("flood %d", n)}
+		rec := call(t, env.h.sendMessage, user, http.MethodPost, "/api/conversations/messages", req)
+		if rec.Code == http.StatusTooManyRequests {
+			seconds, err := strconv.Atoi(rec.Header().Get("Retry-After"))
+			if err != nil || seconds < 1 {
+				t.Errorf("Retry-After = %q, want whole seconds", rec.Header().Get("Retry-After"))
+			}
+		}
+		return rec.Code
+	}
+
+	for i := 0; i < 2; i++ {
+		if code := send(env.f.Alice, i); code != http.StatusCreated {
+			t.Fatalf("send %d: status %d, want 201", i, code)
+		}
+	}
+	if code := send(env.f.Alice, 2); code != http.StatusTooManyRequests {
+		t.Fatalf("send past the burst: status %d, want 429", code)
+	}
+	// The budget is per user
+	if code := send(env.f.Bob, 3); code != http.StatusCreated {
+		t.Fatalf("another user's send: status %d, want 201", code)
+	}
+}
+
+func TestBotsHaveTheirOwnSendLimit(t *testing.T) {
+	env := newTestEnv(t, func(cfg *config.Config) {
+		cfg.MessageRatePerSec = 0.1
+		cfg.MessageRateBurst = 1
+		cfg.BotRatePerSec = 0.1
+		cfg.BotRateBurst = 3
+	})
+	for i := 0; i < 3; i++ {
+		if ok, _ := env.hub.AllowSend(env.f.Alice.ID, true); !ok {
+			t.Fatalf("bot send %d refused within the bot burst", i)
+		}
+	}
+	if ok, _ := env.hub.AllowSend(env.f.Alice.ID, true); ok {
+		t.Fatal("bot send past the bot burst allowed")
+	}
+	// Spending the bot budget leaves the user budget alone
+	if ok, _ := env.hub.AllowSend(env.f.Alice.ID, false); !ok {
+		t.Fatal("user send refused after the bot budget ran out")
+	}
+	if ok, _ := env.hub.AllowSend(env.f.Alice.ID, false); ok {
+		t.Fatal("user send past the user burst allowed")
+	}
+}
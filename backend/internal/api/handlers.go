@@ -2,19 +2,29 @@ package api
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang-jwt/jwt"
 	gorilla "github.com/gorilla/websocket"
 	"golang.org/x/crypto/bcrypt"
 
+	"messager/internal/chaos"
+	"messager/internal/config"
+	"messager/internal/cursor"
 	"messager/internal/db"
+	"messager/internal/errsink"
+	"messager/internal/httpx"
+	"messager/internal/logsafe"
+	"messager/internal/mail"
 	"messager/internal/models"
+	"messager/internal/storage"
 	"messager/internal/websocket"
 )
 
@@ -22,35 +32,95 @@ type contextKey string
 
 const (
 	userContextKey contextKey = "user"
+	// deviceContextKey holds the int64 device registration of the session,
+	// absent when the token wasn't issued to a device
+	deviceContextKey contextKey = "device"
+)
+
+// nextPageTokenHeader carries the page_token for the next page on every
+// paginated listing
+const nextPageTokenHeader = "X-Next-Page-Token"
+
+// Cursor kinds, so a token from one listing can't be replayed on another
+const (
+	messagesCursorKind      = "messages"
+	conversationsCursorKind = "conversations"
+)
+
+// Page sizes for the conversation list
+const (
+	defaultConversationPageSize = 50
+	maxConversationPageSize     = 200
 )
 
 type Handlers struct {
-	db  *db.DB
-	hub *websocket.Hub
+	db        *db.DB
+	hub       *websocket.Hub
+	cfg       *config.Config
+	storage   *storage.Store
+	cursors   *cursor.Codec
+	chaos     *chaos.Injector
+	errs      *errsink.Sink
+	mailer    *mail.Queue
+	startedAt time.Time
+	upgrader  gorilla.Upgrader
+
+	warmingUp atomic.Bool
 }
 
-var upgrader = gorilla.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		origin := r.Header.Get("Origin")
-		return origin == "http://localhost:3000"
-	},
+func NewHandlers(db *db.DB, hub *websocket.Hub, cfg *config.Config) *Handlers {
+	h := &Handlers{db: db, hub: hub, cfg: cfg, cursors: cursor.New(cfg.JWTSecret), startedAt: time.Now()}
+	h.upgrader = gorilla.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     h.checkWebSocketOrigin,
+	}
+	return h
 }
 
-func NewHandlers(db *db.DB, hub *websocket.Hub) *Handlers {
-	return &Handlers{db: db, hub: hub}
+// checkWebSocketOrigin accepts a websocket upgrade from an allowed origin,
+// or with no Origin at all when ALLOW_EMPTY_ORIGIN is set
+func (h *Handlers) checkWebSocketOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" && h.cfg.AllowEmptyOrigin {
+		return true
+	}
+	if h.cfg.OriginAllowed(origin) {
+		return true
+	}
+	log.Printf("WARNING: rejected websocket origin %s from %s; add it to ALLOWED_ORIGINS if it's legitimate",
+		logsafe.String(origin), r.RemoteAddr)
+	return false
+}
+
+// SetStorage attaches the file store used for uploads, exports and backups.
+// It must be called before the server starts serving.
+func (h *Handlers) SetStorage(store *storage.Store) {
+	h.storage = store
 }
 
 // Middleware
 func (h *Handlers) WithAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Skip auth for login, register, and verify endpoints
-		if r.URL.Path == "/api/auth/login" || r.URL.Path == "/api/auth/register" || r.URL.Path == "/api/auth/verify" {
+		// Skip auth for login, register, verify and public metadata
+		// endpoints, and for the event stream, which authenticates like /ws
+		if r.URL.Path == "/api/auth/login" || r.URL.Path == "/api/auth/register" || r.URL.Path == "/api/auth/verify" || r.URL.Path == "/api/auth/verify-email" ||
+			r.URL.Path == "/api/version" || r.URL.Path == "/api/capabilities" || r.URL.Path == "/readyz" || r.URL.Path == "/healthz" || r.URL.Path == "/api/events/stream" {
 			next.ServeHTTP(w, r)
 			return
 		}
 
+		// Bots authenticate with their API key instead of a session
+		if key, ok := botAPIKey(r); ok {
+			bot, err := h.authenticateBot(r.Context(), key)
+			if err != nil {
+				http.Error(w, "Invalid API key", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey, bot)))
+			return
+		}
+
 		// Get token from cookie
 		cookie, err := r.Cookie("auth_token")
 		if err != nil {
@@ -60,12 +130,7 @@ func (h *Handlers) WithAuth(next http.Handler) http.Handler {
 
 		// Parse and validate token
 		claims := jwt.MapClaims{}
-		token, err := jwt.ParseWithClaims(cookie.Value, claims, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return []byte("your-secret-key"), nil // TODO: Use config
-		})
+		token, err := jwt.ParseWithClaims(cookie.Value, claims, h.sessionKey)
 
 		if err != nil || !token.Valid {
 			http.Error(w, "Invalid token", http.StatusUnauthorized)
@@ -87,14 +152,32 @@ func (h *Handlers) WithAuth(next http.Handler) http.Handler {
 		}
 
 		// Get user from database
-		user, err := h.db.GetUserByID(int64(userID))
-		if err != nil {
+		user, err := h.db.GetUserByID(r.Context(), int64(userID))
+		if errors.Is(err, db.ErrNotFound) || (err == nil && user.DeletedAt != nil) {
 			http.Error(w, "User not found", http.StatusUnauthorized)
 			return
 		}
+		if err != nil {
+			log.Printf("Failed to look up user %d: %v", int64(userID), err)
+			http.Error(w, "Failed to look up user", http.StatusInternalServerError)
+			return
+		}
+
+		deviceID, err := h.sessionDevice(r.Context(), claims, user.ID)
+		if err == errSessionRevoked {
+			http.Error(w, "Session revoked", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to check session", http.StatusInternalServerError)
+			return
+		}
 
 		// Add user to request context
 		ctx := context.WithValue(r.Context(), userContextKey, user)
+		if deviceID != 0 {
+			ctx = context.WithValue(ctx, deviceContextKey, deviceID)
+		}
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -107,11 +190,19 @@ func (h *Handlers) WithCORS(next http.Handler) http.Handler {
 			return
 		}
 
-		// Allow requests from your frontend domain in development
-		w.Header().Set("Access-Control-Allow-Origin", "http://localhost:3000")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		// Credentialed requests need the origin echoed back, never "*"
+		origin := r.Header.Get("Origin")
+		w.Header().Add("Vary", "Origin")
+		if h.cfg.OriginAllowed(origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		} else if origin != "" {
+			log.Printf("WARNING: rejected CORS origin %s for %s; add it to ALLOWED_ORIGINS if it's legitimate",
+				logsafe.String(origin), logsafe.String(r.URL.Path))
+		}
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, "+deviceIDHeader+", "+deviceNameHeader+", "+devicePlatformHeader)
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		w.Header().Set("Access-Control-Expose-Headers", nextPageTokenHeader+", Retry-After")
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -124,14 +215,13 @@ func (h *Handlers) WithCORS(next http.Handler) http.Handler {
 
 // Auth handlers
 func (h *Handlers) HandleRegister(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	if !httpx.AllowMethods(w, r, http.MethodPost) {
 		return
 	}
 
 	var req models.RegisterRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := httpx.DecodeJSON(r, &req); err != nil {
+		httpx.WriteDecodeError(w, err)
 		return
 	}
 
@@ -142,46 +232,80 @@ func (h *Handlers) HandleRegister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := h.db.CreateUser(req.Username, string(hashedPassword), req.Avatar)
+	user, err := h.db.CreateUser(r.Context(), req.Username, string(hashedPassword), req.Avatar)
 	if err != nil {
-		http.Error(w, "Username already exists", http.StatusConflict)
+		if h.writeReadOnlyError(w, err) {
+			return
+		}
+		if errors.Is(err, db.ErrDuplicateUsername) {
+			http.Error(w, "Username already exists", http.StatusConflict)
+			return
+		}
+		log.Printf("Failed to create user: %v", err)
+		http.Error(w, "Failed to create user", http.StatusInternalServerError)
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(user)
+	httpx.WriteJSON(w, http.StatusCreated, user)
 }
 
 func (h *Handlers) HandleLogin(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	if !httpx.AllowMethods(w, r, http.MethodPost) {
 		return
 	}
 
 	var req models.LoginRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if err := httpx.DecodeJSON(r, &req); err != nil {
+		httpx.WriteDecodeError(w, err)
 		return
 	}
 
-	user, err := h.db.GetUserByUsername(req.Username)
-	if err != nil {
+	user, err := h.db.GetUserByUsername(r.Context(), req.Username)
+	if errors.Is(err, db.ErrNotFound) {
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
+	if err != nil {
+		http.Error(w, "Failed to look up user", http.StatusInternalServerError)
+		return
+	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
-	// Create token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+	claims := jwt.MapClaims{
 		"user_id": user.ID,
 		"exp":     time.Now().Add(time.Hour * 24 * 30).Unix(), // 30 days
-	})
+	}
+
+	// A login from a named device gets a session bound to it, so revoking
+	// the device ends the session
+	registration, err := deviceFromRequest(r, false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var device *models.Device
+	if registration != nil {
+		device, err = h.db.RegisterDevice(r.Context(), user.ID, registration.id, registration.name, registration.platform)
+		if err != nil {
+			if h.writeReadOnlyError(w, err) {
+				return
+			}
+			log.Printf("Failed to register device for user %d: %v", user.ID, err)
+			http.Error(w, "Failed to register device", http.StatusInternalServerError)
+			return
+		}
+		device.Current = true
+		claims[deviceClaim] = device.ID
+	}
+
+	// Create token
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
-	tokenString, err := token.SignedString([]byte("your-secret-key")) // TODO: Use config
+	tokenString, err := token.SignedString([]byte(h.cfg.JWTSecret))
 	if err != nil {
 		http.Error(w, "Failed to create token", http.StatusInternalServerError)
 		return
@@ -201,17 +325,16 @@ func (h *Handlers) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	// Return user data and token
 	user.Password = "" // Don't send password back
 	response := models.LoginResponse{
-		Token: tokenString,
-		User:  *user,
+		Token:  tokenString,
+		User:   *user,
+		Device: device,
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	httpx.WriteJSON(w, http.StatusOK, response)
 }
 
 func (h *Handlers) HandleLogout(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	if !httpx.AllowMethods(w, r, http.MethodPost) {
 		return
 	}
 
@@ -229,8 +352,7 @@ func (h *Handlers) HandleLogout(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handlers) HandleVerify(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	if !httpx.AllowMethods(w, r, http.MethodGet) {
 		return
 	}
 
@@ -243,12 +365,7 @@ func (h *Handlers) HandleVerify(w http.ResponseWriter, r *http.Request) {
 
 	// Parse and validate token
 	claims := jwt.MapClaims{}
-	token, err := jwt.ParseWithClaims(cookie.Value, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte("your-secret-key"), nil // TODO: Use config
-	})
+	token, err := jwt.ParseWithClaims(cookie.Value, claims, h.sessionKey)
 
 	if err != nil || !token.Valid {
 		http.Error(w, "Invalid token", http.StatusUnauthorized)
@@ -270,23 +387,30 @@ func (h *Handlers) HandleVerify(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get user from database
-	user, err := h.db.GetUserByID(int64(userID))
-	if err != nil {
+	user, err := h.db.GetUserByID(r.Context(), int64(userID))
+	if errors.Is(err, db.ErrNotFound) || (err == nil && user.DeletedAt != nil) {
 		http.Error(w, "User not found", http.StatusUnauthorized)
 		return
 	}
+	if err != nil {
+		log.Printf("Failed to look up user %d: %v", int64(userID), err)
+		http.Error(w, "Failed to look up user", http.StatusInternalServerError)
+		return
+	}
+	if _, err := h.sessionDevice(r.Context(), claims, user.ID); err != nil {
+		http.Error(w, "Session revoked", http.StatusUnauthorized)
+		return
+	}
 
 	// Don't send password back
 	user.Password = ""
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(user)
+	httpx.WriteJSON(w, http.StatusOK, user)
 }
 
 // Conversation handlers
 func (h *Handlers) HandleCreateConversation(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	if !httpx.AllowMethods(w, r, http.MethodPost) {
 		return
 	}
 
@@ -298,74 +422,97 @@ func (h *Handlers) HandleCreateConversation(w http.ResponseWriter, r *http.Reque
 	}
 
 	var req models.CreateConversationRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if err := httpx.DecodeJSON(r, &req); err != nil {
+		httpx.WriteDecodeError(w, err)
 		return
 	}
 
-	// For direct messages, check if conversation already exists
-	if req.Type == "direct" && len(req.Participants) == 1 {
-		otherUserID := req.Participants[0]
-		existingConv, err := h.db.GetExistingDirectConversation(user.ID, otherUserID)
+	errs, err := h.validateCreateConversation(r.Context(), user, &req)
+	if err != nil {
+		log.Printf("Failed to validate conversation: %v", err)
+		http.Error(w, "Failed to create conversation", http.StatusInternalServerError)
+		return
+	}
+	if len(errs) > 0 {
+		httpx.WriteValidationErrors(w, errs)
+		return
+	}
+
+	// A pair of users shares exactly one direct conversation; asking for it
+	// again returns the existing one. Its stored name is irrelevant since
+	// every viewer sees the other participant's name.
+	if req.Type == "direct" {
+		conversation, created, err := h.db.GetOrCreateDirectConversation(r.Context(), user.ID, req.Participants[0], user.Username)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to check existing conversation: %v", err), http.StatusInternalServerError)
+			if errors.Is(err, db.ErrSelfConversation) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if h.writeReadOnlyError(w, err) {
+				return
+			}
+			if errors.Is(err, db.ErrForeignKey) {
+				http.Error(w, "Unknown participant", http.StatusBadRequest)
+				return
+			}
+			http.Error(w, fmt.Sprintf("Failed to create conversation: %v", err), http.StatusInternalServerError)
 			return
 		}
-		if existingConv != nil {
-			// Return the existing conversation
-			json.NewEncoder(w).Encode(existingConv)
-			return
+		if created {
+			h.announceCreated(r.Context(), conversation.ID, user.ID)
 		}
-	}
-
-	// For direct messages, ensure the conversation name is set to the sender's name
-	if req.Type == "direct" {
-		req.Name = user.Username
-	}
-
-	// Add the current user to participants if not already included
-	hasCurrentUser := false
-	for _, participantID := range req.Participants {
-		if participantID == user.ID {
-			hasCurrentUser = true
-			break
+		if err := h.db.ApplyDisplayName(r.Context(), conversation, user.ID); err != nil {
+			log.Printf("Failed to resolve conversation name: %v", err)
 		}
-	}
-	if !hasCurrentUser {
-		req.Participants = append(req.Participants, user.ID)
+		httpx.WriteJSON(w, http.StatusOK, conversation)
+		return
 	}
 
-	conversation, err := h.db.CreateConversation(req.Name, req.Type, req.Participants)
+	conversation, err := h.db.CreateConversation(r.Context(), req.Name, req.Type, user.ID, req.Participants)
 	if err != nil {
+		if h.writeReadOnlyError(w, err) {
+			return
+		}
+		if errors.Is(err, db.ErrForeignKey) {
+			http.Error(w, "Unknown participant", http.StatusBadRequest)
+			return
+		}
 		http.Error(w, fmt.Sprintf("Failed to create conversation: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	// For direct messages, create a second conversation for the other user
-	if req.Type == "direct" && len(req.Participants) == 2 {
-		otherUserID := req.Participants[0]
-		if otherUserID == user.ID {
-			otherUserID = req.Participants[1]
-		}
-
-		// Create a conversation for the other user with the current user's name
-		_, err = h.db.CreateConversation(user.Username, req.Type, req.Participants)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to create reciprocal conversation: %v", err), http.StatusInternalServerError)
-			return
-		}
+	h.announceCreated(r.Context(), conversation.ID, user.ID)
+	if req.Type == "group" {
+		h.postSystemEvent(r.Context(), conversation.ID, models.SystemEvent{
+			Event:   "conversation_created",
+			ActorID: user.ID,
+			Name:    conversation.Name,
+			Text:    fmt.Sprintf("%s created the group %q", user.Username, conversation.Name),
+		})
 	}
 
-	json.NewEncoder(w).Encode(conversation)
+	if err := h.db.ApplyDisplayName(r.Context(), conversation, user.ID); err != nil {
+		log.Printf("Failed to resolve conversation name: %v", err)
+	}
+	httpx.WriteJSON(w, http.StatusOK, conversation)
 }
 
 func (h *Handlers) HandleConversations(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	if r.Method == http.MethodPatch {
+		h.updateConversation(w, r)
+		return
+	}
+	if r.Method == http.MethodDelete {
+		h.deleteConversation(w, r)
+		return
+	}
+	if !httpx.AllowMethods(w, r, http.MethodGet) {
+		return
+	}
+	if r.URL.Query().Has("id") {
+		h.getConversation(w, r)
 		return
 	}
-
-
 
 	// Get user from context as *models.User
     user, ok := r.Context().Value(userContextKey).(*models.User)
@@ -375,29 +522,57 @@ func (h *Handlers) HandleConversations(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    log.Printf("Fetching conversations for user: %d", user.ID)
-    conversations, err := h.db.GetUserConversations(user.ID)
-    if err != nil {
-        log.Printf("Failed to fetch conversations: %v", err)
-        http.Error(w, "Failed to fetch conversations", http.StatusInternalServerError)
-        return
-    }
+	limit := defaultConversationPageSize
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n < 1 || n > maxConversationPageSize {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxConversationPageSize), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	var after *cursor.Position
+	if token := r.URL.Query().Get("page_token"); token != "" {
+		pos, err := h.cursors.Decode(token, conversationsCursorKind, user.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		after = &pos
+	}
 
-	log.Printf("Found %d conversations for user %d", len(conversations), user.ID)
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(conversations); err != nil {
-		log.Printf("Failed to encode conversations: %v", err)
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	log.Printf("Fetching conversations for user: %d", user.ID)
+	conversations, err := h.db.GetUserConversationsPage(r.Context(), user.ID, after, limit)
+	if err != nil {
+		log.Printf("Failed to fetch conversations: %v", err)
+		http.Error(w, "Failed to fetch conversations", http.StatusInternalServerError)
 		return
 	}
+
+	log.Printf("Found %d conversations for user %d", len(conversations), user.ID)
+	if conversations == nil {
+		conversations = []*models.Conversation{}
+	}
+	// A full page means there may be more; hand back where to continue
+	if len(conversations) == limit {
+		last := conversations[len(conversations)-1]
+		w.Header().Set(nextPageTokenHeader, h.cursors.Encode(conversationsCursorKind, user.ID, db.ConversationPosition(last)))
+	}
+	httpx.WriteJSON(w, http.StatusOK, conversations)
 }
 
 func (h *Handlers) HandleMessages(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+	switch r.Method {
+	case http.MethodGet:
+		h.listMessages(w, r)
+	case http.MethodPost:
+		h.sendMessage(w, r)
+	default:
+		httpx.AllowMethods(w, r, http.MethodGet, http.MethodPost)
 	}
+}
 
+func (h *Handlers) listMessages(w http.ResponseWriter, r *http.Request) {
 	conversationID, err := strconv.ParseInt(r.URL.Query().Get("conversation_id"), 10, 64)
 	if err != nil {
 		http.Error(w, "Invalid conversation ID", http.StatusBadRequest)
@@ -405,25 +580,156 @@ func (h *Handlers) HandleMessages(w http.ResponseWriter, r *http.Request) {
 	}
 
 	limit := 50 // Default limit
-	offset := 0
-	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
-		offset, _ = strconv.Atoi(offsetStr)
+
+	// after_seq lets a client that noticed a gap in seq values fetch exactly
+	// what it missed, oldest first
+	if afterSeqStr := r.URL.Query().Get("after_seq"); afterSeqStr != "" {
+		afterSeq, err := strconv.ParseInt(afterSeqStr, 10, 64)
+		if err != nil || afterSeq < 0 {
+			http.Error(w, "Invalid after_seq", http.StatusBadRequest)
+			return
+		}
+		// The client is chasing frames it was just sent, which a lagging
+		// replica may not have yet
+		messages, err := h.db.GetMessagesAfterSeq(db.ReadYourWrites(r.Context()), conversationID, afterSeq, limit)
+		if err != nil {
+			http.Error(w, "Failed to fetch messages", http.StatusInternalServerError)
+			return
+		}
+		httpx.WriteJSON(w, http.StatusOK, messages)
+		return
 	}
 
-	messages, err := h.db.GetConversationMessages(conversationID, limit, offset)
+	var messages []models.Message
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" && r.URL.Query().Get("page_token") == "" {
+		// Legacy offset paging; shifts when messages arrive or are deleted
+		offset, _ := strconv.Atoi(offsetStr)
+		messages, err = h.db.GetConversationMessages(r.Context(), conversationID, limit, offset)
+	} else {
+		var before *cursor.Position
+		if token := r.URL.Query().Get("page_token"); token != "" {
+			pos, err := h.cursors.Decode(token, messagesCursorKind, conversationID)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			before = &pos
+		}
+		messages, err = h.db.GetConversationMessagesBefore(r.Context(), conversationID, before, limit)
+	}
 	if err != nil {
 		http.Error(w, "Failed to fetch messages", http.StatusInternalServerError)
 		return
 	}
 
-	json.NewEncoder(w).Encode(messages)
+	// A full page means there may be more; hand back where to continue
+	if len(messages) == limit {
+		last := messages[len(messages)-1]
+		w.Header().Set(nextPageTokenHeader, h.cursors.Encode(messagesCursorKind, conversationID, cursor.Position{At: last.CreatedAt, ID: last.ID}))
+	}
+	httpx.WriteJSON(w, http.StatusOK, messages)
+}
+
+func (h *Handlers) sendMessage(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(userContextKey).(*models.User)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.SendMessageRequest
+	if err := httpx.DecodeJSON(r, &req); err != nil {
+		httpx.WriteDecodeError(w, err)
+		return
+	}
+
+	isParticipant, err := h.db.IsConversationParticipant(r.Context(), req.ConversationID, user.ID)
+	if err != nil {
+		http.Error(w, "Failed to check membership", http.StatusInternalServerError)
+		return
+	}
+	if !isParticipant {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if allowed, retryAfter := h.hub.AllowSend(user.ID, user.IsBot); !allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		http.Error(w, "Too many messages", http.StatusTooManyRequests)
+		return
+	}
+
+	message, duplicate, err := h.hub.CreateMessage(req.ConversationID, user.ID, user.Username, req.Content)
+	if err != nil {
+		if h.writeReadOnlyError(w, err) {
+			return
+		}
+		if errors.Is(err, db.ErrConversationGone) {
+			http.Error(w, "Conversation not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to send message", http.StatusInternalServerError)
+		return
+	}
+
+	// A double-submit gets the original message back without a second fan-out
+	if duplicate {
+		httpx.WriteJSON(w, http.StatusOK, message)
+		return
+	}
+
+	participants, err := h.db.GetConversationParticipantIDs(r.Context(), req.ConversationID)
+	if err != nil {
+		log.Printf("Failed to get conversation participants: %v", err)
+		h.errs.Swallow(errsink.Store, "api.send_message", err)
+	} else {
+		if err := h.hub.DeliverMessage(message, participants); err != nil {
+			log.Printf("Failed to broadcast message: %v", err)
+			h.errs.Swallow(errsink.FanOut, "api.send_message", err)
+		}
+		if !user.IsBot {
+			h.hub.RouteCommand(message, user.Username, participants)
+		}
+	}
+
+	httpx.WriteJSON(w, http.StatusCreated, message)
+}
+
+// HandleConversationMirror opts a conversation in or out of broker mirroring.
+// Admin-only until conversations have owners.
+func (h *Handlers) HandleConversationMirror(w http.ResponseWriter, r *http.Request) {
+	if !httpx.AllowMethods(w, r, http.MethodPost) {
+		return
+	}
+	if _, ok := h.requireAdmin(w, r); !ok {
+		return
+	}
+
+	var req models.ConversationMirrorRequest
+	if err := httpx.DecodeJSON(r, &req); err != nil {
+		httpx.WriteDecodeError(w, err)
+		return
+	}
+
+	if err := h.db.SetConversationMirror(r.Context(), req.ConversationID, req.Enabled); err != nil {
+		if h.writeReadOnlyError(w, err) {
+			return
+		}
+		if errors.Is(err, db.ErrNotFound) {
+			http.Error(w, "Conversation not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to update mirror setting", http.StatusInternalServerError)
+		return
+	}
+
+	httpx.WriteJSON(w, http.StatusOK, req)
 }
 
 // User handlers
 func (h *Handlers) HandleUsers(w http.ResponseWriter, r *http.Request) {
 	// Only allow GET method
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	if !httpx.AllowMethods(w, r, http.MethodGet) {
 		return
 	}
 
@@ -435,10 +741,10 @@ func (h *Handlers) HandleUsers(w http.ResponseWriter, r *http.Request) {
 
 	if query != "" {
 		// If search query is provided, search users
-		users, err = h.db.SearchUsers(query)
+		users, err = h.db.SearchUsers(r.Context(), query)
 	} else {
 		// If no search query, get all users
-		users, err = h.db.GetAllUsers()
+		users, err = h.db.GetAllUsers(r.Context())
 	}
 
 	if err != nil {
@@ -451,6 +757,10 @@ func (h *Handlers) HandleUsers(w http.ResponseWriter, r *http.Request) {
 		ID       int64  `json:"id"`
 		Username string `json:"username"`
 		Avatar   string `json:"avatar"`
+		IsBot    bool   `json:"is_bot,omitempty"`
+		Online   bool   `json:"online"`
+
+		LastSeenAt *time.Time `json:"last_seen_at,omitempty"`
 	}
 
 	response := make([]UserResponse, 0, len(users))
@@ -459,61 +769,174 @@ func (h *Handlers) HandleUsers(w http.ResponseWriter, r *http.Request) {
 			ID:       user.ID,
 			Username: user.Username,
 			Avatar:   user.Avatar,
+			IsBot:    user.IsBot,
+			Online:   h.hub.IsOnline(user.ID),
+
+			LastSeenAt: user.LastSeenAt,
 		})
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to encode response: %v", err), http.StatusInternalServerError)
-		return
-	}
+	httpx.WriteJSON(w, http.StatusOK, response)
 }
 
 // WebSocket handler
 func (h *Handlers) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	log.Printf("WebSocket connection attempt from %s", r.RemoteAddr)
 
+	user, deviceID, expiresAt, ok := h.authenticateWebSocket(w, r)
+	if !ok {
+		return
+	}
+	if h.hub.RefusesSession(user.ID) {
+		http.Error(w, "Already connected", http.StatusConflict)
+		return
+	}
+
+	// Upgrade connection
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Failed to upgrade connection: %v", err)
+		return
+	}
+
+	log.Printf("WebSocket authenticated for user: %s (ID: %d)", logsafe.String(user.Username), user.ID)
+
+	client := websocket.NewClient(h.hub, conn, user.ID, deviceID, user.Username, user.IsBot)
+	client.SetSessionExpiry(expiresAt)
+	if !h.hub.AddClient(client) {
+		websocket.CloseConn(conn, websocket.CloseServerRestart, "server restarting")
+		return
+	}
+
+	go client.WritePump()
+	go client.ReadPump()
+} 
+
+// authenticateWebSocket identifies the user opening a websocket, from a bot
+// API key or the session cookie, and the device it comes from: the one the
+// session is bound to, else one named on the request, else none (0). It
+// also returns when the session expires, zero for bots. It writes the error
+// response itself when it returns false.
+func (h *Handlers) authenticateWebSocket(w http.ResponseWriter, r *http.Request) (*models.User, int64, time.Time, bool) {
+	if key, ok := botAPIKey(r); ok {
+		bot, err := h.authenticateBot(r.Context(), key)
+		if err != nil {
+			log.Printf("Invalid bot API key: %s", logsafe.Err(err))
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return nil, 0, time.Time{}, false
+		}
+		return bot, 0, time.Time{}, true
+	}
+
 	// Get auth cookie
 	cookie, err := r.Cookie("auth_token")
 	if err != nil {
 		log.Printf("No auth cookie found: %v", err)
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
+		return nil, 0, time.Time{}, false
 	}
 
 	// Validate token
-	claims := jwt.MapClaims{}
-	token, err := jwt.ParseWithClaims(cookie.Value, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte("your-secret-key"), nil // Use config.JWTSecret in production
-	})
-
-	if err != nil || !token.Valid {
-		log.Printf("Invalid token: %v", err)
+	claims, err := h.parseSessionToken(cookie.Value)
+	if err != nil {
+		log.Printf("Invalid token: %s", logsafe.Err(err))
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
+		return nil, 0, time.Time{}, false
 	}
 
 	userIDFloat, _ := claims["user_id"].(float64)
-	userID := int64(userIDFloat)
-	user, err := h.db.GetUserByID(userID)
-	if err != nil {
-		log.Printf("User not found: %v", err)
+	user, err := h.db.GetUserByID(r.Context(), int64(userIDFloat))
+	if errors.Is(err, db.ErrNotFound) || (err == nil && user.DeletedAt != nil) {
 		http.Error(w, "User not found", http.StatusNotFound)
-		return
+		return nil, 0, time.Time{}, false
+	}
+	if err != nil {
+		log.Printf("Failed to look up user %d: %v", int64(userIDFloat), err)
+		http.Error(w, "Failed to look up user", http.StatusInternalServerError)
+		return nil, 0, time.Time{}, false
 	}
 
-	// Upgrade connection
-	conn, err := upgrader.Upgrade(w, r, nil)
+	deviceID, err := h.sessionDevice(r.Context(), claims, user.ID)
+	if err == errSessionRevoked {
+		http.Error(w, "Session revoked", http.StatusUnauthorized)
+		return nil, 0, time.Time{}, false
+	}
 	if err != nil {
-		log.Printf("Failed to upgrade connection: %v", err)
-		return
+		log.Printf("Failed to check session device: %v", err)
+		http.Error(w, "Failed to check session", http.StatusInternalServerError)
+		return nil, 0, time.Time{}, false
+	}
+	if deviceID != 0 {
+		if err := h.db.TouchDevice(r.Context(), deviceID); err != nil {
+			log.Printf("Failed to update device %d: %v", deviceID, err)
+		}
+		return user, deviceID, sessionExpiry(claims), true
 	}
 
-	log.Printf("WebSocket authenticated for user: %s (ID: %d)", user.Username, user.ID)
+	// Sessions from before devices existed can still name one on connect
+	registration, err := deviceFromRequest(r, true)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, 0, time.Time{}, false
+	}
+	if registration != nil {
+		device, err := h.db.RegisterDevice(r.Context(), user.ID, registration.id, registration.name, registration.platform)
+		if err != nil {
+			// The connection still works, just without a device
+			log.Printf("Failed to register device for user %d: %v", user.ID, err)
+		} else {
+			deviceID = device.ID
+		}
+	}
+	return user, deviceID, sessionExpiry(claims), true
+}
 
-	client := websocket.NewClient(h.hub, conn, userID, user.Username)
-	h.hub.Register <- client
+// sessionKey is the jwt.Keyfunc for session tokens: HMAC-signed with
+// JWT_SECRET
+func (h *Handlers) sessionKey(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return []byte(h.cfg.JWTSecret), nil
+}
 
-	go client.WritePump()
-	go client.ReadPump()
-} 
\ No newline at end of file
+// parseSessionToken checks a session token's signature and expiry and
+// returns its claims
+func (h *Handlers) parseSessionToken(raw string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(raw, claims, h.sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+// sessionExpiry returns when a session's token lapses, zero for never
+func sessionExpiry(claims jwt.MapClaims) time.Time {
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(int64(exp), 0)
+}
+
+// ValidateSessionToken checks a token a websocket presents to extend its
+// session. It applies the same checks as opening the connection.
+func (h *Handlers) ValidateSessionToken(ctx context.Context, raw string) (websocket.Session, error) {
+	claims, err := h.parseSessionToken(raw)
+	if err != nil {
+		return websocket.Session{}, err
+	}
+	userID, ok := claims["user_id"].(float64)
+	if !ok {
+		return websocket.Session{}, errors.New("invalid user ID in token")
+	}
+	deviceID, err := h.sessionDevice(ctx, claims, int64(userID))
+	if err != nil {
+		return websocket.Session{}, err
+	}
+	return websocket.Session{UserID: int64(userID), DeviceID: deviceID, ExpiresAt: sessionExpiry(claims)}, nil
+}
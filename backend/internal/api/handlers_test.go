@@ -0,0 +1,64 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"messager/internal/config"
+	"messager/internal/models"
+)
+
+func TestCORSPreflightAllowsPatch(t *testing.T) {
+	env := newTestEnv(t, func(cfg *config.Config) {
+		cfg.AllowedOrigins = []string{"https://chat.example.com"}
+	})
+	reached := false
+	handler := env.h.WithCORS(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { reached = true }))
+
+	req := httptest.NewRequest(http.MethodOptions, "/api/conversations", nil)
+	req.Header.Set("Origin", "https://chat.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPatch)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK || reached {
+		t.Fatalf("preflight: status %d, reached handler %t", rec.Code, reached)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://chat.example.com" {
+		t.Errorf("Allow-Origin = %q", got)
+	}
+	methods := strings.Split(rec.Header().Get("Access-Control-Allow-Methods"), ", ")
+	found := false
+	for _, m := range methods {
+		found = found || m == http.MethodPatch
+	}
+	if !found {
+		t.Errorf("Allow-Methods %v doesn't include PATCH", methods)
+	}
+
+	// Other origins get no grant
+	req.Header.Set("Origin", "https://evil.example.com")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Allow-Origin = %q for an unlisted origin", got)
+	}
+}
+
+func TestRegisterTakenUsername(t *testing.T) {
+	env := newTestEnv(t, nil)
+
+	rec := call(t, env.h.HandleRegister, nil, http.MethodPost, "/api/register",
+		models.RegisterRequest{Username: "alice", Password: "another-password"})
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("taken username: status %d, want %d", rec.Code, http.StatusConflict)
+	}
+	var user models.User
+	decode(t, call(t, env.h.HandleRegister, nil, http.MethodPost, "/api/register",
+		models.RegisterRequest{Username: "dave", Password: "password123"}), http.StatusCreated, &user)
+	if user.Username != "dave" || user.ID == 0 {
+		t.Errorf("registered %+v", user)
+	}
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"messager/internal/config"
+	"messager/internal/db"
+	"messager/internal/db/testdb"
+	"messager/internal/models"
+	"messager/internal/websocket"
+)
+
+// testEnv is a set of handlers over a seeded throwaway database, with a
+// running hub that nobody is connected to
+type testEnv struct {
+	h   *Handlers
+	db  *db.DB
+	hub *websocket.Hub
+	cfg *config.Config
+	f   *testdb.Fixtures
+}
+
+// newTestEnv builds handlers like main does, with the default
+// configuration adjusted by configure
+func newTestEnv(t *testing.T, configure func(*config.Config)) *testEnv {
+	t.Helper()
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	cfg := config.Load()
+	if configure != nil {
+		configure(cfg)
+	}
+	hub := websocket.NewHub(d, cfg)
+	go hub.Run()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		hub.Shutdown(ctx)
+	})
+	h := NewHandlers(d, hub, cfg)
+	hub.SetTokenValidator(h.ValidateSessionToken)
+	return &testEnv{h: h, db: d, hub: hub, cfg: cfg, f: f}
+}
+
+// call runs handler for a request from user, nil for an anonymous one.
+// A non-nil body is sent as JSON unless it's already a string.
+func call(t *testing.T, handler http.HandlerFunc, user *models.User, method, target string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		reader = bytes.NewBufferString(b)
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, target, reader)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if user != nil {
+		req = req.WithContext(context.WithValue(req.Context(), userContextKey, user))
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+// decode unmarshals a response body into v, failing on a status other
+// than want
+func decode(t *testing.T, rec *httptest.ResponseRecorder, want int, v interface{}) {
+	t.Helper()
+	if rec.Code != want {
+		t.Fatalf("status %d, want %d: %s", rec.Code, want, rec.Body.String())
+	}
+	if v == nil {
+		return
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decode %s: %v", rec.Body.String(), err)
+	}
+}
@@ -0,0 +1,269 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"runtime"
+	"strconv"
+	"time"
+
+	"messager/internal/db"
+	"messager/internal/httpx"
+	"messager/internal/models"
+	"messager/internal/version"
+)
+
+// HandleVersion reports the build metadata of the running server
+func (h *Handlers) HandleVersion(w http.ResponseWriter, r *http.Request) {
+	if !httpx.AllowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	httpx.WriteJSON(w, http.StatusOK, version.Get())
+}
+
+// HandleCapabilities describes what this server supports so clients can
+// feature-detect instead of guessing from the version string
+func (h *Handlers) HandleCapabilities(w http.ResponseWriter, r *http.Request) {
+	if !httpx.AllowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	response := map[string]interface{}{
+		"version": version.Get().Version,
+		"features": []string{
+			"export",
+			"heartbeat",
+			"ephemeral_state",
+			"long_poll",
+			"event_stream",
+		},
+		"limits": map[string]interface{}{
+			"message_rate_per_sec": h.cfg.MessageRatePerSec,
+			"message_rate_burst":   h.cfg.MessageRateBurst,
+		},
+		"heartbeat_interval_seconds": h.cfg.WSHeartbeatInterval.Seconds(),
+		"read_only":                  h.db.ReadOnly(),
+	}
+
+	httpx.WriteJSON(w, http.StatusOK, response)
+}
+
+// HandleReadyz reports whether the server can accept writes. Load balancers
+// and clients treat 503 as "degraded, reads only". While a startup warmup is
+// holding readiness it answers 503 with status "warming_up".
+func (h *Handlers) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	if h.warmingUp.Load() {
+		httpx.WriteJSON(w, http.StatusServiceUnavailable, map[string]interface{}{"status": "warming_up", "read_only": h.db.ReadOnly()})
+		return
+	}
+	if h.db.ReadOnly() {
+		httpx.WriteJSON(w, http.StatusServiceUnavailable, map[string]interface{}{"status": "degraded", "read_only": true})
+		return
+	}
+	httpx.WriteJSON(w, http.StatusOK, map[string]interface{}{"status": "ready", "read_only": false})
+}
+
+// HandleHealthz reports whether the database answers, with its pool
+// counters: 200 when it does, 503 when the check fails or times out
+func (h *Handlers) HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	if !httpx.AllowMethods(w, r, http.MethodGet) {
+		return
+	}
+	report, err := h.db.Health(r.Context())
+	if err != nil {
+		log.Printf("Database health check failed: %v", err)
+		httpx.WriteJSON(w, http.StatusServiceUnavailable, map[string]interface{}{"status": "unavailable", "database": report})
+		return
+	}
+	httpx.WriteJSON(w, http.StatusOK, map[string]interface{}{"status": "ok", "database": report})
+}
+
+// SetWarmingUp holds /readyz at 503 until called again with false
+func (h *Handlers) SetWarmingUp(warming bool) {
+	h.warmingUp.Store(warming)
+}
+
+// writeReadOnlyError answers with 503 when err comes from the database being
+// in read-only mode, so clients can tell it apart from a generic failure
+func (h *Handlers) writeReadOnlyError(w http.ResponseWriter, err error) bool {
+	if !errors.Is(err, db.ErrReadOnly) {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(h.cfg.DBRecoveryProbeInterval.Seconds())))
+	http.Error(w, "Server is in read-only mode", http.StatusServiceUnavailable)
+	return true
+}
+
+// requireAdmin returns the calling user when they are listed in
+// ADMIN_USERNAMES, otherwise it writes the error response itself
+func (h *Handlers) requireAdmin(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	user, ok := r.Context().Value(userContextKey).(*models.User)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+	if !h.cfg.IsAdmin(user.Username) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil, false
+	}
+	return user, true
+}
+
+// HandleAdminStats reports process-level health for operators
+func (h *Handlers) HandleAdminStats(w http.ResponseWriter, r *http.Request) {
+	if !httpx.AllowMethods(w, r, http.MethodGet) {
+		return
+	}
+	if _, ok := h.requireAdmin(w, r); !ok {
+		return
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	response := map[string]interface{}{
+		"version":           version.Get(),
+		"uptime_seconds":    int64(time.Since(h.startedAt).Seconds()),
+		"started_at":        h.startedAt.UTC(),
+		"goroutines":        runtime.NumGoroutine(),
+		"heap_alloc_bytes":  mem.HeapAlloc,
+		"connected_clients": h.hub.ClientCount(),
+		"db_pools":          h.db.PoolStats(),
+	}
+	// A failed check is reported in the body; the stats are still useful
+	health, _ := h.db.Health(r.Context())
+	response["db_health"] = health
+	if m := h.hub.Mirror(); m != nil {
+		published, dropped := m.Stats()
+		response["mirror"] = map[string]int64{
+			"published": published,
+			"dropped":   dropped,
+		}
+		response["deliveries"] = m.DeliveryStats()
+	}
+	if stats := h.hub.BotDeliveryStats(); len(stats) > 0 {
+		response["bot_deliveries"] = stats
+	}
+	if h.storage != nil {
+		response["storage"] = h.storage.Usage()
+	}
+	if h.mailer != nil {
+		response["mail"] = h.mailer.Stats()
+	}
+	if h.errs != nil {
+		response["swallowed_errors"] = h.errs.Snapshot().Total
+	}
+
+	httpx.WriteJSON(w, http.StatusOK, response)
+}
+
+// HandleAdminDBStats reports database row counts, file sizes and pool usage
+// for capacity planning
+func (h *Handlers) HandleAdminDBStats(w http.ResponseWriter, r *http.Request) {
+	if !httpx.AllowMethods(w, r, http.MethodGet) {
+		return
+	}
+	if _, ok := h.requireAdmin(w, r); !ok {
+		return
+	}
+
+	stats, err := h.db.Stats(r.Context())
+	if err != nil {
+		log.Printf("Failed to get database stats: %v", err)
+		http.Error(w, "Failed to get database stats", http.StatusInternalServerError)
+		return
+	}
+	httpx.WriteJSON(w, http.StatusOK, stats)
+}
+
+// HandleAdminIntegrity counts rows orphaned by deletes from before foreign
+// keys were enforced: GET reports them, POST also deletes them (admins only)
+func (h *Handlers) HandleAdminIntegrity(w http.ResponseWriter, r *http.Request) {
+	if !httpx.AllowMethods(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+	admin, ok := h.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	fix := r.Method == http.MethodPost
+	orphans, err := h.db.CheckOrphans(r.Context(), fix)
+	if err != nil {
+		if h.writeReadOnlyError(w, err) {
+			return
+		}
+		log.Printf("Failed to check for orphaned rows: %v", err)
+		http.Error(w, "Failed to check for orphaned rows", http.StatusInternalServerError)
+		return
+	}
+	if fix {
+		total := int64(0)
+		for _, n := range orphans {
+			total += n
+		}
+		if total > 0 {
+			log.Printf("Admin %d deleted %d orphaned rows", admin.ID, total)
+		}
+	}
+
+	httpx.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"orphans": orphans,
+		"fixed":   fix,
+	})
+}
+
+// HandleAdminWSStats reports the websocket hub's connection and delivery counters
+func (h *Handlers) HandleAdminWSStats(w http.ResponseWriter, r *http.Request) {
+	if !httpx.AllowMethods(w, r, http.MethodGet) {
+		return
+	}
+	if _, ok := h.requireAdmin(w, r); !ok {
+		return
+	}
+
+	httpx.WriteJSON(w, http.StatusOK, h.hub.Stats())
+}
+
+// HandleAdminConnections lists live websocket connections with their
+// counters, optionally only a user's (?user_id=N), and force-closes one
+// with DELETE ?id=N (admins only)
+func (h *Handlers) HandleAdminConnections(w http.ResponseWriter, r *http.Request) {
+	if !httpx.AllowMethods(w, r, http.MethodGet, http.MethodDelete) {
+		return
+	}
+	admin, ok := h.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil || id <= 0 {
+			http.Error(w, "Invalid connection ID", http.StatusBadRequest)
+			return
+		}
+		if !h.hub.CloseConnection(id) {
+			http.Error(w, "Connection not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Admin %d closed websocket connection %d", admin.ID, id)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var userID int64
+	if raw := r.URL.Query().Get("user_id"); raw != "" {
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || id <= 0 {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+		userID = id
+	}
+	httpx.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"connections": h.hub.Connections(userID),
+	})
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"messager/internal/config"
+	"messager/internal/db"
+	"messager/internal/version"
+)
+
+func TestVersion(t *testing.T) {
+	env := newTestEnv(t, nil)
+	var got version.Info
+	decode(t, call(t, env.h.HandleVersion, nil, http.MethodGet, "/api/version", nil), http.StatusOK, &got)
+	if got != version.Get() {
+		t.Errorf("version = %+v, want %+v", got, version.Get())
+	}
+	if rec := call(t, env.h.HandleVersion, nil, http.MethodPost, "/api/version", nil); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: status %d, want 405", rec.Code)
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	env := newTestEnv(t, func(cfg *config.Config) {
+		cfg.MessageRatePerSec = 4
+		cfg.MessageRateBurst = 9
+	})
+	var got struct {
+		Version  string   `json:"version"`
+		Features []string `json:"features"`
+		Limits   struct {
+			Rate  float64 `json:"message_rate_per_sec"`
+			Burst int     `json:"message_rate_burst"`
+		} `json:"limits"`
+		ReadOnly bool `json:"read_only"`
+	}
+	decode(t, call(t, env.h.HandleCapabilities, nil, http.MethodGet, "/api/capabilities", nil), http.StatusOK, &got)
+	if got.Version != version.Version {
+		t.Errorf("version = %q, want %q", got.Version, version.Version)
+	}
+	if got.Limits.Rate != 4 || got.Limits.Burst != 9 {
+		t.Errorf("limits = %+v, want the configured 4/s burst 9", got.Limits)
+	}
+	if got.ReadOnly {
+		t.Error("read_only on a writable database")
+	}
+	found := false
+	for _, f := range got.Features {
+		found = found || f == "export"
+	}
+	if !found {
+		t.Errorf("features %v don't list export", got.Features)
+	}
+}
+
+func TestReadyzHeldDuringWarmup(t *testing.T) {
+	env := newTestEnv(t, nil)
+	var got struct {
+		Status string `json:"status"`
+	}
+	env.h.SetWarmingUp(true)
+	decode(t, call(t, env.h.HandleReadyz, nil, http.MethodGet, "/readyz", nil), http.StatusServiceUnavailable, &got)
+	if got.Status != "warming_up" {
+		t.Errorf("status = %q, want warming_up", got.Status)
+	}
+	env.h.SetWarmingUp(false)
+	decode(t, call(t, env.h.HandleReadyz, nil, http.MethodGet, "/readyz", nil), http.StatusOK, &got)
+	if got.Status != "ready" {
+		t.Errorf("status = %q, want ready", got.Status)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	env := newTestEnv(t, nil)
+	var got struct {
+		Status   string          `json:"status"`
+		Database db.HealthReport `json:"database"`
+	}
+	decode(t, call(t, env.h.HandleHealthz, nil, http.MethodGet, "/healthz", nil), http.StatusOK, &got)
+	if got.Status != "ok" || got.Database.Status != "ok" || got.Database.MaxOpenConnections == 0 {
+		t.Errorf("healthy: %+v", got)
+	}
+
+	// A pool that no longer answers fails the check
+	env.db.DB.Close()
+	decode(t, call(t, env.h.HandleHealthz, nil, http.MethodGet, "/healthz", nil), http.StatusServiceUnavailable, &got)
+	if got.Status != "unavailable" || got.Database.Error == "" {
+		t.Errorf("closed pool: %+v", got)
+	}
+}
+
+func TestAdminIntegrity(t *testing.T) {
+	env := newTestEnv(t, asAdmin("carol"))
+	if rec := call(t, env.h.HandleAdminIntegrity, env.f.Alice, http.MethodGet, "/api/admin/integrity", nil); rec.Code != http.StatusForbidden {
+		t.Errorf("non-admin: status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	var got struct {
+		Orphans map[string]int64 `json:"orphans"`
+		Fixed   bool             `json:"fixed"`
+	}
+	decode(t, call(t, env.h.HandleAdminIntegrity, env.f.Carol, http.MethodGet, "/api/admin/integrity", nil), http.StatusOK, &got)
+	if got.Fixed || len(got.Orphans) == 0 {
+		t.Errorf("GET: %+v, want every check reported and nothing fixed", got)
+	}
+	for name, n := range got.Orphans {
+		if n != 0 {
+			t.Errorf("%s = %d in a fresh database", name, n)
+		}
+	}
+	decode(t, call(t, env.h.HandleAdminIntegrity, env.f.Carol, http.MethodPost, "/api/admin/integrity", nil), http.StatusOK, &got)
+	if !got.Fixed {
+		t.Error("POST didn't report fixing")
+	}
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"messager/internal/models"
+)
+
+func TestListMessagesAfterSeq(t *testing.T) {
+	env := newTestEnv(t, nil)
+	group := env.f.Group.ID
+	var missed []models.Message
+	decode(t, call(t, env.h.HandleMessages, env.f.Bob, http.MethodGet,
+		fmt.Sprintf("/api/conversations/messages?conversation_id=%d&after_seq=1", group), nil), http.StatusOK, &missed)
+	if len(missed) != 1 || missed[0].ID != env.f.Messages[3].ID {
+		t.Fatalf("after_seq=1 returned %+v, want the group's second message", missed)
+	}
+
+	rec := call(t, env.h.HandleMessages, env.f.Bob, http.MethodGet,
+		fmt.Sprintf("/api/conversations/messages?conversation_id=%d&after_seq=-1", group), nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("after_seq=-1: status %d, want 400", rec.Code)
+	}
+}
+
+func TestListMessagesPageTokens(t *testing.T) {
+	env := newTestEnv(t, nil)
+	group := env.f.Group.ID
+	for i := 0; i < 49; i++ {
+		if _, err := env.db.CreateMessage(context.Background(), group, env.f.Bob.ID, fmt.Sprintf("message %d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	target := fmt.Sprintf("/api/conversations/messages?conversation_id=%d", group)
+
+	var page []models.Message
+	rec := call(t, env.h.HandleMessages, env.f.Bob, http.MethodGet, target, nil)
+	decode(t, rec, http.StatusOK, &page)
+	token := rec.Header().Get(nextPageTokenHeader)
+	if len(page) != 50 || token == "" {
+		t.Fatalf("first page: %d messages, token %q; want a full page and a token", len(page), token)
+	}
+
+	rec = call(t, env.h.HandleMessages, env.f.Bob, http.MethodGet, target+"&page_token="+url.QueryEscape(token), nil)
+	decode(t, rec, http.StatusOK, &page)
+	if len(page) != 1 || page[0].ID != env.f.Messages[2].ID {
+		t.Fatalf("second page: %+v, want only the oldest group message", page)
+	}
+	if next := rec.Header().Get(nextPageTokenHeader); next != "" {
+		t.Errorf("a short page still handed out token %q", next)
+	}
+
+	// The group's token is no good for the direct conversation, nor once altered
+	for name, target := range map[string]string{
+		"other conversation": fmt.Sprintf("/api/conversations/messages?conversation_id=%d&page_token=%s", env.f.Direct.ID, url.QueryEscape(token)),
+		"tampered":           target + "&page_token=" + url.QueryEscape("x"+token[1:]),
+	} {
+		if rec := call(t, env.h.HandleMessages, env.f.Bob, http.MethodGet, target, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", name, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"messager/internal/db"
+	"messager/internal/httpx"
+	"messager/internal/models"
+)
+
+// maxMuteDuration caps timed mutes; anything longer should be "forever"
+const maxMuteDuration = 365 * 24 * time.Hour
+
+// HandleConversationMute mutes (POST) or unmutes (DELETE) a conversation for
+// the caller. Muted conversations still receive events, flagged muted, so
+// the client stays in sync without alerting.
+func (h *Handlers) HandleConversationMute(w http.ResponseWriter, r *http.Request) {
+	if !httpx.AllowMethods(w, r, http.MethodPost, http.MethodDelete) {
+		return
+	}
+	user, ok := r.Context().Value(userContextKey).(*models.User)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.MuteRequest
+	if err := httpx.DecodeJSON(r, &req); err != nil {
+		httpx.WriteDecodeError(w, err)
+		return
+	}
+
+	var state models.MuteState
+	switch r.Method {
+	case http.MethodPost:
+		until := models.MuteForever
+		if req.Duration != "forever" {
+			d, err := time.ParseDuration(req.Duration)
+			if err != nil || d <= 0 || d > maxMuteDuration {
+				http.Error(w, `duration must be a positive duration of at most 8760h, or "forever"`, http.StatusBadRequest)
+				return
+			}
+			until = time.Now().UTC().Add(d)
+		}
+		state = models.MuteState{MutedUntil: &until, MuteMentions: req.MuteMentions}
+	case http.MethodDelete:
+	}
+
+	err := h.db.SetMute(r.Context(), req.ConversationID, user.ID, state.MutedUntil, state.MuteMentions)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		if h.writeReadOnlyError(w, err) {
+			return
+		}
+		log.Printf("Failed to update mute for conversation %d: %v", req.ConversationID, err)
+		http.Error(w, "Failed to update mute", http.StatusInternalServerError)
+		return
+	}
+
+	updated := models.MuteResponse{ConversationID: req.ConversationID, MuteState: state}
+	// Keep the user's other devices in sync
+	h.hub.SendToUser(user.ID, models.WebSocketMessage{
+		Type:    "conversation_mute_updated",
+		Payload: updated,
+	})
+
+	httpx.WriteJSON(w, http.StatusOK, updated)
+}
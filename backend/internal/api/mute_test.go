@@ -0,0 +1,55 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"messager/internal/models"
+)
+
+func TestMuteConversation(t *testing.T) {
+	env := newTestEnv(t, nil)
+	mute := func(user *models.User, method string, req models.MuteRequest) *models.MuteResponse {
+		t.Helper()
+		var resp models.MuteResponse
+		decode(t, call(t, env.h.HandleConversationMute, user, method, "/api/conversations/mute", req), http.StatusOK, &resp)
+		return &resp
+	}
+
+	resp := mute(env.f.Bob, http.MethodPost, models.MuteRequest{ConversationID: env.f.Group.ID, Duration: "8h", MuteMentions: true})
+	if resp.MutedUntil == nil || time.Until(*resp.MutedUntil) < 7*time.Hour || !resp.MuteMentions {
+		t.Errorf("8h mute = %+v", resp.MuteState)
+	}
+	resp = mute(env.f.Bob, http.MethodPost, models.MuteRequest{ConversationID: env.f.Group.ID, Duration: "forever"})
+	if resp.MutedUntil == nil || !resp.MutedUntil.Equal(models.MuteForever) || resp.MuteMentions {
+		t.Errorf("forever mute = %+v", resp.MuteState)
+	}
+	// Bob's view of the conversation carries his mute
+	var conv models.Conversation
+	decode(t, call(t, env.h.HandleConversations, env.f.Bob, http.MethodGet, fmt.Sprintf("/api/conversations?id=%d", env.f.Group.ID), nil), http.StatusOK, &conv)
+	if conv.ID != env.f.Group.ID || conv.Membership == nil || !conv.Membership.Active(time.Now()) {
+		t.Errorf("bob's membership = %+v, want muted", conv.Membership)
+	}
+
+	resp = mute(env.f.Bob, http.MethodDelete, models.MuteRequest{ConversationID: env.f.Group.ID})
+	if resp.MutedUntil != nil {
+		t.Errorf("after unmuting: %+v", resp.MuteState)
+	}
+
+	for _, tc := range []struct {
+		user *models.User
+		req  models.MuteRequest
+		want int
+	}{
+		{env.f.Bob, models.MuteRequest{ConversationID: env.f.Group.ID, Duration: "soon"}, http.StatusBadRequest},
+		{env.f.Bob, models.MuteRequest{ConversationID: env.f.Group.ID, Duration: "-1h"}, http.StatusBadRequest},
+		{env.f.Bob, models.MuteRequest{ConversationID: env.f.Group.ID, Duration: "9000h"}, http.StatusBadRequest},
+		{env.f.Carol, models.MuteRequest{ConversationID: env.f.Direct.ID, Duration: "1h"}, http.StatusForbidden},
+	} {
+		if rec := call(t, env.h.HandleConversationMute, tc.user, http.MethodPost, "/api/conversations/mute", tc.req); rec.Code != tc.want {
+			t.Errorf("%s muting %+v: status %d, want %d", tc.user.Username, tc.req, rec.Code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"messager/internal/httpx"
+	"messager/internal/models"
+)
+
+const (
+	defaultNotificationPageSize = 50
+	maxNotificationPageSize     = 200
+)
+
+// HandleNotifications lists the caller's notifications newest first. Pass
+// the last ID of a page as before_id for the next one; unread=true leaves
+// out those already read.
+func (h *Handlers) HandleNotifications(w http.ResponseWriter, r *http.Request) {
+	if !httpx.AllowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	user, ok := r.Context().Value(userContextKey).(*models.User)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	limit := defaultNotificationPageSize
+	if limitStr := query.Get("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n < 1 || n > maxNotificationPageSize {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxNotificationPageSize), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	var beforeID int64
+	if beforeStr := query.Get("before_id"); beforeStr != "" {
+		n, err := strconv.ParseInt(beforeStr, 10, 64)
+		if err != nil || n < 1 {
+			http.Error(w, "before_id must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		beforeID = n
+	}
+	unreadOnly := query.Get("unread") == "true"
+
+	notifications, unread, err := h.db.GetNotifications(r.Context(), user.ID, beforeID, limit, unreadOnly)
+	if err != nil {
+		log.Printf("Failed to fetch notifications for user %d: %v", user.ID, err)
+		http.Error(w, "Failed to fetch notifications", http.StatusInternalServerError)
+		return
+	}
+
+	httpx.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"notifications": notifications,
+		"unread_count":  unread,
+	})
+}
+
+// HandleMarkNotificationsRead marks some or all of the caller's
+// notifications read and tells their other connections with a
+// "notifications_read" event
+func (h *Handlers) HandleMarkNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	if !httpx.AllowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	user, ok := r.Context().Value(userContextKey).(*models.User)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.MarkNotificationsReadRequest
+	if err := httpx.DecodeJSON(r, &req); err != nil {
+		httpx.WriteDecodeError(w, err)
+		return
+	}
+
+	ids := req.IDs
+	if req.All {
+		ids = nil
+	}
+	updated, err := h.db.MarkNotificationsRead(r.Context(), user.ID, ids)
+	if err != nil {
+		if h.writeReadOnlyError(w, err) {
+			return
+		}
+		log.Printf("Failed to mark notifications read for user %d: %v", user.ID, err)
+		http.Error(w, "Failed to mark notifications read", http.StatusInternalServerError)
+		return
+	}
+
+	if updated > 0 {
+		h.hub.SendToUser(user.ID, models.WebSocketMessage{
+			Type:    "notifications_read",
+			Payload: map[string]interface{}{"ids": ids, "all": req.All},
+		})
+	}
+
+	httpx.WriteJSON(w, http.StatusOK, map[string]interface{}{"updated": updated})
+}
@@ -0,0 +1,335 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"messager/internal/db"
+	"messager/internal/httpx"
+	"messager/internal/models"
+)
+
+// maxParticipantsPerRequest bounds a single batch add
+const maxParticipantsPerRequest = 100
+
+var participantStatusCodes = map[string]int{
+	models.ParticipantAdded:         http.StatusCreated,
+	models.ParticipantAlreadyMember: http.StatusConflict,
+	models.ParticipantNotFound:      http.StatusNotFound,
+}
+
+// HandleConversationParticipants serves /api/conversations/participants:
+// POST adds users to a group, DELETE leaves or removes someone
+func (h *Handlers) HandleConversationParticipants(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		h.addParticipants(w, r)
+	case http.MethodDelete:
+		h.removeParticipant(w, r)
+	default:
+		httpx.AllowMethods(w, r, http.MethodPost, http.MethodDelete)
+	}
+}
+
+// canManageParticipants reports whether user may add members to
+// conversation. Any member of a group may; removing people is gated by role,
+// see removeParticipant.
+func (h *Handlers) canManageParticipants(ctx context.Context, conversation *models.Conversation, user *models.User) (bool, error) {
+	return h.db.IsConversationParticipant(ctx, conversation.ID, user.ID)
+}
+
+// addParticipants adds users to an existing group. Each user is reported
+// individually, so one bad ID doesn't block the rest.
+func (h *Handlers) addParticipants(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(userContextKey).(*models.User)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.AddParticipantsRequest
+	if err := httpx.DecodeJSON(r, &req); err != nil {
+		httpx.WriteDecodeError(w, err)
+		return
+	}
+	if len(req.UserIDs) > maxParticipantsPerRequest {
+		http.Error(w, "Too many user_ids", http.StatusBadRequest)
+		return
+	}
+
+	conversation, err := h.db.GetConversationByID(r.Context(), req.ConversationID)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch conversation", http.StatusInternalServerError)
+		return
+	}
+
+	allowed, err := h.canManageParticipants(r.Context(), conversation, user)
+	if err != nil {
+		http.Error(w, "Failed to check membership", http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if conversation.Type != "group" {
+		http.Error(w, "Participants can only be added to group conversations", http.StatusBadRequest)
+		return
+	}
+
+	current, err := h.db.GetConversationParticipantIDs(r.Context(), conversation.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch participants", http.StatusInternalServerError)
+		return
+	}
+	members := make(map[int64]bool, len(current))
+	for _, id := range current {
+		members[id] = true
+	}
+	joining := 0
+	for _, id := range req.UserIDs {
+		if !members[id] {
+			members[id] = true
+			joining++
+		}
+	}
+	if len(current)+joining > h.cfg.MaxGroupParticipants {
+		http.Error(w, fmt.Sprintf("Groups can have at most %d participants", h.cfg.MaxGroupParticipants), http.StatusBadRequest)
+		return
+	}
+
+	results, err := h.db.AddConversationParticipants(r.Context(), conversation.ID, req.UserIDs)
+	if err != nil {
+		if h.writeReadOnlyError(w, err) {
+			return
+		}
+		log.Printf("Failed to add participants to conversation %d: %v", conversation.ID, err)
+		http.Error(w, "Failed to add participants", http.StatusInternalServerError)
+		return
+	}
+	h.hub.ForgetParticipants(conversation.ID)
+
+	var added []int64
+	status := http.StatusNotFound
+	for i := range results {
+		results[i].Code = participantStatusCodes[results[i].Status]
+		switch results[i].Status {
+		case models.ParticipantAdded:
+			added = append(added, results[i].UserID)
+			status = http.StatusOK
+		case models.ParticipantAlreadyMember:
+			if status == http.StatusNotFound {
+				status = http.StatusConflict
+			}
+		}
+	}
+
+	if len(added) > 0 {
+		h.announceParticipantsAdded(r.Context(), conversation, user, added)
+	}
+
+	httpx.WriteJSON(w, status, models.AddParticipantsResponse{
+		Conversation: conversation,
+		Results:      results,
+	})
+}
+
+// announceParticipantsAdded tells existing members who joined, hands the new
+// members the conversation they've never seen along with a notification,
+// then records the event in the history
+func (h *Handlers) announceParticipantsAdded(ctx context.Context, conversation *models.Conversation, actor *models.User, added []int64) {
+	ctx = announceContext(ctx)
+	participants, err := h.db.GetConversationParticipantIDs(ctx, conversation.ID)
+	if err != nil {
+		log.Printf("Failed to get conversation participants: %v", err)
+		return
+	}
+
+	isNew := make(map[int64]bool, len(added))
+	for _, id := range added {
+		isNew[id] = true
+	}
+	var existing []int64
+	for _, id := range participants {
+		if !isNew[id] {
+			existing = append(existing, id)
+		}
+	}
+
+	h.hub.SendToConversation(conversation.ID, models.WebSocketMessage{
+		Type: "participant_added",
+		Payload: map[string]interface{}{
+			"conversation_id": conversation.ID,
+			"user_ids":        added,
+			"added_by":        actor.ID,
+		},
+	}, existing)
+	// Each new member gets the conversation as they'd see it from
+	// GET /api/conversations?id=, read back from the primary since it was
+	// just written
+	ctx = db.ReadYourWrites(ctx)
+	for _, id := range added {
+		view, err := h.db.GetConversationForViewer(ctx, conversation.ID, id)
+		if err != nil {
+			log.Printf("Failed to load conversation %d for user %d: %v", conversation.ID, id, err)
+			continue
+		}
+		h.hub.SendToConversation(conversation.ID, models.WebSocketMessage{
+			Type:    "conversation_added",
+			Payload: view,
+		}, []int64{id})
+		h.notifyAddedToGroup(ctx, view, actor.ID, id)
+	}
+
+	users, err := h.db.GetUsersByIDs(ctx, added)
+	if err != nil {
+		log.Printf("Failed to load added participants of conversation %d: %v", conversation.ID, err)
+	}
+	names := make([]string, 0, len(added))
+	for _, id := range added {
+		if u, ok := users[id]; ok {
+			names = append(names, u.Username)
+		}
+	}
+	h.postSystemEvent(ctx, conversation.ID, models.SystemEvent{
+		Event:     "participant_added",
+		ActorID:   actor.ID,
+		TargetIDs: added,
+		Text:      fmt.Sprintf("%s added %s", actor.Username, strings.Join(names, ", ")),
+	})
+}
+
+// removeParticipant handles both leaving (user_id omitted or the caller's
+// own ID) and removing someone else from a group
+func (h *Handlers) removeParticipant(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(userContextKey).(*models.User)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.RemoveParticipantRequest
+	if err := httpx.DecodeJSON(r, &req); err != nil {
+		httpx.WriteDecodeError(w, err)
+		return
+	}
+	if req.UserID == 0 {
+		req.UserID = user.ID
+	}
+	leaving := req.UserID == user.ID
+
+	conversation, err := h.db.GetConversationByID(r.Context(), req.ConversationID)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch conversation", http.StatusInternalServerError)
+		return
+	}
+
+	if !leaving {
+		if conversation.Type != "group" {
+			http.Error(w, "Participants can only be removed from group conversations", http.StatusBadRequest)
+			return
+		}
+		// Admins can remove members, the owner can remove anyone
+		role, ok := h.authorize(r.Context(), w, conversation.ID, user, actionRemoveParticipant)
+		if !ok {
+			return
+		}
+		targetRole, err := h.db.GetParticipantRole(r.Context(), conversation.ID, req.UserID)
+		if errors.Is(err, db.ErrNotFound) {
+			http.Error(w, "User is not a participant", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to fetch role", http.StatusInternalServerError)
+			return
+		}
+		if models.RoleRank(targetRole) >= models.RoleRank(role) {
+			http.Error(w, fmt.Sprintf("A group %s can't remove a group %s", role, targetRole), http.StatusForbidden)
+			return
+		}
+	}
+
+	remaining, newOwnerID, err := h.db.RemoveConversationParticipant(r.Context(), conversation.ID, req.UserID)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "User is not a participant", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		if h.writeReadOnlyError(w, err) {
+			return
+		}
+		log.Printf("Failed to remove participant %d from conversation %d: %v", req.UserID, conversation.ID, err)
+		http.Error(w, "Failed to remove participant", http.StatusInternalServerError)
+		return
+	}
+
+	h.hub.ForgetMembership(conversation.ID, req.UserID)
+
+	reason := "removed"
+	if leaving {
+		reason = "left"
+	}
+	h.hub.SendToConversation(conversation.ID, models.WebSocketMessage{
+		Type: "conversation_removed",
+		Payload: map[string]interface{}{
+			"conversation_id": conversation.ID,
+			"reason":          reason,
+		},
+	}, []int64{req.UserID})
+
+	// The last one out took the conversation with them; nobody is left to tell
+	if remaining > 0 {
+		h.announceParticipantRemoved(r.Context(), conversation, user, req.UserID, leaving)
+	}
+	if newOwnerID != 0 {
+		h.announceRoleChanged(r.Context(), conversation, user, newOwnerID, models.RoleOwner)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handlers) announceParticipantRemoved(ctx context.Context, conversation *models.Conversation, actor *models.User, removedID int64, leaving bool) {
+	ctx = announceContext(ctx)
+	participants, err := h.db.GetConversationParticipantIDs(ctx, conversation.ID)
+	if err != nil {
+		log.Printf("Failed to get conversation participants: %v", err)
+		return
+	}
+
+	h.hub.SendToConversation(conversation.ID, models.WebSocketMessage{
+		Type: "participant_removed",
+		Payload: map[string]interface{}{
+			"conversation_id": conversation.ID,
+			"user_id":         removedID,
+			"removed_by":      actor.ID,
+		},
+	}, participants)
+
+	event := models.SystemEvent{
+		Event:     "participant_left",
+		ActorID:   actor.ID,
+		TargetIDs: []int64{removedID},
+		Text:      fmt.Sprintf("%s left", actor.Username),
+	}
+	if !leaving {
+		name := "a participant"
+		if removed, err := h.db.GetUserByID(ctx, removedID); err == nil {
+			name = removed.Username
+		}
+		event.Event = "participant_removed"
+		event.Text = fmt.Sprintf("%s removed %s", actor.Username, name)
+	}
+	h.postSystemEvent(ctx, conversation.ID, event)
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"messager/internal/config"
+	"messager/internal/db/testdb"
+	"messager/internal/models"
+)
+
+func TestAddParticipantsReportsEachUser(t *testing.T) {
+	env := newTestEnv(t, nil)
+	dave := testdb.CreateUser(t, env.db, "dave")
+
+	var resp models.AddParticipantsResponse
+	decode(t, call(t, env.h.HandleConversationParticipants, env.f.Bob, http.MethodPost, "/api/conversations/participants",
+		models.AddParticipantsRequest{ConversationID: env.f.Group.ID, UserIDs: []int64{dave.ID, env.f.Carol.ID, 9999}}), http.StatusOK, &resp)
+
+	want := map[int64]string{
+		dave.ID:        models.ParticipantAdded,
+		env.f.Carol.ID: models.ParticipantAlreadyMember,
+		9999:           models.ParticipantNotFound,
+	}
+	for _, r := range resp.Results {
+		if r.Status != want[r.UserID] {
+			t.Errorf("user %d: %s, want %s", r.UserID, r.Status, want[r.UserID])
+		}
+	}
+	if len(resp.Results) != len(want) {
+		t.Errorf("got %d results, want %d", len(resp.Results), len(want))
+	}
+	member, err := env.db.IsConversationParticipant(context.Background(), env.f.Group.ID, dave.ID)
+	if err != nil || !member {
+		t.Errorf("dave is a member: %t, %v", member, err)
+	}
+
+	// Everyone already in: nothing changed
+	rec := call(t, env.h.HandleConversationParticipants, env.f.Bob, http.MethodPost, "/api/conversations/participants",
+		models.AddParticipantsRequest{ConversationID: env.f.Group.ID, UserIDs: []int64{dave.ID}})
+	if rec.Code != http.StatusConflict {
+		t.Errorf("re-adding: status %d, want 409", rec.Code)
+	}
+}
+
+func TestAddParticipantsRules(t *testing.T) {
+	env := newTestEnv(t, nil)
+	dave := testdb.CreateUser(t, env.db, "dave")
+	add := func(user *models.User, conversationID int64) int {
+		return call(t, env.h.HandleConversationParticipants, user, http.MethodPost, "/api/conversations/participants",
+			models.AddParticipantsRequest{ConversationID: conversationID, UserIDs: []int64{env.f.Carol.ID}}).Code
+	}
+	if code := add(dave, env.f.Group.ID); code != http.StatusForbidden {
+		t.Errorf("non-member adding: status %d, want 403", code)
+	}
+	if code := add(env.f.Alice, env.f.Direct.ID); code != http.StatusBadRequest {
+		t.Errorf("adding to a direct conversation: status %d, want 400", code)
+	}
+	if code := add(env.f.Alice, 9999); code != http.StatusNotFound {
+		t.Errorf("unknown conversation: status %d, want 404", code)
+	}
+}
+
+func removeParticipant(t *testing.T, env *testEnv, user *models.User, conversationID, userID int64) int {
+	t.Helper()
+	return call(t, env.h.HandleConversationParticipants, user, http.MethodDelete, "/api/conversations/participants",
+		models.RemoveParticipantRequest{ConversationID: conversationID, UserID: userID}).Code
+}
+
+func TestRemoveAndLeaveParticipants(t *testing.T) {
+	env := newTestEnv(t, nil)
+	ctx := context.Background()
+	group := env.f.Group.ID
+
+	if code := removeParticipant(t, env, env.f.Bob, group, env.f.Carol.ID); code != http.StatusForbidden {
+		t.Errorf("member removing a member: status %d, want 403", code)
+	}
+	if code := removeParticipant(t, env, env.f.Alice, env.f.Direct.ID, env.f.Bob.ID); code != http.StatusBadRequest {
+		t.Errorf("removing from a direct conversation: status %d, want 400", code)
+	}
+	if code := removeParticipant(t, env, env.f.Alice, group, env.f.Carol.ID); code != http.StatusNoContent {
+		t.Fatalf("owner removing a member: status %d, want 204", code)
+	}
+	if member, _ := env.db.IsConversationParticipant(ctx, group, env.f.Carol.ID); member {
+		t.Error("carol is still a member after being removed")
+	}
+	if code := removeParticipant(t, env, env.f.Alice, group, env.f.Carol.ID); code != http.StatusNotFound {
+		t.Errorf("removing a non-member: status %d, want 404", code)
+	}
+
+	// The owner leaving hands the group to who's left
+	if code := removeParticipant(t, env, env.f.Alice, group, 0); code != http.StatusNoContent {
+		t.Fatalf("owner leaving: status %d, want 204", code)
+	}
+	if role, err := env.db.GetParticipantRole(ctx, group, env.f.Bob.ID); err != nil || role != models.RoleOwner {
+		t.Errorf("bob's role after alice left = %q, %v, want owner", role, err)
+	}
+
+	events := map[string]bool{}
+	for _, event := range systemEvents(t, env, group) {
+		events[event.Event] = true
+	}
+	if !events["participant_removed"] || !events["participant_left"] {
+		t.Errorf("system events %v, want participant_removed and participant_left", events)
+	}
+}
+
+func TestAddParticipantsRespectsGroupSize(t *testing.T) {
+	env := newTestEnv(t, func(cfg *config.Config) { cfg.MaxGroupParticipants = 4 })
+	dave := testdb.CreateUser(t, env.db, "dave")
+	erin := testdb.CreateUser(t, env.db, "erin")
+	add := func(userIDs ...int64) int {
+		return call(t, env.h.HandleConversationParticipants, env.f.Alice, http.MethodPost, "/api/conversations/participants",
+			models.AddParticipantsRequest{ConversationID: env.f.Group.ID, UserIDs: userIDs}).Code
+	}
+
+	// Members already in don't count twice, nor does a repeated ID
+	if code := add(dave.ID, erin.ID); code != http.StatusBadRequest {
+		t.Errorf("growing the group past the limit: status %d, want 400", code)
+	}
+	if member, _ := env.db.IsConversationParticipant(context.Background(), env.f.Group.ID, dave.ID); member {
+		t.Error("a refused add still added dave")
+	}
+	if code := add(dave.ID, dave.ID, env.f.Bob.ID); code != http.StatusOK {
+		t.Errorf("filling the group: status %d, want 200", code)
+	}
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"messager/internal/db"
+	"messager/internal/httpx"
+	"messager/internal/models"
+)
+
+// HandlePinConversation pins a conversation to the top of the caller's list
+func (h *Handlers) HandlePinConversation(w http.ResponseWriter, r *http.Request) {
+	h.setPinned(w, r, true)
+}
+
+// HandleUnpinConversation returns a pinned conversation to the
+// activity-sorted part of the caller's list
+func (h *Handlers) HandleUnpinConversation(w http.ResponseWriter, r *http.Request) {
+	h.setPinned(w, r, false)
+}
+
+// setPinned changes the caller's pin on a conversation. Pins are private:
+// only the caller's own devices hear about the change.
+func (h *Handlers) setPinned(w http.ResponseWriter, r *http.Request, pin bool) {
+	if !httpx.AllowMethods(w, r, http.MethodPost) {
+		return
+	}
+	user, ok := r.Context().Value(userContextKey).(*models.User)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.PinRequest
+	if err := httpx.DecodeJSON(r, &req); err != nil {
+		httpx.WriteDecodeError(w, err)
+		return
+	}
+
+	updated := models.PinResponse{ConversationID: req.ConversationID, Pinned: pin}
+	var err error
+	if pin {
+		var pinnedAt time.Time
+		pinnedAt, err = h.db.PinConversation(r.Context(), req.ConversationID, user.ID, h.cfg.MaxPinnedConversations)
+		updated.PinnedAt = &pinnedAt
+	} else {
+		err = h.db.UnpinConversation(r.Context(), req.ConversationID, user.ID)
+	}
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	case err == db.ErrPinLimit:
+		http.Error(w, fmt.Sprintf("You can pin at most %d conversations", h.cfg.MaxPinnedConversations), http.StatusConflict)
+		return
+	case err != nil:
+		if h.writeReadOnlyError(w, err) {
+			return
+		}
+		log.Printf("Failed to update pin for conversation %d: %v", req.ConversationID, err)
+		http.Error(w, "Failed to update pin", http.StatusInternalServerError)
+		return
+	}
+
+	// Keep the user's other devices in sync
+	h.hub.SendToUser(user.ID, models.WebSocketMessage{
+		Type:    "conversation_pin_updated",
+		Payload: updated,
+	})
+
+	httpx.WriteJSON(w, http.StatusOK, updated)
+}
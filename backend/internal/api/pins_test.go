@@ -0,0 +1,83 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"messager/internal/config"
+	"messager/internal/models"
+)
+
+func TestPinnedConversationsListFirst(t *testing.T) {
+	env := newTestEnv(t, func(cfg *config.Config) {
+		cfg.MaxPinnedConversations = 1
+	})
+	quiet, err := env.db.CreateConversation(context.Background(), "Quiet", "group", env.f.Bob.ID, []int64{env.f.Alice.ID, env.f.Bob.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pin := func(user *models.User, handler http.HandlerFunc, conversationID int64) *models.PinResponse {
+		t.Helper()
+		var resp models.PinResponse
+		decode(t, call(t, handler, user, http.MethodPost, "/api/conversations/pin", models.PinRequest{ConversationID: conversationID}), http.StatusOK, &resp)
+		return &resp
+	}
+
+	// Alice pins the conversation with no messages, which would sort last
+	first := pin(env.f.Alice, env.h.HandlePinConversation, quiet.ID)
+	if !first.Pinned || first.PinnedAt == nil {
+		t.Fatalf("pin = %+v", first)
+	}
+	if again := pin(env.f.Alice, env.h.HandlePinConversation, quiet.ID); !again.PinnedAt.Equal(*first.PinnedAt) {
+		t.Errorf("pinning again moved it from %s to %s", first.PinnedAt, again.PinnedAt)
+	}
+	if rec := call(t, env.h.HandlePinConversation, env.f.Alice, http.MethodPost, "/api/conversations/pin",
+		models.PinRequest{ConversationID: env.f.Direct.ID}); rec.Code != http.StatusConflict {
+		t.Errorf("pinning past the limit: status %d, want 409", rec.Code)
+	}
+	if rec := call(t, env.h.HandlePinConversation, env.f.Carol, http.MethodPost, "/api/conversations/pin",
+		models.PinRequest{ConversationID: env.f.Direct.ID}); rec.Code != http.StatusForbidden {
+		t.Errorf("pinning someone else's conversation: status %d, want 403", rec.Code)
+	}
+
+	// Page through one at a time so the token crosses from the pinned
+	// section into the activity-ordered rest
+	var order []int64
+	target := "/api/conversations?limit=1"
+	for i := 0; i < 4; i++ {
+		var page []*models.Conversation
+		rec := call(t, env.h.HandleConversations, env.f.Alice, http.MethodGet, target, nil)
+		decode(t, rec, http.StatusOK, &page)
+		for _, conv := range page {
+			order = append(order, conv.ID)
+			if conv.Pinned != (conv.ID == quiet.ID) {
+				t.Errorf("conversation %d pinned = %t", conv.ID, conv.Pinned)
+			}
+		}
+		token := rec.Header().Get(nextPageTokenHeader)
+		if token == "" {
+			break
+		}
+		target = "/api/conversations?limit=1&page_token=" + url.QueryEscape(token)
+	}
+	if want := []int64{quiet.ID, env.f.Group.ID, env.f.Direct.ID}; fmt.Sprint(order) != fmt.Sprint(want) {
+		t.Errorf("alice's list = %v, want %v", order, want)
+	}
+
+	// Pins are per user: bob's list is still by activity
+	conversations, err := env.db.GetUserConversations(context.Background(), env.f.Bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := conversations[len(conversations)-1]; last.ID != quiet.ID || last.Pinned {
+		t.Errorf("bob's last conversation = %d (pinned %t), want the unpinned empty one", last.ID, last.Pinned)
+	}
+
+	if resp := pin(env.f.Alice, env.h.HandleUnpinConversation, quiet.ID); resp.Pinned || resp.PinnedAt != nil {
+		t.Errorf("unpin = %+v", resp)
+	}
+	pin(env.f.Alice, env.h.HandlePinConversation, env.f.Direct.ID) // the freed slot
+}
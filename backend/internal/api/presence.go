@@ -0,0 +1,19 @@
+package api
+
+import (
+	"net/http"
+
+	"messager/internal/httpx"
+)
+
+// HandleOnlineUsers lists who is online right now, so a client can seed
+// presence indicators before "presence" events start arriving
+func (h *Handlers) HandleOnlineUsers(w http.ResponseWriter, r *http.Request) {
+	if !httpx.AllowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	httpx.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"user_ids": h.hub.OnlineUserIDs(),
+	})
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"messager/internal/db"
+	"messager/internal/httpx"
+	"messager/internal/models"
+)
+
+// receiptBreakdownLimit is the largest audience that gets a per-user
+// breakdown; bigger conversations only get the counts
+const receiptBreakdownLimit = 50
+
+// HandleMessageReceipts returns delivered/read counts for a message to its
+// sender (or an admin), plus who has seen it in small conversations
+func (h *Handlers) HandleMessageReceipts(w http.ResponseWriter, r *http.Request) {
+	if !httpx.AllowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	user, ok := r.Context().Value(userContextKey).(*models.User)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	messageID, err := strconv.ParseInt(r.URL.Query().Get("message_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+
+	message, err := h.db.GetMessageByID(r.Context(), messageID)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch message", http.StatusInternalServerError)
+		return
+	}
+	if message.SenderID != user.ID && !h.cfg.IsAdmin(user.Username) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	summary, err := h.db.GetMessageReceipts(r.Context(), message, receiptBreakdownLimit)
+	if err != nil {
+		log.Printf("Failed to summarize receipts for message %d: %v", messageID, err)
+		http.Error(w, "Failed to fetch receipts", http.StatusInternalServerError)
+		return
+	}
+
+	httpx.WriteJSON(w, http.StatusOK, summary)
+}
+
+// HandleMarkRead advances the caller's read marker to a message, notifying
+// the conversation when it moves. Markers never move backwards, so marking
+// an older message is a no-op.
+func (h *Handlers) HandleMarkRead(w http.ResponseWriter, r *http.Request) {
+	if !httpx.AllowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	user, ok := r.Context().Value(userContextKey).(*models.User)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.MarkReadRequest
+	if err := httpx.DecodeJSON(r, &req); err != nil {
+		httpx.WriteDecodeError(w, err)
+		return
+	}
+
+	isParticipant, err := h.db.IsConversationParticipant(r.Context(), req.ConversationID, user.ID)
+	if err != nil {
+		http.Error(w, "Failed to check membership", http.StatusInternalServerError)
+		return
+	}
+	if !isParticipant {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	advanced, err := h.hub.MarkRead(req.ConversationID, user.ID, req.MessageID)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		if h.writeReadOnlyError(w, err) {
+			return
+		}
+		log.Printf("Failed to mark conversation %d read for user %d: %v", req.ConversationID, user.ID, err)
+		http.Error(w, "Failed to mark read", http.StatusInternalServerError)
+		return
+	}
+
+	httpx.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"conversation_id": req.ConversationID,
+		"message_id":      req.MessageID,
+		"advanced":        advanced,
+	})
+}
+
+// HandleUserPrivacy reads (GET) or replaces (PUT) the caller's privacy
+// settings
+func (h *Handlers) HandleUserPrivacy(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(userContextKey).(*models.User)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var settings models.PrivacySettings
+		if err := httpx.DecodeJSON(r, &settings); err != nil {
+			httpx.WriteDecodeError(w, err)
+			return
+		}
+		if err := h.db.SetPrivacySettings(r.Context(), user.ID, &settings); err != nil {
+			if h.writeReadOnlyError(w, err) {
+				return
+			}
+			http.Error(w, "Failed to update privacy settings", http.StatusInternalServerError)
+			return
+		}
+	default:
+		httpx.AllowMethods(w, r, http.MethodGet, http.MethodPut)
+		return
+	}
+
+	settings, err := h.db.GetPrivacySettings(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch privacy settings", http.StatusInternalServerError)
+		return
+	}
+	httpx.WriteJSON(w, http.StatusOK, settings)
+}
+
+// HandleAdminRepairUnread recomputes the stored unread counts from the read
+// markers, for one conversation with ?conversation_id=N or all of them, and
+// reports how many had drifted (admins only)
+func (h *Handlers) HandleAdminRepairUnread(w http.ResponseWriter, r *http.Request) {
+	if !httpx.AllowMethods(w, r, http.MethodPost) {
+		return
+	}
+	admin, ok := h.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	var conversationIDs []int64
+	if raw := r.URL.Query().Get("conversation_id"); raw != "" {
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid conversation_id", http.StatusBadRequest)
+			return
+		}
+		conversationIDs = append(conversationIDs, id)
+	}
+
+	repaired, err := h.db.RepairUnreadCounts(r.Context(), conversationIDs...)
+	if err != nil {
+		if h.writeReadOnlyError(w, err) {
+			return
+		}
+		log.Printf("Failed to repair unread counts: %v", err)
+		http.Error(w, "Failed to repair unread counts", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Admin %d repaired %d unread counts", admin.ID, repaired)
+
+	httpx.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"repaired": repaired,
+	})
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"messager/internal/models"
+)
+
+func TestMessageReceipts(t *testing.T) {
+	env := newTestEnv(t, nil)
+	ctx := context.Background()
+	msg := env.f.Messages[2] // alice's, in the group
+	if err := env.db.MarkDelivered(ctx, msg.ConversationID, msg.ID, []int64{env.f.Bob.ID}); err != nil {
+		t.Fatal(err)
+	}
+	// Carol read a later message without a delivered marker; the read covers it
+	if _, err := env.db.MarkRead(ctx, msg.ConversationID, env.f.Carol.ID, env.f.Messages[3].ID); err != nil {
+		t.Fatal(err)
+	}
+	target := fmt.Sprintf("/api/conversations/messages/receipts?message_id=%d", msg.ID)
+
+	var summary models.ReceiptSummary
+	decode(t, call(t, env.h.HandleMessageReceipts, env.f.Alice, http.MethodGet, target, nil), http.StatusOK, &summary)
+	if summary.Recipients != 2 || summary.Delivered != 2 || summary.Read != 1 || summary.Hidden != 0 {
+		t.Errorf("summary = %+v, want 2 recipients, 2 delivered, 1 read", summary)
+	}
+	if len(summary.Breakdown) != 2 {
+		t.Fatalf("breakdown = %+v, want bob and carol", summary.Breakdown)
+	}
+	bob, carol := summary.Breakdown[0], summary.Breakdown[1]
+	if bob.DeliveredAt == nil || bob.ReadAt != nil {
+		t.Errorf("bob: %+v, want delivered and unread", bob)
+	}
+	if carol.DeliveredAt == nil || carol.ReadAt == nil {
+		t.Errorf("carol: %+v, want read", carol)
+	}
+
+	// Carol opts out: she's only counted as hidden
+	decode(t, call(t, env.h.HandleUserPrivacy, env.f.Carol, http.MethodPut, "/api/users/privacy",
+		models.PrivacySettings{ReadReceipts: false}), http.StatusOK, nil)
+	summary = models.ReceiptSummary{}
+	decode(t, call(t, env.h.HandleMessageReceipts, env.f.Alice, http.MethodGet, target, nil), http.StatusOK, &summary)
+	if summary.Delivered != 1 || summary.Read != 0 || summary.Hidden != 1 {
+		t.Errorf("with carol hidden: %+v, want 1 delivered, 0 read, 1 hidden", summary)
+	}
+	if len(summary.Breakdown) != 1 || summary.Breakdown[0].UserID != env.f.Bob.ID {
+		t.Errorf("breakdown = %+v, want only bob", summary.Breakdown)
+	}
+
+	// Only the sender (or an admin) may look
+	if rec := call(t, env.h.HandleMessageReceipts, env.f.Bob, http.MethodGet, target, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("someone else's message: status %d, want 403", rec.Code)
+	}
+	if rec := call(t, env.h.HandleMessageReceipts, env.f.Alice, http.MethodGet, "/api/conversations/messages/receipts?message_id=9999", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown message: status %d, want 404", rec.Code)
+	}
+}
+
+func TestMessageReceiptsOmitBreakdownForLargeAudiences(t *testing.T) {
+	env := newTestEnv(t, nil)
+	ctx := context.Background()
+	members := []int64{env.f.Alice.ID}
+	for i := 0; i <= receiptBreakdownLimit; i++ {
+		user, err := env.db.CreateUser(ctx, fmt.Sprintf("user%02d", i), "x", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		members = append(members, user.ID)
+	}
+	big, err := env.db.CreateConversation(ctx, "Everyone", "group", env.f.Alice.ID, members)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := env.db.CreateMessage(ctx, big.ID, env.f.Alice.ID, "hello all")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var summary models.ReceiptSummary
+	decode(t, call(t, env.h.HandleMessageReceipts, env.f.Alice, http.MethodGet,
+		fmt.Sprintf("/api/conversations/messages/receipts?message_id=%d", msg.ID), nil), http.StatusOK, &summary)
+	if summary.Recipients != receiptBreakdownLimit+1 || summary.Breakdown != nil {
+		t.Errorf("%d recipients with a breakdown of %d, want %d and none", summary.Recipients, len(summary.Breakdown), receiptBreakdownLimit+1)
+	}
+}
+
+func TestAdminRepairUnread(t *testing.T) {
+	env := newTestEnv(t, asAdmin("carol"))
+	if _, err := env.db.Exec(`UPDATE conversation_participants SET unread_count = 99`); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := call(t, env.h.HandleAdminRepairUnread, env.f.Alice, http.MethodPost, "/api/admin/unread/repair", nil); rec.Code != http.StatusForbidden {
+		t.Errorf("non-admin: status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := call(t, env.h.HandleAdminRepairUnread, env.f.Carol, http.MethodPost, "/api/admin/unread/repair?conversation_id=x", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("bad conversation_id: status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	var got struct {
+		Repaired int64 `json:"repaired"`
+	}
+	target := fmt.Sprintf("/api/admin/unread/repair?conversation_id=%d", env.f.Direct.ID)
+	decode(t, call(t, env.h.HandleAdminRepairUnread, env.f.Carol, http.MethodPost, target, nil), http.StatusOK, &got)
+	if got.Repaired != 2 {
+		t.Errorf("repaired %d in the direct conversation, want 2", got.Repaired)
+	}
+	decode(t, call(t, env.h.HandleAdminRepairUnread, env.f.Carol, http.MethodPost, "/api/admin/unread/repair", nil), http.StatusOK, &got)
+	if got.Repaired != 3 {
+		t.Errorf("repaired %d in the rest, want the group's 3", got.Repaired)
+	}
+}
@@ -0,0 +1,209 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"messager/internal/db"
+	"messager/internal/httpx"
+	"messager/internal/models"
+)
+
+// maxReportNoteLength caps the free-text note attached to a report
+const maxReportNoteLength = 1000
+
+// HandleMessageRoutes serves /api/conversations/messages/{id}/... actions
+func (h *Handlers) HandleMessageRoutes(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/conversations/messages/")
+	parts := strings.Split(rest, "/")
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+
+	messageID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+
+	switch parts[1] {
+	case "report":
+		h.reportMessage(w, r, messageID)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *Handlers) reportMessage(w http.ResponseWriter, r *http.Request, messageID int64) {
+	if !httpx.AllowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	user, ok := r.Context().Value(userContextKey).(*models.User)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.ReportMessageRequest
+	if err := httpx.DecodeJSON(r, &req); err != nil {
+		httpx.WriteDecodeError(w, err)
+		return
+	}
+	if len(req.Note) > maxReportNoteLength {
+		http.Error(w, "Note is too long", http.StatusBadRequest)
+		return
+	}
+
+	message, err := h.db.GetMessageByID(r.Context(), messageID)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch message", http.StatusInternalServerError)
+		return
+	}
+	if message.IsSystem() {
+		http.Error(w, "System messages cannot be reported", http.StatusBadRequest)
+		return
+	}
+
+	isParticipant, err := h.db.IsConversationParticipant(r.Context(), message.ConversationID, user.ID)
+	if err != nil {
+		http.Error(w, "Failed to check membership", http.StatusInternalServerError)
+		return
+	}
+	if !isParticipant {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	report, err := h.db.CreateMessageReport(r.Context(), messageID, user.ID, req.Reason, req.Note)
+	if err != nil {
+		if h.writeReadOnlyError(w, err) {
+			return
+		}
+		// The message was deleted since it was looked up
+		if errors.Is(err, db.ErrForeignKey) {
+			http.Error(w, "Message not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to create report", http.StatusInternalServerError)
+		return
+	}
+
+	httpx.WriteJSON(w, http.StatusCreated, report)
+}
+
+// HandleAdminReports lists moderation reports, filtered by ?status=open|resolved
+func (h *Handlers) HandleAdminReports(w http.ResponseWriter, r *http.Request) {
+	if !httpx.AllowMethods(w, r, http.MethodGet) {
+		return
+	}
+	if _, ok := h.requireAdmin(w, r); !ok {
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status != "" && status != "open" && status != "resolved" {
+		http.Error(w, "Invalid status", http.StatusBadRequest)
+		return
+	}
+
+	reports, err := h.db.GetMessageReports(r.Context(), status)
+	if err != nil {
+		http.Error(w, "Failed to fetch reports", http.StatusInternalServerError)
+		return
+	}
+	if reports == nil {
+		reports = []*models.MessageReport{}
+	}
+
+	httpx.WriteJSON(w, http.StatusOK, reports)
+}
+
+// HandleAdminReportRoutes serves /api/admin/reports/{id}/resolve
+func (h *Handlers) HandleAdminReportRoutes(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/admin/reports/")
+	parts := strings.Split(rest, "/")
+	if len(parts) != 2 || parts[1] != "resolve" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !httpx.AllowMethods(w, r, http.MethodPost) {
+		return
+	}
+	admin, ok := h.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	reportID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid report ID", http.StatusBadRequest)
+		return
+	}
+
+	var req models.ResolveReportRequest
+	if r.ContentLength != 0 {
+		if err := httpx.DecodeJSON(r, &req); err != nil {
+			httpx.WriteDecodeError(w, err)
+			return
+		}
+	}
+
+	report, err := h.db.GetMessageReport(r.Context(), reportID)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "Report not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch report", http.StatusInternalServerError)
+		return
+	}
+
+	if req.DeleteMessage {
+		if err := h.db.SoftDeleteMessage(r.Context(), report.MessageID); err != nil {
+			if h.writeReadOnlyError(w, err) {
+				return
+			}
+			http.Error(w, "Failed to delete message", http.StatusInternalServerError)
+			return
+		}
+
+		participants, err := h.db.GetConversationParticipantIDs(r.Context(), report.ConversationID)
+		if err != nil {
+			log.Printf("Failed to get conversation participants: %v", err)
+		} else {
+			h.hub.SendToConversation(report.ConversationID, models.WebSocketMessage{
+				Type: "message_deleted",
+				Payload: map[string]interface{}{
+					"message_id":      report.MessageID,
+					"conversation_id": report.ConversationID,
+				},
+			}, participants)
+		}
+	}
+
+	if err := h.db.ResolveMessageReport(r.Context(), reportID, admin.ID); err != nil {
+		if h.writeReadOnlyError(w, err) {
+			return
+		}
+		http.Error(w, "Failed to resolve report", http.StatusInternalServerError)
+		return
+	}
+
+	report, err = h.db.GetMessageReport(r.Context(), reportID)
+	if err != nil {
+		http.Error(w, "Failed to fetch report", http.StatusInternalServerError)
+		return
+	}
+
+	httpx.WriteJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"messager/internal/config"
+	"messager/internal/models"
+)
+
+func asAdmin(names ...string) func(*config.Config) {
+	return func(cfg *config.Config) { cfg.AdminUsernames = names }
+}
+
+func TestReportAndResolveWithDelete(t *testing.T) {
+	env := newTestEnv(t, asAdmin("carol"))
+	target := env.f.Messages[0] // alice's, in the direct conversation
+	reportURL := fmt.Sprintf("/api/conversations/messages/%d/report", target.ID)
+
+	var report models.MessageReport
+	decode(t, call(t, env.h.HandleMessageRoutes, env.f.Bob, http.MethodPost, reportURL,
+		models.ReportMessageRequest{Reason: "spam", Note: "again"}), http.StatusCreated, &report)
+	if report.Status != "open" || report.SenderID != env.f.Alice.ID || report.ReportCount != 1 {
+		t.Fatalf("report = %+v", report)
+	}
+
+	// Reporting twice is idempotent
+	var again models.MessageReport
+	decode(t, call(t, env.h.HandleMessageRoutes, env.f.Bob, http.MethodPost, reportURL,
+		models.ReportMessageRequest{Reason: "spam"}), http.StatusCreated, &again)
+	if again.ID != report.ID {
+		t.Errorf("second report got id %d, want the first one's %d", again.ID, report.ID)
+	}
+
+	var open []models.MessageReport
+	decode(t, call(t, env.h.HandleAdminReports, env.f.Carol, http.MethodGet, "/api/admin/reports?status=open", nil), http.StatusOK, &open)
+	if len(open) != 1 || open[0].ID != report.ID {
+		t.Fatalf("open reports = %+v", open)
+	}
+
+	var resolved models.MessageReport
+	decode(t, call(t, env.h.HandleAdminReportRoutes, env.f.Carol, http.MethodPost,
+		fmt.Sprintf("/api/admin/reports/%d/resolve", report.ID), models.ResolveReportRequest{DeleteMessage: true}), http.StatusOK, &resolved)
+	if resolved.Status != "resolved" || resolved.ResolvedBy == nil || *resolved.ResolvedBy != env.f.Carol.ID {
+		t.Errorf("resolved report = %+v", resolved)
+	}
+
+	messages, err := env.db.GetConversationMessages(context.Background(), env.f.Direct.ID, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The history keeps a tombstone in its place
+	for _, m := range messages {
+		if m.ID == target.ID && (m.DeletedAt == nil || m.Content != "") {
+			t.Errorf("the reported message is still readable: %+v", m)
+		}
+	}
+	decode(t, call(t, env.h.HandleAdminReports, env.f.Carol, http.MethodGet, "/api/admin/reports?status=open", nil), http.StatusOK, &open)
+	if len(open) != 0 {
+		t.Errorf("%d reports still open", len(open))
+	}
+}
+
+func TestReportRules(t *testing.T) {
+	env := newTestEnv(t, asAdmin("carol"))
+	reportURL := fmt.Sprintf("/api/conversations/messages/%d/report", env.f.Messages[0].ID)
+	system, err := env.db.CreateSystemMessage(context.Background(), env.f.Group.ID, `{"event":"conversation_created"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		user   *models.User
+		target string
+		body   models.ReportMessageRequest
+		want   int
+	}{
+		{"not a participant", env.f.Carol, reportURL, models.ReportMessageRequest{Reason: "spam"}, http.StatusForbidden},
+		{"unknown reason", env.f.Bob, reportURL, models.ReportMessageRequest{Reason: "boring"}, http.StatusBadRequest},
+		{"system message", env.f.Bob, fmt.Sprintf("/api/conversations/messages/%d/report", system.ID), models.ReportMessageRequest{Reason: "spam"}, http.StatusBadRequest},
+		{"unknown message", env.f.Bob, "/api/conversations/messages/9999/report", models.ReportMessageRequest{Reason: "spam"}, http.StatusNotFound},
+	} {
+		if rec := call(t, env.h.HandleMessageRoutes, tc.user, http.MethodPost, tc.target, tc.body); rec.Code != tc.want {
+			t.Errorf("%s: status %d, want %d: %s", tc.name, rec.Code, tc.want, rec.Body.String())
+		}
+	}
+
+	if rec := call(t, env.h.HandleAdminReports, env.f.Bob, http.MethodGet, "/api/admin/reports", nil); rec.Code != http.StatusForbidden {
+		t.Errorf("non-admin listing reports: status %d, want 403", rec.Code)
+	}
+}
+
+func TestMirrorSettingIsAdminOnly(t *testing.T) {
+	env := newTestEnv(t, asAdmin("carol"))
+	req := models.ConversationMirrorRequest{ConversationID: env.f.Group.ID, Enabled: true}
+	if rec := call(t, env.h.HandleConversationMirror, env.f.Alice, http.MethodPost, "/api/conversations/mirror", req); rec.Code != http.StatusForbidden {
+		t.Fatalf("non-admin: status %d, want 403", rec.Code)
+	}
+	decode(t, call(t, env.h.HandleConversationMirror, env.f.Carol, http.MethodPost, "/api/conversations/mirror", req), http.StatusOK, nil)
+	enabled, err := env.db.IsConversationMirrored(context.Background(), env.f.Group.ID)
+	if err != nil || !enabled {
+		t.Fatalf("mirrored = %t, %v after opting in", enabled, err)
+	}
+
+	req.ConversationID = 9999
+	if rec := call(t, env.h.HandleConversationMirror, env.f.Carol, http.MethodPost, "/api/conversations/mirror", req); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown conversation: status %d, want 404", rec.Code)
+	}
+}
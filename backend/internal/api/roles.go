@@ -0,0 +1,260 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"messager/internal/db"
+	"messager/internal/httpx"
+	"messager/internal/models"
+)
+
+// Actions in a group that depend on the caller's role
+const (
+	actionRename             = "rename"
+	actionRemoveParticipant  = "remove_participant"
+	actionDeleteConversation = "delete_conversation"
+	actionChangeRole         = "change_role"
+	actionSetRetention       = "set_retention"
+	actionTransferOwnership  = "transfer_ownership"
+)
+
+// minimumRole is the least role allowed to perform each action
+var minimumRole = map[string]string{
+	actionRename:             models.RoleAdmin,
+	actionRemoveParticipant:  models.RoleAdmin,
+	actionDeleteConversation: models.RoleOwner,
+	actionChangeRole:         models.RoleOwner,
+	actionSetRetention:       models.RoleOwner,
+	actionTransferOwnership:  models.RoleOwner,
+}
+
+// authorize checks that user's role in a group allows action and returns
+// that role. When it returns false it has already written 403, or 500 if
+// the role couldn't be loaded.
+func (h *Handlers) authorize(ctx context.Context, w http.ResponseWriter, conversationID int64, user *models.User, action string) (string, bool) {
+	role, err := h.db.GetParticipantRole(ctx, conversationID, user.ID)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return "", false
+	}
+	if err != nil {
+		log.Printf("Failed to load role in conversation %d: %v", conversationID, err)
+		http.Error(w, "Failed to check membership", http.StatusInternalServerError)
+		return "", false
+	}
+	if models.RoleRank(role) < models.RoleRank(minimumRole[action]) {
+		message := fmt.Sprintf("Only a group %s or the owner can do this", minimumRole[action])
+		if minimumRole[action] == models.RoleOwner {
+			message = "Only the group owner can do this"
+		}
+		http.Error(w, message, http.StatusForbidden)
+		return role, false
+	}
+	return role, true
+}
+
+// HandleParticipantRole makes a member of a group an admin or demotes an
+// admin back to member. Only the owner may change roles, except that an
+// admin may step down. Ownership itself moves only through
+// HandleTransferOwnership.
+func (h *Handlers) HandleParticipantRole(w http.ResponseWriter, r *http.Request) {
+	if !httpx.AllowMethods(w, r, http.MethodPost) {
+		return
+	}
+	user, ok := r.Context().Value(userContextKey).(*models.User)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.SetRoleRequest
+	if err := httpx.DecodeJSON(r, &req); err != nil {
+		httpx.WriteDecodeError(w, err)
+		return
+	}
+	if req.Role != models.RoleAdmin && req.Role != models.RoleMember {
+		http.Error(w, `Role must be "admin" or "member"`, http.StatusBadRequest)
+		return
+	}
+
+	conversation, err := h.db.GetConversationByID(r.Context(), req.ConversationID)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch conversation", http.StatusInternalServerError)
+		return
+	}
+	if conversation.Type != "group" {
+		http.Error(w, "Only group conversations have roles", http.StatusBadRequest)
+		return
+	}
+
+	// Anyone may drop their own admin role; the owner is refused below
+	steppingDown := req.UserID == user.ID && req.Role == models.RoleMember
+	if !steppingDown {
+		if _, ok := h.authorize(r.Context(), w, conversation.ID, user, actionChangeRole); !ok {
+			return
+		}
+	}
+
+	current, err := h.db.GetParticipantRole(r.Context(), conversation.ID, req.UserID)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "User is not a participant", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch role", http.StatusInternalServerError)
+		return
+	}
+	if current == models.RoleOwner {
+		http.Error(w, "The owner's role can't be changed", http.StatusBadRequest)
+		return
+	}
+
+	if current != req.Role {
+		if err := h.db.SetParticipantRole(r.Context(), conversation.ID, req.UserID, req.Role); err != nil {
+			if h.writeReadOnlyError(w, err) {
+				return
+			}
+			log.Printf("Failed to set role in conversation %d: %v", conversation.ID, err)
+			http.Error(w, "Failed to update role", http.StatusInternalServerError)
+			return
+		}
+		h.announceRoleChanged(r.Context(), conversation, user, req.UserID, req.Role)
+	}
+
+	httpx.WriteJSON(w, http.StatusOK, req)
+}
+
+// HandleTransferOwnership hands ownership of a group from the caller, who
+// must own it, to another member. The caller stays on as an admin.
+// Transferring to yourself changes nothing.
+func (h *Handlers) HandleTransferOwnership(w http.ResponseWriter, r *http.Request) {
+	if !httpx.AllowMethods(w, r, http.MethodPost) {
+		return
+	}
+	user, ok := r.Context().Value(userContextKey).(*models.User)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.TransferOwnershipRequest
+	if err := httpx.DecodeJSON(r, &req); err != nil {
+		httpx.WriteDecodeError(w, err)
+		return
+	}
+
+	conversation, err := h.db.GetConversationByID(r.Context(), req.ConversationID)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch conversation", http.StatusInternalServerError)
+		return
+	}
+	if conversation.Type != "group" {
+		http.Error(w, "Only group conversations have an owner", http.StatusBadRequest)
+		return
+	}
+	if _, ok := h.authorize(r.Context(), w, conversation.ID, user, actionTransferOwnership); !ok {
+		return
+	}
+	if req.NewOwnerID == user.ID {
+		httpx.WriteJSON(w, http.StatusOK, req)
+		return
+	}
+
+	err = h.db.TransferOwnership(r.Context(), conversation.ID, user.ID, req.NewOwnerID)
+	if errors.Is(err, db.ErrNotFound) {
+		// The caller was just checked, so it's the target who isn't a member
+		http.Error(w, "New owner must be a participant", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		if h.writeReadOnlyError(w, err) {
+			return
+		}
+		log.Printf("Failed to transfer ownership of conversation %d: %v", conversation.ID, err)
+		http.Error(w, "Failed to transfer ownership", http.StatusInternalServerError)
+		return
+	}
+
+	participants, err := h.db.GetConversationParticipantIDs(r.Context(), conversation.ID)
+	if err != nil {
+		log.Printf("Failed to get conversation participants: %v", err)
+	} else {
+		h.sendRoleChanged(conversation.ID, participants, user.ID, user.ID, models.RoleAdmin)
+		h.sendRoleChanged(conversation.ID, participants, user.ID, req.NewOwnerID, models.RoleOwner)
+	}
+
+	name := "a participant"
+	if target, err := h.db.GetUserByID(r.Context(), req.NewOwnerID); err == nil {
+		name = target.Username
+	}
+	h.postSystemEvent(r.Context(), conversation.ID, models.SystemEvent{
+		Event:     "ownership_transferred",
+		ActorID:   user.ID,
+		TargetIDs: []int64{req.NewOwnerID},
+		Role:      models.RoleOwner,
+		Text:      fmt.Sprintf("%s made %s the owner", user.Username, name),
+	})
+
+	httpx.WriteJSON(w, http.StatusOK, req)
+}
+
+// sendRoleChanged sends "participant_role_changed" for one member's new
+// role to participants
+func (h *Handlers) sendRoleChanged(conversationID int64, participants []int64, actorID, targetID int64, role string) {
+	h.hub.SendToConversation(conversationID, models.WebSocketMessage{
+		Type: "participant_role_changed",
+		Payload: map[string]interface{}{
+			"conversation_id": conversationID,
+			"user_id":         targetID,
+			"role":            role,
+			"changed_by":      actorID,
+		},
+	}, participants)
+}
+
+// announceRoleChanged tells every member about a role change and records
+// it in the history. actor is whoever caused it, including an owner whose
+// departure handed ownership on.
+func (h *Handlers) announceRoleChanged(ctx context.Context, conversation *models.Conversation, actor *models.User, targetID int64, role string) {
+	ctx = announceContext(ctx)
+	participants, err := h.db.GetConversationParticipantIDs(ctx, conversation.ID)
+	if err != nil {
+		log.Printf("Failed to get conversation participants: %v", err)
+		return
+	}
+
+	h.sendRoleChanged(conversation.ID, participants, actor.ID, targetID, role)
+
+	name := "a participant"
+	if target, err := h.db.GetUserByID(ctx, targetID); err == nil {
+		name = target.Username
+	}
+	text := fmt.Sprintf("%s made %s an %s", actor.Username, name, role)
+	switch {
+	case role == models.RoleOwner:
+		text = fmt.Sprintf("%s is now the owner", name)
+	case role == models.RoleMember && targetID == actor.ID:
+		text = fmt.Sprintf("%s is no longer an admin", name)
+	case role == models.RoleMember:
+		text = fmt.Sprintf("%s removed %s as an admin", actor.Username, name)
+	}
+	h.postSystemEvent(ctx, conversation.ID, models.SystemEvent{
+		Event:     "participant_role_changed",
+		ActorID:   actor.ID,
+		TargetIDs: []int64{targetID},
+		Role:      role,
+		Text:      text,
+	})
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"messager/internal/models"
+)
+
+func setRole(t *testing.T, env *testEnv, user *models.User, userID int64, role string) int {
+	t.Helper()
+	return call(t, env.h.HandleParticipantRole, user, http.MethodPost, "/api/conversations/participants/role",
+		models.SetRoleRequest{ConversationID: env.f.Group.ID, UserID: userID, Role: role}).Code
+}
+
+func TestChangeParticipantRole(t *testing.T) {
+	env := newTestEnv(t, nil)
+	ctx := context.Background()
+	group := env.f.Group.ID
+	roleOf := func(user *models.User) string {
+		t.Helper()
+		role, err := env.db.GetParticipantRole(ctx, group, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return role
+	}
+
+	if code := setRole(t, env, env.f.Alice, env.f.Bob.ID, models.RoleAdmin); code != http.StatusOK {
+		t.Fatalf("owner promoting: status %d", code)
+	}
+	if roleOf(env.f.Bob) != models.RoleAdmin {
+		t.Fatalf("bob is %s after being promoted", roleOf(env.f.Bob))
+	}
+
+	// An admin may rename and remove, but not hand out roles
+	if code := setRole(t, env, env.f.Bob, env.f.Carol.ID, models.RoleAdmin); code != http.StatusForbidden {
+		t.Errorf("admin promoting: status %d, want 403", code)
+	}
+	decode(t, call(t, env.h.HandleConversations, env.f.Bob, http.MethodPatch, "/api/conversations",
+		models.UpdateConversationRequest{ConversationID: group, Name: strPtr("Bob's")}), http.StatusOK, nil)
+	if code := call(t, env.h.HandleConversations, env.f.Bob, http.MethodDelete, "/api/conversations",
+		models.DeleteConversationRequest{ConversationID: group}).Code; code != http.StatusForbidden {
+		t.Errorf("admin deleting the group: status %d, want 403", code)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		target int64
+		role   string
+		want   int
+	}{
+		{"making a second owner", env.f.Carol.ID, models.RoleOwner, http.StatusBadRequest},
+		{"an unknown role", env.f.Carol.ID, "moderator", http.StatusBadRequest},
+		{"demoting the owner", env.f.Alice.ID, models.RoleMember, http.StatusBadRequest},
+		{"a non-member", 9999, models.RoleAdmin, http.StatusNotFound},
+	} {
+		if code := setRole(t, env, env.f.Alice, tc.target, tc.role); code != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, code, tc.want)
+		}
+	}
+	if roleOf(env.f.Carol) != models.RoleMember {
+		t.Errorf("carol is %s after the refused changes", roleOf(env.f.Carol))
+	}
+
+	// Anyone may step down
+	if code := setRole(t, env, env.f.Bob, env.f.Bob.ID, models.RoleMember); code != http.StatusOK || roleOf(env.f.Bob) != models.RoleMember {
+		t.Errorf("admin stepping down: status %d, role %s", code, roleOf(env.f.Bob))
+	}
+}
+
+func TestOwnershipPassesToAnAdminFirst(t *testing.T) {
+	env := newTestEnv(t, nil)
+	ctx := context.Background()
+	group := env.f.Group.ID
+
+	// Transferring makes the old owner an admin
+	decode(t, call(t, env.h.HandleTransferOwnership, env.f.Alice, http.MethodPost, "/api/conversations/transfer-ownership",
+		models.TransferOwnershipRequest{ConversationID: group, NewOwnerID: env.f.Carol.ID}), http.StatusOK, nil)
+	for user, want := range map[*models.User]string{env.f.Alice: models.RoleAdmin, env.f.Carol: models.RoleOwner} {
+		if role, _ := env.db.GetParticipantRole(ctx, group, user.ID); role != want {
+			t.Errorf("%s is %s after the transfer, want %s", user.Username, role, want)
+		}
+	}
+	if code := call(t, env.h.HandleTransferOwnership, env.f.Alice, http.MethodPost, "/api/conversations/transfer-ownership",
+		models.TransferOwnershipRequest{ConversationID: group, NewOwnerID: env.f.Bob.ID}).Code; code != http.StatusForbidden {
+		t.Errorf("former owner transferring: status %d, want 403", code)
+	}
+
+	// Bob joined as long ago as alice, but the admin is preferred
+	if code := removeParticipant(t, env, env.f.Carol, group, 0); code != http.StatusNoContent {
+		t.Fatalf("owner leaving: status %d", code)
+	}
+	if role, _ := env.db.GetParticipantRole(ctx, group, env.f.Alice.ID); role != models.RoleOwner {
+		t.Errorf("alice is %s after the owner left, want the admin to inherit", role)
+	}
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	gorilla "github.com/gorilla/websocket"
+	"messager/internal/config"
+	"messager/internal/models"
+)
+
+// resign copies a session token's claims into one signed with secret
+func resign(t *testing.T, token, secret string) string {
+	t.Helper()
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(token, claims); err != nil {
+		t.Fatal(err)
+	}
+	forged, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return forged
+}
+
+func TestSessionsUseTheConfiguredSecret(t *testing.T) {
+	env := newTestEnv(t, func(cfg *config.Config) {
+		cfg.JWTSecret = "configured-secret"
+		cfg.AllowEmptyOrigin = true
+	})
+	token := loginFrom(t, env, env.f.Alice, "phone-1", "Phone")
+	forged := resign(t, token, "your-secret-key")
+
+	if rec := withSession(t, env, env.h.HandleDevices, token, http.MethodGet, nil); rec.Code != http.StatusOK {
+		t.Errorf("token signed with JWT_SECRET: status %d, want 200", rec.Code)
+	}
+	if rec := withSession(t, env, env.h.HandleDevices, forged, http.MethodGet, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("token signed with another secret: status %d, want 401", rec.Code)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(env.h.HandleWebSocket))
+	t.Cleanup(srv.Close)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	if _, _, err := gorilla.DefaultDialer.Dial(url, http.Header{"Cookie": {"auth_token=" + forged}}); err == nil {
+		t.Error("token signed with another secret opened a socket")
+	}
+	conn, _, err := gorilla.DefaultDialer.Dial(url, http.Header{"Cookie": {"auth_token=" + token}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// refresh sends an auth_refresh and returns the answer to it
+	refresh := func(raw string) map[string]interface{} {
+		t.Helper()
+		if err := conn.WriteJSON(map[string]interface{}{"type": "auth_refresh", "payload": models.AuthRefresh{Token: raw}}); err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		for {
+			var frame struct {
+				Type    string                 `json:"type"`
+				Payload map[string]interface{} `json:"payload"`
+			}
+			if err := conn.ReadJSON(&frame); err != nil {
+				t.Fatal(err)
+			}
+			if frame.Type == "error" || frame.Type == "auth_refreshed" {
+				frame.Payload["type"] = frame.Type
+				return frame.Payload
+			}
+		}
+	}
+	if got := refresh(forged); got["type"] != "error" || got["code"] != "invalid_token" {
+		t.Errorf("refresh with another secret's token: %v, want invalid_token", got)
+	}
+	if got := refresh(token); got["type"] != "auth_refreshed" {
+		t.Errorf("refresh with a JWT_SECRET token: %v, want auth_refreshed", got)
+	}
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"unicode/utf8"
+
+	"messager/internal/db"
+	"messager/internal/httpx"
+	"messager/internal/models"
+)
+
+// Per-conversation notification settings are stored as a small JSON object
+// the server mostly treats as opaque. Known keys are validated; anything else
+// is kept for the client as long as the whole blob stays under the caps.
+const (
+	maxSettingsBytes       = 1024
+	maxSettingsKeys        = 32
+	maxSettingsLabelLength = 64
+)
+
+var (
+	settingsSoundPattern = regexp.MustCompile(`^[a-z0-9_.-]{1,64}$`)
+	settingsColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+)
+
+// validateSettings checks a settings blob and returns it compacted for
+// storage. A JSON null (or empty body) clears the settings and yields nil.
+func validateSettings(raw json.RawMessage) (json.RawMessage, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	if len(raw) > maxSettingsBytes {
+		return nil, fmt.Errorf("settings must be at most %d bytes", maxSettingsBytes)
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil || fields == nil {
+		return nil, fmt.Errorf("settings must be a JSON object")
+	}
+	if len(fields) > maxSettingsKeys {
+		return nil, fmt.Errorf("settings may have at most %d keys", maxSettingsKeys)
+	}
+
+	if value, ok := fields["label"]; ok {
+		var label string
+		if err := json.Unmarshal(value, &label); err != nil {
+			return nil, fmt.Errorf("label must be a string")
+		}
+		if utf8.RuneCountInString(label) > maxSettingsLabelLength {
+			return nil, fmt.Errorf("label must be at most %d characters", maxSettingsLabelLength)
+		}
+	}
+	if value, ok := fields["sound"]; ok {
+		var sound string
+		if err := json.Unmarshal(value, &sound); err != nil || !settingsSoundPattern.MatchString(sound) {
+			return nil, fmt.Errorf("sound must be an identifier of lowercase letters, digits, '.', '_' or '-'")
+		}
+	}
+	if value, ok := fields["color"]; ok {
+		var color string
+		if err := json.Unmarshal(value, &color); err != nil || !settingsColorPattern.MatchString(color) {
+			return nil, fmt.Errorf("color must be a hex color like #1e90ff")
+		}
+	}
+
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, raw); err != nil {
+		return nil, fmt.Errorf("settings must be a JSON object")
+	}
+	return compact.Bytes(), nil
+}
+
+// HandleConversationSettings reads (GET) or replaces (PUT) the caller's own
+// notification settings for a conversation
+func (h *Handlers) HandleConversationSettings(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(userContextKey).(*models.User)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		conversationID, err := strconv.ParseInt(r.URL.Query().Get("conversation_id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid conversation ID", http.StatusBadRequest)
+			return
+		}
+		settings, err := h.db.GetParticipantSettings(r.Context(), conversationID, user.ID)
+		if errors.Is(err, db.ErrNotFound) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to fetch settings", http.StatusInternalServerError)
+			return
+		}
+		httpx.WriteJSON(w, http.StatusOK, models.ConversationSettings{ConversationID: conversationID, Settings: settings})
+
+	case http.MethodPut:
+		var req models.ConversationSettings
+		if err := httpx.DecodeJSONLimit(r, &req, 2*maxSettingsBytes); err != nil {
+			httpx.WriteDecodeError(w, err)
+			return
+		}
+		settings, err := validateSettings(req.Settings)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = h.db.SetParticipantSettings(r.Context(), req.ConversationID, user.ID, settings)
+		if errors.Is(err, db.ErrNotFound) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			if h.writeReadOnlyError(w, err) {
+				return
+			}
+			log.Printf("Failed to update settings for conversation %d: %v", req.ConversationID, err)
+			http.Error(w, "Failed to update settings", http.StatusInternalServerError)
+			return
+		}
+
+		updated := models.ConversationSettings{ConversationID: req.ConversationID, Settings: settings}
+		// Keep the user's other devices in sync
+		h.hub.SendToUser(user.ID, models.WebSocketMessage{
+			Type:    "conversation_settings_updated",
+			Payload: updated,
+		})
+
+		httpx.WriteJSON(w, http.StatusOK, updated)
+
+	default:
+		httpx.AllowMethods(w, r, http.MethodGet, http.MethodPut)
+	}
+}
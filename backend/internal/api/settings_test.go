@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"messager/internal/models"
+)
+
+func TestValidateSettings(t *testing.T) {
+	for _, raw := range []string{
+		`[]`,
+		`"loud"`,
+		`{"label": 7}`,
+		`{"label": "` + strings.Repeat("x", maxSettingsLabelLength+1) + `"}`,
+		`{"sound": "Ding Dong"}`,
+		`{"color": "red"}`,
+		`{"note": "` + strings.Repeat("x", maxSettingsBytes) + `"}`,
+	} {
+		if _, err := validateSettings(json.RawMessage(raw)); err == nil {
+			t.Errorf("accepted %.60s", raw)
+		}
+	}
+
+	got, err := validateSettings(json.RawMessage(`{ "sound": "chime.v2", "color": "#1E90ff", "custom": [1, 2] }`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != `{"sound":"chime.v2","color":"#1E90ff","custom":[1,2]}` {
+		t.Errorf("stored %s, want it compacted with unknown keys kept", got)
+	}
+	if got, err := validateSettings(json.RawMessage(`null`)); got != nil || err != nil {
+		t.Errorf("null = %s, %v, want a clear", got, err)
+	}
+}
+
+func TestConversationSettingsRoundTrip(t *testing.T) {
+	env := newTestEnv(t, nil)
+	group := env.f.Group.ID
+	get := func() models.ConversationSettings {
+		var got models.ConversationSettings
+		decode(t, call(t, env.h.HandleConversationSettings, env.f.Bob, http.MethodGet,
+			fmt.Sprintf("/api/conversations/settings?conversation_id=%d", group), nil), http.StatusOK, &got)
+		return got
+	}
+
+	if got := get(); string(got.Settings) != "null" {
+		t.Errorf("settings before any were saved = %s, want null", got.Settings)
+	}
+	decode(t, call(t, env.h.HandleConversationSettings, env.f.Bob, http.MethodPut, "/api/conversations/settings",
+		`{"conversation_id": `+fmt.Sprint(group)+`, "settings": {"sound": "chime"}}`), http.StatusOK, nil)
+	if got := get(); string(got.Settings) != `{"sound":"chime"}` {
+		t.Errorf("settings = %s after saving", got.Settings)
+	}
+	// They're bob's alone
+	var alice models.ConversationSettings
+	decode(t, call(t, env.h.HandleConversationSettings, env.f.Alice, http.MethodGet,
+		fmt.Sprintf("/api/conversations/settings?conversation_id=%d", group), nil), http.StatusOK, &alice)
+	if string(alice.Settings) != "null" {
+		t.Errorf("alice sees bob's settings: %s", alice.Settings)
+	}
+
+	decode(t, call(t, env.h.HandleConversationSettings, env.f.Bob, http.MethodPut, "/api/conversations/settings",
+		`{"conversation_id": `+fmt.Sprint(group)+`, "settings": null}`), http.StatusOK, nil)
+	if got := get(); string(got.Settings) != "null" {
+		t.Errorf("settings = %s after clearing", got.Settings)
+	}
+
+	rec := call(t, env.h.HandleConversationSettings, env.f.Carol, http.MethodPut, "/api/conversations/settings",
+		`{"conversation_id": `+fmt.Sprint(env.f.Direct.ID)+`, "settings": {}}`)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("non-member saving settings: status %d, want 403", rec.Code)
+	}
+}
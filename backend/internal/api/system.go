@@ -0,0 +1,48 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"messager/internal/errsink"
+	"messager/internal/models"
+)
+
+// announceContext lets the database work of telling people about a change
+// finish after the client that made it has gone; the change itself is done
+func announceContext(ctx context.Context) context.Context {
+	return context.WithoutCancel(ctx)
+}
+
+// postSystemEvent records a system message in the conversation history and
+// fans it out to the participants like any other message. Failures are
+// logged only; the operation that triggered the event has already succeeded.
+func (h *Handlers) postSystemEvent(ctx context.Context, conversationID int64, event models.SystemEvent) {
+	ctx = announceContext(ctx)
+	content, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to marshal system event %s: %v", event.Event, err)
+		h.errs.Swallow(errsink.Marshal, "api.system_event", err)
+		return
+	}
+
+	message, err := h.db.CreateSystemMessage(ctx, conversationID, string(content))
+	if err != nil {
+		log.Printf("Failed to create system message for conversation %d: %v", conversationID, err)
+		h.errs.Swallow(errsink.Store, "api.system_event", err)
+		return
+	}
+
+	participants, err := h.db.GetConversationParticipantIDs(ctx, conversationID)
+	if err != nil {
+		log.Printf("Failed to get conversation participants: %v", err)
+		h.errs.Swallow(errsink.Store, "api.system_event", err)
+		return
+	}
+
+	if err := h.hub.DeliverMessage(message, participants); err != nil {
+		log.Printf("Failed to broadcast system event: %v", err)
+		h.errs.Swallow(errsink.FanOut, "api.system_event", err)
+	}
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"messager/internal/models"
+)
+
+func TestCreatingAGroupPostsASystemEvent(t *testing.T) {
+	env := newTestEnv(t, nil)
+	var conversation models.Conversation
+	decode(t, call(t, env.h.HandleCreateConversation, env.f.Alice, http.MethodPost, "/api/conversations", models.CreateConversationRequest{
+		Name:         "Launch",
+		Type:         "group",
+		Participants: []int64{env.f.Bob.ID, env.f.Carol.ID},
+	}), http.StatusOK, &conversation)
+
+	messages, err := env.db.GetConversationMessages(context.Background(), conversation.ID, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want the one system event", len(messages))
+	}
+	msg := messages[0]
+	if !msg.IsSystem() || msg.SenderID != 0 {
+		t.Fatalf("message_type %q from sender %d, want a system message without a sender", msg.MessageType, msg.SenderID)
+	}
+	var event models.SystemEvent
+	if err := json.Unmarshal([]byte(msg.Content), &event); err != nil {
+		t.Fatalf("content %q isn't a system event: %v", msg.Content, err)
+	}
+	if event.Event != "conversation_created" || event.ActorID != env.f.Alice.ID || event.Name != "Launch" {
+		t.Errorf("event = %+v", event)
+	}
+}
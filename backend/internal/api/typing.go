@@ -0,0 +1,45 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"messager/internal/httpx"
+	"messager/internal/models"
+)
+
+// HandleTyping lists who is typing in a conversation right now, so a client
+// opening it can show indicators whose events it missed
+func (h *Handlers) HandleTyping(w http.ResponseWriter, r *http.Request) {
+	if !httpx.AllowMethods(w, r, http.MethodGet) {
+		return
+	}
+	user, ok := r.Context().Value(userContextKey).(*models.User)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	conversationID, err := strconv.ParseInt(r.URL.Query().Get("conversation_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid conversation ID", http.StatusBadRequest)
+		return
+	}
+
+	isParticipant, err := h.db.IsConversationParticipant(r.Context(), conversationID, user.ID)
+	if err != nil {
+		log.Printf("Failed to check membership: %v", err)
+		http.Error(w, "Failed to check membership", http.StatusInternalServerError)
+		return
+	}
+	if !isParticipant {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	httpx.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"conversation_id": conversationID,
+		"typing":          h.hub.TypingUsers(conversationID),
+	})
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"messager/internal/config"
+	"messager/internal/models"
+)
+
+func TestValidateConversationMatchesCreate(t *testing.T) {
+	env := newTestEnv(t, func(cfg *config.Config) {
+		cfg.MaxGroupParticipants = 3
+	})
+	alice, bob, carol := env.f.Alice.ID, env.f.Bob.ID, env.f.Carol.ID
+
+	for _, tc := range []struct {
+		name  string
+		req   models.CreateConversationRequest
+		codes []string
+	}{
+		{"bad type", models.CreateConversationRequest{Type: "channel"}, []string{"invalid_type"}},
+		{"direct with yourself", models.CreateConversationRequest{Type: "direct", Participants: []int64{alice}}, []string{"self_conversation"}},
+		{"direct with two", models.CreateConversationRequest{Type: "direct", Participants: []int64{bob, carol}}, []string{"direct_needs_one_participant"}},
+		{"blank group name", models.CreateConversationRequest{Type: "group", Name: "  ", Participants: []int64{bob}}, []string{"invalid_name"}},
+		{"unknown user", models.CreateConversationRequest{Type: "group", Name: "Ops", Participants: []int64{bob, 9999}}, []string{"unknown_users"}},
+		{"too many", models.CreateConversationRequest{Type: "group", Name: "Ops", Participants: []int64{bob, carol, 9999}}, []string{"too_many_participants"}},
+		{"several problems", models.CreateConversationRequest{Type: "group", Participants: []int64{9999}}, []string{"invalid_name", "unknown_users"}},
+	} {
+		var validated, created models.ValidationErrorResponse
+		decode(t, call(t, env.h.HandleValidateConversation, env.f.Alice, http.MethodPost, "/api/conversations/validate", tc.req), http.StatusBadRequest, &validated)
+		decode(t, call(t, env.h.HandleCreateConversation, env.f.Alice, http.MethodPost, "/api/conversations/create", tc.req), http.StatusBadRequest, &created)
+
+		var codes []string
+		for _, e := range validated.Errors {
+			codes = append(codes, e.Code)
+		}
+		if !reflect.DeepEqual(codes, tc.codes) {
+			t.Errorf("%s: codes %v, want %v", tc.name, codes, tc.codes)
+		}
+		if !reflect.DeepEqual(validated, created) {
+			t.Errorf("%s: validate said %+v but create said %+v", tc.name, validated, created)
+		}
+		if tc.name == "unknown user" && !reflect.DeepEqual(validated.Errors[0].UserIDs, []int64{9999}) {
+			t.Errorf("unknown user IDs = %v, want [9999]", validated.Errors[0].UserIDs)
+		}
+	}
+
+	var ok struct {
+		Valid bool `json:"valid"`
+	}
+	decode(t, call(t, env.h.HandleValidateConversation, env.f.Alice, http.MethodPost, "/api/conversations/validate",
+		models.CreateConversationRequest{Type: "group", Name: "Ops", Participants: []int64{bob, bob, alice}}), http.StatusOK, &ok)
+	if !ok.Valid {
+		t.Error("a valid draft wasn't reported valid")
+	}
+	conversations, err := env.db.GetUserConversations(context.Background(), alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conversations) != 2 {
+		t.Errorf("validating created something: alice has %d conversations", len(conversations))
+	}
+}
+
+func TestCreateDirectListingYourself(t *testing.T) {
+	env := newTestEnv(t, nil)
+
+	// Naming yourself alongside the other person is harmless
+	var conv models.Conversation
+	decode(t, call(t, env.h.HandleCreateConversation, env.f.Alice, http.MethodPost, "/api/conversations/create",
+		models.CreateConversationRequest{Type: "direct", Participants: []int64{env.f.Alice.ID, env.f.Carol.ID}}), http.StatusOK, &conv)
+	if conv.Type != "direct" || conv.Name != "carol" {
+		t.Errorf("created %s %q, want a direct conversation named carol", conv.Type, conv.Name)
+	}
+	participants, err := env.db.GetConversationParticipantIDs(context.Background(), conv.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(participants) != 2 {
+		t.Errorf("participants = %v, want alice and carol", participants)
+	}
+
+	// Asking again, from either side, returns the same one
+	var again models.Conversation
+	decode(t, call(t, env.h.HandleCreateConversation, env.f.Carol, http.MethodPost, "/api/conversations/create",
+		models.CreateConversationRequest{Type: "direct", Participants: []int64{env.f.Alice.ID}}), http.StatusOK, &again)
+	if again.ID != conv.ID || again.Name != "alice" {
+		t.Errorf("carol got %d %q, want %d named alice", again.ID, again.Name, conv.ID)
+	}
+}
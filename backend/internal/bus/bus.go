@@ -0,0 +1,52 @@
+// Package bus carries realtime events between server instances so that
+// users connected to different replicas still see each other's messages.
+// Each instance delivers its own events to its local connections directly
+// and publishes them to the bus for the others; an instance never receives
+// back what it published.
+package bus
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// Handler receives an event published by another instance
+type Handler func(conversationID int64, payload []byte)
+
+// Bus fans events out to the other server instances
+type Bus interface {
+	// Publish forwards payload, a JSON document, to the other instances.
+	// Events published by one instance arrive at the others in the order
+	// they were published. It must not block on the network.
+	Publish(conversationID int64, payload []byte) error
+	// Subscribe registers the handler for events from other instances. It
+	// must be called before the first Publish.
+	Subscribe(handler Handler)
+	Close() error
+}
+
+// Local is the bus for a single instance: every connection is local, so
+// there is nothing to forward
+type Local struct{}
+
+func (Local) Publish(int64, []byte) error { return nil }
+func (Local) Subscribe(Handler)           {}
+func (Local) Close() error                { return nil }
+
+// Open returns the bus for rawURL: Local when it is empty, otherwise a
+// Redis bus for a redis://host:port URL publishing on channel
+func Open(rawURL, channel string) (Bus, error) {
+	if rawURL == "" {
+		return Local{}, nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bus URL: %v", err)
+	}
+	switch u.Scheme {
+	case "redis":
+		return NewRedis(u, channel)
+	default:
+		return nil, fmt.Errorf("unsupported bus URL scheme %q", u.Scheme)
+	}
+}
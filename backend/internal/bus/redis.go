@@ -0,0 +1,431 @@
+package bus
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	redisDialTimeout  = 5 * time.Second
+	redisWriteTimeout = 5 * time.Second
+	redisMaxBackoff   = 30 * time.Second
+
+	// redisOutboxSize is how many events may wait for the broker before
+	// Publish starts dropping them
+	redisOutboxSize = 1024
+
+	// redisMaxBulk bounds a single reply so a confused peer can't make us
+	// allocate without limit
+	redisMaxBulk = 16 << 20
+)
+
+// envelope is what travels over the Redis channel. Origin identifies the
+// publishing instance so it can skip its own events.
+type envelope struct {
+	Origin         string          `json:"origin"`
+	ConversationID int64           `json:"conversation_id"`
+	Payload        json.RawMessage `json:"payload"`
+}
+
+// Redis is a bus over a single Redis pub/sub channel. It speaks just enough
+// RESP for AUTH, PUBLISH and SUBSCRIBE. Publishes are queued and written
+// by one goroutine over one connection, which keeps each instance's events
+// in order; both connections are re-established with exponential backoff.
+// Events published while the broker is unreachable are lost once the
+// outbox fills, and events from others are missed until the subscription
+// is back, so clients should sync after reconnecting.
+type Redis struct {
+	addr     string
+	username string
+	password string
+	channel  string
+	origin   string
+	logger   *log.Logger
+
+	outbox    chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+	loops     sync.WaitGroup
+
+	handler Handler
+
+	mu    sync.Mutex
+	conns map[net.Conn]bool
+}
+
+// NewRedis creates a bus for a redis://[user:password@]host:port URL and
+// starts its publisher. Connections are made in the background.
+func NewRedis(u *url.URL, channel string) (*Redis, error) {
+	if channel == "" {
+		return nil, errors.New("bus channel must not be empty")
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+
+	origin := make([]byte, 8)
+	if _, err := rand.Read(origin); err != nil {
+		return nil, fmt.Errorf("failed to generate instance id: %v", err)
+	}
+
+	r := &Redis{
+		addr:    addr,
+		channel: channel,
+		origin:  hex.EncodeToString(origin),
+		logger:  log.New(os.Stdout, "[BUS] ", log.LstdFlags|log.Lshortfile),
+		outbox:  make(chan []byte, redisOutboxSize),
+		done:    make(chan struct{}),
+		conns:   make(map[net.Conn]bool),
+	}
+	if u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			r.username, r.password = u.User.Username(), password
+		}
+	}
+
+	r.loops.Add(1)
+	go r.publishLoop()
+	return r, nil
+}
+
+// Publish queues the event for the publisher. payload must be a JSON
+// document. It returns an error without blocking when the outbox is full.
+func (r *Redis) Publish(conversationID int64, payload []byte) error {
+	data, err := json.Marshal(envelope{Origin: r.origin, ConversationID: conversationID, Payload: payload})
+	if err != nil {
+		return fmt.Errorf("failed to encode bus event: %v", err)
+	}
+	select {
+	case <-r.done:
+		return errors.New("bus is closed")
+	default:
+	}
+	select {
+	case r.outbox <- data:
+		return nil
+	default:
+		return errors.New("bus outbox full")
+	}
+}
+
+// Subscribe starts the subscriber. Events are handed to handler one at a
+// time, in the order the broker delivers them.
+func (r *Redis) Subscribe(handler Handler) {
+	r.handler = handler
+	r.loops.Add(1)
+	go r.subscribeLoop()
+}
+
+// Close stops both loops and closes their connections. Queued events that
+// haven't been written are discarded.
+func (r *Redis) Close() error {
+	r.closeOnce.Do(func() {
+		close(r.done)
+		r.mu.Lock()
+		for conn := range r.conns {
+			conn.Close()
+		}
+		r.mu.Unlock()
+	})
+	r.loops.Wait()
+	return nil
+}
+
+func (r *Redis) publishLoop() {
+	defer r.loops.Done()
+
+	var conn net.Conn
+	var backoff time.Duration
+	defer func() {
+		if conn != nil {
+			r.release(conn)
+		}
+	}()
+
+	for {
+		var data []byte
+		select {
+		case <-r.done:
+			return
+		case data = <-r.outbox:
+		}
+
+		// Retry the same event until it's written so later ones can't
+		// overtake it
+		for {
+			if conn == nil {
+				c, reader, err := r.dial()
+				if err != nil {
+					backoff = nextBackoff(backoff)
+					r.logger.Printf("Publish connection failed, retrying in %s: %v", backoff, err)
+					if !r.sleep(backoff) {
+						return
+					}
+					continue
+				}
+				conn, backoff = c, 0
+				go r.discardReplies(conn, reader)
+			}
+
+			conn.SetWriteDeadline(time.Now().Add(redisWriteTimeout))
+			if _, err := conn.Write(command("PUBLISH", r.channel, string(data))); err != nil {
+				r.logger.Printf("Publish failed, reconnecting: %v", err)
+				r.release(conn)
+				conn = nil
+				continue
+			}
+			break
+		}
+	}
+}
+
+// discardReplies reads the broker's answers to PUBLISH, logging errors and
+// closing the connection once it fails so the next write notices
+func (r *Redis) discardReplies(conn net.Conn, reader *bufio.Reader) {
+	for {
+		reply, err := readReply(reader)
+		if err != nil {
+			conn.Close()
+			return
+		}
+		if rerr, ok := reply.(redisError); ok {
+			r.logger.Printf("Publish rejected: %v", rerr)
+		}
+	}
+}
+
+func (r *Redis) subscribeLoop() {
+	defer r.loops.Done()
+
+	var backoff time.Duration
+	for {
+		subscribed, err := r.subscribeOnce()
+		select {
+		case <-r.done:
+			return
+		default:
+		}
+		if subscribed {
+			backoff = 0
+		}
+		backoff = nextBackoff(backoff)
+		r.logger.Printf("Subscription to %s lost, retrying in %s: %v", r.channel, backoff, err)
+		if !r.sleep(backoff) {
+			return
+		}
+	}
+}
+
+// subscribeOnce holds one subscription until its connection fails,
+// reporting whether the broker confirmed it
+func (r *Redis) subscribeOnce() (bool, error) {
+	conn, reader, err := r.dial()
+	if err != nil {
+		return false, err
+	}
+	defer r.release(conn)
+
+	conn.SetWriteDeadline(time.Now().Add(redisWriteTimeout))
+	if _, err := conn.Write(command("SUBSCRIBE", r.channel)); err != nil {
+		return false, err
+	}
+
+	subscribed := false
+	for {
+		reply, err := readReply(reader)
+		if err != nil {
+			return subscribed, err
+		}
+		if rerr, ok := reply.(redisError); ok {
+			return subscribed, rerr
+		}
+		parts, ok := reply.([]interface{})
+		if !ok || len(parts) != 3 {
+			continue
+		}
+		switch kind, _ := parts[0].(string); kind {
+		case "subscribe":
+			if !subscribed {
+				r.logger.Printf("Subscribed to %s", r.channel)
+			}
+			subscribed = true
+		case "message":
+			if data, ok := parts[2].(string); ok {
+				r.receive([]byte(data))
+			}
+		}
+	}
+}
+
+// receive hands an event from another instance to the handler
+func (r *Redis) receive(data []byte) {
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		r.logger.Printf("Ignoring malformed bus event: %v", err)
+		return
+	}
+	if env.Origin == r.origin {
+		return
+	}
+	r.handler(env.ConversationID, env.Payload)
+}
+
+// dial connects and authenticates. The connection is tracked so Close can
+// interrupt a blocked read.
+func (r *Redis) dial() (net.Conn, *bufio.Reader, error) {
+	conn, err := net.DialTimeout("tcp", r.addr, redisDialTimeout)
+	if err != nil {
+		return nil, nil, fmt.Errorf("connect failed: %v", err)
+	}
+	reader := bufio.NewReader(conn)
+
+	if r.password != "" {
+		args := []string{"AUTH", r.password}
+		if r.username != "" {
+			args = []string{"AUTH", r.username, r.password}
+		}
+		conn.SetDeadline(time.Now().Add(redisDialTimeout))
+		if _, err := conn.Write(command(args...)); err != nil {
+			conn.Close()
+			return nil, nil, fmt.Errorf("auth failed: %v", err)
+		}
+		reply, err := readReply(reader)
+		if err == nil {
+			if rerr, ok := reply.(redisError); ok {
+				err = rerr
+			}
+		}
+		if err != nil {
+			conn.Close()
+			return nil, nil, fmt.Errorf("auth failed: %v", err)
+		}
+		conn.SetDeadline(time.Time{})
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	select {
+	case <-r.done:
+		conn.Close()
+		return nil, nil, errors.New("bus is closed")
+	default:
+	}
+	r.conns[conn] = true
+	return conn, reader, nil
+}
+
+// release closes a connection made by dial
+func (r *Redis) release(conn net.Conn) {
+	r.mu.Lock()
+	delete(r.conns, conn)
+	r.mu.Unlock()
+	conn.Close()
+}
+
+// sleep waits for d, reporting false if the bus was closed first
+func (r *Redis) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-r.done:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func nextBackoff(backoff time.Duration) time.Duration {
+	if backoff == 0 {
+		return time.Second
+	}
+	backoff *= 2
+	if backoff > redisMaxBackoff {
+		backoff = redisMaxBackoff
+	}
+	return backoff
+}
+
+// command encodes a request as a RESP array of bulk strings
+func command(args ...string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return []byte(b.String())
+}
+
+// redisError is an error reply from the broker
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// readReply reads one RESP value: a string for simple and bulk strings,
+// int64 for integers, redisError for errors, []interface{} for arrays and
+// nil for null values
+func readReply(reader *bufio.Reader) (interface{}, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return redisError(line[1:]), nil
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad integer reply %q", line)
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n > redisMaxBulk {
+			return nil, fmt.Errorf("bad bulk length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n > redisMaxBulk {
+			return nil, fmt.Errorf("bad array length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readReply(reader); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unexpected reply %q", line)
+}
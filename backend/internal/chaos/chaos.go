@@ -0,0 +1,190 @@
+// Package chaos injects faults for resilience testing: latency and errors on
+// named database statements, failed websocket writes and a hub whose client
+// queues look full. It is inert unless the server starts with
+// CHAOS_ENABLED=true, and a nil *Injector is a valid no-op so call sites need
+// no checks.
+package chaos
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrInjected is returned by every injected failure
+var ErrInjected = errors.New("chaos: injected fault")
+
+// AnyStatement in Profile.DB applies a fault to every named statement
+// without an entry of its own
+const AnyStatement = "*"
+
+// maxLatency keeps a typo in a profile from wedging requests indefinitely
+const maxLatency = 30 * time.Second
+
+// DBFault delays a statement by LatencyMS, then fails it with Probability
+type DBFault struct {
+	Probability float64 `json:"probability"`
+	LatencyMS   int     `json:"latency_ms"`
+}
+
+// Profile is the full fault configuration; the zero value injects nothing
+type Profile struct {
+	DB map[string]DBFault `json:"db,omitempty"` // keyed by statement name
+
+	// WSWriteErrorRate is the fraction of websocket frames whose write
+	// fails, which closes the connection like a real network error
+	WSWriteErrorRate float64 `json:"ws_write_error_rate"`
+
+	// HubSaturationRate is the fraction of hub sends that find the
+	// client's queue full
+	HubSaturationRate float64 `json:"hub_saturation_rate"`
+}
+
+// Validate rejects rates outside [0, 1] and excessive latency
+func (p Profile) Validate() error {
+	for name, fault := range p.DB {
+		if err := checkRate(fault.Probability); err != nil {
+			return fmt.Errorf("db %q: %w", name, err)
+		}
+		if fault.LatencyMS < 0 || time.Duration(fault.LatencyMS)*time.Millisecond > maxLatency {
+			return fmt.Errorf("db %q: latency_ms must be between 0 and %d", name, maxLatency.Milliseconds())
+		}
+	}
+	if err := checkRate(p.WSWriteErrorRate); err != nil {
+		return fmt.Errorf("ws_write_error_rate: %w", err)
+	}
+	if err := checkRate(p.HubSaturationRate); err != nil {
+		return fmt.Errorf("hub_saturation_rate: %w", err)
+	}
+	return nil
+}
+
+func checkRate(rate float64) error {
+	if rate < 0 || rate > 1 {
+		return errors.New("must be between 0 and 1")
+	}
+	return nil
+}
+
+// Stats counts injected faults since the injector was created
+type Stats struct {
+	DBErrors       int64 `json:"db_errors"`
+	DBDelays       int64 `json:"db_delays"`
+	WSWriteErrors  int64 `json:"ws_write_errors"`
+	HubSaturations int64 `json:"hub_saturations"`
+}
+
+// Injector holds the active profile, which can be swapped at runtime
+type Injector struct {
+	mu      sync.RWMutex
+	profile Profile
+
+	dbErrors       atomic.Int64
+	dbDelays       atomic.Int64
+	wsWriteErrors  atomic.Int64
+	hubSaturations atomic.Int64
+}
+
+func New() *Injector {
+	return &Injector{}
+}
+
+// SetProfile replaces the active profile
+func (i *Injector) SetProfile(p Profile) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	i.mu.Lock()
+	i.profile = p
+	i.mu.Unlock()
+	return nil
+}
+
+// Profile returns the active profile
+func (i *Injector) Profile() Profile {
+	if i == nil {
+		return Profile{}
+	}
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.profile
+}
+
+// Stats returns the injection counters
+func (i *Injector) Stats() Stats {
+	if i == nil {
+		return Stats{}
+	}
+	return Stats{
+		DBErrors:       i.dbErrors.Load(),
+		DBDelays:       i.dbDelays.Load(),
+		WSWriteErrors:  i.wsWriteErrors.Load(),
+		HubSaturations: i.hubSaturations.Load(),
+	}
+}
+
+// DB applies the fault configured for a statement: it sleeps for the
+// configured latency, then returns ErrInjected with the configured
+// probability
+func (i *Injector) DB(statement string) error {
+	if i == nil {
+		return nil
+	}
+	i.mu.RLock()
+	fault, ok := i.profile.DB[statement]
+	if !ok {
+		fault, ok = i.profile.DB[AnyStatement]
+	}
+	i.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	if fault.LatencyMS > 0 {
+		i.dbDelays.Add(1)
+		time.Sleep(time.Duration(fault.LatencyMS) * time.Millisecond)
+	}
+	if roll(fault.Probability) {
+		i.dbErrors.Add(1)
+		return fmt.Errorf("%s: %w", statement, ErrInjected)
+	}
+	return nil
+}
+
+// FailWSWrite reports whether the next websocket frame write should fail
+func (i *Injector) FailWSWrite() bool {
+	if i == nil {
+		return false
+	}
+	i.mu.RLock()
+	rate := i.profile.WSWriteErrorRate
+	i.mu.RUnlock()
+	if roll(rate) {
+		i.wsWriteErrors.Add(1)
+		return true
+	}
+	return false
+}
+
+// SaturateHub reports whether a hub send should behave as if the client's
+// queue were full
+func (i *Injector) SaturateHub() bool {
+	if i == nil {
+		return false
+	}
+	i.mu.RLock()
+	rate := i.profile.HubSaturationRate
+	i.mu.RUnlock()
+	if roll(rate) {
+		i.hubSaturations.Add(1)
+		return true
+	}
+	return false
+}
+
+func roll(probability float64) bool {
+	return probability > 0 && rand.Float64() < probability
+}
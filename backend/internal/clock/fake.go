@@ -0,0 +1,102 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Fake is a Clock that only moves when Advance is called. Its tickers fire
+// as Advance passes each of their ticks, dropping ticks nobody received
+// like time.Ticker does.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+	changed chan struct{} // closed and replaced whenever tickers changes
+}
+
+// NewFake returns a Fake reading start
+func NewFake(start time.Time) *Fake {
+	return &Fake{now: start, changed: make(chan struct{})}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t := &fakeTicker{f: f, c: make(chan time.Time, 1), every: d, next: f.now.Add(d)}
+	f.tickers = append(f.tickers, t)
+	f.notify()
+	return t
+}
+
+// Advance moves the clock forward by d, firing every tick it passes
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+	for _, t := range f.tickers {
+		for !t.next.After(f.now) {
+			select {
+			case t.c <- t.next:
+			default:
+			}
+			t.next = t.next.Add(t.every)
+		}
+	}
+}
+
+// WaitForTickers blocks until at least n tickers are running, so a test
+// doesn't advance the clock before the code under test has started its
+// tickers. It gives up and returns false after timeout, in real time.
+func (f *Fake) WaitForTickers(n int, timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	for {
+		f.mu.Lock()
+		running, changed := len(f.tickers), f.changed
+		f.mu.Unlock()
+		if running >= n {
+			return true
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+// notify wakes WaitForTickers. The caller holds f.mu.
+func (f *Fake) notify() {
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+type fakeTicker struct {
+	f     *Fake
+	c     chan time.Time
+	every time.Duration
+	next  time.Time
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.c }
+
+func (t *fakeTicker) Stop() {
+	t.f.mu.Lock()
+	defer t.f.mu.Unlock()
+	for i, other := range t.f.tickers {
+		if other == t {
+			t.f.tickers = append(t.f.tickers[:i], t.f.tickers[i+1:]...)
+			t.f.notify()
+			return
+		}
+	}
+}
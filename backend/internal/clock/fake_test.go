@@ -0,0 +1,62 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeTickerFiresAsTimeAdvances(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := NewFake(start)
+	ticker := f.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	f.Advance(9 * time.Second)
+	select {
+	case <-ticker.C():
+		t.Fatal("ticked before its interval")
+	default:
+	}
+
+	f.Advance(time.Second)
+	select {
+	case at := <-ticker.C():
+		if want := start.Add(10 * time.Second); !at.Equal(want) {
+			t.Errorf("tick at %s, want %s", at, want)
+		}
+	default:
+		t.Fatal("didn't tick at its interval")
+	}
+
+	// Like time.Ticker, ticks nobody received are dropped, not queued
+	f.Advance(35 * time.Second)
+	<-ticker.C()
+	select {
+	case <-ticker.C():
+		t.Fatal("queued more than one missed tick")
+	default:
+	}
+	if got, want := f.Now(), start.Add(45*time.Second); !got.Equal(want) {
+		t.Errorf("Now() = %s, want %s", got, want)
+	}
+}
+
+func TestFakeWaitForTickers(t *testing.T) {
+	f := NewFake(time.Now())
+	if f.WaitForTickers(1, 10*time.Millisecond) {
+		t.Fatal("WaitForTickers reported a ticker before one started")
+	}
+	go f.NewTicker(time.Second)
+	if !f.WaitForTickers(1, time.Second) {
+		t.Fatal("WaitForTickers missed a ticker")
+	}
+
+	ticker := f.NewTicker(time.Second)
+	ticker.Stop()
+	f.Advance(time.Minute)
+	select {
+	case <-ticker.C():
+		t.Fatal("a stopped ticker ticked")
+	default:
+	}
+}
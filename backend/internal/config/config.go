@@ -1,15 +1,230 @@
 package config
 
 import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
+
+	"messager/internal/delivery"
 )
 
 type Config struct {
 	ServerAddress string
 	DatabaseURL   string
 	JWTSecret     string
+
+	// ReadDatabaseURLs, when set, serve heavy read endpoints from separate
+	// read-only connections, taking turns; unset means everything uses
+	// DatabaseURL. READ_DATABASE_URL, from when there could be only one,
+	// is added to DATABASE_READ_URLS.
+	ReadDatabaseURLs []string
+
+	// WSHeartbeatInterval is how often the hub sends application-level
+	// heartbeat events on an otherwise idle connection; zero disables them
+	WSHeartbeatInterval time.Duration
+
+	// WSStaleAfter is how long a connection may go without sending
+	// anything, pongs to the heartbeat pings included, before the hub
+	// closes it; zero never does
+	WSStaleAfter time.Duration
+
+	// WSMaxFrameBytes is the largest websocket frame a client may send;
+	// bigger frames close the connection with CloseMessageTooBig
+	WSMaxFrameBytes int
+
+	// ShutdownTimeout bounds how long SIGTERM handling waits for in-flight
+	// requests and websocket send queues to drain
+	ShutdownTimeout time.Duration
+
+	// Per-user flood control on message sends, shared by HTTP and websocket
+	MessageRatePerSec float64
+	MessageRateBurst  int
+
+	// Per-connection budgets for incoming websocket frames: typing events
+	// get their own, every other frame shares WSFrameRate. A connection
+	// over budget WSMaxRateViolations times, each within a minute of the
+	// last, is closed.
+	WSFrameRatePerSec   float64
+	WSFrameBurst        int
+	WSTypingRatePerSec  float64
+	WSTypingBurst       int
+	WSMaxRateViolations int
+
+	// WSSendBuffer is each connection's outgoing queue size in frames, and
+	// WSSlowClientPolicy what happens when it's full: "disconnect" closes
+	// the connection, "drop-oldest" discards the oldest queued frame
+	WSSendBuffer       int
+	WSSlowClientPolicy string
+
+	// WSDuplicateSessionPolicy decides what a second connection for the
+	// same user does: "allow" keeps both, "replace" closes the older ones,
+	// "deny" refuses the new one
+	WSDuplicateSessionPolicy string
+
+	// The last WSReplayEvents events sent to each user are kept for
+	// clients resuming after a reconnect, until the user has been offline
+	// for WSReplayTTL; 0 events turns event IDs and replay off
+	WSReplayEvents int
+	WSReplayTTL    time.Duration
+
+	// Messages sent over websockets are saved by WSPersistWorkers workers,
+	// each taking one share of the conversations and queueing up to
+	// WSPersistQueue of them
+	WSPersistWorkers int
+	WSPersistQueue   int
+
+	// Flood control on messages posted by bots, which replaces the per-user
+	// limit for bot accounts
+	BotRatePerSec float64
+	BotRateBurst  int
+
+	// MessageDedupeWindow suppresses byte-identical resends from the same
+	// sender to the same conversation; zero disables suppression
+	MessageDedupeWindow time.Duration
+
+	// DBRecoveryProbeInterval is how often a database stuck in read-only
+	// mode retries a write to detect recovery
+	DBRecoveryProbeInterval time.Duration
+
+	// DBStatementTimeout bounds how long one database call may run, on top
+	// of any deadline the caller sets; zero disables it
+	DBStatementTimeout time.Duration
+
+	// DBStatsLogInterval is how often database stats are logged; zero
+	// disables logging
+	DBStatsLogInterval time.Duration
+
+	// DBSlowQueryThreshold is how long a statement may run before it is
+	// logged; zero logs none
+	DBSlowQueryThreshold time.Duration
+
+	// DBMembershipCacheTTL is how long each conversation's participants are
+	// cached for membership checks; zero disables the cache
+	DBMembershipCacheTTL time.Duration
+
+	// DBMaxOpenConns, DBMaxIdleConns and DBConnMaxLifetime size each
+	// database connection pool; zero open connections or lifetime means no
+	// limit
+	DBMaxOpenConns    int
+	DBMaxIdleConns    int
+	DBConnMaxLifetime time.Duration
+
+	// MessageEncryptionKey encrypts message content at rest: 32 bytes,
+	// base64. Empty stores new messages in plaintext. Content sealed with
+	// one of MessageEncryptionPreviousKeys can still be read, until
+	// cmd/reencrypt has moved it to the current key.
+	MessageEncryptionKey          string
+	MessageEncryptionPreviousKeys []string
+
+	// NATSURL enables mirroring opted-in conversations to a NATS server;
+	// MirrorTopic is the subject pattern, with {conversation_id} substituted
+	NATSURL     string
+	MirrorTopic string
+
+	// BusURL connects server replicas so websocket events reach users on
+	// any of them; empty runs a single instance. BusChannel is the pub/sub
+	// channel every replica shares.
+	BusURL     string
+	BusChannel string
+
+	// Default per-destination limits for outbound deliveries
+	DeliveryRatePerSec    float64
+	DeliveryBurst         int
+	DeliveryMaxConcurrent int
+	DeliveryMaxBacklog    int
+
+	// AdminUsernames may use the /api/admin endpoints
+	AdminUsernames []string
+
+	// AllowedOrigins are the browser origins accepted by CORS and the
+	// websocket upgrade; "*" accepts any. AllowEmptyOrigin lets clients
+	// that send no Origin header at all, such as native apps, open a
+	// websocket.
+	AllowedOrigins   []string
+	AllowEmptyOrigin bool
+
+	// StorageDir holds one subdirectory per storage root (avatars,
+	// attachments, exports, backups); StorageQuotaBytes caps each root, zero
+	// for unlimited
+	StorageDir        string
+	StorageQuotaBytes int64
+
+	// Warmup prepares statements, opens WarmupConnections pooled
+	// connections and caches participants of the WarmupConversations most
+	// recently active conversations at startup. With WarmupHoldReadiness the
+	// listener opens immediately but /readyz reports 503 until warmup is
+	// done; otherwise warmup finishes before the listener opens.
+	Warmup              bool
+	WarmupConversations int
+	WarmupConnections   int
+	WarmupHoldReadiness bool
+
+	// ChaosEnabled turns on the fault injector and its admin control
+	// endpoint. Never enable it in production.
+	ChaosEnabled bool
+
+	// DevStrict surfaces errors the server would otherwise log and swallow
+	// as "error" events to the affected client, and exposes them on a debug
+	// endpoint. DevStrictPanic also panics on failures that can only be
+	// bugs. Both are for development.
+	DevStrict      bool
+	DevStrictPanic bool
+
+	// LogMessageContent lets message bodies and client payloads reach the
+	// logs; off by default they appear only as a length and hash
+	LogMessageContent bool
+
+	// LogLevel is "info" or "debug"; at debug the startup banner spells out
+	// the lists Summary only counts
+	LogLevel string
+
+	// MaxPinnedConversations caps how many conversations each user may pin
+	MaxPinnedConversations int
+
+	// MaxGroupParticipants caps the size of a group, creator included
+	MaxGroupParticipants int
+
+	// Messages older than their conversation's retention are deleted every
+	// RetentionSweepInterval, at most RetentionBatchSize per transaction
+	// with RetentionBatchPause between them
+	RetentionSweepInterval time.Duration
+	RetentionBatchSize     int
+	RetentionBatchPause    time.Duration
+	// MessageRetentionDays is the retention of conversations without their
+	// own; 0 keeps their messages forever
+	MessageRetentionDays int
+	// RetentionDryRun logs what each sweep would delete instead
+	RetentionDryRun bool
+	// MessageArchiveAfterDays moves messages this old to the archive table
+	// after each retention sweep, in batches of RetentionBatchSize; 0
+	// keeps every message in the hot table
+	MessageArchiveAfterDays int
+
+	// NotifyCreator also sends "conversation_created" to the creator's own
+	// connections, so their other devices pick up the new conversation
+	NotifyCreator bool
+
+	// PublicURL is where users reach the server, used for links in email
+	PublicURL string
+
+	// Outbound email goes through MailSMTPAddr ("host:port"); unset, mail
+	// is rendered and queued but dropped instead of sent. The outbox is
+	// drained every MailDrainInterval, a message is given up after
+	// MailMaxAttempts, and each recipient gets at most MailRatePerHour
+	// messages (bursting to MailBurst).
+	MailSMTPAddr      string
+	MailSMTPUsername  string
+	MailSMTPPassword  string
+	MailFrom          string
+	MailDrainInterval time.Duration
+	MailMaxAttempts   int
+	MailRatePerHour   float64
+	MailBurst         int
 }
 
 func Load() *Config {
@@ -30,13 +245,300 @@ func Load() *Config {
 		ServerAddress: getEnv("SERVER_ADDRESS", ":8080"),
 		DatabaseURL:   getEnv("DATABASE_URL", "sqlite://"+dbPath),
 		JWTSecret:     getEnv("JWT_SECRET", "your-secret-key"),
+
+		ReadDatabaseURLs: readDatabaseURLs(),
+
+		WSHeartbeatInterval: getEnvDuration("WS_HEARTBEAT_INTERVAL", 30*time.Second),
+		WSStaleAfter:        getEnvDuration("WS_STALE_AFTER", 90*time.Second),
+		WSMaxFrameBytes:     getEnvInt("WS_MAX_FRAME_BYTES", 64*1024),
+
+		ShutdownTimeout: getEnvDuration("SHUTDOWN_TIMEOUT", 10*time.Second),
+
+		MessageRatePerSec: getEnvFloat("MESSAGE_RATE_PER_SEC", 1),
+		MessageRateBurst:  getEnvInt("MESSAGE_RATE_BURST", 10),
+
+		WSFrameRatePerSec:   getEnvFloat("WS_FRAME_RATE_PER_SEC", 5),
+		WSFrameBurst:        getEnvInt("WS_FRAME_BURST", 20),
+		WSTypingRatePerSec:  getEnvFloat("WS_TYPING_RATE_PER_SEC", 10),
+		WSTypingBurst:       getEnvInt("WS_TYPING_BURST", 30),
+		WSMaxRateViolations: getEnvInt("WS_MAX_RATE_VIOLATIONS", 5),
+		WSSendBuffer:        getEnvInt("WS_SEND_BUFFER", 256),
+		WSSlowClientPolicy:  getEnv("WS_SLOW_CLIENT_POLICY", "disconnect"),
+		WSPersistWorkers:    getEnvInt("WS_PERSIST_WORKERS", 8),
+		WSPersistQueue:      getEnvInt("WS_PERSIST_QUEUE", 64),
+
+		WSDuplicateSessionPolicy: getEnv("WS_DUPLICATE_SESSION_POLICY", "allow"),
+		WSReplayEvents:           getEnvInt("WS_REPLAY_EVENTS", 100),
+		WSReplayTTL:              getEnvDuration("WS_REPLAY_TTL", 5*time.Minute),
+
+		BotRatePerSec: getEnvFloat("BOT_RATE_PER_SEC", 1),
+		BotRateBurst:  getEnvInt("BOT_RATE_BURST", 5),
+
+		MessageDedupeWindow: getEnvDuration("MESSAGE_DEDUPE_WINDOW", 2*time.Second),
+
+		DBRecoveryProbeInterval: getEnvDuration("DB_RECOVERY_PROBE_INTERVAL", 10*time.Second),
+		DBStatementTimeout:      getEnvDuration("DB_STATEMENT_TIMEOUT", 5*time.Second),
+		DBStatsLogInterval:      getEnvDuration("DB_STATS_LOG_INTERVAL", 0),
+		DBSlowQueryThreshold:    getEnvDuration("DB_SLOW_QUERY_THRESHOLD", 100*time.Millisecond),
+		DBMembershipCacheTTL:    getEnvDuration("DB_MEMBERSHIP_CACHE_TTL", 30*time.Second),
+		DBMaxOpenConns:          getEnvInt("DB_MAX_OPEN_CONNS", 16),
+		DBMaxIdleConns:          getEnvInt("DB_MAX_IDLE_CONNS", 4),
+		DBConnMaxLifetime:       getEnvDuration("DB_CONN_MAX_LIFETIME", 0),
+
+		MessageEncryptionKey:          getEnv("MESSAGE_ENCRYPTION_KEY", ""),
+		MessageEncryptionPreviousKeys: getEnvList("MESSAGE_ENCRYPTION_PREVIOUS_KEYS", nil),
+
+		NATSURL:     getEnv("NATS_URL", ""),
+		MirrorTopic: getEnv("MIRROR_TOPIC", "messager.conversations.{conversation_id}.messages"),
+		BusURL:      getEnv("BUS_URL", ""),
+		BusChannel:  getEnv("BUS_CHANNEL", "messager.events"),
+
+		DeliveryRatePerSec:    getEnvFloat("DELIVERY_RATE_PER_SEC", 20),
+		DeliveryBurst:         getEnvInt("DELIVERY_BURST", 50),
+		DeliveryMaxConcurrent: getEnvInt("DELIVERY_MAX_CONCURRENT", 4),
+		DeliveryMaxBacklog:    getEnvInt("DELIVERY_MAX_BACKLOG", 1000),
+
+		AdminUsernames: getEnvList("ADMIN_USERNAMES", nil),
+
+		AllowedOrigins:   getEnvList("ALLOWED_ORIGINS", []string{"http://localhost:3000"}),
+		AllowEmptyOrigin: getEnvBool("ALLOW_EMPTY_ORIGIN", true),
+
+		StorageDir:        getEnv("STORAGE_DIR", filepath.Join(dataDir, "storage")),
+		StorageQuotaBytes: int64(getEnvInt("STORAGE_QUOTA_BYTES", 1<<30)),
+
+		Warmup:              getEnvBool("WARMUP", false),
+		WarmupConversations: getEnvInt("WARMUP_CONVERSATIONS", 500),
+		WarmupConnections:   getEnvInt("WARMUP_CONNECTIONS", 4),
+		WarmupHoldReadiness: getEnvBool("WARMUP_HOLD_READINESS", false),
+
+		ChaosEnabled: getEnvBool("CHAOS_ENABLED", false),
+
+		DevStrict:      getEnvBool("DEV_STRICT", false),
+		DevStrictPanic: getEnvBool("DEV_STRICT_PANIC", false),
+
+		LogMessageContent: getEnvBool("LOG_MESSAGE_CONTENT", false),
+		LogLevel:          getEnv("LOG_LEVEL", "info"),
+
+		MaxPinnedConversations: getEnvInt("MAX_PINNED_CONVERSATIONS", 10),
+		MaxGroupParticipants:   getEnvInt("MAX_GROUP_PARTICIPANTS", 256),
+
+		RetentionSweepInterval:  getEnvDuration("RETENTION_SWEEP_INTERVAL", time.Hour),
+		RetentionBatchSize:      getEnvInt("RETENTION_BATCH_SIZE", 2000),
+		RetentionBatchPause:     getEnvDuration("RETENTION_BATCH_PAUSE", 100*time.Millisecond),
+		MessageRetentionDays:    getEnvInt("MESSAGE_RETENTION_DAYS", 0),
+		RetentionDryRun:         getEnvBool("RETENTION_DRY_RUN", false),
+		MessageArchiveAfterDays: getEnvInt("MESSAGE_ARCHIVE_AFTER_DAYS", 0),
+
+		NotifyCreator: getEnvBool("CONVERSATION_CREATED_NOTIFY_CREATOR", true),
+
+		PublicURL: strings.TrimSuffix(getEnv("PUBLIC_URL", "http://localhost:8080"), "/"),
+
+		MailSMTPAddr:      getEnv("MAIL_SMTP_ADDR", ""),
+		MailSMTPUsername:  getEnv("MAIL_SMTP_USERNAME", ""),
+		MailSMTPPassword:  getEnv("MAIL_SMTP_PASSWORD", ""),
+		MailFrom:          getEnv("MAIL_FROM", "Messager <no-reply@localhost>"),
+		MailDrainInterval: getEnvDuration("MAIL_DRAIN_INTERVAL", 10*time.Second),
+		MailMaxAttempts:   getEnvInt("MAIL_MAX_ATTEMPTS", 6),
+		MailRatePerHour:   getEnvFloat("MAIL_RATE_PER_HOUR", 10),
+		MailBurst:         getEnvInt("MAIL_BURST", 3),
+	}
+}
+
+// Summary renders the effective configuration for the startup banner with
+// secrets redacted. Add new fields here explicitly; anything sensitive must
+// go through redact.
+func (c *Config) Summary() string {
+	return fmt.Sprintf(
+		"server_address=%s database_url=%s read_database_urls=%s db_statement_timeout=%s db_stats_log_interval=%s db_slow_query_threshold=%s db_membership_cache_ttl=%s db_max_open_conns=%d db_max_idle_conns=%d db_conn_max_lifetime=%s message_encryption_key=%s message_encryption_previous_keys=%d jwt_secret=%s ws_heartbeat_interval=%s ws_stale_after=%s ws_max_frame_bytes=%d shutdown_timeout=%s message_rate=%g/s burst=%d ws_frame_rate=%g/s ws_frame_burst=%d ws_typing_rate=%g/s ws_typing_burst=%d ws_max_rate_violations=%d ws_send_buffer=%d ws_slow_client_policy=%s ws_persist_workers=%d ws_persist_queue=%d ws_duplicate_session_policy=%s ws_replay_events=%d ws_replay_ttl=%s bot_rate=%g/s bot_burst=%d nats_url=%s bus_url=%s bus_channel=%s admins=%d allowed_origins=%s allow_empty_origin=%t storage_dir=%s storage_quota=%d warmup=%t warmup_conversations=%d warmup_connections=%d warmup_hold_readiness=%t chaos=%t dev_strict=%t dev_strict_panic=%t log_message_content=%t log_level=%s max_pinned_conversations=%d max_group_participants=%d retention_sweep_interval=%s retention_batch_size=%d retention_batch_pause=%s message_retention_days=%d retention_dry_run=%t message_archive_after_days=%d notify_creator=%t public_url=%s mail_smtp_addr=%s mail_smtp_password=%s mail_from=%q mail_drain_interval=%s mail_max_attempts=%d mail_rate=%g/h mail_burst=%d",
+		c.ServerAddress,
+		redactURL(c.DatabaseURL),
+		redactURLs(c.ReadDatabaseURLs),
+		c.DBStatementTimeout,
+		c.DBStatsLogInterval,
+		c.DBSlowQueryThreshold,
+		c.DBMembershipCacheTTL,
+		c.DBMaxOpenConns,
+		c.DBMaxIdleConns,
+		c.DBConnMaxLifetime,
+		redact(c.MessageEncryptionKey),
+		len(c.MessageEncryptionPreviousKeys),
+		redact(c.JWTSecret),
+		c.WSHeartbeatInterval,
+		c.WSStaleAfter,
+		c.WSMaxFrameBytes,
+		c.ShutdownTimeout,
+		c.MessageRatePerSec,
+		c.MessageRateBurst,
+		c.WSFrameRatePerSec,
+		c.WSFrameBurst,
+		c.WSTypingRatePerSec,
+		c.WSTypingBurst,
+		c.WSMaxRateViolations,
+		c.WSSendBuffer,
+		c.WSSlowClientPolicy,
+		c.WSPersistWorkers,
+		c.WSPersistQueue,
+		c.WSDuplicateSessionPolicy,
+		c.WSReplayEvents,
+		c.WSReplayTTL,
+		c.BotRatePerSec,
+		c.BotRateBurst,
+		redactURL(c.NATSURL),
+		redactURL(c.BusURL),
+		c.BusChannel,
+		len(c.AdminUsernames),
+		strings.Join(c.AllowedOrigins, ","),
+		c.AllowEmptyOrigin,
+		c.StorageDir,
+		c.StorageQuotaBytes,
+		c.Warmup,
+		c.WarmupConversations,
+		c.WarmupConnections,
+		c.WarmupHoldReadiness,
+		c.ChaosEnabled,
+		c.DevStrict,
+		c.DevStrictPanic,
+		c.LogMessageContent,
+		c.LogLevel,
+		c.MaxPinnedConversations,
+		c.MaxGroupParticipants,
+		c.RetentionSweepInterval,
+		c.RetentionBatchSize,
+		c.RetentionBatchPause,
+		c.MessageRetentionDays,
+		c.RetentionDryRun,
+		c.MessageArchiveAfterDays,
+		c.NotifyCreator,
+		c.PublicURL,
+		c.MailSMTPAddr,
+		redact(c.MailSMTPPassword),
+		c.MailFrom,
+		c.MailDrainInterval,
+		c.MailMaxAttempts,
+		c.MailRatePerHour,
+		c.MailBurst,
+	)
+}
+
+// DebugSummary is Summary with the admin usernames spelled out and whether an
+// SMTP username is set, for LOG_LEVEL=debug. Secrets stay redacted.
+func (c *Config) DebugSummary() string {
+	return fmt.Sprintf("%s admin_usernames=%s mail_smtp_username=%s",
+		c.Summary(),
+		strings.Join(c.AdminUsernames, ","),
+		redact(c.MailSMTPUsername),
+	)
+}
+
+// BannerSummary is the configuration summary for the startup banner at
+// LogLevel
+func (c *Config) BannerSummary() string {
+	if strings.EqualFold(c.LogLevel, "debug") {
+		return c.DebugSummary()
 	}
+	return c.Summary()
+}
+
+// DeliveryLimits returns the default per-destination delivery limits
+func (c *Config) DeliveryLimits() delivery.Limits {
+	return delivery.Limits{
+		RatePerSec:    c.DeliveryRatePerSec,
+		Burst:         c.DeliveryBurst,
+		MaxConcurrent: c.DeliveryMaxConcurrent,
+		MaxBacklog:    c.DeliveryMaxBacklog,
+	}
+}
+
+// OriginAllowed reports whether a browser origin is listed in
+// ALLOWED_ORIGINS. An empty origin is never allowed here; whether clients
+// without one may connect is up to AllowEmptyOrigin.
+func (c *Config) OriginAllowed(origin string) bool {
+	if origin == "" {
+		return false
+	}
+	origin = strings.TrimSuffix(origin, "/")
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAdmin reports whether username is listed in ADMIN_USERNAMES
+func (c *Config) IsAdmin(username string) bool {
+	for _, admin := range c.AdminUsernames {
+		if admin == username {
+			return true
+		}
+	}
+	return false
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return "(unset)"
+	}
+	return "[REDACTED]"
+}
+
+// redactURL strips the credentials from a URL-style DSN: the password, or
+// the whole userinfo when there's no password, since a lone name is usually
+// a token (nats://s3cr3t@nats:4222)
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	if _, hasPassword := u.User.Password(); hasPassword {
+		u.User = url.UserPassword(u.User.Username(), "REDACTED")
+	} else {
+		u.User = url.User("REDACTED")
+	}
+	return u.String()
+}
+
+// redactURLs is redactURL for a list, comma-separated
+func redactURLs(raw []string) string {
+	redacted := make([]string, len(raw))
+	for i, u := range raw {
+		redacted[i] = redactURL(u)
+	}
+	return strings.Join(redacted, ",")
+}
+
+// MessageEncryptionKeys decodes MESSAGE_ENCRYPTION_KEY and
+// MESSAGE_ENCRYPTION_PREVIOUS_KEYS. The current key is nil when unset.
+func (c *Config) MessageEncryptionKeys() (current []byte, previous [][]byte, err error) {
+	if c.MessageEncryptionKey != "" {
+		if current, err = base64.StdEncoding.DecodeString(c.MessageEncryptionKey); err != nil {
+			return nil, nil, fmt.Errorf("MESSAGE_ENCRYPTION_KEY is not valid base64: %v", err)
+		}
+	}
+	for i, encoded := range c.MessageEncryptionPreviousKeys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, nil, fmt.Errorf("MESSAGE_ENCRYPTION_PREVIOUS_KEYS entry %d is not valid base64: %v", i+1, err)
+		}
+		previous = append(previous, key)
+	}
+	return current, previous, nil
 }
 
 // CleanDatabasePath returns a clean filesystem path from a database URL
 func (c *Config) CleanDatabasePath() string {
+	return cleanSQLitePath(c.DatabaseURL)
+}
+
+// CleanSQLitePath is CleanDatabasePath for any database URL, e.g. one of
+// ReadDatabaseURLs
+func CleanSQLitePath(databaseURL string) string {
+	return cleanSQLitePath(databaseURL)
+}
+
+func cleanSQLitePath(databaseURL string) string {
 	// Strip sqlite:// prefix if present
-	dbPath := strings.TrimPrefix(c.DatabaseURL, "sqlite://")
+	dbPath := strings.TrimPrefix(databaseURL, "sqlite://")
 	
 	// If it's not an absolute path, make it relative to the current directory
 	if !filepath.IsAbs(dbPath) {
@@ -64,4 +566,71 @@ func getEnv(key, fallback string) string {
 		return value
 	}
 	return fallback
-} 
\ No newline at end of file
+}
+
+// getEnvDuration parses a Go duration string (e.g. "30s", "2m")
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if value, exists := os.LookupEnv(key); exists {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	if value, exists := os.LookupEnv(key); exists {
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+	}
+	return fallback
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+	if value, exists := os.LookupEnv(key); exists {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return fallback
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	if value, exists := os.LookupEnv(key); exists {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return fallback
+}
+
+// getEnvList splits a comma-separated value, dropping empty entries
+func getEnvList(key string, fallback []string) []string {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// readDatabaseURLs reads DATABASE_READ_URLS, adding READ_DATABASE_URL if
+// it's set and not already listed
+func readDatabaseURLs() []string {
+	urls := getEnvList("DATABASE_READ_URLS", nil)
+	if single := getEnv("READ_DATABASE_URL", ""); single != "" {
+		for _, u := range urls {
+			if u == single {
+				return urls
+			}
+		}
+		urls = append(urls, single)
+	}
+	return urls
+}
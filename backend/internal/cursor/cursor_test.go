@@ -0,0 +1,49 @@
+package cursor
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	c := New("secret")
+	pos := Position{Rank: 1, At: time.Date(2024, 3, 1, 12, 0, 0, 123456789, time.UTC), ID: 42}
+	got, err := c.Decode(c.Encode("messages", 7, pos), "messages", 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != pos {
+		t.Errorf("decoded %+v, want %+v", got, pos)
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	c := New("secret")
+	token := c.Encode("messages", 7, Position{At: time.Now(), ID: 42})
+	body, sig, _ := strings.Cut(token, ".")
+	// A valid body with a different ID, signed by nobody
+	forged := New("other").Encode("messages", 7, Position{At: time.Now(), ID: 1})
+	forgedBody, _, _ := strings.Cut(forged, ".")
+
+	for _, tc := range []struct {
+		name  string
+		token string
+		kind  string
+		scope int64
+		want  error
+	}{
+		{"no signature", body, "messages", 7, ErrInvalid},
+		{"garbage", "not a token", "messages", 7, ErrInvalid},
+		{"tampered body", forgedBody + "." + sig, "messages", 7, ErrInvalid},
+		{"other secret", forged, "messages", 7, ErrInvalid},
+		{"bad base64", body + ".***", "messages", 7, ErrInvalid},
+		{"other conversation", token, "messages", 8, ErrWrongScope},
+		{"other listing", token, "saved", 7, ErrWrongScope},
+	} {
+		if _, err := c.Decode(tc.token, tc.kind, tc.scope); !errors.Is(err, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, err, tc.want)
+		}
+	}
+}
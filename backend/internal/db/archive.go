@@ -0,0 +1,268 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"messager/internal/cursor"
+	"messager/internal/models"
+)
+
+// Messages past the archive age move from messages to messages_archive, a
+// batch per transaction, so the table every send and history page touches
+// stays small. Each conversation records the newest message it has in the
+// archive, and a history page only reads the archive once it reaches that
+// far back. A few old messages stay behind (see below), so a page past
+// that point merges both tables. Exports, retention and re-encryption
+// cover both.
+//
+// Archived messages are read-only. They can't be deleted, reported or
+// fetched on their own, sync and gap repair don't see them, and they no
+// longer count as unread. A conversation's latest message, reported
+// messages and messages still waiting in the outbox are never archived.
+
+// archiveColumns are the columns messages and messages_archive share
+const archiveColumns = `id, conversation_id, sender_id, content, created_at, message_type, deleted_at, seq, content_key, content_nonce, content_bound`
+
+// archivableSQL matches messages, aliased as m, that may move to the
+// archive: older than the placeholder and none of the exceptions above
+const archivableSQL = `unixepoch(m.created_at, 'subsec') < unixepoch(?, 'subsec')
+		  AND m.id NOT IN (SELECT last_message_id FROM conversations WHERE last_message_id IS NOT NULL)
+		  AND NOT EXISTS (SELECT 1 FROM message_reports r WHERE r.message_id = m.id)
+		  AND m.id NOT IN (SELECT message_id FROM message_outbox WHERE sent_at IS NULL)`
+
+// ArchiveMessages moves up to limit messages older than before into the
+// archive and returns how many moved. Each batch moves in one transaction,
+// so a message is always in exactly one of the tables. Callers repeat
+// until fewer than limit come back.
+func (db *DB) ArchiveMessages(ctx context.Context, before time.Time, limit int) (int, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.guardWrite(); err != nil {
+		return 0, err
+	}
+
+	// The batch is picked before the write transaction begins, so writers
+	// only wait for the move and not for the scan. A message can be
+	// reported in between, so the move checks again.
+	cutoff := before.UTC().Format("2006-01-02 15:04:05.999999999")
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT m.id FROM messages m
+		WHERE `+archivableSQL+`
+		ORDER BY m.id
+		LIMIT ?
+	`, cutoff, limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find messages to archive: %v", err)
+	}
+	var ids []interface{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan message to archive: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating messages to archive: %v", err)
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	in := "?" + strings.Repeat(", ?", len(ids)-1)
+	moved := 0
+	err = db.WithTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `
+			INSERT INTO messages_archive (`+archiveColumns+`)
+			SELECT `+archiveColumns+` FROM messages m
+			WHERE m.id IN (`+in+`) AND `+archivableSQL+`
+		`, append(ids, cutoff)...)
+		if err != nil {
+			return fmt.Errorf("failed to copy messages to the archive: %w", db.checkWrite(err))
+		}
+		n, _ := result.RowsAffected()
+		moved = int(n)
+
+		// Archived messages stop counting as unread, so the counts agree
+		// with RepairUnreadCounts, which only sees messages
+		archived := ` AND m.id IN (SELECT a.id FROM messages_archive a WHERE a.id IN (` + in + `))`
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE conversation_participants
+			SET unread_count = MAX(unread_count - `+unreadSQL(archived)+`, 0)
+			WHERE unread_count > 0
+		`, ids...); err != nil {
+			return fmt.Errorf("failed to update unread counts: %w", db.checkWrite(err))
+		}
+		if _, err := tx.ExecContext(ctx, `
+			DELETE FROM messages WHERE id IN (SELECT a.id FROM messages_archive a WHERE a.id IN (`+in+`))
+		`, ids...); err != nil {
+			return fmt.Errorf("failed to delete archived messages: %w", db.checkWrite(err))
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE conversations SET (archived_through_at, archived_through_id) = (
+				SELECT a.created_at, a.id FROM messages_archive a
+				WHERE a.conversation_id = conversations.id
+				ORDER BY unixepoch(a.created_at, 'subsec') DESC, a.id DESC LIMIT 1
+			)
+			WHERE id IN (SELECT a.conversation_id FROM messages_archive a WHERE a.id IN (`+in+`))
+		`, ids...); err != nil {
+			return fmt.Errorf("failed to update archive boundaries: %w", db.checkWrite(err))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return moved, nil
+}
+
+// queryMessages runs a query selecting messageColumns and scans every row
+func (db *DB) queryMessages(ctx context.Context, query string, args ...interface{}) ([]models.Message, error) {
+	rows, err := db.queryRead(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []models.Message
+	for rows.Next() {
+		var msg models.Message
+		if err := db.scanMessage(rows, &msg); err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// archiveBoundary returns the position of the newest message a
+// conversation has in the archive, or nil when it has none. Retention may
+// have deleted that message since; the boundary only has to be at least as
+// new as what's left.
+func (db *DB) archiveBoundary(ctx context.Context, conversationID int64) (*cursor.Position, error) {
+	var at sql.NullTime
+	var id sql.NullInt64
+	err := db.queryRowRead(ctx, `
+		SELECT archived_through_at, archived_through_id FROM conversations WHERE id = ?
+	`, conversationID).Scan(&at, &id)
+	if err == sql.ErrNoRows || !id.Valid {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cursor.Position{At: at.Time, ID: id.Int64}, nil
+}
+
+// pastArchiveBoundary reports whether a page of hot messages, newest first,
+// may be missing archived ones: it came back short, or reaches back to the
+// newest archived message
+func pastArchiveBoundary(page []models.Message, limit int, boundary *cursor.Position) bool {
+	if boundary == nil {
+		return false
+	}
+	return len(page) < limit || !newerThan(page[len(page)-1], boundary)
+}
+
+// newerThan orders msg against p the way history pages do, by created_at
+// and then ID
+func newerThan(msg models.Message, p *cursor.Position) bool {
+	return msg.CreatedAt.After(p.At) || (msg.CreatedAt.Equal(p.At) && msg.ID > p.ID)
+}
+
+// mergeNewestFirst merges two pages that are each newest first
+func mergeNewestFirst(a, b []models.Message) []models.Message {
+	merged := make([]models.Message, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		if newerThan(a[0], &cursor.Position{At: b[0].CreatedAt, ID: b[0].ID}) {
+			merged, a = append(merged, a[0]), a[1:]
+		} else {
+			merged, b = append(merged, b[0]), b[1:]
+		}
+	}
+	return append(append(merged, a...), b...)
+}
+
+// queryMessagesPage is a page of GetConversationMessages read from table,
+// messages or messages_archive
+func (db *DB) queryMessagesPage(ctx context.Context, table string, conversationID int64, limit, offset int) ([]models.Message, error) {
+	return db.queryMessages(ctx, `
+		SELECT `+messageColumns+`
+		FROM `+table+`
+		WHERE conversation_id = ?
+		ORDER BY `+messageTimeKey+` DESC, id DESC
+		LIMIT ? OFFSET ?
+	`, conversationID, limit, offset)
+}
+
+// queryMessagesBefore is a page of GetConversationMessagesBefore read from
+// table, messages or messages_archive
+func (db *DB) queryMessagesBefore(ctx context.Context, table string, conversationID int64, before *cursor.Position, limit int) ([]models.Message, error) {
+	query := `
+		SELECT ` + messageColumns + `
+		FROM ` + table + `
+		WHERE conversation_id = ?`
+	args := []interface{}{conversationID}
+	if before != nil {
+		anchor := before.At.UTC().Format("2006-01-02 15:04:05.999999999")
+		query += `
+		  AND (` + messageTimeKey + ` < unixepoch(?, 'subsec')
+		       OR (` + messageTimeKey + ` = unixepoch(?, 'subsec') AND id < ?))`
+		args = append(args, anchor, anchor, before.ID)
+	}
+	query += `
+		ORDER BY ` + messageTimeKey + ` DESC, id DESC
+		LIMIT ?`
+	args = append(args, limit)
+	return db.queryMessages(ctx, query, args...)
+}
+
+// pruneExpiredArchive deletes up to limit archived messages that are older
+// than their conversation's retention, as PruneExpiredMessages does for
+// messages. Archived messages are never unread or anyone's latest, so
+// nothing else needs updating.
+func (db *DB) pruneExpiredArchive(ctx context.Context, now time.Time, defaultDays, limit int) (map[int64]int, error) {
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT m.id, m.conversation_id
+		FROM messages_archive m
+		JOIN conversations c ON c.id = m.conversation_id
+		WHERE `+expiredMessagesSQL+`
+		ORDER BY m.id
+		LIMIT ?3
+	`, now.UTC().Format("2006-01-02 15:04:05"), defaultDays, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find expired archived messages: %v", err)
+	}
+	var ids []interface{}
+	pruned := make(map[int64]int)
+	for rows.Next() {
+		var id, conversationID int64
+		if err := rows.Scan(&id, &conversationID); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan expired archived message: %v", err)
+		}
+		ids = append(ids, id)
+		pruned[conversationID]++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating expired archived messages: %v", err)
+	}
+	if len(ids) == 0 {
+		return pruned, nil
+	}
+
+	if _, err := db.DB.ExecContext(ctx, `
+		DELETE FROM messages_archive WHERE id IN (?`+strings.Repeat(", ?", len(ids)-1)+`)
+	`, ids...); err != nil {
+		return nil, fmt.Errorf("failed to delete expired archived messages: %w", db.checkWrite(err))
+	}
+	return pruned, nil
+}
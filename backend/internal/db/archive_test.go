@@ -0,0 +1,209 @@
+package db_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"messager/internal/cursor"
+	"messager/internal/db"
+	"messager/internal/db/testdb"
+	"messager/internal/models"
+)
+
+// markSent marks every queued message delivered, so none is held back from
+// the archive for its outbox row
+func markSent(t *testing.T, d *db.DB) {
+	t.Helper()
+	if _, err := d.Exec(`UPDATE message_outbox SET sent_at = CURRENT_TIMESTAMP`); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func countRows(t *testing.T, d *db.DB, table string, conversationID int64) int {
+	t.Helper()
+	var n int
+	if err := d.QueryRow(`SELECT COUNT(*) FROM `+table+` WHERE conversation_id = ?`, conversationID).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestArchiveMessages(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+	now := time.Now()
+	markSent(t, d)
+
+	// A message still waiting for delivery stays behind, and so does each
+	// conversation's latest
+	pending, err := d.CreateMessage(ctx, f.Direct.ID, f.Bob.ID, "pending")
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest, err := d.CreateMessageAt(ctx, f.Direct.ID, f.Alice.ID, "latest", now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Exec(`UPDATE message_outbox SET sent_at = CURRENT_TIMESTAMP WHERE message_id = ?`, latest.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if moved, err := d.ArchiveMessages(ctx, now.Add(-time.Hour), 10); err != nil || moved != 0 {
+		t.Errorf("archived %d before the cutoff, %v", moved, err)
+	}
+	// Batches stop at the limit
+	if moved, err := d.ArchiveMessages(ctx, now.Add(time.Hour), 2); err != nil || moved != 2 {
+		t.Fatalf("first batch archived %d, %v; want 2", moved, err)
+	}
+	if moved, err := d.ArchiveMessages(ctx, now.Add(time.Hour), 2); err != nil || moved != 1 {
+		t.Fatalf("second batch archived %d, %v; want the last 1", moved, err)
+	}
+
+	if hot, archived := countRows(t, d, "messages", f.Direct.ID), countRows(t, d, "messages_archive", f.Direct.ID); hot != 2 || archived != 2 {
+		t.Errorf("direct: %d hot, %d archived; want pending and latest left", hot, archived)
+	}
+	if hot, archived := countRows(t, d, "messages", f.Group.ID), countRows(t, d, "messages_archive", f.Group.ID); hot != 1 || archived != 1 {
+		t.Errorf("group: %d hot, %d archived; want only the latest left", hot, archived)
+	}
+	var throughID int64
+	if err := d.QueryRow(`SELECT archived_through_id FROM conversations WHERE id = ?`, f.Direct.ID).Scan(&throughID); err != nil || throughID != f.Messages[1].ID {
+		t.Errorf("direct archived through %d, %v; want %d", throughID, err, f.Messages[1].ID)
+	}
+	if msg, err := d.GetMessageByID(ctx, pending.ID); err != nil || msg.Content != "pending" {
+		t.Errorf("pending message: %v, %v", msg, err)
+	}
+	if n, err := d.RepairUnreadCounts(ctx); err != nil || n != 0 {
+		t.Errorf("repair after archiving fixed %d, %v", n, err)
+	}
+}
+
+func TestHistoryInterleavesArchive(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+	now := time.Now()
+	heyBob, hiAlice := f.Messages[0], f.Messages[1]
+	var third, fourth *models.Message
+	for i, msg := range []**models.Message{&third, &fourth} {
+		var err error
+		if *msg, err = d.CreateMessageAt(ctx, f.Direct.ID, f.Bob.ID, "later", now.Add(time.Duration(i+1)*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The oldest message is still queued, so it stays hot behind newer
+	// archived ones and pages have to interleave the two tables
+	markSent(t, d)
+	if _, err := d.Exec(`UPDATE message_outbox SET sent_at = NULL WHERE message_id = ?`, heyBob.ID); err != nil {
+		t.Fatal(err)
+	}
+	if moved, err := d.ArchiveMessages(ctx, now.Add(time.Hour), 10); err != nil || moved != 3 {
+		t.Fatalf("archived %d, %v; want hi alice, the third and the group's first", moved, err)
+	}
+
+	for _, tc := range []struct {
+		limit, offset int
+		want          []*models.Message
+	}{
+		{10, 0, []*models.Message{fourth, third, hiAlice, heyBob}},
+		// A full page newer than the archive never reads it
+		{1, 0, []*models.Message{fourth}},
+		{2, 1, []*models.Message{third, hiAlice}},
+		{2, 3, []*models.Message{heyBob}},
+		{2, 4, nil},
+	} {
+		page, err := d.GetConversationMessages(ctx, f.Direct.ID, tc.limit, tc.offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertIDs(t, fmt.Sprintf("limit %d offset %d", tc.limit, tc.offset), page, tc.want...)
+	}
+
+	newest, err := d.GetConversationMessagesBefore(ctx, f.Direct.ID, nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIDs(t, "newest page", newest, fourth, third)
+	older, err := d.GetConversationMessagesBefore(ctx, f.Direct.ID, &cursor.Position{At: third.CreatedAt, ID: third.ID}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIDs(t, "page before the third", older, hiAlice, heyBob)
+
+	if got := exportedContent(t, d, f.Direct.ID); len(got) != 4 || got[0] != "hey bob" || got[1] != "hi alice" {
+		t.Errorf("exported %q, want the seeded two first", got)
+	}
+}
+
+func TestExportOrdersMixedTimestamps(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+
+	// As text, "2020-01-01 10:00" sorts before "2020-01-01T09:00", so the
+	// export has to order by the parsed time. One of each is archived.
+	conv, err := d.CreateConversation(ctx, "Mixed", "group", f.Alice.ID, []int64{f.Alice.ID, f.Bob.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []struct{ table, content, at string }{
+		{"messages", "second", "2020-01-01 10:00:00"},
+		{"messages", "first", "2020-01-01T09:00:00"},
+		{"messages_archive", "fourth", "2020-01-01 12:00:00.5"},
+		{"messages_archive", "third", "2020-01-01T11:00:00.25"},
+	} {
+		if _, err := d.Exec(`INSERT INTO `+m.table+` (conversation_id, sender_id, content, created_at, seq) VALUES (?, ?, ?, ?, (SELECT COUNT(*) + 100 FROM `+m.table+`))`,
+			conv.ID, f.Alice.ID, m.content, m.at); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exported := exportedContent(t, d, conv.ID)
+	if want := []string{"first", "second", "third", "fourth"}; len(exported) != 4 || exported[0] != want[0] || exported[1] != want[1] || exported[2] != want[2] || exported[3] != want[3] {
+		t.Errorf("exported %q, want %q", exported, want)
+	}
+}
+
+func TestPruneExpiredArchive(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	// Four 10-day-old messages, the last still queued so it stays hot
+	var old []*models.Message
+	for i := 0; i < 4; i++ {
+		msg, err := d.CreateMessageAt(ctx, f.Direct.ID, f.Bob.ID, "old", now.AddDate(0, 0, -10).Add(time.Duration(i)*time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		old = append(old, msg)
+	}
+	if _, err := d.Exec(`UPDATE message_outbox SET sent_at = CURRENT_TIMESTAMP WHERE message_id != ?`, old[3].ID); err != nil {
+		t.Fatal(err)
+	}
+	if moved, err := d.ArchiveMessages(ctx, now.AddDate(0, 0, -5), 10); err != nil || moved != 3 {
+		t.Fatalf("archived %d, %v; want 3", moved, err)
+	}
+
+	// Archived messages expire first, within the same limit
+	pruned, err := d.PruneExpiredMessages(ctx, now, 7, 2)
+	if err != nil || pruned[f.Direct.ID] != 2 {
+		t.Fatalf("first batch: %v, %v; want 2", pruned, err)
+	}
+	if archived, hot := countRows(t, d, "messages_archive", f.Direct.ID), countRows(t, d, "messages", f.Direct.ID); archived != 1 || hot != 3 {
+		t.Errorf("after the first batch: %d archived, %d hot; want 1 and 3", archived, hot)
+	}
+	pruned, err = d.PruneExpiredMessages(ctx, now, 7, 10)
+	if err != nil || pruned[f.Direct.ID] != 2 {
+		t.Fatalf("second batch: %v, %v; want the last archived one and the hot one", pruned, err)
+	}
+	if archived, hot := countRows(t, d, "messages_archive", f.Direct.ID), countRows(t, d, "messages", f.Direct.ID); archived != 0 || hot != 2 {
+		t.Errorf("after the second batch: %d archived, %d hot; want only the seeded 2 left", archived, hot)
+	}
+	if pruned, err := d.PruneExpiredMessages(ctx, now, 7, 10); err != nil || len(pruned) != 0 {
+		t.Errorf("third batch: %v, %v; want nothing left", pruned, err)
+	}
+}
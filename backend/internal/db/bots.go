@@ -0,0 +1,240 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"messager/internal/models"
+)
+
+// CreateBot creates a bot account. Bots have no password, so they can't log
+// in; they authenticate with the API key whose hash is stored here.
+func (db *DB) CreateBot(ctx context.Context, username, avatar, webhookURL, apiKeyHash string) (*models.Bot, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.guardWrite(); err != nil {
+		return nil, err
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", db.checkWrite(err))
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO users (username, password, avatar, is_bot, created_at) VALUES (?, '', ?, 1, ?)
+	`, username, avatar, now)
+	if isUniqueViolation(err) {
+		return nil, ErrDuplicateUsername
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bot user: %w", db.checkWrite(err))
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO bots (user_id, api_key_hash, webhook_url) VALUES (?, ?, ?)
+	`, id, apiKeyHash, webhookURL); err != nil {
+		return nil, fmt.Errorf("failed to create bot: %w", db.checkWrite(err))
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", db.checkWrite(err))
+	}
+
+	return &models.Bot{
+		ID:         id,
+		Username:   username,
+		Avatar:     avatar,
+		WebhookURL: webhookURL,
+		Commands:   []models.BotCommand{},
+		CreatedAt:  now,
+	}, nil
+}
+
+// GetBotByAPIKeyHash returns the bot user holding the API key, or
+// ErrNotFound
+func (db *DB) GetBotByAPIKeyHash(ctx context.Context, apiKeyHash string) (*models.User, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var user models.User
+	err := db.DB.QueryRowContext(ctx, `
+		SELECT u.id, u.username, u.avatar, u.is_bot, u.created_at
+		FROM bots b
+		JOIN users u ON u.id = b.user_id
+		WHERE b.api_key_hash = ?
+	`, apiKeyHash).Scan(&user.ID, &user.Username, &user.Avatar, &user.IsBot, &user.CreatedAt)
+	if err != nil {
+		return nil, classify(err)
+	}
+	return &user, nil
+}
+
+// ListBots returns every bot with its commands, oldest first
+func (db *DB) ListBots(ctx context.Context) ([]*models.Bot, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.queryRead(ctx, `
+		SELECT u.id, u.username, COALESCE(u.avatar, ''), b.webhook_url, u.created_at
+		FROM bots b
+		JOIN users u ON u.id = b.user_id
+		ORDER BY u.id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query bots: %v", err)
+	}
+	defer rows.Close()
+
+	var bots []*models.Bot
+	byID := make(map[int64]*models.Bot)
+	for rows.Next() {
+		bot := &models.Bot{Commands: []models.BotCommand{}}
+		if err := rows.Scan(&bot.ID, &bot.Username, &bot.Avatar, &bot.WebhookURL, &bot.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan bot: %v", err)
+		}
+		bots = append(bots, bot)
+		byID[bot.ID] = bot
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating bots: %v", err)
+	}
+
+	commands, err := db.ListBotCommands(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, command := range commands {
+		if bot, ok := byID[command.BotID]; ok {
+			bot.Commands = append(bot.Commands, command.BotCommand)
+		}
+	}
+	return bots, nil
+}
+
+// ListBotCommands returns every registered command, alphabetically
+func (db *DB) ListBotCommands(ctx context.Context) ([]models.RegisteredCommand, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.queryRead(ctx, `
+		SELECT bc.command, bc.description, bc.bot_id, u.username
+		FROM bot_commands bc
+		JOIN users u ON u.id = bc.bot_id
+		ORDER BY bc.command
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query bot commands: %v", err)
+	}
+	defer rows.Close()
+
+	commands := []models.RegisteredCommand{}
+	for rows.Next() {
+		var command models.RegisteredCommand
+		if err := rows.Scan(&command.Command, &command.Description, &command.BotID, &command.BotUsername); err != nil {
+			return nil, fmt.Errorf("failed to scan bot command: %v", err)
+		}
+		commands = append(commands, command)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating bot commands: %v", err)
+	}
+	return commands, nil
+}
+
+// SetBotCommands replaces the commands a bot owns. A command name belongs to
+// at most one bot: if any of them is already held by another bot nothing
+// changes and the conflicting registrations are returned.
+func (db *DB) SetBotCommands(ctx context.Context, botID int64, commands []models.BotCommand) ([]models.RegisteredCommand, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.guardWrite(); err != nil {
+		return nil, err
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", db.checkWrite(err))
+	}
+	defer tx.Rollback()
+
+	if len(commands) > 0 {
+		args := []interface{}{botID}
+		for _, command := range commands {
+			args = append(args, command.Command)
+		}
+		rows, err := tx.QueryContext(ctx, `
+			SELECT bc.command, bc.description, bc.bot_id, u.username
+			FROM bot_commands bc
+			JOIN users u ON u.id = bc.bot_id
+			WHERE bc.bot_id != ? AND bc.command IN (?`+strings.Repeat(", ?", len(commands)-1)+`)
+			ORDER BY bc.command
+		`, args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check command conflicts: %v", err)
+		}
+		var conflicts []models.RegisteredCommand
+		for rows.Next() {
+			var command models.RegisteredCommand
+			if err := rows.Scan(&command.Command, &command.Description, &command.BotID, &command.BotUsername); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to scan bot command: %v", err)
+			}
+			conflicts = append(conflicts, command)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("error iterating bot commands: %v", err)
+		}
+		if len(conflicts) > 0 {
+			return conflicts, nil
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM bot_commands WHERE bot_id = ?`, botID); err != nil {
+		return nil, fmt.Errorf("failed to clear bot commands: %w", db.checkWrite(err))
+	}
+	now := time.Now().UTC()
+	for _, command := range commands {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO bot_commands (command, bot_id, description, created_at) VALUES (?, ?, ?, ?)
+		`, command.Command, botID, command.Description, now); err != nil {
+			return nil, fmt.Errorf("failed to register command %q: %w", command.Command, db.checkWrite(err))
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", db.checkWrite(err))
+	}
+	return nil, nil
+}
+
+// CommandBot returns the bot owning command and its webhook URL (empty when
+// it only listens on its websocket), or ErrNotFound
+func (db *DB) CommandBot(ctx context.Context, command string) (botID int64, webhookURL string, err error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	err = db.DB.QueryRowContext(ctx, `
+		SELECT b.user_id, b.webhook_url
+		FROM bot_commands bc
+		JOIN bots b ON b.user_id = bc.bot_id
+		WHERE bc.command = ?
+	`, command).Scan(&botID, &webhookURL)
+	if err == sql.ErrNoRows {
+		return 0, "", ErrNotFound
+	}
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to look up command: %v", err)
+	}
+	return botID, webhookURL, nil
+}
@@ -0,0 +1,20 @@
+package db
+
+import "messager/internal/chaos"
+
+// Statement names accepted by the fault injector
+const (
+	stmtInsertMessage     = "insert_message"
+	stmtListConversations = "list_conversations"
+	stmtListMessages      = "list_messages"
+	stmtParticipantIDs    = "participant_ids"
+	stmtIsParticipant     = "is_participant"
+	stmtUserByID          = "user_by_id"
+	stmtMarkDelivered     = "mark_delivered"
+)
+
+// SetChaos routes the named statements through a fault injector. It must be
+// called before the server starts serving.
+func (db *DB) SetChaos(injector *chaos.Injector) {
+	db.chaos = injector
+}
@@ -0,0 +1,224 @@
+package db
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// Message content can be encrypted at rest with AES-256-GCM. An encrypted
+// row stores base64 ciphertext in content, the ID of the key that sealed it
+// in content_key and its nonce in content_nonce; a row with no content_key
+// is plaintext, written before a key was set. Nothing else about a message
+// is encrypted, and nothing may index or search content once it is.
+//
+// The message and conversation IDs are sealed in as additional data, so
+// ciphertext copied into another row fails to open. Rows sealed before that
+// have content_bound = 0 and open without it until they are re-encrypted.
+//
+// Keys rotate by adding a new current key and keeping the old one among the
+// previous keys until ReencryptMessages has rewritten every row under it.
+
+// ContentKeySize is the length of a content key: AES-256
+const ContentKeySize = 32
+
+// ErrUnknownContentKey is returned reading a message sealed with a key that
+// isn't configured
+var ErrUnknownContentKey = errors.New("message content is encrypted with a key that isn't configured")
+
+// contentCipher seals new content with the current key and opens content
+// sealed with any configured key. A nil contentCipher stores plaintext.
+type contentCipher struct {
+	currentID string
+	keys      map[string]cipher.AEAD // key ID -> AEAD
+}
+
+// contentKeyID names a key without revealing it: the first 4 bytes of its
+// SHA-256, in hex
+func contentKeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+// SetContentKeys encrypts message content written from now on with current
+// and reads content sealed with current or any of previous. A nil current
+// writes plaintext, while still reading content sealed with previous. It
+// must be set before the server starts serving.
+func (db *DB) SetContentKeys(current []byte, previous ...[]byte) error {
+	if current == nil && len(previous) == 0 {
+		db.content = nil
+		return nil
+	}
+	c := &contentCipher{keys: make(map[string]cipher.AEAD, len(previous)+1)}
+	for i, key := range append([][]byte{current}, previous...) {
+		if key == nil {
+			continue
+		}
+		if len(key) != ContentKeySize {
+			return fmt.Errorf("content key must be %d bytes, got %d", ContentKeySize, len(key))
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return fmt.Errorf("failed to create cipher: %v", err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return fmt.Errorf("failed to create cipher: %v", err)
+		}
+		id := contentKeyID(key)
+		if i == 0 {
+			c.currentID = id
+		}
+		c.keys[id] = aead
+	}
+	db.content = c
+	return nil
+}
+
+// contentAAD is the additional data a message's content is sealed with: its
+// ID and conversation ID, big-endian. Content sealed before it was bound to
+// its row has none.
+func contentAAD(messageID, conversationID int64, bound bool) []byte {
+	if !bound {
+		return nil
+	}
+	aad := make([]byte, 16)
+	binary.BigEndian.PutUint64(aad[:8], uint64(messageID))
+	binary.BigEndian.PutUint64(aad[8:], uint64(conversationID))
+	return aad
+}
+
+// sealing reports whether seal encrypts, rather than storing plaintext
+func (c *contentCipher) sealing() bool {
+	return c != nil && c.currentID != ""
+}
+
+// seal encrypts plaintext with the current key, binding it to aad. Without
+// a key it returns plaintext as it is, with no key ID or nonce.
+func (c *contentCipher) seal(plaintext string, aad []byte) (string, sql.NullString, []byte, error) {
+	if !c.sealing() {
+		return plaintext, sql.NullString{}, nil, nil
+	}
+	aead := c.keys[c.currentID]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", sql.NullString{}, nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	sealed := aead.Seal(nil, nonce, []byte(plaintext), aad)
+	return base64.StdEncoding.EncodeToString(sealed), sql.NullString{String: c.currentID, Valid: true}, nonce, nil
+}
+
+// open decrypts content stored under keyID and sealed with aad, or returns
+// it as it is when there's no key ID
+func (c *contentCipher) open(content string, keyID sql.NullString, nonce, aad []byte) (string, error) {
+	if !keyID.Valid {
+		return content, nil
+	}
+	var aead cipher.AEAD
+	if c != nil {
+		aead = c.keys[keyID.String]
+	}
+	if aead == nil {
+		return "", fmt.Errorf("%w (key %s)", ErrUnknownContentKey, keyID.String)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(content)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode content: %v", err)
+	}
+	if len(nonce) != aead.NonceSize() {
+		return "", fmt.Errorf("content nonce must be %d bytes, got %d", aead.NonceSize(), len(nonce))
+	}
+	plaintext, err := aead.Open(nil, nonce, sealed, aad)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt content: %v", err)
+	}
+	return string(plaintext), nil
+}
+
+// current reports whether content stored under keyID is already sealed the
+// way seal would store it now
+func (c *contentCipher) current(keyID sql.NullString, bound bool) bool {
+	if !c.sealing() {
+		return !keyID.Valid
+	}
+	return keyID.Valid && keyID.String == c.currentID && bound
+}
+
+// ReencryptMessages rewrites up to limit messages with IDs above afterID so
+// their content is sealed with the current key, or stored as plaintext when
+// there is none. Soft-deleted and archived messages are rewritten too, as
+// their content is still stored, and so is content sealed with the current
+// key before it was bound to its row. It returns the highest ID it looked at, 0
+// once there are none left, and how many it rewrote. Call it again from
+// that ID until it returns 0.
+func (db *DB) ReencryptMessages(ctx context.Context, afterID int64, limit int) (int64, int, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	type storedContent struct {
+		id             int64
+		conversationID int64
+		table          string
+		content        string
+		keyID          sql.NullString
+		nonce          []byte
+		bound          bool
+	}
+	var stale []storedContent
+	lastID := int64(0)
+	err := db.WithTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, `
+			SELECT id, conversation_id, 'messages', content, content_key, content_nonce, content_bound FROM messages WHERE id > ?1
+			UNION ALL
+			SELECT id, conversation_id, 'messages_archive', content, content_key, content_nonce, content_bound FROM messages_archive WHERE id > ?1
+			ORDER BY id LIMIT ?2
+		`, afterID, limit)
+		if err != nil {
+			return fmt.Errorf("failed to query messages: %v", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var row storedContent
+			if err := rows.Scan(&row.id, &row.conversationID, &row.table, &row.content, &row.keyID, &row.nonce, &row.bound); err != nil {
+				return fmt.Errorf("failed to scan message: %v", err)
+			}
+			lastID = row.id
+			if !db.content.current(row.keyID, row.bound) {
+				stale = append(stale, row)
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("error iterating messages: %v", err)
+		}
+		rows.Close()
+
+		for _, row := range stale {
+			plaintext, err := db.content.open(row.content, row.keyID, row.nonce, contentAAD(row.id, row.conversationID, row.bound))
+			if err != nil {
+				return fmt.Errorf("message %d: %w", row.id, err)
+			}
+			content, keyID, nonce, err := db.content.seal(plaintext, contentAAD(row.id, row.conversationID, true))
+			if err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, `
+				UPDATE `+row.table+` SET content = ?, content_key = ?, content_nonce = ?, content_bound = 1 WHERE id = ?
+			`, content, keyID, nonce, row.id); err != nil {
+				return fmt.Errorf("failed to update message %d: %w", row.id, db.checkWrite(err))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return lastID, len(stale), nil
+}
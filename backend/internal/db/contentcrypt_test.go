@@ -0,0 +1,246 @@
+package db_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"messager/internal/db"
+	"messager/internal/db/testdb"
+	"messager/internal/models"
+)
+
+func contentKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, db.ContentKeySize)
+}
+
+func setContentKeys(t *testing.T, d *db.DB, current []byte, previous ...[]byte) {
+	t.Helper()
+	if err := d.SetContentKeys(current, previous...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// storedKeys counts stored messages, archived ones included, by the key
+// their content is sealed with, "" for plaintext, and how many are sealed
+// without being bound to their row
+func storedKeys(t *testing.T, d *db.DB) (map[string]int, int) {
+	t.Helper()
+	rows, err := d.Query(`
+		SELECT COALESCE(content_key, ''), content_bound FROM messages
+		UNION ALL
+		SELECT COALESCE(content_key, ''), content_bound FROM messages_archive
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	keys, unbound := map[string]int{}, 0
+	for rows.Next() {
+		var key string
+		var bound bool
+		if err := rows.Scan(&key, &bound); err != nil {
+			t.Fatal(err)
+		}
+		keys[key]++
+		if key != "" && !bound {
+			unbound++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return keys, unbound
+}
+
+// exportedContent reads a conversation's full history, archive included
+func exportedContent(t *testing.T, d *db.DB, conversationID int64) []string {
+	t.Helper()
+	var contents []string
+	err := d.StreamConversationMessages(context.Background(), conversationID, func(msg *models.ExportedMessage) error {
+		contents = append(contents, msg.Content)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return contents
+}
+
+func reencryptAll(t *testing.T, d *db.DB, batch int) int {
+	t.Helper()
+	afterID, rewritten := int64(0), 0
+	for {
+		lastID, n, err := d.ReencryptMessages(context.Background(), afterID, batch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lastID == 0 {
+			return rewritten
+		}
+		rewritten += n
+		afterID = lastID
+	}
+}
+
+func TestContentEncryptionRoundTrip(t *testing.T) {
+	d := testdb.Open(t)
+	setContentKeys(t, d, contentKey(1))
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+
+	var stored string
+	if err := d.QueryRow(`SELECT content FROM messages WHERE id = ?`, f.Messages[0].ID).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored == "" || stored == "hey bob" {
+		t.Errorf("stored content %q, want ciphertext", stored)
+	}
+	if keys, unbound := storedKeys(t, d); keys[""] != 0 || unbound != 0 {
+		t.Errorf("stored keys %v with %d unbound, want every message sealed and bound", keys, unbound)
+	}
+
+	msg, err := d.GetMessageByID(ctx, f.Messages[0].ID)
+	if err != nil || msg.Content != "hey bob" {
+		t.Fatalf("read back %v, %v", msg, err)
+	}
+	conversations, err := d.GetUserConversations(ctx, f.Alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, conv := range conversations {
+		if want := map[int64]string{f.Direct.ID: "hi alice", f.Group.ID: "thanks!"}[conv.ID]; conv.LastMessage == nil || conv.LastMessage.Content != want {
+			t.Errorf("conversation %d previews %+v, want %q", conv.ID, conv.LastMessage, want)
+		}
+	}
+	if got := exportedContent(t, d, f.Group.ID); len(got) != 2 || got[0] != "welcome to the team" || got[1] != "thanks!" {
+		t.Errorf("exported %q", got)
+	}
+}
+
+func TestContentUnknownKey(t *testing.T) {
+	d := testdb.Open(t)
+	setContentKeys(t, d, contentKey(1))
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+
+	if err := d.SetContentKeys(contentKey(1)[:16]); err == nil {
+		t.Error("a 16 byte key was accepted")
+	}
+
+	// A different key, and no key at all, can't read what key 1 sealed
+	for name, current := range map[string][]byte{"other key": contentKey(2), "no key": nil} {
+		setContentKeys(t, d, current)
+		if _, err := d.GetMessageByID(ctx, f.Messages[0].ID); !errors.Is(err, db.ErrUnknownContentKey) {
+			t.Errorf("%s: %v, want ErrUnknownContentKey", name, err)
+		}
+	}
+
+	// Listing it among the previous keys is enough
+	setContentKeys(t, d, contentKey(2), contentKey(1))
+	if msg, err := d.GetMessageByID(ctx, f.Messages[0].ID); err != nil || msg.Content != "hey bob" {
+		t.Errorf("read with key 1 as a previous key: %v, %v", msg, err)
+	}
+}
+
+func TestContentBoundToMessage(t *testing.T) {
+	d := testdb.Open(t)
+	setContentKeys(t, d, contentKey(1))
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+
+	// Ciphertext and nonce copied over another message, in the same
+	// conversation or another, don't open there
+	for _, target := range []*models.Message{f.Messages[1], f.Messages[2]} {
+		if _, err := d.Exec(`
+			UPDATE messages SET (content, content_key, content_nonce) =
+				(SELECT content, content_key, content_nonce FROM messages WHERE id = ?)
+			WHERE id = ?
+		`, f.Messages[0].ID, target.ID); err != nil {
+			t.Fatal(err)
+		}
+		if msg, err := d.GetMessageByID(ctx, target.ID); err == nil {
+			t.Errorf("message %d opened copied content as %q", target.ID, msg.Content)
+		} else if errors.Is(err, db.ErrUnknownContentKey) {
+			t.Errorf("message %d: %v, want a decryption failure", target.ID, err)
+		}
+	}
+	if msg, err := d.GetMessageByID(ctx, f.Messages[0].ID); err != nil || msg.Content != "hey bob" {
+		t.Errorf("original: %v, %v", msg, err)
+	}
+}
+
+func TestReencryptMessages(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+	var total int
+	if err := d.QueryRow(`SELECT COUNT(*) FROM messages`).Scan(&total); err != nil {
+		t.Fatal(err)
+	}
+
+	// Plaintext history gets encrypted
+	setContentKeys(t, d, contentKey(1))
+	if n := reencryptAll(t, d, 3); n != total {
+		t.Fatalf("encrypting rewrote %d, want all %d", n, total)
+	}
+	keys, _ := storedKeys(t, d)
+	if keys[""] != 0 || len(keys) != 1 {
+		t.Fatalf("stored keys %v, want one key for everything", keys)
+	}
+
+	// A message sealed before content was bound to its row still reads,
+	// and is the only one rewritten on the next pass
+	content, keyID, nonce, err := d.SealUnbound("hey bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Exec(`UPDATE messages SET content = ?, content_key = ?, content_nonce = ?, content_bound = 0 WHERE id = ?`,
+		content, keyID, nonce, f.Messages[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := d.GetMessageByID(ctx, f.Messages[0].ID); err != nil || msg.Content != "hey bob" {
+		t.Fatalf("unbound message: %v, %v", msg, err)
+	}
+	if n := reencryptAll(t, d, 3); n != 1 {
+		t.Errorf("rebinding rewrote %d, want 1", n)
+	}
+	if _, unbound := storedKeys(t, d); unbound != 0 {
+		t.Errorf("%d messages still unbound", unbound)
+	}
+
+	// Archived messages rotate with the rest
+	if _, err := d.Exec(`UPDATE message_outbox SET sent_at = CURRENT_TIMESTAMP`); err != nil {
+		t.Fatal(err)
+	}
+	if moved, err := d.ArchiveMessages(ctx, time.Now().Add(time.Hour), 10); err != nil || moved == 0 {
+		t.Fatalf("archived %d, %v", moved, err)
+	}
+	setContentKeys(t, d, contentKey(2), contentKey(1))
+	if n := reencryptAll(t, d, 3); n != total {
+		t.Errorf("rotating rewrote %d, want all %d", n, total)
+	}
+	if n := reencryptAll(t, d, 3); n != 0 {
+		t.Errorf("a second pass rewrote %d", n)
+	}
+
+	// Key 1 is no longer needed
+	setContentKeys(t, d, contentKey(2))
+	if got := exportedContent(t, d, f.Direct.ID); len(got) != 2 || got[0] != "hey bob" || got[1] != "hi alice" {
+		t.Errorf("direct history %q", got)
+	}
+	if got := exportedContent(t, d, f.Group.ID); len(got) != 2 || got[0] != "welcome to the team" || got[1] != "thanks!" {
+		t.Errorf("group history %q", got)
+	}
+
+	// With no current key, everything is decrypted back to plaintext
+	setContentKeys(t, d, nil, contentKey(2))
+	if n := reencryptAll(t, d, 3); n != total {
+		t.Errorf("decrypting rewrote %d, want all %d", n, total)
+	}
+	if keys, _ := storedKeys(t, d); keys[""] != total {
+		t.Errorf("stored keys %v, want all plaintext", keys)
+	}
+}
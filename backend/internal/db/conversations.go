@@ -0,0 +1,85 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrConversationGone is returned when writing into a conversation that has
+// been deleted, including one deleted while the write was in flight
+var ErrConversationGone = errors.New("conversation no longer exists")
+
+// ErrSelfConversation is returned when asked for a direct conversation
+// between a user and themselves
+var ErrSelfConversation = errors.New("cannot start a direct conversation with yourself")
+
+// DeleteConversation deletes a conversation with its messages, their
+// reports and its participants in one transaction, returning the IDs of
+// the participants it had. Returns ErrNotFound if it doesn't exist.
+func (db *DB) DeleteConversation(ctx context.Context, conversationID int64) ([]int64, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.guardWrite(); err != nil {
+		return nil, err
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", db.checkWrite(err))
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		DELETE FROM conversation_participants WHERE conversation_id = ? RETURNING user_id
+	`, conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to remove participants: %w", db.checkWrite(err))
+	}
+	var participantIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan participant: %v", err)
+		}
+		participantIDs = append(participantIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to remove participants: %w", db.checkWrite(err))
+	}
+
+	deleted, err := deleteConversationRows(ctx, tx, conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete conversation: %w", db.checkWrite(err))
+	}
+	if !deleted {
+		return nil, ErrNotFound
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", db.checkWrite(err))
+	}
+
+	db.names.forgetConversation(conversationID)
+	db.InvalidateMembership(conversationID)
+	return participantIDs, nil
+}
+
+// deleteConversationRows removes a conversation; its participants,
+// messages and their reports go with it (ON DELETE CASCADE). It reports
+// whether the conversation existed.
+func deleteConversationRows(ctx context.Context, tx *sql.Tx, conversationID int64) (bool, error) {
+	result, err := tx.ExecContext(ctx, `DELETE FROM conversations WHERE id = ?`, conversationID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,142 @@
+package db_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"messager/internal/cursor"
+	"messager/internal/db"
+	"messager/internal/db/testdb"
+	"messager/internal/models"
+)
+
+// conversationPages pages through userID's list limit at a time
+func conversationPages(t *testing.T, d *db.DB, userID int64, limit int) [][]int64 {
+	t.Helper()
+	var pages [][]int64
+	var after *cursor.Position
+	for {
+		page, err := d.GetUserConversationsPage(context.Background(), userID, after, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) == 0 {
+			return pages
+		}
+		var ids []int64
+		for _, c := range page {
+			ids = append(ids, c.ID)
+		}
+		pages = append(pages, ids)
+		pos := db.ConversationPosition(page[len(page)-1])
+		after = &pos
+	}
+}
+
+func TestConversationPagesCrossSections(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+	quiet, err := d.CreateConversation(ctx, "Quiet", "group", f.Alice.ID, []int64{f.Alice.ID, f.Bob.ID, f.Carol.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Pinned first, then by latest message, then empty ones
+	if _, err := d.PinConversation(ctx, f.Direct.ID, f.Alice.ID, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []int64{f.Direct.ID, f.Group.ID, quiet.ID}
+	for _, limit := range []int{1, 2, 3, 10} {
+		var got []int64
+		for _, page := range conversationPages(t, d, f.Alice.ID, limit) {
+			if len(page) > limit {
+				t.Errorf("limit %d: page of %d", limit, len(page))
+			}
+			got = append(got, page...)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("limit %d: pages give %v, want %v", limit, got, want)
+		}
+	}
+
+	all, err := d.GetUserConversations(ctx, f.Alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range all {
+		if c.ID != want[i] {
+			t.Errorf("GetUserConversations[%d] = %d, want the paged order %v", i, c.ID, want)
+		}
+	}
+}
+
+func TestConversationPagesSkipMovedConversation(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+	quiet, err := d.CreateConversation(ctx, "Quiet", "group", f.Alice.ID, []int64{f.Alice.ID, f.Bob.ID, f.Carol.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := d.GetUserConversationsPage(ctx, f.Alice.ID, nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 1 || first[0].ID != f.Group.ID {
+		t.Fatalf("first page = %+v, want the group", first)
+	}
+
+	// A message lifts the quiet conversation above the anchor while the
+	// client holds the token. It isn't repeated, and nothing is skipped.
+	if _, err := d.CreateMessage(ctx, quiet.ID, f.Bob.ID, "now it's busy"); err != nil {
+		t.Fatal(err)
+	}
+	pos := db.ConversationPosition(first[0])
+	rest, err := d.GetUserConversationsPage(ctx, f.Alice.ID, &pos, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != 1 || rest[0].ID != f.Direct.ID {
+		t.Errorf("after the group: %d conversations, want only the direct one", len(rest))
+	}
+}
+
+func TestConversationPosition(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+	empty, err := d.CreateConversation(ctx, "Empty", "group", f.Alice.ID, []int64{f.Alice.ID, f.Bob.ID, f.Carol.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinnedAt, err := d.PinConversation(ctx, f.Direct.ID, f.Alice.ID, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byID := make(map[int64]*models.Conversation)
+	all, err := d.GetUserConversations(ctx, f.Alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range all {
+		byID[c.ID] = c
+	}
+	for _, tc := range []struct {
+		name string
+		conv *models.Conversation
+		want cursor.Position
+	}{
+		{"pinned", byID[f.Direct.ID], cursor.Position{Rank: 0, At: pinnedAt, ID: f.Direct.ID}},
+		{"with messages", byID[f.Group.ID], cursor.Position{Rank: 1, At: byID[f.Group.ID].LastMessage.CreatedAt, ID: f.Group.ID}},
+		{"empty", byID[empty.ID], cursor.Position{Rank: 2, At: byID[empty.ID].CreatedAt, ID: empty.ID}},
+	} {
+		got := db.ConversationPosition(tc.conv)
+		if got.Rank != tc.want.Rank || !got.At.Equal(tc.want.At) || got.ID != tc.want.ID {
+			t.Errorf("%s: position %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
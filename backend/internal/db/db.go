@@ -1,19 +1,45 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"messager/internal/chaos"
+	"messager/internal/cursor"
+	"messager/internal/logsafe"
 	"messager/internal/models"
 )
 
 type DB struct {
 	*sql.DB
+	path string
+	pool PoolOptions // see pool.go
+
+	// Read-only pools pure reads take turns on, see replica.go
+	replicas     []*replica
+	nextReplica  atomic.Uint64
+	primaryReads atomic.Int64
+
+	readOnly      atomic.Bool
+	onModeChange  func(readOnly bool)
+	probeInterval time.Duration
+	stmtTimeout   time.Duration
+
+	names   *displayNameCache
+	members *membershipCache // see membership.go
+	stmts   stmtCache        // hot statements, see warmup.go
+	counts  tableCounts      // see stats.go
+	queries *queryLog        // see querylog.go
+	content *contentCipher   // see contentcrypt.go
+	chaos   *chaos.Injector
 }
 
 func NewDB(dbPath string) (*DB, error) {
@@ -23,10 +49,14 @@ func NewDB(dbPath string) (*DB, error) {
 		return nil, fmt.Errorf("error creating database directory: %v", err)
 	}
 
-	db, err := sql.Open("sqlite3", dbPath)
-	if err != nil {
-		return nil, fmt.Errorf("error opening database: %v", err)
-	}
+	// _loc=UTC makes the driver return every DATETIME in UTC regardless of
+	// the offset it was written with or the host timezone
+	// _txlock=immediate takes the write lock at BEGIN so concurrent write
+	// transactions queue on the busy timeout instead of deadlocking
+	// _foreign_keys=1 enforces the schema's REFERENCES clauses, which SQLite
+	// otherwise ignores; violations come back as ErrForeignKey
+	queries := newQueryLog()
+	db := openLogged(dbPath+"?_loc=UTC&_txlock=immediate&_foreign_keys=1", queries)
 
 	if err := db.Ping(); err != nil {
 		return nil, fmt.Errorf("error connecting to the database: %v", err)
@@ -37,7 +67,13 @@ func NewDB(dbPath string) (*DB, error) {
 		return nil, fmt.Errorf("error initializing schema: %v", err)
 	}
 
-	return &DB{db}, nil
+	if err := migrate(db); err != nil {
+		return nil, fmt.Errorf("error migrating schema: %v", err)
+	}
+
+	applyPool(db, DefaultPoolOptions)
+
+	return &DB{DB: db, path: dbPath, pool: DefaultPoolOptions, probeInterval: defaultRecoveryProbeInterval, stmtTimeout: defaultStatementTimeout, names: newDisplayNameCache(), members: newMembershipCache(), queries: queries}, nil
 }
 
 func initSchema(db *sql.DB) error {
@@ -72,6 +108,10 @@ func initSchema(db *sql.DB) error {
 			FOREIGN KEY (conversation_id) REFERENCES conversations(id),
 			FOREIGN KEY (sender_id) REFERENCES users(id)
 		)`,
+		`CREATE TABLE IF NOT EXISTS health_probe (
+			id INTEGER PRIMARY KEY,
+			checked_at DATETIME
+		)`,
 	}
 
 	for _, query := range queries {
@@ -84,13 +124,26 @@ func initSchema(db *sql.DB) error {
 }
 
 // User methods
-func (db *DB) CreateUser(username, password, avatar string) (*models.User, error) {
-	result, err := db.Exec(
+func (db *DB) CreateUser(ctx context.Context, username, password, avatar string) (*models.User, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.guardWrite(); err != nil {
+		return nil, err
+	}
+
+	// The returned user carries the same timestamp as the row, so it
+	// matches what later fetches return
+	now := time.Now().UTC()
+	result, err := db.ExecContext(ctx, 
 		"INSERT INTO users (username, password, avatar, created_at) VALUES (?, ?, ?, ?)",
-		username, password, avatar, time.Now(),
+		username, password, avatar, now,
 	)
+	if isUniqueViolation(err) {
+		return nil, ErrDuplicateUsername
+	}
 	if err != nil {
-		return nil, err
+		return nil, db.checkWrite(err)
 	}
 
 	id, err := result.LastInsertId()
@@ -102,89 +155,197 @@ func (db *DB) CreateUser(username, password, avatar string) (*models.User, error
 		ID:        id,
 		Username:  username,
 		Avatar:    avatar,
-		CreatedAt: time.Now(),
+		CreatedAt: now,
 	}, nil
 }
 
-func (db *DB) GetUserByUsername(username string) (*models.User, error) {
-	log.Printf("Looking up user by username: %s", username)
+func (db *DB) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	log.Printf("Looking up user by username: %s", logsafe.String(username))
 	
 	user := &models.User{}
-	err := db.DB.QueryRow(`
-		SELECT id, username, password, avatar, created_at 
+	err := db.DB.QueryRowContext(ctx, `
+		SELECT id, username, password, avatar, is_bot, created_at 
 		FROM users 
-		WHERE username = ?
-	`, username).Scan(&user.ID, &user.Username, &user.Password, &user.Avatar, &user.CreatedAt)
+		WHERE username = ? AND deleted_at IS NULL
+	`, username).Scan(&user.ID, &user.Username, &user.Password, &user.Avatar, &user.IsBot, &user.CreatedAt)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
-			log.Printf("No user found with username: %s", username)
-			return nil, fmt.Errorf("user not found")
+			log.Printf("No user found with username: %s", logsafe.String(username))
+			return nil, ErrNotFound
 		}
-		log.Printf("Database error looking up user %s: %v", username, err)
+		log.Printf("Database error looking up user %s: %v", logsafe.String(username), err)
 		return nil, fmt.Errorf("database error: %v", err)
 	}
 
-	log.Printf("Successfully found user: %s (ID: %d)", username, user.ID)
+	log.Printf("Successfully found user: %s (ID: %d)", logsafe.String(username), user.ID)
 	return user, nil
 }
 
-func (db *DB) GetUserByID(id int64) (*models.User, error) {
-	var user models.User
-	err := db.QueryRow(
-		"SELECT id, username, password, avatar, created_at FROM users WHERE id = ?",
-		id,
-	).Scan(&user.ID, &user.Username, &user.Password, &user.Avatar, &user.CreatedAt)
+// GetUserByID returns the user with id. A deleted user comes back as a
+// stub with DeletedAt set, so their messages still render; callers that
+// act on behalf of the user must check DeletedAt.
+func (db *DB) GetUserByID(ctx context.Context, id int64) (*models.User, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.chaos.DB(stmtUserByID); err != nil {
+		return nil, err
+	}
+	stmt, err := db.prepared(sqlUserByID)
 	if err != nil {
 		return nil, err
 	}
+	var user models.User
+	var deletedAt sql.NullTime
+	err = stmt.QueryRowContext(ctx, id).Scan(&user.ID, &user.Username, &user.Password, &user.Avatar, &user.IsBot, &user.CreatedAt, &deletedAt)
+	if err != nil {
+		return nil, classify(err)
+	}
+	redactDeleted(&user, deletedAt)
 	return &user, nil
 }
 
-// Conversation methods
-func (db *DB) CreateConversation(name string, convType string, participants []int64) (*models.Conversation, error) {
-	tx, err := db.DB.Begin()
+// GetUsersByIDs looks up several users in one query, keyed by ID. IDs that
+// don't belong to any user are left out, and deleted users are stubs as in
+// GetUserByID. Passwords aren't loaded.
+func (db *DB) GetUsersByIDs(ctx context.Context, userIDs []int64) (map[int64]*models.User, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	users := make(map[int64]*models.User, len(userIDs))
+	if len(userIDs) == 0 {
+		return users, nil
+	}
+	args := make([]interface{}, len(userIDs))
+	for i, id := range userIDs {
+		args[i] = id
+	}
+	rows, err := db.queryRead(ctx, `
+		SELECT id, username, avatar, is_bot, created_at, last_seen_at, deleted_at
+		FROM users
+		WHERE id IN (?`+strings.Repeat(", ?", len(userIDs)-1)+`)
+	`, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+		return nil, fmt.Errorf("failed to look up users: %v", err)
 	}
-	defer tx.Rollback()
+	defer rows.Close()
 
-	// Create conversation
-	result, err := tx.Exec(`
-		INSERT INTO conversations (name, type)
-		VALUES (?, ?)
-	`, name, convType)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create conversation: %v", err)
+	for rows.Next() {
+		user := &models.User{}
+		var lastSeen, deletedAt sql.NullTime
+		if err := rows.Scan(&user.ID, &user.Username, &user.Avatar, &user.IsBot, &user.CreatedAt, &lastSeen, &deletedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan user: %v", err)
+		}
+		if lastSeen.Valid {
+			user.LastSeenAt = &lastSeen.Time
+		}
+		redactDeleted(user, deletedAt)
+		users[user.ID] = user
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating users: %v", err)
 	}
+	return users, nil
+}
 
-	conversationID, err := result.LastInsertId()
+// MissingUserIDs returns the ids in userIDs that don't belong to any user,
+// or belong to a deleted one, in the order given
+func (db *DB) MissingUserIDs(ctx context.Context, userIDs []int64) ([]int64, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if len(userIDs) == 0 {
+		return nil, nil
+	}
+	args := make([]interface{}, len(userIDs))
+	for i, id := range userIDs {
+		args[i] = id
+	}
+	rows, err := db.DB.QueryContext(ctx,
+		`SELECT id FROM users WHERE deleted_at IS NULL AND id IN (?`+strings.Repeat(", ?", len(userIDs)-1)+`)`, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get conversation ID: %v", err)
+		return nil, fmt.Errorf("failed to look up users: %v", err)
 	}
+	defer rows.Close()
 
-	// Add participants
-	for _, userID := range participants {
-		_, err = tx.Exec(`
-			INSERT INTO conversation_participants (conversation_id, user_id)
+	found := make(map[int64]bool, len(userIDs))
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan user: %v", err)
+		}
+		found[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating users: %v", err)
+	}
+
+	var missing []int64
+	for _, id := range userIDs {
+		if !found[id] {
+			missing = append(missing, id)
+		}
+	}
+	return missing, nil
+}
+
+// Conversation methods
+
+// CreateConversation creates a conversation with its participants. The
+// creator becomes the owner of a group.
+func (db *DB) CreateConversation(ctx context.Context, name string, convType string, creatorID int64, participants []int64) (*models.Conversation, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var conversationID int64
+	err := db.WithTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		// Create conversation
+		result, err := tx.ExecContext(ctx, `
+			INSERT INTO conversations (name, type)
 			VALUES (?, ?)
-		`, conversationID, userID)
+		`, name, convType)
 		if err != nil {
-			return nil, fmt.Errorf("failed to add participant %d: %v", userID, err)
+			return fmt.Errorf("failed to create conversation: %w", db.checkWrite(err))
 		}
-	}
 
-	if err = tx.Commit(); err != nil {
-		return nil, fmt.Errorf("failed to commit transaction: %v", err)
+		conversationID, err = result.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("failed to get conversation ID: %v", err)
+		}
+
+		// Add participants
+		for _, userID := range participants {
+			role := models.RoleMember
+			if convType == "group" && userID == creatorID {
+				role = models.RoleOwner
+			}
+			_, err = tx.ExecContext(ctx, `
+				INSERT INTO conversation_participants (conversation_id, user_id, role)
+				VALUES (?, ?, ?)
+			`, conversationID, userID, role)
+			if err != nil {
+				return fmt.Errorf("failed to add participant %d: %w", userID, db.checkWrite(err))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	// A lookup of the ID before it existed may have cached no participants
+	db.InvalidateMembership(conversationID)
 
 	// Fetch the created conversation
 	conversation := &models.Conversation{}
-	err = db.DB.QueryRow(`
-		SELECT id, name, type, created_at
+	err = db.DB.QueryRowContext(ctx, `
+		SELECT id, name, type, COALESCE(topic, ''), created_at
 		FROM conversations
 		WHERE id = ?
-	`, conversationID).Scan(&conversation.ID, &conversation.Name, &conversation.Type, &conversation.CreatedAt)
+	`, conversationID).Scan(&conversation.ID, &conversation.Name, &conversation.Type, &conversation.Topic, &conversation.CreatedAt)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch created conversation: %v", err)
 	}
@@ -192,14 +353,103 @@ func (db *DB) CreateConversation(name string, convType string, participants []in
 	return conversation, nil
 }
 
-func (db *DB) GetUserConversations(userID int64) ([]*models.Conversation, error) {
-	rows, err := db.DB.Query(`
-		SELECT DISTINCT c.id, c.name, c.type, c.created_at
+// GetUserConversations lists a user's conversations, most recently active
+// first. Conversations without messages sort last, newest first among
+// themselves.
+func (db *DB) GetUserConversations(ctx context.Context, userID int64) ([]*models.Conversation, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	return db.queryViewerConversations(ctx, userID, 0, "")
+}
+
+// GetUserConversationsPage returns up to limit of the user's conversations in
+// GetUserConversations order, starting after the conversation at after, or
+// from the top when after is nil. The comparison is on the list's sort key
+// rather than on positions, so a conversation that moves to the top when a
+// message arrives neither shifts the pages after it nor shows up twice.
+func (db *DB) GetUserConversationsPage(ctx context.Context, userID int64, after *cursor.Position, limit int) ([]*models.Conversation, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if after == nil {
+		return db.queryViewerConversations(ctx, userID, limit, "")
+	}
+	anchor := after.At.UTC().Format("2006-01-02 15:04:05.999999999")
+	return db.queryViewerConversations(ctx, userID, limit, `
+		  AND (`+conversationRankSQL+` > ?
+		       OR (`+conversationRankSQL+` = ? AND `+conversationTimeKey+` < unixepoch(?, 'subsec'))
+		       OR (`+conversationRankSQL+` = ? AND `+conversationTimeKey+` = unixepoch(?, 'subsec') AND c.id < ?))`,
+		after.Rank, after.Rank, anchor, after.Rank, anchor, after.ID)
+}
+
+// ConversationPosition is where c sits in its viewer's list, for resuming
+// GetUserConversationsPage after it. It mirrors conversationRankSQL and
+// conversationTimeKey.
+func ConversationPosition(c *models.Conversation) cursor.Position {
+	switch {
+	case c.Membership != nil && c.Membership.PinnedAt != nil:
+		return cursor.Position{Rank: 0, At: *c.Membership.PinnedAt, ID: c.ID}
+	case c.LastMessage != nil:
+		return cursor.Position{Rank: 1, At: c.LastMessage.CreatedAt, ID: c.ID}
+	default:
+		return cursor.Position{Rank: 2, At: c.CreatedAt, ID: c.ID}
+	}
+}
+
+// GetConversationForViewer returns one conversation in the same shape as
+// GetUserConversations, or ErrNotFound when it doesn't exist or the viewer
+// isn't a member
+func (db *DB) GetConversationForViewer(ctx context.Context, conversationID, viewerID int64) (*models.Conversation, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	conversations, err := db.queryViewerConversations(ctx, viewerID, 0, "AND c.id = ?", conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if len(conversations) == 0 {
+		return nil, ErrNotFound
+	}
+	return conversations[0], nil
+}
+
+// The conversation list sorts by section, then by the section's time, newest
+// first, then by id: pinned conversations by when they were pinned, then
+// those with messages by their latest one, then empty ones by creation.
+// The latest message is read from the conversation row, see lastmessage.go.
+const (
+	conversationRankSQL = `CASE WHEN cp.pinned_at IS NOT NULL THEN 0 WHEN c.last_message_id IS NOT NULL THEN 1 ELSE 2 END`
+	conversationTimeKey = `unixepoch(CASE WHEN cp.pinned_at IS NOT NULL THEN cp.pinned_at WHEN c.last_message_id IS NOT NULL THEN c.last_message_at ELSE c.created_at END, 'subsec')`
+)
+
+// queryViewerConversations loads the viewer's conversations with their
+// display name, settings, membership, latest message and participants, in
+// list order. A positive limit caps how many are returned.
+// filter is extra SQL appended to the WHERE clause, with its args.
+func (db *DB) queryViewerConversations(ctx context.Context, viewerID int64, limit int, filter string, filterArgs ...interface{}) ([]*models.Conversation, error) {
+	if err := db.chaos.DB(stmtListConversations); err != nil {
+		return nil, err
+	}
+	args := append([]interface{}{viewerID, viewerID, viewerID}, filterArgs...)
+	page := ""
+	if limit > 0 {
+		page = "LIMIT ?"
+		args = append(args, limit)
+	}
+	rows, err := db.queryRead(ctx, `
+		SELECT DISTINCT c.id, `+viewerDisplayNameSQL+`, `+directAvatarSQL+`, c.type, COALESCE(c.topic, ''), c.created_at, c.retention_days, cp.settings,
+		       cp.joined_at, COALESCE(cp.last_read_message_id, 0), cp.last_read_at, cp.muted_until, cp.mute_mentions, cp.pinned_at, cp.role,
+		       COALESCE(cp.custom_name, ''), cp.unread_count,
+		       lm.id, lm.sender_id, CASE WHEN lu.deleted_at IS NULL THEN COALESCE(lu.username, '') ELSE '`+models.DeletedUsername+`' END, lm.content, lm.content_key, lm.content_nonce, lm.content_bound, lm.message_type, lm.created_at, lm.deleted_at IS NOT NULL
 		FROM conversations c
 		JOIN conversation_participants cp ON c.id = cp.conversation_id
-		WHERE cp.user_id = ?
-		ORDER BY c.created_at DESC
-	`, userID)
+		LEFT JOIN messages lm ON lm.id = c.last_message_id
+		LEFT JOIN users lu ON lu.id = lm.sender_id
+		WHERE cp.user_id = ? `+filter+`
+		ORDER BY `+conversationRankSQL+`, `+conversationTimeKey+` DESC, c.id DESC
+		`+page+`
+	`, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query conversations: %v", err)
 	}
@@ -207,11 +457,42 @@ func (db *DB) GetUserConversations(userID int64) ([]*models.Conversation, error)
 
 	var conversations []*models.Conversation
 	for rows.Next() {
-		conv := &models.Conversation{}
-		err := rows.Scan(&conv.ID, &conv.Name, &conv.Type, &conv.CreatedAt)
+		conv := &models.Conversation{Membership: &models.Membership{}}
+		var settings sql.NullString
+		var retention sql.NullInt64
+		var lastReadAt, mutedUntil, pinnedAt sql.NullTime
+		var muteMentions bool
+		var last lastMessageRow
+		err := rows.Scan(&conv.ID, &conv.Name, &conv.Avatar, &conv.Type, &conv.Topic, &conv.CreatedAt, &retention, &settings,
+			&conv.Membership.JoinedAt, &conv.Membership.LastReadMessageID, &lastReadAt, &mutedUntil, &muteMentions, &pinnedAt, &conv.Membership.Role,
+			&conv.Membership.CustomName, &conv.Membership.UnreadCount,
+			&last.id, &last.senderID, &last.senderUsername, &last.content, &last.contentKey, &last.contentNonce, &last.contentBound, &last.messageType, &last.createdAt, &last.deleted)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan conversation: %v", err)
 		}
+		if last.content.Valid && !last.deleted.Bool {
+			if last.content.String, err = db.content.open(last.content.String, last.contentKey, last.contentNonce, contentAAD(last.id.Int64, conv.ID, last.contentBound.Bool)); err != nil {
+				return nil, fmt.Errorf("failed to read message %d: %w", last.id.Int64, err)
+			}
+		}
+		conv.RetentionDays = retentionDays(retention)
+		if settings.Valid {
+			conv.Settings = json.RawMessage(settings.String)
+		}
+		if lastReadAt.Valid {
+			conv.Membership.LastReadAt = &lastReadAt.Time
+		}
+		if pinnedAt.Valid {
+			conv.Pinned = true
+			conv.Membership.PinnedAt = &pinnedAt.Time
+		}
+		if mutedUntil.Valid {
+			mute := models.MuteState{MutedUntil: &mutedUntil.Time, MuteMentions: muteMentions}
+			if mute.Active(time.Now()) {
+				conv.Membership.MuteState = mute
+			}
+		}
+		conv.LastMessage = last.preview()
 		conversations = append(conversations, conv)
 	}
 
@@ -219,60 +500,434 @@ func (db *DB) GetUserConversations(userID int64) ([]*models.Conversation, error)
 		return nil, fmt.Errorf("error iterating conversations: %v", err)
 	}
 
+	if err := db.attachParticipantSummaries(ctx, conversations); err != nil {
+		return nil, err
+	}
+
 	return conversations, nil
 }
 
+// lastMessageRow holds the LEFT JOINed latest message of a conversation
+type lastMessageRow struct {
+	id             sql.NullInt64
+	senderID       sql.NullInt64
+	senderUsername string
+	content        sql.NullString
+	contentKey     sql.NullString
+	contentNonce   []byte
+	contentBound   sql.NullBool
+	messageType    sql.NullString
+	createdAt      sql.NullTime
+	deleted        sql.NullBool
+}
+
+// preview builds the list preview, or nil when the conversation is empty.
+// Deleted messages keep only their metadata and system messages show their
+// human-readable text rather than the stored JSON.
+func (r lastMessageRow) preview() *models.MessagePreview {
+	if !r.id.Valid {
+		return nil
+	}
+	p := &models.MessagePreview{
+		ID:             r.id.Int64,
+		SenderID:       r.senderID.Int64,
+		SenderUsername: r.senderUsername,
+		MessageType:    r.messageType.String,
+		Deleted:        r.deleted.Bool,
+		CreatedAt:      r.createdAt.Time,
+	}
+	if p.Deleted {
+		return p
+	}
+	content := r.content.String
+	if p.MessageType == models.MessageTypeSystem {
+		var event models.SystemEvent
+		if err := json.Unmarshal([]byte(content), &event); err == nil {
+			content = event.Text
+		}
+	}
+	if runes := []rune(content); len(runes) > models.MaxPreviewLength {
+		content = string(runes[:models.MaxPreviewLength]) + "…"
+		p.Truncated = true
+	}
+	p.Content = content
+	return p
+}
+
 // Message methods
-func (db *DB) CreateMessage(conversationID, senderID int64, content string) (*models.Message, error) {
-	result, err := db.Exec(
-		"INSERT INTO messages (conversation_id, sender_id, content, created_at) VALUES (?, ?, ?, ?)",
-		conversationID, senderID, content, time.Now(),
-	)
+
+// messageColumns selects a message row in the order scanMessage expects.
+// Soft-deleted messages come back as tombstones with empty content.
+const messageColumns = `id, conversation_id, sender_id, seq,
+	CASE WHEN deleted_at IS NULL THEN content ELSE '' END,
+	CASE WHEN deleted_at IS NULL THEN content_key END, content_nonce, content_bound,
+	message_type, created_at, deleted_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanMessage reads a row selected with messageColumns, decrypting its content
+func (db *DB) scanMessage(row rowScanner, msg *models.Message) error {
+	var senderID sql.NullInt64
+	var deletedAt sql.NullTime
+	var contentKey sql.NullString
+	var contentNonce []byte
+	var contentBound bool
+	if err := row.Scan(&msg.ID, &msg.ConversationID, &senderID, &msg.Seq, &msg.Content, &contentKey, &contentNonce, &contentBound, &msg.MessageType, &msg.CreatedAt, &deletedAt); err != nil {
+		return err
+	}
+	content, err := db.content.open(msg.Content, contentKey, contentNonce, contentAAD(msg.ID, msg.ConversationID, contentBound))
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("failed to read message %d: %w", msg.ID, err)
+	}
+	msg.Content = content
+	msg.SenderID = senderID.Int64
+	msg.DeletedAt = nil
+	if deletedAt.Valid {
+		msg.DeletedAt = &deletedAt.Time
 	}
+	return nil
+}
 
-	id, err := result.LastInsertId()
+// insertMessage writes msg and assigns its ID and per-conversation sequence
+// number. The conversation's counter row is bumped in the same transaction
+// as the insert, so concurrent sends never share or skip a seq. A zero
+// SenderID is stored as NULL (system messages).
+func (db *DB) insertMessage(ctx context.Context, msg *models.Message) error {
+	if err := db.chaos.DB(stmtInsertMessage); err != nil {
+		return err
+	}
+
+	var id, seq int64
+	err := db.WithTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		id, seq, err = db.insertMessageTx(ctx, tx, msg)
+		if err != nil {
+			return err
+		}
+
+		// The outbox row commits with the message, so a crash before fan-out
+		// leaves a record that it still has to be delivered
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO message_outbox (message_id, created_at) VALUES (?, ?)
+		`, id, time.Now().UTC()); err != nil {
+			return db.checkWrite(err)
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return &models.Message{
-		ID:             id,
+	msg.ID = id
+	msg.Seq = seq
+	return nil
+}
+
+// insertMessageTx does the work of insertMessage inside tx, returning the
+// new message's ID and seq without setting them on msg
+func (db *DB) insertMessageTx(ctx context.Context, tx *sql.Tx, msg *models.Message) (id, seq int64, err error) {
+	err = tx.QueryRowContext(ctx, `
+		UPDATE conversations SET last_seq = last_seq + 1 WHERE id = ? RETURNING last_seq
+	`, msg.ConversationID).Scan(&seq)
+	// The conversation was deleted, possibly after the sender's membership
+	// was checked. Bumping last_seq first means a message can never be
+	// written into a conversation a concurrent delete has removed.
+	if err == sql.ErrNoRows {
+		return 0, 0, ErrConversationGone
+	}
+	if err != nil {
+		return 0, 0, db.checkWrite(err)
+	}
+
+	var senderID sql.NullInt64
+	if msg.SenderID != 0 {
+		senderID = sql.NullInt64{Int64: msg.SenderID, Valid: true}
+	}
+
+	// Sealed content is bound to the message ID, so it's written once the
+	// row has one; plaintext never reaches the row in between
+	content := msg.Content
+	if db.content.sealing() {
+		content = ""
+	}
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO messages (conversation_id, sender_id, content, message_type, seq, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, msg.ConversationID, senderID, content, msg.MessageType, seq, msg.CreatedAt)
+	if err != nil {
+		return 0, 0, db.checkWrite(err)
+	}
+
+	id, err = result.LastInsertId()
+	if err != nil {
+		return 0, 0, err
+	}
+	if db.content.sealing() {
+		content, contentKey, contentNonce, err := db.content.seal(msg.Content, contentAAD(id, msg.ConversationID, true))
+		if err != nil {
+			return 0, 0, err
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE messages SET content = ?, content_key = ?, content_nonce = ? WHERE id = ?
+		`, content, contentKey, contentNonce, id); err != nil {
+			return 0, 0, db.checkWrite(err)
+		}
+	}
+
+	if err := bumpUnread(ctx, tx, msg, senderID); err != nil {
+		return 0, 0, db.checkWrite(err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE conversations SET last_message_id = ?, last_message_at = ? WHERE id = ?
+	`, id, msg.CreatedAt, msg.ConversationID); err != nil {
+		return 0, 0, db.checkWrite(err)
+	}
+	return id, seq, nil
+}
+
+// ImportMessages stores a batch of past messages in one transaction,
+// assigning their IDs and seqs the way CreateMessageAt does. They are not
+// queued for delivery. Each conversation's messages must be in time order.
+func (db *DB) ImportMessages(ctx context.Context, messages []*models.Message) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	ids := make([][2]int64, len(messages))
+	err := db.WithTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		for i, msg := range messages {
+			if msg.MessageType == "" {
+				msg.MessageType = models.MessageTypeUser
+			}
+			msg.CreatedAt = msg.CreatedAt.UTC()
+			id, seq, err := db.insertMessageTx(ctx, tx, msg)
+			if err != nil {
+				return fmt.Errorf("failed to import message into conversation %d: %w", msg.ConversationID, err)
+			}
+			ids[i] = [2]int64{id, seq}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, msg := range messages {
+		msg.ID, msg.Seq = ids[i][0], ids[i][1]
+	}
+	return nil
+}
+
+func (db *DB) CreateMessage(ctx context.Context, conversationID, senderID int64, content string) (*models.Message, error) {
+	return db.CreateMessageAt(ctx, conversationID, senderID, content, time.Now())
+}
+
+// CreateMessageAt is CreateMessage with the send time given, for generating
+// or importing history. A conversation's messages must be created in time
+// order, or their seq won't follow created_at.
+func (db *DB) CreateMessageAt(ctx context.Context, conversationID, senderID int64, content string, at time.Time) (*models.Message, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	msg := &models.Message{
 		ConversationID: conversationID,
 		SenderID:       senderID,
 		Content:        content,
-		CreatedAt:      time.Now(),
-	}, nil
+		MessageType:    models.MessageTypeUser,
+		CreatedAt:      at.UTC(),
+	}
+	if err := db.insertMessage(ctx, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
 }
 
-func (db *DB) GetConversationMessages(conversationID int64, limit, offset int) ([]models.Message, error) {
-	rows, err := db.Query(`
-		SELECT id, conversation_id, sender_id, content, created_at
-		FROM messages
-		WHERE conversation_id = ?
-		ORDER BY created_at DESC
-		LIMIT ? OFFSET ?
-	`, conversationID, limit, offset)
+// CreateSystemMessage records a membership or conversation event in the
+// history. System messages have no sender.
+func (db *DB) CreateSystemMessage(ctx context.Context, conversationID int64, content string) (*models.Message, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	msg := &models.Message{
+		ConversationID: conversationID,
+		Content:        content,
+		MessageType:    models.MessageTypeSystem,
+		CreatedAt:      time.Now().UTC(),
+	}
+	if err := db.insertMessage(ctx, msg); err != nil {
+		return nil, fmt.Errorf("failed to create system message: %w", err)
+	}
+	return msg, nil
+}
+
+// messageTimeKey compares message timestamps by value rather than by their
+// stored text, which varies in precision and offset suffix between rows
+// written by SQLite defaults and rows written by the driver
+const messageTimeKey = `unixepoch(created_at, 'subsec')`
+
+// GetConversationMessages returns a page of messages, newest first. The
+// archive is only read once the page reaches back to it.
+func (db *DB) GetConversationMessages(ctx context.Context, conversationID int64, limit, offset int) ([]models.Message, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.chaos.DB(stmtListMessages); err != nil {
+		return nil, err
+	}
+	messages, err := db.queryMessagesPage(ctx, "messages", conversationID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	boundary, err := db.archiveBoundary(ctx, conversationID)
+	if err != nil || !pastArchiveBoundary(messages, limit, boundary) {
+		return messages, err
+	}
+
+	// Offsets count across both tables, so take everything up to the end
+	// of the page from each and merge
+	hot, err := db.queryMessagesPage(ctx, "messages", conversationID, offset+limit, 0)
 	if err != nil {
 		return nil, err
 	}
+	archived, err := db.queryMessagesPage(ctx, "messages_archive", conversationID, offset+limit, 0)
+	if err != nil {
+		return nil, err
+	}
+	merged := mergeNewestFirst(hot, archived)
+	if offset >= len(merged) {
+		return nil, nil
+	}
+	if offset+limit < len(merged) {
+		merged = merged[:offset+limit]
+	}
+	return merged[offset:], nil
+}
+
+// GetConversationMessagesBefore returns up to limit messages older than
+// before, newest first, or the newest messages when before is nil. The
+// comparison is on (created_at, id) values, so it works even if the message
+// at before has since been deleted. The archive is only read once the page
+// reaches back to it.
+func (db *DB) GetConversationMessagesBefore(ctx context.Context, conversationID int64, before *cursor.Position, limit int) ([]models.Message, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.chaos.DB(stmtListMessages); err != nil {
+		return nil, err
+	}
+	messages, err := db.queryMessagesBefore(ctx, "messages", conversationID, before, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query messages: %v", err)
+	}
+	boundary, err := db.archiveBoundary(ctx, conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive boundary: %v", err)
+	}
+	if !pastArchiveBoundary(messages, limit, boundary) {
+		return messages, nil
+	}
+
+	archived, err := db.queryMessagesBefore(ctx, "messages_archive", conversationID, before, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query archived messages: %v", err)
+	}
+	messages = mergeNewestFirst(messages, archived)
+	if len(messages) > limit {
+		messages = messages[:limit]
+	}
+	return messages, nil
+}
+
+// GetMessagesSince returns up to limit messages newer than a client last
+// saw across every conversation userID is in, oldest first. A
+// conversation's entry in perConversation is the last message ID seen
+// there; conversations without one use since.
+func (db *DB) GetMessagesSince(ctx context.Context, userID, since int64, perConversation map[int64]int64, limit int) ([]models.Message, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	floor := "?"
+	args := []interface{}{userID}
+	if len(perConversation) > 0 {
+		floor = "CASE conversation_id"
+		for conversationID, lastID := range perConversation {
+			floor += " WHEN ? THEN ?"
+			args = append(args, conversationID, lastID)
+		}
+		floor += " ELSE ? END"
+	}
+	args = append(args, since, limit)
+
+	rows, err := db.queryRead(ctx, `
+		SELECT `+messageColumns+`
+		FROM messages
+		WHERE conversation_id IN (SELECT conversation_id FROM conversation_participants WHERE user_id = ?)
+		  AND id > `+floor+`
+		ORDER BY id ASC
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query messages: %v", err)
+	}
 	defer rows.Close()
 
 	var messages []models.Message
 	for rows.Next() {
 		var msg models.Message
-		if err := rows.Scan(&msg.ID, &msg.ConversationID, &msg.SenderID, &msg.Content, &msg.CreatedAt); err != nil {
-			return nil, err
+		if err := db.scanMessage(rows, &msg); err != nil {
+			return nil, fmt.Errorf("failed to scan message: %v", err)
+		}
+		messages = append(messages, msg)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating messages: %v", err)
+	}
+
+	return messages, nil
+}
+
+// GetMessagesAfterSeq returns up to limit messages with seq greater than
+// afterSeq in ascending order, for clients repairing a gap
+func (db *DB) GetMessagesAfterSeq(ctx context.Context, conversationID, afterSeq int64, limit int) ([]models.Message, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.queryRead(ctx, `
+		SELECT `+messageColumns+`
+		FROM messages
+		WHERE conversation_id = ? AND seq > ?
+		ORDER BY seq ASC
+		LIMIT ?
+	`, conversationID, afterSeq, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query messages: %v", err)
+	}
+	defer rows.Close()
+
+	var messages []models.Message
+	for rows.Next() {
+		var msg models.Message
+		if err := db.scanMessage(rows, &msg); err != nil {
+			return nil, fmt.Errorf("failed to scan message: %v", err)
 		}
 		messages = append(messages, msg)
 	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating messages: %v", err)
+	}
+
 	return messages, nil
 }
 
-func (db *DB) GetConversationParticipants(conversationID int64) ([]models.User, error) {
-	rows, err := db.Query(`
-		SELECT u.id, u.username, u.avatar, u.created_at
+func (db *DB) GetConversationParticipants(ctx context.Context, conversationID int64) ([]models.User, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT u.id, `+visibleUsernameSQL+`, `+visibleAvatarSQL+`, u.created_at
 		FROM users u
 		JOIN conversation_participants cp ON u.id = cp.user_id
 		WHERE cp.conversation_id = ?
@@ -293,11 +948,15 @@ func (db *DB) GetConversationParticipants(conversationID int64) ([]models.User,
 	return participants, nil
 }
 
-// GetAllUsers returns all users in the database
-func (db *DB) GetAllUsers() ([]*models.User, error) {
-	rows, err := db.DB.Query(`
-		SELECT id, username, password, avatar, created_at 
+// GetAllUsers returns all users in the database except deleted ones
+func (db *DB) GetAllUsers(ctx context.Context) ([]*models.User, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.queryRead(ctx, `
+		SELECT id, username, password, avatar, is_bot, created_at, last_seen_at
 		FROM users 
+		WHERE deleted_at IS NULL
 		ORDER BY username
 	`)
 	if err != nil {
@@ -308,22 +967,30 @@ func (db *DB) GetAllUsers() ([]*models.User, error) {
 	var users []*models.User
 	for rows.Next() {
 		user := &models.User{}
-		err := rows.Scan(&user.ID, &user.Username, &user.Password, &user.Avatar, &user.CreatedAt)
+		var lastSeen sql.NullTime
+		err := rows.Scan(&user.ID, &user.Username, &user.Password, &user.Avatar, &user.IsBot, &user.CreatedAt, &lastSeen)
 		if err != nil {
 			return nil, err
 		}
+		if lastSeen.Valid {
+			user.LastSeenAt = &lastSeen.Time
+		}
 		users = append(users, user)
 	}
 	return users, nil
 }
 
-// SearchUsers searches for users by username with case-insensitive partial matching
-func (db *DB) SearchUsers(query string) ([]*models.User, error) {
+// SearchUsers searches for users by username with case-insensitive partial
+// matching. Deleted users are never found.
+func (db *DB) SearchUsers(ctx context.Context, query string) ([]*models.User, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	// Use LIKE with case-insensitive matching and limit results
-	rows, err := db.DB.Query(`
-		SELECT id, username, avatar, created_at 
+	rows, err := db.queryRead(ctx, `
+		SELECT id, username, avatar, is_bot, created_at, last_seen_at
 		FROM users 
-		WHERE username LIKE ? COLLATE NOCASE
+		WHERE username LIKE ? COLLATE NOCASE AND deleted_at IS NULL
 		ORDER BY 
 			CASE 
 				WHEN username LIKE ? COLLATE NOCASE THEN 1  -- Exact match
@@ -341,10 +1008,14 @@ func (db *DB) SearchUsers(query string) ([]*models.User, error) {
 	var users []*models.User
 	for rows.Next() {
 		user := &models.User{}
-		err := rows.Scan(&user.ID, &user.Username, &user.Avatar, &user.CreatedAt)
+		var lastSeen sql.NullTime
+		err := rows.Scan(&user.ID, &user.Username, &user.Avatar, &user.IsBot, &user.CreatedAt, &lastSeen)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan user: %v", err)
 		}
+		if lastSeen.Valid {
+			user.LastSeenAt = &lastSeen.Time
+		}
 		users = append(users, user)
 	}
 
@@ -355,79 +1026,303 @@ func (db *DB) SearchUsers(query string) ([]*models.User, error) {
 	return users, nil
 }
 
-// SaveMessage saves a new message to the database
-func (db *DB) SaveMessage(message *models.Message) (*models.Message, error) {
-	result, err := db.DB.Exec(`
-		INSERT INTO messages (conversation_id, sender_id, content, created_at)
-		VALUES (?, ?, ?, ?)
-	`, message.ConversationID, message.SenderID, message.Content, message.CreatedAt)
+// SaveMessage saves a new message to the database, stamped with the
+// server's current time whatever CreatedAt the caller set. Callers that
+// need a given send time use CreateMessageAt.
+func (db *DB) SaveMessage(ctx context.Context, message *models.Message) (*models.Message, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	message.CreatedAt = time.Now().UTC()
+	message.MessageType = models.MessageTypeUser
+	if err := db.insertMessage(ctx, message); err != nil {
+		return nil, fmt.Errorf("failed to save message: %w", err)
+	}
+	return message, nil
+}
+
+// GetConversationParticipantIDs returns all participant IDs for a
+// conversation, cached for a short while (see membership.go)
+func (db *DB) GetConversationParticipantIDs(ctx context.Context, conversationID int64) ([]int64, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.chaos.DB(stmtParticipantIDs); err != nil {
+		return nil, err
+	}
+	members, err := db.participants(ctx, conversationID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to save message: %v", err)
+		return nil, err
 	}
+	// Callers may modify the slice, and the cached one is shared
+	return append([]int64(nil), members.ids...), nil
+}
 
-	id, err := result.LastInsertId()
+// directKey identifies the single direct conversation between two users
+func directKey(userA, userB int64) string {
+	if userA > userB {
+		userA, userB = userB, userA
+	}
+	return fmt.Sprintf("%d:%d", userA, userB)
+}
+
+// GetExistingDirectConversation returns the direct conversation between two
+// users, or nil if they've never talked. A conversation holding the pair's
+// key only counts if its members are exactly those two users, so a
+// malformed one with extra or missing members is never handed back as
+// their DM.
+func (db *DB) GetExistingDirectConversation(ctx context.Context, userID1, userID2 int64) (*models.Conversation, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if userID1 == userID2 {
+		return nil, ErrSelfConversation
+	}
+	conv := &models.Conversation{}
+	err := db.DB.QueryRowContext(ctx, `
+		SELECT c.id, c.name, c.type, COALESCE(c.topic, ''), c.created_at
+		FROM conversations c
+		JOIN conversation_participants cp ON cp.conversation_id = c.id
+		WHERE c.direct_key = ? AND c.type = 'direct'
+		GROUP BY c.id
+		HAVING COUNT(*) = 2 AND SUM(cp.user_id IN (?, ?)) = 2
+	`, directKey(userID1, userID2), userID1, userID2).Scan(&conv.ID, &conv.Name, &conv.Type, &conv.Topic, &conv.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to get message ID: %v", err)
+		return nil, fmt.Errorf("failed to query existing conversation: %v", err)
 	}
+	return conv, nil
+}
 
-	message.ID = id
-	return message, nil
+// GetOrCreateDirectConversation returns the one direct conversation between
+// two users, creating it if needed. created reports whether this call made
+// it; a concurrent create for the same pair loses on the unique direct_key
+// and gets the winner's conversation.
+func (db *DB) GetOrCreateDirectConversation(ctx context.Context, userID, otherUserID int64, name string) (conv *models.Conversation, created bool, err error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if conv, err := db.GetExistingDirectConversation(ctx, userID, otherUserID); err != nil || conv != nil {
+		return conv, false, err
+	}
+	if err := db.guardWrite(); err != nil {
+		return nil, false, err
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to begin transaction: %w", db.checkWrite(err))
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO conversations (name, type, direct_key)
+		VALUES (?, 'direct', ?)
+		ON CONFLICT(direct_key) DO NOTHING
+	`, name, directKey(userID, otherUserID))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create conversation: %w", db.checkWrite(err))
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		tx.Rollback()
+		conv, err := db.GetExistingDirectConversation(ctx, userID, otherUserID)
+		if err == nil && conv == nil {
+			// The key is taken by a conversation that isn't a clean pair
+			err = fmt.Errorf("direct conversation %s has unexpected participants", directKey(userID, otherUserID))
+		}
+		return conv, false, err
+	}
+
+	conversationID, err := result.LastInsertId()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get conversation ID: %v", err)
+	}
+	for _, participant := range []int64{userID, otherUserID} {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO conversation_participants (conversation_id, user_id)
+			VALUES (?, ?)
+		`, conversationID, participant); err != nil {
+			return nil, false, fmt.Errorf("failed to add participant %d: %w", participant, db.checkWrite(err))
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, false, fmt.Errorf("failed to commit transaction: %w", db.checkWrite(err))
+	}
+	db.InvalidateMembership(conversationID)
+
+	conv, err = db.GetConversationByID(ctx, conversationID)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to fetch created conversation: %v", err)
+	}
+	return conv, true, nil
 }
 
-// GetConversationParticipantIDs returns all participant IDs for a conversation
-func (db *DB) GetConversationParticipantIDs(conversationID int64) ([]int64, error) {
-	rows, err := db.DB.Query(`
-		SELECT user_id
-		FROM conversation_participants
-		WHERE conversation_id = ?
+// GetConversationByID returns a single conversation
+func (db *DB) GetConversationByID(ctx context.Context, conversationID int64) (*models.Conversation, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	conv := &models.Conversation{}
+	var retention sql.NullInt64
+	err := db.DB.QueryRowContext(ctx, `
+		SELECT id, name, type, COALESCE(topic, ''), created_at, retention_days
+		FROM conversations
+		WHERE id = ?
+	`, conversationID).Scan(&conv.ID, &conv.Name, &conv.Type, &conv.Topic, &conv.CreatedAt, &retention)
+	if err != nil {
+		return nil, classify(err)
+	}
+	conv.RetentionDays = retentionDays(retention)
+	return conv, nil
+}
+
+// IsConversationParticipant reports whether a user belongs to a
+// conversation, from the cached participant set unless caching is off
+func (db *DB) IsConversationParticipant(ctx context.Context, conversationID, userID int64) (bool, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.chaos.DB(stmtIsParticipant); err != nil {
+		return false, err
+	}
+	if db.members.ttl.Load() > 0 {
+		members, err := db.participants(ctx, conversationID)
+		if err != nil {
+			return false, fmt.Errorf("failed to check participant: %v", err)
+		}
+		_, ok := members.set[userID]
+		return ok, nil
+	}
+
+	stmt, err := db.prepared(sqlIsParticipant)
+	if err != nil {
+		return false, fmt.Errorf("failed to check participant: %v", err)
+	}
+	var exists int
+	err = stmt.QueryRowContext(ctx, conversationID, userID).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check participant: %v", err)
+	}
+	return true, nil
+}
+
+// StreamConversationMessages walks the full history of a conversation,
+// archived messages included, oldest first by the same time key and ID
+// history pages use, calling fn for each row without buffering the result
+// set
+func (db *DB) StreamConversationMessages(ctx context.Context, conversationID int64, fn func(*models.ExportedMessage) error) error {
+	rows, err := db.queryRead(ctx, `
+		SELECT m.id, m.conversation_id, m.sender_id, COALESCE(`+visibleUsernameSQL+`, ''), m.content, m.content_key, m.content_nonce, m.content_bound, m.message_type, m.created_at
+		FROM (
+			SELECT `+archiveColumns+` FROM messages_archive
+			UNION ALL
+			SELECT `+archiveColumns+` FROM messages
+		) m
+		LEFT JOIN users u ON u.id = m.sender_id
+		WHERE m.conversation_id = ? AND m.deleted_at IS NULL
+		ORDER BY unixepoch(m.created_at, 'subsec') ASC, m.id ASC
 	`, conversationID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get participants: %v", err)
+		return fmt.Errorf("failed to query messages: %v", err)
 	}
 	defer rows.Close()
 
-	var participantIDs []int64
+	msg := &models.ExportedMessage{}
+	var senderID sql.NullInt64
+	var contentKey sql.NullString
+	var contentNonce []byte
+	var contentBound bool
 	for rows.Next() {
-		var id int64
-		if err := rows.Scan(&id); err != nil {
-			return nil, fmt.Errorf("failed to scan participant ID: %v", err)
+		if err := rows.Scan(&msg.ID, &msg.ConversationID, &senderID, &msg.SenderUsername, &msg.Content, &contentKey, &contentNonce, &contentBound, &msg.MessageType, &msg.CreatedAt); err != nil {
+			return fmt.Errorf("failed to scan message: %v", err)
+		}
+		msg.SenderID = nil
+		if senderID.Valid {
+			msg.SenderID = &senderID.Int64
+		}
+		content, err := db.content.open(msg.Content, contentKey, contentNonce, contentAAD(msg.ID, msg.ConversationID, contentBound))
+		if err != nil {
+			return fmt.Errorf("failed to read message %d: %w", msg.ID, err)
+		}
+		msg.Content = content
+		if err := fn(msg); err != nil {
+			return err
 		}
-		participantIDs = append(participantIDs, id)
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating participants: %v", err)
+		return fmt.Errorf("error iterating messages: %v", err)
 	}
 
-	return participantIDs, nil
+	return nil
 }
 
-// GetExistingDirectConversation checks if a direct conversation exists between two users
-func (db *DB) GetExistingDirectConversation(userID1, userID2 int64) (*models.Conversation, error) {
-	// Find conversations where both users are participants
-	rows, err := db.DB.Query(`
-		SELECT DISTINCT c.id, c.name, c.type, c.created_at
-		FROM conversations c
-		JOIN conversation_participants cp1 ON c.id = cp1.conversation_id
-		JOIN conversation_participants cp2 ON c.id = cp2.conversation_id
-		WHERE c.type = 'direct'
-		AND cp1.user_id = ?
-		AND cp2.user_id = ?
-	`, userID1, userID2)
+// UpdateConversationDetails sets a conversation's name and topic; an empty
+// topic is stored as NULL
+func (db *DB) UpdateConversationDetails(ctx context.Context, conversationID int64, name, topic string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.guardWrite(); err != nil {
+		return err
+	}
+
+	var topicValue interface{}
+	if topic != "" {
+		topicValue = topic
+	}
+	result, err := db.DB.ExecContext(ctx, `
+		UPDATE conversations SET name = ?, topic = ? WHERE id = ?
+	`, name, topicValue, conversationID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query existing conversation: %v", err)
+		return fmt.Errorf("failed to update conversation: %w", db.checkWrite(err))
 	}
-	defer rows.Close()
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
 
-	// There should be at most one such conversation
-	if rows.Next() {
-		conv := &models.Conversation{}
-		err := rows.Scan(&conv.ID, &conv.Name, &conv.Type, &conv.CreatedAt)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan conversation: %v", err)
-		}
-		return conv, nil
+// SetConversationMirror opts a conversation in or out of broker mirroring
+func (db *DB) SetConversationMirror(ctx context.Context, conversationID int64, enabled bool) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.guardWrite(); err != nil {
+		return err
 	}
 
-	return nil, nil
-} 
\ No newline at end of file
+	result, err := db.DB.ExecContext(ctx, `
+		UPDATE conversations SET mirror_enabled = ? WHERE id = ?
+	`, enabled, conversationID)
+	if err != nil {
+		return fmt.Errorf("failed to update mirror setting: %w", db.checkWrite(err))
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// IsConversationMirrored reports whether a conversation opted in to broker mirroring
+func (db *DB) IsConversationMirrored(ctx context.Context, conversationID int64) (bool, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var enabled bool
+	err := db.DB.QueryRowContext(ctx, `
+		SELECT mirror_enabled FROM conversations WHERE id = ?
+	`, conversationID).Scan(&enabled)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read mirror setting: %v", err)
+	}
+	return enabled, nil
+}
@@ -0,0 +1,101 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"syscall"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// ErrReadOnly is returned by write methods while the database is in degraded
+// read-only mode after a persistent write failure (disk full, read-only
+// filesystem). Reads keep working.
+var ErrReadOnly = errors.New("database is in read-only mode")
+
+const defaultRecoveryProbeInterval = 10 * time.Second
+
+// ReadOnly reports whether the database is currently in degraded mode
+func (db *DB) ReadOnly() bool {
+	return db.readOnly.Load()
+}
+
+// OnModeChange registers a callback invoked whenever the database enters or
+// leaves read-only mode. It must be set before the server starts serving.
+func (db *DB) OnModeChange(fn func(readOnly bool)) {
+	db.onModeChange = fn
+}
+
+// SetRecoveryProbeInterval sets how often a degraded database retries a
+// probe write to detect recovery
+func (db *DB) SetRecoveryProbeInterval(interval time.Duration) {
+	if interval > 0 {
+		db.probeInterval = interval
+	}
+}
+
+// guardWrite rejects writes up front while degraded so callers get a
+// consistent error instead of whatever the driver happens to return
+func (db *DB) guardWrite() error {
+	if db.readOnly.Load() {
+		return ErrReadOnly
+	}
+	return nil
+}
+
+// checkWrite inspects an error from a write path. Persistent failures flip
+// the database into read-only mode and are reported as ErrReadOnly.
+func (db *DB) checkWrite(err error) error {
+	if err == nil || !isPersistentWriteFailure(err) {
+		return classify(err)
+	}
+	db.enterReadOnly(err)
+	return fmt.Errorf("%w: %v", ErrReadOnly, err)
+}
+
+func (db *DB) enterReadOnly(cause error) {
+	if !db.readOnly.CompareAndSwap(false, true) {
+		return
+	}
+	log.Printf("Database entering read-only mode: %v", cause)
+	if db.onModeChange != nil {
+		db.onModeChange(true)
+	}
+	go db.probeUntilRecovered()
+}
+
+// probeUntilRecovered periodically attempts a tiny write and leaves
+// read-only mode as soon as one succeeds
+func (db *DB) probeUntilRecovered() {
+	ticker := time.NewTicker(db.probeInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if _, err := db.DB.Exec(`
+			INSERT OR REPLACE INTO health_probe (id, checked_at) VALUES (1, ?)
+		`, time.Now().UTC()); err != nil {
+			log.Printf("Database still read-only: %v", err)
+			continue
+		}
+
+		db.readOnly.Store(false)
+		log.Println("Database recovered, leaving read-only mode")
+		if db.onModeChange != nil {
+			db.onModeChange(false)
+		}
+		return
+	}
+}
+
+func isPersistentWriteFailure(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code {
+		case sqlite3.ErrFull, sqlite3.ErrReadonly, sqlite3.ErrIoErr:
+			return true
+		}
+	}
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EROFS)
+}
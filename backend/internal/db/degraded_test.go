@@ -0,0 +1,54 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+
+	"messager/internal/db"
+	"messager/internal/db/testdb"
+)
+
+func TestPersistentWriteFailureEntersReadOnlyUntilAProbeSucceeds(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+	d.SetRecoveryProbeInterval(20 * time.Millisecond)
+	modes := make(chan bool, 2)
+	d.OnModeChange(func(readOnly bool) { modes <- readOnly })
+
+	// A transient failure is passed through without degrading
+	if err := d.CheckWrite(errors.New("constraint failed")); errors.Is(err, db.ErrReadOnly) || d.ReadOnly() {
+		t.Fatalf("a one-off error entered read-only mode: %v", err)
+	}
+
+	err := d.CheckWrite(fmt.Errorf("write: %w", syscall.ENOSPC))
+	if !errors.Is(err, db.ErrReadOnly) || !d.ReadOnly() {
+		t.Fatalf("disk full didn't enter read-only mode: %v", err)
+	}
+	if readOnly := <-modes; !readOnly {
+		t.Fatal("mode change reported leaving read-only mode first")
+	}
+	if _, err := d.CreateMessage(ctx, f.Group.ID, f.Alice.ID, "while degraded"); !errors.Is(err, db.ErrReadOnly) {
+		t.Errorf("write while degraded: %v, want ErrReadOnly", err)
+	}
+	if _, err := d.GetConversationMessages(ctx, f.Group.ID, 10, 0); err != nil {
+		t.Errorf("read while degraded: %v", err)
+	}
+
+	// The disk is fine, so the first probe write recovers
+	select {
+	case readOnly := <-modes:
+		if readOnly {
+			t.Fatal("mode change reported read-only twice")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("never left read-only mode")
+	}
+	if _, err := d.CreateMessage(ctx, f.Group.ID, f.Alice.ID, "after recovery"); err != nil {
+		t.Errorf("write after recovery: %v", err)
+	}
+}
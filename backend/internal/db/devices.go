@@ -0,0 +1,145 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"messager/internal/models"
+)
+
+const deviceColumns = `id, device_id, name, platform, created_at, last_seen_at`
+
+// RegisterDevice records a login or connection from one of a user's
+// devices, creating it on first sight. An empty name or platform keeps
+// what was registered before.
+func (db *DB) RegisterDevice(ctx context.Context, userID int64, deviceID, name, platform string) (*models.Device, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.guardWrite(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	var device models.Device
+	err := db.DB.QueryRowContext(ctx, `
+		INSERT INTO devices (user_id, device_id, name, platform, created_at, last_seen_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (user_id, device_id) DO UPDATE SET
+			name = COALESCE(NULLIF(excluded.name, ''), name),
+			platform = COALESCE(NULLIF(excluded.platform, ''), platform),
+			last_seen_at = excluded.last_seen_at
+		RETURNING `+deviceColumns,
+		userID, deviceID, name, platform, now, now,
+	).Scan(&device.ID, &device.DeviceID, &device.Name, &device.Platform, &device.CreatedAt, &device.LastSeenAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register device: %w", db.checkWrite(err))
+	}
+	return &device, nil
+}
+
+// DeviceRegistered reports whether the device registration id still exists
+// for the user, i.e. hasn't been revoked
+func (db *DB) DeviceRegistered(ctx context.Context, userID, id int64) (bool, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var exists bool
+	err := db.DB.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM devices WHERE id = ? AND user_id = ?)
+	`, id, userID).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to look up device: %v", err)
+	}
+	return exists, nil
+}
+
+// TouchDevice updates when a device was last seen
+func (db *DB) TouchDevice(ctx context.Context, id int64) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.guardWrite(); err != nil {
+		return err
+	}
+	if _, err := db.DB.ExecContext(ctx, `
+		UPDATE devices SET last_seen_at = ? WHERE id = ?
+	`, time.Now().UTC(), id); err != nil {
+		return fmt.Errorf("failed to update device: %w", db.checkWrite(err))
+	}
+	return nil
+}
+
+// ListDevices returns a user's devices, most recently seen first
+func (db *DB) ListDevices(ctx context.Context, userID int64) ([]models.Device, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.queryRead(ctx, `
+		SELECT `+deviceColumns+`
+		FROM devices
+		WHERE user_id = ?
+		ORDER BY last_seen_at DESC, id DESC
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query devices: %v", err)
+	}
+	defer rows.Close()
+
+	devices := []models.Device{}
+	for rows.Next() {
+		var device models.Device
+		if err := rows.Scan(&device.ID, &device.DeviceID, &device.Name, &device.Platform, &device.CreatedAt, &device.LastSeenAt); err != nil {
+			return nil, fmt.Errorf("failed to scan device: %v", err)
+		}
+		devices = append(devices, device)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating devices: %v", err)
+	}
+	return devices, nil
+}
+
+// RenameDevice renames one of a user's devices, returning it, or
+// ErrNotFound if the user has no such device
+func (db *DB) RenameDevice(ctx context.Context, userID int64, deviceID, name string) (*models.Device, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.guardWrite(); err != nil {
+		return nil, err
+	}
+
+	var device models.Device
+	err := db.DB.QueryRowContext(ctx, `
+		UPDATE devices SET name = ? WHERE user_id = ? AND device_id = ?
+		RETURNING `+deviceColumns,
+		name, userID, deviceID,
+	).Scan(&device.ID, &device.DeviceID, &device.Name, &device.Platform, &device.CreatedAt, &device.LastSeenAt)
+	if err != nil {
+		return nil, db.checkWrite(err)
+	}
+	return &device, nil
+}
+
+// RevokeDevice removes one of a user's devices, which invalidates every
+// session issued to it, and returns its registration id. Returns
+// ErrNotFound if the user has no such device.
+func (db *DB) RevokeDevice(ctx context.Context, userID int64, deviceID string) (int64, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.guardWrite(); err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err := db.DB.QueryRowContext(ctx, `
+		DELETE FROM devices WHERE user_id = ? AND device_id = ? RETURNING id
+	`, userID, deviceID).Scan(&id)
+	if err != nil {
+		return 0, db.checkWrite(err)
+	}
+	return id, nil
+}
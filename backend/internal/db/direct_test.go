@@ -0,0 +1,110 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"messager/internal/db"
+	"messager/internal/db/testdb"
+)
+
+func TestOneDirectConversationPerPair(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+
+	// Either side asking gets the seeded conversation back
+	for _, pair := range [][2]int64{{f.Alice.ID, f.Bob.ID}, {f.Bob.ID, f.Alice.ID}} {
+		conv, created, err := d.GetOrCreateDirectConversation(ctx, pair[0], pair[1], "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if created || conv.ID != f.Direct.ID {
+			t.Errorf("%v: got conversation %d (created %t), want the existing %d", pair, conv.ID, created, f.Direct.ID)
+		}
+	}
+
+	if _, _, err := d.GetOrCreateDirectConversation(ctx, f.Alice.ID, f.Alice.ID, ""); !errors.Is(err, db.ErrSelfConversation) {
+		t.Errorf("conversation with yourself: %v, want ErrSelfConversation", err)
+	}
+}
+
+func TestConcurrentDirectCreatesAgree(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+
+	const racers = 8
+	ids := make([]int64, racers)
+	created := make([]bool, racers)
+	errs := make([]error, racers)
+	var wg sync.WaitGroup
+	for i := 0; i < racers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			a, b := f.Alice.ID, f.Carol.ID
+			if i%2 == 1 {
+				a, b = b, a
+			}
+			conv, c, err := d.GetOrCreateDirectConversation(ctx, a, b, "")
+			if err == nil {
+				ids[i], created[i] = conv.ID, c
+			}
+			errs[i] = err
+		}(i)
+	}
+	wg.Wait()
+
+	creators := 0
+	for i := range ids {
+		if errs[i] != nil {
+			t.Fatalf("racer %d: %v", i, errs[i])
+		}
+		if ids[i] != ids[0] {
+			t.Errorf("racer %d got conversation %d, racer 0 got %d", i, ids[i], ids[0])
+		}
+		if created[i] {
+			creators++
+		}
+	}
+	if creators != 1 {
+		t.Errorf("%d racers report creating it, want 1", creators)
+	}
+}
+
+func TestDirectConversationNeedsExactPair(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+
+	if _, err := d.GetExistingDirectConversation(ctx, f.Bob.ID, f.Bob.ID); !errors.Is(err, db.ErrSelfConversation) {
+		t.Errorf("looking up a conversation with yourself: %v, want ErrSelfConversation", err)
+	}
+	if conv, err := d.GetExistingDirectConversation(ctx, f.Alice.ID, f.Carol.ID); err != nil || conv != nil {
+		t.Errorf("a pair who never talked: %+v, %v", conv, err)
+	}
+
+	// A third member makes the conversation no longer the pair's DM, even
+	// though it still holds their key
+	if _, err := d.Exec(`INSERT INTO conversation_participants (conversation_id, user_id) VALUES (?, ?)`, f.Direct.ID, f.Carol.ID); err != nil {
+		t.Fatal(err)
+	}
+	if conv, err := d.GetExistingDirectConversation(ctx, f.Alice.ID, f.Bob.ID); err != nil || conv != nil {
+		t.Errorf("conversation with an extra member: %+v, %v, want none", conv, err)
+	}
+	conv, _, err := d.GetOrCreateDirectConversation(ctx, f.Bob.ID, f.Alice.ID, "")
+	if err == nil {
+		t.Errorf("GetOrCreateDirectConversation handed back %d with an extra member", conv.ID)
+	}
+
+	// And so does a missing one
+	if _, err := d.Exec(`DELETE FROM conversation_participants WHERE conversation_id = ? AND user_id IN (?, ?)`, f.Direct.ID, f.Bob.ID, f.Carol.ID); err != nil {
+		t.Fatal(err)
+	}
+	if conv, err := d.GetExistingDirectConversation(ctx, f.Alice.ID, f.Bob.ID); err != nil || conv != nil {
+		t.Errorf("conversation missing a member: %+v, %v, want none", conv, err)
+	}
+}
@@ -0,0 +1,221 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"messager/internal/models"
+)
+
+// displayNameTTL bounds how stale a cached username can be when resolving
+// direct conversation names
+const displayNameTTL = time.Minute
+
+type cachedName struct {
+	name    string
+	expires time.Time
+}
+
+// displayNameCache memoizes the lookups behind DisplayName. The other
+// participant of a direct conversation never changes, so that mapping is
+// cached indefinitely; usernames expire after displayNameTTL.
+type displayNameCache struct {
+	mu        sync.Mutex
+	otherUser map[[2]int64]int64 // (conversation, viewer) -> other participant
+	usernames map[int64]cachedName
+}
+
+func newDisplayNameCache() *displayNameCache {
+	return &displayNameCache{
+		otherUser: make(map[[2]int64]int64),
+		usernames: make(map[int64]cachedName),
+	}
+}
+
+// directDisplayNameSQL resolves a conversation's name for the viewer bound
+// to the single placeholder: the other participant's username for direct
+// conversations, the stored name otherwise. Expects the conversation
+// aliased as c.
+const directDisplayNameSQL = `CASE WHEN c.type = 'direct' THEN COALESCE((
+		SELECT ` + visibleUsernameSQL + `
+		FROM conversation_participants cpo
+		JOIN users u ON u.id = cpo.user_id
+		WHERE cpo.conversation_id = c.id AND cpo.user_id != ?
+		LIMIT 1
+	), c.name) ELSE c.name END`
+
+// viewerDisplayNameSQL is the name the viewer sees, in order of precedence:
+// the custom name they gave the conversation, the derived name of a direct
+// conversation, then the stored name. Expects the viewer's membership
+// aliased as cp, and binds the same placeholder as directDisplayNameSQL.
+const viewerDisplayNameSQL = `COALESCE(cp.custom_name, ` + directDisplayNameSQL + `)`
+
+// directAvatarSQL is the avatar counterpart of directDisplayNameSQL: the
+// other participant's avatar for direct conversations, empty otherwise
+const directAvatarSQL = `CASE WHEN c.type = 'direct' THEN COALESCE((
+		SELECT ` + visibleAvatarSQL + `
+		FROM conversation_participants cpo
+		JOIN users u ON u.id = cpo.user_id
+		WHERE cpo.conversation_id = c.id AND cpo.user_id != ?
+		LIMIT 1
+	), '') ELSE '' END`
+
+// DisplayName returns the name a viewer should see for a conversation,
+// with the same precedence as viewerDisplayNameSQL. The stored name of a
+// direct conversation is whichever user happened to create it, and members
+// may have renamed it for themselves, so every payload-producing path must
+// go through here (or viewerDisplayNameSQL) instead of reading Name
+// directly.
+func (db *DB) DisplayName(ctx context.Context, conv *models.Conversation, viewerID int64) (string, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var custom sql.NullString
+	err := db.DB.QueryRowContext(ctx, `
+		SELECT custom_name FROM conversation_participants WHERE conversation_id = ? AND user_id = ?
+	`, conv.ID, viewerID).Scan(&custom)
+	if err != nil && err != sql.ErrNoRows {
+		return "", fmt.Errorf("failed to look up custom name: %v", err)
+	}
+	if custom.Valid {
+		return custom.String, nil
+	}
+
+	if conv.Type != "direct" {
+		return conv.Name, nil
+	}
+
+	otherID, err := db.directPeer(ctx, conv.ID, viewerID)
+	if err == ErrNotFound {
+		return conv.Name, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return db.cachedUsername(ctx, otherID)
+}
+
+// ApplyDisplayName rewrites conv.Name in place for the viewer
+func (db *DB) ApplyDisplayName(ctx context.Context, conv *models.Conversation, viewerID int64) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	name, err := db.DisplayName(ctx, conv, viewerID)
+	if err != nil {
+		return err
+	}
+	conv.Name = name
+	return nil
+}
+
+// forgetUser drops a cached username after the user is deleted or restored
+func (c *displayNameCache) forgetUser(userID int64) {
+	c.mu.Lock()
+	delete(c.usernames, userID)
+	c.mu.Unlock()
+}
+
+// forgetConversation drops cached peers for a deleted conversation
+func (c *displayNameCache) forgetConversation(conversationID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.otherUser {
+		if key[0] == conversationID {
+			delete(c.otherUser, key)
+		}
+	}
+}
+
+func (db *DB) directPeer(ctx context.Context, conversationID, viewerID int64) (int64, error) {
+	key := [2]int64{conversationID, viewerID}
+
+	db.names.mu.Lock()
+	otherID, ok := db.names.otherUser[key]
+	db.names.mu.Unlock()
+	if ok {
+		return otherID, nil
+	}
+
+	stmt, err := db.prepared(sqlDirectPeer)
+	if err != nil {
+		return 0, fmt.Errorf("failed to look up direct peer: %v", err)
+	}
+	err = stmt.QueryRowContext(ctx, conversationID, viewerID).Scan(&otherID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrNotFound
+		}
+		return 0, fmt.Errorf("failed to look up direct peer: %v", err)
+	}
+
+	db.names.mu.Lock()
+	db.names.otherUser[key] = otherID
+	db.names.mu.Unlock()
+	return otherID, nil
+}
+
+// Username returns a user's name through the same short-lived cache
+func (db *DB) Username(ctx context.Context, userID int64) (string, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	return db.cachedUsername(ctx, userID)
+}
+
+func (db *DB) cachedUsername(ctx context.Context, userID int64) (string, error) {
+	db.names.mu.Lock()
+	cached, ok := db.names.usernames[userID]
+	db.names.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.name, nil
+	}
+
+	stmt, err := db.prepared(sqlUsernameByID)
+	if err != nil {
+		return "", fmt.Errorf("failed to look up username: %v", err)
+	}
+	var username string
+	if err := stmt.QueryRowContext(ctx, userID).Scan(&username); err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrNotFound
+		}
+		return "", fmt.Errorf("failed to look up username: %v", err)
+	}
+
+	db.names.mu.Lock()
+	db.names.usernames[userID] = cachedName{name: username, expires: time.Now().Add(displayNameTTL)}
+	db.names.mu.Unlock()
+	return username, nil
+}
+
+// SetCustomName sets the name a member sees for a conversation in place of
+// its shared one. Nobody else sees it. An empty name clears it. Returns
+// ErrNotFound if the user isn't a member.
+func (db *DB) SetCustomName(ctx context.Context, conversationID, userID int64, name string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.guardWrite(); err != nil {
+		return err
+	}
+
+	var custom sql.NullString
+	if name != "" {
+		custom = sql.NullString{String: name, Valid: true}
+	}
+	result, err := db.DB.ExecContext(ctx, `
+		UPDATE conversation_participants SET custom_name = ?
+		WHERE conversation_id = ? AND user_id = ?
+	`, custom, conversationID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to set custom name: %w", db.checkWrite(err))
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+
+	"messager/internal/db/testdb"
+	"messager/internal/models"
+)
+
+func TestDirectConversationIsNamedForTheViewer(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		conversation *models.Conversation
+		viewer       *models.User
+		want         string
+	}{
+		{f.Direct, f.Alice, "bob"},
+		{f.Direct, f.Bob, "alice"},
+		{f.Group, f.Bob, "Team"},
+	} {
+		name, err := d.DisplayName(ctx, tc.conversation, tc.viewer.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name != tc.want {
+			t.Errorf("%s sees conversation %d as %q, want %q", tc.viewer.Username, tc.conversation.ID, name, tc.want)
+		}
+	}
+
+	// The list resolves names in SQL and must agree
+	conversations, err := d.GetUserConversations(ctx, f.Bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range conversations {
+		if c.ID == f.Direct.ID && c.Name != "alice" {
+			t.Errorf("bob's list names the direct conversation %q, want alice", c.Name)
+		}
+	}
+}
+
+func TestCustomNameOnlyChangesItForThatMember(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+
+	if err := d.SetCustomName(ctx, f.Direct.ID, f.Alice.ID, "Bobby"); err != nil {
+		t.Fatal(err)
+	}
+	if name, _ := d.DisplayName(ctx, f.Direct, f.Alice.ID); name != "Bobby" {
+		t.Errorf("alice sees %q, want her custom name", name)
+	}
+	if name, _ := d.DisplayName(ctx, f.Direct, f.Bob.ID); name != "alice" {
+		t.Errorf("bob sees %q, want alice", name)
+	}
+
+	if err := d.SetCustomName(ctx, f.Direct.ID, f.Alice.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+	if name, _ := d.DisplayName(ctx, f.Direct, f.Alice.ID); name != "bob" {
+		t.Errorf("alice sees %q after clearing her custom name, want bob", name)
+	}
+	if err := d.SetCustomName(ctx, f.Direct.ID, f.Carol.ID, "mine"); err == nil {
+		t.Error("a non-member set a custom name")
+	}
+}
@@ -0,0 +1,44 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+var (
+	// ErrNotFound is returned when the row a method reads or changes doesn't
+	// exist, e.g. an unknown user or a member lookup for a non-member
+	ErrNotFound = errors.New("not found")
+	// ErrDuplicateUsername is returned by CreateUser and CreateBot when the
+	// username is already taken
+	ErrDuplicateUsername = errors.New("username already exists")
+	// ErrForeignKey is returned when a write references a user, conversation
+	// or message that doesn't exist
+	ErrForeignKey = errors.New("referenced row does not exist")
+)
+
+// classify maps driver errors onto the errors above, keeping the driver's
+// message for logs. Anything else is returned unchanged.
+func classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintForeignKey {
+		return fmt.Errorf("%w: %v", ErrForeignKey, err)
+	}
+	return err
+}
+
+// isUniqueViolation reports whether err is a UNIQUE or PRIMARY KEY conflict
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) &&
+		(sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey)
+}
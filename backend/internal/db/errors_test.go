@@ -0,0 +1,51 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"messager/internal/db"
+	"messager/internal/db/testdb"
+)
+
+func TestSentinelErrors(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+
+	if _, err := d.CreateUser(ctx, "alice", "hash", ""); !errors.Is(err, db.ErrDuplicateUsername) {
+		t.Errorf("taken username: %v", err)
+	}
+	if _, err := d.CreateBot(ctx, "bob", "", "", "key-hash"); !errors.Is(err, db.ErrDuplicateUsername) {
+		t.Errorf("bot with a taken username: %v", err)
+	}
+	if _, err := d.GetUserByID(ctx, 9999); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("unknown user id: %v", err)
+	}
+	if _, err := d.GetUserByUsername(ctx, "nobody"); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("unknown username: %v", err)
+	}
+	if _, err := d.GetConversationByID(ctx, 9999); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("unknown conversation: %v", err)
+	}
+	if _, err := d.GetParticipantRole(ctx, f.Direct.ID, f.Carol.ID); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("role of a non-member: %v", err)
+	}
+	if err := d.SetParticipantRole(ctx, f.Direct.ID, f.Carol.ID, "admin"); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("setting the role of a non-member: %v", err)
+	}
+
+	// Foreign keys are enforced, and a violation isn't mistaken for a
+	// failing disk
+	if _, err := d.CreateConversation(ctx, "Ghosts", "group", f.Alice.ID, []int64{f.Alice.ID, 9999}); !errors.Is(err, db.ErrForeignKey) {
+		t.Errorf("conversation with an unknown member: %v", err)
+	}
+	if d.ReadOnly() {
+		t.Error("a foreign key violation put the database in read-only mode")
+	}
+	var orphans int
+	if err := d.QueryRow(`SELECT COUNT(*) FROM conversation_participants WHERE user_id = 9999`).Scan(&orphans); err != nil || orphans != 0 {
+		t.Errorf("%d orphaned memberships, %v", orphans, err)
+	}
+}
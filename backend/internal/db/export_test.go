@@ -0,0 +1,63 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Hooks into unexported state for the external db_test package, which
+// can't be package db because testdb imports it
+
+// CheckWrite classifies a write error the way every write path does
+func (db *DB) CheckWrite(err error) error {
+	return db.checkWrite(err)
+}
+
+// UTCBackfill is the statement migration 4 runs per timestamp column
+var UTCBackfill = utcBackfill
+
+// Path is the file the primary was opened on, for attaching readers
+func (db *DB) Path() string {
+	return db.path
+}
+
+// LatestMigration is the schema version a fully migrated database records
+var LatestMigration = migrations[len(migrations)-1].version
+
+// SealUnbound seals plaintext with the current key the way content was
+// sealed before it was bound to its message
+func (db *DB) SealUnbound(plaintext string) (string, sql.NullString, []byte, error) {
+	return db.content.seal(plaintext, nil)
+}
+
+// ReplicaRetryAfter is how long a replica that failed a read is passed over
+const ReplicaRetryAfter = replicaRetryAfter
+
+func (db *DB) replicaNamed(name string) *replica {
+	for _, r := range db.replicas {
+		if r.name == name {
+			return r
+		}
+	}
+	panic("no replica " + name)
+}
+
+// CloseReplica closes a replica's pool, so every read on it fails
+func (db *DB) CloseReplica(name string) error {
+	return db.replicaNamed(name).pool.Close()
+}
+
+// ReplicaDownUntil is when a replica that failed is tried again, zero
+// while it's healthy
+func (db *DB) ReplicaDownUntil(name string) time.Time {
+	if until := db.replicaNamed(name).downUntil.Load(); until != 0 {
+		return time.Unix(0, until)
+	}
+	return time.Time{}
+}
+
+// ExpireReplicaBackoff moves a replica's downUntil into the past, as if
+// replicaRetryAfter had gone by
+func (db *DB) ExpireReplicaBackoff(name string) {
+	db.replicaNamed(name).downUntil.Store(time.Now().Add(-time.Second).UnixNano())
+}
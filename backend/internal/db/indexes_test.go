@@ -0,0 +1,67 @@
+package db_test
+
+import (
+	"strings"
+	"testing"
+
+	"messager/internal/db"
+	"messager/internal/db/testdb"
+)
+
+// queryPlan returns the EXPLAIN QUERY PLAN details for query, one per line
+func queryPlan(t *testing.T, d *db.DB, query string, args ...any) string {
+	t.Helper()
+	rows, err := d.Query("EXPLAIN QUERY PLAN "+query, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var details []string
+	for rows.Next() {
+		var id, parent, unused int
+		var detail string
+		if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+			t.Fatal(err)
+		}
+		details = append(details, detail)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return strings.Join(details, "\n")
+}
+
+func TestHotQueriesUseIndexes(t *testing.T) {
+	d := testdb.Open(t)
+
+	for _, tc := range []struct {
+		name, query, index string
+	}{
+		{
+			name: "history page",
+			query: `SELECT id FROM messages WHERE conversation_id = 1
+				AND (unixepoch(created_at, 'subsec') < unixepoch('2024-01-01', 'subsec')
+				     OR (unixepoch(created_at, 'subsec') = unixepoch('2024-01-01', 'subsec') AND id < 10))
+				ORDER BY unixepoch(created_at, 'subsec') DESC, id DESC LIMIT 50`,
+			index: "idx_messages_conversation_time",
+		},
+		{
+			name:  "a user's conversations",
+			query: `SELECT conversation_id FROM conversation_participants WHERE user_id = 1`,
+			index: "idx_participants_user",
+		},
+		{
+			name:  "username lookup",
+			query: `SELECT id FROM users WHERE username = 'Alice' COLLATE NOCASE`,
+			index: "idx_users_username_nocase",
+		},
+	} {
+		plan := queryPlan(t, d, tc.query)
+		if !strings.Contains(plan, tc.index) {
+			t.Errorf("%s doesn't use %s:\n%s", tc.name, tc.index, plan)
+		}
+		if strings.Contains(plan, "TEMP B-TREE") {
+			t.Errorf("%s sorts instead of walking the index:\n%s", tc.name, plan)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package db
+
+import (
+	"context"
+	"fmt"
+)
+
+// Foreign keys have been enforced for a while and deletes now cascade, but
+// databases from before then can still hold rows whose conversation, user
+// or message is gone. CheckOrphans finds them and can delete them.
+
+// orphanChecks are the kinds of orphan CheckOrphans looks for, by the name
+// they're reported as. They're cleaned in this order: messages go before
+// reports, as deleting a message takes its reports with it.
+var orphanChecks = []struct {
+	name, table, where string
+}{
+	{"participants_without_conversation", "conversation_participants",
+		`NOT EXISTS (SELECT 1 FROM conversations c WHERE c.id = conversation_participants.conversation_id)`},
+	{"participants_without_user", "conversation_participants",
+		`NOT EXISTS (SELECT 1 FROM users u WHERE u.id = conversation_participants.user_id)`},
+	{"messages_without_conversation", "messages",
+		`NOT EXISTS (SELECT 1 FROM conversations c WHERE c.id = messages.conversation_id)`},
+	{"messages_without_sender", "messages",
+		`sender_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM users u WHERE u.id = messages.sender_id)`},
+	{"reports_without_message", "message_reports",
+		`NOT EXISTS (SELECT 1 FROM messages m WHERE m.id = message_reports.message_id)`},
+	{"reports_without_reporter", "message_reports",
+		`NOT EXISTS (SELECT 1 FROM users u WHERE u.id = message_reports.reporter_id)`},
+}
+
+// CheckOrphans counts each kind of orphaned row, deleting them too when fix
+// is set. Deleting messages from a conversation that still exists also
+// recomputes its latest message and unread counts. It reads every row, so
+// it isn't bound by the statement timeout.
+func (db *DB) CheckOrphans(ctx context.Context, fix bool) (map[string]int64, error) {
+	orphans := make(map[string]int64, len(orphanChecks))
+	total := int64(0)
+	for _, check := range orphanChecks {
+		var n int64
+		if err := db.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+check.table+` WHERE `+check.where).Scan(&n); err != nil {
+			return nil, fmt.Errorf("failed to count %s: %v", check.name, err)
+		}
+		orphans[check.name] = n
+		total += n
+	}
+	if !fix || total == 0 {
+		return orphans, nil
+	}
+
+	if err := db.guardWrite(); err != nil {
+		return nil, err
+	}
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", db.checkWrite(err))
+	}
+	defer tx.Rollback()
+
+	// Conversations losing messages whose sender is gone
+	var affected []int64
+	rows, err := tx.QueryContext(ctx, `
+		SELECT DISTINCT conversation_id FROM messages
+		WHERE sender_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM users u WHERE u.id = messages.sender_id)
+		  AND EXISTS (SELECT 1 FROM conversations c WHERE c.id = messages.conversation_id)
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find affected conversations: %v", err)
+	}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan conversation: %v", err)
+		}
+		affected = append(affected, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating conversations: %v", err)
+	}
+
+	for _, check := range orphanChecks {
+		if orphans[check.name] == 0 {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM `+check.table+` WHERE `+check.where); err != nil {
+			return nil, fmt.Errorf("failed to delete %s: %w", check.name, db.checkWrite(err))
+		}
+	}
+	for start := 0; start < len(affected); start += refreshBatch {
+		end := start + refreshBatch
+		if end > len(affected) {
+			end = len(affected)
+		}
+		if err := refreshLastMessage(ctx, tx, affected[start:end]); err != nil {
+			return nil, fmt.Errorf("failed to update latest messages: %w", db.checkWrite(err))
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", db.checkWrite(err))
+	}
+	if orphans["participants_without_conversation"]+orphans["participants_without_user"] > 0 {
+		db.invalidateAllMembership()
+	}
+
+	if len(affected) > 0 {
+		if _, err := db.RepairUnreadCounts(ctx, affected...); err != nil {
+			return nil, err
+		}
+	}
+	return orphans, nil
+}
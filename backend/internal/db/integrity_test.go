@@ -0,0 +1,106 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+
+	"messager/internal/db/testdb"
+)
+
+func TestDeletesCascade(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+
+	if _, err := d.CreateMessageReport(ctx, f.Messages[2].ID, f.Bob.ID, "spam", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.DeleteConversation(ctx, f.Group.ID); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"conversation_participants", "messages"} {
+		var n int
+		if err := d.QueryRow(`SELECT COUNT(*) FROM `+table+` WHERE conversation_id = ?`, f.Group.ID).Scan(&n); err != nil || n != 0 {
+			t.Errorf("%d %s rows left for the deleted group, %v", n, table, err)
+		}
+	}
+	var reports int
+	if err := d.QueryRow(`SELECT COUNT(*) FROM message_reports`).Scan(&reports); err != nil || reports != 0 {
+		t.Errorf("%d reports left on deleted messages, %v", reports, err)
+	}
+
+	// Users are only soft-deleted; a hard delete of one still referenced
+	// is refused
+	if _, err := d.Exec(`DELETE FROM users WHERE id = ?`, f.Bob.ID); err == nil {
+		t.Error("hard-deleted a user who still has messages and memberships")
+	}
+}
+
+func TestCheckOrphans(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+
+	// Orphans can only be written with foreign keys off, as an old
+	// database could have them
+	conn, err := d.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []struct {
+		sql  string
+		args []any
+	}{
+		{`PRAGMA foreign_keys = OFF`, nil},
+		{`INSERT INTO conversation_participants (conversation_id, user_id) VALUES (9999, ?)`, []any{f.Alice.ID}},
+		{`INSERT INTO messages (conversation_id, sender_id, content, seq, created_at) VALUES (?, 9999, 'ghost', 99, '2999-01-01 00:00:00')`, []any{f.Group.ID}},
+		{`INSERT INTO message_reports (message_id, reporter_id, reason) VALUES (9999, ?, 'spam')`, []any{f.Alice.ID}},
+		{`PRAGMA foreign_keys = ON`, nil},
+	} {
+		if _, err := conn.ExecContext(ctx, stmt.sql, stmt.args...); err != nil {
+			t.Fatalf("%s: %v", stmt.sql, err)
+		}
+	}
+	conn.Close()
+	// The ghost message is the group's latest now
+	if _, err := d.Exec(`UPDATE conversations SET last_message_id = (SELECT MAX(id) FROM messages) WHERE id = ?`, f.Group.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	orphans, err := d.CheckOrphans(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"participants_without_conversation", "messages_without_sender", "reports_without_message"} {
+		if orphans[name] != 1 {
+			t.Errorf("%s = %d, want 1", name, orphans[name])
+		}
+	}
+	if orphans["participants_without_user"]+orphans["messages_without_conversation"]+orphans["reports_without_reporter"] != 0 {
+		t.Errorf("orphans %v, want only the three planted", orphans)
+	}
+	// Only counting leaves them in place
+	if again, _ := d.CheckOrphans(ctx, false); again["messages_without_sender"] != 1 {
+		t.Error("counting deleted the orphans")
+	}
+
+	if _, err := d.CheckOrphans(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+	after, err := d.CheckOrphans(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, n := range after {
+		if n != 0 {
+			t.Errorf("%s = %d after cleaning", name, n)
+		}
+	}
+	var last int64
+	if err := d.QueryRow(`SELECT last_message_id FROM conversations WHERE id = ?`, f.Group.ID).Scan(&last); err != nil || last != f.Messages[3].ID {
+		t.Errorf("group's latest message %d, %v; want %d back", last, err, f.Messages[3].ID)
+	}
+	if n, err := d.RepairUnreadCounts(ctx); err != nil || n != 0 {
+		t.Errorf("unread counts needed %d repairs after cleaning, %v", n, err)
+	}
+}
@@ -0,0 +1,109 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Each conversation row carries last_message_id and last_message_at, its
+// latest message by seq, so the conversation list can sort and preview
+// without finding it per row. insertMessage sets both in the message's own
+// transaction. Soft-deleted messages stay latest, as the list previews
+// them as deleted; hard deletes (the retention sweep) recompute them with
+// refreshLastMessage. CheckLastMessages finds and fixes any drift.
+
+// refreshBatch caps the conversations refreshed per statement, keeping the
+// parameter list well under SQLite's limit
+const refreshBatch = 500
+
+// latestMessageSQL selects the id and created_at of the latest message of
+// the conversations row being updated
+const latestMessageSQL = `SELECT id, created_at FROM messages m
+	WHERE m.conversation_id = conversations.id ORDER BY m.seq DESC LIMIT 1`
+
+// refreshLastMessage recomputes the latest message of conversationIDs
+func refreshLastMessage(ctx context.Context, tx *sql.Tx, conversationIDs []int64) error {
+	if len(conversationIDs) == 0 {
+		return nil
+	}
+	args := make([]interface{}, len(conversationIDs))
+	for i, id := range conversationIDs {
+		args[i] = id
+	}
+	_, err := tx.ExecContext(ctx, `
+		UPDATE conversations SET (last_message_id, last_message_at) = (`+latestMessageSQL+`)
+		WHERE id IN (?`+strings.Repeat(", ?", len(conversationIDs)-1)+`)
+	`, args...)
+	return err
+}
+
+// LastMessageDrift is a conversation whose stored latest message is wrong.
+// The IDs are 0 for none.
+type LastMessageDrift struct {
+	ConversationID int64 `json:"conversation_id"`
+	Stored         int64 `json:"stored_message_id"`
+	Actual         int64 `json:"actual_message_id"`
+}
+
+// CheckLastMessages compares every conversation's stored latest message
+// with its messages and returns those that disagree, fixing them too when
+// fix is set. It reads every conversation, so it isn't bound by the
+// statement timeout.
+func (db *DB) CheckLastMessages(ctx context.Context, fix bool) ([]LastMessageDrift, error) {
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT id, COALESCE(last_message_id, 0), COALESCE((
+			SELECT id FROM messages m WHERE m.conversation_id = conversations.id ORDER BY m.seq DESC LIMIT 1
+		), 0) AS actual
+		FROM conversations
+		WHERE COALESCE(last_message_id, 0) != actual
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check latest messages: %v", err)
+	}
+	var drift []LastMessageDrift
+	for rows.Next() {
+		var d LastMessageDrift
+		if err := rows.Scan(&d.ConversationID, &d.Stored, &d.Actual); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan conversation: %v", err)
+		}
+		drift = append(drift, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating conversations: %v", err)
+	}
+	if !fix || len(drift) == 0 {
+		return drift, nil
+	}
+
+	if err := db.guardWrite(); err != nil {
+		return nil, err
+	}
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", db.checkWrite(err))
+	}
+	defer tx.Rollback()
+
+	ids := make([]int64, len(drift))
+	for i, d := range drift {
+		ids[i] = d.ConversationID
+	}
+	for start := 0; start < len(ids); start += refreshBatch {
+		end := start + refreshBatch
+		if end > len(ids) {
+			end = len(ids)
+		}
+		if err := refreshLastMessage(ctx, tx, ids[start:end]); err != nil {
+			return nil, fmt.Errorf("failed to fix latest messages: %w", db.checkWrite(err))
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", db.checkWrite(err))
+	}
+	return drift, nil
+}
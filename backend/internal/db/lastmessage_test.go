@@ -0,0 +1,74 @@
+package db_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"messager/internal/db/testdb"
+	"messager/internal/models"
+)
+
+func TestConversationListOrderAndPreview(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+
+	empty, err := d.CreateConversation(ctx, "Quiet", "group", f.Alice.ID, []int64{f.Alice.ID, f.Bob.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The direct conversation was seeded first but is now the most active
+	long := strings.Repeat("é", models.MaxPreviewLength+5)
+	latest, err := d.CreateMessage(ctx, f.Direct.ID, f.Bob.ID, long)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list := func() []*models.Conversation {
+		t.Helper()
+		conversations, err := d.GetUserConversations(ctx, f.Alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(conversations) != 3 {
+			t.Fatalf("listed %d conversations, want 3", len(conversations))
+		}
+		return conversations
+	}
+
+	conversations := list()
+	for i, want := range []int64{f.Direct.ID, f.Group.ID, empty.ID} {
+		if conversations[i].ID != want {
+			t.Errorf("position %d: conversation %d, want %d", i, conversations[i].ID, want)
+		}
+	}
+	if conversations[2].LastMessage != nil {
+		t.Errorf("empty conversation has preview %+v", conversations[2].LastMessage)
+	}
+	p := conversations[0].LastMessage
+	if p == nil || p.ID != latest.ID || p.SenderUsername != "bob" {
+		t.Fatalf("direct preview = %+v, want bob's latest message", p)
+	}
+	if want := strings.Repeat("é", models.MaxPreviewLength) + "…"; !p.Truncated || p.Content != want {
+		t.Errorf("preview content %q (truncated %t), want %d characters and an ellipsis", p.Content, p.Truncated, models.MaxPreviewLength)
+	}
+
+	// System messages preview their text; deleted ones keep only metadata
+	if _, err := d.CreateSystemMessage(ctx, f.Group.ID, `{"event":"topic_changed","text":"alice changed the topic"}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SoftDeleteMessage(ctx, latest.ID); err != nil {
+		t.Fatal(err)
+	}
+	conversations = list()
+	if conversations[0].ID != f.Group.ID {
+		t.Fatalf("the group should lead after its system message, got %d", conversations[0].ID)
+	}
+	if p := conversations[0].LastMessage; p.Content != "alice changed the topic" || p.SenderID != 0 {
+		t.Errorf("system preview = %+v", p)
+	}
+	if p := conversations[1].LastMessage; !p.Deleted || p.Content != "" || p.ID != latest.ID {
+		t.Errorf("deleted preview = %+v", p)
+	}
+}
@@ -0,0 +1,34 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TouchLastSeen sets last_seen_at for a batch of users in one statement
+func (db *DB) TouchLastSeen(ctx context.Context, userIDs []int64, at time.Time) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if len(userIDs) == 0 {
+		return nil
+	}
+	if err := db.guardWrite(); err != nil {
+		return err
+	}
+
+	args := make([]interface{}, 0, len(userIDs)+1)
+	args = append(args, at.UTC())
+	for _, id := range userIDs {
+		args = append(args, id)
+	}
+	if _, err := db.DB.ExecContext(ctx, `
+		UPDATE users SET last_seen_at = ?
+		WHERE id IN (?`+strings.Repeat(", ?", len(userIDs)-1)+`)
+	`, args...); err != nil {
+		return fmt.Errorf("failed to update last seen: %w", db.checkWrite(err))
+	}
+	return nil
+}
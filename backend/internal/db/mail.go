@@ -0,0 +1,213 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"messager/internal/models"
+)
+
+// The outbox drained by mail.Queue; DB satisfies mail.Store
+
+func (db *DB) EnqueueMail(ctx context.Context, msg *models.OutboundMail) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.guardWrite(); err != nil {
+		return err
+	}
+
+	result, err := db.DB.ExecContext(ctx, `
+		INSERT INTO mail_outbox (recipient, template, subject, text_body, html_body, status, next_attempt_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, msg.Recipient, msg.Template, msg.Subject, msg.TextBody, msg.HTMLBody, msg.Status, msg.NextAttemptAt, msg.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to queue mail: %w", db.checkWrite(err))
+	}
+	msg.ID, err = result.LastInsertId()
+	return err
+}
+
+// DueMail returns up to limit pending messages whose next attempt is due,
+// oldest first
+func (db *DB) DueMail(ctx context.Context, now time.Time, limit int) ([]models.OutboundMail, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT id, recipient, template, subject, text_body, html_body, status, attempts, last_error, next_attempt_at, created_at
+		FROM mail_outbox
+		WHERE status = ? AND next_attempt_at <= ?
+		ORDER BY next_attempt_at, id
+		LIMIT ?
+	`, models.MailPending, now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query outbox: %v", err)
+	}
+	defer rows.Close()
+
+	var due []models.OutboundMail
+	for rows.Next() {
+		var msg models.OutboundMail
+		if err := rows.Scan(&msg.ID, &msg.Recipient, &msg.Template, &msg.Subject, &msg.TextBody, &msg.HTMLBody,
+			&msg.Status, &msg.Attempts, &msg.LastError, &msg.NextAttemptAt, &msg.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan mail: %v", err)
+		}
+		due = append(due, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating outbox: %v", err)
+	}
+	return due, nil
+}
+
+func (db *DB) MarkMailSent(ctx context.Context, id int64) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	return db.updateMail(ctx, `UPDATE mail_outbox SET status = ?, attempts = attempts + 1, sent_at = ?, last_error = '' WHERE id = ?`,
+		models.MailSent, time.Now().UTC(), id)
+}
+
+func (db *DB) RetryMail(ctx context.Context, id int64, attempts int, next time.Time, lastErr string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	return db.updateMail(ctx, `UPDATE mail_outbox SET attempts = ?, next_attempt_at = ?, last_error = ? WHERE id = ?`,
+		attempts, next, lastErr, id)
+}
+
+func (db *DB) DeferMail(ctx context.Context, id int64, next time.Time) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	return db.updateMail(ctx, `UPDATE mail_outbox SET next_attempt_at = ? WHERE id = ?`, next, id)
+}
+
+func (db *DB) FailMail(ctx context.Context, id int64, attempts int, lastErr string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	return db.updateMail(ctx, `UPDATE mail_outbox SET status = ?, attempts = ?, last_error = ? WHERE id = ?`,
+		models.MailFailed, attempts, lastErr, id)
+}
+
+func (db *DB) updateMail(ctx context.Context, query string, args ...interface{}) error {
+	if err := db.guardWrite(); err != nil {
+		return err
+	}
+	if _, err := db.DB.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("failed to update outbox: %w", db.checkWrite(err))
+	}
+	return nil
+}
+
+// hashEmailToken is how verification tokens are stored
+func hashEmailToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// GetEmailStatus returns a user's email address and when it was verified
+func (db *DB) GetEmailStatus(ctx context.Context, userID int64) (*models.EmailStatus, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var email sql.NullString
+	var verifiedAt sql.NullTime
+	err := db.DB.QueryRowContext(ctx, `
+		SELECT email, email_verified_at FROM users WHERE id = ?
+	`, userID).Scan(&email, &verifiedAt)
+	if err != nil {
+		return nil, classify(err)
+	}
+	status := &models.EmailStatus{Email: email.String, Verified: verifiedAt.Valid}
+	if verifiedAt.Valid {
+		status.VerifiedAt = &verifiedAt.Time
+	}
+	return status, nil
+}
+
+// SetEmail changes a user's email address, marking it unverified and
+// replacing any outstanding verification with one for token
+func (db *DB) SetEmail(ctx context.Context, userID int64, email, token string, expiresAt time.Time) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.guardWrite(); err != nil {
+		return err
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", db.checkWrite(err))
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	stmts := []struct {
+		query string
+		args  []interface{}
+	}{
+		{`UPDATE users SET email = ?, email_verified_at = NULL WHERE id = ?`, []interface{}{email, userID}},
+		{`DELETE FROM email_verifications WHERE user_id = ?`, []interface{}{userID}},
+		{`INSERT INTO email_verifications (token_hash, user_id, email, expires_at, created_at) VALUES (?, ?, ?, ?, ?)`,
+			[]interface{}{hashEmailToken(token), userID, email, expiresAt, now}},
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt.query, stmt.args...); err != nil {
+			return fmt.Errorf("failed to set email: %w", db.checkWrite(err))
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", db.checkWrite(err))
+	}
+	return nil
+}
+
+// VerifyEmail consumes a verification token and marks the address it was
+// issued for as verified, returning the user. Returns ErrNotFound for an
+// unknown or expired token, or one for an address the user has since
+// changed.
+func (db *DB) VerifyEmail(ctx context.Context, token string) (int64, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.guardWrite(); err != nil {
+		return 0, err
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", db.checkWrite(err))
+	}
+	defer tx.Rollback()
+
+	var userID int64
+	var email string
+	err = tx.QueryRowContext(ctx, `
+		DELETE FROM email_verifications WHERE token_hash = ? AND expires_at > ?
+		RETURNING user_id, email
+	`, hashEmailToken(token), time.Now().UTC()).Scan(&userID, &email)
+	if err != nil {
+		return 0, db.checkWrite(err)
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE users SET email_verified_at = ? WHERE id = ? AND email = ?
+	`, time.Now().UTC(), userID, email)
+	if err != nil {
+		return 0, fmt.Errorf("failed to verify email: %w", db.checkWrite(err))
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return 0, ErrNotFound
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", db.checkWrite(err))
+	}
+	return userID, nil
+}
@@ -0,0 +1,193 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Membership checks run on every send, read and websocket frame, so each
+// conversation's participant IDs are cached for a short TTL. Every method
+// that adds or removes participants calls InvalidateMembership once it has
+// committed. The cache is per process: with several servers on the bus, a
+// change made on another one shows up here once the entry expires.
+
+// defaultMembershipTTL bounds how long a cached participant set is used
+const defaultMembershipTTL = 30 * time.Second
+
+// membershipCacheMax caps the number of cached conversations; once full,
+// expired entries are dropped and, failing that, new ones aren't cached
+const membershipCacheMax = 10000
+
+type cachedMembers struct {
+	ids     []int64
+	set     map[int64]struct{}
+	expires time.Time
+}
+
+// membershipCache holds participant sets by conversation. gen counts
+// invalidations, so a load that raced one isn't stored.
+type membershipCache struct {
+	ttl atomic.Int64 // nanoseconds, 0 to not cache
+
+	mu      sync.Mutex
+	entries map[int64]cachedMembers
+	gen     uint64
+
+	hits          atomic.Int64
+	misses        atomic.Int64
+	invalidations atomic.Int64
+}
+
+func newMembershipCache() *membershipCache {
+	c := &membershipCache{entries: make(map[int64]cachedMembers)}
+	c.ttl.Store(int64(defaultMembershipTTL))
+	return c
+}
+
+// MembershipCacheStats counts lookups of the membership cache since start
+type MembershipCacheStats struct {
+	Hits          int64   `json:"hits"`
+	Misses        int64   `json:"misses"`
+	HitRate       float64 `json:"hit_rate"`
+	Invalidations int64   `json:"invalidations"`
+	Entries       int     `json:"entries"`
+}
+
+// SetMembershipCacheTTL sets how long participant sets are cached, 0 to
+// not cache them. It must be set before the server starts serving.
+func (db *DB) SetMembershipCacheTTL(ttl time.Duration) {
+	if ttl >= 0 {
+		db.members.ttl.Store(int64(ttl))
+	}
+}
+
+// InvalidateMembership drops the cached participants of a conversation.
+// Methods in this package that change membership call it themselves; code
+// that writes conversation_participants any other way must too.
+func (db *DB) InvalidateMembership(conversationIDs ...int64) {
+	db.members.mu.Lock()
+	for _, id := range conversationIDs {
+		delete(db.members.entries, id)
+	}
+	db.members.gen++
+	db.members.mu.Unlock()
+	db.members.invalidations.Add(int64(len(conversationIDs)))
+}
+
+// invalidateAllMembership drops every cached participant set, for changes
+// that can touch any conversation
+func (db *DB) invalidateAllMembership() {
+	db.members.mu.Lock()
+	db.members.entries = make(map[int64]cachedMembers)
+	db.members.gen++
+	db.members.mu.Unlock()
+	db.members.invalidations.Add(1)
+}
+
+// MembershipCacheStats reports the membership cache's hit rate and size
+func (db *DB) MembershipCacheStats() MembershipCacheStats {
+	db.members.mu.Lock()
+	entries := len(db.members.entries)
+	db.members.mu.Unlock()
+
+	stats := MembershipCacheStats{
+		Hits:          db.members.hits.Load(),
+		Misses:        db.members.misses.Load(),
+		Invalidations: db.members.invalidations.Load(),
+		Entries:       entries,
+	}
+	if lookups := stats.Hits + stats.Misses; lookups > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(lookups)
+	}
+	return stats
+}
+
+// participants returns a conversation's participant set, from the cache
+// when it's fresh. The result is shared and must not be modified.
+func (db *DB) participants(ctx context.Context, conversationID int64) (cachedMembers, error) {
+	c := db.members
+	ttl := time.Duration(c.ttl.Load())
+	now := time.Now()
+
+	c.mu.Lock()
+	cached, ok := c.entries[conversationID]
+	gen := c.gen
+	c.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		c.hits.Add(1)
+		return cached, nil
+	}
+	c.misses.Add(1)
+
+	stmt, err := db.prepared(sqlParticipantIDs)
+	if err != nil {
+		return cachedMembers{}, fmt.Errorf("failed to get participants: %v", err)
+	}
+	rows, err := stmt.QueryContext(ctx, conversationID)
+	if err != nil {
+		return cachedMembers{}, fmt.Errorf("failed to get participants: %v", err)
+	}
+	defer rows.Close()
+
+	loaded := cachedMembers{set: make(map[int64]struct{}), expires: now.Add(ttl)}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return cachedMembers{}, fmt.Errorf("failed to scan participant ID: %v", err)
+		}
+		loaded.ids = append(loaded.ids, id)
+		loaded.set[id] = struct{}{}
+	}
+	if err := rows.Err(); err != nil {
+		return cachedMembers{}, fmt.Errorf("error iterating participant IDs: %v", err)
+	}
+
+	if ttl > 0 {
+		c.mu.Lock()
+		// Membership may have changed while this was loading
+		if c.gen == gen && c.makeRoom(now) {
+			c.entries[conversationID] = loaded
+		}
+		c.mu.Unlock()
+	}
+	return loaded, nil
+}
+
+// prime caches participant sets loaded elsewhere, as warmup does before
+// the server starts serving
+func (c *membershipCache) prime(members map[int64][]int64) {
+	ttl := time.Duration(c.ttl.Load())
+	if ttl <= 0 {
+		return
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for conversationID, ids := range members {
+		if !c.makeRoom(now) {
+			return
+		}
+		entry := cachedMembers{ids: ids, set: make(map[int64]struct{}, len(ids)), expires: now.Add(ttl)}
+		for _, id := range ids {
+			entry.set[id] = struct{}{}
+		}
+		c.entries[conversationID] = entry
+	}
+}
+
+// makeRoom reports whether there's room for another entry, dropping
+// expired ones if the cache is full. The caller holds mu.
+func (c *membershipCache) makeRoom(now time.Time) bool {
+	if len(c.entries) < membershipCacheMax {
+		return true
+	}
+	for id, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, id)
+		}
+	}
+	return len(c.entries) < membershipCacheMax
+}
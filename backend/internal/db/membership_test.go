@@ -0,0 +1,111 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+
+	"messager/internal/db"
+	"messager/internal/db/testdb"
+)
+
+func isMember(t *testing.T, d *db.DB, conversationID, userID int64) bool {
+	t.Helper()
+	ok, err := d.IsConversationParticipant(context.Background(), conversationID, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ok
+}
+
+func TestMembershipCacheHit(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+
+	if !isMember(t, d, f.Group.ID, f.Carol.ID) {
+		t.Fatal("carol isn't in the group")
+	}
+	before := d.MembershipCacheStats()
+
+	// A write that bypasses the package isn't seen until the entry is
+	// dropped, so the next answer must come from the cache
+	if _, err := d.Exec(`DELETE FROM conversation_participants WHERE conversation_id = ? AND user_id = ?`, f.Group.ID, f.Carol.ID); err != nil {
+		t.Fatal(err)
+	}
+	if !isMember(t, d, f.Group.ID, f.Carol.ID) || isMember(t, d, f.Group.ID, 9999) {
+		t.Error("cached set didn't answer")
+	}
+	if stats := d.MembershipCacheStats(); stats.Hits != before.Hits+2 || stats.Misses != before.Misses {
+		t.Errorf("stats %+v after %+v, want two hits", stats, before)
+	}
+
+	d.InvalidateMembership(f.Group.ID)
+	if isMember(t, d, f.Group.ID, f.Carol.ID) {
+		t.Error("carol is still a member after invalidating")
+	}
+
+	// Without caching, every check reads the table
+	d.SetMembershipCacheTTL(0)
+	if _, err := d.Exec(`INSERT INTO conversation_participants (conversation_id, user_id) VALUES (?, ?)`, f.Group.ID, f.Carol.ID); err != nil {
+		t.Fatal(err)
+	}
+	if !isMember(t, d, f.Group.ID, f.Carol.ID) {
+		t.Error("uncached check missed carol")
+	}
+}
+
+func TestMembershipCacheInvalidatedByParticipantChanges(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+	dave := testdb.CreateUser(t, d, "dave")
+
+	// Cache both answers first
+	if isMember(t, d, f.Group.ID, dave.ID) || !isMember(t, d, f.Group.ID, f.Carol.ID) {
+		t.Fatal("unexpected group membership")
+	}
+	before := d.MembershipCacheStats().Invalidations
+
+	if _, err := d.AddConversationParticipants(ctx, f.Group.ID, []int64{dave.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if !isMember(t, d, f.Group.ID, dave.ID) {
+		t.Error("dave isn't a member right after being added")
+	}
+
+	if _, _, err := d.RemoveConversationParticipant(ctx, f.Group.ID, f.Carol.ID); err != nil {
+		t.Fatal(err)
+	}
+	if isMember(t, d, f.Group.ID, f.Carol.ID) {
+		t.Error("carol is still a member right after being removed")
+	}
+	ids, err := d.GetConversationParticipantIDs(ctx, f.Group.ID)
+	if err != nil || len(ids) != 3 {
+		t.Errorf("participants %v, %v; want alice, bob and dave", ids, err)
+	}
+	if n := d.MembershipCacheStats().Invalidations - before; n < 2 {
+		t.Errorf("%d invalidations, want one per change", n)
+	}
+}
+
+func TestMembershipCacheInvalidatedByDelete(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+
+	if !isMember(t, d, f.Direct.ID, f.Alice.ID) {
+		t.Fatal("alice isn't in the direct conversation")
+	}
+	if _, err := d.DeleteConversation(ctx, f.Direct.ID); err != nil {
+		t.Fatal(err)
+	}
+	if isMember(t, d, f.Direct.ID, f.Alice.ID) {
+		t.Error("alice is still a member of the deleted conversation")
+	}
+	if ids, err := d.GetConversationParticipantIDs(ctx, f.Direct.ID); err != nil || len(ids) != 0 {
+		t.Errorf("deleted conversation has participants %v, %v", ids, err)
+	}
+	// The other conversation's entry is untouched
+	if !isMember(t, d, f.Group.ID, f.Alice.ID) {
+		t.Error("alice is no longer in the group")
+	}
+}
@@ -0,0 +1,183 @@
+package db_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"messager/internal/cursor"
+	"messager/internal/db"
+	"messager/internal/db/testdb"
+	"messager/internal/models"
+)
+
+// unreadCounts returns each member's stored unread_count in conversationID
+func unreadCounts(t *testing.T, d *db.DB, conversationID int64) map[int64]int {
+	t.Helper()
+	rows, err := d.Query(`SELECT user_id, unread_count FROM conversation_participants WHERE conversation_id = ?`, conversationID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	counts := make(map[int64]int)
+	for rows.Next() {
+		var userID int64
+		var n int
+		if err := rows.Scan(&userID, &n); err != nil {
+			t.Fatal(err)
+		}
+		counts[userID] = n
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return counts
+}
+
+func TestInsertMessageBookkeeping(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+	before := unreadCounts(t, d, f.Group.ID)
+
+	msg, err := d.CreateMessage(ctx, f.Group.ID, f.Alice.ID, "one more")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Seq != 3 {
+		t.Errorf("seq %d, want 3 after the two seeded messages", msg.Seq)
+	}
+
+	// Everyone but the sender has one more unread
+	after := unreadCounts(t, d, f.Group.ID)
+	for _, user := range []*models.User{f.Alice, f.Bob, f.Carol} {
+		want := before[user.ID] + 1
+		if user.ID == f.Alice.ID {
+			want = before[user.ID]
+		}
+		if after[user.ID] != want {
+			t.Errorf("%s has %d unread, want %d", user.Username, after[user.ID], want)
+		}
+	}
+
+	var lastID, lastSeq int64
+	if err := d.QueryRow(`SELECT last_message_id, last_seq FROM conversations WHERE id = ?`, f.Group.ID).Scan(&lastID, &lastSeq); err != nil {
+		t.Fatal(err)
+	}
+	if lastID != msg.ID || lastSeq != msg.Seq {
+		t.Errorf("conversation row has last message %d at seq %d, want %d at %d", lastID, lastSeq, msg.ID, msg.Seq)
+	}
+	var queued int
+	if err := d.QueryRow(`SELECT COUNT(*) FROM message_outbox WHERE message_id = ? AND sent_at IS NULL`, msg.ID).Scan(&queued); err != nil || queued != 1 {
+		t.Errorf("%d outbox rows for the message, %v", queued, err)
+	}
+
+	// A system message takes a seq and becomes the latest, but nobody has
+	// it unread
+	system, err := d.CreateSystemMessage(ctx, f.Group.ID, "carol changed the topic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if system.Seq != 4 {
+		t.Errorf("system message seq %d, want 4", system.Seq)
+	}
+	if got := unreadCounts(t, d, f.Group.ID); fmt.Sprint(got) != fmt.Sprint(after) {
+		t.Errorf("unread counts %v after a system message, want %v", got, after)
+	}
+	if err := d.QueryRow(`SELECT last_message_id FROM conversations WHERE id = ?`, f.Group.ID).Scan(&lastID); err != nil || lastID != system.ID {
+		t.Errorf("last message %d, %v; want the system message", lastID, err)
+	}
+}
+
+func TestOffsetPagesBreakTiesByID(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+
+	// Five messages with the same timestamp page in ID order
+	at := time.Now().Add(time.Minute)
+	var tied []*models.Message
+	for i := 0; i < 5; i++ {
+		msg, err := d.CreateMessageAt(ctx, f.Direct.ID, f.Bob.ID, fmt.Sprintf("tied %d", i), at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tied = append(tied, msg)
+	}
+
+	first, err := d.GetConversationMessages(ctx, f.Direct.ID, 3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIDs(t, "first page", first, tied[4], tied[3], tied[2])
+	second, err := d.GetConversationMessages(ctx, f.Direct.ID, 3, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIDs(t, "second page", second, tied[1], tied[0], f.Messages[1])
+	past, err := d.GetConversationMessages(ctx, f.Direct.ID, 3, 10)
+	if err != nil || len(past) != 0 {
+		t.Errorf("page past the end: %d messages, %v", len(past), err)
+	}
+}
+
+func TestHistoryMergesArchive(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+
+	// Six old messages and two recent ones; the old ones are delivered,
+	// so they may be archived
+	start := time.Now().Add(-48 * time.Hour)
+	all := []*models.Message{f.Messages[2], f.Messages[3]}
+	for i := 0; i < 8; i++ {
+		at := start.Add(time.Duration(i) * time.Hour)
+		if i >= 6 {
+			at = time.Now().Add(time.Duration(i) * time.Second)
+		}
+		msg, err := d.CreateMessageAt(ctx, f.Group.ID, f.Bob.ID, fmt.Sprintf("message %d", i), at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, msg)
+	}
+	if _, err := d.Exec(`UPDATE message_outbox SET sent_at = CURRENT_TIMESTAMP`); err != nil {
+		t.Fatal(err)
+	}
+	// The seeded messages are newer than the cutoff, so only the six move
+	moved, err := d.ArchiveMessages(ctx, time.Now().Add(-time.Hour), 100)
+	if err != nil || moved != 6 {
+		t.Fatalf("archived %d, %v; want 6", moved, err)
+	}
+
+	newestFirst := make([]*models.Message, 0, len(all))
+	for i := len(all) - 1; i >= 0; i-- {
+		newestFirst = append(newestFirst, all[i])
+	}
+	// Seeded messages are older than the recent two but newer than the
+	// archived six
+	want := append([]*models.Message{newestFirst[0], newestFirst[1], f.Messages[3], f.Messages[2]}, newestFirst[2:8]...)
+
+	page, err := d.GetConversationMessages(ctx, f.Group.ID, 5, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIDs(t, "offset page across the boundary", page, want[2:7]...)
+
+	var walked []models.Message
+	var before *cursor.Position
+	for {
+		page, err := d.GetConversationMessagesBefore(ctx, f.Group.ID, before, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		walked = append(walked, page...)
+		last := page[len(page)-1]
+		before = &cursor.Position{At: last.CreatedAt, ID: last.ID}
+	}
+	assertIDs(t, "cursor walk", walked, want...)
+}
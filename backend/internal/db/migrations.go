@@ -0,0 +1,583 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// migration is a forward-only schema change applied once and recorded in
+// schema_migrations. Append new entries; never edit or reorder old ones.
+type migration struct {
+	version int
+	name    string
+	stmts   []string
+	// foreignKeysOff runs the migration with foreign keys off, for one that
+	// rebuilds a table other tables reference. SQLite can't switch them
+	// inside a transaction, and dropping the old table would otherwise trip
+	// every reference to it.
+	foreignKeysOff bool
+}
+
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "add message_type to messages",
+		stmts: []string{
+			`ALTER TABLE messages ADD COLUMN message_type TEXT NOT NULL DEFAULT 'user'`,
+		},
+	},
+	{
+		version: 2,
+		name:    "add mirror_enabled to conversations",
+		stmts: []string{
+			`ALTER TABLE conversations ADD COLUMN mirror_enabled INTEGER NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		version: 3,
+		name:    "add message reports and soft-deleted messages",
+		stmts: []string{
+			`ALTER TABLE messages ADD COLUMN deleted_at DATETIME`,
+			`CREATE TABLE message_reports (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				message_id INTEGER NOT NULL,
+				reporter_id INTEGER NOT NULL,
+				reason TEXT NOT NULL,
+				note TEXT NOT NULL DEFAULT '',
+				status TEXT NOT NULL DEFAULT 'open',
+				resolved_by INTEGER,
+				resolved_at DATETIME,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE (message_id, reporter_id),
+				FOREIGN KEY (message_id) REFERENCES messages(id),
+				FOREIGN KEY (reporter_id) REFERENCES users(id)
+			)`,
+			`CREATE INDEX idx_message_reports_status ON message_reports(status)`,
+		},
+	},
+	{
+		// Rows written from Go before timestamps were normalized carry the
+		// host's UTC offset (e.g. "2024-01-02 10:00:00.123+02:00"). strftime
+		// converts them to UTC; rows without an offset are already UTC.
+		version: 4,
+		name:    "normalize timestamps to UTC",
+		stmts: []string{
+			utcBackfill("users", "created_at"),
+			utcBackfill("conversations", "created_at"),
+			utcBackfill("messages", "created_at"),
+			utcBackfill("message_reports", "created_at"),
+		},
+	},
+	{
+		version: 5,
+		name:    "add per-conversation message sequence numbers",
+		stmts: []string{
+			`ALTER TABLE conversations ADD COLUMN last_seq INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE messages ADD COLUMN seq INTEGER NOT NULL DEFAULT 0`,
+			`UPDATE messages SET seq = numbered.rn
+				FROM (
+					SELECT id, ROW_NUMBER() OVER (PARTITION BY conversation_id ORDER BY created_at, id) AS rn
+					FROM messages
+				) AS numbered
+				WHERE messages.id = numbered.id`,
+			`UPDATE conversations SET last_seq = COALESCE(
+				(SELECT MAX(seq) FROM messages WHERE messages.conversation_id = conversations.id), 0)`,
+			`CREATE UNIQUE INDEX idx_messages_conversation_seq ON messages(conversation_id, seq)`,
+		},
+	},
+	{
+		version: 6,
+		name:    "add per-participant notification settings",
+		stmts: []string{
+			`ALTER TABLE conversation_participants ADD COLUMN settings TEXT`,
+		},
+	},
+	{
+		version: 7,
+		name:    "add conversation topic",
+		stmts: []string{
+			`ALTER TABLE conversations ADD COLUMN topic TEXT`,
+		},
+	},
+	{
+		// Direct messages used to create one conversation per side of the
+		// pair. Fold each duplicate into the oldest conversation for that
+		// pair, renumber the merged history, and key direct conversations
+		// by pair so it can't happen again.
+		version: 8,
+		name:    "merge duplicate direct conversations",
+		stmts: []string{
+			`CREATE TEMP TABLE direct_pairs AS
+				SELECT c.id AS conversation_id, MIN(cp.user_id) AS user_a, MAX(cp.user_id) AS user_b
+				FROM conversations c
+				JOIN conversation_participants cp ON cp.conversation_id = c.id
+				WHERE c.type = 'direct'
+				GROUP BY c.id
+				HAVING COUNT(*) = 2`,
+			`CREATE TEMP TABLE direct_merge AS
+				SELECT p.conversation_id AS duplicate_id, k.keep_id
+				FROM direct_pairs p
+				JOIN (
+					SELECT user_a, user_b, MIN(conversation_id) AS keep_id
+					FROM direct_pairs
+					GROUP BY user_a, user_b
+				) k ON k.user_a = p.user_a AND k.user_b = p.user_b
+				WHERE p.conversation_id != k.keep_id`,
+			// Park seq on a unique negative value so the renumbering below
+			// never collides with idx_messages_conversation_seq
+			`UPDATE messages SET seq = -id
+				WHERE conversation_id IN (SELECT duplicate_id FROM direct_merge)
+				   OR conversation_id IN (SELECT keep_id FROM direct_merge)`,
+			`UPDATE messages SET conversation_id = (
+					SELECT keep_id FROM direct_merge WHERE duplicate_id = messages.conversation_id)
+				WHERE conversation_id IN (SELECT duplicate_id FROM direct_merge)`,
+			`UPDATE messages SET seq = numbered.rn
+				FROM (
+					SELECT id, ROW_NUMBER() OVER (PARTITION BY conversation_id ORDER BY created_at, id) AS rn
+					FROM messages
+					WHERE conversation_id IN (SELECT keep_id FROM direct_merge)
+				) AS numbered
+				WHERE messages.id = numbered.id`,
+			`UPDATE conversations SET last_seq = COALESCE(
+					(SELECT MAX(seq) FROM messages WHERE messages.conversation_id = conversations.id), 0)
+				WHERE id IN (SELECT keep_id FROM direct_merge)`,
+			`DELETE FROM conversation_participants WHERE conversation_id IN (SELECT duplicate_id FROM direct_merge)`,
+			`DELETE FROM conversations WHERE id IN (SELECT duplicate_id FROM direct_merge)`,
+			`ALTER TABLE conversations ADD COLUMN direct_key TEXT`,
+			`UPDATE conversations SET direct_key = (
+					SELECT user_a || ':' || user_b FROM direct_pairs WHERE conversation_id = conversations.id)
+				WHERE id IN (SELECT conversation_id FROM direct_pairs)
+				  AND id NOT IN (SELECT duplicate_id FROM direct_merge)`,
+			`CREATE UNIQUE INDEX idx_conversations_direct_key ON conversations(direct_key)`,
+			`DROP TABLE direct_merge`,
+			`DROP TABLE direct_pairs`,
+		},
+	},
+	{
+		version: 9,
+		name:    "add participant read markers",
+		stmts: []string{
+			`ALTER TABLE conversation_participants ADD COLUMN last_read_message_id INTEGER`,
+			`ALTER TABLE conversation_participants ADD COLUMN last_read_at DATETIME`,
+		},
+	},
+	{
+		version: 10,
+		name:    "add delivery markers and read receipt privacy",
+		stmts: []string{
+			`ALTER TABLE conversation_participants ADD COLUMN last_delivered_message_id INTEGER`,
+			`ALTER TABLE conversation_participants ADD COLUMN last_delivered_at DATETIME`,
+			`ALTER TABLE users ADD COLUMN read_receipts INTEGER NOT NULL DEFAULT 1`,
+		},
+	},
+	{
+		version: 11,
+		name:    "add conversation mute",
+		stmts: []string{
+			`ALTER TABLE conversation_participants ADD COLUMN muted_until DATETIME`,
+			`ALTER TABLE conversation_participants ADD COLUMN mute_mentions INTEGER NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		version: 12,
+		name:    "add conversation pins",
+		stmts: []string{
+			`ALTER TABLE conversation_participants ADD COLUMN pinned_at DATETIME`,
+			`CREATE INDEX IF NOT EXISTS idx_participants_pinned ON conversation_participants(user_id, pinned_at) WHERE pinned_at IS NOT NULL`,
+		},
+	},
+	{
+		version: 13,
+		name:    "add bots and their commands",
+		stmts: []string{
+			`ALTER TABLE users ADD COLUMN is_bot INTEGER NOT NULL DEFAULT 0`,
+			`CREATE TABLE IF NOT EXISTS bots (
+				user_id INTEGER PRIMARY KEY REFERENCES users(id),
+				api_key_hash TEXT NOT NULL UNIQUE,
+				webhook_url TEXT NOT NULL DEFAULT ''
+			)`,
+			`CREATE TABLE IF NOT EXISTS bot_commands (
+				command TEXT PRIMARY KEY,
+				bot_id INTEGER NOT NULL REFERENCES bots(user_id),
+				description TEXT NOT NULL DEFAULT '',
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_bot_commands_bot ON bot_commands(bot_id)`,
+		},
+	},
+	{
+		// Existing groups are handed to their longest-standing member
+		version: 14,
+		name:    "add participant roles",
+		stmts: []string{
+			`ALTER TABLE conversation_participants ADD COLUMN role TEXT NOT NULL DEFAULT 'member'`,
+			`UPDATE conversation_participants SET role = 'owner'
+				WHERE (conversation_id, user_id) IN (
+					SELECT conversation_id, user_id FROM (
+						SELECT cp.conversation_id, cp.user_id,
+						       ROW_NUMBER() OVER (PARTITION BY cp.conversation_id ORDER BY cp.joined_at, cp.user_id) AS position
+						FROM conversation_participants cp
+						JOIN conversations c ON c.id = cp.conversation_id
+						WHERE c.type = 'group'
+					)
+					WHERE position = 1
+				)`,
+		},
+	},
+	{
+		// id, not device_id, is what sessions hold, so a revoked device that
+		// registers again doesn't revive tokens issued before the revocation
+		version: 15,
+		name:    "add devices",
+		stmts: []string{
+			`CREATE TABLE IF NOT EXISTS devices (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL REFERENCES users(id),
+				device_id TEXT NOT NULL,
+				name TEXT NOT NULL DEFAULT '',
+				platform TEXT NOT NULL DEFAULT '',
+				created_at DATETIME NOT NULL,
+				last_seen_at DATETIME NOT NULL,
+				UNIQUE (user_id, device_id)
+			)`,
+		},
+	},
+	{
+		version: 16,
+		name:    "add mail outbox and email verification",
+		stmts: []string{
+			`CREATE TABLE IF NOT EXISTS mail_outbox (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				recipient TEXT NOT NULL,
+				template TEXT NOT NULL,
+				subject TEXT NOT NULL,
+				text_body TEXT NOT NULL,
+				html_body TEXT NOT NULL,
+				status TEXT NOT NULL DEFAULT 'pending',
+				attempts INTEGER NOT NULL DEFAULT 0,
+				last_error TEXT NOT NULL DEFAULT '',
+				next_attempt_at DATETIME NOT NULL,
+				created_at DATETIME NOT NULL,
+				sent_at DATETIME
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_mail_outbox_due ON mail_outbox(next_attempt_at) WHERE status = 'pending'`,
+			`ALTER TABLE users ADD COLUMN email TEXT`,
+			`ALTER TABLE users ADD COLUMN email_verified_at DATETIME`,
+			`CREATE TABLE IF NOT EXISTS email_verifications (
+				token_hash TEXT PRIMARY KEY,
+				user_id INTEGER NOT NULL REFERENCES users(id),
+				email TEXT NOT NULL,
+				expires_at DATETIME NOT NULL,
+				created_at DATETIME NOT NULL
+			)`,
+		},
+	},
+	{
+		version: 17,
+		name:    "add per-member custom conversation names",
+		stmts: []string{
+			`ALTER TABLE conversation_participants ADD COLUMN custom_name TEXT`,
+		},
+	},
+	{
+		version: 18,
+		name:    "add per-conversation message retention",
+		stmts: []string{
+			`ALTER TABLE conversations ADD COLUMN retention_days INTEGER`,
+		},
+	},
+	{
+		version: 19,
+		name:    "add user last seen",
+		stmts: []string{
+			`ALTER TABLE users ADD COLUMN last_seen_at DATETIME`,
+		},
+	},
+	{
+		version: 20,
+		name:    "create notifications",
+		stmts: []string{
+			`CREATE TABLE IF NOT EXISTS notifications (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL REFERENCES users(id),
+				category TEXT NOT NULL,
+				title TEXT NOT NULL,
+				body TEXT NOT NULL DEFAULT '',
+				data TEXT,
+				created_at DATETIME NOT NULL,
+				read_at DATETIME
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_notifications_user ON notifications(user_id, id)`,
+		},
+	},
+	{
+		version: 21,
+		name:    "create message outbox",
+		stmts: []string{
+			`CREATE TABLE IF NOT EXISTS message_outbox (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				message_id INTEGER NOT NULL,
+				created_at DATETIME NOT NULL,
+				sent_at DATETIME
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_message_outbox_sent ON message_outbox(sent_at)`,
+		},
+	},
+	{
+		version: 22,
+		name:    "index hot query paths",
+		stmts: []string{
+			// History pages are ordered by messageTimeKey; indexing the same
+			// expression lets them walk the index instead of sorting
+			`CREATE INDEX IF NOT EXISTS idx_messages_conversation_time ON messages(conversation_id, unixepoch(created_at, 'subsec'), id)`,
+			// The primary key leads with conversation_id, so listing a
+			// user's conversations otherwise scans every membership
+			`CREATE INDEX IF NOT EXISTS idx_participants_user ON conversation_participants(user_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_users_username_nocase ON users(username COLLATE NOCASE)`,
+		},
+	},
+	{
+		version: 23,
+		name:    "soft-delete users",
+		stmts: []string{
+			// Deleted users keep their row so messages and memberships that
+			// reference them stay intact, see users.go
+			`ALTER TABLE users ADD COLUMN deleted_at DATETIME`,
+		},
+	},
+	{
+		version: 24,
+		name:    "add unread counts",
+		stmts: []string{
+			// Maintained on every send and read, see unread.go; the
+			// backfill counts the same way
+			`ALTER TABLE conversation_participants ADD COLUMN unread_count INTEGER NOT NULL DEFAULT 0`,
+			`UPDATE conversation_participants SET unread_count = (
+				SELECT COUNT(*) FROM messages m
+				WHERE m.conversation_id = conversation_participants.conversation_id
+				  AND m.id > COALESCE(conversation_participants.last_read_message_id, 0)
+				  AND m.message_type = 'user' AND m.deleted_at IS NULL
+				  AND m.sender_id IS NOT conversation_participants.user_id
+				  AND unixepoch(m.created_at, 'subsec') >= unixepoch(conversation_participants.joined_at, 'subsec')
+			)`,
+		},
+	},
+	{
+		version: 25,
+		name:    "add conversation last message",
+		stmts: []string{
+			// Maintained on every insert, see lastmessage.go
+			`ALTER TABLE conversations ADD COLUMN last_message_id INTEGER`,
+			`ALTER TABLE conversations ADD COLUMN last_message_at DATETIME`,
+			`UPDATE conversations SET (last_message_id, last_message_at) = (
+				SELECT id, created_at FROM messages m WHERE m.conversation_id = conversations.id ORDER BY seq DESC LIMIT 1
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_conversations_last_message ON conversations(unixepoch(last_message_at, 'subsec') DESC, id DESC)`,
+		},
+	},
+	{
+		// SQLite can't alter a foreign key, so the tables are rebuilt with
+		// their current columns. Deleting a conversation takes its
+		// participants, messages and their reports with it. Users are only
+		// ever soft-deleted (see users.go), so deleting one that anything
+		// still references is refused. Orphans left by older versions are
+		// copied as they are; CheckOrphans finds and cleans them.
+		version:        26,
+		name:           "add delete rules to foreign keys",
+		foreignKeysOff: true,
+		stmts: []string{
+			`CREATE TABLE conversation_participants_new (
+				conversation_id INTEGER REFERENCES conversations(id) ON DELETE CASCADE,
+				user_id INTEGER REFERENCES users(id) ON DELETE RESTRICT,
+				joined_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				settings TEXT,
+				last_read_message_id INTEGER,
+				last_read_at DATETIME,
+				last_delivered_message_id INTEGER,
+				last_delivered_at DATETIME,
+				muted_until DATETIME,
+				mute_mentions INTEGER NOT NULL DEFAULT 0,
+				pinned_at DATETIME,
+				role TEXT NOT NULL DEFAULT 'member',
+				custom_name TEXT,
+				unread_count INTEGER NOT NULL DEFAULT 0,
+				PRIMARY KEY (conversation_id, user_id)
+			)`,
+			`INSERT INTO conversation_participants_new (conversation_id, user_id, joined_at, settings,
+					last_read_message_id, last_read_at, last_delivered_message_id, last_delivered_at,
+					muted_until, mute_mentions, pinned_at, role, custom_name, unread_count)
+				SELECT conversation_id, user_id, joined_at, settings,
+					last_read_message_id, last_read_at, last_delivered_message_id, last_delivered_at,
+					muted_until, mute_mentions, pinned_at, role, custom_name, unread_count
+				FROM conversation_participants`,
+			`DROP TABLE conversation_participants`,
+			`ALTER TABLE conversation_participants_new RENAME TO conversation_participants`,
+			`CREATE INDEX idx_participants_pinned ON conversation_participants(user_id, pinned_at) WHERE pinned_at IS NOT NULL`,
+			`CREATE INDEX idx_participants_user ON conversation_participants(user_id)`,
+
+			`CREATE TABLE messages_new (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				conversation_id INTEGER REFERENCES conversations(id) ON DELETE CASCADE,
+				sender_id INTEGER REFERENCES users(id) ON DELETE RESTRICT,
+				content TEXT NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				message_type TEXT NOT NULL DEFAULT 'user',
+				deleted_at DATETIME,
+				seq INTEGER NOT NULL DEFAULT 0
+			)`,
+			`INSERT INTO messages_new (id, conversation_id, sender_id, content, created_at, message_type, deleted_at, seq)
+				SELECT id, conversation_id, sender_id, content, created_at, message_type, deleted_at, seq FROM messages`,
+			// Carry the AUTOINCREMENT high-water mark over, so IDs of
+			// messages deleted from the end are never handed out again
+			`DELETE FROM sqlite_sequence WHERE name = 'messages_new'`,
+			`INSERT INTO sqlite_sequence (name, seq) SELECT 'messages_new', seq FROM sqlite_sequence WHERE name = 'messages'`,
+			`DROP TABLE messages`,
+			`ALTER TABLE messages_new RENAME TO messages`,
+			`CREATE UNIQUE INDEX idx_messages_conversation_seq ON messages(conversation_id, seq)`,
+			`CREATE INDEX idx_messages_conversation_time ON messages(conversation_id, unixepoch(created_at, 'subsec'), id)`,
+
+			`CREATE TABLE message_reports_new (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				message_id INTEGER NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+				reporter_id INTEGER NOT NULL REFERENCES users(id) ON DELETE RESTRICT,
+				reason TEXT NOT NULL,
+				note TEXT NOT NULL DEFAULT '',
+				status TEXT NOT NULL DEFAULT 'open',
+				resolved_by INTEGER,
+				resolved_at DATETIME,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE (message_id, reporter_id)
+			)`,
+			`INSERT INTO message_reports_new (id, message_id, reporter_id, reason, note, status, resolved_by, resolved_at, created_at)
+				SELECT id, message_id, reporter_id, reason, note, status, resolved_by, resolved_at, created_at FROM message_reports`,
+			`DELETE FROM sqlite_sequence WHERE name = 'message_reports_new'`,
+			`INSERT INTO sqlite_sequence (name, seq) SELECT 'message_reports_new', seq FROM sqlite_sequence WHERE name = 'message_reports'`,
+			`DROP TABLE message_reports`,
+			`ALTER TABLE message_reports_new RENAME TO message_reports`,
+			`CREATE INDEX idx_message_reports_status ON message_reports(status)`,
+		},
+	},
+	{
+		// content_key names the key content is sealed with, NULL for
+		// plaintext; see contentcrypt.go
+		version: 27,
+		name:    "add content encryption columns to messages",
+		stmts: []string{
+			`ALTER TABLE messages ADD COLUMN content_key TEXT`,
+			`ALTER TABLE messages ADD COLUMN content_nonce BLOB`,
+		},
+	},
+	{
+		// Old messages move here to keep messages small; see archive.go.
+		// Rows keep their IDs, and AUTOINCREMENT on messages never hands
+		// those out again. archived_through_* is the newest message a
+		// conversation has in the archive, so history pages above it never
+		// read the archive.
+		version: 28,
+		name:    "create messages archive",
+		stmts: []string{
+			`CREATE TABLE messages_archive (
+				id INTEGER PRIMARY KEY,
+				conversation_id INTEGER REFERENCES conversations(id) ON DELETE CASCADE,
+				sender_id INTEGER REFERENCES users(id) ON DELETE RESTRICT,
+				content TEXT NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				message_type TEXT NOT NULL DEFAULT 'user',
+				deleted_at DATETIME,
+				seq INTEGER NOT NULL DEFAULT 0,
+				content_key TEXT,
+				content_nonce BLOB
+			)`,
+			`CREATE UNIQUE INDEX idx_messages_archive_conversation_seq ON messages_archive(conversation_id, seq)`,
+			`CREATE INDEX idx_messages_archive_conversation_time ON messages_archive(conversation_id, unixepoch(created_at, 'subsec'), id)`,
+			`ALTER TABLE conversations ADD COLUMN archived_through_at DATETIME`,
+			`ALTER TABLE conversations ADD COLUMN archived_through_id INTEGER`,
+		},
+	},
+	{
+		// Content is now sealed with its message and conversation IDs as
+		// additional data. Rows sealed before that open without it until
+		// ReencryptMessages rewrites them; see contentcrypt.go.
+		version: 29,
+		name:    "bind encrypted content to its message",
+		stmts: []string{
+			`ALTER TABLE messages ADD COLUMN content_bound INTEGER NOT NULL DEFAULT 1`,
+			`ALTER TABLE messages_archive ADD COLUMN content_bound INTEGER NOT NULL DEFAULT 1`,
+			`UPDATE messages SET content_bound = 0 WHERE content_key IS NOT NULL`,
+			`UPDATE messages_archive SET content_bound = 0 WHERE content_key IS NOT NULL`,
+		},
+	},
+}
+
+// utcBackfill rewrites offset-suffixed timestamps in column to UTC
+func utcBackfill(table, column string) string {
+	return fmt.Sprintf(`UPDATE %[1]s SET %[2]s = strftime('%%Y-%%m-%%d %%H:%%M:%%f', %[2]s)
+		WHERE %[2]s LIKE '%%+__:__' OR %[2]s LIKE '%%-__:__'`, table, column)
+}
+
+// migrate applies any migrations newer than the recorded schema version
+func migrate(db *sql.DB) error {
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)
+	`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %v", err)
+	}
+
+	var current int
+	if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %v", err)
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := applyMigration(db, m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applyMigration runs m and records it in one transaction, on a connection
+// of its own so foreignKeysOff only affects the migration
+func applyMigration(db *sql.DB, m migration) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin migration %d: %v", m.version, err)
+	}
+	defer conn.Close()
+
+	if m.foreignKeysOff {
+		if _, err := conn.ExecContext(ctx, `PRAGMA foreign_keys = OFF`); err != nil {
+			return fmt.Errorf("failed to disable foreign keys for migration %d: %v", m.version, err)
+		}
+		defer conn.ExecContext(ctx, `PRAGMA foreign_keys = ON`)
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin migration %d: %v", m.version, err)
+	}
+	for _, stmt := range m.stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d (%s) failed: %v", m.version, m.name, err)
+		}
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES (?, ?)`, m.version, m.name); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to record migration %d: %v", m.version, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration %d: %v", m.version, err)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+
+	"messager/internal/db"
+	"messager/internal/db/testdb"
+)
+
+// schemaVersions lists the versions recorded in schema_migrations, in order
+func schemaVersions(t *testing.T, d *db.DB) []int {
+	t.Helper()
+	rows, err := d.Query(`SELECT version FROM schema_migrations ORDER BY version`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var versions []int
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			t.Fatal(err)
+		}
+		versions = append(versions, v)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return versions
+}
+
+func TestMigrationsRecordEveryVersion(t *testing.T) {
+	d := testdb.Open(t)
+
+	versions := schemaVersions(t, d)
+	if len(versions) != db.LatestMigration {
+		t.Fatalf("%d migrations recorded, want %d", len(versions), db.LatestMigration)
+	}
+	for i, v := range versions {
+		if v != i+1 {
+			t.Fatalf("versions %v aren't 1 to %d without gaps", versions, db.LatestMigration)
+		}
+	}
+
+	var integrity string
+	if err := d.QueryRow(`PRAGMA integrity_check`).Scan(&integrity); err != nil || integrity != "ok" {
+		t.Errorf("integrity check: %q, %v", integrity, err)
+	}
+	rows, err := d.Query(`PRAGMA foreign_key_check`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	if rows.Next() {
+		t.Error("a fresh schema fails its own foreign key check")
+	}
+}
+
+func TestReopenDoesNotMigrateAgain(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	path := d.Path()
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := db.NewDB(path)
+	if err != nil {
+		t.Fatalf("reopening a migrated database: %v", err)
+	}
+	defer reopened.Close()
+	if versions := schemaVersions(t, reopened); len(versions) != db.LatestMigration {
+		t.Errorf("%d migrations recorded after reopening, want %d", len(versions), db.LatestMigration)
+	}
+	ids, err := reopened.GetConversationParticipantIDs(context.Background(), f.Group.ID)
+	if err != nil || len(ids) != 3 {
+		t.Errorf("group members after reopening: %v, %v", ids, err)
+	}
+}
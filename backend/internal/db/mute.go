@@ -0,0 +1,71 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"messager/internal/models"
+)
+
+// SetMute mutes a conversation for a member until the given time, or unmutes
+// it when until is nil. Returns ErrNotFound if the user isn't a member.
+func (db *DB) SetMute(ctx context.Context, conversationID, userID int64, until *time.Time, muteMentions bool) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.guardWrite(); err != nil {
+		return err
+	}
+
+	var mutedUntil interface{}
+	if until != nil {
+		mutedUntil = until.UTC()
+	}
+	result, err := db.DB.ExecContext(ctx, `
+		UPDATE conversation_participants SET muted_until = ?, mute_mentions = ?
+		WHERE conversation_id = ? AND user_id = ?
+	`, mutedUntil, until != nil && muteMentions, conversationID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update mute: %w", db.checkWrite(err))
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetActiveMutes returns the members of a conversation whose mute is in
+// force at now
+func (db *DB) GetActiveMutes(ctx context.Context, conversationID int64, now time.Time) (map[int64]models.MuteState, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT user_id, muted_until, mute_mentions
+		FROM conversation_participants
+		WHERE conversation_id = ? AND muted_until IS NOT NULL
+	`, conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query mutes: %v", err)
+	}
+	defer rows.Close()
+
+	mutes := make(map[int64]models.MuteState)
+	for rows.Next() {
+		var userID int64
+		var until time.Time
+		var state models.MuteState
+		if err := rows.Scan(&userID, &until, &state.MuteMentions); err != nil {
+			return nil, fmt.Errorf("failed to scan mute: %v", err)
+		}
+		state.MutedUntil = &until
+		if state.Active(now) {
+			mutes[userID] = state
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating mutes: %v", err)
+	}
+	return mutes, nil
+}
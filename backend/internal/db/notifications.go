@@ -0,0 +1,195 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"messager/internal/models"
+)
+
+// CreateNotification stores n for n.UserID, filling in its ID and
+// CreatedAt
+func (db *DB) CreateNotification(ctx context.Context, n *models.Notification) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.guardWrite(); err != nil {
+		return err
+	}
+
+	var data sql.NullString
+	if len(n.Data) > 0 {
+		encoded, err := json.Marshal(n.Data)
+		if err != nil {
+			return fmt.Errorf("failed to encode notification data: %v", err)
+		}
+		data = sql.NullString{String: string(encoded), Valid: true}
+	}
+
+	n.CreatedAt = time.Now().UTC()
+	result, err := db.DB.ExecContext(ctx, `
+		INSERT INTO notifications (user_id, category, title, body, data, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, n.UserID, n.Category, n.Title, n.Body, data, n.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create notification: %w", db.checkWrite(err))
+	}
+	n.ID, err = result.LastInsertId()
+	return err
+}
+
+// GetNotifications returns up to limit of a user's notifications, newest
+// first and older than beforeID when it's set, along with how many are
+// unread in total
+func (db *DB) GetNotifications(ctx context.Context, userID, beforeID int64, limit int, unreadOnly bool) ([]models.Notification, int, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT id, category, title, body, data, created_at, read_at
+		FROM notifications
+		WHERE user_id = ?`
+	args := []interface{}{userID}
+	if beforeID > 0 {
+		query += ` AND id < ?`
+		args = append(args, beforeID)
+	}
+	if unreadOnly {
+		query += ` AND read_at IS NULL`
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get notifications: %v", err)
+	}
+	defer rows.Close()
+
+	notifications := []models.Notification{}
+	for rows.Next() {
+		n := models.Notification{UserID: userID}
+		var data sql.NullString
+		var readAt sql.NullTime
+		if err := rows.Scan(&n.ID, &n.Category, &n.Title, &n.Body, &data, &n.CreatedAt, &readAt); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan notification: %v", err)
+		}
+		if data.Valid {
+			if err := json.Unmarshal([]byte(data.String), &n.Data); err != nil {
+				return nil, 0, fmt.Errorf("failed to decode notification %d data: %v", n.ID, err)
+			}
+		}
+		if readAt.Valid {
+			n.ReadAt = &readAt.Time
+		}
+		notifications = append(notifications, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	var unread int
+	if err := db.DB.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM notifications WHERE user_id = ? AND read_at IS NULL
+	`, userID).Scan(&unread); err != nil {
+		return nil, 0, fmt.Errorf("failed to count unread notifications: %v", err)
+	}
+	return notifications, unread, nil
+}
+
+// MarkNotificationsRead marks the user's notifications with the given IDs
+// read, or all of them when ids is empty, and returns how many changed.
+// IDs belonging to other users are ignored.
+func (db *DB) MarkNotificationsRead(ctx context.Context, userID int64, ids []int64) (int64, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.guardWrite(); err != nil {
+		return 0, err
+	}
+
+	query := `UPDATE notifications SET read_at = ? WHERE user_id = ? AND read_at IS NULL`
+	args := []interface{}{time.Now().UTC(), userID}
+	if len(ids) > 0 {
+		query += ` AND id IN (?` + strings.Repeat(", ?", len(ids)-1) + `)`
+		for _, id := range ids {
+			args = append(args, id)
+		}
+	}
+
+	result, err := db.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark notifications read: %w", db.checkWrite(err))
+	}
+	return result.RowsAffected()
+}
+
+// CreateNotificationForAll stores a copy of n for every user who isn't a
+// bot, except those in skip, in one transaction, and returns how many were
+// stored. n.UserID is ignored.
+func (db *DB) CreateNotificationForAll(ctx context.Context, n models.Notification, skip []int64) (int, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.guardWrite(); err != nil {
+		return 0, err
+	}
+
+	var data sql.NullString
+	if len(n.Data) > 0 {
+		encoded, err := json.Marshal(n.Data)
+		if err != nil {
+			return 0, fmt.Errorf("failed to encode notification data: %v", err)
+		}
+		data = sql.NullString{String: string(encoded), Valid: true}
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", db.checkWrite(err))
+	}
+	defer tx.Rollback()
+
+	skipped := make(map[int64]bool, len(skip))
+	for _, userID := range skip {
+		skipped[userID] = true
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT id FROM users WHERE is_bot = 0 AND deleted_at IS NULL`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list users: %v", err)
+	}
+	var userIDs []int64
+	for rows.Next() {
+		var userID int64
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan user: %v", err)
+		}
+		if !skipped[userID] {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	now := time.Now().UTC()
+	for _, userID := range userIDs {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO notifications (user_id, category, title, body, data, created_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, userID, n.Category, n.Title, n.Body, data, now); err != nil {
+			return 0, fmt.Errorf("failed to create notification: %w", db.checkWrite(err))
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", db.checkWrite(err))
+	}
+	return len(userIDs), nil
+}
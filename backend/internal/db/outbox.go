@@ -0,0 +1,83 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PendingOutbox returns the IDs of up to limit messages, oldest first,
+// whose fan-out hasn't been recorded and that were saved before cutoff
+func (db *DB) PendingOutbox(ctx context.Context, cutoff time.Time, limit int) ([]int64, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT message_id FROM message_outbox
+		WHERE sent_at IS NULL AND created_at < ?
+		ORDER BY id
+		LIMIT ?
+	`, cutoff.UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load outbox: %v", err)
+	}
+	defer rows.Close()
+
+	var messageIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan outbox row: %v", err)
+		}
+		messageIDs = append(messageIDs, id)
+	}
+	return messageIDs, rows.Err()
+}
+
+// MarkOutboxSent records that the given messages have been fanned out
+func (db *DB) MarkOutboxSent(ctx context.Context, messageIDs []int64) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if len(messageIDs) == 0 {
+		return nil
+	}
+	if err := db.guardWrite(); err != nil {
+		return err
+	}
+
+	args := []interface{}{time.Now().UTC()}
+	for _, id := range messageIDs {
+		args = append(args, id)
+	}
+	_, err := db.DB.ExecContext(ctx, `
+		UPDATE message_outbox SET sent_at = ?
+		WHERE sent_at IS NULL AND message_id IN (?`+strings.Repeat(", ?", len(messageIDs)-1)+`)
+	`, args...)
+	if err != nil {
+		return fmt.Errorf("failed to mark outbox sent: %w", db.checkWrite(err))
+	}
+	return nil
+}
+
+// PruneOutbox deletes up to limit rows sent before cutoff and returns how
+// many went
+func (db *DB) PruneOutbox(ctx context.Context, cutoff time.Time, limit int) (int64, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.guardWrite(); err != nil {
+		return 0, err
+	}
+
+	result, err := db.DB.ExecContext(ctx, `
+		DELETE FROM message_outbox WHERE id IN (
+			SELECT id FROM message_outbox WHERE sent_at < ? LIMIT ?
+		)
+	`, cutoff.UTC(), limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune outbox: %w", db.checkWrite(err))
+	}
+	return result.RowsAffected()
+}
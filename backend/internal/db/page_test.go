@@ -0,0 +1,65 @@
+package db_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"messager/internal/cursor"
+	"messager/internal/db/testdb"
+	"messager/internal/models"
+)
+
+func TestPagingSurvivesDeletedAnchor(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+	// Seven messages in the group, the two seeded ones oldest
+	var all []*models.Message
+	all = append(all, f.Messages[2], f.Messages[3])
+	for i := 0; i < 5; i++ {
+		msg, err := d.CreateMessage(ctx, f.Group.ID, f.Bob.ID, fmt.Sprintf("message %d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, msg)
+	}
+
+	first, err := d.GetConversationMessagesBefore(ctx, f.Group.ID, nil, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIDs(t, "first page", first, all[6], all[5], all[4])
+
+	// The anchor goes away between pages
+	anchor := first[len(first)-1]
+	if _, err := d.Exec(`DELETE FROM messages WHERE id = ?`, anchor.ID); err != nil {
+		t.Fatal(err)
+	}
+	pos := cursor.Position{At: anchor.CreatedAt, ID: anchor.ID}
+	second, err := d.GetConversationMessagesBefore(ctx, f.Group.ID, &pos, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIDs(t, "second page", second, all[3], all[2], all[1])
+
+	last := second[len(second)-1]
+	pos = cursor.Position{At: last.CreatedAt, ID: last.ID}
+	third, err := d.GetConversationMessagesBefore(ctx, f.Group.ID, &pos, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIDs(t, "third page", third, all[0])
+}
+
+func assertIDs(t *testing.T, what string, got []models.Message, want ...*models.Message) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s: %d messages, want %d", what, len(got), len(want))
+	}
+	for i := range want {
+		if got[i].ID != want[i].ID {
+			t.Errorf("%s[%d] = message %d, want %d", what, i, got[i].ID, want[i].ID)
+		}
+	}
+}
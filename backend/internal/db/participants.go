@@ -0,0 +1,395 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"messager/internal/models"
+)
+
+// AddConversationParticipants adds userIDs to a conversation in a single
+// transaction. Each user gets its own outcome: users that don't exist and
+// users who are already members are reported rather than failing the batch.
+func (db *DB) AddConversationParticipants(ctx context.Context, conversationID int64, userIDs []int64) ([]models.ParticipantResult, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.guardWrite(); err != nil {
+		return nil, err
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", db.checkWrite(err))
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	seen := make(map[int64]bool, len(userIDs))
+	results := make([]models.ParticipantResult, 0, len(userIDs))
+	for _, userID := range userIDs {
+		if seen[userID] {
+			continue
+		}
+		seen[userID] = true
+
+		var exists int
+		err := tx.QueryRowContext(ctx, `SELECT 1 FROM users WHERE id = ? AND deleted_at IS NULL`, userID).Scan(&exists)
+		if err == sql.ErrNoRows {
+			results = append(results, models.ParticipantResult{UserID: userID, Status: models.ParticipantNotFound})
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up user %d: %v", userID, err)
+		}
+
+		result, err := tx.ExecContext(ctx, `
+			INSERT OR IGNORE INTO conversation_participants (conversation_id, user_id, joined_at)
+			VALUES (?, ?, ?)
+		`, conversationID, userID, now)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add participant %d: %w", userID, db.checkWrite(err))
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			results = append(results, models.ParticipantResult{UserID: userID, Status: models.ParticipantAlreadyMember})
+			continue
+		}
+		results = append(results, models.ParticipantResult{UserID: userID, Status: models.ParticipantAdded})
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", db.checkWrite(err))
+	}
+	db.InvalidateMembership(conversationID)
+	return results, nil
+}
+
+// RemoveConversationParticipant removes a member. When nobody is left the
+// conversation is deleted outright along with its messages and reports, so
+// empty conversations don't linger. When the owner leaves, ownership passes
+// to the longest-standing admin, or failing that member, whose ID is
+// returned as newOwnerID. It returns the number of remaining participants,
+// or ErrNotFound if the user wasn't a member.
+func (db *DB) RemoveConversationParticipant(ctx context.Context, conversationID, userID int64) (remaining int, newOwnerID int64, err error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.guardWrite(); err != nil {
+		return 0, 0, err
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to begin transaction: %w", db.checkWrite(err))
+	}
+	defer tx.Rollback()
+
+	var role string
+	err = tx.QueryRowContext(ctx, `
+		DELETE FROM conversation_participants WHERE conversation_id = ? AND user_id = ?
+		RETURNING role
+	`, conversationID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return 0, 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to remove participant: %w", db.checkWrite(err))
+	}
+
+	err = tx.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM conversation_participants WHERE conversation_id = ?
+	`, conversationID).Scan(&remaining)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to count participants: %v", err)
+	}
+
+	if role == models.RoleOwner && remaining > 0 {
+		err = tx.QueryRowContext(ctx, `
+			UPDATE conversation_participants SET role = ?
+			WHERE conversation_id = ? AND user_id = (
+				SELECT user_id FROM conversation_participants
+				WHERE conversation_id = ?
+				ORDER BY role != ?, joined_at, user_id
+				LIMIT 1
+			)
+			RETURNING user_id
+		`, models.RoleOwner, conversationID, conversationID, models.RoleAdmin).Scan(&newOwnerID)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to transfer ownership: %w", db.checkWrite(err))
+		}
+	}
+
+	if remaining == 0 {
+		if _, err := deleteConversationRows(ctx, tx, conversationID); err != nil {
+			return 0, 0, fmt.Errorf("failed to delete empty conversation: %w", db.checkWrite(err))
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("failed to commit transaction: %w", db.checkWrite(err))
+	}
+
+	db.InvalidateMembership(conversationID)
+	if remaining == 0 {
+		db.names.forgetConversation(conversationID)
+	}
+	return remaining, newOwnerID, nil
+}
+
+// GetContactIDs returns every other user who shares at least one
+// conversation with userID
+func (db *DB) GetContactIDs(ctx context.Context, userID int64) ([]int64, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT DISTINCT other.user_id
+		FROM conversation_participants mine
+		JOIN conversation_participants other ON other.conversation_id = mine.conversation_id
+		WHERE mine.user_id = ? AND other.user_id != ?
+	`, userID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get contacts: %v", err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan contact ID: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetParticipantRole returns a member's role in a conversation, or
+// ErrNotFound if the user isn't a member
+func (db *DB) GetParticipantRole(ctx context.Context, conversationID, userID int64) (string, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var role string
+	err := db.DB.QueryRowContext(ctx, `
+		SELECT role FROM conversation_participants WHERE conversation_id = ? AND user_id = ?
+	`, conversationID, userID).Scan(&role)
+	return role, classify(err)
+}
+
+// SetParticipantRole changes a member's role. Returns ErrNotFound if the
+// user isn't a member.
+func (db *DB) SetParticipantRole(ctx context.Context, conversationID, userID int64, role string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.guardWrite(); err != nil {
+		return err
+	}
+
+	result, err := db.DB.ExecContext(ctx, `
+		UPDATE conversation_participants SET role = ? WHERE conversation_id = ? AND user_id = ?
+	`, role, conversationID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update role: %w", db.checkWrite(err))
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// TransferOwnership makes toID the owner of a group and demotes the current
+// owner, fromID, to admin in one transaction. Returns ErrNotFound if
+// fromID isn't the owner or toID isn't a member.
+func (db *DB) TransferOwnership(ctx context.Context, conversationID, fromID, toID int64) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.guardWrite(); err != nil {
+		return err
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", db.checkWrite(err))
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE conversation_participants SET role = ?
+		WHERE conversation_id = ? AND user_id = ? AND role = ?
+	`, models.RoleAdmin, conversationID, fromID, models.RoleOwner)
+	if err != nil {
+		return fmt.Errorf("failed to demote owner: %w", db.checkWrite(err))
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+
+	result, err = tx.ExecContext(ctx, `
+		UPDATE conversation_participants SET role = ? WHERE conversation_id = ? AND user_id = ?
+	`, models.RoleOwner, conversationID, toID)
+	if err != nil {
+		return fmt.Errorf("failed to promote owner: %w", db.checkWrite(err))
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", db.checkWrite(err))
+	}
+	return nil
+}
+
+// GetParticipantSettings returns a member's settings blob for a conversation,
+// nil when none are set, or ErrNotFound if the user isn't a member
+func (db *DB) GetParticipantSettings(ctx context.Context, conversationID, userID int64) (json.RawMessage, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var settings sql.NullString
+	err := db.DB.QueryRowContext(ctx, `
+		SELECT settings FROM conversation_participants WHERE conversation_id = ? AND user_id = ?
+	`, conversationID, userID).Scan(&settings)
+	if err != nil {
+		return nil, classify(err)
+	}
+	if !settings.Valid {
+		return nil, nil
+	}
+	return json.RawMessage(settings.String), nil
+}
+
+// SetParticipantSettings replaces a member's settings blob; nil clears it.
+// Returns ErrNotFound if the user isn't a member.
+func (db *DB) SetParticipantSettings(ctx context.Context, conversationID, userID int64, settings json.RawMessage) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.guardWrite(); err != nil {
+		return err
+	}
+
+	var value interface{}
+	if settings != nil {
+		value = string(settings)
+	}
+	result, err := db.DB.ExecContext(ctx, `
+		UPDATE conversation_participants SET settings = ? WHERE conversation_id = ? AND user_id = ?
+	`, value, conversationID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update settings: %w", db.checkWrite(err))
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetParticipantsForConversations returns the members of each conversation
+// in join order, keyed by conversation ID, in one query. Conversations with
+// no members, or that don't exist, are left out. Membership is read from
+// the primary, since it decides who receives what.
+func (db *DB) GetParticipantsForConversations(ctx context.Context, conversationIDs []int64) (map[int64][]models.User, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	participants := make(map[int64][]models.User, len(conversationIDs))
+	if len(conversationIDs) == 0 {
+		return participants, nil
+	}
+	args := make([]interface{}, len(conversationIDs))
+	for i, id := range conversationIDs {
+		args[i] = id
+	}
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT cp.conversation_id, u.id, `+visibleUsernameSQL+`, `+visibleAvatarSQL+`, u.is_bot, u.created_at, u.last_seen_at
+		FROM conversation_participants cp
+		JOIN users u ON u.id = cp.user_id
+		WHERE cp.conversation_id IN (?`+strings.Repeat(", ?", len(conversationIDs)-1)+`)
+		ORDER BY cp.conversation_id, cp.joined_at, u.id
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query participants: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var conversationID int64
+		var user models.User
+		var lastSeen sql.NullTime
+		if err := rows.Scan(&conversationID, &user.ID, &user.Username, &user.Avatar, &user.IsBot, &user.CreatedAt, &lastSeen); err != nil {
+			return nil, fmt.Errorf("failed to scan participant: %v", err)
+		}
+		if lastSeen.Valid {
+			user.LastSeenAt = &lastSeen.Time
+		}
+		participants[conversationID] = append(participants[conversationID], user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating participants: %v", err)
+	}
+	return participants, nil
+}
+
+// attachParticipantSummaries fills Participants and TotalParticipants for
+// every conversation with one query over all of them, keeping at most
+// models.MaxEmbeddedParticipants members per conversation in join order
+func (db *DB) attachParticipantSummaries(ctx context.Context, conversations []*models.Conversation) error {
+	if len(conversations) == 0 {
+		return nil
+	}
+
+	byID := make(map[int64]*models.Conversation, len(conversations))
+	args := make([]interface{}, 0, len(conversations)+1)
+	for _, conv := range conversations {
+		byID[conv.ID] = conv
+		conv.Participants = []models.ParticipantSummary{}
+		args = append(args, conv.ID)
+	}
+	args = append(args, models.MaxEmbeddedParticipants)
+
+	rows, err := db.queryRead(ctx, `
+		SELECT conversation_id, id, username, avatar, role, last_seen_at, total
+		FROM (
+			SELECT cp.conversation_id, u.id, `+visibleUsernameSQL+` AS username, `+visibleAvatarSQL+` AS avatar, cp.role, u.last_seen_at,
+			       ROW_NUMBER() OVER (PARTITION BY cp.conversation_id ORDER BY cp.joined_at, u.id) AS position,
+			       COUNT(*) OVER (PARTITION BY cp.conversation_id) AS total
+			FROM conversation_participants cp
+			JOIN users u ON u.id = cp.user_id
+			WHERE cp.conversation_id IN (?`+strings.Repeat(", ?", len(conversations)-1)+`)
+		)
+		WHERE position <= ?
+		ORDER BY conversation_id, position
+	`, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query participants: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var conversationID int64
+		var p models.ParticipantSummary
+		var lastSeen sql.NullTime
+		var total int
+		if err := rows.Scan(&conversationID, &p.ID, &p.Username, &p.Avatar, &p.Role, &lastSeen, &total); err != nil {
+			return fmt.Errorf("failed to scan participant: %v", err)
+		}
+		if lastSeen.Valid {
+			p.LastSeenAt = &lastSeen.Time
+		}
+		conv := byID[conversationID]
+		conv.Participants = append(conv.Participants, p)
+		conv.TotalParticipants = total
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating participants: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package db_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"messager/internal/db/testdb"
+	"messager/internal/models"
+)
+
+func TestConversationListEmbedsParticipants(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+
+	members := []int64{f.Alice.ID}
+	for i := 0; i < models.MaxEmbeddedParticipants+1; i++ {
+		members = append(members, testdb.CreateUser(t, d, fmt.Sprintf("user%02d", i)).ID)
+	}
+	big, err := d.CreateConversation(ctx, "Everyone", "group", f.Alice.ID, members)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conversations, err := d.GetUserConversations(ctx, f.Alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[int64]*models.Conversation)
+	for _, conv := range conversations {
+		got[conv.ID] = conv
+	}
+
+	team := got[f.Group.ID]
+	if team == nil || team.TotalParticipants != 3 || len(team.Participants) != 3 {
+		t.Fatalf("team: %+v, want all 3 participants", team)
+	}
+	for i, want := range []string{"alice", "bob", "carol"} {
+		if team.Participants[i].Username != want {
+			t.Errorf("team participant %d = %s, want %s in join order", i, team.Participants[i].Username, want)
+		}
+	}
+
+	everyone := got[big.ID]
+	if everyone == nil {
+		t.Fatal("the big group isn't listed")
+	}
+	if everyone.TotalParticipants != len(members) {
+		t.Errorf("total = %d, want %d", everyone.TotalParticipants, len(members))
+	}
+	if len(everyone.Participants) != models.MaxEmbeddedParticipants {
+		t.Errorf("embedded %d participants, want the cap of %d", len(everyone.Participants), models.MaxEmbeddedParticipants)
+	}
+}
+
+func TestBatchedLookups(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+
+	members, err := d.GetParticipantsForConversations(ctx, []int64{f.Direct.ID, f.Group.ID, 9999})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 || len(members[f.Direct.ID]) != 2 || len(members[f.Group.ID]) != 3 {
+		t.Fatalf("members %v, want both conversations and not the unknown one", members)
+	}
+	if got := members[f.Group.ID][0]; got.ID != f.Alice.ID || got.Username != "alice" || got.Password != "" {
+		t.Errorf("first group member %+v, want alice without her password", got)
+	}
+
+	users, err := d.GetUsersByIDs(ctx, []int64{f.Bob.ID, f.Carol.ID, 9999})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[f.Bob.ID].Username != "bob" || users[f.Carol.ID].Username != "carol" {
+		t.Errorf("users %v, want bob and carol only", users)
+	}
+
+	for _, ids := range [][]int64{nil, {}} {
+		if members, err := d.GetParticipantsForConversations(ctx, ids); err != nil || len(members) != 0 {
+			t.Errorf("no conversations: %v, %v", members, err)
+		}
+		if users, err := d.GetUsersByIDs(ctx, ids); err != nil || len(users) != 0 {
+			t.Errorf("no users: %v, %v", users, err)
+		}
+	}
+}
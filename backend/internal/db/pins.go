@@ -0,0 +1,91 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrPinLimit is returned when pinning would take a user past their limit
+var ErrPinLimit = errors.New("pinned conversation limit reached")
+
+// PinConversation pins a conversation to the top of a member's list and
+// returns when it was pinned. Pinning an already pinned conversation keeps
+// its original place. Returns ErrNotFound if the user isn't a member and
+// ErrPinLimit if they already have limit pins.
+func (db *DB) PinConversation(ctx context.Context, conversationID, userID int64, limit int) (time.Time, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.guardWrite(); err != nil {
+		return time.Time{}, err
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to begin transaction: %w", db.checkWrite(err))
+	}
+	defer tx.Rollback()
+
+	var pinnedAt sql.NullTime
+	err = tx.QueryRowContext(ctx, `
+		SELECT pinned_at FROM conversation_participants
+		WHERE conversation_id = ? AND user_id = ?
+	`, conversationID, userID).Scan(&pinnedAt)
+	if err != nil {
+		return time.Time{}, classify(err)
+	}
+	if pinnedAt.Valid {
+		return pinnedAt.Time, nil
+	}
+
+	var pinned int
+	err = tx.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM conversation_participants
+		WHERE user_id = ? AND pinned_at IS NOT NULL
+	`, userID).Scan(&pinned)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to count pins: %v", err)
+	}
+	if pinned >= limit {
+		return time.Time{}, ErrPinLimit
+	}
+
+	now := time.Now().UTC()
+	_, err = tx.ExecContext(ctx, `
+		UPDATE conversation_participants SET pinned_at = ?
+		WHERE conversation_id = ? AND user_id = ?
+	`, now, conversationID, userID)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to pin conversation: %w", db.checkWrite(err))
+	}
+	if err := tx.Commit(); err != nil {
+		return time.Time{}, fmt.Errorf("failed to commit transaction: %w", db.checkWrite(err))
+	}
+	return now, nil
+}
+
+// UnpinConversation unpins a conversation for a member. Returns
+// ErrNotFound if the user isn't a member.
+func (db *DB) UnpinConversation(ctx context.Context, conversationID, userID int64) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.guardWrite(); err != nil {
+		return err
+	}
+
+	result, err := db.DB.ExecContext(ctx, `
+		UPDATE conversation_participants SET pinned_at = NULL
+		WHERE conversation_id = ? AND user_id = ?
+	`, conversationID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to unpin conversation: %w", db.checkWrite(err))
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+)
+
+// healthTimeout bounds Health's round trip, so a wedged database fails the
+// check quickly instead of holding up the prober
+const healthTimeout = time.Second
+
+// PoolOptions sizes a connection pool. Zero MaxOpenConns or ConnMaxLifetime
+// means no limit.
+type PoolOptions struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// DefaultPoolOptions suits SQLite: writers queue on one lock whatever the
+// pool size, so a handful of connections covers concurrent reads, and
+// connections are local file handles with nothing to gain from recycling
+var DefaultPoolOptions = PoolOptions{MaxOpenConns: 16, MaxIdleConns: 4}
+
+// SetPool applies opts to the primary pool and the replicas, if any. It
+// must be called before the server starts serving.
+func (db *DB) SetPool(opts PoolOptions) {
+	db.pool = opts
+	applyPool(db.DB, opts)
+	for _, r := range db.replicas {
+		applyPool(r.pool, opts)
+	}
+}
+
+func applyPool(pool *sql.DB, opts PoolOptions) {
+	pool.SetMaxOpenConns(opts.MaxOpenConns)
+	pool.SetMaxIdleConns(opts.MaxIdleConns)
+	pool.SetConnMaxLifetime(opts.ConnMaxLifetime)
+}
+
+// HealthReport is the outcome of Health with the primary pool's counters
+type HealthReport struct {
+	Status             string  `json:"status"`
+	Error              string  `json:"error,omitempty"`
+	LatencyMS          float64 `json:"latency_ms"`
+	MaxOpenConnections int     `json:"max_open_connections"`
+	OpenConnections    int     `json:"open_connections"`
+	InUse              int     `json:"in_use"`
+	Idle               int     `json:"idle"`
+	WaitCount          int64   `json:"wait_count"`
+	WaitDurationMS     int64   `json:"wait_duration_ms"`
+}
+
+// Health checks that the database file is still in place and answers a
+// query within healthTimeout. The report is filled in either way; Status is
+// "ok", or "unavailable" with the error.
+func (db *DB) Health(ctx context.Context) (HealthReport, error) {
+	ctx, cancel := context.WithTimeout(ctx, healthTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := db.checkHealth(ctx)
+	stats := db.DB.Stats()
+	report := HealthReport{
+		Status:             "ok",
+		LatencyMS:          float64(time.Since(start).Microseconds()) / 1000,
+		MaxOpenConnections: stats.MaxOpenConnections,
+		OpenConnections:    stats.OpenConnections,
+		InUse:              stats.InUse,
+		Idle:               stats.Idle,
+		WaitCount:          stats.WaitCount,
+		WaitDurationMS:     stats.WaitDuration.Milliseconds(),
+	}
+	if err != nil {
+		report.Status = "unavailable"
+		report.Error = err.Error()
+	}
+	return report, err
+}
+
+func (db *DB) checkHealth(ctx context.Context) error {
+	// Open connections keep working on a deleted file, but every new one
+	// would silently start an empty database
+	if db.path != "" {
+		if _, err := os.Stat(db.path); err != nil {
+			return fmt.Errorf("database file unavailable: %v", err)
+		}
+	}
+	var tables int
+	if err := db.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master`).Scan(&tables); err != nil {
+		return fmt.Errorf("database not answering: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package db_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"messager/internal/db"
+	"messager/internal/db/testdb"
+)
+
+func TestPoolOptions(t *testing.T) {
+	d := testdb.Open(t)
+	if got := d.DB.Stats().MaxOpenConnections; got != db.DefaultPoolOptions.MaxOpenConns {
+		t.Errorf("new pool allows %d connections, want the default %d", got, db.DefaultPoolOptions.MaxOpenConns)
+	}
+
+	d.SetPool(db.PoolOptions{MaxOpenConns: 2, MaxIdleConns: 1, ConnMaxLifetime: time.Minute})
+	if got := d.DB.Stats().MaxOpenConnections; got != 2 {
+		t.Errorf("after SetPool the pool allows %d connections, want 2", got)
+	}
+
+	// Warmup opens no more connections than the pool allows
+	report, err := d.Warmup(context.Background(), db.WarmupOptions{Connections: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Connections != 2 {
+		t.Errorf("warmup opened %d connections, want the pool's 2", report.Connections)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "health.db")
+	d, err := db.NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	ctx := context.Background()
+
+	report, err := d.Health(ctx)
+	if err != nil || report.Status != "ok" || report.Error != "" {
+		t.Fatalf("healthy database: %+v, %v", report, err)
+	}
+	if report.MaxOpenConnections != db.DefaultPoolOptions.MaxOpenConns || report.OpenConnections == 0 {
+		t.Errorf("pool counters %+v", report)
+	}
+
+	// Open connections still answer on a deleted file, so only the file
+	// check notices
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	report, err = d.Health(ctx)
+	if err == nil || report.Status != "unavailable" || report.Error == "" {
+		t.Errorf("deleted database file: %+v, %v", report, err)
+	}
+	if report.MaxOpenConnections == 0 {
+		t.Error("a failed check left out the pool counters")
+	}
+}
@@ -0,0 +1,248 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"log"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mattn/go-sqlite3"
+	"messager/internal/logsafe"
+)
+
+// Both pools open their connections through a thin wrapper around the
+// SQLite driver that times every statement. Each is counted under the DB
+// method that ran it, and one that takes longer than the slow-query
+// threshold is logged with its SQL and arguments. A query is timed until
+// its rows are closed, so a slow scan shows up even when the first row came
+// back quickly.
+
+const defaultSlowQueryThreshold = 100 * time.Millisecond
+
+// Slow-query log lines are cut down to these sizes
+const (
+	slowQueryMaxSQL = 500
+	slowQueryMaxArg = 64
+)
+
+// methodPrefix is how the runtime names methods of DB
+var methodPrefix = reflect.TypeOf(DB{}).PkgPath() + ".(*DB)."
+
+// queryLog counts statements and logs slow ones. It is shared by the
+// primary and the replica.
+type queryLog struct {
+	threshold atomic.Int64 // nanoseconds, 0 to not log
+	counts    sync.Map     // method name -> *atomic.Int64
+	slow      atomic.Int64
+}
+
+func newQueryLog() *queryLog {
+	q := &queryLog{}
+	q.threshold.Store(int64(defaultSlowQueryThreshold))
+	return q
+}
+
+// SetSlowQueryThreshold sets how long a statement may take before it is
+// logged, 0 to log none. It must be set before the server starts serving.
+func (db *DB) SetSlowQueryThreshold(threshold time.Duration) {
+	if threshold >= 0 {
+		db.queries.threshold.Store(int64(threshold))
+	}
+}
+
+// QueryCounts returns how many statements each DB method has run, with
+// statements run from outside one under "other", and how many of all of
+// them were slow
+func (db *DB) QueryCounts() (map[string]int64, int64) {
+	counts := make(map[string]int64)
+	db.queries.counts.Range(func(key, value interface{}) bool {
+		counts[key.(string)] = value.(*atomic.Int64).Load()
+		return true
+	})
+	return counts, db.queries.slow.Load()
+}
+
+// record counts a statement that method ran and logs it if it was slow
+func (q *queryLog) record(method, query string, args []driver.NamedValue, took time.Duration) {
+	counter, ok := q.counts.Load(method)
+	if !ok {
+		counter, _ = q.counts.LoadOrStore(method, new(atomic.Int64))
+	}
+	counter.(*atomic.Int64).Add(1)
+
+	threshold := time.Duration(q.threshold.Load())
+	if threshold <= 0 || took < threshold {
+		return
+	}
+	q.slow.Add(1)
+	log.Printf("Slow query in %s took %v: %s [%s]", method, took.Round(time.Microsecond), formatSQL(query), formatArgs(args))
+}
+
+// callerMethod names the exported DB method running the current statement,
+// looking past unexported helpers and WithTx to the method that called
+// them. Statements WithTx runs for callers outside the package count as
+// WithTx.
+func callerMethod() string {
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	method := "other"
+	for {
+		frame, more := frames.Next()
+		if name, ok := strings.CutPrefix(frame.Function, methodPrefix); ok {
+			// Closures are named after their method, e.g. CreateConversation.func1
+			name, _, _ = strings.Cut(name, ".")
+			if name == "WithTx" {
+				method = name
+			} else if r, _ := utf8.DecodeRuneInString(name); unicode.IsUpper(r) {
+				return name
+			}
+		}
+		if !more {
+			return method
+		}
+	}
+}
+
+// formatSQL puts a statement on one line
+func formatSQL(query string) string {
+	query = strings.Join(strings.Fields(query), " ")
+	if len(query) > slowQueryMaxSQL {
+		query = query[:slowQueryMaxSQL] + "..."
+	}
+	return query
+}
+
+// formatArgs renders statement arguments for the log. Strings go through
+// logsafe.Content, as they may be message bodies, and times are cut to the
+// second.
+func formatArgs(args []driver.NamedValue) string {
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		switch v := arg.Value.(type) {
+		case string:
+			if len(v) > slowQueryMaxArg {
+				v = v[:slowQueryMaxArg] + "..."
+			}
+			parts[i] = logsafe.Content(v)
+		case []byte:
+			parts[i] = fmt.Sprintf("[%d bytes]", len(v))
+		case time.Time:
+			parts[i] = v.UTC().Truncate(time.Second).Format(time.RFC3339)
+		case float64:
+			parts[i] = strconv.FormatFloat(v, 'g', 6, 64)
+		case nil:
+			parts[i] = "NULL"
+		default:
+			parts[i] = fmt.Sprint(v)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// openLogged opens a pool on dsn whose statements are recorded in q
+func openLogged(dsn string, q *queryLog) *sql.DB {
+	return sql.OpenDB(loggedConnector{dsn: dsn, log: q})
+}
+
+// loggedConnector opens SQLite connections wrapped in loggedConn
+type loggedConnector struct {
+	dsn string
+	log *queryLog
+}
+
+func (c loggedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Driver().Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &loggedConn{SQLiteConn: conn.(*sqlite3.SQLiteConn), log: c.log}, nil
+}
+
+func (c loggedConnector) Driver() driver.Driver {
+	return &sqlite3.SQLiteDriver{}
+}
+
+// loggedConn times statements run directly on a connection and hands out
+// timed prepared statements
+type loggedConn struct {
+	*sqlite3.SQLiteConn
+	log *queryLog
+}
+
+func (c *loggedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	method, start := callerMethod(), time.Now()
+	result, err := c.SQLiteConn.ExecContext(ctx, query, args)
+	c.log.record(method, query, args, time.Since(start))
+	return result, err
+}
+
+func (c *loggedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	method, start := callerMethod(), time.Now()
+	rows, err := c.SQLiteConn.QueryContext(ctx, query, args)
+	return c.log.wrapRows(rows, err, method, query, args, start)
+}
+
+func (c *loggedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	stmt, err := c.SQLiteConn.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &loggedStmt{SQLiteStmt: stmt.(*sqlite3.SQLiteStmt), log: c.log, query: query}, nil
+}
+
+// loggedStmt times each run of a prepared statement
+type loggedStmt struct {
+	*sqlite3.SQLiteStmt
+	log   *queryLog
+	query string
+}
+
+func (s *loggedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	method, start := callerMethod(), time.Now()
+	result, err := s.SQLiteStmt.ExecContext(ctx, args)
+	s.log.record(method, s.query, args, time.Since(start))
+	return result, err
+}
+
+func (s *loggedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	method, start := callerMethod(), time.Now()
+	rows, err := s.SQLiteStmt.QueryContext(ctx, args)
+	return s.log.wrapRows(rows, err, method, s.query, args, start)
+}
+
+// wrapRows defers recording a query until its rows are closed. A failed
+// query is recorded straight away.
+func (q *queryLog) wrapRows(rows driver.Rows, err error, method, query string, args []driver.NamedValue, start time.Time) (driver.Rows, error) {
+	sqliteRows, ok := rows.(*sqlite3.SQLiteRows)
+	if err != nil || !ok {
+		q.record(method, query, args, time.Since(start))
+		return rows, err
+	}
+	return &loggedRows{SQLiteRows: sqliteRows, log: q, method: method, query: query, args: args, start: start}, nil
+}
+
+// loggedRows records its query once closed
+type loggedRows struct {
+	*sqlite3.SQLiteRows
+	log    *queryLog
+	method string
+	query  string
+	args   []driver.NamedValue
+	start  time.Time
+}
+
+func (r *loggedRows) Close() error {
+	err := r.SQLiteRows.Close()
+	r.log.record(r.method, r.query, r.args, time.Since(r.start))
+	return err
+}
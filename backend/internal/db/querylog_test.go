@@ -0,0 +1,83 @@
+package db_test
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"messager/internal/db/testdb"
+)
+
+func TestQueryCountsByMethod(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+	before, _ := d.QueryCounts()
+
+	if _, err := d.GetUserByID(ctx, f.Alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.CreateConversation(ctx, "Another", "group", f.Alice.ID, []int64{f.Alice.ID, f.Bob.ID}); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := d.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+
+	after, _ := d.QueryCounts()
+	if got := after["GetUserByID"] - before["GetUserByID"]; got != 1 {
+		t.Errorf("GetUserByID ran %d statements, want 1", got)
+	}
+	// Statements in the WithTx closure count under the method that called it
+	if got := after["CreateConversation"] - before["CreateConversation"]; got < 3 {
+		t.Errorf("CreateConversation counted %d statements, want its insert and both members", got)
+	}
+	if after["WithTx"] != before["WithTx"] {
+		t.Errorf("statements counted under WithTx rather than their method")
+	}
+	if got := after["other"] - before["other"]; got != 1 {
+		t.Errorf("%d statements from outside a method, want the 1 raw query", got)
+	}
+}
+
+func TestSlowQueryLog(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	d.SetSlowQueryThreshold(0)
+	if _, err := d.CreateMessage(ctx, f.Direct.ID, f.Alice.ID, "quiet"); err != nil {
+		t.Fatal(err)
+	}
+	if _, slow := d.QueryCounts(); slow != 0 || strings.Contains(buf.String(), "Slow query") {
+		t.Fatalf("%d slow queries logged with the log off", slow)
+	}
+
+	// Everything is slow against a nanosecond
+	d.SetSlowQueryThreshold(time.Nanosecond)
+	if _, err := d.CreateMessage(ctx, f.Direct.ID, f.Alice.ID, "the secret plan"); err != nil {
+		t.Fatal(err)
+	}
+	if _, slow := d.QueryCounts(); slow == 0 {
+		t.Error("no slow queries counted")
+	}
+	logged := buf.String()
+	if !strings.Contains(logged, "Slow query in CreateMessage") {
+		t.Errorf("slow log doesn't name the method:\n%s", logged)
+	}
+	if strings.Contains(logged, "secret plan") {
+		t.Errorf("slow log leaks message content:\n%s", logged)
+	}
+	if !strings.Contains(logged, "INSERT INTO messages (conversation_id") {
+		t.Errorf("slow log doesn't have the statement on one line:\n%s", logged)
+	}
+}
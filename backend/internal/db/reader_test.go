@@ -0,0 +1,51 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+
+	"messager/internal/db"
+	"messager/internal/db/testdb"
+)
+
+func TestHeavyReadsGoToTheReader(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+	if d.HasReader() {
+		t.Fatal("HasReader before one was opened")
+	}
+	if err := d.OpenReader(d.Path()); err != nil {
+		t.Fatal(err)
+	}
+	if !d.HasReader() {
+		t.Fatal("HasReader after OpenReader")
+	}
+
+	before := d.PoolStats()
+	messages, err := d.GetConversationMessages(ctx, f.Group.ID, 10, 0)
+	if err != nil || len(messages) != 2 {
+		t.Fatalf("history through the reader: %d messages, %v", len(messages), err)
+	}
+	after := d.PoolStats()
+	if after["replica-1"].Reads == before["replica-1"].Reads || after["primary"].Reads != before["primary"].Reads {
+		t.Errorf("history read went to the primary: before %+v, after %+v", before, after)
+	}
+
+	// A pinned read skips it
+	if _, err := d.GetConversationMessages(db.ReadYourWrites(ctx), f.Group.ID, 10, 0); err != nil {
+		t.Fatal(err)
+	}
+	pinned := d.PoolStats()
+	if pinned["primary"].Reads == after["primary"].Reads || pinned["replica-1"].Reads != after["replica-1"].Reads {
+		t.Errorf("pinned read went to the reader: before %+v, after %+v", after, pinned)
+	}
+
+	// The reader sees committed writes
+	if _, err := d.CreateMessage(ctx, f.Group.ID, f.Bob.ID, "fresh"); err != nil {
+		t.Fatal(err)
+	}
+	if messages, _ := d.GetConversationMessages(ctx, f.Group.ID, 10, 0); len(messages) != 3 {
+		t.Errorf("reader returned %d messages after a write, want 3", len(messages))
+	}
+}
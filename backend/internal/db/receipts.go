@@ -0,0 +1,185 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"messager/internal/models"
+)
+
+// Receipts are derived from per-participant markers rather than stored per
+// message: a message counts as delivered to, or read by, a participant once
+// their last_delivered_message_id or last_read_message_id reaches its ID.
+// Message IDs only grow, so a marker covers everything before it.
+
+// MarkDelivered advances the delivered marker of userIDs in a conversation
+// to messageID. Markers never move backwards.
+func (db *DB) MarkDelivered(ctx context.Context, conversationID, messageID int64, userIDs []int64) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if len(userIDs) == 0 {
+		return nil
+	}
+	if err := db.guardWrite(); err != nil {
+		return err
+	}
+	if err := db.chaos.DB(stmtMarkDelivered); err != nil {
+		return err
+	}
+
+	args := []interface{}{messageID, time.Now().UTC(), conversationID}
+	for _, id := range userIDs {
+		args = append(args, id)
+	}
+	args = append(args, messageID)
+	_, err := db.DB.ExecContext(ctx, `
+		UPDATE conversation_participants
+		SET last_delivered_message_id = ?, last_delivered_at = ?
+		WHERE conversation_id = ?
+		  AND user_id IN (?`+strings.Repeat(", ?", len(userIDs)-1)+`)
+		  AND COALESCE(last_delivered_message_id, 0) < ?
+	`, args...)
+	if err != nil {
+		return fmt.Errorf("failed to mark delivered: %w", db.checkWrite(err))
+	}
+	return nil
+}
+
+// MarkRead advances a participant's read marker to messageID, reporting
+// whether it moved. Markers never move backwards. Returns ErrNotFound if
+// the message isn't in the conversation.
+func (db *DB) MarkRead(ctx context.Context, conversationID, userID, messageID int64) (bool, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.guardWrite(); err != nil {
+		return false, err
+	}
+
+	var seq int64
+	err := db.DB.QueryRowContext(ctx, `
+		SELECT seq FROM messages WHERE id = ? AND conversation_id = ?
+	`, messageID, conversationID).Scan(&seq)
+	if err == sql.ErrNoRows {
+		return false, ErrNotFound
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to look up message: %v", err)
+	}
+
+	// The unread count is recounted from the new marker; the subquery
+	// sees the row as it was before the update, hence the explicit seq
+	result, err := db.DB.ExecContext(ctx, `
+		UPDATE conversation_participants
+		SET last_read_message_id = ?, last_read_at = ?, unread_count = `+unreadSQL(" AND m.seq > ?")+`
+		WHERE conversation_id = ? AND user_id = ?
+		  AND COALESCE(last_read_message_id, 0) < ?
+	`, messageID, time.Now().UTC(), seq, conversationID, userID, messageID)
+	if err != nil {
+		return false, fmt.Errorf("failed to mark read: %w", db.checkWrite(err))
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// GetMessageReceipts summarizes delivery and reads of msg among everyone in
+// its conversation except the sender. The per-user breakdown is included
+// only when there are at most breakdownLimit recipients.
+func (db *DB) GetMessageReceipts(ctx context.Context, msg *models.Message, breakdownLimit int) (*models.ReceiptSummary, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	summary := &models.ReceiptSummary{MessageID: msg.ID}
+
+	// A read implies delivery even if the delivered marker lagged, e.g. the
+	// message was fetched over HTTP rather than pushed
+	var delivered, read, hidden sql.NullInt64
+	err := db.queryRowRead(ctx, `
+		SELECT COUNT(*),
+		       SUM(u.read_receipts = 1 AND (COALESCE(cp.last_delivered_message_id, 0) >= ?1 OR COALESCE(cp.last_read_message_id, 0) >= ?1)),
+		       SUM(u.read_receipts = 1 AND COALESCE(cp.last_read_message_id, 0) >= ?1),
+		       SUM(u.read_receipts = 0)
+		FROM conversation_participants cp
+		JOIN users u ON u.id = cp.user_id
+		WHERE cp.conversation_id = ?2 AND cp.user_id != ?3
+	`, msg.ID, msg.ConversationID, msg.SenderID).Scan(&summary.Recipients, &delivered, &read, &hidden)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count receipts: %v", err)
+	}
+	summary.Delivered, summary.Read, summary.Hidden = int(delivered.Int64), int(read.Int64), int(hidden.Int64)
+
+	if summary.Recipients > breakdownLimit {
+		return summary, nil
+	}
+
+	rows, err := db.queryRead(ctx, `
+		SELECT u.id, `+visibleUsernameSQL+`,
+		       COALESCE(cp.last_delivered_message_id, 0), cp.last_delivered_at,
+		       COALESCE(cp.last_read_message_id, 0), cp.last_read_at
+		FROM conversation_participants cp
+		JOIN users u ON u.id = cp.user_id
+		WHERE cp.conversation_id = ? AND cp.user_id != ? AND u.read_receipts = 1
+		ORDER BY u.username
+	`, msg.ConversationID, msg.SenderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query receipts: %v", err)
+	}
+	defer rows.Close()
+
+	summary.Breakdown = []models.UserReceipt{}
+	for rows.Next() {
+		var receipt models.UserReceipt
+		var deliveredID, readID int64
+		var deliveredAt, readAt sql.NullTime
+		if err := rows.Scan(&receipt.UserID, &receipt.Username, &deliveredID, &deliveredAt, &readID, &readAt); err != nil {
+			return nil, fmt.Errorf("failed to scan receipt: %v", err)
+		}
+		if readID >= msg.ID && readAt.Valid {
+			receipt.ReadAt = &readAt.Time
+			receipt.DeliveredAt = &readAt.Time
+		}
+		if deliveredID >= msg.ID && deliveredAt.Valid {
+			receipt.DeliveredAt = &deliveredAt.Time
+		}
+		summary.Breakdown = append(summary.Breakdown, receipt)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating receipts: %v", err)
+	}
+	return summary, nil
+}
+
+// GetPrivacySettings returns a user's privacy choices
+func (db *DB) GetPrivacySettings(ctx context.Context, userID int64) (*models.PrivacySettings, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	settings := &models.PrivacySettings{}
+	err := db.DB.QueryRowContext(ctx, `SELECT read_receipts FROM users WHERE id = ?`, userID).Scan(&settings.ReadReceipts)
+	if err != nil {
+		return nil, classify(err)
+	}
+	return settings, nil
+}
+
+// SetPrivacySettings replaces a user's privacy choices
+func (db *DB) SetPrivacySettings(ctx context.Context, userID int64, settings *models.PrivacySettings) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.guardWrite(); err != nil {
+		return err
+	}
+	_, err := db.DB.ExecContext(ctx, `UPDATE users SET read_receipts = ? WHERE id = ?`, settings.ReadReceipts, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update privacy settings: %w", db.checkWrite(err))
+	}
+	return nil
+}
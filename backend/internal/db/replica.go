@@ -0,0 +1,179 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// The primary connection takes every write. Heavy pure-read paths (message
+// history, conversation lists, search, exports) go through queryRead and
+// queryRowRead, which take turns across the replicas when any are
+// configured. A method opts in by calling one of them; everything else
+// stays on the primary. A replica may lag the primary, so a caller that
+// must see its own writes marks its context with ReadYourWrites to pin the
+// read to the primary.
+//
+// A read that fails on a replica is retried on the primary, and that
+// replica is skipped for replicaRetryAfter before it's tried again.
+
+// replicaRetryAfter is how long a replica that failed a read is passed over
+const replicaRetryAfter = 10 * time.Second
+
+type primaryKey struct{}
+
+// ReadYourWrites returns a context whose reads are served by the primary
+func ReadYourWrites(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+func wantsPrimary(ctx context.Context) bool {
+	pinned, _ := ctx.Value(primaryKey{}).(bool)
+	return pinned
+}
+
+// replica is one read-only pool
+type replica struct {
+	name      string
+	pool      *sql.DB
+	reads     atomic.Int64
+	failures  atomic.Int64
+	downUntil atomic.Int64 // unix nanoseconds, 0 while healthy
+}
+
+func (r *replica) available(now time.Time) bool {
+	return now.UnixNano() >= r.downUntil.Load()
+}
+
+// PoolStats describes one connection pool for the admin stats endpoint
+type PoolStats struct {
+	Reads int64 `json:"reads"`
+	// Failures counts reads a replica failed and handed to the primary
+	Failures        int64 `json:"failures,omitempty"`
+	Down            bool  `json:"down,omitempty"`
+	OpenConnections int   `json:"open_connections"`
+	InUse           int   `json:"in_use"`
+	Idle            int   `json:"idle"`
+}
+
+// OpenReader attaches a read-only connection to path (for SQLite, typically
+// the same file as the primary) and adds it to the replicas pure reads take
+// turns on. It must be called before the server starts serving.
+func (db *DB) OpenReader(path string) error {
+	reader := openLogged("file:"+path+"?mode=ro&_loc=UTC", db.queries)
+	if err := reader.Ping(); err != nil {
+		reader.Close()
+		return fmt.Errorf("error connecting to the read database: %v", err)
+	}
+	applyPool(reader, db.pool)
+	db.replicas = append(db.replicas, &replica{
+		name: "replica-" + strconv.Itoa(len(db.replicas)+1),
+		pool: reader,
+	})
+	return nil
+}
+
+// HasReader reports whether any separate read connection is configured
+func (db *DB) HasReader() bool {
+	return len(db.replicas) > 0
+}
+
+// pickReplica returns the next available replica in turn, or nil when the
+// read should go to the primary
+func (db *DB) pickReplica(ctx context.Context) *replica {
+	if len(db.replicas) == 0 || wantsPrimary(ctx) {
+		return nil
+	}
+	now := time.Now()
+	start := db.nextReplica.Add(1)
+	for i := range db.replicas {
+		r := db.replicas[(start+uint64(i))%uint64(len(db.replicas))]
+		if r.available(now) {
+			return r
+		}
+	}
+	return nil
+}
+
+// replicaFailed records that r failed a read with err and reports whether
+// the read should be retried on the primary. Reads the caller gave up on
+// aren't the replica's fault.
+func (db *DB) replicaFailed(ctx context.Context, r *replica, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	r.failures.Add(1)
+	if r.downUntil.Swap(time.Now().Add(replicaRetryAfter).UnixNano()) == 0 {
+		log.Printf("Read replica %s failed, using the primary for %v: %v", r.name, replicaRetryAfter, err)
+	}
+	return true
+}
+
+// queryRead runs a pure read on a replica, or on the primary when there is
+// none, the context is pinned, or the replica fails the query
+func (db *DB) queryRead(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if r := db.pickReplica(ctx); r != nil {
+		r.reads.Add(1)
+		rows, err := r.pool.QueryContext(ctx, query, args...)
+		if err == nil || !db.replicaFailed(ctx, r, err) {
+			return rows, err
+		}
+	}
+	db.primaryReads.Add(1)
+	return db.DB.QueryContext(ctx, query, args...)
+}
+
+// queryRowRead is queryRead for a single row
+func (db *DB) queryRowRead(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	if r := db.pickReplica(ctx); r != nil {
+		r.reads.Add(1)
+		row := r.pool.QueryRowContext(ctx, query, args...)
+		if err := row.Err(); err == nil || !db.replicaFailed(ctx, r, err) {
+			return row
+		}
+	}
+	db.primaryReads.Add(1)
+	return db.DB.QueryRowContext(ctx, query, args...)
+}
+
+// PoolStats reports per-pool read counts and connection usage, with an
+// entry per replica ("replica-1" and on) when any are configured
+func (db *DB) PoolStats() map[string]PoolStats {
+	primary := db.DB.Stats()
+	stats := map[string]PoolStats{
+		"primary": {
+			Reads:           db.primaryReads.Load(),
+			OpenConnections: primary.OpenConnections,
+			InUse:           primary.InUse,
+			Idle:            primary.Idle,
+		},
+	}
+	now := time.Now()
+	for _, r := range db.replicas {
+		pool := r.pool.Stats()
+		stats[r.name] = PoolStats{
+			Reads:           r.reads.Load(),
+			Failures:        r.failures.Load(),
+			Down:            !r.available(now),
+			OpenConnections: pool.OpenConnections,
+			InUse:           pool.InUse,
+			Idle:            pool.Idle,
+		}
+	}
+	return stats
+}
+
+// Close releases prepared statements and closes the replicas, if any, and
+// the primary
+func (db *DB) Close() error {
+	db.closeStatements()
+	for _, r := range db.replicas {
+		r.pool.Close()
+	}
+	return db.DB.Close()
+}
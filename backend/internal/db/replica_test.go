@@ -0,0 +1,121 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"messager/internal/db"
+	"messager/internal/db/testdb"
+)
+
+// openReplicas attaches two readers, replica-1 and replica-2, to d's file
+func openReplicas(t *testing.T, d *db.DB) {
+	t.Helper()
+	for i := 0; i < 2; i++ {
+		if err := d.OpenReader(d.Path()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readsSince reports how many reads each pool served since before
+func readsSince(d *db.DB, before map[string]db.PoolStats) map[string]int64 {
+	reads := map[string]int64{}
+	for name, stats := range d.PoolStats() {
+		reads[name] = stats.Reads - before[name].Reads
+	}
+	return reads
+}
+
+func TestReplicasTakeTurns(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+	openReplicas(t, d)
+
+	lookup := func(ctx context.Context) map[string]int64 {
+		t.Helper()
+		before := d.PoolStats()
+		users, err := d.GetUsersByIDs(ctx, []int64{f.Alice.ID, f.Bob.ID})
+		if err != nil || len(users) != 2 {
+			t.Fatalf("looked up %d users, %v", len(users), err)
+		}
+		return readsSince(d, before)
+	}
+
+	last := ""
+	for i := 0; i < 4; i++ {
+		reads := lookup(ctx)
+		if reads["primary"] != 0 || reads["replica-1"]+reads["replica-2"] != 1 {
+			t.Fatalf("read %d: %v, want one replica read", i, reads)
+		}
+		served := "replica-1"
+		if reads["replica-2"] == 1 {
+			served = "replica-2"
+		}
+		if served == last {
+			t.Errorf("read %d: %s served twice in a row", i, served)
+		}
+		last = served
+
+		// A pinned read in between goes to the primary and doesn't take a
+		// replica's turn
+		if i == 1 {
+			if reads := lookup(db.ReadYourWrites(ctx)); reads["primary"] != 1 || reads["replica-1"]+reads["replica-2"] != 0 {
+				t.Errorf("pinned read: %v, want only the primary", reads)
+			}
+		}
+	}
+}
+
+func TestReplicaFailureFallsBackToPrimary(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+	openReplicas(t, d)
+	if err := d.CloseReplica("replica-2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// replica-2's turn comes up once in four reads; its read is retried on
+	// the primary, and replica-1 covers its later turns
+	before := d.PoolStats()
+	failedAt := time.Now()
+	for i := 0; i < 4; i++ {
+		users, err := d.GetUsersByIDs(ctx, []int64{f.Alice.ID})
+		if err != nil || users[f.Alice.ID] == nil {
+			t.Fatalf("read %d: %v, %v", i, users, err)
+		}
+	}
+	if reads := readsSince(d, before); reads["replica-2"] != 1 || reads["primary"] != 1 || reads["replica-1"] != 3 {
+		t.Errorf("reads %v, want one each on replica-2 and the primary, and 3 on replica-1", reads)
+	}
+	stats := d.PoolStats()["replica-2"]
+	if stats.Failures != 1 || !stats.Down {
+		t.Errorf("replica-2 stats %+v, want one failure and down", stats)
+	}
+	if until := d.ReplicaDownUntil("replica-2"); until.Before(failedAt.Add(db.ReplicaRetryAfter)) || until.After(time.Now().Add(db.ReplicaRetryAfter)) {
+		t.Errorf("replica-2 down until %v, want %v after the failure", until, db.ReplicaRetryAfter)
+	}
+	if stats := d.PoolStats()["replica-1"]; stats.Failures != 0 || stats.Down {
+		t.Errorf("replica-1 stats %+v, want it healthy", stats)
+	}
+
+	// Once the backoff is over replica-2 gets its turn again. Single-row
+	// reads fall back the same way.
+	d.ExpireReplicaBackoff("replica-2")
+	before = d.PoolStats()
+	for i := 0; i < 2; i++ {
+		summary, err := d.GetMessageReceipts(ctx, f.Messages[2], 0)
+		if err != nil || summary.Recipients != 2 {
+			t.Fatalf("receipts %d: %+v, %v", i, summary, err)
+		}
+	}
+	if reads := readsSince(d, before); reads["replica-2"] != 1 || reads["primary"] != 1 || reads["replica-1"] != 1 {
+		t.Errorf("reads after the backoff %v, want one on each pool", reads)
+	}
+	if stats := d.PoolStats()["replica-2"]; stats.Failures != 2 || !stats.Down {
+		t.Errorf("replica-2 stats %+v, want a second failure", stats)
+	}
+}
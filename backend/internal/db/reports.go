@@ -0,0 +1,190 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"messager/internal/models"
+)
+
+// GetMessageByID returns a single message; soft-deleted ones come back as tombstones
+func (db *DB) GetMessageByID(ctx context.Context, messageID int64) (*models.Message, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	msg := &models.Message{}
+	err := db.scanMessage(db.DB.QueryRowContext(ctx, `
+		SELECT `+messageColumns+`
+		FROM messages
+		WHERE id = ?
+	`, messageID), msg)
+	if err != nil {
+		return nil, classify(err)
+	}
+	return msg, nil
+}
+
+// SoftDeleteMessage tombstones a message; its content is no longer served
+// and it stops counting as unread
+func (db *DB) SoftDeleteMessage(ctx context.Context, messageID int64) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.guardWrite(); err != nil {
+		return err
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", db.checkWrite(err))
+	}
+	defer tx.Rollback()
+
+	var msg models.Message
+	var senderID sql.NullInt64
+	err = tx.QueryRowContext(ctx, `
+		UPDATE messages SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL
+		RETURNING conversation_id, sender_id, message_type, created_at
+	`, time.Now().UTC(), messageID).Scan(&msg.ConversationID, &senderID, &msg.MessageType, &msg.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete message: %w", db.checkWrite(err))
+	}
+	if msg.MessageType == models.MessageTypeUser {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE conversation_participants SET unread_count = MAX(unread_count - 1, 0)
+			WHERE conversation_id = ? AND user_id IS NOT ?
+			  AND COALESCE(last_read_message_id, 0) < ?
+			  AND unixepoch(joined_at, 'subsec') <= unixepoch(?, 'subsec')
+		`, msg.ConversationID, senderID, messageID, msg.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to update unread counts: %w", db.checkWrite(err))
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", db.checkWrite(err))
+	}
+	return nil
+}
+
+// CreateMessageReport files a moderation report. Reporting the same message
+// twice is idempotent and returns the original report.
+func (db *DB) CreateMessageReport(ctx context.Context, messageID, reporterID int64, reason, note string) (*models.MessageReport, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.guardWrite(); err != nil {
+		return nil, err
+	}
+
+	_, err := db.DB.ExecContext(ctx, `
+		INSERT OR IGNORE INTO message_reports (message_id, reporter_id, reason, note, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, messageID, reporterID, reason, note, time.Now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create report: %w", db.checkWrite(err))
+	}
+
+	reports, err := db.queryReports(ctx, `WHERE r.message_id = ? AND r.reporter_id = ?`, messageID, reporterID)
+	if err != nil {
+		return nil, err
+	}
+	if len(reports) == 0 {
+		return nil, fmt.Errorf("report not found after insert")
+	}
+	return reports[0], nil
+}
+
+// GetMessageReports lists reports, optionally filtered by status, newest first
+func (db *DB) GetMessageReports(ctx context.Context, status string) ([]*models.MessageReport, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if status == "" {
+		return db.queryReports(ctx, ``)
+	}
+	return db.queryReports(ctx, `WHERE r.status = ?`, status)
+}
+
+// GetMessageReport returns a single report
+func (db *DB) GetMessageReport(ctx context.Context, reportID int64) (*models.MessageReport, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	reports, err := db.queryReports(ctx, `WHERE r.id = ?`, reportID)
+	if err != nil {
+		return nil, err
+	}
+	if len(reports) == 0 {
+		return nil, ErrNotFound
+	}
+	return reports[0], nil
+}
+
+// ResolveMessageReport closes every open report against the same message,
+// since one moderation decision covers them all
+func (db *DB) ResolveMessageReport(ctx context.Context, reportID, resolverID int64) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.guardWrite(); err != nil {
+		return err
+	}
+
+	_, err := db.DB.ExecContext(ctx, `
+		UPDATE message_reports
+		SET status = 'resolved', resolved_by = ?, resolved_at = ?
+		WHERE status = 'open'
+		AND message_id = (SELECT message_id FROM message_reports WHERE id = ?)
+	`, resolverID, time.Now().UTC(), reportID)
+	if err != nil {
+		return fmt.Errorf("failed to resolve report: %w", db.checkWrite(err))
+	}
+	return nil
+}
+
+func (db *DB) queryReports(ctx context.Context, where string, args ...interface{}) ([]*models.MessageReport, error) {
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT r.id, r.message_id, m.conversation_id, COALESCE(m.sender_id, 0), r.reporter_id,
+			r.reason, r.note, r.status,
+			(SELECT COUNT(*) FROM message_reports r2 WHERE r2.message_id = r.message_id),
+			r.resolved_by, r.resolved_at, r.created_at
+		FROM message_reports r
+		JOIN messages m ON m.id = r.message_id
+		`+where+`
+		ORDER BY r.created_at DESC, r.id DESC
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query reports: %v", err)
+	}
+	defer rows.Close()
+
+	var reports []*models.MessageReport
+	for rows.Next() {
+		report := &models.MessageReport{}
+		var resolvedBy sql.NullInt64
+		var resolvedAt sql.NullTime
+		if err := rows.Scan(&report.ID, &report.MessageID, &report.ConversationID, &report.SenderID, &report.ReporterID,
+			&report.Reason, &report.Note, &report.Status, &report.ReportCount,
+			&resolvedBy, &resolvedAt, &report.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan report: %v", err)
+		}
+		if resolvedBy.Valid {
+			report.ResolvedBy = &resolvedBy.Int64
+		}
+		if resolvedAt.Valid {
+			report.ResolvedAt = &resolvedAt.Time
+		}
+		reports = append(reports, report)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating reports: %v", err)
+	}
+
+	return reports, nil
+}
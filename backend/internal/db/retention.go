@@ -0,0 +1,178 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// retentionDays converts a nullable retention_days column to the model field
+func retentionDays(v sql.NullInt64) *int {
+	if !v.Valid {
+		return nil
+	}
+	days := int(v.Int64)
+	return &days
+}
+
+// SetConversationRetention sets how many days of messages a conversation
+// keeps; zero clears it, so the server default applies
+func (db *DB) SetConversationRetention(ctx context.Context, conversationID int64, days int) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.guardWrite(); err != nil {
+		return err
+	}
+
+	var value interface{}
+	if days > 0 {
+		value = days
+	}
+	result, err := db.DB.ExecContext(ctx, `
+		UPDATE conversations SET retention_days = ? WHERE id = ?
+	`, value, conversationID)
+	if err != nil {
+		return fmt.Errorf("failed to set retention: %w", db.checkWrite(err))
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// expiredMessagesSQL matches messages older than their retention as of the
+// first placeholder: the conversation's own retention_days, or failing
+// that the default in days bound to the second, where 0 keeps them
+// forever. Expects the message aliased as m and its conversation as c.
+const expiredMessagesSQL = `COALESCE(c.retention_days, NULLIF(?2, 0)) IS NOT NULL
+		  AND unixepoch(m.created_at, 'subsec') < unixepoch(?1, '-' || COALESCE(c.retention_days, ?2) || ' days')`
+
+// PruneExpiredMessages deletes up to limit messages, archived ones first,
+// that are older than their conversation's retention as of now, along with
+// their reports, and returns how many went from each conversation. Conversations without a
+// retention of their own keep messages for defaultDays, or forever when
+// that is 0. Messages are removed outright rather than tombstoned, so their
+// content is gone. Callers repeat until fewer than limit come back.
+func (db *DB) PruneExpiredMessages(ctx context.Context, now time.Time, defaultDays, limit int) (map[int64]int, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.guardWrite(); err != nil {
+		return nil, err
+	}
+
+	// Archived messages are the oldest, so they expire first
+	pruned, err := db.pruneExpiredArchive(ctx, now, defaultDays, limit)
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range pruned {
+		limit -= n
+	}
+	if limit <= 0 {
+		return pruned, nil
+	}
+
+	// The batch is picked before the write transaction begins, so writers
+	// only wait for the deletes and not for the scan. Expired messages
+	// never become unexpired, so the pick can't go stale in between.
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT m.id, m.conversation_id
+		FROM messages m
+		JOIN conversations c ON c.id = m.conversation_id
+		WHERE `+expiredMessagesSQL+`
+		ORDER BY m.id
+		LIMIT ?3
+	`, now.UTC().Format("2006-01-02 15:04:05"), defaultDays, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find expired messages: %v", err)
+	}
+	var ids []interface{}
+	for rows.Next() {
+		var id, conversationID int64
+		if err := rows.Scan(&id, &conversationID); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan expired message: %v", err)
+		}
+		ids = append(ids, id)
+		pruned[conversationID]++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating expired messages: %v", err)
+	}
+	if len(ids) == 0 {
+		return pruned, nil
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", db.checkWrite(err))
+	}
+	defer tx.Rollback()
+
+	// Expired messages that were still unread come off the counts first,
+	// while the rows are there to be counted
+	in := "?" + strings.Repeat(", ?", len(ids)-1)
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE conversation_participants
+		SET unread_count = MAX(unread_count - `+unreadSQL(` AND m.id IN (`+in+`)`)+`, 0)
+		WHERE unread_count > 0
+	`, ids...); err != nil {
+		return nil, fmt.Errorf("failed to update unread counts: %w", db.checkWrite(err))
+	}
+	// Their reports go with them (ON DELETE CASCADE)
+	if _, err := tx.ExecContext(ctx, `DELETE FROM messages WHERE id IN (`+in+`)`, ids...); err != nil {
+		return nil, fmt.Errorf("failed to delete expired messages: %w", db.checkWrite(err))
+	}
+	// The latest message may have been among them
+	conversationIDs := make([]int64, 0, len(pruned))
+	for conversationID := range pruned {
+		conversationIDs = append(conversationIDs, conversationID)
+	}
+	if err := refreshLastMessage(ctx, tx, conversationIDs); err != nil {
+		return nil, fmt.Errorf("failed to update latest messages: %w", db.checkWrite(err))
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", db.checkWrite(err))
+	}
+	return pruned, nil
+}
+
+// CountExpiredMessages reports how many messages PruneExpiredMessages would
+// delete from each conversation as of now, without deleting anything. It
+// scans every message, so it isn't bound by the statement timeout.
+func (db *DB) CountExpiredMessages(ctx context.Context, now time.Time, defaultDays int) (map[int64]int, error) {
+	rows, err := db.queryRead(ctx, `
+		SELECT m.conversation_id, COUNT(*)
+		FROM (
+			SELECT id, conversation_id, created_at FROM messages
+			UNION ALL
+			SELECT id, conversation_id, created_at FROM messages_archive
+		) m
+		JOIN conversations c ON c.id = m.conversation_id
+		WHERE `+expiredMessagesSQL+`
+		GROUP BY m.conversation_id
+	`, now.UTC().Format("2006-01-02 15:04:05"), defaultDays)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count expired messages: %v", err)
+	}
+	defer rows.Close()
+
+	expired := make(map[int64]int)
+	for rows.Next() {
+		var conversationID int64
+		var count int
+		if err := rows.Scan(&conversationID, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan expired count: %v", err)
+		}
+		expired[conversationID] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating expired counts: %v", err)
+	}
+	return expired, nil
+}
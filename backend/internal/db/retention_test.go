@@ -0,0 +1,96 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"messager/internal/db"
+	"messager/internal/db/testdb"
+)
+
+func TestPruneExpiredMessages(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	// Everyone joined long ago, so old messages count as unread
+	if _, err := d.Exec(`UPDATE conversation_participants SET joined_at = ?`, now.AddDate(0, -2, 0)); err != nil {
+		t.Fatal(err)
+	}
+	// Three 10-day-old messages in each conversation
+	for _, conversationID := range []int64{f.Direct.ID, f.Group.ID} {
+		for i := 0; i < 3; i++ {
+			if _, err := d.CreateMessageAt(ctx, conversationID, f.Bob.ID, "old", now.AddDate(0, 0, -10).Add(time.Duration(i)*time.Minute)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := d.SetConversationRetention(ctx, f.Group.ID, 30); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a default, only conversations with their own setting expire,
+	// and the group keeps 30 days
+	if expired, err := d.CountExpiredMessages(ctx, now, 0); err != nil || len(expired) != 0 {
+		t.Errorf("no default: %v, %v; want nothing expired", expired, err)
+	}
+	// A 7 day default applies to the direct conversation only
+	expired, err := d.CountExpiredMessages(ctx, now, 7)
+	if err != nil || len(expired) != 1 || expired[f.Direct.ID] != 3 {
+		t.Fatalf("7 day default: %v, %v; want the direct conversation's 3", expired, err)
+	}
+	unreadBefore := unreadCounts(t, d, f.Direct.ID)[f.Alice.ID]
+
+	// Batches stop at the limit
+	pruned, err := d.PruneExpiredMessages(ctx, now, 7, 2)
+	if err != nil || pruned[f.Direct.ID] != 2 {
+		t.Fatalf("first batch: %v, %v; want 2", pruned, err)
+	}
+	pruned, err = d.PruneExpiredMessages(ctx, now, 7, 2)
+	if err != nil || pruned[f.Direct.ID] != 1 {
+		t.Fatalf("second batch: %v, %v; want the last 1", pruned, err)
+	}
+	if got := unreadCounts(t, d, f.Direct.ID)[f.Alice.ID]; got != unreadBefore-3 {
+		t.Errorf("alice has %d unread, want %d with the pruned ones taken off", got, unreadBefore-3)
+	}
+	if n, err := d.RepairUnreadCounts(ctx); err != nil || n != 0 {
+		t.Errorf("repair after pruning fixed %d, %v", n, err)
+	}
+
+	// Clearing the group's setting puts it on the default
+	if err := d.SetConversationRetention(ctx, f.Group.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+	if expired, err := d.CountExpiredMessages(ctx, now, 7); err != nil || expired[f.Group.ID] != 3 {
+		t.Errorf("group on the default: %v, %v", expired, err)
+	}
+	if err := d.SetConversationRetention(ctx, 9999, 7); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("unknown conversation: %v, want ErrNotFound", err)
+	}
+}
+
+func TestPruneRefreshesLatestMessage(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	// Everything in the direct conversation expires as of a month from now
+	pruned, err := d.PruneExpiredMessages(ctx, now.AddDate(0, 1, 0), 7, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pruned[f.Direct.ID] != 2 || pruned[f.Group.ID] != 2 {
+		t.Fatalf("pruned %v, want both seeded pairs", pruned)
+	}
+	var last *int64
+	if err := d.QueryRow(`SELECT last_message_id FROM conversations WHERE id = ?`, f.Direct.ID).Scan(&last); err != nil {
+		t.Fatal(err)
+	}
+	if last != nil {
+		t.Errorf("latest message is still %d after pruning everything", *last)
+	}
+}
@@ -0,0 +1,119 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"messager/internal/db/testdb"
+	"messager/internal/models"
+)
+
+func TestSeqIsDensePerConversation(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+
+	// Seed wrote two messages to each conversation
+	for i, want := range []int64{1, 2, 1, 2} {
+		if got := f.Messages[i].Seq; got != want {
+			t.Errorf("fixture message %d has seq %d, want %d", i, got, want)
+		}
+	}
+	system, err := d.CreateSystemMessage(ctx, f.Group.ID, `{"event":"topic_changed"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if system.Seq != 3 {
+		t.Errorf("system message seq = %d, want 3", system.Seq)
+	}
+	msg, err := d.CreateMessage(ctx, f.Direct.ID, f.Bob.ID, "still 3 here")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Seq != 3 {
+		t.Errorf("direct message seq = %d, want 3", msg.Seq)
+	}
+}
+
+func TestGetMessagesAfterSeq(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+	for _, content := range []string{"three", "four", "five"} {
+		if _, err := d.CreateMessage(ctx, f.Group.ID, f.Bob.ID, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	messages, err := d.GetMessagesAfterSeq(ctx, f.Group.ID, 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 || messages[0].Seq != 3 || messages[1].Seq != 4 {
+		t.Fatalf("after seq 2, limit 2: got %d messages %+v, want seq 3 and 4 oldest first", len(messages), messages)
+	}
+	if messages[0].Content != "three" {
+		t.Errorf("content = %q, want three", messages[0].Content)
+	}
+
+	messages, err = d.GetMessagesAfterSeq(ctx, f.Group.ID, 5, 10)
+	if err != nil || len(messages) != 0 {
+		t.Errorf("after the latest seq: %d messages, %v", len(messages), err)
+	}
+}
+
+func TestImportMessages(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+	var outboxBefore int
+	if err := d.QueryRow(`SELECT COUNT(*) FROM message_outbox`).Scan(&outboxBefore); err != nil {
+		t.Fatal(err)
+	}
+
+	at := time.Now().Add(-time.Hour)
+	messages := []*models.Message{
+		{ConversationID: f.Group.ID, SenderID: f.Bob.ID, Content: "one", CreatedAt: at},
+		{ConversationID: f.Direct.ID, SenderID: f.Alice.ID, Content: "two", CreatedAt: at.Add(time.Minute)},
+		{ConversationID: f.Group.ID, SenderID: f.Carol.ID, Content: "three", CreatedAt: at.Add(2 * time.Minute)},
+	}
+	if err := d.ImportMessages(ctx, messages); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []int64{3, 3, 4} {
+		if messages[i].Seq != want || messages[i].ID == 0 {
+			t.Errorf("imported message %d has id %d, seq %d, want seq %d", i, messages[i].ID, messages[i].Seq, want)
+		}
+		if messages[i].MessageType != models.MessageTypeUser {
+			t.Errorf("imported message %d has type %q", i, messages[i].MessageType)
+		}
+	}
+
+	var outbox int
+	if err := d.QueryRow(`SELECT COUNT(*) FROM message_outbox`).Scan(&outbox); err != nil {
+		t.Fatal(err)
+	}
+	if outbox != outboxBefore {
+		t.Errorf("import queued %d messages for delivery, want none", outbox-outboxBefore)
+	}
+	stored, err := d.GetMessagesAfterSeq(ctx, f.Group.ID, 2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 2 || stored[0].Content != "one" || stored[1].Content != "three" {
+		t.Errorf("group after seq 2 = %+v, want the two imported messages", stored)
+	}
+
+	// A message for a conversation that doesn't exist rolls back the batch
+	err = d.ImportMessages(ctx, []*models.Message{
+		{ConversationID: f.Direct.ID, SenderID: f.Alice.ID, Content: "kept?", CreatedAt: at},
+		{ConversationID: 9999, SenderID: f.Alice.ID, Content: "nowhere", CreatedAt: at},
+	})
+	if err == nil {
+		t.Fatal("import into a missing conversation succeeded")
+	}
+	if stored, _ := d.GetMessagesAfterSeq(ctx, f.Direct.ID, 3, 10); len(stored) != 0 {
+		t.Errorf("failed import left %d messages behind", len(stored))
+	}
+}
@@ -0,0 +1,134 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// tableCountsTTL is how long Stats reuses row counts. SQLite has no cheap
+// approximate count, and COUNT(*) walks the table, so a burst of requests
+// costs one scan rather than one each.
+const tableCountsTTL = time.Minute
+
+// statsTables are the tables Stats counts, by the name they're reported as
+var statsTables = []struct{ name, table string }{
+	{"users", "users"},
+	{"conversations", "conversations"},
+	{"participants", "conversation_participants"},
+	{"messages", "messages"},
+	{"archived_messages", "messages_archive"},
+}
+
+// tableCounts caches the row counts behind Stats
+type tableCounts struct {
+	mu        sync.Mutex
+	counts    map[string]int64
+	countedAt time.Time
+}
+
+// Stats is a snapshot of the database's size for capacity planning
+type Stats struct {
+	// Tables maps a table to its row count as of CountedAt, at most
+	// tableCountsTTL ago
+	Tables    map[string]int64 `json:"tables"`
+	CountedAt time.Time        `json:"counted_at"`
+	// FileBytes and WALBytes are the sizes of the database file and its
+	// write-ahead log, 0 when there is none
+	FileBytes int64     `json:"file_bytes"`
+	WALBytes  int64     `json:"wal_bytes"`
+	Pool      PoolUsage `json:"pool"`
+	// Queries counts statements by the DB method that ran them, across
+	// both pools, and SlowQueries those over the slow-query threshold
+	Queries     map[string]int64 `json:"queries"`
+	SlowQueries int64            `json:"slow_queries"`
+	// MembershipCache is the participant cache's hit rate, see membership.go
+	MembershipCache MembershipCacheStats `json:"membership_cache"`
+}
+
+// PoolUsage is the primary pool's sql.DBStats
+type PoolUsage struct {
+	MaxOpenConnections int   `json:"max_open_connections"`
+	OpenConnections    int   `json:"open_connections"`
+	InUse              int   `json:"in_use"`
+	Idle               int   `json:"idle"`
+	WaitCount          int64 `json:"wait_count"`
+	WaitDurationMS     int64 `json:"wait_duration_ms"`
+	MaxIdleClosed      int64 `json:"max_idle_closed"`
+	MaxIdleTimeClosed  int64 `json:"max_idle_time_closed"`
+	MaxLifetimeClosed  int64 `json:"max_lifetime_closed"`
+}
+
+// Stats reports row counts, file sizes and pool usage. Counts are cached
+// for tableCountsTTL; counting reads every row, so it isn't bound by the
+// statement timeout.
+func (db *DB) Stats(ctx context.Context) (Stats, error) {
+	counts, countedAt, err := db.tableCounts(ctx)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	pool := db.DB.Stats()
+	stats := Stats{
+		Tables:    counts,
+		CountedAt: countedAt,
+		Pool: PoolUsage{
+			MaxOpenConnections: pool.MaxOpenConnections,
+			OpenConnections:    pool.OpenConnections,
+			InUse:              pool.InUse,
+			Idle:               pool.Idle,
+			WaitCount:          pool.WaitCount,
+			WaitDurationMS:     pool.WaitDuration.Milliseconds(),
+			MaxIdleClosed:      pool.MaxIdleClosed,
+			MaxIdleTimeClosed:  pool.MaxIdleTimeClosed,
+			MaxLifetimeClosed:  pool.MaxLifetimeClosed,
+		},
+	}
+	stats.Queries, stats.SlowQueries = db.QueryCounts()
+	stats.MembershipCache = db.MembershipCacheStats()
+	if db.path != "" {
+		if stats.FileBytes, err = fileSize(db.path); err != nil {
+			return Stats{}, err
+		}
+		if stats.WALBytes, err = fileSize(db.path + "-wal"); err != nil {
+			return Stats{}, err
+		}
+	}
+	return stats, nil
+}
+
+// tableCounts returns the cached row counts, counting again once they're
+// older than tableCountsTTL. Concurrent callers wait for one count.
+func (db *DB) tableCounts(ctx context.Context) (map[string]int64, time.Time, error) {
+	db.counts.mu.Lock()
+	defer db.counts.mu.Unlock()
+
+	if db.counts.counts != nil && time.Since(db.counts.countedAt) < tableCountsTTL {
+		return db.counts.counts, db.counts.countedAt, nil
+	}
+	counts := make(map[string]int64, len(statsTables))
+	for _, t := range statsTables {
+		var n int64
+		if err := db.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+t.table).Scan(&n); err != nil {
+			return nil, time.Time{}, fmt.Errorf("failed to count %s: %v", t.table, err)
+		}
+		counts[t.name] = n
+	}
+	db.counts.counts = counts
+	db.counts.countedAt = time.Now().UTC()
+	return counts, db.counts.countedAt, nil
+}
+
+// fileSize is the size of the file at path, 0 if it doesn't exist
+func fileSize(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat %s: %v", path, err)
+	}
+	return info.Size(), nil
+}
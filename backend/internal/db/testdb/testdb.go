@@ -0,0 +1,94 @@
+// Package testdb opens throwaway databases for tests of the db package and
+// of the handlers built on it.
+package testdb
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+	"messager/internal/db"
+	"messager/internal/models"
+)
+
+// Password is what every fixture user logs in with
+const Password = "password123"
+
+// Open returns a fully migrated database in a temporary file that is closed
+// and removed when tb finishes. A file is used rather than ":memory:"
+// because each pooled connection would otherwise get its own empty
+// database.
+func Open(tb testing.TB) *db.DB {
+	tb.Helper()
+	d, err := db.NewDB(filepath.Join(tb.TempDir(), "test.db"))
+	if err != nil {
+		tb.Fatalf("testdb: open: %v", err)
+	}
+	tb.Cleanup(func() { d.Close() })
+	return d
+}
+
+// Fixtures is the data Seed creates: alice, bob and carol, a direct
+// conversation between alice and bob, and a group of all three owned by
+// alice, each with a couple of messages
+type Fixtures struct {
+	Alice, Bob, Carol *models.User
+	Direct            *models.Conversation
+	Group             *models.Conversation
+	Messages          []*models.Message // oldest first, Direct's then Group's
+}
+
+// Seed fills d with the Fixtures
+func Seed(tb testing.TB, d *db.DB) *Fixtures {
+	tb.Helper()
+	ctx := context.Background()
+	f := &Fixtures{
+		Alice: CreateUser(tb, d, "alice"),
+		Bob:   CreateUser(tb, d, "bob"),
+		Carol: CreateUser(tb, d, "carol"),
+	}
+
+	var err error
+	f.Direct, _, err = d.GetOrCreateDirectConversation(ctx, f.Alice.ID, f.Bob.ID, "")
+	if err != nil {
+		tb.Fatalf("testdb: direct conversation: %v", err)
+	}
+	f.Group, err = d.CreateConversation(ctx, "Team", "group", f.Alice.ID, []int64{f.Alice.ID, f.Bob.ID, f.Carol.ID})
+	if err != nil {
+		tb.Fatalf("testdb: group conversation: %v", err)
+	}
+
+	for _, m := range []struct {
+		conversation *models.Conversation
+		sender       *models.User
+		content      string
+	}{
+		{f.Direct, f.Alice, "hey bob"},
+		{f.Direct, f.Bob, "hi alice"},
+		{f.Group, f.Alice, "welcome to the team"},
+		{f.Group, f.Carol, "thanks!"},
+	} {
+		msg, err := d.CreateMessage(ctx, m.conversation.ID, m.sender.ID, m.content)
+		if err != nil {
+			tb.Fatalf("testdb: message %q: %v", m.content, err)
+		}
+		f.Messages = append(f.Messages, msg)
+	}
+	return f
+}
+
+// CreateUser adds a user who logs in with Password. The hash uses the
+// minimum cost to keep tests fast.
+func CreateUser(tb testing.TB, d *db.DB, username string) *models.User {
+	tb.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(Password), bcrypt.MinCost)
+	if err != nil {
+		tb.Fatalf("testdb: hash password: %v", err)
+	}
+	user, err := d.CreateUser(context.Background(), username, string(hash), "")
+	if err != nil {
+		tb.Fatalf("testdb: create user %s: %v", username, err)
+	}
+	return user
+}
@@ -0,0 +1,27 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+const defaultStatementTimeout = 5 * time.Second
+
+// SetStatementTimeout sets the longest any one DB method may run, 0 for no
+// limit. A caller's tighter deadline on ctx still applies. It must be set
+// before the server starts serving.
+func (db *DB) SetStatementTimeout(timeout time.Duration) {
+	if timeout >= 0 {
+		db.stmtTimeout = timeout
+	}
+}
+
+// withTimeout bounds ctx by the statement timeout. A query that runs past it,
+// or whose caller has gone away, is interrupted and returns the context's
+// error.
+func (db *DB) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if db.stmtTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, db.stmtTimeout)
+}
@@ -0,0 +1,59 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"messager/internal/db/testdb"
+)
+
+func TestStatementTimeout(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+
+	// A timeout too short for anything to finish interrupts reads and writes
+	d.SetStatementTimeout(time.Nanosecond)
+	if _, err := d.GetConversationByID(ctx, f.Group.ID); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("read under a 1ns timeout: %v, want DeadlineExceeded", err)
+	}
+	if _, err := d.CreateMessage(ctx, f.Group.ID, f.Alice.ID, "too slow"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("write under a 1ns timeout: %v, want DeadlineExceeded", err)
+	}
+
+	// A negative timeout is ignored, and zero turns the limit off
+	d.SetStatementTimeout(-time.Second)
+	if _, err := d.GetConversationByID(ctx, f.Group.ID); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("after a negative timeout: %v, want the 1ns limit kept", err)
+	}
+	d.SetStatementTimeout(0)
+	ids, err := d.GetConversationParticipantIDs(ctx, f.Group.ID)
+	if err != nil || len(ids) != 3 {
+		t.Errorf("without a limit: %v, %v", ids, err)
+	}
+	if stored, _ := d.GetMessagesAfterSeq(ctx, f.Group.ID, 2, 10); len(stored) != 0 {
+		t.Errorf("the interrupted write left %d messages", len(stored))
+	}
+}
+
+func TestCallerContextStillApplies(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	d.SetStatementTimeout(time.Hour)
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := d.GetConversationByID(canceled, f.Group.ID); !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled caller: %v, want Canceled", err)
+	}
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	if _, err := d.CreateMessage(expired, f.Group.ID, f.Alice.ID, "late"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("caller past its deadline: %v, want DeadlineExceeded", err)
+	}
+	if _, err := d.GetConversationParticipantIDs(context.Background(), f.Group.ID); err != nil {
+		t.Errorf("a live context: %v", err)
+	}
+}
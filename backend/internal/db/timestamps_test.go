@@ -0,0 +1,169 @@
+package db_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"messager/internal/db"
+	"messager/internal/db/testdb"
+	"messager/internal/models"
+)
+
+// Rows written before timestamps were normalized carry the writer's UTC
+// offset. They must read back as the same instant, in UTC.
+func TestOffsetTimestampsReadBackInUTC(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+
+	target := f.Messages[3]
+	if _, err := d.Exec(`UPDATE messages SET created_at = ? WHERE id = ?`, "2024-01-02 10:00:00.123+02:00", target.ID); err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2024, 1, 2, 8, 0, 0, 123e6, time.UTC)
+
+	messages, err := d.GetConversationMessages(ctx, f.Group.ID, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range messages {
+		if m.ID != target.ID {
+			continue
+		}
+		if !m.CreatedAt.Equal(want) || m.CreatedAt.Location() != time.UTC {
+			t.Errorf("created_at = %s, want %s", m.CreatedAt, want)
+		}
+	}
+
+	if _, err := d.Exec(db.UTCBackfill("messages", "created_at")); err != nil {
+		t.Fatal(err)
+	}
+	var stored string
+	if err := d.QueryRow(`SELECT created_at || '' FROM messages WHERE id = ?`, target.ID).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored != "2024-01-02 08:00:00.123" {
+		t.Errorf("backfilled to %q, want 2024-01-02 08:00:00.123", stored)
+	}
+
+	// New rows are written in UTC so the backfill leaves them alone
+	msg, err := d.CreateMessage(ctx, f.Group.ID, f.Bob.ID, "now")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.CreatedAt.Location() != time.UTC {
+		t.Errorf("new message created_at in %s, want UTC", msg.CreatedAt.Location())
+	}
+}
+
+func TestCreateReturnsStoredTimestamps(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+
+	user, err := d.CreateUser(ctx, "dave", "hash", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, lookup := range []func() (*models.User, error){
+		func() (*models.User, error) { return d.GetUserByID(ctx, user.ID) },
+		func() (*models.User, error) { return d.GetUserByUsername(ctx, "dave") },
+	} {
+		stored, err := lookup()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !stored.CreatedAt.Equal(user.CreatedAt) {
+			t.Errorf("stored user created at %v, create returned %v", stored.CreatedAt, user.CreatedAt)
+		}
+	}
+
+	// SaveMessage stamps the server's time over whatever the caller set
+	before := time.Now()
+	saved, err := d.SaveMessage(ctx, &models.Message{ConversationID: f.Direct.ID, SenderID: f.Alice.ID, Content: "now"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backdated, err := d.SaveMessage(ctx, &models.Message{
+		ConversationID: f.Direct.ID, SenderID: f.Alice.ID, Content: "claims to be old",
+		CreatedAt: time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []*models.Message{saved, backdated} {
+		if msg.CreatedAt.Before(before) || msg.CreatedAt.Location() != time.UTC {
+			t.Errorf("%q stamped %v, want the server's time in UTC", msg.Content, msg.CreatedAt)
+		}
+		stored, err := d.GetMessageByID(ctx, msg.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !stored.CreatedAt.Equal(msg.CreatedAt) {
+			t.Errorf("%q stored at %v, save returned %v", msg.Content, stored.CreatedAt, msg.CreatedAt)
+		}
+	}
+}
+
+// A server whose local zone isn't UTC must still write and read UTC, and
+// the backfill must turn rows its driver wrote with a local offset into UTC
+func TestTimestampsStayUTCUnderLocalZone(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		newYork = time.FixedZone("EST", -5*60*60)
+	}
+	local := time.Local
+	time.Local = newYork
+	t.Cleanup(func() { time.Local = local })
+
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+
+	msg, err := d.CreateMessage(ctx, f.Group.ID, f.Bob.ID, "from new york")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := d.GetMessageByID(ctx, msg.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.CreatedAt.Location() != time.UTC || stored.CreatedAt.Location() != time.UTC {
+		t.Errorf("created_at in %s, read back in %s, want UTC", msg.CreatedAt.Location(), stored.CreatedAt.Location())
+	}
+	if !stored.CreatedAt.Equal(msg.CreatedAt) {
+		t.Errorf("stored at %v, create returned %v", stored.CreatedAt, msg.CreatedAt)
+	}
+	var raw string
+	if err := d.QueryRow(`SELECT created_at || '' FROM messages WHERE id = ?`, msg.ID).Scan(&raw); err != nil {
+		t.Fatal(err)
+	}
+	if strings.ContainsAny(raw[10:], "+-") && !strings.HasSuffix(raw, "+00:00") {
+		t.Errorf("created_at stored as %q, want UTC", raw)
+	}
+
+	// What the driver wrote for a local time before timestamps were
+	// normalized: the wall clock with its offset
+	written := time.Date(2024, 7, 1, 9, 30, 0, 0, newYork)
+	if _, err := d.Exec(`UPDATE messages SET created_at = ? WHERE id = ?`, written, msg.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Exec(db.UTCBackfill("messages", "created_at")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.QueryRow(`SELECT created_at || '' FROM messages WHERE id = ?`, msg.ID).Scan(&raw); err != nil {
+		t.Fatal(err)
+	}
+	if want := written.UTC().Format("2006-01-02 15:04:05.000"); raw != want {
+		t.Errorf("backfilled to %q, want %q", raw, want)
+	}
+	backfilled, err := d.GetMessageByID(ctx, msg.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !backfilled.CreatedAt.Equal(written) || backfilled.CreatedAt.Location() != time.UTC {
+		t.Errorf("backfilled created_at = %v, want %v in UTC", backfilled.CreatedAt, written.UTC())
+	}
+}
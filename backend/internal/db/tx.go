@@ -0,0 +1,46 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrNestedTx is returned by WithTx when called with a context from inside
+// another WithTx. SQLite has one writer, and the outer transaction already
+// holds the lock (_txlock=immediate), so a second one would wait on it
+// forever; the inner work should use the outer tx instead.
+var ErrNestedTx = errors.New("transaction already in progress")
+
+// txKey marks a context as belonging to a WithTx transaction
+type txKey struct{}
+
+// WithTx runs fn in a write transaction, committing if it returns nil and
+// rolling back if it returns an error or panics. fn gets a context marked
+// as inside the transaction; passing it on lets a nested WithTx fail with
+// ErrNestedTx rather than deadlock. Errors from fn are returned as is, so
+// fn should wrap them with checkWrite as usual.
+func (db *DB) WithTx(ctx context.Context, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	if ctx.Value(txKey{}) != nil {
+		return ErrNestedTx
+	}
+	if err := db.guardWrite(); err != nil {
+		return err
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", db.checkWrite(err))
+	}
+	// A no-op once committed, and runs on panic too
+	defer tx.Rollback()
+
+	if err := fn(context.WithValue(ctx, txKey{}, tx), tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", db.checkWrite(err))
+	}
+	return nil
+}
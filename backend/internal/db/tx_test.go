@@ -0,0 +1,116 @@
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"messager/internal/db"
+	"messager/internal/db/testdb"
+)
+
+func countUsers(t *testing.T, d *db.DB) int {
+	t.Helper()
+	var n int
+	if err := d.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func insertUser(ctx context.Context, tx *sql.Tx, username string) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO users (username, password) VALUES (?, 'hash')`, username)
+	return err
+}
+
+func TestWithTx(t *testing.T) {
+	d := testdb.Open(t)
+	ctx := context.Background()
+
+	if err := d.WithTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		return insertUser(ctx, tx, "committed")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if n := countUsers(t, d); n != 1 {
+		t.Fatalf("%d users after a commit, want 1", n)
+	}
+
+	// An error rolls back and comes back as is
+	failed := errors.New("changed my mind")
+	err := d.WithTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		if err := insertUser(ctx, tx, "rolled-back"); err != nil {
+			return err
+		}
+		return failed
+	})
+	if err != failed {
+		t.Errorf("WithTx returned %v, want fn's error", err)
+	}
+
+	// So does a panic, which still propagates
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("the panic was swallowed")
+			}
+		}()
+		d.WithTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+			if err := insertUser(ctx, tx, "panicked"); err != nil {
+				return err
+			}
+			panic("boom")
+		})
+	}()
+	if n := countUsers(t, d); n != 1 {
+		t.Errorf("%d users after a rollback and a panic, want 1", n)
+	}
+
+	// The write lock isn't left held: another write goes through
+	if _, err := d.CreateUser(ctx, "after", "hash", ""); err != nil {
+		t.Errorf("write after a rollback: %v", err)
+	}
+}
+
+func TestNestedWithTxFails(t *testing.T) {
+	d := testdb.Open(t)
+	ctx := context.Background()
+
+	var inner error
+	err := d.WithTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		inner = d.WithTx(ctx, func(context.Context, *sql.Tx) error { return nil })
+		return insertUser(ctx, tx, "outer")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(inner, db.ErrNestedTx) {
+		t.Errorf("nested WithTx: %v, want ErrNestedTx", inner)
+	}
+	if n := countUsers(t, d); n != 1 {
+		t.Errorf("%d users, want the outer transaction's 1", n)
+	}
+}
+
+func TestFailedMessageInsertLeavesNoTrace(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+	unread := unreadCounts(t, d, f.Group.ID)
+
+	// The sender doesn't exist, so the insert fails after the seq was bumped
+	if _, err := d.CreateMessage(ctx, f.Group.ID, 9999, "from nobody"); !errors.Is(err, db.ErrForeignKey) {
+		t.Fatalf("message from an unknown sender: %v, want ErrForeignKey", err)
+	}
+	msg, err := d.CreateMessage(ctx, f.Group.ID, f.Alice.ID, "next")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Seq != 3 {
+		t.Errorf("seq %d after a failed insert, want 3 with no gap", msg.Seq)
+	}
+	if got := unreadCounts(t, d, f.Group.ID); got[f.Bob.ID] != unread[f.Bob.ID]+1 {
+		t.Errorf("bob has %d unread, want %d", got[f.Bob.ID], unread[f.Bob.ID]+1)
+	}
+}
@@ -0,0 +1,69 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"messager/internal/models"
+)
+
+// Each participant row keeps unread_count, the number of user messages
+// after their read marker that someone else sent since they joined, so the
+// conversation list doesn't count history on every request. insertMessage
+// bumps it in the message's own transaction, MarkRead recounts what is left
+// after the new marker, and deleting or pruning messages takes them back
+// off. Write transactions take the lock at BEGIN (_txlock=immediate), so
+// concurrent sends queue rather than losing increments or deadlocking.
+// RepairUnreadCounts recomputes the column if it ever drifts.
+
+// unreadSQL counts the messages unread by the conversation_participants row
+// being updated, narrowed by the extra conditions in filter
+func unreadSQL(filter string) string {
+	return `(SELECT COUNT(*) FROM messages m
+		WHERE m.conversation_id = conversation_participants.conversation_id
+		  AND m.id > COALESCE(conversation_participants.last_read_message_id, 0)
+		  AND m.message_type = '` + models.MessageTypeUser + `' AND m.deleted_at IS NULL
+		  AND m.sender_id IS NOT conversation_participants.user_id
+		  AND unixepoch(m.created_at, 'subsec') >= unixepoch(conversation_participants.joined_at, 'subsec')` + filter + `)`
+}
+
+// bumpUnread counts msg as unread for everyone in its conversation but the
+// sender. senderID is NULL for system messages, which aren't counted.
+func bumpUnread(ctx context.Context, tx *sql.Tx, msg *models.Message, senderID sql.NullInt64) error {
+	if msg.MessageType != models.MessageTypeUser {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, `
+		UPDATE conversation_participants SET unread_count = unread_count + 1
+		WHERE conversation_id = ? AND user_id IS NOT ?
+		  AND unixepoch(joined_at, 'subsec') <= unixepoch(?, 'subsec')
+	`, msg.ConversationID, senderID, msg.CreatedAt)
+	return err
+}
+
+// RepairUnreadCounts recomputes unread_count from the read markers for the
+// given conversations, or for every conversation when none are given, and
+// returns how many participants had drifted. A full repair reads every
+// message, so it isn't bound by the statement timeout.
+func (db *DB) RepairUnreadCounts(ctx context.Context, conversationIDs ...int64) (int64, error) {
+	if err := db.guardWrite(); err != nil {
+		return 0, err
+	}
+
+	query := `UPDATE conversation_participants SET unread_count = ` + unreadSQL("") + `
+		WHERE unread_count != ` + unreadSQL("")
+	args := make([]interface{}, len(conversationIDs))
+	for i, id := range conversationIDs {
+		args[i] = id
+	}
+	if len(conversationIDs) > 0 {
+		query += ` AND conversation_id IN (?` + strings.Repeat(", ?", len(conversationIDs)-1) + `)`
+	}
+	result, err := db.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to repair unread counts: %w", db.checkWrite(err))
+	}
+	return result.RowsAffected()
+}
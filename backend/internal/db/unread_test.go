@@ -0,0 +1,121 @@
+package db_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"messager/internal/db/testdb"
+)
+
+func TestUnreadCountsFollowReadsAndDeletes(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+
+	// Seeded: alice and carol each sent one to the group
+	if got := unreadCounts(t, d, f.Group.ID); got[f.Alice.ID] != 1 || got[f.Bob.ID] != 2 || got[f.Carol.ID] != 1 {
+		t.Fatalf("seeded unread counts %v", got)
+	}
+	var sent []int64
+	for i := 0; i < 3; i++ {
+		msg, err := d.CreateMessage(ctx, f.Group.ID, f.Carol.ID, fmt.Sprintf("more %d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sent = append(sent, msg.ID)
+	}
+
+	// Reading up to a message leaves only what came after it
+	if _, err := d.MarkRead(ctx, f.Group.ID, f.Bob.ID, sent[0]); err != nil {
+		t.Fatal(err)
+	}
+	if got := unreadCounts(t, d, f.Group.ID)[f.Bob.ID]; got != 2 {
+		t.Errorf("bob has %d unread after reading to the first new message, want 2", got)
+	}
+	if moved, err := d.MarkRead(ctx, f.Group.ID, f.Bob.ID, f.Messages[2].ID); err != nil || moved {
+		t.Errorf("moving the marker back: %t, %v", moved, err)
+	}
+
+	// A deleted message isn't unread any more
+	if err := d.SoftDeleteMessage(ctx, sent[2]); err != nil {
+		t.Fatal(err)
+	}
+	got := unreadCounts(t, d, f.Group.ID)
+	if got[f.Bob.ID] != 1 || got[f.Alice.ID] != 3 {
+		t.Errorf("after deleting the last message: %v, want bob 1 and alice 3", got)
+	}
+
+	// Someone who joins later doesn't inherit the backlog
+	dave := testdb.CreateUser(t, d, "dave")
+	if _, err := d.AddConversationParticipants(ctx, f.Group.ID, []int64{dave.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if got := unreadCounts(t, d, f.Group.ID)[dave.ID]; got != 0 {
+		t.Errorf("a new member has %d unread", got)
+	}
+
+	if n, err := d.RepairUnreadCounts(ctx); err != nil || n != 0 {
+		t.Errorf("repair found %d drifted counts, %v; want none", n, err)
+	}
+}
+
+func TestConcurrentSendsKeepCountsExact(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+
+	const senders, each = 6, 10
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		sender := f.Bob.ID
+		if i%2 == 0 {
+			sender = f.Carol.ID
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < each; j++ {
+				if _, err := d.CreateMessage(ctx, f.Group.ID, sender, "busy"); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Alice sees all of them plus carol's seeded one
+	if got := unreadCounts(t, d, f.Group.ID)[f.Alice.ID]; got != senders*each+1 {
+		t.Errorf("alice has %d unread, want %d", got, senders*each+1)
+	}
+	if n, err := d.RepairUnreadCounts(ctx); err != nil || n != 0 {
+		t.Errorf("repair found %d drifted counts after concurrent sends, %v", n, err)
+	}
+}
+
+func TestRepairUnreadCounts(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+	want := unreadCounts(t, d, f.Group.ID)
+
+	if _, err := d.Exec(`UPDATE conversation_participants SET unread_count = 99`); err != nil {
+		t.Fatal(err)
+	}
+	// Only the named conversation is repaired
+	n, err := d.RepairUnreadCounts(ctx, f.Group.ID)
+	if err != nil || n != 3 {
+		t.Fatalf("repaired %d, %v; want the group's 3", n, err)
+	}
+	if got := unreadCounts(t, d, f.Group.ID); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("repaired counts %v, want %v", got, want)
+	}
+	if got := unreadCounts(t, d, f.Direct.ID)[f.Alice.ID]; got != 99 {
+		t.Errorf("the direct conversation was repaired too (%d)", got)
+	}
+	if n, err := d.RepairUnreadCounts(ctx); err != nil || n != 2 {
+		t.Errorf("a full repair fixed %d, %v; want the direct conversation's 2", n, err)
+	}
+}
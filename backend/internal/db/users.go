@@ -0,0 +1,133 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"messager/internal/models"
+)
+
+// Deleted users keep their row: messages, memberships and reports reference
+// it, and removing it would orphan them. Instead deleted_at is set, lookups
+// by name, search and listings skip the row, and lookups by ID return a
+// stub. Anonymizing goes further and scrubs what the row says about the
+// person, freeing the username.
+
+// visibleUsernameSQL and visibleAvatarSQL show a deleted user as
+// models.DeletedUsername with no avatar. Both expect the user aliased as u.
+const (
+	visibleUsernameSQL = `CASE WHEN u.deleted_at IS NULL THEN u.username ELSE '` + models.DeletedUsername + `' END`
+	visibleAvatarSQL   = `CASE WHEN u.deleted_at IS NULL THEN COALESCE(u.avatar, '') ELSE '' END`
+)
+
+// redactDeleted turns user into the stub of a deleted account when
+// deletedAt is set
+func redactDeleted(user *models.User, deletedAt sql.NullTime) {
+	if !deletedAt.Valid {
+		return
+	}
+	user.Username = models.DeletedUsername
+	user.Password = ""
+	user.Avatar = ""
+	user.LastSeenAt = nil
+	user.DeletedAt = &deletedAt.Time
+}
+
+// DeleteUser soft-deletes a user: they can no longer log in or be found,
+// and show up as models.DeletedUsername, but their row and everything
+// referencing it stays. Returns ErrNotFound for an unknown or already
+// deleted user.
+func (db *DB) DeleteUser(ctx context.Context, userID int64) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.guardWrite(); err != nil {
+		return err
+	}
+
+	result, err := db.DB.ExecContext(ctx, `
+		UPDATE users SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL
+	`, time.Now().UTC(), userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", db.checkWrite(err))
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	db.names.forgetUser(userID)
+	return nil
+}
+
+// RestoreUser undoes DeleteUser. An anonymized user can't be restored, as
+// there is nothing left to log in with; ErrNotFound is returned for them
+// and for users that aren't deleted.
+func (db *DB) RestoreUser(ctx context.Context, userID int64) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.guardWrite(); err != nil {
+		return err
+	}
+
+	result, err := db.DB.ExecContext(ctx, `
+		UPDATE users SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL AND password != ''
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to restore user: %w", db.checkWrite(err))
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	db.names.forgetUser(userID)
+	return nil
+}
+
+// AnonymizeUser deletes a user, if not already, and scrubs their row: the
+// username becomes a random placeholder, freeing the old one, and the
+// password, avatar and email are cleared along with pending email
+// verifications. Messages they sent are left for conversation retention to
+// handle. Returns ErrNotFound for an unknown user.
+func (db *DB) AnonymizeUser(ctx context.Context, userID int64) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	if err := db.guardWrite(); err != nil {
+		return err
+	}
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return fmt.Errorf("failed to generate placeholder username: %v", err)
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", db.checkWrite(err))
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE users
+		SET username = ?, password = '', avatar = '', email = NULL, email_verified_at = NULL,
+		    deleted_at = COALESCE(deleted_at, ?)
+		WHERE id = ?
+	`, "deleted-"+hex.EncodeToString(suffix), time.Now().UTC(), userID)
+	if err != nil {
+		return fmt.Errorf("failed to anonymize user: %w", db.checkWrite(err))
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM email_verifications WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("failed to delete email verifications: %w", db.checkWrite(err))
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", db.checkWrite(err))
+	}
+	db.names.forgetUser(userID)
+	return nil
+}
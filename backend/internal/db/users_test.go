@@ -0,0 +1,117 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"messager/internal/db"
+	"messager/internal/db/testdb"
+	"messager/internal/models"
+)
+
+func TestDeletedUserKeepsTheirRow(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+
+	if err := d.DeleteUser(ctx, f.Bob.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.DeleteUser(ctx, f.Bob.ID); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("deleting twice: %v, want ErrNotFound", err)
+	}
+
+	// Gone from lookups by name, search and listings
+	if _, err := d.GetUserByUsername(ctx, "bob"); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("lookup by name: %v, want ErrNotFound", err)
+	}
+	if found, err := d.SearchUsers(ctx, "bo"); err != nil || len(found) != 0 {
+		t.Errorf("search found %d users, %v", len(found), err)
+	}
+	all, err := d.GetAllUsers(ctx)
+	if err != nil || len(all) != 2 {
+		t.Errorf("%d users listed, %v; want alice and carol", len(all), err)
+	}
+
+	// By ID, and wherever they appear, they are a stub
+	stub, err := d.GetUserByID(ctx, f.Bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stub.Username != models.DeletedUsername || stub.Password != "" || stub.DeletedAt == nil {
+		t.Errorf("deleted user looks up as %+v", stub)
+	}
+	members, err := d.GetParticipantsForConversations(ctx, []int64{f.Group.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, member := range members[f.Group.ID] {
+		if member.ID == f.Bob.ID && member.Username != models.DeletedUsername {
+			t.Errorf("group lists bob as %q", member.Username)
+		}
+	}
+	conversations, err := d.GetUserConversations(ctx, f.Alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, conv := range conversations {
+		if conv.ID == f.Direct.ID && conv.Name != models.DeletedUsername {
+			t.Errorf("alice's direct conversation with bob is named %q", conv.Name)
+		}
+	}
+
+	// Their memberships and messages stay, but nobody can add them again
+	if ids, _ := d.GetConversationParticipantIDs(ctx, f.Group.ID); len(ids) != 3 {
+		t.Errorf("group has %d members after deleting bob, want 3", len(ids))
+	}
+	other, err := d.CreateConversation(ctx, "Other", "group", f.Alice.ID, []int64{f.Alice.ID, f.Carol.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := d.AddConversationParticipants(ctx, other.ID, []int64{f.Bob.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Status != models.ParticipantNotFound {
+		t.Errorf("adding a deleted user: %+v", results)
+	}
+
+	if err := d.RestoreUser(ctx, f.Bob.ID); err != nil {
+		t.Fatal(err)
+	}
+	if user, err := d.GetUserByUsername(ctx, "bob"); err != nil || user.ID != f.Bob.ID {
+		t.Errorf("after restoring: %v, %v", user, err)
+	}
+	if err := d.RestoreUser(ctx, f.Bob.ID); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("restoring a user who isn't deleted: %v, want ErrNotFound", err)
+	}
+}
+
+func TestAnonymizeUser(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+
+	if err := d.AnonymizeUser(ctx, f.Carol.ID); err != nil {
+		t.Fatal(err)
+	}
+	var username, password, avatar string
+	if err := d.QueryRow(`SELECT username, password, COALESCE(avatar, '') FROM users WHERE id = ?`, f.Carol.ID).Scan(&username, &password, &avatar); err != nil {
+		t.Fatal(err)
+	}
+	if username == "carol" || password != "" || avatar != "" {
+		t.Errorf("anonymized row keeps %q, %q, %q", username, password, avatar)
+	}
+
+	// The name is free again, and there's nothing left to restore
+	if _, err := d.CreateUser(ctx, "carol", "hash", ""); err != nil {
+		t.Errorf("reusing an anonymized username: %v", err)
+	}
+	if err := d.RestoreUser(ctx, f.Carol.ID); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("restoring an anonymized user: %v, want ErrNotFound", err)
+	}
+	if err := d.AnonymizeUser(ctx, 9999); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("anonymizing an unknown user: %v, want ErrNotFound", err)
+	}
+}
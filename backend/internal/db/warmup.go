@@ -0,0 +1,218 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Statements on the per-message and per-connection hot paths. They are
+// prepared once and reused; database/sql re-prepares transparently on any
+// pooled connection that hasn't seen them yet.
+const (
+	sqlIsParticipant  = `SELECT 1 FROM conversation_participants WHERE conversation_id = ? AND user_id = ?`
+	sqlParticipantIDs = `SELECT user_id FROM conversation_participants WHERE conversation_id = ?`
+	sqlUserByID       = `SELECT id, username, password, avatar, is_bot, created_at, deleted_at FROM users WHERE id = ?`
+	sqlUsernameByID   = `SELECT ` + visibleUsernameSQL + ` FROM users u WHERE u.id = ?`
+	sqlDirectPeer     = `SELECT user_id FROM conversation_participants WHERE conversation_id = ? AND user_id != ? LIMIT 1`
+)
+
+var hotStatements = []string{sqlIsParticipant, sqlParticipantIDs, sqlUserByID, sqlUsernameByID, sqlDirectPeer}
+
+// warmupMessageWindow bounds how many of the newest messages are scanned to
+// find recently active conversations
+const warmupMessageWindow = 10000
+
+type stmtCache struct {
+	mu    sync.Mutex
+	stmts map[string]*sql.Stmt
+}
+
+// prepared returns the cached statement for query, preparing it on first use
+func (db *DB) prepared(query string) (*sql.Stmt, error) {
+	db.stmts.mu.Lock()
+	defer db.stmts.mu.Unlock()
+	if stmt, ok := db.stmts.stmts[query]; ok {
+		return stmt, nil
+	}
+	stmt, err := db.DB.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	if db.stmts.stmts == nil {
+		db.stmts.stmts = make(map[string]*sql.Stmt)
+	}
+	db.stmts.stmts[query] = stmt
+	return stmt, nil
+}
+
+func (db *DB) closeStatements() {
+	db.stmts.mu.Lock()
+	defer db.stmts.mu.Unlock()
+	for _, stmt := range db.stmts.stmts {
+		stmt.Close()
+	}
+	db.stmts.stmts = nil
+}
+
+// WarmupOptions bounds the work done by Warmup
+type WarmupOptions struct {
+	// Conversations is how many of the most recently active conversations
+	// have their participants and usernames cached
+	Conversations int
+	// Connections is how many pooled connections to open ahead of traffic
+	Connections int
+}
+
+// WarmupReport summarizes what Warmup did
+type WarmupReport struct {
+	Statements    int
+	Connections   int
+	Conversations int
+	Users         int
+	Duration      time.Duration
+
+	// ConversationIDs are the recently active conversations cached, for
+	// priming other caches
+	ConversationIDs []int64
+}
+
+// Warmup gets the database ready for a burst of reconnecting clients:
+// it prepares the hot statements, opens pooled connections and primes the
+// display name cache for recently active conversations, which also pulls
+// their index pages into SQLite's page cache.
+func (db *DB) Warmup(ctx context.Context, opts WarmupOptions) (WarmupReport, error) {
+	start := time.Now()
+	var report WarmupReport
+
+	for _, query := range hotStatements {
+		if _, err := db.prepared(query); err != nil {
+			return report, fmt.Errorf("failed to prepare statement: %v", err)
+		}
+		report.Statements++
+	}
+
+	n, err := db.openConnections(ctx, db.DB, opts.Connections)
+	report.Connections += n
+	if err != nil {
+		return report, err
+	}
+	for _, r := range db.replicas {
+		n, err := db.openConnections(ctx, r.pool, opts.Connections)
+		report.Connections += n
+		if err != nil {
+			return report, err
+		}
+	}
+
+	if opts.Conversations > 0 {
+		conversationIDs, users, err := db.primeNameCache(ctx, opts.Conversations)
+		if err != nil {
+			return report, err
+		}
+		report.Conversations, report.Users = len(conversationIDs), users
+		report.ConversationIDs = conversationIDs
+	}
+
+	report.Duration = time.Since(start)
+	return report, nil
+}
+
+// openConnections checks out n connections at once so the pool dials them
+// all, then returns them as idle. n is capped at the pool's limit, and the
+// idle limit is raised to n if it's lower.
+func (db *DB) openConnections(ctx context.Context, pool *sql.DB, n int) (int, error) {
+	if max := db.pool.MaxOpenConns; max > 0 && n > max {
+		n = max
+	}
+	if n <= 0 {
+		return 0, nil
+	}
+	if n > db.pool.MaxIdleConns {
+		pool.SetMaxIdleConns(n)
+	}
+
+	conns := make([]*sql.Conn, 0, n)
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	for i := 0; i < n; i++ {
+		conn, err := pool.Conn(ctx)
+		if err != nil {
+			return len(conns), fmt.Errorf("failed to open connection: %v", err)
+		}
+		conns = append(conns, conn)
+		if err := conn.PingContext(ctx); err != nil {
+			return len(conns), fmt.Errorf("failed to open connection: %v", err)
+		}
+	}
+	return len(conns), nil
+}
+
+// primeNameCache loads the participants of the most recently active
+// conversations, caching their participant sets, usernames and direct
+// conversation peers. It returns the conversations and the number of
+// distinct users cached.
+func (db *DB) primeNameCache(ctx context.Context, limit int) ([]int64, int, error) {
+	rows, err := db.DB.QueryContext(ctx, `
+		WITH recent AS (
+			SELECT conversation_id, MAX(id) AS last_id
+			FROM (SELECT id, conversation_id FROM messages ORDER BY id DESC LIMIT ?)
+			GROUP BY conversation_id
+			ORDER BY last_id DESC
+			LIMIT ?
+		)
+		SELECT c.id, c.type, u.id, `+visibleUsernameSQL+`
+		FROM recent r
+		JOIN conversations c ON c.id = r.conversation_id
+		JOIN conversation_participants cp ON cp.conversation_id = c.id
+		JOIN users u ON u.id = cp.user_id
+		ORDER BY c.id
+	`, warmupMessageWindow, limit)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query recent conversations: %v", err)
+	}
+	defer rows.Close()
+
+	members := make(map[int64][]int64)
+	usernames := make(map[int64]string)
+	direct := make(map[int64]bool)
+	for rows.Next() {
+		var conversationID, userID int64
+		var convType, username string
+		if err := rows.Scan(&conversationID, &convType, &userID, &username); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan participant: %v", err)
+		}
+		members[conversationID] = append(members[conversationID], userID)
+		usernames[userID] = username
+		direct[conversationID] = convType == "direct"
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating participants: %v", err)
+	}
+
+	expires := time.Now().Add(displayNameTTL)
+	db.names.mu.Lock()
+	for userID, username := range usernames {
+		db.names.usernames[userID] = cachedName{name: username, expires: expires}
+	}
+	for conversationID, ids := range members {
+		if !direct[conversationID] || len(ids) != 2 {
+			continue
+		}
+		db.names.otherUser[[2]int64{conversationID, ids[0]}] = ids[1]
+		db.names.otherUser[[2]int64{conversationID, ids[1]}] = ids[0]
+	}
+	db.names.mu.Unlock()
+	db.members.prime(members)
+
+	conversationIDs := make([]int64, 0, len(members))
+	for conversationID := range members {
+		conversationIDs = append(conversationIDs, conversationID)
+	}
+	return conversationIDs, len(usernames), nil
+}
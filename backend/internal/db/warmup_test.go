@@ -0,0 +1,38 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+
+	"messager/internal/db"
+	"messager/internal/db/testdb"
+)
+
+func TestWarmup(t *testing.T) {
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	ctx := context.Background()
+
+	report, err := d.Warmup(ctx, db.WarmupOptions{Conversations: 1, Connections: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Statements == 0 || report.Connections != 3 {
+		t.Errorf("report = %+v, want the hot statements and 3 connections", report)
+	}
+	// Only the group, the most recently active, with its three members
+	if report.Conversations != 1 || report.Users != 3 {
+		t.Errorf("cached %d conversations and %d users, want 1 and 3", report.Conversations, report.Users)
+	}
+
+	// The prepared statements serve the hot paths afterwards
+	ok, err := d.IsConversationParticipant(ctx, f.Group.ID, f.Carol.ID)
+	if err != nil || !ok {
+		t.Errorf("IsConversationParticipant after warmup = %t, %v", ok, err)
+	}
+
+	report, err = d.Warmup(ctx, db.WarmupOptions{})
+	if err != nil || report.Connections != 0 || report.Conversations != 0 {
+		t.Errorf("zero options: %+v, %v; want only statements", report, err)
+	}
+}
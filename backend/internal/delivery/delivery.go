@@ -0,0 +1,320 @@
+// Package delivery schedules outbound deliveries (broker publishes,
+// webhooks, push) per destination. Each destination gets its own token
+// bucket, concurrency cap and bounded backlog so one slow or busy endpoint
+// can't starve the others or get us blacklisted.
+package delivery
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	maxAttempts = 3
+	baseBackoff = 500 * time.Millisecond
+	maxBackoff  = time.Minute
+)
+
+// ErrClosed is returned by Enqueue after Close
+var ErrClosed = errors.New("dispatcher closed")
+
+// Job performs one delivery attempt
+type Job func() error
+
+// Limits bound the traffic sent to a single destination
+type Limits struct {
+	RatePerSec    float64 // sustained deliveries per second; <= 0 means unlimited
+	Burst         int     // deliveries allowed back-to-back after idling
+	MaxConcurrent int     // parallel in-flight deliveries
+	MaxBacklog    int     // queued jobs; the oldest is dropped when full
+}
+
+// Stats are the per-destination counters reported to operators
+type Stats struct {
+	Queued           int     `json:"queued"`
+	Delivered        int64   `json:"delivered"`
+	Failed           int64   `json:"failed"`    // failed attempts, including ones later retried
+	Abandoned        int64   `json:"abandoned"` // jobs that failed every attempt
+	Dropped          int64   `json:"dropped"`   // jobs evicted from a full backlog
+	AvgLatencyMillis float64 `json:"avg_latency_ms"`
+	BackingOff       bool    `json:"backing_off"`
+}
+
+type Dispatcher struct {
+	mu           sync.Mutex
+	defaults     Limits
+	overrides    map[string]Limits
+	destinations map[string]*destination
+	closed       bool
+	done         chan struct{}
+	wg           sync.WaitGroup
+}
+
+func NewDispatcher(defaults Limits) *Dispatcher {
+	return &Dispatcher{
+		defaults:     normalize(defaults),
+		overrides:    make(map[string]Limits),
+		destinations: make(map[string]*destination),
+		done:         make(chan struct{}),
+	}
+}
+
+// SetLimits overrides the defaults for one destination. It only affects
+// destinations that have not received a job yet.
+func (d *Dispatcher) SetLimits(name string, limits Limits) {
+	d.mu.Lock()
+	d.overrides[name] = normalize(limits)
+	d.mu.Unlock()
+}
+
+// Enqueue schedules job for destination name. It never blocks; a full
+// backlog drops its oldest job to make room.
+func (d *Dispatcher) Enqueue(name string, job Job) error {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return ErrClosed
+	}
+	dest, ok := d.destinations[name]
+	if !ok {
+		limits, hasOverride := d.overrides[name]
+		if !hasOverride {
+			limits = d.defaults
+		}
+		dest = newDestination(limits)
+		d.destinations[name] = dest
+		for i := 0; i < limits.MaxConcurrent; i++ {
+			d.wg.Add(1)
+			go d.work(dest)
+		}
+	}
+	d.mu.Unlock()
+
+	dest.push(job)
+	return nil
+}
+
+// Stats returns a snapshot of every destination's counters
+func (d *Dispatcher) Stats() map[string]Stats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	stats := make(map[string]Stats, len(d.destinations))
+	for name, dest := range d.destinations {
+		stats[name] = dest.stats()
+	}
+	return stats
+}
+
+// Close stops all workers and discards queued jobs. In-flight deliveries
+// finish first.
+func (d *Dispatcher) Close() {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return
+	}
+	d.closed = true
+	close(d.done)
+	d.mu.Unlock()
+
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) work(dest *destination) {
+	defer d.wg.Done()
+
+	for {
+		job, ok := dest.pop(d.done)
+		if !ok {
+			return
+		}
+
+		for attempt := 1; ; attempt++ {
+			if !dest.waitTurn(d.done) {
+				return
+			}
+
+			start := time.Now()
+			err := job()
+			dest.record(err, time.Since(start))
+			if err == nil {
+				break
+			}
+			if attempt >= maxAttempts {
+				dest.abandon()
+				break
+			}
+		}
+	}
+}
+
+type destination struct {
+	mu     sync.Mutex
+	limits Limits
+	queue  []Job
+	ready  chan struct{}
+
+	// The token bucket and retry backoff share pausedUntil so a failing
+	// endpoint isn't hit again the moment a token frees up
+	tokens      float64
+	lastRefill  time.Time
+	pausedUntil time.Time
+	failures    int
+
+	delivered    int64
+	failed       int64
+	abandoned    int64
+	dropped      int64
+	totalLatency time.Duration
+}
+
+func newDestination(limits Limits) *destination {
+	return &destination{
+		limits:     limits,
+		ready:      make(chan struct{}, 1),
+		tokens:     float64(limits.Burst),
+		lastRefill: time.Now(),
+	}
+}
+
+func (d *destination) push(job Job) {
+	d.mu.Lock()
+	if len(d.queue) >= d.limits.MaxBacklog {
+		d.queue = d.queue[1:]
+		d.dropped++
+	}
+	d.queue = append(d.queue, job)
+	d.mu.Unlock()
+
+	select {
+	case d.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (d *destination) pop(done <-chan struct{}) (Job, bool) {
+	for {
+		d.mu.Lock()
+		if len(d.queue) > 0 {
+			job := d.queue[0]
+			d.queue = d.queue[1:]
+			more := len(d.queue) > 0
+			d.mu.Unlock()
+			if more {
+				// Wake another worker for the remaining backlog
+				select {
+				case d.ready <- struct{}{}:
+				default:
+				}
+			}
+			return job, true
+		}
+		d.mu.Unlock()
+
+		select {
+		case <-d.ready:
+		case <-done:
+			return nil, false
+		}
+	}
+}
+
+// waitTurn blocks until both the backoff window and the token bucket allow
+// another attempt
+func (d *destination) waitTurn(done <-chan struct{}) bool {
+	for {
+		d.mu.Lock()
+		now := time.Now()
+		wait := d.pausedUntil.Sub(now)
+
+		if d.limits.RatePerSec > 0 {
+			d.tokens += now.Sub(d.lastRefill).Seconds() * d.limits.RatePerSec
+			if d.tokens > float64(d.limits.Burst) {
+				d.tokens = float64(d.limits.Burst)
+			}
+			d.lastRefill = now
+
+			if wait <= 0 && d.tokens >= 1 {
+				d.tokens--
+				d.mu.Unlock()
+				return true
+			}
+			if tokenWait := time.Duration((1 - d.tokens) / d.limits.RatePerSec * float64(time.Second)); tokenWait > wait {
+				wait = tokenWait
+			}
+		} else if wait <= 0 {
+			d.mu.Unlock()
+			return true
+		}
+		d.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-done:
+			timer.Stop()
+			return false
+		}
+	}
+}
+
+func (d *destination) record(err error, latency time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.totalLatency += latency
+	if err == nil {
+		d.delivered++
+		d.failures = 0
+		return
+	}
+
+	d.failed++
+	d.failures++
+	backoff := baseBackoff << (d.failures - 1)
+	if backoff > maxBackoff || backoff <= 0 {
+		backoff = maxBackoff
+	}
+	d.pausedUntil = time.Now().Add(backoff)
+}
+
+func (d *destination) abandon() {
+	d.mu.Lock()
+	d.abandoned++
+	d.mu.Unlock()
+}
+
+func (d *destination) stats() Stats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	attempts := d.delivered + d.failed
+	var avg float64
+	if attempts > 0 {
+		avg = float64(d.totalLatency.Milliseconds()) / float64(attempts)
+	}
+	return Stats{
+		Queued:           len(d.queue),
+		Delivered:        d.delivered,
+		Failed:           d.failed,
+		Abandoned:        d.abandoned,
+		Dropped:          d.dropped,
+		AvgLatencyMillis: avg,
+		BackingOff:       time.Now().Before(d.pausedUntil),
+	}
+}
+
+func normalize(l Limits) Limits {
+	if l.Burst < 1 {
+		l.Burst = 1
+	}
+	if l.MaxConcurrent < 1 {
+		l.MaxConcurrent = 1
+	}
+	if l.MaxBacklog < 1 {
+		l.MaxBacklog = 1
+	}
+	return l
+}
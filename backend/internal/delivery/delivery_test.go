@@ -0,0 +1,122 @@
+package delivery
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitStats polls until cond holds for the destination's stats
+func waitStats(t *testing.T, d *Dispatcher, name string, cond func(Stats) bool) Stats {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stats := d.Stats()[name]
+		if cond(stats) {
+			return stats
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s stats never settled: %+v", name, stats)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestFullBacklogDropsTheOldestJob(t *testing.T) {
+	d := NewDispatcher(Limits{MaxBacklog: 2})
+	defer d.Close()
+
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var ran []int
+	job := func(n int) Job {
+		return func() error {
+			if n == 0 {
+				<-release
+			}
+			mu.Lock()
+			ran = append(ran, n)
+			mu.Unlock()
+			return nil
+		}
+	}
+
+	d.Enqueue("hook", job(0))
+	waitStats(t, d, "hook", func(s Stats) bool { return s.Queued == 0 }) // 0 is running
+	for n := 1; n <= 3; n++ {
+		d.Enqueue("hook", job(n))
+	}
+	close(release)
+
+	stats := waitStats(t, d, "hook", func(s Stats) bool { return s.Delivered == 3 })
+	if stats.Dropped != 1 {
+		t.Errorf("dropped = %d, want 1", stats.Dropped)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ran) != 3 || ran[0] != 0 || ran[1] != 2 || ran[2] != 3 {
+		t.Errorf("ran %v, want [0 2 3]", ran)
+	}
+}
+
+func TestFailedJobIsRetriedAfterBackoff(t *testing.T) {
+	d := NewDispatcher(Limits{MaxBacklog: 4})
+	defer d.Close()
+
+	var attempts []time.Time
+	var mu sync.Mutex
+	d.Enqueue("flaky", func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts = append(attempts, time.Now())
+		if len(attempts) == 1 {
+			return errors.New("503")
+		}
+		return nil
+	})
+
+	// The backoff is per destination: another one isn't held up
+	delivered := make(chan struct{})
+	waitStats(t, d, "flaky", func(s Stats) bool { return s.BackingOff })
+	d.Enqueue("healthy", func() error { close(delivered); return nil })
+	select {
+	case <-delivered:
+	case <-time.After(baseBackoff / 2):
+		t.Fatal("a healthy destination waited out another's backoff")
+	}
+
+	stats := waitStats(t, d, "flaky", func(s Stats) bool { return s.Delivered == 1 })
+	if stats.Failed != 1 || stats.Abandoned != 0 {
+		t.Errorf("stats = %+v, want one failed attempt and nothing abandoned", stats)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if gap := attempts[1].Sub(attempts[0]); gap < baseBackoff {
+		t.Errorf("retried after %s, want at least %s", gap, baseBackoff)
+	}
+}
+
+func TestRateLimitSpacesDeliveries(t *testing.T) {
+	d := NewDispatcher(Limits{RatePerSec: 20, Burst: 1, MaxBacklog: 8})
+	defer d.Close()
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		d.Enqueue("push", func() error { return nil })
+	}
+	waitStats(t, d, "push", func(s Stats) bool { return s.Delivered == 3 })
+	// One from the burst, then a token every 50ms
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("3 deliveries at 20/s with a burst of 1 took %s", elapsed)
+	}
+}
+
+func TestEnqueueAfterClose(t *testing.T) {
+	d := NewDispatcher(Limits{})
+	d.Close()
+	if err := d.Enqueue("hook", func() error { return nil }); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Enqueue after Close = %v, want ErrClosed", err)
+	}
+	d.Close() // idempotent
+}
@@ -0,0 +1,146 @@
+// Package errsink is where the server's tolerated failures go: errors it
+// logs and carries on from, such as a message that couldn't be stored or an
+// event dropped because a client's queue was full. Every swallowed error is
+// counted. In strict mode, meant for development, call sites are also told
+// to report the failure to the affected client, and categories that can
+// only mean a bug panic when StrictPanic is set. A nil *Sink is a valid
+// tolerant sink that counts nothing, so call sites need no checks.
+package errsink
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Category groups swallow sites for counting and for the panic policy
+type Category string
+
+const (
+	// Marshal is a failure to encode one of our own structs, which is
+	// always a bug
+	Marshal Category = "marshal"
+	// Store is a failed database write or lookup on a path that continues
+	Store Category = "store"
+	// FanOut is a failure to deliver an event to a conversation
+	FanOut Category = "fanout"
+	// Dropped is an event discarded because a client's queue was full
+	Dropped Category = "dropped"
+)
+
+// fatalInDev lists the categories that panic in strict mode with
+// StrictPanic set
+var fatalInDev = map[Category]bool{
+	Marshal: true,
+}
+
+// recentLimit is how many diagnostics Snapshot keeps
+const recentLimit = 20
+
+// Options configures a Sink
+type Options struct {
+	// Strict asks call sites to surface swallowed errors to clients
+	Strict bool
+	// StrictPanic panics on fatal-in-dev categories; it needs Strict
+	StrictPanic bool
+}
+
+// Diagnostic describes one swallowed error. Site names the code path, such
+// as "ws.save_message".
+type Diagnostic struct {
+	Category Category  `json:"category"`
+	Site     string    `json:"site"`
+	Error    string    `json:"error"`
+	At       time.Time `json:"at"`
+}
+
+// Fields returns the diagnostic as extra fields for a websocket "error"
+// event
+func (d *Diagnostic) Fields() map[string]interface{} {
+	return map[string]interface{}{
+		"category": d.Category,
+		"site":     d.Site,
+		"error":    d.Error,
+	}
+}
+
+// Sink counts swallowed errors and applies the strict-mode policy
+type Sink struct {
+	opts Options
+
+	mu     sync.Mutex
+	counts map[Category]int64
+	recent []Diagnostic
+}
+
+func New(opts Options) *Sink {
+	return &Sink{
+		opts:   opts,
+		counts: make(map[Category]int64),
+	}
+}
+
+// Strict reports whether swallowed errors should be surfaced
+func (s *Sink) Strict() bool {
+	return s != nil && s.opts.Strict
+}
+
+// Swallow records err at site. In tolerant mode it returns nil and the
+// caller carries on as before; in strict mode it returns a diagnostic the
+// caller should send to the affected client, if there is one. Logging
+// stays with the caller.
+func (s *Sink) Swallow(category Category, site string, err error) *Diagnostic {
+	if s == nil {
+		return nil
+	}
+
+	d := Diagnostic{Category: category, Site: site, At: time.Now().UTC()}
+	if err != nil {
+		d.Error = err.Error()
+	}
+
+	s.mu.Lock()
+	s.counts[category]++
+	s.recent = append(s.recent, d)
+	if len(s.recent) > recentLimit {
+		s.recent = s.recent[len(s.recent)-recentLimit:]
+	}
+	s.mu.Unlock()
+
+	if !s.opts.Strict {
+		return nil
+	}
+	if s.opts.StrictPanic && fatalInDev[category] {
+		panic(fmt.Sprintf("errsink: %s at %s: %s", category, site, d.Error))
+	}
+	return &d
+}
+
+// Snapshot is the state shown on the debug endpoint
+type Snapshot struct {
+	Strict bool               `json:"strict"`
+	Total  int64              `json:"total"`
+	Counts map[Category]int64 `json:"counts"`
+	Recent []Diagnostic       `json:"recent,omitempty"`
+}
+
+// Snapshot returns the counters and the most recent diagnostics, newest
+// last
+func (s *Sink) Snapshot() Snapshot {
+	if s == nil {
+		return Snapshot{Counts: map[Category]int64{}}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap := Snapshot{
+		Strict: s.opts.Strict,
+		Counts: make(map[Category]int64, len(s.counts)),
+		Recent: append([]Diagnostic(nil), s.recent...),
+	}
+	for category, n := range s.counts {
+		snap.Counts[category] = n
+		snap.Total += n
+	}
+	return snap
+}
@@ -0,0 +1,84 @@
+package errsink
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLenientSinkCountsAndCarriesOn(t *testing.T) {
+	s := New(Options{})
+	if d := s.Swallow(Marshal, "hub.send_to_user", errors.New("bad payload")); d != nil {
+		t.Errorf("lenient sink returned a diagnostic: %+v", d)
+	}
+	if d := s.Swallow(Dropped, "hub.send_to_user", errors.New("queue full")); d != nil {
+		t.Errorf("lenient sink returned a diagnostic: %+v", d)
+	}
+
+	snap := s.Snapshot()
+	if snap.Strict || snap.Total != 2 || snap.Counts[Marshal] != 1 || snap.Counts[Dropped] != 1 {
+		t.Errorf("snapshot = %+v, want one marshal and one dropped", snap)
+	}
+	if len(snap.Recent) != 2 || snap.Recent[1].Site != "hub.send_to_user" || snap.Recent[1].Error != "queue full" {
+		t.Errorf("recent = %+v", snap.Recent)
+	}
+}
+
+func TestStrictSinkSurfacesErrors(t *testing.T) {
+	s := New(Options{Strict: true})
+	d := s.Swallow(Dropped, "ws.persist_queue", errors.New("persist queue full"))
+	if d == nil {
+		t.Fatal("strict sink returned no diagnostic")
+	}
+	fields := d.Fields()
+	if fields["category"] != Dropped || fields["site"] != "ws.persist_queue" || fields["error"] != "persist queue full" {
+		t.Errorf("fields = %v", fields)
+	}
+	// Without StrictPanic even a bug is only surfaced
+	if d := s.Swallow(Marshal, "ws.error_event", errors.New("bad payload")); d == nil {
+		t.Error("strict sink returned no diagnostic for a marshal error")
+	}
+	if n := s.Snapshot().Total; n != 2 {
+		t.Errorf("counted %d, want 2", n)
+	}
+}
+
+func TestStrictPanicOnlyForBugs(t *testing.T) {
+	s := New(Options{Strict: true, StrictPanic: true})
+	if d := s.Swallow(Dropped, "hub.broadcast", errors.New("broadcast queue full")); d == nil {
+		t.Error("dropped event returned no diagnostic")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("marshal error didn't panic")
+		}
+		if n := s.Snapshot().Counts[Marshal]; n != 1 {
+			t.Errorf("marshal count = %d, want it counted before the panic", n)
+		}
+	}()
+	s.Swallow(Marshal, "hub.broadcast", errors.New("bad payload"))
+}
+
+func TestNilSinkIsLenient(t *testing.T) {
+	var s *Sink
+	if s.Strict() {
+		t.Error("nil sink is strict")
+	}
+	if d := s.Swallow(Marshal, "hub.broadcast", errors.New("bad payload")); d != nil {
+		t.Errorf("nil sink returned %+v", d)
+	}
+	if snap := s.Snapshot(); snap.Total != 0 || snap.Counts == nil {
+		t.Errorf("nil sink snapshot = %+v", snap)
+	}
+}
+
+func TestRecentIsBounded(t *testing.T) {
+	s := New(Options{})
+	for i := 0; i < recentLimit+5; i++ {
+		s.Swallow(Store, "ws.save_message", nil)
+	}
+	snap := s.Snapshot()
+	if len(snap.Recent) != recentLimit || snap.Counts[Store] != recentLimit+5 {
+		t.Errorf("kept %d diagnostics of %d counted, want %d of %d", len(snap.Recent), snap.Counts[Store], recentLimit, recentLimit+5)
+	}
+}
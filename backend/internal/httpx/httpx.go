@@ -0,0 +1,170 @@
+// Package httpx holds the request and response plumbing shared by the HTTP
+// handlers, so every endpoint checks methods, decodes bodies and writes
+// errors the same way.
+//
+// Errors stay plain text, as they always have been, except validation
+// failures, which are a JSON models.ValidationErrorResponse listing every
+// problem found.
+package httpx
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"messager/internal/models"
+)
+
+// MaxBodyBytes bounds a JSON request body unless a handler asks for less
+const MaxBodyBytes = 1 << 20
+
+// Validator is implemented by request structs with checks that need nothing
+// but the request itself. DecodeJSON runs it after decoding.
+type Validator interface {
+	Validate() []models.FieldError
+}
+
+// Error is a request the handler can't serve, with the status to answer
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// ValidationError lists what a Validator found wrong
+type ValidationError struct {
+	Fields []models.FieldError
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%d invalid fields", len(e.Fields))
+}
+
+// DecodeJSON reads a single JSON value from r's body into v and validates it.
+// A Content-Type other than JSON is refused with 415, though a missing one
+// is accepted; unknown fields, trailing data and bodies over MaxBodyBytes
+// are refused too. Write the result with WriteDecodeError.
+func DecodeJSON(r *http.Request, v interface{}) error {
+	return DecodeJSONLimit(r, v, MaxBodyBytes)
+}
+
+// DecodeJSONLimit is DecodeJSON with a smaller body limit
+func DecodeJSONLimit(r *http.Request, v interface{}, limit int64) error {
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || (mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json")) {
+			return &Error{Status: http.StatusUnsupportedMediaType, Message: "Content-Type must be application/json"}
+		}
+	}
+
+	dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, limit))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return decodeError(err)
+	}
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		return &Error{Status: http.StatusBadRequest, Message: "Request body must be a single JSON value"}
+	}
+
+	if validator, ok := v.(Validator); ok {
+		if fields := validator.Validate(); len(fields) > 0 {
+			return &ValidationError{Fields: fields}
+		}
+	}
+	return nil
+}
+
+func decodeError(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return &Error{Status: http.StatusRequestEntityTooLarge, Message: fmt.Sprintf("Request body must be at most %d bytes", tooLarge.Limit)}
+	}
+	// The decoder reports unknown fields only as text
+	if msg := err.Error(); strings.HasPrefix(msg, "json: unknown field ") {
+		return &ValidationError{Fields: []models.FieldError{{
+			Field:   strings.Trim(strings.TrimPrefix(msg, "json: unknown field "), `"`),
+			Code:    "unknown_field",
+			Message: "Unknown field",
+		}}}
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return &ValidationError{Fields: []models.FieldError{{
+			Field:   typeErr.Field,
+			Code:    "invalid_type",
+			Message: fmt.Sprintf("Must be a %s", jsonKind(typeErr.Type.Kind().String())),
+		}}}
+	}
+	return &Error{Status: http.StatusBadRequest, Message: "Invalid request body"}
+}
+
+// jsonKind names a Go kind the way a JSON client would know it
+func jsonKind(kind string) string {
+	switch {
+	case strings.HasPrefix(kind, "int"), strings.HasPrefix(kind, "uint"), strings.HasPrefix(kind, "float"):
+		return "number"
+	case kind == "bool":
+		return "boolean"
+	case kind == "slice", kind == "array":
+		return "list"
+	case kind == "map", kind == "struct":
+		return "object"
+	}
+	return kind
+}
+
+// WriteDecodeError answers a DecodeJSON failure: 400 with the field errors
+// for a validation failure, otherwise the status the error carries
+func WriteDecodeError(w http.ResponseWriter, err error) {
+	var validation *ValidationError
+	if errors.As(err, &validation) {
+		WriteValidationErrors(w, validation.Fields)
+		return
+	}
+	var reqErr *Error
+	if errors.As(err, &reqErr) {
+		WriteError(w, reqErr.Status, reqErr.Message)
+		return
+	}
+	WriteError(w, http.StatusBadRequest, "Invalid request body")
+}
+
+// WriteValidationErrors answers 400 with every problem found
+func WriteValidationErrors(w http.ResponseWriter, fields []models.FieldError) {
+	WriteJSON(w, http.StatusBadRequest, models.ValidationErrorResponse{
+		Error:  "validation_failed",
+		Errors: fields,
+	})
+}
+
+// WriteJSON sends v as the JSON response body with status
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// WriteError sends a plain-text error
+func WriteError(w http.ResponseWriter, status int, message string) {
+	http.Error(w, message, status)
+}
+
+// AllowMethods answers 405, listing the allowed methods in the Allow
+// header, unless r uses one of them
+func AllowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	WriteError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	return false
+}
@@ -0,0 +1,126 @@
+package httpx
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"messager/internal/models"
+)
+
+type request struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func (r *request) Validate() []models.FieldError {
+	if r.Name == "" {
+		return []models.FieldError{{Field: "name", Code: "required", Message: "Required"}}
+	}
+	return nil
+}
+
+func newRequest(body, contentType string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	return r
+}
+
+func TestDecodeJSON(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		body        string
+		contentType string
+		status      int    // of an *Error, 0 for none
+		field       string // of a *ValidationError
+	}{
+		{"valid", `{"name":"a","count":2}`, "application/json", 0, ""},
+		{"no content type", `{"name":"a"}`, "", 0, ""},
+		{"charset", `{"name":"a"}`, "application/json; charset=utf-8", 0, ""},
+		{"json suffix", `{"name":"a"}`, "application/merge-patch+json", 0, ""},
+		{"form", `name=a`, "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType, ""},
+		{"malformed", `{"name":`, "application/json", http.StatusBadRequest, ""},
+		{"trailing data", `{"name":"a"} {}`, "application/json", http.StatusBadRequest, ""},
+		{"unknown field", `{"name":"a","colour":"red"}`, "application/json", 0, "colour"},
+		{"wrong type", `{"name":"a","count":"two"}`, "application/json", 0, "count"},
+		{"fails validation", `{"count":1}`, "application/json", 0, "name"},
+	} {
+		var req request
+		err := DecodeJSON(newRequest(tc.body, tc.contentType), &req)
+
+		var reqErr *Error
+		var validation *ValidationError
+		switch {
+		case tc.status != 0:
+			if !errors.As(err, &reqErr) || reqErr.Status != tc.status {
+				t.Errorf("%s: got %v, want status %d", tc.name, err, tc.status)
+			}
+		case tc.field != "":
+			if !errors.As(err, &validation) || len(validation.Fields) != 1 || validation.Fields[0].Field != tc.field {
+				t.Errorf("%s: got %v, want a validation error on %s", tc.name, err, tc.field)
+			}
+		default:
+			if err != nil || req.Name != "a" {
+				t.Errorf("%s: got %v, %+v", tc.name, err, req)
+			}
+		}
+	}
+}
+
+func TestDecodeJSONLimit(t *testing.T) {
+	var req request
+	err := DecodeJSONLimit(newRequest(`{"name":"`+strings.Repeat("a", 100)+`"}`, ""), &req, 32)
+	var reqErr *Error
+	if !errors.As(err, &reqErr) || reqErr.Status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("oversized body: got %v, want 413", err)
+	}
+	if !strings.Contains(reqErr.Message, "32 bytes") {
+		t.Errorf("message %q doesn't give the limit", reqErr.Message)
+	}
+}
+
+func TestWriteDecodeError(t *testing.T) {
+	rec := httptest.NewRecorder()
+	WriteDecodeError(rec, &ValidationError{Fields: []models.FieldError{{Field: "name", Code: "required"}}})
+	var resp models.ValidationErrorResponse
+	if rec.Code != http.StatusBadRequest || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("validation error: status %d, content type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error != "validation_failed" || len(resp.Errors) != 1 || resp.Errors[0].Field != "name" {
+		t.Errorf("validation error body = %+v", resp)
+	}
+
+	rec = httptest.NewRecorder()
+	WriteDecodeError(rec, &Error{Status: http.StatusUnsupportedMediaType, Message: "Content-Type must be application/json"})
+	if rec.Code != http.StatusUnsupportedMediaType || !strings.HasPrefix(rec.Body.String(), "Content-Type must be") {
+		t.Errorf("request error: status %d, body %q", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	WriteDecodeError(rec, errors.New("something else"))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("other error: status %d, want 400", rec.Code)
+	}
+}
+
+func TestAllowMethods(t *testing.T) {
+	rec := httptest.NewRecorder()
+	if !AllowMethods(rec, httptest.NewRequest(http.MethodPatch, "/", nil), http.MethodGet, http.MethodPatch) {
+		t.Error("an allowed method was refused")
+	}
+	rec = httptest.NewRecorder()
+	if AllowMethods(rec, httptest.NewRequest(http.MethodDelete, "/", nil), http.MethodGet, http.MethodPatch) {
+		t.Fatal("a disallowed method was accepted")
+	}
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "GET, PATCH" {
+		t.Errorf("status %d, Allow %q", rec.Code, rec.Header().Get("Allow"))
+	}
+}
@@ -0,0 +1,60 @@
+package logsafe
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+)
+
+// Message bodies and client payloads are personal data. Unless the operator
+// opts in with LOG_MESSAGE_CONTENT, Content and Payload replace them with
+// their length and a short keyed hash: enough to tell whether two log lines
+// are about the same text, not enough to recover it.
+
+var logContent atomic.Bool
+
+// contentKey keys the placeholder hash so short, guessable bodies ("ok",
+// "yes") can't be confirmed by hashing candidates. It is fresh per process,
+// so hashes only correlate within one run.
+var contentKey = func() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("logsafe: failed to generate content key: %v", err))
+	}
+	return key
+}()
+
+// SetLogContent chooses whether Content and Payload log the real value
+func SetLogContent(enabled bool) {
+	logContent.Store(enabled)
+}
+
+// Content prepares message content for a log line: escaped as String does
+// when content logging is on, otherwise a placeholder such as
+// "[redacted 42 bytes #1a2b3c4d]"
+func Content(s string) string {
+	if logContent.Load() {
+		return String(s)
+	}
+	return placeholder([]byte(s))
+}
+
+// Payload is Content for structured client payloads, e.g. a decoded
+// websocket frame
+func Payload(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data = []byte(fmt.Sprint(v))
+	}
+	return Content(string(data))
+}
+
+func placeholder(data []byte) string {
+	mac := hmac.New(sha256.New, contentKey)
+	mac.Write(data)
+	return fmt.Sprintf("[redacted %d bytes #%s]", len(data), hex.EncodeToString(mac.Sum(nil)[:4]))
+}
@@ -0,0 +1,78 @@
+// Package logsafe makes untrusted values safe to interpolate into log lines.
+// Usernames, request paths, search terms and errors that echo client input
+// can carry newlines or terminal escapes; written raw they could forge extra
+// log entries or break log parsing. Wrap them with String or Err before they
+// reach a Printf.
+package logsafe
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxLength is the longest value, in bytes, written before truncation
+const MaxLength = 200
+
+// String escapes control characters, invalid UTF-8 and line/paragraph
+// separators Go-style (\n, \x1b, \u2028) and truncates long values. Plain
+// values come back unchanged. Backslashes are doubled so an escaped value
+// can't be mistaken for one that merely contains the escape text.
+func String(s string) string {
+	if len(s) <= MaxLength && isPlain(s) {
+		return s
+	}
+
+	var b strings.Builder
+	truncated := 0
+	for i, r := range s {
+		if b.Len() >= MaxLength {
+			truncated = len(s) - i
+			break
+		}
+		if r == utf8.RuneError {
+			if _, size := utf8.DecodeRuneInString(s[i:]); size == 1 {
+				fmt.Fprintf(&b, `\x%02x`, s[i])
+				continue
+			}
+		}
+		switch {
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r < 0x80 && unicode.IsControl(r):
+			fmt.Fprintf(&b, `\x%02x`, r)
+		case unicode.IsControl(r) || r == '\u2028' || r == '\u2029':
+			fmt.Fprintf(&b, `\u%04x`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if truncated > 0 {
+		fmt.Fprintf(&b, "...(%d more bytes)", truncated)
+	}
+	return b.String()
+}
+
+// Err is String for an error's message; nil renders as "<nil>"
+func Err(err error) string {
+	if err == nil {
+		return "<nil>"
+	}
+	return String(err.Error())
+}
+
+func isPlain(s string) bool {
+	for _, r := range s {
+		if r == '\\' || r == utf8.RuneError || unicode.IsControl(r) || r == '\u2028' || r == '\u2029' {
+			return false
+		}
+	}
+	return true
+}
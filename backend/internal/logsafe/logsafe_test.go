@@ -0,0 +1,63 @@
+package logsafe
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestString(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"alice", "alice"},
+		{"ünïcödé 👋", "ünïcödé 👋"},
+		{"bob\n2024/01/01 admin logged in", `bob\n2024/01/01 admin logged in`},
+		{"a\rb\tc", `a\rb\tc`},
+		{"\x1b[31mred", `\x1b[31mred`},
+		{"line\u2028sep", `line\u2028sep`},
+		{"\u0085next", `\u0085next`},
+		{"bad\xffutf8", `bad\xffutf8`},
+		// A literal backslash-n must not read the same as an escaped newline
+		{`back\slash` + "\n", `back\\slash\n`},
+	} {
+		if got := String(tc.in); got != tc.want {
+			t.Errorf("String(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestStringTruncates(t *testing.T) {
+	got := String(strings.Repeat("x", MaxLength+50))
+	if want := strings.Repeat("x", MaxLength) + "...(50 more bytes)"; got != want {
+		t.Errorf("String of %d bytes = %q", MaxLength+50, got)
+	}
+}
+
+func TestErr(t *testing.T) {
+	if got := Err(nil); got != "<nil>" {
+		t.Errorf("Err(nil) = %q", got)
+	}
+	if got := Err(errors.New("no such user \"x\ny\"")); got != `no such user "x\ny"` {
+		t.Errorf("Err = %q", got)
+	}
+}
+
+func TestContentRedactedUnlessEnabled(t *testing.T) {
+	defer SetLogContent(false)
+
+	SetLogContent(false)
+	a, b := Content("meet at 6"), Content("meet at 6")
+	if strings.Contains(a, "meet") || !strings.HasPrefix(a, "[redacted 9 bytes #") {
+		t.Errorf("Content = %q, want a placeholder", a)
+	}
+	if a != b || a == Content("meet at 7") {
+		t.Error("placeholders should match for equal text and differ otherwise")
+	}
+	if got := Payload(map[string]int{"conversation_id": 1}); !strings.HasPrefix(got, "[redacted ") {
+		t.Errorf("Payload = %q, want a placeholder", got)
+	}
+
+	SetLogContent(true)
+	if got := Content("meet\nat 6"); got != `meet\nat 6` {
+		t.Errorf("Content with logging on = %q, want it escaped", got)
+	}
+}
@@ -0,0 +1,40 @@
+package mail
+
+import (
+	"context"
+	"sync"
+)
+
+// CaptureSender records messages instead of sending them, for tests and
+// local development
+type CaptureSender struct {
+	mu       sync.Mutex
+	messages []Message
+
+	// Err, when set, is returned from every Send and nothing is recorded
+	Err error
+}
+
+func (s *CaptureSender) Send(ctx context.Context, msg Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Err != nil {
+		return s.Err
+	}
+	s.messages = append(s.messages, msg)
+	return nil
+}
+
+// Messages returns the messages sent so far, oldest first
+func (s *CaptureSender) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+// Reset forgets every recorded message
+func (s *CaptureSender) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = nil
+}
@@ -0,0 +1,59 @@
+// Package mail sends transactional email. Callers render a template into
+// the durable outbox with Queue.Enqueue; the queue drains it through a
+// Sender in the background with retries and per-recipient rate limiting,
+// so a slow or failing mail server never delays a request.
+package mail
+
+import (
+	"context"
+	"errors"
+	"log"
+)
+
+// Message is a rendered email
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Sender delivers a rendered message. An error wrapped with Permanent
+// means retrying can't help, e.g. the mailbox doesn't exist.
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks a send failure as not worth retrying
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+// IsPermanent reports whether err was marked with Permanent
+func IsPermanent(err error) bool {
+	var permanent permanentError
+	return errors.As(err, &permanent)
+}
+
+// NopSender drops every message, logging only its recipient and subject.
+// It is used when no mail server is configured.
+type NopSender struct {
+	Logger *log.Logger
+}
+
+func (s NopSender) Send(ctx context.Context, msg Message) error {
+	if s.Logger != nil {
+		s.Logger.Printf("Mail disabled, dropping %q to %s", msg.Subject, msg.To)
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/mail"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"messager/internal/models"
+	"messager/internal/ratelimit"
+)
+
+const (
+	// drainBatch bounds how many due messages one pass picks up
+	drainBatch = 50
+	// sendTimeout bounds a single delivery attempt
+	sendTimeout = 30 * time.Second
+
+	retryBase = 30 * time.Second
+	retryMax  = time.Hour
+)
+
+// Store is the durable outbox the queue drains
+type Store interface {
+	EnqueueMail(ctx context.Context, msg *models.OutboundMail) error
+	DueMail(ctx context.Context, now time.Time, limit int) ([]models.OutboundMail, error)
+	MarkMailSent(ctx context.Context, id int64) error
+	// RetryMail records a failed attempt and when to try again
+	RetryMail(ctx context.Context, id int64, attempts int, next time.Time, lastErr string) error
+	// DeferMail reschedules a message without counting an attempt
+	DeferMail(ctx context.Context, id int64, next time.Time) error
+	FailMail(ctx context.Context, id int64, attempts int, lastErr string) error
+}
+
+// Options tunes the queue
+type Options struct {
+	// MaxAttempts is how many sends are tried before a message is failed
+	MaxAttempts int
+	// Interval is how often the outbox is drained
+	Interval time.Duration
+	// RatePerHour and Burst limit mail to any one recipient; excess mail
+	// waits in the outbox. A non-positive rate disables the limit.
+	RatePerHour float64
+	Burst       int
+}
+
+// Stats counts outcomes since startup
+type Stats struct {
+	Enqueued int64 `json:"enqueued"`
+	Sent     int64 `json:"sent"`
+	Retried  int64 `json:"retried"`
+	Failed   int64 `json:"failed"`
+	Deferred int64 `json:"deferred"` // held back by the recipient rate limit
+}
+
+// Queue renders email into the outbox and drains it through a Sender
+type Queue struct {
+	store    Store
+	sender   Sender
+	renderer *Renderer
+	opts     Options
+	limiter  *ratelimit.Limiter
+	logger   *log.Logger
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+
+	enqueued, sent, retried, failed, deferred atomic.Int64
+}
+
+func NewQueue(store Store, sender Sender, renderer *Renderer, opts Options) *Queue {
+	if opts.MaxAttempts < 1 {
+		opts.MaxAttempts = 1
+	}
+	return &Queue{
+		store:    store,
+		sender:   sender,
+		renderer: renderer,
+		opts:     opts,
+		limiter:  ratelimit.New(opts.RatePerHour/3600, opts.Burst),
+		logger:   log.New(os.Stdout, "[MAIL] ", log.LstdFlags|log.Lshortfile),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Enqueue renders the named template for data and stores it for delivery
+// to the address to
+func (q *Queue) Enqueue(ctx context.Context, to, template string, data interface{}) error {
+	address, err := mail.ParseAddress(to)
+	if err != nil {
+		return fmt.Errorf("invalid recipient: %v", err)
+	}
+
+	msg, err := q.renderer.Render(template, data)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	if err := q.store.EnqueueMail(ctx, &models.OutboundMail{
+		Recipient:     address.Address,
+		Template:      template,
+		Subject:       msg.Subject,
+		TextBody:      msg.Text,
+		HTMLBody:      msg.HTML,
+		Status:        models.MailPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}); err != nil {
+		return err
+	}
+	q.enqueued.Add(1)
+	return nil
+}
+
+// Start drains the outbox every Interval until Close
+func (q *Queue) Start() {
+	go func() {
+		defer close(q.done)
+		ticker := time.NewTicker(q.opts.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-q.stop:
+				return
+			case <-ticker.C:
+				q.Drain(context.Background())
+			}
+		}
+	}()
+}
+
+// Close stops draining and waits for the current pass to finish. Pending
+// mail stays in the outbox for the next start.
+func (q *Queue) Close() {
+	q.stopOnce.Do(func() { close(q.stop) })
+	<-q.done
+}
+
+// Drain makes one delivery attempt for every due message and returns how
+// many were sent
+func (q *Queue) Drain(ctx context.Context) int {
+	due, err := q.store.DueMail(ctx, time.Now().UTC(), drainBatch)
+	if err != nil {
+		q.logger.Printf("Failed to load outbox: %v", err)
+		return 0
+	}
+
+	sent := 0
+	for _, msg := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		if q.deliver(ctx, msg) {
+			sent++
+		}
+	}
+	return sent
+}
+
+func (q *Queue) deliver(ctx context.Context, msg models.OutboundMail) bool {
+	if allowed, wait := q.limiter.Allow(recipientKey(msg.Recipient)); !allowed {
+		q.deferred.Add(1)
+		if err := q.store.DeferMail(ctx, msg.ID, time.Now().UTC().Add(wait)); err != nil {
+			q.logger.Printf("Failed to defer mail %d: %v", msg.ID, err)
+		}
+		return false
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+	err := q.sender.Send(sendCtx, Message{
+		To:      msg.Recipient,
+		Subject: msg.Subject,
+		Text:    msg.TextBody,
+		HTML:    msg.HTMLBody,
+	})
+	cancel()
+
+	if err == nil {
+		q.sent.Add(1)
+		if err := q.store.MarkMailSent(ctx, msg.ID); err != nil {
+			// It went out; at worst it is sent again on the next pass
+			q.logger.Printf("Failed to mark mail %d sent: %v", msg.ID, err)
+		}
+		return true
+	}
+
+	attempts := msg.Attempts + 1
+	if IsPermanent(err) || attempts >= q.opts.MaxAttempts {
+		q.failed.Add(1)
+		q.logger.Printf("Giving up on mail %d (%s) after %d attempts: %v", msg.ID, msg.Template, attempts, err)
+		if err := q.store.FailMail(ctx, msg.ID, attempts, err.Error()); err != nil {
+			q.logger.Printf("Failed to mark mail %d failed: %v", msg.ID, err)
+		}
+		return false
+	}
+
+	q.retried.Add(1)
+	next := time.Now().UTC().Add(retryDelay(attempts))
+	q.logger.Printf("Mail %d (%s) failed, retrying at %s: %v", msg.ID, msg.Template, next.Format(time.RFC3339), err)
+	if err := q.store.RetryMail(ctx, msg.ID, attempts, next, err.Error()); err != nil {
+		q.logger.Printf("Failed to reschedule mail %d: %v", msg.ID, err)
+	}
+	return false
+}
+
+// Stats returns the outcome counters
+func (q *Queue) Stats() Stats {
+	return Stats{
+		Enqueued: q.enqueued.Load(),
+		Sent:     q.sent.Load(),
+		Retried:  q.retried.Load(),
+		Failed:   q.failed.Load(),
+		Deferred: q.deferred.Load(),
+	}
+}
+
+// retryDelay doubles from retryBase with each attempt, up to retryMax
+func retryDelay(attempts int) time.Duration {
+	delay := retryBase
+	for i := 1; i < attempts && delay < retryMax; i++ {
+		delay *= 2
+	}
+	if delay > retryMax {
+		delay = retryMax
+	}
+	return delay
+}
+
+// recipientKey maps an address onto the rate limiter's integer keys
+func recipientKey(address string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(strings.ToLower(address)))
+	return int64(h.Sum64())
+}
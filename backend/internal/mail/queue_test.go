@@ -0,0 +1,146 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"messager/internal/db"
+	"messager/internal/db/testdb"
+	"messager/internal/models"
+)
+
+func newTestQueue(t *testing.T, opts Options) (*Queue, *db.DB, *CaptureSender) {
+	t.Helper()
+	renderer, err := NewRenderer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := testdb.Open(t)
+	sender := &CaptureSender{}
+	return NewQueue(d, sender, renderer, opts), d, sender
+}
+
+func enqueueVerification(t *testing.T, q *Queue, to string) {
+	t.Helper()
+	err := q.Enqueue(context.Background(), to, TemplateVerifyEmail, map[string]string{
+		"Username": "alice", "Email": to, "Link": "https://chat.example.com/verify?token=abc", "ExpiresIn": "24 hours",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// outboxRow returns the state of the only message in the outbox
+func outboxRow(t *testing.T, d *db.DB) (status string, attempts int, next time.Time) {
+	t.Helper()
+	if err := d.QueryRow(`SELECT status, attempts, next_attempt_at FROM mail_outbox`).Scan(&status, &attempts, &next); err != nil {
+		t.Fatal(err)
+	}
+	return status, attempts, next
+}
+
+func TestDrainSendsRenderedMail(t *testing.T) {
+	q, d, sender := newTestQueue(t, Options{MaxAttempts: 3})
+	if err := q.Enqueue(context.Background(), "not an address", TemplateVerifyEmail, nil); err == nil {
+		t.Error("enqueued mail to an invalid address")
+	}
+	enqueueVerification(t, q, "Alice <alice@example.com>")
+
+	if sent := q.Drain(context.Background()); sent != 1 {
+		t.Fatalf("drain sent %d, want 1", sent)
+	}
+	messages := sender.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("sent %d messages", len(messages))
+	}
+	msg := messages[0]
+	if msg.To != "alice@example.com" || msg.Subject == "" {
+		t.Errorf("sent to %q with subject %q", msg.To, msg.Subject)
+	}
+	for name, body := range map[string]string{"text": msg.Text, "html": msg.HTML} {
+		if !strings.Contains(body, "https://chat.example.com/verify?token=abc") {
+			t.Errorf("%s body has no link:\n%s", name, body)
+		}
+	}
+	if status, _, _ := outboxRow(t, d); status != models.MailSent {
+		t.Errorf("status = %s, want sent", status)
+	}
+	if q.Drain(context.Background()) != 0 || len(sender.Messages()) != 1 {
+		t.Error("sent mail was sent again")
+	}
+	if stats := q.Stats(); stats.Enqueued != 1 || stats.Sent != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestDrainRetriesThenFails(t *testing.T) {
+	q, d, sender := newTestQueue(t, Options{MaxAttempts: 2})
+	sender.Err = errors.New("connection refused")
+	enqueueVerification(t, q, "alice@example.com")
+
+	before := time.Now().UTC()
+	q.Drain(context.Background())
+	status, attempts, next := outboxRow(t, d)
+	if status != models.MailPending || attempts != 1 || next.Before(before.Add(retryBase)) {
+		t.Fatalf("after one failure: %s, %d attempts, next at %s", status, attempts, next)
+	}
+	// Not due again yet
+	q.Drain(context.Background())
+	if _, attempts, _ = outboxRow(t, d); attempts != 1 {
+		t.Fatalf("retried early: %d attempts", attempts)
+	}
+
+	d.Exec(`UPDATE mail_outbox SET next_attempt_at = ?`, before)
+	q.Drain(context.Background())
+	if status, attempts, _ = outboxRow(t, d); status != models.MailFailed || attempts != 2 {
+		t.Errorf("after the last attempt: %s, %d attempts, want failed after 2", status, attempts)
+	}
+	if stats := q.Stats(); stats.Retried != 1 || stats.Failed != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestPermanentFailureIsNotRetried(t *testing.T) {
+	q, d, sender := newTestQueue(t, Options{MaxAttempts: 5})
+	sender.Err = Permanent(errors.New("550 no such mailbox"))
+	enqueueVerification(t, q, "nobody@example.com")
+
+	q.Drain(context.Background())
+	if status, attempts, _ := outboxRow(t, d); status != models.MailFailed || attempts != 1 {
+		t.Errorf("%s after %d attempts, want failed after 1", status, attempts)
+	}
+}
+
+func TestRecipientRateLimitDefersMail(t *testing.T) {
+	q, d, sender := newTestQueue(t, Options{MaxAttempts: 3, RatePerHour: 1, Burst: 1})
+	enqueueVerification(t, q, "alice@example.com")
+	enqueueVerification(t, q, "ALICE@example.com")
+	enqueueVerification(t, q, "bob@example.com")
+
+	if sent := q.Drain(context.Background()); sent != 2 {
+		t.Fatalf("drain sent %d, want one to each recipient", sent)
+	}
+	var recipient string
+	var attempts int
+	var next time.Time
+	if err := d.QueryRow(`SELECT recipient, attempts, next_attempt_at FROM mail_outbox WHERE status = ?`, models.MailPending).Scan(&recipient, &attempts, &next); err != nil {
+		t.Fatal(err)
+	}
+	if recipient != "ALICE@example.com" || attempts != 0 || !next.After(time.Now().Add(time.Minute)) {
+		t.Errorf("%s deferred until %s after %d attempts, want alice's second held back without using an attempt", recipient, next, attempts)
+	}
+	if q.Stats().Deferred != 1 || len(sender.Messages()) != 2 {
+		t.Errorf("stats = %+v", q.Stats())
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{1: retryBase, 2: 2 * retryBase, 3: 4 * retryBase, 20: retryMax} {
+		if got := retryDelay(attempts); got != want {
+			t.Errorf("retryDelay(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+}
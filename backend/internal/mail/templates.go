@@ -0,0 +1,90 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Each email is a pair of templates/<name>.txt and templates/<name>.html,
+// each defining "content" to be wrapped by the matching base layout. The
+// .txt file also defines the "subject".
+//
+//go:embed templates
+var templateFS embed.FS
+
+// Template names
+const (
+	TemplateVerifyEmail = "verify_email"
+)
+
+// Renderer renders named emails into text and HTML bodies
+type Renderer struct {
+	text map[string]*texttemplate.Template
+	html map[string]*htmltemplate.Template
+}
+
+// NewRenderer parses every embedded template, so a broken one fails at
+// startup rather than on first send
+func NewRenderer() (*Renderer, error) {
+	r := &Renderer{
+		text: make(map[string]*texttemplate.Template),
+		html: make(map[string]*htmltemplate.Template),
+	}
+
+	names, err := fs.Glob(templateFS, "templates/*.txt")
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range names {
+		name := strings.TrimSuffix(strings.TrimPrefix(path, "templates/"), ".txt")
+		if name == "layout" {
+			continue
+		}
+
+		text, err := texttemplate.ParseFS(templateFS, "templates/layout.txt", path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+		}
+		if text.Lookup("subject") == nil {
+			return nil, fmt.Errorf("%s doesn't define a subject", path)
+		}
+		html, err := htmltemplate.ParseFS(templateFS, "templates/layout.html", "templates/"+name+".html")
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s.html: %v", name, err)
+		}
+		r.text[name] = text
+		r.html[name] = html
+	}
+	return r, nil
+}
+
+// Render produces the subject and bodies of the named email for data. The
+// recipient is left to the caller.
+func (r *Renderer) Render(name string, data interface{}) (Message, error) {
+	text, ok := r.text[name]
+	if !ok {
+		return Message{}, fmt.Errorf("unknown email template %q", name)
+	}
+
+	var subject, textBody, htmlBody bytes.Buffer
+	if err := text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Message{}, fmt.Errorf("failed to render %s subject: %v", name, err)
+	}
+	if err := text.ExecuteTemplate(&textBody, "layout", data); err != nil {
+		return Message{}, fmt.Errorf("failed to render %s text: %v", name, err)
+	}
+	if err := r.html[name].ExecuteTemplate(&htmlBody, "layout", data); err != nil {
+		return Message{}, fmt.Errorf("failed to render %s HTML: %v", name, err)
+	}
+
+	return Message{
+		Subject: strings.Join(strings.Fields(subject.String()), " "),
+		Text:    strings.TrimSpace(textBody.String()) + "\n",
+		HTML:    htmlBody.String(),
+	}, nil
+}
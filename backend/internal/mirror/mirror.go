@@ -0,0 +1,113 @@
+// Package mirror republishes messages from opted-in conversations to an
+// external broker so self-hosters can drive automations from chat traffic.
+// Publishing is asynchronous and lossy by design: a slow or unreachable
+// broker never delays or fails a message save.
+package mirror
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"messager/internal/delivery"
+)
+
+// previewLength caps the message content carried in mirrored events
+const previewLength = 140
+
+// destination names the broker in the delivery dispatcher's stats
+const destination = "mirror"
+
+// Publisher delivers a payload to a subject on an external broker
+type Publisher interface {
+	Publish(subject string, data []byte) error
+	Close() error
+}
+
+// Event is the compact JSON document published for each mirrored message
+type Event struct {
+	ConversationID int64     `json:"conversation_id"`
+	MessageID      int64     `json:"message_id"`
+	SenderID       int64     `json:"sender_id"`
+	Sender         string    `json:"sender"`
+	Preview        string    `json:"preview"`
+	Timestamp      time.Time `json:"timestamp"`
+}
+
+type Mirror struct {
+	pub          Publisher
+	topicPattern string
+	dispatcher   *delivery.Dispatcher
+	logger       *log.Logger
+
+	published atomic.Int64
+}
+
+// New creates a mirror publishing to topicPattern, where
+// "{conversation_id}" is replaced per event. Publishes go through the
+// delivery dispatcher with the given limits; concurrency is forced to one
+// so events reach the broker in order.
+func New(pub Publisher, topicPattern string, limits delivery.Limits) *Mirror {
+	limits.MaxConcurrent = 1
+	dispatcher := delivery.NewDispatcher(limits)
+
+	return &Mirror{
+		pub:          pub,
+		topicPattern: topicPattern,
+		dispatcher:   dispatcher,
+		logger:       log.New(os.Stdout, "[MIRROR] ", log.LstdFlags|log.Lshortfile),
+	}
+}
+
+// Enqueue schedules an event for publishing. It never blocks; when the
+// backlog is full the oldest pending event is dropped and counted.
+func (m *Mirror) Enqueue(ev Event) {
+	ev.Preview = truncate(ev.Preview, previewLength)
+
+	data, err := json.Marshal(ev)
+	if err != nil {
+		m.logger.Printf("Failed to marshal event: %v", err)
+		return
+	}
+
+	subject := strings.ReplaceAll(m.topicPattern, "{conversation_id}", strconv.FormatInt(ev.ConversationID, 10))
+	m.dispatcher.Enqueue(destination, func() error {
+		if err := m.pub.Publish(subject, data); err != nil {
+			m.logger.Printf("Failed to publish to %s: %v", subject, err)
+			return err
+		}
+		m.published.Add(1)
+		return nil
+	})
+}
+
+// Stats returns how many events were published and how many were lost,
+// either evicted from a full backlog or failing every retry
+func (m *Mirror) Stats() (published, dropped int64) {
+	stats := m.dispatcher.Stats()[destination]
+	return m.published.Load(), stats.Dropped + stats.Abandoned
+}
+
+// DeliveryStats exposes the dispatcher counters for the admin endpoint
+func (m *Mirror) DeliveryStats() map[string]delivery.Stats {
+	return m.dispatcher.Stats()
+}
+
+// Close stops publishing and closes the publisher. Queued events are discarded.
+func (m *Mirror) Close() error {
+	m.dispatcher.Close()
+	return m.pub.Close()
+}
+
+func truncate(s string, max int) string {
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:max]) + "…"
+}
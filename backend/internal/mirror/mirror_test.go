@@ -0,0 +1,115 @@
+package mirror
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"messager/internal/delivery"
+)
+
+type published struct {
+	subject string
+	data    []byte
+}
+
+// fakePublisher records publishes on a channel
+type fakePublisher struct {
+	out    chan published
+	mu     sync.Mutex
+	closed bool
+}
+
+func newFakePublisher() *fakePublisher {
+	return &fakePublisher{out: make(chan published, 16)}
+}
+
+func (p *fakePublisher) Publish(subject string, data []byte) error {
+	p.out <- published{subject: subject, data: data}
+	return nil
+}
+
+func (p *fakePublisher) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *fakePublisher) next(t *testing.T) published {
+	t.Helper()
+	select {
+	case msg := <-p.out:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing was published")
+		return published{}
+	}
+}
+
+func TestEnqueuePublishesEventToConversationSubject(t *testing.T) {
+	pub := newFakePublisher()
+	m := New(pub, "chat.{conversation_id}.messages", delivery.Limits{})
+	defer m.Close()
+
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	m.Enqueue(Event{ConversationID: 7, MessageID: 42, SenderID: 3, Sender: "alice", Preview: strings.Repeat("é", 200), Timestamp: at})
+
+	msg := pub.next(t)
+	if msg.subject != "chat.7.messages" {
+		t.Errorf("subject = %q, want chat.7.messages", msg.subject)
+	}
+	var ev Event
+	if err := json.Unmarshal(msg.data, &ev); err != nil {
+		t.Fatal(err)
+	}
+	if ev.MessageID != 42 || ev.Sender != "alice" || !ev.Timestamp.Equal(at) {
+		t.Errorf("event = %+v", ev)
+	}
+	if want := strings.Repeat("é", previewLength) + "…"; ev.Preview != want {
+		t.Errorf("preview is %d runes, want %d and an ellipsis", len([]rune(ev.Preview)), previewLength)
+	}
+
+	// The counter is bumped after Publish returns
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if sent, dropped := m.Stats(); sent == 1 && dropped == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			sent, dropped := m.Stats()
+			t.Fatalf("stats = %d published, %d dropped, want 1 and 0", sent, dropped)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestEnqueueKeepsOrder(t *testing.T) {
+	pub := newFakePublisher()
+	m := New(pub, "chat", delivery.Limits{MaxConcurrent: 8, MaxBacklog: 16})
+	defer m.Close()
+
+	for i := int64(1); i <= 5; i++ {
+		m.Enqueue(Event{ConversationID: 1, MessageID: i})
+	}
+	for i := int64(1); i <= 5; i++ {
+		var ev Event
+		json.Unmarshal(pub.next(t).data, &ev)
+		if ev.MessageID != i {
+			t.Fatalf("event %d published as number %d", ev.MessageID, i)
+		}
+	}
+}
+
+func TestCloseClosesThePublisher(t *testing.T) {
+	pub := newFakePublisher()
+	m := New(pub, "chat", delivery.Limits{})
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !pub.closed {
+		t.Error("publisher left open")
+	}
+}
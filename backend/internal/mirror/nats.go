@@ -0,0 +1,159 @@
+package mirror
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	natsDialTimeout  = 5 * time.Second
+	natsWriteTimeout = 5 * time.Second
+	natsMaxBackoff   = 30 * time.Second
+)
+
+// NATSPublisher speaks just enough of the NATS client protocol to publish:
+// CONNECT, PUB and answering server PINGs. MQTT consumers can subscribe
+// through the NATS server's built-in MQTT listener.
+type NATSPublisher struct {
+	addr string
+
+	mu        sync.Mutex
+	conn      net.Conn
+	backoff   time.Duration
+	nextRetry time.Time
+}
+
+// NewNATSPublisher creates a publisher for a nats://host:port URL. The
+// connection is established lazily and re-established with exponential
+// backoff after failures.
+func NewNATSPublisher(rawURL string) (*NATSPublisher, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid NATS URL: %v", err)
+	}
+	if u.Scheme != "nats" {
+		return nil, fmt.Errorf("unsupported NATS URL scheme %q", u.Scheme)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	return &NATSPublisher{addr: addr}, nil
+}
+
+func (p *NATSPublisher) Publish(subject string, data []byte) error {
+	if strings.ContainsAny(subject, " \t\r\n") {
+		return fmt.Errorf("invalid subject %q", subject)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.connectLocked(); err != nil {
+		return err
+	}
+
+	p.conn.SetWriteDeadline(time.Now().Add(natsWriteTimeout))
+	frame := fmt.Sprintf("PUB %s %d\r\n%s\r\n", subject, len(data), data)
+	if _, err := p.conn.Write([]byte(frame)); err != nil {
+		p.failLocked()
+		return fmt.Errorf("publish failed: %v", err)
+	}
+	return nil
+}
+
+func (p *NATSPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close()
+	p.conn = nil
+	return err
+}
+
+func (p *NATSPublisher) connectLocked() error {
+	if p.conn != nil {
+		return nil
+	}
+	if time.Now().Before(p.nextRetry) {
+		return fmt.Errorf("not connected, retrying in %s", time.Until(p.nextRetry).Round(time.Second))
+	}
+
+	conn, err := net.DialTimeout("tcp", p.addr, natsDialTimeout)
+	if err != nil {
+		p.failLocked()
+		return fmt.Errorf("connect failed: %v", err)
+	}
+
+	// The server greets with INFO before accepting CONNECT
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(natsDialTimeout))
+	line, err := reader.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "INFO") {
+		conn.Close()
+		p.failLocked()
+		return fmt.Errorf("unexpected greeting from NATS server: %q", strings.TrimSpace(line))
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	conn.SetWriteDeadline(time.Now().Add(natsWriteTimeout))
+	if _, err := conn.Write([]byte(`CONNECT {"verbose":false,"pedantic":false,"name":"messager-mirror"}` + "\r\n")); err != nil {
+		conn.Close()
+		p.failLocked()
+		return fmt.Errorf("handshake failed: %v", err)
+	}
+
+	p.conn = conn
+	p.backoff = 0
+	go p.readLoop(conn, reader)
+	return nil
+}
+
+// readLoop answers keepalive PINGs so the server doesn't drop us as stale,
+// and notices when the connection goes away
+func (p *NATSPublisher) readLoop(conn net.Conn, reader *bufio.Reader) {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			p.mu.Lock()
+			if p.conn == conn {
+				p.failLocked()
+			}
+			p.mu.Unlock()
+			return
+		}
+
+		if strings.HasPrefix(line, "PING") {
+			p.mu.Lock()
+			if p.conn == conn {
+				conn.SetWriteDeadline(time.Now().Add(natsWriteTimeout))
+				conn.Write([]byte("PONG\r\n"))
+			}
+			p.mu.Unlock()
+		}
+	}
+}
+
+// failLocked drops the connection and schedules the next reconnect attempt
+func (p *NATSPublisher) failLocked() {
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+	if p.backoff == 0 {
+		p.backoff = time.Second
+	} else if p.backoff < natsMaxBackoff {
+		p.backoff *= 2
+		if p.backoff > natsMaxBackoff {
+			p.backoff = natsMaxBackoff
+		}
+	}
+	p.nextRetry = time.Now().Add(p.backoff)
+}
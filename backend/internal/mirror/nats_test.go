@@ -0,0 +1,128 @@
+package mirror
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeNATS accepts connections and hands each one's lines to lines
+func fakeNATS(t *testing.T) (addr string, lines chan string, conns chan net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	lines = make(chan string, 32)
+	conns = make(chan net.Conn, 4)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+			conn.Write([]byte("INFO {\"server_id\":\"test\"}\r\n"))
+			go func() {
+				reader := bufio.NewReader(conn)
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					lines <- strings.TrimRight(line, "\r\n")
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String(), lines, conns
+}
+
+func nextLine(t *testing.T, lines chan string) string {
+	t.Helper()
+	select {
+	case line := <-lines:
+		return line
+	case <-time.After(5 * time.Second):
+		t.Fatal("server received nothing")
+		return ""
+	}
+}
+
+func TestNATSPublisherHandshakeAndPublish(t *testing.T) {
+	addr, lines, _ := fakeNATS(t)
+	p, err := NewNATSPublisher("nats://" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	if err := p.Publish("chat.1", []byte(`{"a":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if line := nextLine(t, lines); !strings.HasPrefix(line, "CONNECT {") {
+		t.Fatalf("first line %q, want CONNECT", line)
+	}
+	if line := nextLine(t, lines); line != "PUB chat.1 7" {
+		t.Fatalf("got %q, want the PUB header", line)
+	}
+	if line := nextLine(t, lines); line != `{"a":1}` {
+		t.Fatalf("got payload %q", line)
+	}
+}
+
+func TestNATSPublisherBacksOffAfterTheServerGoesAway(t *testing.T) {
+	addr, lines, conns := fakeNATS(t)
+	p, err := NewNATSPublisher("nats://" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	if err := p.Publish("chat.1", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	nextLine(t, lines) // CONNECT
+	(<-conns).Close()
+
+	// The read loop notices and schedules a reconnect a second out
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		p.mu.Lock()
+		down := p.conn == nil
+		p.mu.Unlock()
+		if down {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("publisher never noticed the connection closed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	err = p.Publish("chat.1", []byte("y"))
+	if err == nil || !strings.Contains(err.Error(), "retrying in") {
+		t.Fatalf("publish during backoff: %v, want a retry error", err)
+	}
+	if p.backoff != time.Second {
+		t.Errorf("backoff = %s, want 1s after the first failure", p.backoff)
+	}
+}
+
+func TestNewNATSPublisher(t *testing.T) {
+	p, err := NewNATSPublisher("nats://broker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.addr != "broker:4222" {
+		t.Errorf("addr = %q, want the default port", p.addr)
+	}
+	if _, err := NewNATSPublisher("mqtt://broker"); err == nil {
+		t.Error("accepted a non-NATS URL")
+	}
+	if err := p.Publish("bad subject", nil); err == nil {
+		t.Error("published to a subject with a space")
+	}
+}
@@ -1,20 +1,224 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
 
 type User struct {
 	ID        int64     `json:"id" db:"id"`
 	Username  string    `json:"username" db:"username"`
 	Password  string    `json:"-" db:"password"`
 	Avatar    string    `json:"avatar" db:"avatar"`
+	IsBot     bool      `json:"is_bot,omitempty" db:"is_bot"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
+
+	// LastSeenAt is when the user was last connected, nil if never. Only
+	// user lookups fill it in.
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty" db:"last_seen_at"`
+
+	// DeletedAt is set for a deleted account, which lookups by ID return
+	// as a stub named DeletedUsername
+	DeletedAt *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
 }
 
+// DeletedUsername stands in for the name of a deleted account
+const DeletedUsername = "deleted user"
+
 type Conversation struct {
 	ID        int64     `json:"id" db:"id"`
 	Name      string    `json:"name" db:"name"`
 	Type      string    `json:"type" db:"type"` // "direct" or "group"
+	Topic     string    `json:"topic,omitempty" db:"topic"`
+	Avatar    string    `json:"avatar,omitempty"` // other participant's avatar, direct conversations only
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
+
+	// RetentionDays is how long messages are kept; nil follows the server
+	// default
+	RetentionDays *int `json:"retention_days,omitempty" db:"retention_days"`
+
+	// Pinned is true when the viewer pinned the conversation to the top of
+	// their list
+	Pinned bool `json:"pinned"`
+
+	// Settings is the viewer's own per-conversation notification settings
+	// (label, sound, color, plus client-defined keys); only set in
+	// viewer-specific payloads
+	Settings json.RawMessage `json:"settings,omitempty"`
+
+	// Participants is a preview of the members (at most
+	// MaxEmbeddedParticipants) and TotalParticipants the full count; only
+	// set in viewer-specific payloads
+	Participants      []ParticipantSummary `json:"participants,omitempty"`
+	TotalParticipants int                  `json:"total_participants,omitempty"`
+
+	// LastMessage previews the most recent message; nil for an empty
+	// conversation. Only set in viewer-specific payloads.
+	LastMessage *MessagePreview `json:"last_message,omitempty"`
+
+	// Typing lists who is typing right now; only set in the single
+	// conversation response
+	Typing []TypingUser `json:"typing,omitempty"`
+
+	// Membership is the viewer's own participation; only set in
+	// viewer-specific payloads
+	Membership *Membership `json:"membership,omitempty"`
+}
+
+// Membership describes the viewer's participation in a conversation.
+// LastReadMessageID is 0 until the viewer has read anything. UnreadCount
+// is how many messages others sent after it, excluding system messages
+// and anything from before the viewer joined.
+type Membership struct {
+	JoinedAt          time.Time  `json:"joined_at"`
+	LastReadMessageID int64      `json:"last_read_message_id"`
+	LastReadAt        *time.Time `json:"last_read_at,omitempty"`
+	Role              string     `json:"role"`
+	PinnedAt          *time.Time `json:"pinned_at,omitempty"`
+	CustomName        string     `json:"custom_name,omitempty"`
+	UnreadCount       int        `json:"unread_count"`
+	MuteState
+}
+
+// MuteForever is the muted_until stored for a mute without an end
+var MuteForever = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+
+// MuteState is a member's mute of one conversation. MutedUntil is nil when
+// not muted; an expired mute needs no cleanup, it simply stops being Active.
+type MuteState struct {
+	MutedUntil   *time.Time `json:"muted_until,omitempty"`
+	MuteMentions bool       `json:"mute_mentions,omitempty"`
+}
+
+// Active reports whether the mute is in force at now
+func (m MuteState) Active(now time.Time) bool {
+	return m.MutedUntil != nil && now.Before(*m.MutedUntil)
+}
+
+// Silences reports whether an event should be delivered quietly and kept
+// out of push notifications. Mentions break through unless MuteMentions is
+// set.
+func (m MuteState) Silences(now time.Time, mentioned bool) bool {
+	return m.Active(now) && (!mentioned || m.MuteMentions)
+}
+
+// MuteRequest mutes a conversation for Duration, a Go duration such as
+// "8h" or "forever"
+type MuteRequest struct {
+	ConversationID int64  `json:"conversation_id"`
+	Duration       string `json:"duration"`
+	MuteMentions   bool   `json:"mute_mentions"`
+}
+
+// MuteResponse is the caller's mute state after a change
+type MuteResponse struct {
+	ConversationID int64 `json:"conversation_id"`
+	MuteState
+}
+
+// CustomNameRequest sets the name the caller sees for a conversation. A
+// null or empty CustomName clears it.
+type CustomNameRequest struct {
+	ConversationID int64   `json:"conversation_id"`
+	CustomName     *string `json:"custom_name"`
+}
+
+// PinRequest pins or unpins a conversation for the caller
+type PinRequest struct {
+	ConversationID int64 `json:"conversation_id"`
+}
+
+// PinResponse is the caller's pin state after a change
+type PinResponse struct {
+	ConversationID int64      `json:"conversation_id"`
+	Pinned         bool       `json:"pinned"`
+	PinnedAt       *time.Time `json:"pinned_at,omitempty"`
+}
+
+// MentionsUser reports whether content contains @username as a whole word,
+// ignoring case
+func MentionsUser(content, username string) bool {
+	if username == "" {
+		return false
+	}
+	for i := 0; i+len(username) < len(content); i++ {
+		if content[i] != '@' || (i > 0 && isWordByte(content[i-1])) {
+			continue
+		}
+		end := i + 1 + len(username)
+		if end > len(content) || !strings.EqualFold(content[i+1:end], username) {
+			continue
+		}
+		if end == len(content) || !isWordByte(content[end]) {
+			return true
+		}
+	}
+	return false
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
+
+// MaxPreviewLength is how many characters of content a MessagePreview keeps
+const MaxPreviewLength = 120
+
+// TypingUser is a participant seen typing in the last few seconds.
+// ExpiresAt is when they stop counting without another typing event.
+type TypingUser struct {
+	UserID       int64     `json:"user_id"`
+	LastTypingAt time.Time `json:"last_typing_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// MessagePreview is a shortened message for conversation lists. Content is
+// empty for deleted messages and holds the human-readable text for system
+// messages.
+type MessagePreview struct {
+	ID             int64     `json:"id"`
+	SenderID       int64     `json:"sender_id"` // 0 for system messages
+	SenderUsername string    `json:"sender_username,omitempty"`
+	Content        string    `json:"content"`
+	MessageType    string    `json:"message_type"`
+	Truncated      bool      `json:"truncated,omitempty"`
+	Deleted        bool      `json:"deleted,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// MaxEmbeddedParticipants caps the participants embedded in each
+// conversation of the list response
+const MaxEmbeddedParticipants = 25
+
+// ParticipantSummary is the public part of a user shown alongside a
+// conversation
+type ParticipantSummary struct {
+	ID         int64      `json:"id"`
+	Username   string     `json:"username"`
+	Avatar     string     `json:"avatar"`
+	Role       string     `json:"role"`
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty"`
+}
+
+// Participant roles. Every group has exactly one owner; direct
+// conversations only have members.
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+// RoleRank orders roles by authority; unknown roles rank below member
+func RoleRank(role string) int {
+	switch role {
+	case RoleOwner:
+		return 3
+	case RoleAdmin:
+		return 2
+	case RoleMember:
+		return 1
+	}
+	return 0
 }
 
 type ConversationParticipant struct {
@@ -23,12 +227,186 @@ type ConversationParticipant struct {
 	JoinedAt       time.Time `json:"joined_at" db:"joined_at"`
 }
 
+const (
+	MessageTypeUser   = "user"
+	MessageTypeSystem = "system"
+)
+
 type Message struct {
-	ID             int64     `json:"id" db:"id"`
-	ConversationID int64     `json:"conversation_id" db:"conversation_id"`
-	SenderID       int64     `json:"sender_id" db:"sender_id"`
-	Content        string    `json:"content" db:"content"`
-	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+	ID             int64      `json:"id" db:"id"`
+	ConversationID int64      `json:"conversation_id" db:"conversation_id"`
+	SenderID       int64      `json:"sender_id" db:"sender_id"` // 0 for system messages
+	Seq            int64      `json:"seq" db:"seq"`             // dense, per-conversation ordering
+	Content        string     `json:"content" db:"content"`
+	MessageType    string     `json:"message_type" db:"message_type"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	DeletedAt      *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+}
+
+// IsSystem reports whether the message was generated by the server. System
+// messages cannot be edited, deleted or reacted to.
+func (m *Message) IsSystem() bool {
+	return m.MessageType == MessageTypeSystem
+}
+
+// ConversationSettings is one user's notification settings for a
+// conversation, used both as the PUT body and the response
+type ConversationSettings struct {
+	ConversationID int64           `json:"conversation_id"`
+	Settings       json.RawMessage `json:"settings"`
+}
+
+type AddParticipantsRequest struct {
+	ConversationID int64   `json:"conversation_id"`
+	UserIDs        []int64 `json:"user_ids"`
+}
+
+// RemoveParticipantRequest removes UserID from a conversation; omit it (or
+// pass your own ID) to leave
+type RemoveParticipantRequest struct {
+	ConversationID int64 `json:"conversation_id"`
+	UserID         int64 `json:"user_id"`
+}
+
+// DeleteConversationRequest deletes a conversation for every participant
+type DeleteConversationRequest struct {
+	ConversationID int64 `json:"conversation_id"`
+}
+
+// MarkReadRequest advances the caller's read marker in a conversation
+type MarkReadRequest struct {
+	ConversationID int64 `json:"conversation_id"`
+	MessageID      int64 `json:"message_id"`
+}
+
+// Notification is an alert for one user that isn't itself a conversation
+// message, such as a mention or being added to a group. It is stored so
+// users who were offline see it later, and pushed as a "notification" event.
+type Notification struct {
+	ID        int64                  `json:"id"`
+	UserID    int64                  `json:"-"`
+	Category  string                 `json:"category"`
+	Title     string                 `json:"title"`
+	Body      string                 `json:"body,omitempty"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+	CreatedAt time.Time              `json:"created_at"`
+	ReadAt    *time.Time             `json:"read_at,omitempty"`
+}
+
+// Notification categories
+const (
+	NotificationMention      = "mention"
+	NotificationAddedToGroup = "added_to_group"
+	NotificationAnnouncement = "announcement"
+)
+
+// AnnouncementRequest is an admin's message to everyone, sent to connected
+// clients as a "system" event. NotifyOffline also stores it as a
+// notification for users who aren't connected. Clients may hide it after
+// ExpiresAt.
+type AnnouncementRequest struct {
+	Message       string     `json:"message"`
+	Severity      string     `json:"severity"`
+	ExpiresAt     *time.Time `json:"expires_at"`
+	NotifyOffline bool       `json:"notify_offline"`
+}
+
+// Announcement severities
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// MarkNotificationsReadRequest marks the listed notifications read, or all
+// of the caller's with All
+type MarkNotificationsReadRequest struct {
+	IDs []int64 `json:"ids"`
+	All bool    `json:"all"`
+}
+
+// SetRoleRequest makes UserID an admin or a plain member of a group
+type SetRoleRequest struct {
+	ConversationID int64  `json:"conversation_id"`
+	UserID         int64  `json:"user_id"`
+	Role           string `json:"role"`
+}
+
+// TransferOwnershipRequest hands ownership of a group to NewOwnerID
+type TransferOwnershipRequest struct {
+	ConversationID int64 `json:"conversation_id"`
+	NewOwnerID     int64 `json:"new_owner_id"`
+}
+
+// Per-user outcomes when adding participants
+const (
+	ParticipantAdded         = "added"
+	ParticipantAlreadyMember = "already_member"
+	ParticipantNotFound      = "not_found"
+)
+
+// ParticipantResult reports what happened to one user in a batch add. Code
+// mirrors the HTTP status the user would have got on their own.
+type ParticipantResult struct {
+	UserID int64  `json:"user_id"`
+	Status string `json:"status"`
+	Code   int    `json:"code"`
+}
+
+type AddParticipantsResponse struct {
+	Conversation *Conversation       `json:"conversation"`
+	Results      []ParticipantResult `json:"results"`
+}
+
+// ReceiptSummary aggregates who has received and read a message, for its
+// sender. Recipients who turned read receipts off are only counted in
+// Hidden. Breakdown is omitted for conversations too large to list.
+type ReceiptSummary struct {
+	MessageID  int64         `json:"message_id"`
+	Recipients int           `json:"recipients"`
+	Delivered  int           `json:"delivered"` // includes those who have read it
+	Read       int           `json:"read"`
+	Hidden     int           `json:"hidden"`
+	Breakdown  []UserReceipt `json:"breakdown,omitempty"`
+}
+
+// UserReceipt is one recipient's state. The timestamps are when the
+// recipient's delivered/read marker last moved, so they can postdate the
+// moment this particular message arrived or was read.
+type UserReceipt struct {
+	UserID      int64      `json:"user_id"`
+	Username    string     `json:"username"`
+	DeliveredAt *time.Time `json:"delivered_at"`
+	ReadAt      *time.Time `json:"read_at"`
+}
+
+// PrivacySettings are a user's own privacy choices
+type PrivacySettings struct {
+	// ReadReceipts false hides the user from other people's receipt
+	// breakdowns
+	ReadReceipts bool `json:"read_receipts"`
+}
+
+// SystemEvent is the structured content of a system message, stored as JSON
+// in the content column
+type SystemEvent struct {
+	Event     string  `json:"event"` // e.g. "conversation_created", "participant_added"
+	ActorID   int64   `json:"actor_id,omitempty"`
+	TargetIDs []int64 `json:"target_ids,omitempty"`
+	Name      string  `json:"name,omitempty"`
+	Role      string  `json:"role,omitempty"`
+	Text      string  `json:"text"` // human-readable fallback, e.g. "alice added bob"
+}
+
+// ExportedMessage is a message row joined with its sender's username
+type ExportedMessage struct {
+	ID             int64     `json:"id"`
+	ConversationID int64     `json:"conversation_id"`
+	SenderID       *int64    `json:"sender_id"` // nil for system messages
+	SenderUsername string    `json:"sender_username"`
+	Content        string    `json:"content"`
+	MessageType    string    `json:"message_type"`
+	CreatedAt      time.Time `json:"created_at"`
 }
 
 // Request/Response structures
@@ -44,22 +422,284 @@ type LoginRequest struct {
 }
 
 type LoginResponse struct {
-	Token string `json:"token"`
-	User  User   `json:"user"`
+	Token  string  `json:"token"`
+	User   User    `json:"user"`
+	Device *Device `json:"device,omitempty"`
+}
+
+// Device is one of a user's clients. DeviceID is generated by the client
+// and stable across logins; ID is the server's handle for this
+// registration, which sessions are bound to.
+type Device struct {
+	ID         int64     `json:"-"`
+	DeviceID   string    `json:"device_id"`
+	Name       string    `json:"name"`
+	Platform   string    `json:"platform"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	Current    bool      `json:"current"`
+}
+
+// DeviceRequest renames (with Name) or revokes one of the caller's devices
+type DeviceRequest struct {
+	DeviceID string `json:"device_id"`
+	Name     string `json:"name"`
+}
+
+// UpdateConversationRequest changes the fields that are present; omitted
+// fields are left alone and an empty topic clears it
+// MaxRetentionDays is the longest retention a conversation can set, about
+// ten years
+const MaxRetentionDays = 3650
+
+type UpdateConversationRequest struct {
+	ConversationID int64   `json:"conversation_id"`
+	Name           *string `json:"name"`
+	Topic          *string `json:"topic"`
+	// RetentionDays of 0 clears it, falling back to the server default
+	RetentionDays *int `json:"retention_days"`
 }
 
 type CreateConversationRequest struct {
-	Name        string  `json:"name"`
-	Type        string  `json:"type"`
+	Name         string  `json:"name"`
+	Type         string  `json:"type"`
 	Participants []int64 `json:"participants"`
 }
 
+// FieldError is one problem with one field of a request. Code is stable
+// and machine readable; Message is for people.
+type FieldError struct {
+	Field   string  `json:"field"`
+	Code    string  `json:"code"`
+	Message string  `json:"message"`
+	UserIDs []int64 `json:"user_ids,omitempty"`
+}
+
+// ValidationErrorResponse is the 400 body listing every problem found
+type ValidationErrorResponse struct {
+	Error  string       `json:"error"`
+	Errors []FieldError `json:"errors"`
+}
+
 type SendMessageRequest struct {
 	ConversationID int64  `json:"conversation_id"`
 	Content        string `json:"content"`
 }
 
+// MaxMessageContentBytes caps the UTF-8 size of a message's content, over
+// HTTP and the websocket alike
+const MaxMessageContentBytes = 8 * 1024
+
+// Moderation report reasons
+var ReportReasons = map[string]bool{
+	"spam":       true,
+	"harassment": true,
+	"hate":       true,
+	"violence":   true,
+	"sexual":     true,
+	"other":      true,
+}
+
+type MessageReport struct {
+	ID             int64      `json:"id"`
+	MessageID      int64      `json:"message_id"`
+	ConversationID int64      `json:"conversation_id"`
+	SenderID       int64      `json:"sender_id"`
+	ReporterID     int64      `json:"reporter_id"`
+	Reason         string     `json:"reason"`
+	Note           string     `json:"note"`
+	Status         string     `json:"status"`       // "open" or "resolved"
+	ReportCount    int        `json:"report_count"` // total reports against the same message
+	ResolvedBy     *int64     `json:"resolved_by,omitempty"`
+	ResolvedAt     *time.Time `json:"resolved_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+type ReportMessageRequest struct {
+	Reason string `json:"reason"`
+	Note   string `json:"note"`
+}
+
+type ResolveReportRequest struct {
+	DeleteMessage bool `json:"delete_message"`
+}
+
+type ConversationMirrorRequest struct {
+	ConversationID int64 `json:"conversation_id"`
+	Enabled        bool  `json:"enabled"`
+}
+
+// Bot is a user account driven by a program. It authenticates with an API
+// key instead of a password and receives the commands it registered, from
+// conversations it belongs to, over its websocket or else its webhook.
+type Bot struct {
+	ID         int64        `json:"id"`
+	Username   string       `json:"username"`
+	Avatar     string       `json:"avatar"`
+	WebhookURL string       `json:"webhook_url,omitempty"`
+	Commands   []BotCommand `json:"commands"`
+	CreatedAt  time.Time    `json:"created_at"`
+}
+
+// BotCommand is one command a bot answers, without the leading slash
+type BotCommand struct {
+	Command     string `json:"command"`
+	Description string `json:"description,omitempty"`
+}
+
+// RegisteredCommand is a command together with the bot that owns it
+type RegisteredCommand struct {
+	BotCommand
+	BotID       int64  `json:"bot_id"`
+	BotUsername string `json:"bot_username"`
+}
+
+type CreateBotRequest struct {
+	Username   string `json:"username"`
+	Avatar     string `json:"avatar"`
+	WebhookURL string `json:"webhook_url"`
+}
+
+// CreateBotResponse carries the bot's API key, which is shown only once
+type CreateBotResponse struct {
+	Bot    *Bot   `json:"bot"`
+	APIKey string `json:"api_key"`
+}
+
+// SetBotCommandsRequest replaces every command the calling bot owns
+type SetBotCommandsRequest struct {
+	Commands []BotCommand `json:"commands"`
+}
+
+// BotInvocation is what a bot receives when someone uses one of its
+// commands: the "bot_command" websocket payload and the webhook body
+type BotInvocation struct {
+	ConversationID int64     `json:"conversation_id"`
+	MessageID      int64     `json:"message_id"`
+	SenderID       int64     `json:"sender_id"`
+	SenderUsername string    `json:"sender_username"`
+	Command        string    `json:"command"`
+	Args           []string  `json:"args"`
+	Text           string    `json:"text"` // everything after the command
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// BotReply is an optional webhook response body; non-empty Content is
+// posted to the conversation as the bot
+type BotReply struct {
+	Content string `json:"content"`
+}
+
+// Outbox states of an email
+const (
+	MailPending = "pending"
+	MailSent    = "sent"
+	MailFailed  = "failed" // permanently, or out of attempts
+)
+
+// OutboundMail is a rendered email in the outbox
+type OutboundMail struct {
+	ID            int64
+	Recipient     string
+	Template      string
+	Subject       string
+	TextBody      string
+	HTMLBody      string
+	Status        string
+	Attempts      int
+	LastError     string
+	NextAttemptAt time.Time
+	CreatedAt     time.Time
+	SentAt        *time.Time
+}
+
+// EmailStatus is the caller's email address and whether it's verified
+type EmailStatus struct {
+	Email      string     `json:"email"`
+	Verified   bool       `json:"verified"`
+	VerifiedAt *time.Time `json:"verified_at,omitempty"`
+}
+
+// SetEmailRequest sets the caller's email address, which then needs
+// verifying
+type SetEmailRequest struct {
+	Email string `json:"email"`
+}
+
 type WebSocketMessage struct {
 	Type    string      `json:"type"`
 	Payload interface{} `json:"payload"`
-} 
\ No newline at end of file
+
+	// Muted marks an event for a conversation the recipient has muted; the
+	// client should update its state without alerting
+	Muted bool `json:"muted,omitempty"`
+}
+
+// IncomingWebSocketMessage is a frame received from a client. The payload
+// stays raw until the type says which of the structs below it decodes into.
+// ClientID is the client's own label for the frame, echoed on any "error"
+// it causes.
+type IncomingWebSocketMessage struct {
+	Type     string          `json:"type"`
+	Payload  json.RawMessage `json:"payload"`
+	ClientID string          `json:"client_id,omitempty"`
+}
+
+// IncomingMessage is the payload of a client's "message" event
+type IncomingMessage struct {
+	ConversationID int64  `json:"conversation_id"`
+	Content        string `json:"content"`
+}
+
+// TypingEvent is the payload of a client's "typing" event
+type TypingEvent struct {
+	ConversationID int64 `json:"conversation_id"`
+	IsTyping       bool  `json:"is_typing"`
+}
+
+// StateEvent is the payload of a client's "state" event. A missing or null
+// Value clears the key; TTL is in seconds.
+type StateEvent struct {
+	ConversationID int64           `json:"conversation_id"`
+	Key            string          `json:"key"`
+	TTL            float64         `json:"ttl"`
+	Value          json.RawMessage `json:"value"`
+}
+
+// ActiveEvent is the payload of a client's "active" event; zero clears it
+type ActiveEvent struct {
+	ConversationID int64 `json:"conversation_id"`
+}
+
+// SyncRequest is the payload of a client's "sync" event after reconnecting.
+// Conversations maps a conversation ID to the last message ID the client
+// has from it; conversations it leaves out use LastMessageID.
+type SyncRequest struct {
+	LastMessageID int64           `json:"last_message_id"`
+	Conversations map[int64]int64 `json:"conversations"`
+}
+
+// ResumeRequest is the payload of a client's "resume" event after a brief
+// disconnect: the event_id of the last event it received
+type ResumeRequest struct {
+	LastEventID int64 `json:"last_event_id"`
+}
+
+// SubscriptionRequest is the payload of a client's "subscribe" and
+// "unsubscribe" events. All, on subscribe, goes back to receiving every
+// conversation.
+type SubscriptionRequest struct {
+	ConversationIDs []int64 `json:"conversation_ids"`
+	All             bool    `json:"all"`
+}
+
+// AuthRefresh is the payload of a client's "auth_refresh" event: a fresh
+// session token for the connection's user
+type AuthRefresh struct {
+	Token string `json:"token"`
+}
+
+// HeartbeatAck is the payload of a client's "heartbeat_ack" event
+type HeartbeatAck struct {
+	LatencyMS float64 `json:"latency_ms"`
+}
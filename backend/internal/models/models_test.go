@@ -0,0 +1,49 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMentionsUser(t *testing.T) {
+	for _, tc := range []struct {
+		content string
+		want    bool
+	}{
+		{"@bob lunch?", true},
+		{"lunch, @Bob?", true},
+		{"ask @bob", true},
+		{"@bobby lunch?", false},
+		{"mail bob@example.com", false},
+		{"bob lunch?", false},
+		{"@", false},
+	} {
+		if got := MentionsUser(tc.content, "bob"); got != tc.want {
+			t.Errorf("MentionsUser(%q, bob) = %t, want %t", tc.content, got, tc.want)
+		}
+	}
+	if MentionsUser("@bob", "") {
+		t.Error("an empty username matched")
+	}
+}
+
+func TestMuteSilences(t *testing.T) {
+	now := time.Now()
+	later, earlier := now.Add(time.Hour), now.Add(-time.Hour)
+	for _, tc := range []struct {
+		name      string
+		state     MuteState
+		mentioned bool
+		want      bool
+	}{
+		{"not muted", MuteState{}, false, false},
+		{"muted", MuteState{MutedUntil: &later}, false, true},
+		{"mention breaks through", MuteState{MutedUntil: &later}, true, false},
+		{"mentions muted too", MuteState{MutedUntil: &later, MuteMentions: true}, true, true},
+		{"expired", MuteState{MutedUntil: &earlier}, false, false},
+	} {
+		if got := tc.state.Silences(now, tc.mentioned); got != tc.want {
+			t.Errorf("%s: Silences = %t, want %t", tc.name, got, tc.want)
+		}
+	}
+}
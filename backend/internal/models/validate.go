@@ -0,0 +1,187 @@
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Validate methods hold the checks a request can pass or fail on its own.
+// Checks that need the database or the caller stay in the handlers.
+
+func requiredField(field string) FieldError {
+	return FieldError{Field: field, Code: "required", Message: "Required"}
+}
+
+// requireConversationID appends an error unless id can name a conversation
+func requireConversationID(errs []FieldError, id int64) []FieldError {
+	if id <= 0 {
+		errs = append(errs, requiredField("conversation_id"))
+	}
+	return errs
+}
+
+func (r *RegisterRequest) Validate() []FieldError {
+	var errs []FieldError
+	if strings.TrimSpace(r.Username) == "" {
+		errs = append(errs, requiredField("username"))
+	}
+	if r.Password == "" {
+		errs = append(errs, requiredField("password"))
+	}
+	return errs
+}
+
+func (r *LoginRequest) Validate() []FieldError {
+	var errs []FieldError
+	if r.Username == "" {
+		errs = append(errs, requiredField("username"))
+	}
+	if r.Password == "" {
+		errs = append(errs, requiredField("password"))
+	}
+	return errs
+}
+
+func (r *SendMessageRequest) Validate() []FieldError {
+	errs := requireConversationID(nil, r.ConversationID)
+	if r.Content == "" {
+		errs = append(errs, requiredField("content"))
+	} else if len(r.Content) > MaxMessageContentBytes {
+		errs = append(errs, FieldError{
+			Field:   "content",
+			Code:    "too_long",
+			Message: fmt.Sprintf("Must be at most %d bytes", MaxMessageContentBytes),
+		})
+	}
+	return errs
+}
+
+func (r *MarkReadRequest) Validate() []FieldError {
+	errs := requireConversationID(nil, r.ConversationID)
+	if r.MessageID <= 0 {
+		errs = append(errs, requiredField("message_id"))
+	}
+	return errs
+}
+
+func (r *MarkNotificationsReadRequest) Validate() []FieldError {
+	if !r.All && len(r.IDs) == 0 {
+		return []FieldError{requiredField("ids")}
+	}
+	return nil
+}
+
+func (r *AnnouncementRequest) Validate() []FieldError {
+	var errs []FieldError
+	if strings.TrimSpace(r.Message) == "" {
+		errs = append(errs, requiredField("message"))
+	} else if len(r.Message) > MaxMessageContentBytes {
+		errs = append(errs, FieldError{
+			Field:   "message",
+			Code:    "too_long",
+			Message: fmt.Sprintf("Must be at most %d bytes", MaxMessageContentBytes),
+		})
+	}
+	switch r.Severity {
+	case "", SeverityInfo, SeverityWarning, SeverityCritical:
+	default:
+		errs = append(errs, FieldError{Field: "severity", Code: "invalid_severity", Message: `Must be "info", "warning" or "critical"`})
+	}
+	if r.ExpiresAt != nil && !r.ExpiresAt.After(time.Now()) {
+		errs = append(errs, FieldError{Field: "expires_at", Code: "in_past", Message: "Must be in the future"})
+	}
+	return errs
+}
+
+func (r *MuteRequest) Validate() []FieldError {
+	return requireConversationID(nil, r.ConversationID)
+}
+
+func (r *CustomNameRequest) Validate() []FieldError {
+	return requireConversationID(nil, r.ConversationID)
+}
+
+func (r *PinRequest) Validate() []FieldError {
+	return requireConversationID(nil, r.ConversationID)
+}
+
+func (r *DeleteConversationRequest) Validate() []FieldError {
+	return requireConversationID(nil, r.ConversationID)
+}
+
+func (r *UpdateConversationRequest) Validate() []FieldError {
+	errs := requireConversationID(nil, r.ConversationID)
+	if r.RetentionDays != nil && (*r.RetentionDays < 0 || *r.RetentionDays > MaxRetentionDays) {
+		errs = append(errs, FieldError{
+			Field:   "retention_days",
+			Code:    "out_of_range",
+			Message: fmt.Sprintf("Must be between 0 (keep forever) and %d", MaxRetentionDays),
+		})
+	}
+	return errs
+}
+
+func (r *ConversationMirrorRequest) Validate() []FieldError {
+	return requireConversationID(nil, r.ConversationID)
+}
+
+func (r *RemoveParticipantRequest) Validate() []FieldError {
+	return requireConversationID(nil, r.ConversationID)
+}
+
+func (r *AddParticipantsRequest) Validate() []FieldError {
+	errs := requireConversationID(nil, r.ConversationID)
+	if len(r.UserIDs) == 0 {
+		errs = append(errs, requiredField("user_ids"))
+	}
+	return errs
+}
+
+func (r *SetRoleRequest) Validate() []FieldError {
+	errs := requireConversationID(nil, r.ConversationID)
+	if r.UserID <= 0 {
+		errs = append(errs, requiredField("user_id"))
+	}
+	if r.Role != RoleAdmin && r.Role != RoleMember {
+		errs = append(errs, FieldError{Field: "role", Code: "invalid_role", Message: `Must be "admin" or "member"`})
+	}
+	return errs
+}
+
+func (r *TransferOwnershipRequest) Validate() []FieldError {
+	errs := requireConversationID(nil, r.ConversationID)
+	if r.NewOwnerID <= 0 {
+		errs = append(errs, requiredField("new_owner_id"))
+	}
+	return errs
+}
+
+func (r *ReportMessageRequest) Validate() []FieldError {
+	if !ReportReasons[r.Reason] {
+		return []FieldError{{Field: "reason", Code: "invalid_reason", Message: "Unknown report reason"}}
+	}
+	return nil
+}
+
+func (r *DeviceRequest) Validate() []FieldError {
+	if r.DeviceID == "" {
+		return []FieldError{requiredField("device_id")}
+	}
+	return nil
+}
+
+func (r *CreateBotRequest) Validate() []FieldError {
+	var errs []FieldError
+	if strings.TrimSpace(r.Username) == "" {
+		errs = append(errs, requiredField("username"))
+	}
+	if r.WebhookURL != "" {
+		u, err := url.Parse(r.WebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, FieldError{Field: "webhook_url", Code: "invalid_url", Message: "Must be an absolute http(s) URL"})
+		}
+	}
+	return errs
+}
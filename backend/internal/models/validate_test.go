@@ -0,0 +1,64 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// codes lists the field:code pairs of errs, for comparing in one go
+func codes(errs []FieldError) string {
+	var out []string
+	for _, e := range errs {
+		out = append(out, e.Field+":"+e.Code)
+	}
+	return strings.Join(out, " ")
+}
+
+func TestValidate(t *testing.T) {
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	negative, tooLong := -1, MaxRetentionDays+1
+	for _, tc := range []struct {
+		name string
+		req  interface{ Validate() []FieldError }
+		want string
+	}{
+		{"register", &RegisterRequest{Username: "alice", Password: "x"}, ""},
+		{"register blank", &RegisterRequest{Username: "  "}, "username:required password:required"},
+		{"login", &LoginRequest{}, "username:required password:required"},
+		{"send", &SendMessageRequest{ConversationID: 1, Content: "hi"}, ""},
+		{"send empty", &SendMessageRequest{}, "conversation_id:required content:required"},
+		{"send too long", &SendMessageRequest{ConversationID: 1, Content: strings.Repeat("x", MaxMessageContentBytes+1)}, "content:too_long"},
+		{"mark read", &MarkReadRequest{ConversationID: 1}, "message_id:required"},
+		{"notifications all", &MarkNotificationsReadRequest{All: true}, ""},
+		{"notifications none", &MarkNotificationsReadRequest{}, "ids:required"},
+		{"announcement", &AnnouncementRequest{Message: "maintenance", Severity: SeverityWarning, ExpiresAt: &future}, ""},
+		{"announcement bad", &AnnouncementRequest{Message: " ", Severity: "loud", ExpiresAt: &past}, "message:required severity:invalid_severity expires_at:in_past"},
+		{"mute", &MuteRequest{}, "conversation_id:required"},
+		{"custom name", &CustomNameRequest{ConversationID: -3}, "conversation_id:required"},
+		{"update retention", &UpdateConversationRequest{ConversationID: 1, RetentionDays: &negative}, "retention_days:out_of_range"},
+		{"update retention too long", &UpdateConversationRequest{ConversationID: 1, RetentionDays: &tooLong}, "retention_days:out_of_range"},
+		{"add participants", &AddParticipantsRequest{ConversationID: 1}, "user_ids:required"},
+		{"set role", &SetRoleRequest{ConversationID: 1, UserID: 2, Role: RoleAdmin}, ""},
+		{"set role owner", &SetRoleRequest{ConversationID: 1, Role: RoleOwner}, "user_id:required role:invalid_role"},
+		{"transfer", &TransferOwnershipRequest{ConversationID: 1}, "new_owner_id:required"},
+		{"report", &ReportMessageRequest{Reason: "spam"}, ""},
+		{"report unknown", &ReportMessageRequest{Reason: "boring"}, "reason:invalid_reason"},
+		{"device", &DeviceRequest{}, "device_id:required"},
+		{"bot", &CreateBotRequest{Username: "helper", WebhookURL: "https://example.com/hook"}, ""},
+		{"bot bad url", &CreateBotRequest{Username: "helper", WebhookURL: "ftp://example.com"}, "webhook_url:invalid_url"},
+		{"bot relative url", &CreateBotRequest{WebhookURL: "/hook"}, "username:required webhook_url:invalid_url"},
+	} {
+		if got := codes(tc.req.Validate()); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestValidateMessages(t *testing.T) {
+	errs := (&SendMessageRequest{ConversationID: 1, Content: strings.Repeat("x", MaxMessageContentBytes+1)}).Validate()
+	if want := fmt.Sprintf("Must be at most %d bytes", MaxMessageContentBytes); len(errs) != 1 || errs[0].Message != want {
+		t.Errorf("too long content: %+v, want %q", errs, want)
+	}
+}
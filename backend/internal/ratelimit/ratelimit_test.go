@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiterAllowsBurstThenRefuses(t *testing.T) {
+	l := New(1, 3)
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow(1); !ok {
+			t.Fatalf("send %d of the burst refused", i+1)
+		}
+	}
+	ok, wait := l.Allow(1)
+	if ok {
+		t.Fatal("send past the burst allowed")
+	}
+	if wait <= 0 || wait > time.Second {
+		t.Errorf("retry after %s, want within one refill interval", wait)
+	}
+
+	// Keys don't share a bucket
+	if ok, _ := l.Allow(2); !ok {
+		t.Error("another key was refused")
+	}
+}
+
+func TestLimiterRefills(t *testing.T) {
+	l := New(100, 1)
+	l.Allow(1)
+	if ok, _ := l.Allow(1); ok {
+		t.Fatal("second send allowed with a burst of 1")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if ok, _ := l.Allow(1); !ok {
+		t.Error("bucket didn't refill")
+	}
+}
+
+func TestLimiterDisabled(t *testing.T) {
+	l := New(0, 1)
+	for i := 0; i < 100; i++ {
+		if ok, _ := l.Allow(1); !ok {
+			t.Fatal("a limiter with no rate refused a send")
+		}
+	}
+}
+
+func TestLimiterForgetAndPrune(t *testing.T) {
+	l := New(1, 1)
+	l.Allow(1)
+	l.Forget(1)
+	if ok, _ := l.Allow(1); !ok {
+		t.Error("a forgotten key kept its empty bucket")
+	}
+
+	l.Allow(2)
+	if removed := l.Prune(time.Hour); removed != 0 {
+		t.Errorf("pruned %d fresh buckets", removed)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if removed := l.Prune(time.Millisecond); removed != 2 {
+		t.Errorf("pruned %d idle buckets, want 2", removed)
+	}
+}
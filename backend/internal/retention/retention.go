@@ -0,0 +1,182 @@
+// Package retention deletes messages that have outlived their
+// conversation's retention setting, or the server-wide default for
+// conversations without one, and moves old messages to the archive table
+// when archiving is on. The janitor works straight against the
+// database on its own goroutine, in batches with a pause between them, so
+// it never holds up the hub or a long write lock.
+package retention
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Store is the database the janitor prunes
+type Store interface {
+	PruneExpiredMessages(ctx context.Context, now time.Time, defaultDays, limit int) (map[int64]int, error)
+	CountExpiredMessages(ctx context.Context, now time.Time, defaultDays int) (map[int64]int, error)
+	ArchiveMessages(ctx context.Context, before time.Time, limit int) (int, error)
+}
+
+// Options tunes the janitor
+type Options struct {
+	// Interval is how often expired messages are swept
+	Interval time.Duration
+	// BatchSize bounds how many messages one transaction deletes
+	BatchSize int
+	// BatchPause is how long to wait between batches, letting queued
+	// writes through
+	BatchPause time.Duration
+	// DefaultDays is the retention of conversations without their own;
+	// 0 keeps their messages forever
+	DefaultDays int
+	// DryRun only logs what each sweep would delete
+	DryRun bool
+	// ArchiveDays moves messages older than this many days to the archive
+	// after each sweep, dry run or not; 0 archives nothing
+	ArchiveDays int
+}
+
+// Janitor periodically deletes expired messages
+type Janitor struct {
+	store  Store
+	opts   Options
+	logger *log.Logger
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewJanitor(store Store, opts Options) *Janitor {
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 1
+	}
+	return &Janitor{
+		store:  store,
+		opts:   opts,
+		logger: log.New(os.Stdout, "[RETENTION] ", log.LstdFlags|log.Lshortfile),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// Start sweeps every Interval until Close
+func (j *Janitor) Start() {
+	go func() {
+		defer close(j.done)
+		ticker := time.NewTicker(j.opts.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-j.stop:
+				return
+			case <-ticker.C:
+				j.Sweep(context.Background())
+				j.Archive(context.Background())
+			}
+		}
+	}()
+}
+
+// Close stops sweeping and waits for the current batch to finish
+func (j *Janitor) Close() {
+	j.stopOnce.Do(func() { close(j.stop) })
+	<-j.done
+}
+
+// Sweep deletes every message expired as of now, a batch at a time, and
+// returns how many were deleted. It stops early on an error or Close; the
+// next sweep picks up the rest. In dry-run mode it returns how many would
+// have been deleted.
+func (j *Janitor) Sweep(ctx context.Context) int {
+	now := time.Now().UTC()
+	if j.opts.DryRun {
+		return j.dryRun(ctx, now)
+	}
+	total := 0
+	perConversation := make(map[int64]int)
+	for {
+		pruned, err := j.store.PruneExpiredMessages(ctx, now, j.opts.DefaultDays, j.opts.BatchSize)
+		if err != nil {
+			j.logger.Printf("Failed to prune expired messages: %v", err)
+			break
+		}
+		n := 0
+		for conversationID, count := range pruned {
+			perConversation[conversationID] += count
+			n += count
+		}
+		total += n
+		if n < j.opts.BatchSize {
+			break
+		}
+		j.logger.Printf("Deleted %d expired messages so far", total)
+		select {
+		case <-j.stop:
+			j.logger.Printf("Sweep interrupted after %d messages", total)
+			return total
+		case <-time.After(j.opts.BatchPause):
+		}
+	}
+
+	for conversationID, count := range perConversation {
+		j.logger.Printf("Deleted %d expired messages from conversation %d", count, conversationID)
+	}
+	if total > 0 {
+		j.logger.Printf("Sweep deleted %d expired messages", total)
+	}
+	return total
+}
+
+// dryRun logs what a sweep at now would delete
+func (j *Janitor) dryRun(ctx context.Context, now time.Time) int {
+	expired, err := j.store.CountExpiredMessages(ctx, now, j.opts.DefaultDays)
+	if err != nil {
+		j.logger.Printf("Failed to count expired messages: %v", err)
+		return 0
+	}
+	total := 0
+	for conversationID, count := range expired {
+		j.logger.Printf("Dry run: would delete %d expired messages from conversation %d", count, conversationID)
+		total += count
+	}
+	j.logger.Printf("Dry run: sweep would delete %d expired messages", total)
+	return total
+}
+
+// Archive moves every message older than ArchiveDays to the archive, a
+// batch at a time, and returns how many moved. Like Sweep, it stops early
+// on an error or Close.
+func (j *Janitor) Archive(ctx context.Context) int {
+	if j.opts.ArchiveDays <= 0 {
+		return 0
+	}
+	before := time.Now().UTC().AddDate(0, 0, -j.opts.ArchiveDays)
+	total := 0
+	for {
+		n, err := j.store.ArchiveMessages(ctx, before, j.opts.BatchSize)
+		if err != nil {
+			j.logger.Printf("Failed to archive messages: %v", err)
+			break
+		}
+		total += n
+		if n < j.opts.BatchSize {
+			break
+		}
+		j.logger.Printf("Archived %d messages so far", total)
+		select {
+		case <-j.stop:
+			j.logger.Printf("Archiving interrupted after %d messages", total)
+			return total
+		case <-time.After(j.opts.BatchPause):
+		}
+	}
+	if total > 0 {
+		j.logger.Printf("Archived %d messages older than %d days", total, j.opts.ArchiveDays)
+	}
+	return total
+}
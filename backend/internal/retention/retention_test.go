@@ -0,0 +1,148 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStore hands out expired messages in batches from a fixed backlog
+type fakeStore struct {
+	mu       sync.Mutex
+	expired  int
+	archived int
+	failAt   int // fail the nth prune call, counting from 1
+	calls    int
+	counted  bool
+	days     []int
+}
+
+func (s *fakeStore) PruneExpiredMessages(ctx context.Context, now time.Time, defaultDays, limit int) (map[int64]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	s.days = append(s.days, defaultDays)
+	if s.calls == s.failAt {
+		return nil, errors.New("disk on fire")
+	}
+	n := min(limit, s.expired)
+	s.expired -= n
+	// Split across two conversations to check the totals add up
+	return map[int64]int{1: n / 2, 2: n - n/2}, nil
+}
+
+func (s *fakeStore) CountExpiredMessages(ctx context.Context, now time.Time, defaultDays int) (map[int64]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counted = true
+	return map[int64]int{1: s.expired}, nil
+}
+
+func (s *fakeStore) ArchiveMessages(ctx context.Context, before time.Time, limit int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := min(limit, s.archived)
+	s.archived -= n
+	return n, nil
+}
+
+func TestSweepInBatches(t *testing.T) {
+	store := &fakeStore{expired: 25}
+	j := NewJanitor(store, Options{BatchSize: 10, DefaultDays: 30})
+
+	if n := j.Sweep(context.Background()); n != 25 {
+		t.Errorf("swept %d, want 25", n)
+	}
+	// Two full batches, then the short one that ends the sweep
+	if store.calls != 3 || store.expired != 0 {
+		t.Errorf("%d prune calls leaving %d, want 3 leaving none", store.calls, store.expired)
+	}
+	for _, days := range store.days {
+		if days != 30 {
+			t.Errorf("pruned with a %d day default, want 30", days)
+		}
+	}
+}
+
+func TestSweepPausesBetweenBatches(t *testing.T) {
+	store := &fakeStore{expired: 30}
+	j := NewJanitor(store, Options{BatchSize: 10, BatchPause: 20 * time.Millisecond})
+
+	start := time.Now()
+	j.Sweep(context.Background())
+	// Three full batches mean three pauses before the empty fourth
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("sweep took %s, want at least the 3 pauses", elapsed)
+	}
+}
+
+func TestSweepStopsOnError(t *testing.T) {
+	store := &fakeStore{expired: 50, failAt: 2}
+	j := NewJanitor(store, Options{BatchSize: 10})
+
+	if n := j.Sweep(context.Background()); n != 10 {
+		t.Errorf("swept %d, want the 10 before the failure", n)
+	}
+	// The next sweep picks up the rest
+	if n := j.Sweep(context.Background()); n != 40 {
+		t.Errorf("next sweep took %d, want the remaining 40", n)
+	}
+}
+
+func TestDryRunDeletesNothing(t *testing.T) {
+	store := &fakeStore{expired: 25}
+	j := NewJanitor(store, Options{BatchSize: 10, DryRun: true})
+
+	if n := j.Sweep(context.Background()); n != 25 {
+		t.Errorf("dry run reported %d, want 25", n)
+	}
+	if !store.counted || store.calls != 0 || store.expired != 25 {
+		t.Errorf("dry run: counted %t, %d prune calls, %d left", store.counted, store.calls, store.expired)
+	}
+}
+
+func TestArchive(t *testing.T) {
+	store := &fakeStore{archived: 15}
+	if n := NewJanitor(store, Options{BatchSize: 10}).Archive(context.Background()); n != 0 || store.archived != 15 {
+		t.Errorf("archiving off moved %d", n)
+	}
+	if n := NewJanitor(store, Options{BatchSize: 10, ArchiveDays: 90}).Archive(context.Background()); n != 15 || store.archived != 0 {
+		t.Errorf("archived %d leaving %d, want all 15", n, store.archived)
+	}
+}
+
+func TestCloseInterruptsSweep(t *testing.T) {
+	store := &fakeStore{expired: 1000}
+	j := NewJanitor(store, Options{Interval: time.Millisecond, BatchSize: 10, BatchPause: time.Hour})
+	j.Start()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		store.mu.Lock()
+		calls := store.calls
+		store.mu.Unlock()
+		if calls > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the janitor never swept")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		j.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close waited out the batch pause")
+	}
+	if store.expired < 900 {
+		t.Errorf("%d left after closing mid-sweep, want most of the backlog", store.expired)
+	}
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// File is a writable file handed out by FS.CreateTemp. *os.File satisfies it.
+type File interface {
+	io.Writer
+	Name() string
+	Sync() error
+	Close() error
+}
+
+// FS is the filesystem the store writes through. Paths are always absolute
+// and already validated by the store. Implementations must make Rename
+// atomic with respect to readers of newpath.
+type FS interface {
+	MkdirAll(dir string) error
+	CreateTemp(dir, pattern string) (File, error)
+	Rename(oldpath, newpath string) error
+	Remove(name string) error
+	Open(name string) (io.ReadCloser, error)
+	// Size returns the size of a regular file, or an error matching
+	// fs.ErrNotExist when it doesn't exist
+	Size(name string) (int64, error)
+	// Walk calls fn for every regular file under root
+	Walk(root string, fn func(path string, size int64) error) error
+}
+
+// OSFS is the local disk implementation of FS
+type OSFS struct{}
+
+func (OSFS) MkdirAll(dir string) error {
+	return os.MkdirAll(dir, 0755)
+}
+
+func (OSFS) CreateTemp(dir, pattern string) (File, error) {
+	return os.CreateTemp(dir, pattern)
+}
+
+func (OSFS) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (OSFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (OSFS) Open(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+func (OSFS) Size(name string) (int64, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return 0, err
+	}
+	if !info.Mode().IsRegular() {
+		return 0, &fs.PathError{Op: "size", Path: name, Err: fs.ErrNotExist}
+	}
+	return info.Size(), nil
+}
+
+func (OSFS) Walk(root string, fn func(path string, size int64) error) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return fn(path, info.Size())
+	})
+}
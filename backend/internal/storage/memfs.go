@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemFS is an in-memory FS for tests and local tooling. Temp files become
+// visible only once closed, and Rename swaps contents under a single lock,
+// so readers never see a partial write.
+type MemFS struct {
+	mu    sync.Mutex
+	files map[string][]byte
+	dirs  map[string]bool
+	next  int
+}
+
+func NewMemFS() *MemFS {
+	return &MemFS{
+		files: make(map[string][]byte),
+		dirs:  map[string]bool{string(filepath.Separator): true},
+	}
+}
+
+func (m *MemFS) MkdirAll(dir string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for d := filepath.Clean(dir); !m.dirs[d]; d = filepath.Dir(d) {
+		if _, ok := m.files[d]; ok {
+			return &fs.PathError{Op: "mkdir", Path: d, Err: fs.ErrExist}
+		}
+		m.dirs[d] = true
+	}
+	return nil
+}
+
+func (m *MemFS) CreateTemp(dir, pattern string) (File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	dir = filepath.Clean(dir)
+	if !m.dirs[dir] {
+		return nil, &fs.PathError{Op: "createtemp", Path: dir, Err: fs.ErrNotExist}
+	}
+	m.next++
+	name := filepath.Join(dir, strings.Replace(pattern, "*", fmt.Sprint(m.next), 1))
+	m.files[name] = nil
+	return &memFile{fs: m, name: name}, nil
+}
+
+func (m *MemFS) Rename(oldpath, newpath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	oldpath, newpath = filepath.Clean(oldpath), filepath.Clean(newpath)
+	data, ok := m.files[oldpath]
+	if !ok {
+		return &fs.PathError{Op: "rename", Path: oldpath, Err: fs.ErrNotExist}
+	}
+	if !m.dirs[filepath.Dir(newpath)] {
+		return &fs.PathError{Op: "rename", Path: newpath, Err: fs.ErrNotExist}
+	}
+	delete(m.files, oldpath)
+	m.files[newpath] = data
+	return nil
+}
+
+func (m *MemFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name = filepath.Clean(name)
+	if _, ok := m.files[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(m.files, name)
+	return nil
+}
+
+func (m *MemFS) Open(name string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.files[filepath.Clean(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *MemFS) Size(name string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.files[filepath.Clean(name)]
+	if !ok {
+		return 0, &fs.PathError{Op: "size", Path: name, Err: fs.ErrNotExist}
+	}
+	return int64(len(data)), nil
+}
+
+func (m *MemFS) Walk(root string, fn func(path string, size int64) error) error {
+	m.mu.Lock()
+	root = filepath.Clean(root)
+	prefix := root + string(filepath.Separator)
+	var names []string
+	sizes := make(map[string]int64)
+	for name, data := range m.files {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+			sizes[name] = int64(len(data))
+		}
+	}
+	m.mu.Unlock()
+
+	sort.Strings(names)
+	for _, name := range names {
+		if err := fn(name, sizes[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// memFile buffers writes and publishes them to the MemFS on Close
+type memFile struct {
+	fs     *MemFS
+	name   string
+	buf    bytes.Buffer
+	closed bool
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	return f.buf.Write(p)
+}
+
+func (f *memFile) Name() string { return f.name }
+
+func (f *memFile) Sync() error { return nil }
+
+func (f *memFile) Close() error {
+	if f.closed {
+		return fs.ErrClosed
+	}
+	f.closed = true
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if _, ok := f.fs.files[f.name]; ok {
+		f.fs.files[f.name] = f.buf.Bytes()
+	}
+	return nil
+}
@@ -0,0 +1,301 @@
+// Package storage owns every directory the server writes user-influenced
+// files into (avatars, attachments, exports, backups). Callers name a root
+// and the path components under it; the store validates each component,
+// keeps the result inside the root, enforces the root's quota and writes
+// through a temp file so readers only ever see complete files.
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Root names used by the server
+const (
+	RootAvatars     = "avatars"
+	RootAttachments = "attachments"
+	RootExports     = "exports"
+	RootBackups     = "backups"
+)
+
+var (
+	// ErrInvalidName is returned for path components that are empty, hidden,
+	// contain separators or otherwise could escape or confuse the root
+	ErrInvalidName = errors.New("invalid file name")
+	// ErrQuotaExceeded is returned when a write would take a root over its quota
+	ErrQuotaExceeded = errors.New("storage quota exceeded")
+	// ErrUnknownRoot is returned for a root that was never registered
+	ErrUnknownRoot = errors.New("unknown storage root")
+	// ErrNotFound is returned when reading or deleting a missing file
+	ErrNotFound = errors.New("file not found")
+)
+
+const (
+	maxNameLength = 255
+	tempPattern   = ".tmp-*"
+)
+
+type root struct {
+	dir   string
+	quota int64 // 0 means unlimited
+
+	mu   sync.Mutex
+	used int64
+}
+
+// Usage is a point-in-time view of a root's consumption
+type Usage struct {
+	Dir   string `json:"dir"`
+	Used  int64  `json:"used_bytes"`
+	Quota int64  `json:"quota_bytes"`
+}
+
+// Store resolves and guards the registered roots
+type Store struct {
+	fs FS
+
+	mu    sync.RWMutex
+	roots map[string]*root
+}
+
+func New(fsys FS) *Store {
+	return &Store{fs: fsys, roots: make(map[string]*root)}
+}
+
+// AddRoot registers dir under name with a byte quota (0 for unlimited). The
+// directory is created if needed, leftover temp files from an interrupted
+// write are removed and current usage is measured from what's on disk.
+func (s *Store) AddRoot(name, dir string, quota int64) error {
+	if dir == "" {
+		return fmt.Errorf("storage root %q: directory is required", name)
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("storage root %q: %w", name, err)
+	}
+	if err := s.fs.MkdirAll(abs); err != nil {
+		return fmt.Errorf("storage root %q: %w", name, err)
+	}
+
+	var used int64
+	var stale []string
+	err = s.fs.Walk(abs, func(path string, size int64) error {
+		if strings.HasPrefix(filepath.Base(path), ".tmp-") {
+			stale = append(stale, path)
+			return nil
+		}
+		used += size
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("storage root %q: failed to measure usage: %w", name, err)
+	}
+	for _, path := range stale {
+		if err := s.fs.Remove(path); err != nil {
+			log.Printf("Failed to remove stale temp file %s: %v", path, err)
+		}
+	}
+
+	s.mu.Lock()
+	s.roots[name] = &root{dir: abs, quota: quota, used: used}
+	s.mu.Unlock()
+	return nil
+}
+
+// ValidateName checks a single user-influenced path component
+func ValidateName(name string) error {
+	switch {
+	case name == "", len(name) > maxNameLength:
+		return ErrInvalidName
+	case strings.HasPrefix(name, "."):
+		// Covers "." and "..", and keeps user files from colliding with temp files
+		return ErrInvalidName
+	case strings.ContainsAny(name, "/\\:\x00"):
+		return ErrInvalidName
+	}
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f {
+			return ErrInvalidName
+		}
+	}
+	return nil
+}
+
+// Path returns the absolute path for elems under the named root after
+// validating every component
+func (s *Store) Path(rootName string, elems ...string) (string, error) {
+	rt, err := s.root(rootName)
+	if err != nil {
+		return "", err
+	}
+	return rt.path(elems)
+}
+
+func (rt *root) path(elems []string) (string, error) {
+	if len(elems) == 0 {
+		return "", ErrInvalidName
+	}
+	for _, elem := range elems {
+		if err := ValidateName(elem); err != nil {
+			return "", fmt.Errorf("%w: %q", err, elem)
+		}
+	}
+	path := filepath.Join(append([]string{rt.dir}, elems...)...)
+
+	// Belt and braces: validated components can't climb out, but never hand
+	// back a path that isn't strictly inside the root
+	rel, err := filepath.Rel(rt.dir, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", ErrInvalidName
+	}
+	return path, nil
+}
+
+func (s *Store) root(name string) (*root, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rt, ok := s.roots[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownRoot, name)
+	}
+	return rt, nil
+}
+
+// Put writes r to elems under the named root, replacing any existing file.
+// The data lands in a temp file in the destination directory and is renamed
+// into place only once fully written and synced, so a crash or quota
+// rejection never leaves a partial file behind. It returns the bytes written.
+func (s *Store) Put(rootName string, r io.Reader, elems ...string) (int64, error) {
+	rt, err := s.root(rootName)
+	if err != nil {
+		return 0, err
+	}
+	path, err := rt.path(elems)
+	if err != nil {
+		return 0, err
+	}
+	dir := filepath.Dir(path)
+	if err := s.fs.MkdirAll(dir); err != nil {
+		return 0, err
+	}
+
+	previous, err := s.fs.Size(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return 0, err
+	}
+
+	// Stop reading one byte past what the quota could allow so an oversized
+	// upload is rejected without being buffered to disk in full
+	src := r
+	if rt.quota > 0 {
+		rt.mu.Lock()
+		remaining := rt.quota - rt.used + previous
+		rt.mu.Unlock()
+		if remaining < 0 {
+			remaining = 0
+		}
+		src = io.LimitReader(r, remaining+1)
+	}
+
+	tmp, err := s.fs.CreateTemp(dir, tempPattern)
+	if err != nil {
+		return 0, err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			s.fs.Remove(tmp.Name())
+		}
+	}()
+
+	n, err := io.Copy(tmp, src)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// Re-check under the lock: concurrent writers may have used the space
+	// since the limit above was computed
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if current, err := s.fs.Size(path); err == nil {
+		previous = current
+	} else if errors.Is(err, fs.ErrNotExist) {
+		previous = 0
+	} else {
+		return 0, err
+	}
+	if rt.quota > 0 && rt.used-previous+n > rt.quota {
+		return 0, ErrQuotaExceeded
+	}
+	if err := s.fs.Rename(tmp.Name(), path); err != nil {
+		return 0, err
+	}
+	committed = true
+	rt.used += n - previous
+	return n, nil
+}
+
+// Open returns a reader for elems under the named root
+func (s *Store) Open(rootName string, elems ...string) (io.ReadCloser, error) {
+	path, err := s.Path(rootName, elems...)
+	if err != nil {
+		return nil, err
+	}
+	f, err := s.fs.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// Delete removes elems under the named root and releases its quota
+func (s *Store) Delete(rootName string, elems ...string) error {
+	rt, err := s.root(rootName)
+	if err != nil {
+		return err
+	}
+	path, err := rt.path(elems)
+	if err != nil {
+		return err
+	}
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	size, err := s.fs.Size(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.fs.Remove(path); err != nil {
+		return err
+	}
+	rt.used -= size
+	return nil
+}
+
+// Usage reports consumption for every registered root
+func (s *Store) Usage() map[string]Usage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	usage := make(map[string]Usage, len(s.roots))
+	for name, rt := range s.roots {
+		rt.mu.Lock()
+		usage[name] = Usage{Dir: rt.dir, Used: rt.used, Quota: rt.quota}
+		rt.mu.Unlock()
+	}
+	return usage
+}
@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newStore(t *testing.T, quota int64) *Store {
+	t.Helper()
+	s := New(NewMemFS())
+	if err := s.AddRoot(RootAttachments, "/data/attachments", quota); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func read(t *testing.T, s *Store, elems ...string) string {
+	t.Helper()
+	r, err := s.Open(RootAttachments, elems...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestValidateName(t *testing.T) {
+	for _, name := range []string{"", ".", "..", ".hidden", "a/b", `a\b`, "c:", "nul\x00", "tab\t", strings.Repeat("x", 256)} {
+		if err := ValidateName(name); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("ValidateName(%q) = %v, want ErrInvalidName", name, err)
+		}
+	}
+	for _, name := range []string{"avatar.png", "report 2024.csv", "ünïcode"} {
+		if err := ValidateName(name); err != nil {
+			t.Errorf("ValidateName(%q) = %v", name, err)
+		}
+	}
+}
+
+func TestPathStaysInsideTheRoot(t *testing.T) {
+	s := newStore(t, 0)
+	if _, err := s.Path(RootAttachments, "..", "etc", "passwd"); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("escaping path: %v", err)
+	}
+	if _, err := s.Path(RootAttachments); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("the root itself: %v", err)
+	}
+	if _, err := s.Path("secrets", "a"); !errors.Is(err, ErrUnknownRoot) {
+		t.Errorf("unregistered root: %v", err)
+	}
+	path, err := s.Path(RootAttachments, "7", "file.txt")
+	if err != nil || path != filepath.Join("/data/attachments", "7", "file.txt") {
+		t.Errorf("Path = %q, %v", path, err)
+	}
+}
+
+func TestPutReplaceAndDeleteTrackUsage(t *testing.T) {
+	s := newStore(t, 100)
+	if _, err := s.Put(RootAttachments, strings.NewReader("hello"), "1", "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(t, s, "1", "a.txt"); got != "hello" {
+		t.Errorf("read back %q", got)
+	}
+
+	// Replacing counts the new size, not both
+	if _, err := s.Put(RootAttachments, strings.NewReader("hi"), "1", "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if used := s.Usage()[RootAttachments].Used; used != 2 {
+		t.Errorf("used = %d after replacing, want 2", used)
+	}
+
+	if err := s.Delete(RootAttachments, "1", "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if used := s.Usage()[RootAttachments].Used; used != 0 {
+		t.Errorf("used = %d after deleting, want 0", used)
+	}
+	if _, err := s.Open(RootAttachments, "1", "a.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("open after delete: %v", err)
+	}
+	if err := s.Delete(RootAttachments, "1", "a.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second delete: %v", err)
+	}
+}
+
+func TestPutOverQuotaLeavesNothingBehind(t *testing.T) {
+	s := newStore(t, 10)
+	if _, err := s.Put(RootAttachments, strings.NewReader("123456"), "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Put(RootAttachments, strings.NewReader("123456"), "b"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("write over quota: %v", err)
+	}
+	if _, err := s.Open(RootAttachments, "b"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("rejected write is readable: %v", err)
+	}
+	if used := s.Usage()[RootAttachments].Used; used != 6 {
+		t.Errorf("used = %d, want 6", used)
+	}
+	var files []string
+	s.fs.Walk("/data/attachments", func(path string, size int64) error {
+		files = append(files, path)
+		return nil
+	})
+	if len(files) != 1 {
+		t.Errorf("files left after a rejected write: %v", files)
+	}
+
+	// Replacing a file may reuse its own space
+	if _, err := s.Put(RootAttachments, strings.NewReader("1234567890"), "a"); err != nil {
+		t.Errorf("replacing within quota: %v", err)
+	}
+}
+
+func TestAddRootMeasuresUsageAndClearsTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "kept.bin"), []byte("12345"), 0o644)
+	os.WriteFile(filepath.Join(dir, ".tmp-42"), []byte("partial"), 0o644)
+
+	s := New(OSFS{})
+	if err := s.AddRoot(RootBackups, dir, 0); err != nil {
+		t.Fatal(err)
+	}
+	if used := s.Usage()[RootBackups].Used; used != 5 {
+		t.Errorf("used = %d, want 5", used)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".tmp-42")); !os.IsNotExist(err) {
+		t.Errorf("stale temp file survived: %v", err)
+	}
+}
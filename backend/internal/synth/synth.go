@@ -0,0 +1,277 @@
+// Package synth generates synthetic users, conversations and message
+// history: development data for cmd/seed and the datasets cmd/capacity
+// measures. Everything is written through the db package, the same code
+// paths the server uses, and drawn from one random source, so a seed
+// reproduces the same dataset.
+//
+// Group sizes and traffic both follow a Zipf distribution: most groups are
+// small, and a handful of conversations carry most of the messages. Messages
+// arrive in daytime bursts, a few replies a minute or two apart.
+package synth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"messager/internal/db"
+	"messager/internal/models"
+)
+
+// importBatch is how many messages go into one transaction
+const importBatch = 5000
+
+// Spec describes a dataset
+type Spec struct {
+	Users   int
+	Directs int
+	Groups  int
+	// MaxGroupSize caps groups, creator included; it is lowered to Users
+	MaxGroupSize int
+	// Messages is the total, spread over the conversations
+	Messages int
+	// History is how far back the messages go
+	History time.Duration
+
+	// Names are given to the first users, in order; the rest are named
+	// NamePrefix followed by their number
+	Names      []string
+	NamePrefix string
+	// PasswordHash is stored for every user. Empty leaves accounts that
+	// can't log in.
+	PasswordHash string
+}
+
+// Validate reports a spec that can't be generated
+func (s Spec) Validate() error {
+	if s.Users < 2 {
+		return errors.New("at least 2 users are needed")
+	}
+	if pairs := s.Users * (s.Users - 1) / 2; s.Directs > pairs {
+		return fmt.Errorf("at most %d direct conversations fit %d users", pairs, s.Users)
+	}
+	if s.Groups > 0 && s.Users < 3 {
+		return errors.New("groups need at least 3 users")
+	}
+	if s.Messages > 0 && s.Directs+s.Groups == 0 {
+		return errors.New("messages need a conversation to go in")
+	}
+	return nil
+}
+
+// Dataset is what was generated
+type Dataset struct {
+	Users         []*models.User
+	Conversations []Conversation
+	Messages      int
+
+	traffic *rand.Zipf
+	order   []int
+}
+
+// Conversation is a generated conversation and its participants
+type Conversation struct {
+	ID           int64
+	Type         string
+	Participants []int64
+}
+
+// Pick returns a conversation, skewed the way traffic is: a few busy
+// conversations come up most of the time
+func (ds *Dataset) Pick() Conversation {
+	return ds.Conversations[ds.order[ds.traffic.Uint64()]]
+}
+
+// Generator writes datasets into a database
+type Generator struct {
+	db  *db.DB
+	rng *rand.Rand
+	now time.Time
+}
+
+// New returns a generator drawing from rng. Message history ends at now.
+func New(d *db.DB, rng *rand.Rand, now time.Time) *Generator {
+	return &Generator{db: d, rng: rng, now: now.UTC()}
+}
+
+// Populate creates the users and conversations of spec
+func (g *Generator) Populate(ctx context.Context, spec Spec) (*Dataset, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	ds := &Dataset{}
+
+	for i := 0; i < spec.Users; i++ {
+		username := fmt.Sprintf("%s%d", spec.NamePrefix, i+1)
+		if i < len(spec.Names) {
+			username = spec.Names[i]
+		}
+		user, err := g.db.CreateUser(ctx, username, spec.PasswordHash, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create user %s: %w", username, err)
+		}
+		ds.Users = append(ds.Users, user)
+	}
+
+	for created := 0; created < spec.Directs; {
+		pair := g.rng.Perm(len(ds.Users))[:2]
+		a, b := ds.Users[pair[0]], ds.Users[pair[1]]
+		conv, isNew, err := g.db.GetOrCreateDirectConversation(ctx, a.ID, b.ID, a.Username)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create direct conversation: %w", err)
+		}
+		if isNew {
+			ds.Conversations = append(ds.Conversations, Conversation{ID: conv.ID, Type: "direct", Participants: []int64{a.ID, b.ID}})
+			created++
+		}
+	}
+
+	maxGroup := spec.MaxGroupSize
+	if maxGroup > spec.Users {
+		maxGroup = spec.Users
+	}
+	if maxGroup < 3 {
+		maxGroup = 3
+	}
+	groupSize := rand.NewZipf(g.rng, 1.5, 4, uint64(maxGroup-3))
+	for i := 0; i < spec.Groups; i++ {
+		var participants []int64
+		for _, j := range g.rng.Perm(len(ds.Users))[:3+int(groupSize.Uint64())] {
+			participants = append(participants, ds.Users[j].ID)
+		}
+		name := groupNames[i%len(groupNames)]
+		if i >= len(groupNames) {
+			name = fmt.Sprintf("%s %d", name, i/len(groupNames)+1)
+		}
+		conv, err := g.db.CreateConversation(ctx, name, "group", participants[0], participants)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create group %s: %w", name, err)
+		}
+		ds.Conversations = append(ds.Conversations, Conversation{ID: conv.ID, Type: "group", Participants: participants})
+	}
+
+	if len(ds.Conversations) > 0 {
+		ds.traffic = rand.NewZipf(g.rng, 1.1, 1, uint64(len(ds.Conversations)-1))
+		ds.order = g.rng.Perm(len(ds.Conversations))
+	}
+	return ds, nil
+}
+
+// AddHistory adds spec.Messages messages to ds's conversations, in time
+// order, over the spec.History before now. Every conversation gets at
+// least one burst while there are messages to go round.
+func (g *Generator) AddHistory(ctx context.Context, ds *Dataset, spec Spec) error {
+	type burst struct {
+		at           time.Time
+		conversation int
+		size         int
+	}
+	var bursts []burst
+	for planned := 0; planned < spec.Messages; {
+		b := burst{at: g.burstStart(spec.History), size: 1 + g.rng.Intn(6)}
+		if len(bursts) < len(ds.Conversations) {
+			b.conversation = len(bursts)
+		} else {
+			b.conversation = ds.order[ds.traffic.Uint64()]
+		}
+		if b.size > spec.Messages-planned {
+			b.size = spec.Messages - planned
+		}
+		bursts = append(bursts, b)
+		planned += b.size
+	}
+
+	type message struct {
+		at           time.Time
+		conversation int
+	}
+	messages := make([]message, 0, spec.Messages)
+	for _, b := range bursts {
+		at := b.at
+		for i := 0; i < b.size; i++ {
+			messages = append(messages, message{at: at, conversation: b.conversation})
+			at = at.Add(time.Duration(10+g.rng.Intn(180)) * time.Second)
+			if at.After(g.now) {
+				at = g.now
+			}
+		}
+	}
+	// A conversation's messages must be created in time order, and keeping
+	// the whole history in order makes ids follow time the way they do live
+	sort.SliceStable(messages, func(i, j int) bool { return messages[i].at.Before(messages[j].at) })
+
+	batch := make([]*models.Message, 0, importBatch)
+	for i, m := range messages {
+		conv := ds.Conversations[m.conversation]
+		batch = append(batch, &models.Message{
+			ConversationID: conv.ID,
+			SenderID:       conv.Participants[g.rng.Intn(len(conv.Participants))],
+			Content:        Content(g.rng),
+			CreatedAt:      m.at,
+		})
+		if len(batch) == importBatch || i == len(messages)-1 {
+			if err := g.db.ImportMessages(ctx, batch); err != nil {
+				return err
+			}
+			ds.Messages += len(batch)
+			batch = batch[:0]
+		}
+	}
+	return nil
+}
+
+// burstStart picks a day within span and a time between 8:00 and 23:00 on
+// it, no earlier than span before now and no later than now
+func (g *Generator) burstStart(span time.Duration) time.Time {
+	day := g.now.Add(-time.Duration(g.rng.Int63n(int64(span) + 1))).Truncate(24 * time.Hour)
+	at := day.Add(8*time.Hour + time.Duration(g.rng.Int63n(int64(15*time.Hour))))
+	if at.After(g.now) {
+		at = g.now.Add(-time.Duration(g.rng.Int63n(int64(time.Hour))))
+	}
+	if earliest := g.now.Add(-span); at.Before(earliest) {
+		at = earliest
+	}
+	return at
+}
+
+var groupNames = []string{
+	"Team", "Weekend plans", "Book club", "Release crew", "Lunch", "Design review",
+	"Climbing", "Family", "On-call", "Board games", "Reading group", "Hackathon",
+}
+
+var phrases = []string{
+	"hey!", "morning", "ok", "sounds good", "thanks!", "on my way",
+	"can you take a look at this when you get a chance?",
+	"just pushed the fix, let me know if it works for you",
+	"lunch at 12?", "running 5 minutes late", "haha", "👍",
+	"did you see the email from yesterday?", "sure, let's do it",
+	"I'll be offline for a bit", "what time works tomorrow?",
+	"the deploy went out fine", "nice work", "can we move the call to 3?",
+	"I think we should ship it as is and follow up next week",
+}
+
+var words = strings.Fields(`the a to and of you it is that in for on this we be have
+	are not with just can so will what do at meeting lunch tomorrow today thanks ok sure
+	sounds good see later deploy review branch ticket fixed broken coffee call now soon`)
+
+// Content returns a chat-like message. Lengths follow the short-heavy mix
+// seen in practice: a stock phrase or a few words most of the time, and a
+// paragraph now and then.
+func Content(rng *rand.Rand) string {
+	if rng.Intn(2) == 0 {
+		return phrases[rng.Intn(len(phrases))]
+	}
+	n := 1 + int(rng.ExpFloat64()*8)
+	if n > 120 {
+		n = 120
+	}
+	parts := make([]string, n)
+	for i := range parts {
+		parts[i] = words[rng.Intn(len(words))]
+	}
+	return strings.Join(parts, " ")
+}
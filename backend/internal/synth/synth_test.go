@@ -0,0 +1,201 @@
+package synth
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"messager/internal/db"
+	"messager/internal/db/testdb"
+)
+
+var testSpec = Spec{
+	Users:        12,
+	Directs:      8,
+	Groups:       4,
+	MaxGroupSize: 6,
+	Messages:     300,
+	History:      14 * 24 * time.Hour,
+	Names:        []string{"alice", "bob"},
+	NamePrefix:   "user",
+}
+
+var testNow = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func generate(t *testing.T, d *db.DB, seed int64, spec Spec) *Dataset {
+	t.Helper()
+	gen := New(d, rand.New(rand.NewSource(seed)), testNow)
+	ds, err := gen.Populate(context.Background(), spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gen.AddHistory(context.Background(), ds, spec); err != nil {
+		t.Fatal(err)
+	}
+	return ds
+}
+
+func count(t *testing.T, d *db.DB, query string, args ...interface{}) int {
+	t.Helper()
+	var n int
+	if err := d.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestGenerateCounts(t *testing.T) {
+	d := testdb.Open(t)
+	ds := generate(t, d, 1, testSpec)
+
+	if len(ds.Users) != testSpec.Users || ds.Users[0].Username != "alice" || ds.Users[2].Username != "user3" {
+		t.Errorf("users = %d, first %q, third %q", len(ds.Users), ds.Users[0].Username, ds.Users[2].Username)
+	}
+	if n := count(t, d, `SELECT COUNT(*) FROM users`); n != testSpec.Users {
+		t.Errorf("%d users stored, want %d", n, testSpec.Users)
+	}
+	if n := count(t, d, `SELECT COUNT(*) FROM conversations WHERE type = 'direct'`); n != testSpec.Directs {
+		t.Errorf("%d direct conversations, want %d", n, testSpec.Directs)
+	}
+	if n := count(t, d, `SELECT COUNT(*) FROM conversations WHERE type = 'group'`); n != testSpec.Groups {
+		t.Errorf("%d groups, want %d", n, testSpec.Groups)
+	}
+	if ds.Messages != testSpec.Messages {
+		t.Errorf("dataset counts %d messages, want %d", ds.Messages, testSpec.Messages)
+	}
+	if n := count(t, d, `SELECT COUNT(*) FROM messages`); n != testSpec.Messages {
+		t.Errorf("%d messages stored, want %d", n, testSpec.Messages)
+	}
+
+	for _, conv := range ds.Conversations {
+		if conv.Type == "group" && (len(conv.Participants) < 3 || len(conv.Participants) > testSpec.MaxGroupSize) {
+			t.Errorf("group %d has %d participants, want 3 to %d", conv.ID, len(conv.Participants), testSpec.MaxGroupSize)
+		}
+		if n := count(t, d, `SELECT COUNT(*) FROM conversation_participants WHERE conversation_id = ?`, conv.ID); n != len(conv.Participants) {
+			t.Errorf("conversation %d stores %d participants, want %d", conv.ID, n, len(conv.Participants))
+		}
+		// Every conversation gets messages, numbered without gaps
+		var messages, maxSeq int
+		if err := d.QueryRow(`SELECT COUNT(*), COALESCE(MAX(seq), 0) FROM messages WHERE conversation_id = ?`, conv.ID).Scan(&messages, &maxSeq); err != nil {
+			t.Fatal(err)
+		}
+		if messages == 0 || maxSeq != messages {
+			t.Errorf("conversation %d has %d messages with max seq %d", conv.ID, messages, maxSeq)
+		}
+	}
+}
+
+func TestGenerateHistoryWindow(t *testing.T) {
+	d := testdb.Open(t)
+	generate(t, d, 1, testSpec)
+
+	rows, err := d.Query(`SELECT created_at FROM messages ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var previous time.Time
+	for rows.Next() {
+		var at time.Time
+		if err := rows.Scan(&at); err != nil {
+			t.Fatal(err)
+		}
+		if at.Before(testNow.Add(-testSpec.History)) || at.After(testNow) {
+			t.Errorf("message at %v is outside the history window", at)
+		}
+		if at.Before(previous) {
+			t.Errorf("message at %v was inserted after one at %v", at, previous)
+		}
+		previous = at
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGenerateRepeatable(t *testing.T) {
+	contents := func(seed int64) []string {
+		d := testdb.Open(t)
+		generate(t, d, seed, testSpec)
+		rows, err := d.Query(`SELECT conversation_id || ':' || sender_id || ':' || content FROM messages ORDER BY id`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var out []string
+		for rows.Next() {
+			var s string
+			if err := rows.Scan(&s); err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, s)
+		}
+		return out
+	}
+
+	first, again, other := contents(7), contents(7), contents(8)
+	if len(first) != len(again) {
+		t.Fatalf("same seed gave %d and %d messages", len(first), len(again))
+	}
+	for i := range first {
+		if first[i] != again[i] {
+			t.Fatalf("same seed differs at message %d: %q and %q", i, first[i], again[i])
+		}
+	}
+	same := len(first) == len(other)
+	for i := 0; same && i < len(first); i++ {
+		same = first[i] == other[i]
+	}
+	if same {
+		t.Error("a different seed gave the same history")
+	}
+}
+
+func TestSpecValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		spec Spec
+		ok   bool
+	}{
+		{"valid", testSpec, true},
+		{"one user", Spec{Users: 1}, false},
+		{"too many directs", Spec{Users: 3, Directs: 4}, false},
+		{"every pair", Spec{Users: 3, Directs: 3}, true},
+		{"group of two", Spec{Users: 2, Groups: 1}, false},
+		{"messages nowhere", Spec{Users: 2, Messages: 1}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.spec.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v", tt.name, err)
+		}
+	}
+
+	gen := New(testdb.Open(t), rand.New(rand.NewSource(1)), testNow)
+	if _, err := gen.Populate(context.Background(), Spec{Users: 1}); err == nil {
+		t.Error("Populate accepted an invalid spec")
+	}
+}
+
+func TestPickSkewed(t *testing.T) {
+	d := testdb.Open(t)
+	gen := New(d, rand.New(rand.NewSource(1)), testNow)
+	ds, err := gen.Populate(context.Background(), Spec{Users: 20, Directs: 40})
+	if err != nil {
+		t.Fatal(err)
+	}
+	picks := make(map[int64]int)
+	for i := 0; i < 2000; i++ {
+		picks[ds.Pick().ID]++
+	}
+	busiest := 0
+	for _, n := range picks {
+		if n > busiest {
+			busiest = n
+		}
+	}
+	// Uniform picks would give each conversation about 50
+	if busiest < 200 {
+		t.Errorf("busiest conversation picked %d times out of 2000, want traffic skewed", busiest)
+	}
+}
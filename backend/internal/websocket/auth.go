@@ -0,0 +1,105 @@
+package websocket
+
+import (
+	"context"
+	"time"
+
+	"messager/internal/models"
+)
+
+const (
+	// authCheckInterval is how often the hub looks for sessions about to
+	// lapse or already lapsed
+	authCheckInterval = 30 * time.Second
+	// authExpiryWarning is how long before a session lapses its connections
+	// are sent "auth_expiring"
+	authExpiryWarning = 5 * time.Minute
+)
+
+// Session is what a valid session token says about its holder
+type Session struct {
+	UserID    int64
+	DeviceID  int64 // 0 when the session isn't bound to a device
+	ExpiresAt time.Time
+}
+
+// TokenValidator checks a session token, as presented in "auth_refresh"
+type TokenValidator func(ctx context.Context, token string) (Session, error)
+
+// SetTokenValidator lets connections extend their session with
+// "auth_refresh". It must be called before the hub starts serving.
+func (h *Hub) SetTokenValidator(validate TokenValidator) {
+	h.validateToken = validate
+}
+
+// SetSessionExpiry records when the session behind the connection lapses.
+// A zero time, as for bots, never lapses.
+func (c *Client) SetSessionExpiry(expiresAt time.Time) {
+	if expiresAt.IsZero() {
+		c.expiresAt.Store(0)
+		return
+	}
+	c.expiresAt.Store(expiresAt.UnixNano())
+}
+
+// checkSessions warns connections whose session is about to lapse and
+// disconnects those whose session has
+func (h *Hub) checkSessions(now time.Time) {
+	var expired, expiring []*Client
+	h.mu.RLock()
+	for client := range h.clients {
+		exp := client.expiresAt.Load()
+		if exp == 0 {
+			continue
+		}
+		expiresAt := time.Unix(0, exp)
+		switch {
+		case !now.Before(expiresAt):
+			expired = append(expired, client)
+		case expiresAt.Sub(now) <= authExpiryWarning && !client.expiryWarned.Swap(true):
+			expiring = append(expiring, client)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, client := range expiring {
+		client.queueEvent("ws.auth_expiring", models.WebSocketMessage{
+			Type:    "auth_expiring",
+			Payload: map[string]interface{}{"expires_at": time.Unix(0, client.expiresAt.Load()).UTC()},
+		})
+	}
+	if len(expired) > 0 {
+		h.logger.Printf("Closing %d connections whose session expired", len(expired))
+		h.disconnect(expired, func(c *Client) { c.expired.Store(true) })
+	}
+}
+
+// handleAuthRefresh swaps in a fresh session token for the same user, so a
+// long-lived connection outlasts the token it was opened with
+func (c *Client) handleAuthRefresh(req models.AuthRefresh) {
+	if c.hub.validateToken == nil || c.expiresAt.Load() == 0 {
+		c.sendError("auth_refresh_unsupported", "This connection's session doesn't expire", nil)
+		return
+	}
+	if req.Token == "" {
+		c.sendError("invalid_token", "token is required", nil)
+		return
+	}
+
+	session, err := c.hub.validateToken(c.ctx, req.Token)
+	if err != nil {
+		c.sendError("invalid_token", "Token is invalid or expired", nil)
+		return
+	}
+	if session.UserID != c.userID || (session.DeviceID != 0 && session.DeviceID != c.deviceID) {
+		c.sendError("invalid_token", "Token belongs to a different session", nil)
+		return
+	}
+
+	c.SetSessionExpiry(session.ExpiresAt)
+	c.expiryWarned.Store(false)
+	c.queueEvent("ws.auth_refreshed", models.WebSocketMessage{
+		Type:    "auth_refreshed",
+		Payload: map[string]interface{}{"expires_at": session.ExpiresAt.UTC()},
+	})
+}
@@ -0,0 +1,217 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"messager/internal/db"
+	"messager/internal/delivery"
+	"messager/internal/errsink"
+	"messager/internal/models"
+)
+
+const (
+	botWebhookTimeout  = 10 * time.Second
+	maxBotReplyBytes   = 64 << 10
+	botDestinationName = "bot:"
+)
+
+// CommandPattern is what a registered command name must look like, without
+// the leading slash
+var CommandPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,31}$`)
+
+// botRouter hands commands to the bots that registered them. A connected bot
+// gets a "bot_command" event on its websocket; otherwise the invocation is
+// POSTed to its webhook through a per-bot delivery queue, and a reply in the
+// response body is posted back as the bot.
+type botRouter struct {
+	hub        *Hub
+	dispatcher *delivery.Dispatcher
+	client     *http.Client
+}
+
+func newBotRouter(h *Hub, limits delivery.Limits) *botRouter {
+	return &botRouter{
+		hub:        h,
+		dispatcher: delivery.NewDispatcher(limits),
+		client:     &http.Client{Timeout: botWebhookTimeout},
+	}
+}
+
+// ParseCommand splits "/remind me in 5m" into "remind", ["me", "in", "5m"]
+// and "me in 5m". ok is false when content isn't a command.
+func ParseCommand(content string) (command string, args []string, text string, ok bool) {
+	if !strings.HasPrefix(content, "/") {
+		return "", nil, "", false
+	}
+	command, text, _ = strings.Cut(content[1:], " ")
+	command = strings.ToLower(command)
+	if !CommandPattern.MatchString(command) {
+		return "", nil, "", false
+	}
+	text = strings.TrimSpace(text)
+	return command, strings.Fields(text), text, true
+}
+
+// RouteCommand delivers message to the bot owning its command, if the
+// message is one and that bot is a participant. Everyone, the bot included,
+// has already received the message itself; this only adds the parsed
+// invocation. Bots' own messages should not be routed, so bots can't drive
+// each other in loops.
+func (h *Hub) RouteCommand(message *models.Message, senderName string, participants []int64) {
+	command, args, text, ok := ParseCommand(message.Content)
+	if !ok {
+		return
+	}
+
+	botID, webhookURL, err := h.db.CommandBot(h.ctx, command)
+	if errors.Is(err, db.ErrNotFound) {
+		return
+	}
+	if err != nil {
+		h.logger.Printf("Failed to route command /%s: %v", command, err)
+		return
+	}
+	if !contains(participants, botID) {
+		return
+	}
+
+	invocation := models.BotInvocation{
+		ConversationID: message.ConversationID,
+		MessageID:      message.ID,
+		SenderID:       message.SenderID,
+		SenderUsername: senderName,
+		Command:        command,
+		Args:           args,
+		Text:           text,
+		CreatedAt:      message.CreatedAt,
+	}
+
+	if h.IsConnected(botID) {
+		h.SendToUser(botID, models.WebSocketMessage{Type: "bot_command", Payload: invocation})
+		return
+	}
+	if webhookURL == "" {
+		h.logger.Printf("Bot %d is offline and has no webhook, dropping /%s", botID, command)
+		return
+	}
+	h.bots.enqueueWebhook(botID, webhookURL, invocation)
+}
+
+// IsConnected reports whether the user has a live websocket
+func (h *Hub) IsConnected(userID int64) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.userMap[userID]) > 0
+}
+
+// BotDeliveryStats exposes the per-bot webhook counters for the admin endpoint
+func (h *Hub) BotDeliveryStats() map[string]delivery.Stats {
+	return h.bots.dispatcher.Stats()
+}
+
+func (r *botRouter) enqueueWebhook(botID int64, url string, invocation models.BotInvocation) {
+	body, err := json.Marshal(invocation)
+	if err != nil {
+		r.hub.logger.Printf("Failed to marshal bot invocation: %v", err)
+		r.hub.errs.Swallow(errsink.Marshal, "bots.invocation", err)
+		return
+	}
+
+	destination := botDestinationName + strconv.FormatInt(botID, 10)
+	r.dispatcher.Enqueue(destination, func() error {
+		reply, err := r.callWebhook(url, body)
+		if err != nil {
+			r.hub.logger.Printf("Bot %d webhook failed: %v", botID, err)
+			return err
+		}
+		if reply.Content != "" {
+			r.hub.postBotReply(botID, invocation.ConversationID, reply.Content)
+		}
+		return nil
+	})
+}
+
+func (r *botRouter) callWebhook(url string, body []byte) (models.BotReply, error) {
+	var reply models.BotReply
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return reply, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return reply, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return reply, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBotReplyBytes))
+	if err != nil {
+		return reply, err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return reply, nil
+	}
+	// A body that isn't a reply is not worth retrying the command for
+	if err := json.Unmarshal(data, &reply); err != nil {
+		r.hub.logger.Printf("Ignoring malformed reply from %s: %v", url, err)
+	}
+	return reply, nil
+}
+
+// postBotReply sends a webhook's reply through the normal message path,
+// attributed to the bot and subject to the bot rate limit
+func (h *Hub) postBotReply(botID, conversationID int64, content string) {
+	if allowed, _ := h.AllowSend(botID, true); !allowed {
+		h.logger.Printf("Bot %d is over its rate limit, dropping reply in conversation %d", botID, conversationID)
+		return
+	}
+
+	isParticipant, err := h.db.IsConversationParticipant(h.ctx, conversationID, botID)
+	if err != nil || !isParticipant {
+		return
+	}
+	username, err := h.db.Username(h.ctx, botID)
+	if err != nil {
+		h.logger.Printf("Failed to look up bot %d: %v", botID, err)
+		return
+	}
+
+	message, duplicate, err := h.CreateMessage(conversationID, botID, username, content)
+	if err != nil {
+		h.logger.Printf("Failed to save reply from bot %d: %v", botID, err)
+		return
+	}
+	if duplicate {
+		return
+	}
+	participants, err := h.participantIDs(h.ctx, conversationID)
+	if err != nil {
+		h.logger.Printf("Failed to get conversation participants: %v", err)
+		return
+	}
+	h.DeliverMessage(message, participants)
+}
+
+func contains(ids []int64, id int64) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"messager/internal/db"
+	"messager/internal/models"
+)
+
+// newBot creates a bot owning command and adds it to conversationID
+func newBot(t *testing.T, d *db.DB, username, webhookURL, command string, conversationID int64) *models.User {
+	t.Helper()
+	ctx := context.Background()
+	bot, err := d.CreateBot(ctx, username, "", webhookURL, "hash-"+username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conflicts, err := d.SetBotCommands(ctx, bot.ID, []models.BotCommand{{Command: command}}); err != nil || conflicts != nil {
+		t.Fatalf("register /%s: %v, %v", command, conflicts, err)
+	}
+	if _, err := d.AddConversationParticipants(ctx, conversationID, []int64{bot.ID}); err != nil {
+		t.Fatal(err)
+	}
+	return &models.User{ID: bot.ID, Username: username, IsBot: true}
+}
+
+// readContent reads until the "message" frame with content arrives
+func readContent(t *testing.T, conn *testConn, content string) frame {
+	t.Helper()
+	for {
+		if f := readUntil(t, conn, "message"); f.Payload["content"] == content {
+			return f
+		}
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	for _, tc := range []struct {
+		content, command, text string
+		args                   []string
+		ok                     bool
+	}{
+		{"/remind me  in 5m", "remind", "me  in 5m", []string{"me", "in", "5m"}, true},
+		{"/Poll", "poll", "", []string{}, true},
+		{"remind me", "", "", nil, false},
+		{"/ remind", "", "", nil, false},
+		{"/path/to/file", "", "", nil, false},
+	} {
+		command, args, text, ok := ParseCommand(tc.content)
+		if ok != tc.ok || command != tc.command || text != tc.text || !reflect.DeepEqual(args, tc.args) {
+			t.Errorf("ParseCommand(%q) = %q, %q, %q, %t", tc.content, command, args, text, ok)
+		}
+	}
+}
+
+func TestCommandsRouteToAConnectedBot(t *testing.T) {
+	h, d, f := newTestHub(t, nil)
+	startHub(t, h)
+	bot := newBot(t, d, "remindbot", "", "remind", f.Group.ID)
+	botConn, _ := dial(t, h, bot)
+	alice, _ := dial(t, h, f.Alice)
+	bob, _ := dial(t, h, f.Bob)
+
+	// Everyone gets the message itself; the bot also gets it parsed
+	sendMessage(t, alice, f.Group.ID, "/remind me in 5m")
+	readMessage(t, bob, "/remind me in 5m")
+	invocation := readUntil(t, botConn, "bot_command").Payload
+	if invocation["command"] != "remind" || invocation["text"] != "me in 5m" || invocation["sender_username"] != "alice" {
+		t.Fatalf("invocation = %v", invocation)
+	}
+	if args, _ := invocation["args"].([]interface{}); len(args) != 3 {
+		t.Errorf("args = %v, want three", invocation["args"])
+	}
+
+	// The reply goes through the normal send path, as the bot. Bots' own
+	// messages aren't routed, so one can't trigger itself.
+	sendMessage(t, botConn, f.Group.ID, "/remind done")
+	for _, conn := range []*testConn{alice, bob} {
+		if reply := readContent(t, conn, "/remind done"); reply.Payload["sender_id"] != float64(bot.ID) {
+			t.Errorf("reply = %v", reply.Payload)
+		}
+	}
+	expectNoFrameOfType(t, botConn, "bot_command", 100*time.Millisecond)
+
+	// Not a command, or a command in a conversation without the bot
+	sendMessage(t, alice, f.Group.ID, "remind me")
+	sendMessage(t, alice, f.Direct.ID, "/remind me")
+	readMessage(t, alice, "/remind me")
+	expectNoFrameOfType(t, botConn, "bot_command", 100*time.Millisecond)
+}
+
+func TestCommandsRouteToAnOfflineBotsWebhook(t *testing.T) {
+	invocations := make(chan models.BotInvocation, 1)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var invocation models.BotInvocation
+		json.NewDecoder(r.Body).Decode(&invocation)
+		invocations <- invocation
+		json.NewEncoder(w).Encode(models.BotReply{Content: "reminder set"})
+	}))
+	t.Cleanup(webhook.Close)
+
+	h, d, f := newTestHub(t, nil)
+	startHub(t, h)
+	bot := newBot(t, d, "remindbot", webhook.URL, "remind", f.Group.ID)
+	alice, _ := dial(t, h, f.Alice)
+
+	sendMessage(t, alice, f.Group.ID, "/remind standup")
+	select {
+	case invocation := <-invocations:
+		if invocation.Command != "remind" || invocation.Text != "standup" || invocation.SenderID != f.Alice.ID {
+			t.Errorf("webhook got %+v", invocation)
+		}
+	case <-time.After(frameWait):
+		t.Fatal("the webhook was never called")
+	}
+	if reply := readContent(t, alice, "reminder set"); reply.Payload["sender_id"] != float64(bot.ID) {
+		t.Errorf("reply posted by %v, want the bot", reply.Payload["sender_id"])
+	}
+}
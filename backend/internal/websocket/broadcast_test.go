@@ -0,0 +1,77 @@
+package websocket
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"messager/internal/config"
+	"messager/internal/models"
+)
+
+func TestBroadcastQueueFullDrops(t *testing.T) {
+	// Run isn't started, so nothing drains the queue
+	h, _, _ := newTestHub(t, nil)
+
+	done := make(chan struct{})
+	var errs []error
+	go func() {
+		defer close(done)
+		for i := 0; i < broadcastQueueSize+3; i++ {
+			errs = append(errs, h.BroadcastMessage(models.WebSocketMessage{Type: "notice"}))
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(frameWait):
+		t.Fatal("BroadcastMessage blocked on a full queue")
+	}
+
+	for i, err := range errs {
+		if want := i >= broadcastQueueSize; errors.Is(err, ErrBroadcastDropped) != want {
+			t.Errorf("broadcast %d: %v", i, err)
+		}
+	}
+	if n := h.Stats().BroadcastsDropped; n != 3 {
+		t.Errorf("%d broadcasts counted as dropped, want 3", n)
+	}
+}
+
+func TestBroadcastEvictsSlowClient(t *testing.T) {
+	h, _, f := newTestHub(t, func(cfg *config.Config) {
+		cfg.WSSendBuffer = 4
+		cfg.WSSlowClientPolicy = slowClientDisconnect
+	})
+	startHub(t, h)
+	alice, _ := dial(t, h, f.Alice)
+	bob, _, release := dialStalled(t, h, f.Bob)
+
+	const sent = 8
+	for i := 0; i < sent; i++ {
+		if err := h.BroadcastMessage(models.WebSocketMessage{Type: "notice"}); err != nil {
+			t.Fatal(err)
+		}
+		readUntil(t, alice, "notice")
+	}
+	waitFor(t, "bob to be evicted", func() bool { return h.Stats().Evictions == 1 })
+
+	stats := h.Stats()
+	if stats.Broadcasts != sent || stats.BroadcastsDropped != 0 {
+		t.Errorf("broadcasts %d, dropped %d, want %d and none", stats.Broadcasts, stats.BroadcastsDropped, sent)
+	}
+	if stats.Dropped == 0 {
+		t.Error("no frame was counted as dropped for the full queue")
+	}
+	if stats.ConnectedClients != 1 {
+		t.Errorf("%d clients connected, want only alice", stats.ConnectedClients)
+	}
+
+	release()
+	if code := readClose(t, bob); code != CloseTooSlow {
+		t.Errorf("slow client closed with %d, want %d", code, CloseTooSlow)
+	}
+	// Eviction happened once, however many broadcasts found the queue full
+	if n := h.Stats().Evictions; n != 1 {
+		t.Errorf("%d evictions, want 1", n)
+	}
+}
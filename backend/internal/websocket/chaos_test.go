@@ -0,0 +1,350 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"messager/internal/bus"
+	"messager/internal/chaos"
+	"messager/internal/config"
+)
+
+// Resilience scenarios: each injects a fault, checks that no message the
+// sender saw accepted is lost and that the hub keeps serving, then lifts
+// the fault and checks that it recovers.
+
+func sendMessage(t *testing.T, conn *testConn, conversationID int64, content string) {
+	t.Helper()
+	send(t, conn, "message", map[string]interface{}{"conversation_id": conversationID, "content": content})
+}
+
+// readMessage reads until the "message" frame with content arrives,
+// returning its seq
+func readMessage(t *testing.T, conn *testConn, content string) int64 {
+	t.Helper()
+	for {
+		f := readUntil(t, conn, "message")
+		if f.Payload["content"] == content {
+			seq, _ := f.Payload["seq"].(float64)
+			return int64(seq)
+		}
+	}
+}
+
+// storedContents returns the content of every message in a conversation
+func storedContents(t *testing.T, h *Hub, conversationID int64) map[string]int {
+	t.Helper()
+	messages, err := h.db.GetConversationMessages(context.Background(), conversationID, 1000, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := make(map[string]int)
+	for _, m := range messages {
+		contents[m.Content]++
+	}
+	return contents
+}
+
+func TestChaosDatabaseOutage(t *testing.T) {
+	h, d, f := newTestHub(t, nil)
+	injector := chaos.New()
+	d.SetChaos(injector)
+	h.SetChaos(injector)
+	startHub(t, h)
+	alice, _ := dial(t, h, f.Alice)
+	bob, _ := dial(t, h, f.Bob)
+
+	// Every named statement fails while the database is "down"
+	if err := injector.SetProfile(chaos.Profile{DB: map[string]chaos.DBFault{chaos.AnyStatement: {Probability: 1}}}); err != nil {
+		t.Fatal(err)
+	}
+	sendMessage(t, alice, f.Direct.ID, "lost in the outage")
+	if got := readUntil(t, alice, "error"); got.Payload["code"] != "save_failed" {
+		t.Fatalf("sender got %v, want save_failed", got.Payload)
+	}
+	expectNoFrameOfType(t, bob, "message", 100*time.Millisecond)
+	if injector.Stats().DBErrors == 0 {
+		t.Error("no database faults were injected")
+	}
+
+	// Back up: the retry goes through exactly once, and the hub still serves
+	// the same connections
+	injector.SetProfile(chaos.Profile{})
+	sendMessage(t, alice, f.Direct.ID, "retried")
+	readMessage(t, alice, "retried")
+	readMessage(t, bob, "retried")
+
+	stored := storedContents(t, h, f.Direct.ID)
+	if stored["lost in the outage"] != 0 || stored["retried"] != 1 {
+		t.Errorf("stored %v, want only the retry, once", stored)
+	}
+	if h.ClientCount() != 2 {
+		t.Errorf("%d clients connected, want both to survive the outage", h.ClientCount())
+	}
+}
+
+func TestChaosBusPartition(t *testing.T) {
+	broker := newFakeRedis(t)
+	first, d, f := newTestHub(t, nil)
+	second := NewHub(d, config.Load())
+	for _, h := range []*Hub{first, second} {
+		b, err := bus.Open("redis://"+broker.addr(), "chaos-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { b.Close() })
+		h.SetBus(b)
+		startHub(t, h)
+	}
+	alice, _ := dial(t, first, f.Alice)
+	bob, _ := dial(t, second, f.Bob)
+	waitFor(t, "both instances to subscribe", func() bool { return broker.subscribers() == 2 })
+
+	sendMessage(t, alice, f.Direct.ID, "before")
+	before := readMessage(t, bob, "before")
+
+	// Cut both instances off from the broker. Each keeps serving its own
+	// connections, and nothing blocks on the bus.
+	broker.partition()
+	waitFor(t, "the subscriptions to drop", func() bool { return broker.subscribers() == 0 })
+	sendMessage(t, alice, f.Direct.ID, "during")
+	readMessage(t, alice, "during")
+
+	broker.heal()
+	waitFor(t, "both instances to resubscribe", func() bool { return broker.subscribers() == 2 })
+	sendMessage(t, alice, f.Direct.ID, "after")
+	after := readMessage(t, bob, "after")
+
+	// Bob may have missed "during", but it was saved, and the seq gap tells
+	// his client what to fetch
+	if after != before+2 {
+		t.Fatalf("seq went from %d to %d, want one message in between", before, after)
+	}
+	missed, err := d.GetMessagesAfterSeq(context.Background(), f.Direct.ID, before, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missed) != 2 || missed[0].Content != "during" {
+		t.Errorf("gap repair returned %+v, want the message sent during the partition", missed)
+	}
+}
+
+func TestChaosSlowConsumer(t *testing.T) {
+	const sent = 10
+	configure := func(policy string) func(*config.Config) {
+		return func(cfg *config.Config) {
+			cfg.WSSendBuffer = 4
+			cfg.WSSlowClientPolicy = policy
+			cfg.MessageRateBurst = 2 * sent
+		}
+	}
+
+	t.Run("disconnect", func(t *testing.T) {
+		h, _, f := newTestHub(t, configure(slowClientDisconnect))
+		startHub(t, h)
+		alice, _ := dial(t, h, f.Alice)
+		carol, _ := dial(t, h, f.Carol)
+		bob, _, release := dialStalled(t, h, f.Bob)
+
+		for i := 0; i < sent; i++ {
+			sendMessage(t, alice, f.Group.ID, strconv.Itoa(i))
+		}
+		// Everyone keeping up gets everything, and the sender sees each
+		// message accepted
+		for i := 0; i < sent; i++ {
+			readMessage(t, alice, strconv.Itoa(i))
+			readMessage(t, carol, strconv.Itoa(i))
+		}
+		waitFor(t, "bob to be evicted", func() bool { return h.counters.evictions.Load() == 1 })
+		release()
+		if code := readClose(t, bob); code != CloseTooSlow {
+			t.Fatalf("slow consumer closed with %d, want %d", code, CloseTooSlow)
+		}
+		if stored := storedContents(t, h, f.Group.ID); len(stored) != sent+2 {
+			t.Errorf("stored %d distinct messages, want the %d seeded and %d sent", len(stored), 2, sent)
+		}
+
+		// Reconnecting gets bob going again
+		bob, _ = dial(t, h, f.Bob)
+		sendMessage(t, alice, f.Group.ID, "welcome back")
+		readMessage(t, bob, "welcome back")
+	})
+
+	t.Run("drop-oldest", func(t *testing.T) {
+		h, _, f := newTestHub(t, configure(slowClientDropOldest))
+		startHub(t, h)
+		alice, _ := dial(t, h, f.Alice)
+		bob, _, release := dialStalled(t, h, f.Bob)
+
+		for i := 0; i < sent; i++ {
+			sendMessage(t, alice, f.Group.ID, strconv.Itoa(i))
+			readMessage(t, alice, strconv.Itoa(i))
+		}
+		release()
+		// Bob is told to resync, then gets the newest frames that fit
+		readUntil(t, bob, "resync_required")
+		readMessage(t, bob, strconv.Itoa(sent-1))
+		if h.counters.evictions.Load() != 0 || h.ClientCount() != 2 {
+			t.Errorf("evictions = %d with %d clients, want bob kept connected", h.counters.evictions.Load(), h.ClientCount())
+		}
+	})
+}
+
+// fakeRedis is a pub/sub broker speaking the RESP subset the bus uses. A
+// partition closes every connection and refuses new ones until heal.
+type fakeRedis struct {
+	ln net.Listener
+
+	mu          sync.Mutex
+	partitioned bool
+	conns       map[net.Conn]*sync.Mutex // each with its write lock
+	subscribed  map[net.Conn]bool
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &fakeRedis{ln: ln, conns: make(map[net.Conn]*sync.Mutex), subscribed: make(map[net.Conn]bool)}
+	t.Cleanup(func() {
+		ln.Close()
+		r.partition()
+	})
+	go r.serve()
+	return r
+}
+
+func (r *fakeRedis) addr() string {
+	return r.ln.Addr().String()
+}
+
+func (r *fakeRedis) subscribers() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.subscribed)
+}
+
+func (r *fakeRedis) partition() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.partitioned = true
+	for conn := range r.conns {
+		conn.Close()
+	}
+}
+
+func (r *fakeRedis) heal() {
+	r.mu.Lock()
+	r.partitioned = false
+	r.mu.Unlock()
+}
+
+func (r *fakeRedis) serve() {
+	for {
+		conn, err := r.ln.Accept()
+		if err != nil {
+			return
+		}
+		r.mu.Lock()
+		if r.partitioned {
+			r.mu.Unlock()
+			conn.Close()
+			continue
+		}
+		r.conns[conn] = &sync.Mutex{}
+		r.mu.Unlock()
+		go r.handle(conn)
+	}
+}
+
+func (r *fakeRedis) handle(conn net.Conn) {
+	defer func() {
+		r.mu.Lock()
+		delete(r.conns, conn)
+		delete(r.subscribed, conn)
+		r.mu.Unlock()
+		conn.Close()
+	}()
+	reader := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		switch strings.ToUpper(args[0]) {
+		case "SUBSCRIBE":
+			r.mu.Lock()
+			r.subscribed[conn] = true
+			r.mu.Unlock()
+			r.write(conn, fmt.Sprintf("*3\r\n%s%s:1\r\n", bulk("subscribe"), bulk(args[1])))
+		case "PUBLISH":
+			r.mu.Lock()
+			var subscribers []net.Conn
+			for sub := range r.subscribed {
+				subscribers = append(subscribers, sub)
+			}
+			r.mu.Unlock()
+			for _, sub := range subscribers {
+				r.write(sub, fmt.Sprintf("*3\r\n%s%s%s", bulk("message"), bulk(args[1]), bulk(args[2])))
+			}
+			r.write(conn, fmt.Sprintf(":%d\r\n", len(subscribers)))
+		default:
+			r.write(conn, "-ERR unknown command\r\n")
+		}
+	}
+}
+
+func (r *fakeRedis) write(conn net.Conn, reply string) {
+	r.mu.Lock()
+	lock := r.conns[conn]
+	r.mu.Unlock()
+	if lock == nil {
+		return
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	conn.Write([]byte(reply))
+}
+
+func bulk(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+// readCommand reads a request, a RESP array of bulk strings
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil || n < 2 {
+		return nil, fmt.Errorf("bad command %q", line)
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, fmt.Errorf("bad bulk length %q", line)
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
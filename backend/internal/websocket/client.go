@@ -1,15 +1,24 @@
 package websocket
 
 import (
+	"context"
+
 	"github.com/gorilla/websocket"
 )
 
-func NewClient(hub *Hub, conn *websocket.Conn, userID int64, username string) *Client {
+func NewClient(hub *Hub, conn *websocket.Conn, userID, deviceID int64, username string, isBot bool) *Client {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Client{
-		hub:      hub,
-		conn:     conn,
-		send:     make(chan []byte, 256),
-		userID:   userID,
-		username: username,
+		id:          nextClientID.Add(1),
+		hub:         hub,
+		conn:        conn,
+		send:        make(chan []byte, hub.sendBuffer),
+		userID:      userID,
+		deviceID:    deviceID,
+		username:    username,
+		isBot:       isBot,
+		connectedAt: hub.clock.Now().UTC(),
+		ctx:         ctx,
+		cancel:      cancel,
 	}
 } 
\ No newline at end of file
@@ -0,0 +1,41 @@
+package websocket
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Close codes the server ends connections with. The standard codes keep
+// their usual meaning; 4000-4999 are this server's own. Clients should
+// reconnect after CloseServerRestart, CloseTooSlow and CloseStale, log in again after
+// CloseSessionExpired and CloseSessionRevoked, and not reconnect on their
+// own after ClosePolicyViolation, CloseDuplicateSession or CloseKicked.
+const (
+	CloseNormal           = websocket.CloseNormalClosure   // 1000
+	CloseServerRestart    = websocket.CloseGoingAway       // 1001, shutting down
+	ClosePolicyViolation  = websocket.ClosePolicyViolation // 1008, repeated rate limit violations
+	CloseMessageTooBig    = websocket.CloseMessageTooBig   // 1009, frame over WS_MAX_FRAME_BYTES
+	CloseTooSlow          = websocket.CloseTryAgainLater   // 1013, evicted for a full send queue
+	CloseSessionExpired   = 4001                           // the session's token lapsed
+	CloseSessionRevoked   = 4002                           // the session's device was revoked
+	CloseDuplicateSession = 4003                           // replaced by a newer connection
+	CloseKicked           = 4004                           // closed from /api/admin/connections
+	CloseStale            = 4005                           // nothing heard, not even a pong, for WS_STALE_AFTER
+)
+
+// closeWait bounds how long sending a close frame may take
+const closeWait = time.Second
+
+// CloseConn sends a close frame with code and reason, then closes conn.
+// Use it for connections that never became a registered client.
+func CloseConn(conn *websocket.Conn, code int, reason string) {
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(closeWait))
+	conn.Close()
+}
+
+// closeWith ends the connection from outside its write pump. The read
+// pump notices and unregisters the client as usual.
+func (c *Client) closeWith(code int, reason string) {
+	CloseConn(c.conn, code, reason)
+}
@@ -0,0 +1,80 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"messager/internal/bus"
+	"messager/internal/errsink"
+)
+
+// busEvent is what the hub publishes for other instances: the frame and
+// the users it's for, which the receiving hub can't work out on its own
+type busEvent struct {
+	Participants []int64         `json:"participants"`
+	Data         json.RawMessage `json:"data"`
+	Ephemeral    bool            `json:"ephemeral,omitempty"`
+	// ForgetParticipants carries no frame: the conversation's members
+	// changed and receivers drop their cached participant IDs
+	ForgetParticipants bool `json:"forget_participants,omitempty"`
+}
+
+// SetBus connects the hub to the other server instances: frames it sends
+// to participants are also published on b, and frames published by the
+// others are delivered to the participants connected here. It must be
+// called before Run.
+func (h *Hub) SetBus(b bus.Bus) {
+	h.bus = b
+	b.Subscribe(h.deliverRemote)
+}
+
+// publish forwards a frame to the other instances. It never blocks; a
+// frame the bus can't take is dropped and counted.
+func (h *Hub) publish(conversationID int64, data []byte, participants []int64, ephemeral bool) {
+	if _, single := h.bus.(bus.Local); single {
+		return
+	}
+	payload, err := json.Marshal(busEvent{Participants: participants, Data: data, Ephemeral: ephemeral})
+	if err != nil {
+		h.logger.Printf("Failed to marshal bus event: %v", err)
+		h.errs.Swallow(errsink.Marshal, "hub.bus_publish", err)
+		return
+	}
+	if err := h.bus.Publish(conversationID, payload); err != nil {
+		h.logger.Printf("Failed to publish conversation %d to the bus: %v", conversationID, err)
+		h.errs.Swallow(errsink.Dropped, "hub.bus_publish", fmt.Errorf("conversation %d: %w", conversationID, err))
+	}
+}
+
+// publishForget tells the other instances to drop their cached
+// participants of conversationID
+func (h *Hub) publishForget(conversationID int64) {
+	if _, single := h.bus.(bus.Local); single {
+		return
+	}
+	payload, err := json.Marshal(busEvent{ForgetParticipants: true})
+	if err != nil {
+		h.errs.Swallow(errsink.Marshal, "hub.bus_forget", err)
+		return
+	}
+	if err := h.bus.Publish(conversationID, payload); err != nil {
+		h.logger.Printf("Failed to publish participant change for conversation %d: %v", conversationID, err)
+		h.errs.Swallow(errsink.Dropped, "hub.bus_forget", fmt.Errorf("conversation %d: %w", conversationID, err))
+	}
+}
+
+// deliverRemote hands a frame published by another instance to the
+// participants connected here
+func (h *Hub) deliverRemote(conversationID int64, payload []byte) {
+	var event busEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		h.logger.Printf("Ignoring malformed bus event: %v", err)
+		h.errs.Swallow(errsink.Marshal, "hub.bus_deliver", err)
+		return
+	}
+	if event.ForgetParticipants {
+		h.participants.forget(conversationID)
+		return
+	}
+	h.deliver(conversationID, event.Data, event.Participants, event.Ephemeral)
+}
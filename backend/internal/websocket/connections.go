@@ -0,0 +1,127 @@
+package websocket
+
+import (
+	"sort"
+	"time"
+)
+
+// ConnectionInfo describes one live connection for debugging delivery
+// problems. It holds counters and timestamps only, never frame contents.
+type ConnectionInfo struct {
+	ID          int64     `json:"id"`
+	UserID      int64     `json:"user_id"`
+	Username    string    `json:"username"`
+	DeviceID    int64     `json:"device_id,omitempty"`
+	IsBot       bool      `json:"is_bot"`
+	ConnectedAt time.Time `json:"connected_at"`
+
+	// Frames read from and written to the connection, and when the last
+	// of each happened
+	FramesReceived int64      `json:"frames_received"`
+	FramesSent     uint64     `json:"frames_sent"`
+	LastReceivedAt *time.Time `json:"last_received_at,omitempty"`
+	LastSentAt     *time.Time `json:"last_sent_at,omitempty"`
+
+	// The send queue: frames waiting now, the most ever waiting, its
+	// size, and frames that didn't fit or were discarded to make room
+	QueueLength    int   `json:"queue_length"`
+	QueueHighWater int64 `json:"queue_high_water"`
+	QueueCapacity  int   `json:"queue_capacity"`
+	Dropped        int64 `json:"dropped"`
+
+	ActiveConversationID int64      `json:"active_conversation_id,omitempty"`
+	HeartbeatAcks        int64      `json:"heartbeat_acks"`
+	HeartbeatRTTMS       float64    `json:"heartbeat_rtt_ms,omitempty"`
+	LastHeartbeatAckAt   *time.Time `json:"last_heartbeat_ack_at,omitempty"`
+	SessionExpiresAt     *time.Time `json:"session_expires_at,omitempty"`
+}
+
+// Connections lists the live connections, oldest first, optionally only
+// those of userID (0 for everyone's)
+func (h *Hub) Connections(userID int64) []ConnectionInfo {
+	h.mu.RLock()
+	conns := make([]ConnectionInfo, 0, len(h.clients))
+	for client := range h.clients {
+		if userID == 0 || client.userID == userID {
+			conns = append(conns, client.info())
+		}
+	}
+	h.mu.RUnlock()
+
+	sort.Slice(conns, func(i, j int) bool { return conns[i].ID < conns[j].ID })
+	return conns
+}
+
+// CloseConnection closes the connection with the given ID, telling the
+// client an admin did it, and reports whether it was found. Its read pump
+// then unregisters it as usual.
+func (h *Hub) CloseConnection(id int64) bool {
+	h.mu.RLock()
+	var target *Client
+	for client := range h.clients {
+		if client.id == id {
+			target = client
+			break
+		}
+	}
+	h.mu.RUnlock()
+
+	if target == nil {
+		return false
+	}
+	target.closeWith(CloseKicked, "closed by an admin")
+	return true
+}
+
+// info snapshots the client's counters. The caller must hold h.mu, so the
+// queue isn't closed while its length is read.
+func (c *Client) info() ConnectionInfo {
+	info := ConnectionInfo{
+		ID:                   c.id,
+		UserID:               c.userID,
+		Username:             c.username,
+		DeviceID:             c.deviceID,
+		IsBot:                c.isBot,
+		ConnectedAt:          c.connectedAt,
+		FramesReceived:       c.framesReceived.Load(),
+		FramesSent:           c.seq.Load(),
+		LastReceivedAt:       unixNanoTime(c.lastReceived.Load()),
+		LastSentAt:           unixNanoTime(c.lastSent.Load()),
+		QueueLength:          len(c.send),
+		QueueHighWater:       c.queueHighWater.Load(),
+		QueueCapacity:        cap(c.send),
+		Dropped:              c.dropped.Load(),
+		ActiveConversationID: c.active.Load(),
+		SessionExpiresAt:     unixNanoTime(c.expiresAt.Load()),
+	}
+
+	c.statsMu.Lock()
+	info.HeartbeatAcks = c.heartbeatAcks
+	if c.heartbeatAcks > 0 {
+		info.HeartbeatRTTMS = float64(c.lastRTT) / float64(time.Millisecond)
+		ackAt := c.lastAckAt.UTC()
+		info.LastHeartbeatAckAt = &ackAt
+	}
+	c.statsMu.Unlock()
+	return info
+}
+
+// recordQueued notes how full the client's queue got after a frame went in
+func (c *Client) recordQueued() {
+	n := int64(len(c.send))
+	for {
+		high := c.queueHighWater.Load()
+		if n <= high || c.queueHighWater.CompareAndSwap(high, n) {
+			return
+		}
+	}
+}
+
+// unixNanoTime converts a stored unix nano timestamp, nil for 0 (never)
+func unixNanoTime(nanos int64) *time.Time {
+	if nanos == 0 {
+		return nil
+	}
+	t := time.Unix(0, nanos).UTC()
+	return &t
+}
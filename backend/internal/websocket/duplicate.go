@@ -0,0 +1,48 @@
+package websocket
+
+// What the hub does when a user who already has a connection opens another
+const (
+	// duplicateSessionAllow keeps every connection open
+	duplicateSessionAllow = "allow"
+	// duplicateSessionReplace closes the older connections with
+	// CloseDuplicateSession in favour of the new one
+	duplicateSessionReplace = "replace"
+	// duplicateSessionDeny refuses the new connection
+	duplicateSessionDeny = "deny"
+)
+
+// RefusesSession reports whether a new connection for userID would be
+// refused under the duplicate-session policy, so the handshake can be
+// answered with 409 instead of being upgraded. Register checks again under
+// the lock, since another connection may slip in between.
+func (h *Hub) RefusesSession(userID int64) bool {
+	if h.duplicateSessions != duplicateSessionDeny {
+		return false
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.userMap[userID]) > 0
+}
+
+// admit applies the duplicate-session policy to a registering client. A
+// refused client is marked and has its queue closed, so its write pump
+// closes the connection with CloseDuplicateSession; under "replace" the
+// user's other connections go the same way. It returns whether client may
+// register and how many connections it replaced. The caller must hold h.mu
+// for writing.
+func (h *Hub) admit(client *Client) (admitted bool, replaced int) {
+	if len(h.userMap[client.userID]) == 0 || h.duplicateSessions == duplicateSessionAllow {
+		return true, 0
+	}
+	if h.duplicateSessions == duplicateSessionDeny {
+		client.duplicate.Store(true)
+		close(client.send)
+		return false, 0
+	}
+	for other := range h.userMap[client.userID] {
+		other.duplicate.Store(true)
+		h.removeClient(other)
+		replaced++
+	}
+	return true, replaced
+}
@@ -0,0 +1,91 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+
+	"messager/internal/config"
+	"messager/internal/models"
+)
+
+func duplicatePolicy(policy string) func(*config.Config) {
+	return func(cfg *config.Config) {
+		cfg.WSDuplicateSessionPolicy = policy
+	}
+}
+
+func TestDuplicateSessionAllow(t *testing.T) {
+	h, _, f := newTestHub(t, duplicatePolicy(duplicateSessionAllow))
+	startHub(t, h)
+	first, _ := dial(t, h, f.Alice)
+	if h.RefusesSession(f.Alice.ID) {
+		t.Error("RefusesSession under allow")
+	}
+	second, _ := dial(t, h, f.Alice)
+	readUntil(t, first, "system")
+	readUntil(t, second, "system")
+
+	waitFor(t, "both connections to register", func() bool { return h.ClientCount() == 2 })
+	expectNoFrame(t, first, 50*time.Millisecond)
+
+	// Direct sends reach every connection, and the user stays connected
+	// until the last one closes
+	if err := h.SendToUser(f.Alice.ID, models.WebSocketMessage{Type: "notice", Payload: map[string]string{"text": "hi"}}); err != nil {
+		t.Fatal(err)
+	}
+	readUntil(t, first, "notice")
+	readUntil(t, second, "notice")
+	first.Close()
+	waitFor(t, "the first connection to go", func() bool { return h.ClientCount() == 1 })
+	if !h.IsConnected(f.Alice.ID) || h.Stats().UniqueUsers != 1 {
+		t.Error("alice counted as offline with a connection still open")
+	}
+	second.Close()
+	waitFor(t, "alice to go offline", func() bool { return !h.IsConnected(f.Alice.ID) })
+}
+
+func TestDuplicateSessionReplace(t *testing.T) {
+	h, _, f := newTestHub(t, duplicatePolicy(duplicateSessionReplace))
+	startHub(t, h)
+	bob, _ := dial(t, h, f.Bob)
+	old, _ := dial(t, h, f.Alice)
+	readUntil(t, bob, "presence")
+	if h.RefusesSession(f.Alice.ID) {
+		t.Error("RefusesSession under replace")
+	}
+
+	current, _ := dial(t, h, f.Alice)
+	if code := readClose(t, old); code != CloseDuplicateSession {
+		t.Errorf("old connection closed with %d, want %d", code, CloseDuplicateSession)
+	}
+	waitFor(t, "the old connection to go", func() bool { return h.ClientCount() == 2 })
+
+	// The handover doesn't take alice offline, and the new connection works
+	expectNoFrameOfType(t, bob, "presence", 100*time.Millisecond)
+	sendMessage(t, bob, f.Direct.ID, "still there?")
+	readMessage(t, current, "still there?")
+}
+
+func TestDuplicateSessionDeny(t *testing.T) {
+	h, _, f := newTestHub(t, duplicatePolicy(duplicateSessionDeny))
+	startHub(t, h)
+	if h.RefusesSession(f.Alice.ID) {
+		t.Error("RefusesSession before alice connected")
+	}
+	first, _ := dial(t, h, f.Alice)
+	waitFor(t, "alice to register", func() bool { return h.RefusesSession(f.Alice.ID) })
+	if h.RefusesSession(f.Bob.ID) {
+		t.Error("RefusesSession for a user who isn't connected")
+	}
+
+	// One that gets past the handshake check is still turned away
+	second, _ := dial(t, h, f.Alice)
+	if code := readClose(t, second); code != CloseDuplicateSession {
+		t.Errorf("second connection closed with %d, want %d", code, CloseDuplicateSession)
+	}
+	if n := h.ClientCount(); n != 1 {
+		t.Errorf("%d clients, want only the first", n)
+	}
+	sendMessage(t, first, f.Direct.ID, "first stays")
+	readMessage(t, first, "first stays")
+}
@@ -0,0 +1,54 @@
+package websocket
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// rateViolationWindow is how long a connection must stay within its frame
+// budget for earlier violations to be forgiven
+const rateViolationWindow = time.Minute
+
+// nextClientID numbers connections so per-connection limiter state can be
+// keyed like the per-user state
+var nextClientID atomic.Int64
+
+// allowFrame spends a token from the budget of this frame's type. An over
+// budget frame gets a "rate_limited" error; once the connection has been
+// warned maxRateViolations times in close succession it is closed, and
+// allowFrame reports false with closed set so the read loop stops.
+func (c *Client) allowFrame(frameType string) (allowed, closed bool) {
+	limiter := c.hub.frameLimiter
+	if frameType == "typing" {
+		limiter = c.hub.typingLimiter
+	}
+	ok, retryAfter := limiter.Allow(c.id)
+	if ok {
+		return true, false
+	}
+
+	now := time.Now()
+	if now.Sub(c.lastViolation) >= rateViolationWindow {
+		c.violations = 0
+	}
+	c.violations++
+	c.lastViolation = now
+
+	if max := c.hub.maxRateViolations; max > 0 && c.violations >= max {
+		c.hub.logger.Printf("WARNING: closing connection for user %d after %d rate limit violations", c.userID, c.violations)
+		c.closeWith(ClosePolicyViolation, "rate limit exceeded")
+		return false, true
+	}
+
+	c.sendError("rate_limited", "Too many events, slow down", map[string]interface{}{
+		"event":       frameType,
+		"retry_after": retryAfter.Seconds(),
+	})
+	return false, false
+}
+
+// forgetFrameBudget drops a closed connection's limiter state
+func (h *Hub) forgetFrameBudget(client *Client) {
+	h.frameLimiter.Forget(client.id)
+	h.typingLimiter.Forget(client.id)
+}
@@ -0,0 +1,116 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+
+	gorilla "github.com/gorilla/websocket"
+	"messager/internal/config"
+)
+
+func TestSocketSendsShareTheUserBudget(t *testing.T) {
+	h, _, f := newTestHub(t, func(cfg *config.Config) {
+		cfg.MessageRatePerSec = 0.1
+		cfg.MessageRateBurst = 1
+	})
+	startHub(t, h)
+	conn, _ := dial(t, h, f.Alice)
+	readUntil(t, conn, "system")
+
+	send(t, conn, "message", map[string]interface{}{"conversation_id": f.Group.ID, "content": "first"})
+	readUntil(t, conn, "message")
+
+	send(t, conn, "message", map[string]interface{}{"conversation_id": f.Group.ID, "content": "second"})
+	errFrame := readUntil(t, conn, "error")
+	if code := errFrame.Payload["code"]; code != "rate_limited" {
+		t.Fatalf("error code = %v, want rate_limited", code)
+	}
+	if retry, _ := errFrame.Payload["retry_after"].(float64); retry <= 0 || retry > (10*time.Second).Seconds() {
+		t.Errorf("retry_after = %v, want within one refill interval", errFrame.Payload["retry_after"])
+	}
+}
+
+// frameBudget limits every connection to burst frames with no refill during
+// a test, and closes it after violations over-budget frames
+func frameBudget(burst, typingBurst, violations int) func(*config.Config) {
+	return func(cfg *config.Config) {
+		cfg.WSFrameRatePerSec = 0.001
+		cfg.WSFrameBurst = burst
+		cfg.WSTypingRatePerSec = 0.001
+		cfg.WSTypingBurst = typingBurst
+		cfg.WSMaxRateViolations = violations
+	}
+}
+
+func TestGarbageFloodIsClosed(t *testing.T) {
+	h, _, f := newTestHub(t, frameBudget(3, 3, 3))
+	startHub(t, h)
+	conn, _ := dial(t, h, f.Alice)
+
+	// The budget answers three, the next two are warned about, and the
+	// third over-budget frame ends the connection. Writing past that would
+	// reset it before the close frame is read.
+	for i := 0; i < 6; i++ {
+		if err := conn.WriteMessage(gorilla.TextMessage, []byte("garbage")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if code := readClose(t, conn); code != ClosePolicyViolation {
+		t.Errorf("closed with %d, want %d", code, ClosePolicyViolation)
+	}
+	waitFor(t, "the connection to be unregistered", func() bool { return h.ClientCount() == 0 })
+}
+
+func TestMalformedFramesSpendTheBudget(t *testing.T) {
+	h, _, f := newTestHub(t, frameBudget(2, 1, 0))
+	startHub(t, h)
+	conn, _ := dial(t, h, f.Alice)
+
+	// Two unparseable frames use up the general budget, so a valid frame
+	// after them is refused
+	for i := 0; i < 2; i++ {
+		if err := conn.WriteMessage(gorilla.TextMessage, []byte("{")); err != nil {
+			t.Fatal(err)
+		}
+		if got := readUntil(t, conn, "error").Payload["code"]; got != "invalid_frame" {
+			t.Fatalf("frame %d: error %v, want invalid_frame", i, got)
+		}
+	}
+	send(t, conn, "active", map[string]interface{}{"conversation_id": f.Direct.ID})
+	if got := readUntil(t, conn, "error").Payload["code"]; got != "rate_limited" {
+		t.Errorf("valid frame after the garbage: error %v, want rate_limited", got)
+	}
+
+	// A typing frame with a bad payload spends the typing budget
+	send(t, conn, "typing", "not an object")
+	if got := readUntil(t, conn, "error").Payload["code"]; got != "invalid_payload" {
+		t.Fatalf("bad typing payload: error %v, want invalid_payload", got)
+	}
+	send(t, conn, "typing", map[string]interface{}{"conversation_id": f.Direct.ID, "is_typing": true})
+	got := readUntil(t, conn, "error")
+	if got.Payload["code"] != "rate_limited" || got.Payload["event"] != "typing" {
+		t.Errorf("typing after a malformed one: error %v, want rate_limited for typing", got.Payload)
+	}
+}
+
+func TestReconnectKeepsTheSendBudget(t *testing.T) {
+	h, _, f := newTestHub(t, func(cfg *config.Config) {
+		cfg.MessageRatePerSec = 0.1
+		cfg.MessageRateBurst = 1
+	})
+	startHub(t, h)
+	conn, _ := dial(t, h, f.Alice)
+	readUntil(t, conn, "system")
+	send(t, conn, "message", map[string]interface{}{"conversation_id": f.Group.ID, "content": "first"})
+	readUntil(t, conn, "message")
+
+	conn.Close()
+	waitFor(t, "the connection to be unregistered", func() bool { return h.ClientCount() == 0 })
+
+	conn, _ = dial(t, h, f.Alice)
+	readUntil(t, conn, "system")
+	send(t, conn, "message", map[string]interface{}{"conversation_id": f.Group.ID, "content": "second"})
+	if code := readUntil(t, conn, "error").Payload["code"]; code != "rate_limited" {
+		t.Fatalf("send after reconnecting: error code = %v, want rate_limited", code)
+	}
+}
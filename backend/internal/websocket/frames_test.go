@@ -0,0 +1,73 @@
+package websocket
+
+import (
+	"fmt"
+	"testing"
+
+	gorilla "github.com/gorilla/websocket"
+)
+
+// sendRaw writes data to the server as a text frame, as it is
+func sendRaw(t *testing.T, conn *testConn, data string) {
+	t.Helper()
+	if err := conn.WriteMessage(gorilla.TextMessage, []byte(data)); err != nil {
+		t.Fatalf("write frame: %v", err)
+	}
+}
+
+func TestMalformedFramesAreReported(t *testing.T) {
+	h, _, f := newTestHub(t, nil)
+	startHub(t, h)
+	alice, _ := dial(t, h, f.Alice)
+
+	for _, tc := range []struct {
+		name  string
+		frame string
+		code  string
+		event string
+	}{
+		{"not json", `hello`, "invalid_frame", ""},
+		{"not an object", `[1, 2]`, "invalid_frame", ""},
+		{"wrong payload type", `{"type":"message","payload":{"conversation_id":"one","content":"hi"}}`, "invalid_payload", "message"},
+		{"payload not an object", `{"type":"typing","payload":"yes"}`, "invalid_payload", "typing"},
+		{"missing payload", `{"type":"active"}`, "invalid_payload", "active"},
+		{"null payload", `{"type":"state","payload":null}`, "invalid_payload", "state"},
+		{"no content", `{"type":"message","payload":{"conversation_id":1}}`, "invalid_message", ""},
+		{"no conversation", `{"type":"typing","payload":{"is_typing":true}}`, "invalid_typing", ""},
+		{"no state key", `{"type":"state","payload":{"conversation_id":1}}`, "invalid_state", ""},
+	} {
+		sendRaw(t, alice, tc.frame)
+		got := readUntil(t, alice, "error")
+		if got.Payload["code"] != tc.code {
+			t.Errorf("%s: error %v, want %s", tc.name, got.Payload, tc.code)
+			continue
+		}
+		if tc.event != "" && got.Payload["event"] != tc.event {
+			t.Errorf("%s: error names event %v, want %s", tc.name, got.Payload["event"], tc.event)
+		}
+	}
+
+	// None of it cost the connection, and well-formed frames still work
+	sendMessage(t, alice, f.Direct.ID, "still here")
+	readMessage(t, alice, "still here")
+}
+
+func TestTypedPayloadsAreHandled(t *testing.T) {
+	h, _, f := newTestHub(t, nil)
+	startHub(t, h)
+	alice, _ := dial(t, h, f.Alice)
+	bob, _ := dial(t, h, f.Bob)
+
+	// Unknown fields are ignored rather than rejected
+	sendRaw(t, alice, fmt.Sprintf(`{"type":"typing","payload":{"conversation_id":%d,"is_typing":true,"extra":1}}`, f.Direct.ID))
+	typing := readUntil(t, bob, "typing")
+	if typing.Payload["conversation_id"] != float64(f.Direct.ID) || typing.Payload["is_typing"] != true || typing.Payload["user_id"] != float64(f.Alice.ID) {
+		t.Errorf("typing relayed as %v", typing.Payload)
+	}
+
+	send(t, alice, "state", map[string]interface{}{"conversation_id": f.Direct.ID, "key": "presence.viewing", "value": map[string]interface{}{"x": 1}, "ttl": 30})
+	state := readUntil(t, bob, "state")
+	if state.Payload["key"] != "presence.viewing" {
+		t.Errorf("state relayed as %v", state.Payload)
+	}
+}
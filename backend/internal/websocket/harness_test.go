@@ -0,0 +1,257 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gorilla "github.com/gorilla/websocket"
+	"messager/internal/config"
+	"messager/internal/db"
+	"messager/internal/db/testdb"
+	"messager/internal/models"
+)
+
+// frameWait bounds how long a test waits for a frame it expects
+const frameWait = 5 * time.Second
+
+// newTestHub returns a hub over a seeded throwaway database, with the
+// default configuration adjusted by configure. It isn't running yet, so
+// the test can still replace its clock, bus or chaos injector.
+func newTestHub(t *testing.T, configure func(*config.Config)) (*Hub, *db.DB, *testdb.Fixtures) {
+	t.Helper()
+	d := testdb.Open(t)
+	f := testdb.Seed(t, d)
+	cfg := config.Load()
+	if configure != nil {
+		configure(cfg)
+	}
+	return NewHub(d, cfg), d, f
+}
+
+// startHub runs h until the test ends
+func startHub(t *testing.T, h *Hub) {
+	t.Helper()
+	go h.Run()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), frameWait)
+		defer cancel()
+		h.Shutdown(ctx)
+	})
+}
+
+// testConn is the client's end of a connection. A goroutine reads it into
+// frames, as a gorilla connection can't be read again after a timeout.
+type testConn struct {
+	*gorilla.Conn
+	frames chan []byte
+	err    chan error // the error that ended reading
+}
+
+// dial connects user to h the way the /ws handler does, returning the
+// client's end of the socket and the hub's Client for it
+func dial(t *testing.T, h *Hub, user *models.User) (*testConn, *Client) {
+	t.Helper()
+	return dialWith(t, h, user, dialOptions{})
+}
+
+// dialSilent is dial for a client that never answers pings
+func dialSilent(t *testing.T, h *Hub, user *models.User) (*testConn, *Client) {
+	t.Helper()
+	return dialWith(t, h, user, dialOptions{silent: true})
+}
+
+// dialStalled is dial for a consumer whose frames pile up in its send
+// queue until release is called, which starts writing them out
+func dialStalled(t *testing.T, h *Hub, user *models.User) (conn *testConn, client *Client, release func()) {
+	t.Helper()
+	stalled := make(chan struct{})
+	var once sync.Once
+	release = func() { once.Do(func() { close(stalled) }) }
+	t.Cleanup(release)
+	conn, client = dialWith(t, h, user, dialOptions{stalled: stalled})
+	return conn, client, release
+}
+
+type dialOptions struct {
+	silent  bool          // never answer pings
+	stalled chan struct{} // start the write pump once closed
+}
+
+func dialWith(t *testing.T, h *Hub, user *models.User, opts dialOptions) (*testConn, *Client) {
+	t.Helper()
+	clients := make(chan *Client, 1)
+	upgrader := gorilla.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		client := NewClient(h, conn, user.ID, 0, user.Username, user.IsBot)
+		if !h.AddClient(client) {
+			CloseConn(conn, CloseServerRestart, "server restarting")
+			return
+		}
+		if opts.stalled != nil {
+			go func() {
+				<-opts.stalled
+				client.WritePump()
+			}()
+		} else {
+			go client.WritePump()
+		}
+		go client.ReadPump()
+		clients <- client
+	}))
+	t.Cleanup(srv.Close)
+
+	conn, _, err := gorilla.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if opts.silent {
+		conn.SetPingHandler(func(string) error { return nil })
+	}
+	tc := &testConn{Conn: conn, frames: make(chan []byte, 1024), err: make(chan error, 1)}
+	go func() {
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				tc.err <- err
+				close(tc.frames)
+				return
+			}
+			tc.frames <- data
+		}
+	}()
+
+	select {
+	case client := <-clients:
+		return tc, client
+	case <-time.After(frameWait):
+		t.Fatal("dial: client never registered")
+		return nil, nil
+	}
+}
+
+// frame is an event as a client receives it
+type frame struct {
+	Type    string                 `json:"type"`
+	Payload map[string]interface{} `json:"payload"`
+}
+
+// readFrame returns the next text frame from conn
+func readFrame(t *testing.T, conn *testConn) frame {
+	t.Helper()
+	select {
+	case data, ok := <-conn.frames:
+		if !ok {
+			t.Fatalf("read frame: %v", <-conn.err)
+		}
+		var f frame
+		if err := json.Unmarshal(data, &f); err != nil {
+			t.Fatalf("decode frame %s: %v", data, err)
+		}
+		return f
+	case <-time.After(frameWait):
+		t.Fatal("read frame: timed out")
+		return frame{}
+	}
+}
+
+// readUntil reads frames until one of type typ arrives, skipping others
+func readUntil(t *testing.T, conn *testConn, typ string) frame {
+	t.Helper()
+	for {
+		if f := readFrame(t, conn); f.Type == typ {
+			return f
+		}
+	}
+}
+
+// expectNoFrame checks that no frame arrives on conn within wait
+func expectNoFrame(t *testing.T, conn *testConn, wait time.Duration) {
+	t.Helper()
+	select {
+	case data, ok := <-conn.frames:
+		if ok {
+			t.Fatalf("unexpected frame %s", data)
+		}
+		t.Fatalf("unexpected end of connection: %v", <-conn.err)
+	case <-time.After(wait):
+	}
+}
+
+// expectNoFrameOfType is expectNoFrame ignoring frames of other types
+func expectNoFrameOfType(t *testing.T, conn *testConn, typ string, wait time.Duration) {
+	t.Helper()
+	deadline := time.After(wait)
+	for {
+		select {
+		case data, ok := <-conn.frames:
+			if !ok {
+				t.Fatalf("unexpected end of connection: %v", <-conn.err)
+			}
+			var f frame
+			if json.Unmarshal(data, &f) == nil && f.Type == typ {
+				t.Fatalf("unexpected %s frame %s", typ, data)
+			}
+		case <-deadline:
+			return
+		}
+	}
+}
+
+// readClose reads until the server closes conn and returns the close code
+func readClose(t *testing.T, conn *testConn) int {
+	t.Helper()
+	deadline := time.After(frameWait)
+	for {
+		select {
+		case _, ok := <-conn.frames:
+			if ok {
+				continue
+			}
+			err := <-conn.err
+			var closeErr *gorilla.CloseError
+			if !errors.As(err, &closeErr) {
+				t.Fatalf("expected a close frame, got %v", err)
+			}
+			return closeErr.Code
+		case <-deadline:
+			t.Fatal("timed out waiting for the connection to close")
+			return 0
+		}
+	}
+}
+
+// send writes an event to the server
+func send(t *testing.T, conn *testConn, typ string, payload interface{}) {
+	t.Helper()
+	data, err := json.Marshal(map[string]interface{}{"type": typ, "payload": payload})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteMessage(gorilla.TextMessage, data); err != nil {
+		t.Fatalf("write frame: %v", err)
+	}
+}
+
+// waitFor polls cond until it holds, failing the test after frameWait
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(frameWait)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -1,23 +1,112 @@
 package websocket
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"messager/internal/bus"
+	"messager/internal/chaos"
+	"messager/internal/clock"
+	"messager/internal/config"
+	"messager/internal/errsink"
+	"messager/internal/logsafe"
 	"messager/internal/models"
 	"messager/internal/db"
+	"messager/internal/mirror"
+	"messager/internal/ratelimit"
+	"messager/internal/version"
 )
 
+// sendLimiterIdle is how long a user's flood-control bucket may sit unused
+// before the hub discards it. Buckets outlive the connection, so reconnecting
+// doesn't refill one
+const sendLimiterIdle = 5 * time.Minute
+
+// minFrameBytes is the smallest frame limit the hub accepts: room for a
+// message of the largest allowed content with every byte JSON-escaped as
+// \uXXXX, plus the envelope, so no legitimate send is refused
+const minFrameBytes = 6*models.MaxMessageContentBytes + 1024
+
+// broadcastQueueSize is how many broadcasts may wait for Run before
+// BroadcastMessage starts dropping them
+const broadcastQueueSize = 256
+
 type Client struct {
+	id       int64 // unique per connection
 	hub      *Hub
 	conn     *websocket.Conn
 	send     chan []byte
 	userID   int64
+	deviceID int64 // device registration, 0 when the client named none
 	username string
+	isBot    bool
+
+	// ctx bounds the database work done for the connection's frames and
+	// is canceled once it's unregistered
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// seq counts frames written to this connection and is echoed in
+	// heartbeats so clients can tell whether they missed anything
+	seq      atomic.Uint64
+	lastSent atomic.Int64 // unix nanos of the last frame written
+
+	// active is the conversation the client says is on screen, 0 for none;
+	// messages delivered to it advance the user's read marker
+	active atomic.Int64
+
+	// subs limits which conversations typing and state events are
+	// delivered for
+	subs subscriptions
+
+	// members caches the conversations the user may send messages to
+	members membershipCache
+
+	// resync is set when queued frames were discarded to make room, and
+	// evicted when the client is being dropped for falling behind
+	resync  atomic.Bool
+	evicted atomic.Bool
+
+	// expiresAt is when the connection's session lapses in unix nanos, 0
+	// for never; expiryWarned is set once it has been sent "auth_expiring"
+	// and expired once it's being closed for lapsing
+	expiresAt    atomic.Int64
+	expiryWarned atomic.Bool
+	expired      atomic.Bool
+
+	// duplicate is set when the duplicate-session policy refused or
+	// replaced the connection
+	duplicate atomic.Bool
+
+	// Frame budget violations, only touched by ReadPump
+	violations    int
+	lastViolation time.Time
+
+	// frameClientID is the client_id of the frame ReadPump is handling,
+	// attached to any error it sends back
+	frameClientID string
+
+	// Per-connection counters for /api/admin/connections; lastReceived is
+	// unix nanos like lastSent
+	connectedAt    time.Time
+	framesReceived atomic.Int64
+	lastReceived   atomic.Int64
+	lastPong       atomic.Int64
+	queueHighWater atomic.Int64
+	dropped        atomic.Int64
+
+	statsMu       sync.Mutex
+	lastRTT       time.Duration
+	lastAckAt     time.Time
+	heartbeatAcks int64
 }
 
 type Hub struct {
@@ -25,241 +114,965 @@ type Hub struct {
 	Broadcast  chan []byte
 	Register   chan *Client
 	Unregister chan *Client
-	userMap    map[int64]*Client
+	userMap    map[int64]map[*Client]struct{} // each user's open connections
 	mu         sync.RWMutex
 	logger     *log.Logger
 	db         *db.DB
+
+	heartbeatInterval time.Duration
+	staleAfter        time.Duration // 0 when stale connections aren't reaped
+	maxFrameBytes     int64
+	sendBuffer        int
+	slowClientPolicy  string
+	duplicateSessions string
+	sendLimiter       *ratelimit.Limiter
+	botLimiter        *ratelimit.Limiter
+	frameLimiter      *ratelimit.Limiter // per connection, keyed by Client.id
+	typingLimiter     *ratelimit.Limiter
+	maxRateViolations int
+	bots              *botRouter
+	dedupe            *dedupeCache
+	mirror            *mirror.Mirror
+	bus               bus.Bus
+	state             *stateRelay
+	typing            *typingTracker
+	presence          *presenceTracker
+	lastSeen          *lastSeenRecorder
+	chaos             *chaos.Injector
+	errs              *errsink.Sink
+	validateToken     TokenValidator
+	persist           *persistPool
+	replay            *replayBuffer
+	participants      *participantCache
+	pollers           *pollRegistry
+	streams           *streamSet
+	outbox            outboxState
+	counters          hubCounters
+
+	// clock times heartbeats and stale connections
+	clock clock.Clock
+
+	// done is closed by Shutdown to stop Run, which closes stopped on
+	// its way out; pumps counts the write pumps of registered clients
+	done         chan struct{}
+	stopped      chan struct{}
+	shutdownOnce sync.Once
+	shuttingDown atomic.Bool
+	pumps        sync.WaitGroup
+
+	// ctx is passed to the database for the hub's own work, like fan-out
+	// and the outbox; Shutdown cancels it once everything is drained
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// released is closed by ReleaseListeners to end long polls
+	released    chan struct{}
+	releaseOnce sync.Once
 }
 
-func NewHub(database *db.DB) *Hub {
-	return &Hub{
-		Broadcast:  make(chan []byte),
+func NewHub(database *db.DB, cfg *config.Config) *Hub {
+	h := &Hub{
+		Broadcast:  make(chan []byte, broadcastQueueSize),
 		Register:   make(chan *Client),
 		Unregister: make(chan *Client),
 		clients:    make(map[*Client]bool),
-		userMap:    make(map[int64]*Client),
+		userMap:    make(map[int64]map[*Client]struct{}),
 		logger:     log.New(os.Stdout, "[WEBSOCKET] ", log.LstdFlags|log.Lshortfile),
 		db:         database,
+
+		heartbeatInterval: cfg.WSHeartbeatInterval,
+		staleAfter:        cfg.WSStaleAfter,
+		maxFrameBytes:     int64(cfg.WSMaxFrameBytes),
+		sendBuffer:        cfg.WSSendBuffer,
+		slowClientPolicy:  cfg.WSSlowClientPolicy,
+		duplicateSessions: cfg.WSDuplicateSessionPolicy,
+		sendLimiter:       ratelimit.New(cfg.MessageRatePerSec, cfg.MessageRateBurst),
+		botLimiter:        ratelimit.New(cfg.BotRatePerSec, cfg.BotRateBurst),
+		frameLimiter:      ratelimit.New(cfg.WSFrameRatePerSec, cfg.WSFrameBurst),
+		typingLimiter:     ratelimit.New(cfg.WSTypingRatePerSec, cfg.WSTypingBurst),
+		maxRateViolations: cfg.WSMaxRateViolations,
+		dedupe:            newDedupeCache(cfg.MessageDedupeWindow),
+		bus:               bus.Local{},
+		clock:             clock.Real{},
+
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+		released: make(chan struct{}),
+	}
+	if h.maxFrameBytes < minFrameBytes {
+		h.logger.Printf("WARNING: WS_MAX_FRAME_BYTES=%d is below the %d bytes a maximum-size message can take, using %d",
+			cfg.WSMaxFrameBytes, minFrameBytes, minFrameBytes)
+		h.maxFrameBytes = minFrameBytes
+	}
+	switch {
+	case h.staleAfter <= 0:
+		h.staleAfter = 0
+	case h.heartbeatInterval <= 0:
+		h.logger.Printf("WARNING: WS_STALE_AFTER needs WS_HEARTBEAT_INTERVAL for pings, not reaping stale connections")
+		h.staleAfter = 0
+	case h.staleAfter < 2*h.heartbeatInterval:
+		h.logger.Printf("WARNING: WS_STALE_AFTER=%s is under two heartbeat intervals, using %s",
+			cfg.WSStaleAfter, 3*h.heartbeatInterval)
+		h.staleAfter = 3 * h.heartbeatInterval
+	}
+	if h.sendBuffer < 1 {
+		h.logger.Printf("WARNING: WS_SEND_BUFFER=%d is not positive, using %d", cfg.WSSendBuffer, defaultSendBuffer)
+		h.sendBuffer = defaultSendBuffer
+	}
+	if h.slowClientPolicy != slowClientDisconnect && h.slowClientPolicy != slowClientDropOldest {
+		h.logger.Printf("WARNING: unknown WS_SLOW_CLIENT_POLICY %q, using %q", cfg.WSSlowClientPolicy, slowClientDisconnect)
+		h.slowClientPolicy = slowClientDisconnect
+	}
+	switch h.duplicateSessions {
+	case duplicateSessionAllow, duplicateSessionReplace, duplicateSessionDeny:
+	default:
+		h.logger.Printf("WARNING: unknown WS_DUPLICATE_SESSION_POLICY %q, using %q", cfg.WSDuplicateSessionPolicy, duplicateSessionAllow)
+		h.duplicateSessions = duplicateSessionAllow
+	}
+	workers, queue := cfg.WSPersistWorkers, cfg.WSPersistQueue
+	if workers < 1 {
+		h.logger.Printf("WARNING: WS_PERSIST_WORKERS=%d is not positive, using %d", workers, defaultPersistWorkers)
+		workers = defaultPersistWorkers
+	}
+	if queue < 1 {
+		h.logger.Printf("WARNING: WS_PERSIST_QUEUE=%d is not positive, using %d", queue, defaultPersistQueue)
+		queue = defaultPersistQueue
+	}
+	h.ctx, h.cancel = context.WithCancel(context.Background())
+	h.persist = newPersistPool(h, workers, queue)
+	replayEvents := cfg.WSReplayEvents
+	if replayEvents < 0 {
+		h.logger.Printf("WARNING: WS_REPLAY_EVENTS=%d is negative, disabling the replay buffer", replayEvents)
+		replayEvents = 0
 	}
+	h.replay = newReplayBuffer(replayEvents, cfg.WSReplayTTL)
+	h.pollers = newPollRegistry()
+	h.streams = newStreamSet()
+	h.state = newStateRelay(h)
+	h.typing = newTypingTracker()
+	h.participants = newParticipantCache()
+	h.presence = newPresenceTracker()
+	h.lastSeen = newLastSeenRecorder()
+	h.bots = newBotRouter(h, cfg.DeliveryLimits())
+	return h
 }
 
+// Run processes registrations, unregistrations and broadcasts until
+// Shutdown is called
 func (h *Hub) Run() {
 	h.logger.Println("WebSocket hub started")
+	defer close(h.stopped)
+	go h.dispatchOutbox()
+
+	prune := time.NewTicker(time.Minute)
+	defer prune.Stop()
+	refreshSeen := time.NewTicker(lastSeenRefresh)
+	defer refreshSeen.Stop()
+	checkAuth := time.NewTicker(authCheckInterval)
+	defer checkAuth.Stop()
+	var janitor <-chan time.Time
+	if h.staleAfter > 0 {
+		ticker := h.clock.NewTicker(janitorInterval)
+		defer ticker.Stop()
+		janitor = ticker.C()
+	}
+
 	for {
 		select {
+		case <-h.done:
+			h.logger.Println("WebSocket hub stopped")
+			return
+
 		case client := <-h.Register:
+			h.pumps.Add(1)
 			h.mu.Lock()
+			admitted, replaced := h.admit(client)
+			if !admitted {
+				h.mu.Unlock()
+				h.logger.Printf("Refused duplicate session for %s (ID: %d)", logsafe.String(client.username), client.userID)
+				continue
+			}
+			h.counters.registrations.Add(1)
 			h.clients[client] = true
-			h.userMap[client.userID] = client
+			conns := h.userMap[client.userID]
+			if conns == nil {
+				conns = make(map[*Client]struct{})
+				h.userMap[client.userID] = conns
+			}
+			conns[client] = struct{}{}
+			// A replaced session hands over without the user going offline
+			first := len(conns) == 1 && replaced == 0
 			h.mu.Unlock()
+			if replaced > 0 {
+				h.logger.Printf("Replaced %d session(s) for %s (ID: %d)", replaced, logsafe.String(client.username), client.userID)
+			}
+			if first {
+				h.userConnected(client.userID)
+			}
 			h.logger.Printf("Client connected: %s (ID: %d), total clients: %d", 
-				client.username, client.userID, len(h.clients))
+				logsafe.String(client.username), client.userID, len(h.clients))
 
 			// Send welcome message
 			welcomeMsg := models.WebSocketMessage{
 				Type: "system",
 				Payload: map[string]interface{}{
 					"message": "Connected to chat server",
+					"version": version.Version,
 				},
 			}
 			if data, err := json.Marshal(welcomeMsg); err == nil {
 				client.send <- data
+				h.countSend(client, true)
 			}
 
 		case client := <-h.Unregister:
 			h.mu.Lock()
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				delete(h.userMap, client.userID)
-				close(client.send)
+			_, registered := h.clients[client]
+			var last bool
+			if registered {
+				last = h.removeClient(client)
+				h.counters.unregistrations.Add(1)
+				h.forgetFrameBudget(client)
 				h.logger.Printf("Client disconnected: %s (ID: %d), remaining clients: %d", 
-					client.username, client.userID, len(h.clients))
+					logsafe.String(client.username), client.userID, len(h.clients))
 			}
 			h.mu.Unlock()
+			if registered {
+				h.state.clearUser(client.userID)
+				h.typing.clearUser(client.userID)
+			}
+			if last {
+				h.userDisconnected(client.userID)
+			}
 
 		case message := <-h.Broadcast:
-			h.logger.Printf("Broadcasting message to %d clients", len(h.clients))
-			h.mu.RLock()
-			for client := range h.clients {
-				select {
-				case client.send <- message:
-					h.logger.Printf("Message sent to client: %s", client.username)
-				default:
-					h.logger.Printf("Failed to send message to client: %s, removing client", client.username)
-					h.mu.RUnlock()
-					h.mu.Lock()
-					close(client.send)
-					delete(h.clients, client)
-					delete(h.userMap, client.userID)
-					h.mu.Unlock()
-					h.mu.RLock()
-				}
+			h.counters.broadcasts.Add(1)
+			h.broadcast(message)
+
+		case <-prune.C:
+			if n := h.sendLimiter.Prune(sendLimiterIdle) + h.botLimiter.Prune(sendLimiterIdle) +
+				h.frameLimiter.Prune(sendLimiterIdle) + h.typingLimiter.Prune(sendLimiterIdle); n > 0 {
+				h.logger.Printf("Pruned %d idle send limiters", n)
 			}
-			h.mu.RUnlock()
+			h.dedupe.prune()
+			h.state.prune()
+			h.typing.prune(time.Now().UTC())
+			h.presence.prune(time.Now())
+			h.lastSeen.prune(time.Now().UTC())
+			h.prunePollers(time.Now())
+			h.replay.prune(time.Now())
+			h.participants.prune(time.Now())
+
+		case <-refreshSeen.C:
+			go h.RecordLastSeen(h.connectedUserIDs(), lastSeenRefresh)
+
+		case <-checkAuth.C:
+			go h.checkSessions(time.Now())
+
+		case <-janitor:
+			go h.reapStale(h.clock.Now())
 		}
 	}
 }
 
-func (h *Hub) SendToUser(userID int64, message interface{}) error {
+// AddClient registers a new connection with the hub. It returns false
+// once the hub is shutting down, in which case the caller should close the
+// connection instead of starting its pumps.
+func (h *Hub) AddClient(client *Client) bool {
+	select {
+	case h.Register <- client:
+		return true
+	case <-h.done:
+		return false
+	}
+}
+
+// Shutdown stops Run, then closes every connection with a CloseGoingAway
+// frame once whatever was already queued for it has been written. It
+// returns when all write pumps have exited, or with ctx's error if that
+// takes too long, after closing the remaining connections outright.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.shutdownOnce.Do(func() {
+		h.shuttingDown.Store(true)
+		close(h.done)
+	})
+	h.ReleaseListeners()
+	select {
+	case <-h.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer h.cancel()
+
+	// Messages already taken off the wire are saved and delivered while
+	// their recipients are still connected
+	if err := h.persist.drain(ctx); err != nil {
+		h.logger.Printf("WARNING: gave up waiting for queued messages to be saved: %v", err)
+	}
+	// Whatever isn't recorded as delivered is sent again on the next start
+	if err := h.flushOutbox(); err != nil {
+		h.logger.Printf("Failed to record delivered messages in the outbox: %v", err)
+	}
+
+	// Everyone still connected was seen right up to now
+	h.RecordLastSeen(h.connectedUserIDs(), 0)
+
+	h.mu.Lock()
+	clients := make([]*Client, 0, len(h.clients))
+	for client := range h.clients {
+		clients = append(clients, client)
+		h.removeClient(client)
+	}
+	h.mu.Unlock()
+	h.logger.Printf("Closing %d client connections", len(clients))
+
+	drained := make(chan struct{})
+	go func() {
+		h.pumps.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		for _, client := range clients {
+			client.closeWith(CloseServerRestart, "server restarting")
+		}
+		return ctx.Err()
+	}
+}
+
+// closeFrame is the close message a write pump sends when its queue is
+// closed: "server restarting" during shutdown, "client too slow" after an
+// eviction, "session expired" once the session lapsed, "duplicate session"
+// when the duplicate-session policy ended it, a normal closure otherwise
+func (c *Client) closeFrame() []byte {
+	switch {
+	case c.hub.shuttingDown.Load():
+		return websocket.FormatCloseMessage(CloseServerRestart, "server restarting")
+	case c.evicted.Load():
+		return websocket.FormatCloseMessage(CloseTooSlow, "client too slow")
+	case c.expired.Load():
+		return websocket.FormatCloseMessage(CloseSessionExpired, "session expired")
+	case c.duplicate.Load():
+		return websocket.FormatCloseMessage(CloseDuplicateSession, "duplicate session")
+	}
+	return websocket.FormatCloseMessage(CloseNormal, "")
+}
+
+// ClientCount returns the number of registered connections
+func (h *Hub) ClientCount() int {
 	h.mu.RLock()
-	client, ok := h.userMap[userID]
-	h.mu.RUnlock()
+	defer h.mu.RUnlock()
+	return len(h.clients)
+}
 
-	if !ok {
-		h.logger.Printf("User not connected: %d", userID)
-		return nil // User not connected
+// SetMirror enables republishing of opted-in conversations to a broker.
+// It must be called before Run.
+func (h *Hub) SetMirror(m *mirror.Mirror) {
+	h.mirror = m
+}
+
+// Mirror returns the configured broker mirror, or nil when disabled
+func (h *Hub) Mirror() *mirror.Mirror {
+	return h.mirror
+}
+
+// MirrorMessage queues a saved message for the broker if mirroring is
+// enabled and the conversation opted in. It never blocks the caller.
+func (h *Hub) MirrorMessage(message *models.Message, sender string) {
+	if h.mirror == nil {
+		return
+	}
+
+	enabled, err := h.db.IsConversationMirrored(h.ctx, message.ConversationID)
+	if err != nil {
+		h.logger.Printf("Failed to check mirror setting: %v", err)
+		return
+	}
+	if !enabled {
+		return
 	}
 
+	h.mirror.Enqueue(mirror.Event{
+		ConversationID: message.ConversationID,
+		MessageID:      message.ID,
+		SenderID:       message.SenderID,
+		Sender:         sender,
+		Preview:        message.Content,
+		Timestamp:      message.CreatedAt.UTC(),
+	})
+}
+
+// AllowSend applies per-user flood control to message sends, with the
+// separate bot limit for bot accounts. When the user is over budget it
+// returns false and how long to wait before retrying.
+func (h *Hub) AllowSend(userID int64, isBot bool) (bool, time.Duration) {
+	if isBot {
+		return h.botLimiter.Allow(userID)
+	}
+	return h.sendLimiter.Allow(userID)
+}
+
+func (h *Hub) SendToUser(userID int64, message interface{}) error {
 	data, err := json.Marshal(message)
 	if err != nil {
 		h.logger.Printf("Failed to marshal message: %v", err)
+		h.errs.Swallow(errsink.Marshal, "hub.send_to_user", err)
 		return err
 	}
+	// The user may also be connected to another instance
+	h.publish(0, data, []int64{userID}, false)
 
-	select {
-	case client.send <- data:
-		h.logger.Printf("Message sent to user: %d", userID)
-	default:
-		h.logger.Printf("Failed to send message to user: %d, removing client", userID)
-		h.mu.Lock()
-		close(client.send)
-		delete(h.clients, client)
-		delete(h.userMap, client.userID)
-		h.mu.Unlock()
+	h.mu.RLock()
+	conns := h.userMap[userID]
+	if len(conns) == 0 {
+		listening := h.keepForListeners(userID, data)
+		h.mu.RUnlock()
+		if !listening {
+			h.logger.Printf("User not connected: %d", userID)
+		}
+		return nil // User not connected
+	}
+	// Every connection gets the same frame, so each device sees one event ID
+	frame := h.replay.stamp(userID, data)
+	h.streams.send(userID, frame)
+	var evict []*Client
+	for client := range conns {
+		queued, slow := h.enqueue(client, frame)
+		if queued {
+			h.logger.Printf("Message sent to user: %d on connection %d", userID, client.id)
+			continue
+		}
+		h.logger.Printf("Failed to send message to user: %d on connection %d", userID, client.id)
+		h.errs.Swallow(errsink.Dropped, "hub.send_to_user", fmt.Errorf("queue full for user %d", userID))
+		if slow {
+			evict = append(evict, client)
+		}
 	}
+	h.mu.RUnlock()
 
+	h.evict(evict)
 	return nil
 }
 
+// removeClient forgets a registered client and closes its queue. It
+// reports whether that was the user's last connection. A client
+// that isn't registered, say one already reaped or evicted, is left alone,
+// so its queue is only ever closed once. The caller must hold h.mu for
+// writing.
+func (h *Hub) removeClient(client *Client) bool {
+	if !h.clients[client] {
+		return false
+	}
+	close(client.send)
+	client.cancel()
+	delete(h.clients, client)
+	conns := h.userMap[client.userID]
+	delete(conns, client)
+	if len(conns) > 0 {
+		return false
+	}
+	delete(h.userMap, client.userID)
+	return true
+}
+
+// DisconnectDevice closes every connection a user has open from a device
+// registration, telling the client why, and returns how many it closed.
+// The read pumps then unregister them as usual.
+func (h *Hub) DisconnectDevice(userID, deviceID int64) int {
+	h.mu.RLock()
+	var matched []*Client
+	for client := range h.clients {
+		if client.userID == userID && client.deviceID == deviceID {
+			matched = append(matched, client)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, client := range matched {
+		client.closeWith(CloseSessionRevoked, "device revoked")
+	}
+	return len(matched)
+}
+
 func (h *Hub) SendToConversation(conversationID int64, message interface{}, participants []int64) error {
+	_, err := h.sendToParticipants(conversationID, message, participants)
+	return err
+}
+
+// sendToParticipants queues message for every participant connected here,
+// publishes it for those connected to other instances, and returns the
+// users it was queued for locally
+func (h *Hub) sendToParticipants(conversationID int64, message interface{}, participants []int64) ([]int64, error) {
 	data, err := json.Marshal(message)
 	if err != nil {
 		h.logger.Printf("Failed to marshal conversation message: %v", err)
-		return err
+		h.errs.Swallow(errsink.Marshal, "hub.send_to_participants", err)
+		return nil, err
 	}
+	ephemeral := isEphemeral(message)
+	h.publish(conversationID, data, participants, ephemeral)
+	return h.deliver(conversationID, data, participants, ephemeral), nil
+}
 
+// deliver queues an encoded frame on every connection the participants
+// have open here and returns the users it was queued for on at least one.
+// Ephemeral frames skip connections that aren't subscribed to the
+// conversation.
+func (h *Hub) deliver(conversationID int64, data []byte, participants []int64, ephemeral bool) []int64 {
 	h.mu.RLock()
-	defer h.mu.RUnlock()
-
+	var sent []int64
+	var evict []*Client
 	for _, userID := range participants {
-		if client, ok := h.userMap[userID]; ok {
-			select {
-			case client.send <- data:
-				h.logger.Printf("Message sent to participant: %d in conversation: %d", userID, conversationID)
-			default:
-				h.logger.Printf("Failed to send message to participant: %d in conversation: %d", userID, conversationID)
+		conns := h.userMap[userID]
+		if len(conns) == 0 {
+			if !ephemeral && h.keepForListeners(userID, data) {
+				sent = append(sent, userID)
+			}
+			continue
+		}
+		frame := data
+		if !ephemeral {
+			frame = h.replay.stamp(userID, data)
+			h.streams.send(userID, frame)
+		}
+		reached := false
+		for client := range conns {
+			if ephemeral && !client.subs.wants(conversationID) {
+				continue
+			}
+			queued, slow := h.enqueue(client, frame)
+			if queued {
+				reached = true
 				continue
 			}
+			h.logger.Printf("Failed to send message to participant: %d on connection %d in conversation: %d", userID, client.id, conversationID)
+			h.errs.Swallow(errsink.Dropped, "hub.send_to_participants", fmt.Errorf("queue full for user %d", userID))
+			// The client missed a message, so it can't be trusted to have
+			// read what follows until it declares the conversation again
+			client.active.CompareAndSwap(conversationID, 0)
+			if slow {
+				evict = append(evict, client)
+			}
+		}
+		if reached {
+			h.logger.Printf("Message sent to participant: %d in conversation: %d", userID, conversationID)
+			sent = append(sent, userID)
 		}
 	}
+	h.mu.RUnlock()
 
-	return nil
+	h.evict(evict)
+	return sent
+}
+
+// queue returns the client's send channel, or nil when fault injection is
+// simulating a full queue; a send on nil never proceeds, so the caller's
+// select falls through to its full-queue branch
+func (h *Hub) queue(client *Client) chan<- []byte {
+	if h.chaos.SaturateHub() {
+		return nil
+	}
+	return client.send
 }
 
+// SetClock replaces the clock that times heartbeats and stale connections,
+// for tests. It must be called before Run and before any client connects.
+func (h *Hub) SetClock(c clock.Clock) {
+	h.clock = c
+}
+
+// SetChaos enables fault injection on client queues and frame writes. It
+// must be called before the hub starts serving.
+func (h *Hub) SetChaos(injector *chaos.Injector) {
+	h.chaos = injector
+}
+
+// evict removes clients that fell behind; their write pumps close the
+// connection with 1013 "client too slow". Ones already gone, because their
+// read pump unregistered them in the meantime, are skipped.
+func (h *Hub) evict(clients []*Client) {
+	h.disconnect(clients, func(client *Client) {
+		h.counters.evictions.Add(1)
+		client.evicted.Store(true)
+	})
+}
+
+// disconnect removes clients, calling mark on each still registered before
+// its queue is closed so its write pump sends the right close frame
+func (h *Hub) disconnect(clients []*Client, mark func(*Client)) {
+	if len(clients) == 0 {
+		return
+	}
+	var gone []int64
+	h.mu.Lock()
+	for _, client := range clients {
+		if _, ok := h.clients[client]; !ok {
+			continue
+		}
+		mark(client)
+		if h.removeClient(client) {
+			gone = append(gone, client.userID)
+		}
+	}
+	h.mu.Unlock()
+	for _, userID := range gone {
+		h.userDisconnected(userID)
+	}
+}
+
+// broadcastChunk is how many clients a broadcast queues to per hold of
+// h.mu, so senders aren't held up while it works through thousands
+const broadcastChunk = 256
+
+// broadcast queues data for every registered client, a chunk at a time.
+// Clients that register meanwhile miss it, and ones that go away are
+// skipped. Clients to evict are collected along the way and removed at the
+// end, never mid-iteration.
+func (h *Hub) broadcast(data []byte) {
+	h.mu.RLock()
+	clients := make([]*Client, 0, len(h.clients))
+	for client := range h.clients {
+		clients = append(clients, client)
+	}
+	h.mu.RUnlock()
+
+	sent := 0
+	var evict []*Client
+	for start := 0; start < len(clients); start += broadcastChunk {
+		end := start + broadcastChunk
+		if end > len(clients) {
+			end = len(clients)
+		}
+		h.mu.RLock()
+		for _, client := range clients[start:end] {
+			if !h.clients[client] {
+				continue
+			}
+			queued, slow := h.enqueue(client, data)
+			if queued {
+				sent++
+				continue
+			}
+			h.logger.Printf("Failed to send message to client: %s", logsafe.String(client.username))
+			if slow {
+				evict = append(evict, client)
+			}
+		}
+		h.mu.RUnlock()
+	}
+	h.logger.Printf("Broadcast message to %d of %d clients", sent, len(clients))
+	h.evict(evict)
+}
+
+// ErrBroadcastDropped is returned by BroadcastMessage when the broadcast
+// queue is full or the hub is shutting down
+var ErrBroadcastDropped = errors.New("broadcast dropped")
+
+// BroadcastMessage queues message for every connected client without
+// waiting on Run. When the broadcast queue is full the message is dropped
+// and counted rather than stalling the caller, which is usually a read pump.
 func (h *Hub) BroadcastMessage(message interface{}) error {
 	data, err := json.Marshal(message)
 	if err != nil {
 		h.logger.Printf("Failed to marshal broadcast message: %v", err)
+		h.errs.Swallow(errsink.Marshal, "hub.broadcast", err)
 		return err
 	}
 
-	h.Broadcast <- data
-	h.logger.Println("Message queued for broadcast")
+	if h.shuttingDown.Load() {
+		h.logger.Println("Hub is shut down, broadcast dropped")
+		return ErrBroadcastDropped
+	}
+	select {
+	case h.Broadcast <- data:
+		h.logger.Println("Message queued for broadcast")
+	default:
+		h.logger.Println("Broadcast queue full, message dropped")
+		h.counters.broadcastsDropped.Add(1)
+		h.errs.Swallow(errsink.Dropped, "hub.broadcast", errors.New("broadcast queue full"))
+		return ErrBroadcastDropped
+	}
 	return nil
 }
 
 func (c *Client) ReadPump() {
 	defer func() {
-		c.hub.Unregister <- c
+		select {
+		case c.hub.Unregister <- c:
+		case <-c.hub.done:
+			// Shutdown has already let go of every client
+		}
 		c.conn.Close()
 	}()
+	c.conn.SetReadLimit(c.hub.maxFrameBytes)
+	c.conn.SetPongHandler(func(string) error {
+		c.lastPong.Store(c.hub.clock.Now().UnixNano())
+		return nil
+	})
 
 	for {
 		_, message, err := c.conn.ReadMessage()
 		if err != nil {
+			// The connection has already sent CloseMessageTooBig itself
+			if errors.Is(err, websocket.ErrReadLimit) {
+				c.hub.logger.Printf("WARNING: closing connection for user %d: frame larger than %d bytes", c.userID, c.hub.maxFrameBytes)
+				break
+			}
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("error: %v", err)
+				log.Printf("error: %s", logsafe.Err(err))
 			}
 			break
 		}
+		c.framesReceived.Add(1)
+		c.lastReceived.Store(c.hub.clock.Now().UnixNano())
+
+		// The send queue may already be closed, so nothing more is handled
+		if c.hub.shuttingDown.Load() {
+			break
+		}
 
-		var wsMessage models.WebSocketMessage
+		var wsMessage models.IncomingWebSocketMessage
 		if err := json.Unmarshal(message, &wsMessage); err != nil {
-			log.Printf("error unmarshaling message: %v", err)
+			c.frameClientID = ""
+			// Garbage spends from the general budget like any other frame,
+			// so a flood of it is throttled and closed the same way
+			allowed, closed := c.allowFrame("")
+			if closed {
+				break
+			}
+			if !allowed {
+				continue
+			}
+			log.Printf("error unmarshaling message %s: %s", logsafe.Content(string(message)), logsafe.Err(err))
+			c.sendError("invalid_frame", "Frames must be JSON objects with a type and payload", nil)
+			continue
+		}
+		c.frameClientID = wsMessage.ClientID
+
+		allowed, closed := c.allowFrame(wsMessage.Type)
+		if closed {
+			break
+		}
+		if !allowed {
 			continue
 		}
 
 		// Handle different message types
 		switch wsMessage.Type {
 		case "message":
-			if msg, ok := wsMessage.Payload.(map[string]interface{}); ok {
-				conversationID := int64(msg["conversation_id"].(float64))
-				content := msg["content"].(string)
-
-				// Create and save the message to the database
-				newMessage := &models.Message{
-					ConversationID: conversationID,
-					SenderID:      c.userID,
-					Content:       content,
-					CreatedAt:     time.Now(),
-				}
-
-				// Save message to database
-				savedMessage, err := c.hub.db.SaveMessage(newMessage)
-				if err != nil {
-					log.Printf("Failed to save message: %v", err)
-					continue
-				}
-
-				// Create response message with saved message data
-				response := models.WebSocketMessage{
-					Type:    "message",
-					Payload: savedMessage,
-				}
-
-				// Send to all participants in the conversation
-				participants, err := c.hub.db.GetConversationParticipantIDs(conversationID)
-				if err != nil {
-					log.Printf("Failed to get conversation participants: %v", err)
-					continue
-				}
-
-				if err := c.hub.SendToConversation(conversationID, response, participants); err != nil {
-					log.Printf("Failed to broadcast message: %v", err)
-				}
+			var msg models.IncomingMessage
+			if !c.decodePayload(wsMessage, &msg) {
+				continue
+			}
+			if msg.ConversationID <= 0 || msg.Content == "" {
+				log.Printf("Malformed message payload from user %d: %s", c.userID, logsafe.Payload(msg))
+				c.sendError("invalid_message", "conversation_id and content are required", nil)
+				continue
+			}
+			if len(msg.Content) > models.MaxMessageContentBytes {
+				c.sendError("invalid_message", "content is too long", map[string]interface{}{
+					"max_bytes": models.MaxMessageContentBytes,
+				})
+				continue
 			}
+			c.handleMessage(msg)
 		case "typing":
-			if typing, ok := wsMessage.Payload.(map[string]interface{}); ok {
-				response := models.WebSocketMessage{
-					Type: "typing",
-					Payload: map[string]interface{}{
-						"user_id":         c.userID,
-						"conversation_id": typing["conversation_id"],
-						"is_typing":       typing["is_typing"],
-					},
-				}
-				c.hub.BroadcastMessage(response)
+			var typing models.TypingEvent
+			if !c.decodePayload(wsMessage, &typing) {
+				continue
+			}
+			if typing.ConversationID <= 0 {
+				c.sendError("invalid_typing", "conversation_id is required", nil)
+				continue
+			}
+			c.recordTyping(typing)
+		case "state":
+			var state models.StateEvent
+			if c.decodePayload(wsMessage, &state) {
+				c.handleState(state)
+			}
+		case "active":
+			var active models.ActiveEvent
+			if c.decodePayload(wsMessage, &active) {
+				c.handleActive(active)
+			}
+		case "auth_refresh":
+			var req models.AuthRefresh
+			if c.decodePayload(wsMessage, &req) {
+				c.handleAuthRefresh(req)
 			}
+		case "mark_read":
+			var req models.MarkReadRequest
+			if c.decodePayload(wsMessage, &req) {
+				c.handleMarkRead(req)
+			}
+		case "subscribe", "unsubscribe":
+			var req models.SubscriptionRequest
+			if c.decodePayload(wsMessage, &req) {
+				c.handleSubscription(wsMessage.Type, req)
+			}
+		case "resume":
+			var req models.ResumeRequest
+			if c.decodePayload(wsMessage, &req) {
+				c.handleResume(req)
+			}
+		case "sync":
+			var sync models.SyncRequest
+			if c.decodePayload(wsMessage, &sync) {
+				c.handleSync(sync)
+			}
+		case "heartbeat_ack":
+			var ack models.HeartbeatAck
+			if c.decodePayload(wsMessage, &ack) {
+				c.recordHeartbeatAck(time.Duration(ack.LatencyMS * float64(time.Millisecond)))
+			}
+		default:
+			c.sendError("unknown_type", "Unknown event type", map[string]interface{}{
+				"event": wsMessage.Type,
+			})
 		}
 	}
 }
 
+// decodePayload unmarshals a frame's payload into v. When the payload is
+// missing or doesn't fit, it tells the client which event was rejected and
+// why, and returns false so the read loop can move on.
+func (c *Client) decodePayload(msg models.IncomingWebSocketMessage, v interface{}) bool {
+	err := errors.New("payload is required")
+	if len(msg.Payload) > 0 && string(msg.Payload) != "null" {
+		err = json.Unmarshal(msg.Payload, v)
+	}
+	if err == nil {
+		return true
+	}
+	log.Printf("Malformed %s payload from user %d: %s", logsafe.String(msg.Type), c.userID, logsafe.Err(err))
+	c.sendError("invalid_payload", fmt.Sprintf("Invalid %s payload: %v", msg.Type, err), map[string]interface{}{
+		"event": msg.Type,
+	})
+	return false
+}
+
 func (c *Client) WritePump() {
+	var heartbeat <-chan time.Time
+	if c.hub.heartbeatInterval > 0 {
+		ticker := c.hub.clock.NewTicker(c.hub.heartbeatInterval)
+		defer ticker.Stop()
+		heartbeat = ticker.C()
+	}
+
 	defer func() {
 		c.conn.Close()
+		c.hub.pumps.Done()
 	}()
 
 	for {
 		select {
 		case message, ok := <-c.send:
 			if !ok {
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				c.conn.WriteMessage(websocket.CloseMessage, c.closeFrame())
 				return
 			}
 
-			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+			if c.resync.Swap(false) {
+				if data, err := resyncFrame(); err == nil {
+					if err := c.write(data); err != nil {
+						return
+					}
+				}
+			}
+			if err := c.write(message); err != nil {
+				return
+			}
+
+		case <-heartbeat:
+			// Pings go out regardless of traffic, so the janitor can tell a
+			// client that stopped answering from one that's merely quiet.
+			// The write deadline is the socket's, so it's in real time.
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(closeWait)); err != nil {
+				return
+			}
+			// Real traffic within the window already proves the connection
+			// is alive, so skip the heartbeat
+			now := c.hub.clock.Now()
+			if now.Sub(time.Unix(0, c.lastSent.Load())) < c.hub.heartbeatInterval {
+				continue
+			}
+
+			data, err := json.Marshal(models.WebSocketMessage{
+				Type: "heartbeat",
+				Payload: map[string]interface{}{
+					"server_time": now.UTC(),
+					"seq":         c.seq.Load() + 1,
+				},
+			})
+			if err != nil {
+				c.hub.logger.Printf("Failed to marshal heartbeat: %v", err)
+				c.hub.errs.Swallow(errsink.Marshal, "ws.heartbeat", err)
+				continue
+			}
+			if err := c.write(data); err != nil {
 				return
 			}
 		}
 	}
+}
+
+// sendError queues an "error" event for this client only, answering the
+// frame ReadPump is handling
+func (c *Client) sendError(code, message string, fields map[string]interface{}) {
+	c.sendErrorFor(c.frameClientID, code, message, fields)
+}
+
+// sendErrorFor is sendError for work done off ReadPump, answering the
+// frame with clientID
+func (c *Client) sendErrorFor(clientID, code, message string, fields map[string]interface{}) {
+	payload := map[string]interface{}{
+		"code":    code,
+		"message": message,
+	}
+	if clientID != "" {
+		payload["client_id"] = clientID
+	}
+	for k, v := range fields {
+		payload[k] = v
+	}
+
+	data, err := json.Marshal(models.WebSocketMessage{Type: "error", Payload: payload})
+	if err != nil {
+		c.hub.logger.Printf("Failed to marshal error event: %v", err)
+		c.hub.errs.Swallow(errsink.Marshal, "ws.error_event", err)
+		return
+	}
+
+	if !c.enqueue(data) {
+		c.hub.logger.Printf("Dropped error event for client: %s", logsafe.String(c.username))
+		c.hub.errs.Swallow(errsink.Dropped, "ws.error_event", fmt.Errorf("queue full for user %d", c.userID))
+	}
+}
+
+// write sends a single text frame and advances the connection's sequence counter
+func (c *Client) write(data []byte) error {
+	if c.hub.chaos.FailWSWrite() {
+		return chaos.ErrInjected
+	}
+	if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		return err
+	}
+	c.seq.Add(1)
+	c.lastSent.Store(c.hub.clock.Now().UnixNano())
+	return nil
+}
+
+// recordHeartbeatAck stores the round-trip latency the client observed
+// and adds it to the hub's histogram
+func (c *Client) recordHeartbeatAck(rtt time.Duration) {
+	c.hub.counters.heartbeatRTT.observe(rtt)
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+	c.lastRTT = rtt
+	c.lastAckAt = c.hub.clock.Now()
+	c.heartbeatAcks++
 } 
\ No newline at end of file
@@ -0,0 +1,85 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+
+	"messager/internal/clock"
+	"messager/internal/config"
+	"messager/internal/models"
+)
+
+func TestHeartbeatOnIdleConnection(t *testing.T) {
+	h, _, f := newTestHub(t, func(cfg *config.Config) {
+		cfg.WSHeartbeatInterval = 30 * time.Second
+		cfg.WSStaleAfter = 0
+	})
+	clk := clock.NewFake(time.Now())
+	h.SetClock(clk)
+	startHub(t, h)
+
+	conn, _ := dial(t, h, f.Alice)
+	readUntil(t, conn, "system") // the welcome, frame 1
+	if !clk.WaitForTickers(1, frameWait) {
+		t.Fatal("write pump never started its heartbeat ticker")
+	}
+
+	clk.Advance(30 * time.Second)
+	hb := readFrame(t, conn)
+	if hb.Type != "heartbeat" {
+		t.Fatalf("got %s frame, want heartbeat", hb.Type)
+	}
+	if seq := hb.Payload["seq"]; seq != float64(2) {
+		t.Errorf("heartbeat seq = %v, want 2", seq)
+	}
+	want := clk.Now().UTC().Format(time.RFC3339Nano)
+	if got := hb.Payload["server_time"]; got != want {
+		t.Errorf("heartbeat server_time = %v, want %s", got, want)
+	}
+}
+
+func TestHeartbeatSkippedAfterRecentTraffic(t *testing.T) {
+	h, _, f := newTestHub(t, func(cfg *config.Config) {
+		cfg.WSHeartbeatInterval = 30 * time.Second
+		cfg.WSStaleAfter = 0
+	})
+	clk := clock.NewFake(time.Now())
+	h.SetClock(clk)
+	startHub(t, h)
+
+	conn, _ := dial(t, h, f.Alice)
+	readUntil(t, conn, "system")
+	if !clk.WaitForTickers(1, frameWait) {
+		t.Fatal("write pump never started its heartbeat ticker")
+	}
+
+	// A frame 10s before the tick proves the connection is alive
+	clk.Advance(20 * time.Second)
+	if err := h.SendToUser(f.Alice.ID, models.WebSocketMessage{Type: "notice"}); err != nil {
+		t.Fatal(err)
+	}
+	readUntil(t, conn, "notice")
+	clk.Advance(10 * time.Second)
+	expectNoFrame(t, conn, 200*time.Millisecond)
+
+	// 40s without traffic by the next tick
+	clk.Advance(30 * time.Second)
+	if hb := readFrame(t, conn); hb.Type != "heartbeat" {
+		t.Fatalf("got %s frame, want heartbeat", hb.Type)
+	}
+}
+
+func TestNoHeartbeatWhenDisabled(t *testing.T) {
+	h, _, f := newTestHub(t, func(cfg *config.Config) {
+		cfg.WSHeartbeatInterval = 0
+		cfg.WSStaleAfter = 0
+	})
+	clk := clock.NewFake(time.Now())
+	h.SetClock(clk)
+	startHub(t, h)
+
+	conn, _ := dial(t, h, f.Alice)
+	readUntil(t, conn, "system")
+	clk.Advance(time.Hour)
+	expectNoFrame(t, conn, 200*time.Millisecond)
+}
@@ -0,0 +1,46 @@
+package websocket
+
+import "time"
+
+// janitorInterval is how often Run looks for stale connections
+const janitorInterval = 30 * time.Second
+
+// lastHeard is when anything, a frame or a pong, last arrived from the
+// client, or when it connected if nothing has
+func (c *Client) lastHeard() time.Time {
+	last := c.connectedAt
+	for _, nanos := range []int64{c.lastReceived.Load(), c.lastPong.Load()} {
+		if t := time.Unix(0, nanos); nanos != 0 && t.After(last) {
+			last = t
+		}
+	}
+	return last
+}
+
+// reapStale removes and closes connections nothing has been heard from for
+// longer than staleAfter. Pings go out every heartbeat interval, so a live
+// client answers well within it; one that doesn't has usually lost its
+// network without the socket noticing, or its pumps have wedged.
+func (h *Hub) reapStale(now time.Time) {
+	h.mu.RLock()
+	var stale []*Client
+	for client := range h.clients {
+		if now.Sub(client.lastHeard()) > h.staleAfter {
+			stale = append(stale, client)
+		}
+	}
+	h.mu.RUnlock()
+	if len(stale) == 0 {
+		return
+	}
+
+	h.disconnect(stale, func(client *Client) {
+		h.counters.reaped.Add(1)
+		h.forgetFrameBudget(client)
+	})
+	for _, client := range stale {
+		h.logger.Printf("Reaped stale connection %d for user %d, last heard %s ago",
+			client.id, client.userID, now.Sub(client.lastHeard()).Round(time.Second))
+		client.closeWith(CloseStale, "connection stale")
+	}
+}
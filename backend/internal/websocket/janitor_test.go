@@ -0,0 +1,47 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+
+	"messager/internal/clock"
+	"messager/internal/config"
+)
+
+func TestReapStaleConnections(t *testing.T) {
+	h, _, f := newTestHub(t, func(cfg *config.Config) {
+		cfg.WSHeartbeatInterval = 10 * time.Second
+		cfg.WSStaleAfter = 30 * time.Second
+	})
+	clk := clock.NewFake(time.Now())
+	h.SetClock(clk)
+	startHub(t, h)
+
+	// quiet never answers a ping
+	quiet, _ := dialSilent(t, h, f.Alice)
+	chatty, chattyClient := dial(t, h, f.Bob)
+	// Run's janitor and a heartbeat ticker per connection
+	if !clk.WaitForTickers(3, frameWait) {
+		t.Fatal("tickers never started")
+	}
+
+	clk.Advance(20 * time.Second)
+	send(t, chatty, "heartbeat_ack", map[string]interface{}{"latency_ms": 5})
+	waitFor(t, "the ack to be read", func() bool { return chattyClient.framesReceived.Load() == 1 })
+
+	// The janitor runs at 40s: quiet was last heard from at 0, chatty at 20
+	clk.Advance(20 * time.Second)
+	if code := readClose(t, quiet); code != CloseStale {
+		t.Fatalf("quiet connection closed with %d, want %d", code, CloseStale)
+	}
+	waitFor(t, "the reaped client to be removed", func() bool { return h.ClientCount() == 1 })
+	if got := h.counters.reaped.Load(); got != 1 {
+		t.Errorf("reaped = %d, want 1", got)
+	}
+	h.mu.RLock()
+	_, kept := h.clients[chattyClient]
+	h.mu.RUnlock()
+	if !kept {
+		t.Error("the connection heard from 20s ago was reaped too")
+	}
+}
@@ -0,0 +1,80 @@
+package websocket
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// lastSeenRefresh is how often connected users' last_seen_at is
+	// rewritten, so a crash loses at most this much
+	lastSeenRefresh = 5 * time.Minute
+
+	// lastSeenMinGap is the least time between two writes for one user,
+	// which keeps reconnect churn from turning into a write per connection
+	lastSeenMinGap = time.Minute
+)
+
+// lastSeenRecorder remembers when each user's last_seen_at was written so
+// writes can be skipped until they'd change something worth storing
+type lastSeenRecorder struct {
+	mu      sync.Mutex
+	written map[int64]time.Time
+}
+
+func newLastSeenRecorder() *lastSeenRecorder {
+	return &lastSeenRecorder{written: make(map[int64]time.Time)}
+}
+
+// due returns the users among userIDs whose last write is at least gap old
+// and marks them written at now
+func (r *lastSeenRecorder) due(userIDs []int64, gap time.Duration, now time.Time) []int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var due []int64
+	for _, id := range userIDs {
+		if at, ok := r.written[id]; ok && now.Sub(at) < gap {
+			continue
+		}
+		r.written[id] = now
+		due = append(due, id)
+	}
+	return due
+}
+
+// prune forgets writes older than lastSeenRefresh, which due would no
+// longer skip anyway
+func (r *lastSeenRecorder) prune(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, at := range r.written {
+		if now.Sub(at) >= lastSeenRefresh {
+			delete(r.written, id)
+		}
+	}
+}
+
+// RecordLastSeen stores that userIDs were connected just now, skipping
+// users written within gap. Every last_seen_at write goes through here, so
+// this is where a setting to hide it would apply.
+func (h *Hub) RecordLastSeen(userIDs []int64, gap time.Duration) {
+	now := time.Now().UTC()
+	due := h.lastSeen.due(userIDs, gap, now)
+	if len(due) == 0 {
+		return
+	}
+	if err := h.db.TouchLastSeen(h.ctx, due, now); err != nil {
+		h.logger.Printf("Failed to record last seen for %d users: %v", len(due), err)
+	}
+}
+
+// connectedUserIDs returns every user with an open connection
+func (h *Hub) connectedUserIDs() []int64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	ids := make([]int64, 0, len(h.userMap))
+	for id := range h.userMap {
+		ids = append(ids, id)
+	}
+	return ids
+}
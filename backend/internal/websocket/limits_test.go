@@ -0,0 +1,58 @@
+package websocket
+
+import (
+	"strings"
+	"testing"
+
+	"messager/internal/config"
+	"messager/internal/models"
+)
+
+func TestOversizedFrameClosesConnection(t *testing.T) {
+	h, _, f := newTestHub(t, func(cfg *config.Config) {
+		cfg.WSMaxFrameBytes = minFrameBytes
+	})
+	startHub(t, h)
+	alice, _ := dial(t, h, f.Alice)
+
+	sendRaw(t, alice, `{"type":"message","payload":{"content":"`+strings.Repeat("x", minFrameBytes)+`"}}`)
+	if code := readClose(t, alice); code != CloseMessageTooBig {
+		t.Errorf("closed with %d, want %d", code, CloseMessageTooBig)
+	}
+	waitFor(t, "alice to be unregistered", func() bool { return h.ClientCount() == 0 })
+}
+
+func TestFrameLimitFitsLargestMessage(t *testing.T) {
+	h, _, f := newTestHub(t, func(cfg *config.Config) {
+		cfg.WSMaxFrameBytes = 1024
+	})
+	if h.maxFrameBytes != minFrameBytes {
+		t.Fatalf("frame limit %d, want it raised to %d", h.maxFrameBytes, minFrameBytes)
+	}
+	startHub(t, h)
+	alice, _ := dial(t, h, f.Alice)
+
+	// Control characters are escaped as \u00XX, six bytes for each one
+	largest := strings.Repeat("\x01", models.MaxMessageContentBytes)
+	sendMessage(t, alice, f.Direct.ID, largest)
+	readMessage(t, alice, largest)
+}
+
+func TestOverlongContentRejected(t *testing.T) {
+	h, _, f := newTestHub(t, nil)
+	startHub(t, h)
+	alice, _ := dial(t, h, f.Alice)
+
+	sendMessage(t, alice, f.Direct.ID, strings.Repeat("é", models.MaxMessageContentBytes/2+1))
+	got := readUntil(t, alice, "error")
+	if got.Payload["code"] != "invalid_message" || got.Payload["max_bytes"] != float64(models.MaxMessageContentBytes) {
+		t.Fatalf("error %v, want invalid_message with max_bytes", got.Payload)
+	}
+	if stored := storedContents(t, h, f.Direct.ID); len(stored) != 2 {
+		t.Errorf("%d distinct messages stored, want only the 2 seeded", len(stored))
+	}
+
+	// The connection stays usable
+	sendMessage(t, alice, f.Direct.ID, "shorter")
+	readMessage(t, alice, "shorter")
+}
@@ -0,0 +1,76 @@
+package websocket
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	gorilla "github.com/gorilla/websocket"
+	"messager/internal/logsafe"
+)
+
+// logBuffer collects log output written from any goroutine
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// captureLogs sends the standard logger and h's logger to a buffer until
+// the test ends
+func captureLogs(t *testing.T, h *Hub) *logBuffer {
+	t.Helper()
+	logs := &logBuffer{}
+	log.SetOutput(logs)
+	h.logger.SetOutput(logs)
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		h.logger.SetOutput(os.Stdout)
+	})
+	return logs
+}
+
+func TestMessageContentRedactedInLogs(t *testing.T) {
+	h, _, f := newTestHub(t, nil)
+	logs := captureLogs(t, h)
+	startHub(t, h)
+	alice, _ := dial(t, h, f.Alice)
+
+	const secret = "the vault code is 0451"
+	sendBad := func() {
+		t.Helper()
+		// Not JSON, then a message without a conversation
+		if err := alice.WriteMessage(gorilla.TextMessage, []byte("{"+secret)); err != nil {
+			t.Fatal(err)
+		}
+		readUntil(t, alice, "error")
+		send(t, alice, "message", map[string]interface{}{"content": secret})
+		readUntil(t, alice, "error")
+	}
+
+	sendBad()
+	if got := logs.String(); strings.Contains(got, "vault") || !strings.Contains(got, "[redacted ") {
+		t.Fatalf("content reached the log, or no placeholder was logged:\n%s", got)
+	}
+
+	logsafe.SetLogContent(true)
+	defer logsafe.SetLogContent(false)
+	sendBad()
+	if !strings.Contains(logs.String(), "vault") {
+		t.Error("content isn't logged with LOG_MESSAGE_CONTENT on")
+	}
+}
@@ -0,0 +1,115 @@
+package websocket
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// A connection remembers up to membershipCacheSize conversations its user
+// was found to be in, for up to membershipCacheTTL, so sending a message
+// doesn't cost a membership query every time. Removals made through this
+// instance drop the entry at once; the TTL bounds how long one made
+// elsewhere goes unnoticed.
+const (
+	membershipCacheSize = 64
+	membershipCacheTTL  = time.Minute
+)
+
+// membershipCache is a small LRU of conversations confirmed to include the
+// connection's user. Only positive answers are kept, so joining a
+// conversation takes effect on the next message. The zero value is ready
+// to use.
+type membershipCache struct {
+	mu      sync.Mutex
+	order   list.List // of *membershipEntry, most recently used first
+	entries map[int64]*list.Element
+}
+
+type membershipEntry struct {
+	conversationID int64
+	checkedAt      time.Time
+}
+
+// has reports whether conversationID was confirmed within the TTL
+func (m *membershipCache) has(conversationID int64, now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	el, ok := m.entries[conversationID]
+	if !ok {
+		return false
+	}
+	if now.Sub(el.Value.(*membershipEntry).checkedAt) > membershipCacheTTL {
+		m.order.Remove(el)
+		delete(m.entries, conversationID)
+		return false
+	}
+	m.order.MoveToFront(el)
+	return true
+}
+
+// add remembers conversationID as confirmed at now, evicting the least
+// recently used entry when full
+func (m *membershipCache) add(conversationID int64, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.entries == nil {
+		m.entries = make(map[int64]*list.Element)
+	}
+	if el, ok := m.entries[conversationID]; ok {
+		el.Value.(*membershipEntry).checkedAt = now
+		m.order.MoveToFront(el)
+		return
+	}
+	if m.order.Len() >= membershipCacheSize {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.entries, oldest.Value.(*membershipEntry).conversationID)
+	}
+	m.entries[conversationID] = m.order.PushFront(&membershipEntry{conversationID: conversationID, checkedAt: now})
+}
+
+// forget drops conversationID
+func (m *membershipCache) forget(conversationID int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if el, ok := m.entries[conversationID]; ok {
+		m.order.Remove(el)
+		delete(m.entries, conversationID)
+	}
+}
+
+// isMember reports whether the client's user is in conversationID, from
+// the connection's cache when it can
+func (c *Client) isMember(conversationID int64) (bool, error) {
+	now := time.Now()
+	if c.members.has(conversationID, now) {
+		return true, nil
+	}
+	isParticipant, err := c.hub.db.IsConversationParticipant(c.ctx, conversationID, c.userID)
+	if err != nil {
+		return false, err
+	}
+	if isParticipant {
+		c.members.add(conversationID, now)
+	}
+	return isParticipant, nil
+}
+
+// ForgetMembership tells the connections of userIDs that they're no longer
+// in conversationID, after they leave or are removed or it's deleted. The
+// conversation's cached participants are dropped too.
+func (h *Hub) ForgetMembership(conversationID int64, userIDs ...int64) {
+	h.ForgetParticipants(conversationID)
+	gone := make(map[int64]bool, len(userIDs))
+	for _, userID := range userIDs {
+		gone[userID] = true
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for client := range h.clients {
+		if gone[client.userID] {
+			client.members.forget(conversationID)
+		}
+	}
+}
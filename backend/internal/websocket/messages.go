@@ -0,0 +1,211 @@
+package websocket
+
+import (
+	"sync"
+	"time"
+
+	"messager/internal/errsink"
+	"messager/internal/models"
+)
+
+type dedupeKey struct {
+	senderID       int64
+	conversationID int64
+}
+
+// recentSend is a sender's last message in a conversation. It's pending
+// from the moment a send claims it until the message is saved, and identical
+// sends in between wait on done instead of inserting their own.
+type recentSend struct {
+	content string
+	message *models.Message
+	at      time.Time
+	pending bool
+	done    chan struct{}
+}
+
+// dedupeCache remembers each sender's last message per conversation so a
+// double-clicked send or a client retry within the window returns the
+// original message instead of inserting a copy
+type dedupeCache struct {
+	mu     sync.Mutex
+	window time.Duration
+	recent map[dedupeKey]*recentSend
+}
+
+func newDedupeCache(window time.Duration) *dedupeCache {
+	return &dedupeCache{
+		window: window,
+		recent: make(map[dedupeKey]*recentSend),
+	}
+}
+
+// claim returns the message an identical send within the window produced,
+// waiting for it if that send is still being saved. Otherwise it reserves
+// key for the caller, who must settle the returned entry once the save is
+// done. Both results are nil when deduplication is off.
+func (d *dedupeCache) claim(key dedupeKey, content string) (*models.Message, *recentSend) {
+	if d.window <= 0 {
+		return nil, nil
+	}
+
+	for {
+		d.mu.Lock()
+		last, ok := d.recent[key]
+		if ok && last.content == content && last.pending {
+			d.mu.Unlock()
+			<-last.done
+			// The save may have failed, in which case the next lap
+			// claims the key
+			continue
+		}
+		if ok && last.content == content && time.Since(last.at) < d.window {
+			d.mu.Unlock()
+			return last.message, nil
+		}
+		entry := &recentSend{content: content, at: time.Now(), pending: true, done: make(chan struct{})}
+		d.recent[key] = entry
+		d.mu.Unlock()
+		return nil, entry
+	}
+}
+
+// settle records the outcome of a claimed send and wakes the sends waiting
+// on it. A nil message means the save failed and the claim is dropped.
+func (d *dedupeCache) settle(key dedupeKey, entry *recentSend, message *models.Message) {
+	if entry == nil {
+		return
+	}
+
+	d.mu.Lock()
+	entry.message = message
+	entry.at = time.Now()
+	entry.pending = false
+	if message == nil && d.recent[key] == entry {
+		delete(d.recent, key)
+	}
+	d.mu.Unlock()
+	close(entry.done)
+}
+
+// prune drops entries that can no longer match, keeping pending ones
+func (d *dedupeCache) prune() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for key, last := range d.recent {
+		if !last.pending && time.Since(last.at) >= d.window {
+			delete(d.recent, key)
+		}
+	}
+}
+
+// DeliverMessage fans a stored message out to the connected participants
+// and advances the delivered marker of every recipient it reached, which is
+// what the receipts summary counts as delivered. Recipients who muted the
+// conversation still get the message, flagged muted, unless it mentions
+// them and they haven't muted mentions. Recipients with the conversation
+// active also have it marked read, and mentioned participants get a
+// notification. Once it's done the outbox stops holding the message for
+// redelivery.
+func (h *Hub) DeliverMessage(message *models.Message, participants []int64) error {
+	loud, quiet := h.splitMuted(message, participants)
+
+	sent, err := h.sendToParticipants(message.ConversationID, models.WebSocketMessage{
+		Type:    "message",
+		Payload: message,
+	}, loud)
+	if err != nil {
+		return err
+	}
+	if len(quiet) > 0 {
+		sentQuiet, err := h.sendToParticipants(message.ConversationID, models.WebSocketMessage{
+			Type:    "message",
+			Payload: message,
+			Muted:   true,
+		}, quiet)
+		if err != nil {
+			return err
+		}
+		sent = append(sent, sentQuiet...)
+	}
+
+	recipients := others(sent, message.SenderID)
+	if len(recipients) > 0 {
+		go func() {
+			if err := h.db.MarkDelivered(h.ctx, message.ConversationID, message.ID, recipients); err != nil {
+				h.logger.Printf("Failed to record delivery of message %d: %v", message.ID, err)
+				h.errs.Swallow(errsink.Store, "hub.mark_delivered", err)
+			}
+		}()
+	}
+	h.advanceViewers(message, recipients)
+	go h.notifyMentions(message, participants)
+	h.outbox.record(message.ID)
+	return nil
+}
+
+// splitMuted separates participants who should be alerted about message from
+// those who muted the conversation. If mutes can't be loaded everyone is
+// alerted rather than anyone missing the message.
+func (h *Hub) splitMuted(message *models.Message, participants []int64) (loud, quiet []int64) {
+	mutes, err := h.db.GetActiveMutes(h.ctx, message.ConversationID, time.Now())
+	if err != nil {
+		h.logger.Printf("Failed to load mutes for conversation %d: %v", message.ConversationID, err)
+		return participants, nil
+	}
+	if len(mutes) == 0 {
+		return participants, nil
+	}
+
+	now := time.Now()
+	for _, userID := range participants {
+		mute, ok := mutes[userID]
+		if !ok || userID == message.SenderID || !mute.Silences(now, h.mentions(message, userID)) {
+			loud = append(loud, userID)
+			continue
+		}
+		quiet = append(quiet, userID)
+	}
+	return loud, quiet
+}
+
+// mentions reports whether a user message @-mentions userID
+func (h *Hub) mentions(message *models.Message, userID int64) bool {
+	if message.IsSystem() || message.DeletedAt != nil {
+		return false
+	}
+	username, err := h.db.Username(h.ctx, userID)
+	if err != nil {
+		return false
+	}
+	return models.MentionsUser(message.Content, username)
+}
+
+// CreateMessage is the single write path for user messages, shared by the
+// HTTP send endpoint and the websocket. It suppresses byte-identical resends
+// within the configured window, returning the original message with
+// duplicate set, and mirrors new messages to the broker. Fan-out is left to
+// the caller. A resend that arrives while the original is still being saved
+// waits for it rather than racing it into the database.
+func (h *Hub) CreateMessage(conversationID, senderID int64, senderName, content string) (*models.Message, bool, error) {
+	key := dedupeKey{senderID: senderID, conversationID: conversationID}
+	existing, claim := h.dedupe.claim(key, content)
+	if existing != nil {
+		h.logger.Printf("Suppressed duplicate message from user %d in conversation %d", senderID, conversationID)
+		return existing, true, nil
+	}
+
+	message, err := h.db.SaveMessage(h.ctx, &models.Message{
+		ConversationID: conversationID,
+		SenderID:       senderID,
+		Content:        content,
+	})
+	h.dedupe.settle(key, claim, message)
+	if err != nil {
+		return nil, false, err
+	}
+
+	h.MirrorMessage(message, senderName)
+	return message, false, nil
+}
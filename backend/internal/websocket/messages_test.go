@@ -0,0 +1,107 @@
+package websocket
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"messager/internal/config"
+)
+
+func TestCreateMessageSuppressesRapidDuplicates(t *testing.T) {
+	h, _, f := newTestHub(t, func(cfg *config.Config) {
+		cfg.MessageDedupeWindow = time.Minute
+	})
+	first, dup, err := h.CreateMessage(f.Group.ID, f.Alice.ID, "alice", "ship it")
+	if err != nil || dup {
+		t.Fatalf("first send: duplicate=%t err=%v", dup, err)
+	}
+	again, dup, err := h.CreateMessage(f.Group.ID, f.Alice.ID, "alice", "ship it")
+	if err != nil || !dup || again.ID != first.ID {
+		t.Fatalf("resend: got message %d duplicate=%t err=%v, want the original %d", again.ID, dup, err, first.ID)
+	}
+
+	// Different content, sender or conversation is a new message
+	for _, tc := range []struct {
+		conversation, sender int64
+		content              string
+	}{
+		{f.Group.ID, f.Alice.ID, "ship it now"},
+		{f.Group.ID, f.Bob.ID, "ship it"},
+		{f.Direct.ID, f.Alice.ID, "ship it"},
+	} {
+		if msg, dup, err := h.CreateMessage(tc.conversation, tc.sender, "", tc.content); err != nil || dup || msg.ID == first.ID {
+			t.Errorf("%+v: treated as a duplicate (err %v)", tc, err)
+		}
+	}
+}
+
+func TestCreateMessageDedupeDisabled(t *testing.T) {
+	h, _, f := newTestHub(t, func(cfg *config.Config) {
+		cfg.MessageDedupeWindow = 0
+	})
+	first, _, err := h.CreateMessage(f.Group.ID, f.Alice.ID, "alice", "ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, dup, _ := h.CreateMessage(f.Group.ID, f.Alice.ID, "alice", "ok"); dup || again.ID == first.ID {
+		t.Error("resend suppressed with the window disabled")
+	}
+}
+
+func TestConcurrentDuplicatesInsertOnce(t *testing.T) {
+	h, _, f := newTestHub(t, func(cfg *config.Config) {
+		cfg.MessageDedupeWindow = time.Minute
+	})
+
+	const senders = 8
+	ids := make(chan int64, senders)
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			msg, _, err := h.CreateMessage(f.Group.ID, f.Alice.ID, "alice", "double click")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			ids <- msg.ID
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	var first int64
+	for id := range ids {
+		if first == 0 {
+			first = id
+		} else if id != first {
+			t.Errorf("sends returned messages %d and %d, want the same one", first, id)
+		}
+	}
+	if n := storedContents(t, h, f.Group.ID)["double click"]; n != 1 {
+		t.Errorf("stored %d copies, want 1", n)
+	}
+}
+
+func TestSocketResendGetsTheOriginalBack(t *testing.T) {
+	h, _, f := newTestHub(t, func(cfg *config.Config) {
+		cfg.MessageDedupeWindow = time.Minute
+	})
+	startHub(t, h)
+	alice, _ := dial(t, h, f.Alice)
+	bob, _ := dial(t, h, f.Bob)
+	readUntil(t, alice, "system")
+	readUntil(t, bob, "system")
+
+	sendMessage(t, alice, f.Group.ID, "retry me")
+	original := readUntil(t, alice, "message").Payload["id"]
+	readMessage(t, bob, "retry me")
+
+	sendMessage(t, alice, f.Group.ID, "retry me")
+	if again := readUntil(t, alice, "message").Payload["id"]; again != original {
+		t.Errorf("resend answered with message %v, want the original %v", again, original)
+	}
+	expectNoFrameOfType(t, bob, "message", 100*time.Millisecond)
+}
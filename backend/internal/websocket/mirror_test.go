@@ -0,0 +1,54 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"messager/internal/delivery"
+	"messager/internal/mirror"
+)
+
+// chanPublisher hands every publish to a channel
+type chanPublisher chan []byte
+
+func (p chanPublisher) Publish(subject string, data []byte) error {
+	p <- data
+	return nil
+}
+
+func (p chanPublisher) Close() error { return nil }
+
+func TestMirrorOnlyOptedInConversations(t *testing.T) {
+	h, d, f := newTestHub(t, nil)
+	pub := make(chanPublisher, 4)
+	m := mirror.New(pub, "chat.{conversation_id}", delivery.Limits{MaxBacklog: 4})
+	t.Cleanup(func() { m.Close() })
+	h.SetMirror(m)
+	startHub(t, h)
+
+	if err := d.SetConversationMirror(context.Background(), f.Group.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	h.MirrorMessage(f.Messages[0], "alice") // the direct conversation's
+	h.MirrorMessage(f.Messages[2], "alice") // the group's
+
+	select {
+	case data := <-pub:
+		var ev mirror.Event
+		if err := json.Unmarshal(data, &ev); err != nil {
+			t.Fatal(err)
+		}
+		if ev.MessageID != f.Messages[2].ID || ev.Sender != "alice" || ev.Preview != f.Messages[2].Content {
+			t.Fatalf("mirrored %+v, want the group message", ev)
+		}
+	case <-time.After(frameWait):
+		t.Fatal("the opted-in conversation wasn't mirrored")
+	}
+	select {
+	case data := <-pub:
+		t.Fatalf("mirrored a conversation that didn't opt in: %s", data)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
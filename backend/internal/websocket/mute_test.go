@@ -0,0 +1,76 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"messager/internal/models"
+)
+
+func TestMutedConversationsDeliverQuietly(t *testing.T) {
+	h, d, f := newTestHub(t, nil)
+	startHub(t, h)
+	ctx := context.Background()
+	alice, _ := dial(t, h, f.Alice)
+	bob, _ := dial(t, h, f.Bob)
+	carol, _ := dial(t, h, f.Carol)
+
+	forever := models.MuteForever
+	if err := d.SetMute(ctx, f.Group.ID, f.Bob.ID, &forever, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetMute(ctx, f.Group.ID, f.Carol.ID, &forever, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// muted reads the next message frame with content and reports its flag
+	muted := func(conn *testConn, content string) bool {
+		t.Helper()
+		for {
+			select {
+			case data, ok := <-conn.frames:
+				if !ok {
+					t.Fatalf("connection ended: %v", <-conn.err)
+				}
+				var f struct {
+					Type    string         `json:"type"`
+					Payload models.Message `json:"payload"`
+					Muted   bool           `json:"muted"`
+				}
+				if json.Unmarshal(data, &f) == nil && f.Type == "message" && f.Payload.Content == content {
+					return f.Muted
+				}
+			case <-time.After(frameWait):
+				t.Fatalf("no message %q", content)
+			}
+		}
+	}
+
+	sendMessage(t, alice, f.Group.ID, "lunch?")
+	if muted(alice, "lunch?") {
+		t.Error("the sender's own copy was flagged muted")
+	}
+	if !muted(bob, "lunch?") || !muted(carol, "lunch?") {
+		t.Error("members who muted the group got an alerting message")
+	}
+
+	// A mention breaks through unless mentions are muted too
+	sendMessage(t, alice, f.Group.ID, "@bob @carol lunch?")
+	if muted(bob, "@bob @carol lunch?") {
+		t.Error("bob's mention was delivered muted")
+	}
+	if !muted(carol, "@bob @carol lunch?") {
+		t.Error("carol muted mentions but was alerted")
+	}
+
+	// Unmuting restores normal delivery
+	if err := d.SetMute(ctx, f.Group.ID, f.Bob.ID, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	sendMessage(t, alice, f.Group.ID, "now?")
+	if muted(bob, "now?") {
+		t.Error("still muted after unmuting")
+	}
+}
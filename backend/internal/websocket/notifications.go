@@ -0,0 +1,81 @@
+package websocket
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"messager/internal/errsink"
+	"messager/internal/models"
+)
+
+// NotifyUser stores a notification for userID and pushes it to their
+// connections as a "notification" event. Offline users find it in
+// GET /api/notifications.
+func (h *Hub) NotifyUser(userID int64, n models.Notification) (*models.Notification, error) {
+	n.UserID = userID
+	if err := h.db.CreateNotification(h.ctx, &n); err != nil {
+		return nil, err
+	}
+	if err := h.SendToUser(userID, models.WebSocketMessage{Type: "notification", Payload: n}); err != nil {
+		h.logger.Printf("Failed to send notification %d: %v", n.ID, err)
+	}
+	return &n, nil
+}
+
+// NotifyOffline stores n for every user with no connection to this
+// instance, for announcements whose live copy only reaches connected
+// clients. It returns how many users it was stored for.
+func (h *Hub) NotifyOffline(n models.Notification) (int, error) {
+	return h.db.CreateNotificationForAll(h.ctx, n, h.connectedUserIDs())
+}
+
+// notifyMentions sends a "mention" notification to each participant a user
+// message @-mentions, except the sender and those who muted the
+// conversation including mentions
+func (h *Hub) notifyMentions(message *models.Message, participants []int64) {
+	if message.IsSystem() || message.DeletedAt != nil || !strings.Contains(message.Content, "@") {
+		return
+	}
+
+	mutes, err := h.db.GetActiveMutes(h.ctx, message.ConversationID, time.Now())
+	if err != nil {
+		h.logger.Printf("Failed to load mutes for conversation %d: %v", message.ConversationID, err)
+	}
+	sender, err := h.db.Username(h.ctx, message.SenderID)
+	if err != nil {
+		h.logger.Printf("Failed to look up sender %d: %v", message.SenderID, err)
+		return
+	}
+
+	now := time.Now()
+	for _, userID := range participants {
+		if userID == message.SenderID || !h.mentions(message, userID) {
+			continue
+		}
+		if mute, ok := mutes[userID]; ok && mute.Silences(now, true) {
+			continue
+		}
+		_, err := h.NotifyUser(userID, models.Notification{
+			Category: models.NotificationMention,
+			Title:    fmt.Sprintf("%s mentioned you", sender),
+			Body:     notificationPreview(message.Content),
+			Data: map[string]interface{}{
+				"conversation_id": message.ConversationID,
+				"message_id":      message.ID,
+			},
+		})
+		if err != nil {
+			h.logger.Printf("Failed to notify user %d of mention in message %d: %v", userID, message.ID, err)
+			h.errs.Swallow(errsink.Store, "hub.notify_mention", err)
+		}
+	}
+}
+
+// notificationPreview shortens content the way conversation list previews are
+func notificationPreview(content string) string {
+	if runes := []rune(content); len(runes) > models.MaxPreviewLength {
+		return string(runes[:models.MaxPreviewLength]) + "…"
+	}
+	return content
+}
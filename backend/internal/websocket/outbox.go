@@ -0,0 +1,159 @@
+package websocket
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"messager/internal/db"
+	"messager/internal/errsink"
+)
+
+const (
+	// outboxInterval is how often the dispatcher looks for messages whose
+	// fan-out was never recorded; the first look also comes this long
+	// after startup, giving clients time to reconnect
+	outboxInterval = 5 * time.Second
+	// outboxGrace is how old such a message must be before the dispatcher
+	// takes it over from the request or read pump that saved it
+	outboxGrace = 10 * time.Second
+	// outboxBatch bounds how many messages one pass redelivers
+	outboxBatch = 100
+	// outboxMaxBackoff caps the wait between passes while the database
+	// keeps failing
+	outboxMaxBackoff = 5 * time.Minute
+	// outboxRetention is how long rows stay in the outbox once sent
+	outboxRetention = 24 * time.Hour
+	// outboxMarkChunk bounds the IDs marked sent per statement
+	outboxMarkChunk = 500
+)
+
+// outboxState collects the IDs of messages fanned out since the last
+// flush, so marking them sent costs one write per pass rather than one per
+// message. IDs lost in a crash are simply delivered again.
+type outboxState struct {
+	mu        sync.Mutex
+	delivered []int64
+}
+
+func (o *outboxState) record(messageID int64) {
+	o.mu.Lock()
+	o.delivered = append(o.delivered, messageID)
+	o.mu.Unlock()
+}
+
+func (o *outboxState) take() []int64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	ids := o.delivered
+	o.delivered = nil
+	return ids
+}
+
+// dispatchOutbox redelivers saved messages whose fan-out was never
+// recorded, because the server crashed or delivery failed after the save,
+// until Shutdown. Fan-out is at least once: clients drop a "message" event
+// whose ID they already have.
+func (h *Hub) dispatchOutbox() {
+	delay := outboxInterval
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-time.After(delay):
+		}
+
+		n, err := h.drainOutbox(time.Now())
+		switch {
+		case err != nil:
+			delay *= 2
+			if delay > outboxMaxBackoff {
+				delay = outboxMaxBackoff
+			}
+			h.logger.Printf("Outbox pass failed, next in %s: %v", delay, err)
+			h.errs.Swallow(errsink.Store, "hub.outbox", err)
+		case n == outboxBatch:
+			// More may be waiting
+			delay = 0
+		default:
+			delay = outboxInterval
+		}
+	}
+}
+
+// drainOutbox records what was delivered since the last pass, redelivers
+// one batch of what wasn't, and returns how many it picked up
+func (h *Hub) drainOutbox(now time.Time) (int, error) {
+	if err := h.flushOutbox(); err != nil {
+		return 0, err
+	}
+	messageIDs, err := h.db.PendingOutbox(h.ctx, now.Add(-outboxGrace), outboxBatch)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, id := range messageIDs {
+		// Left for the next start rather than sent into a closing hub
+		if h.shuttingDown.Load() {
+			break
+		}
+		h.redeliver(id)
+	}
+	if err := h.flushOutbox(); err != nil {
+		return 0, err
+	}
+	if _, err := h.db.PruneOutbox(h.ctx, now.Add(-outboxRetention), outboxBatch*10); err != nil {
+		return 0, err
+	}
+	return len(messageIDs), nil
+}
+
+// redeliver fans out a saved message again. Ones since deleted only need
+// recording.
+func (h *Hub) redeliver(messageID int64) {
+	message, err := h.db.GetMessageByID(h.ctx, messageID)
+	if errors.Is(err, db.ErrNotFound) {
+		h.outbox.record(messageID)
+		return
+	}
+	if err != nil {
+		h.logger.Printf("Failed to load outbox message %d: %v", messageID, err)
+		return
+	}
+	if message.DeletedAt != nil {
+		h.outbox.record(messageID)
+		return
+	}
+
+	participants, err := h.participantIDs(h.ctx, message.ConversationID)
+	if err != nil {
+		h.logger.Printf("Failed to get participants for outbox message %d: %v", messageID, err)
+		return
+	}
+	if err := h.DeliverMessage(message, participants); err != nil {
+		h.logger.Printf("Failed to redeliver message %d: %v", messageID, err)
+		return
+	}
+	h.counters.outboxRedelivered.Add(1)
+	h.logger.Printf("Redelivered message %d from the outbox", messageID)
+}
+
+// flushOutbox marks everything delivered since the last flush as sent.
+// On failure the IDs are kept for the next try.
+func (h *Hub) flushOutbox() error {
+	ids := h.outbox.take()
+	for len(ids) > 0 {
+		chunk := ids
+		if len(chunk) > outboxMarkChunk {
+			chunk = chunk[:outboxMarkChunk]
+		}
+		if err := h.db.MarkOutboxSent(h.ctx, chunk); err != nil {
+			for _, id := range ids {
+				h.outbox.record(id)
+			}
+			return err
+		}
+		ids = ids[len(chunk):]
+	}
+	return nil
+}
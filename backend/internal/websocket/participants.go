@@ -0,0 +1,128 @@
+package websocket
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// The hub remembers each conversation's participant IDs for up to
+// participantCacheTTL, so fan-out of messages, typing, state and receipts
+// doesn't cost a query per event. Membership changes drop the entry here
+// and, over the bus, on the other instances; the TTL bounds anything that
+// slips past that. At most participantCacheSize conversations are kept.
+const (
+	participantCacheSize = 4096
+	participantCacheTTL  = time.Minute
+)
+
+// participantCache maps conversation IDs to their participant IDs. The
+// slices it hands out are shared and must not be modified.
+type participantCache struct {
+	mu      sync.Mutex
+	entries map[int64]participantEntry
+}
+
+type participantEntry struct {
+	ids      []int64
+	loadedAt time.Time
+}
+
+func newParticipantCache() *participantCache {
+	return &participantCache{entries: make(map[int64]participantEntry)}
+}
+
+// get returns the participants of conversationID if they were loaded
+// within the TTL
+func (p *participantCache) get(conversationID int64, now time.Time) ([]int64, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	entry, ok := p.entries[conversationID]
+	if !ok || now.Sub(entry.loadedAt) > participantCacheTTL {
+		return nil, false
+	}
+	return entry.ids, true
+}
+
+// put remembers ids for conversationID. When full, expired entries are
+// dropped first and then, if that isn't enough, an arbitrary one.
+func (p *participantCache) put(conversationID int64, ids []int64, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.entries[conversationID]; !ok && len(p.entries) >= participantCacheSize {
+		p.pruneLocked(now)
+		for id := range p.entries {
+			if len(p.entries) < participantCacheSize {
+				break
+			}
+			delete(p.entries, id)
+		}
+	}
+	p.entries[conversationID] = participantEntry{ids: ids, loadedAt: now}
+}
+
+// forget drops conversationID
+func (p *participantCache) forget(conversationID int64) {
+	p.mu.Lock()
+	delete(p.entries, conversationID)
+	p.mu.Unlock()
+}
+
+// prune drops expired entries
+func (p *participantCache) prune(now time.Time) {
+	p.mu.Lock()
+	p.pruneLocked(now)
+	p.mu.Unlock()
+}
+
+func (p *participantCache) pruneLocked(now time.Time) {
+	for id, entry := range p.entries {
+		if now.Sub(entry.loadedAt) > participantCacheTTL {
+			delete(p.entries, id)
+		}
+	}
+}
+
+// participantIDs returns the participants of conversationID from the cache,
+// loading them on a miss. The slice is shared and must not be modified.
+// A conversation with no participants isn't cached, since it's either
+// gone or about to be filled.
+func (h *Hub) participantIDs(ctx context.Context, conversationID int64) ([]int64, error) {
+	now := time.Now()
+	if ids, ok := h.participants.get(conversationID, now); ok {
+		return ids, nil
+	}
+	ids, err := h.db.GetConversationParticipantIDs(ctx, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) > 0 {
+		h.participants.put(conversationID, ids, now)
+	}
+	return ids, nil
+}
+
+// PrimeParticipants loads the participants of conversationIDs into the
+// cache in one query, e.g. for recently active conversations at startup
+func (h *Hub) PrimeParticipants(ctx context.Context, conversationIDs []int64) (int, error) {
+	members, err := h.db.GetParticipantsForConversations(ctx, conversationIDs)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	for conversationID, users := range members {
+		ids := make([]int64, len(users))
+		for i, user := range users {
+			ids[i] = user.ID
+		}
+		h.participants.put(conversationID, ids, now)
+	}
+	return len(members), nil
+}
+
+// ForgetParticipants drops the cached participants of conversationID, here
+// and on the other instances, after members join or leave or it's deleted
+func (h *Hub) ForgetParticipants(conversationID int64) {
+	h.participants.forget(conversationID)
+	h.publishForget(conversationID)
+}
@@ -0,0 +1,72 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestParticipantCache(t *testing.T) {
+	p := newParticipantCache()
+	now := time.Now()
+
+	p.put(1, []int64{10, 11}, now)
+	if ids, ok := p.get(1, now.Add(participantCacheTTL)); !ok || len(ids) != 2 {
+		t.Errorf("within the TTL: %v, %t", ids, ok)
+	}
+	if _, ok := p.get(1, now.Add(participantCacheTTL+time.Second)); ok {
+		t.Error("an expired entry was returned")
+	}
+	p.forget(1)
+	if _, ok := p.get(1, now); ok {
+		t.Error("a forgotten entry was returned")
+	}
+
+	// A full cache makes room, expired entries first
+	p.put(1, []int64{10}, now.Add(-2*participantCacheTTL))
+	for id := int64(2); id <= participantCacheSize; id++ {
+		p.put(id, []int64{10}, now)
+	}
+	p.put(participantCacheSize+1, []int64{10}, now)
+	if len(p.entries) != participantCacheSize {
+		t.Errorf("%d entries, want the limit of %d", len(p.entries), participantCacheSize)
+	}
+	if _, ok := p.entries[1]; ok {
+		t.Error("the expired entry survived while a live one could have been dropped")
+	}
+	if _, ok := p.get(participantCacheSize+1, now); !ok {
+		t.Error("the newest entry wasn't kept")
+	}
+}
+
+func TestHubParticipantIDs(t *testing.T) {
+	h, d, f := newTestHub(t, nil)
+	ctx := context.Background()
+
+	ids, err := h.participantIDs(ctx, f.Direct.ID)
+	if err != nil || len(ids) != 2 {
+		t.Fatalf("participants %v, %v", ids, err)
+	}
+
+	// Later reads come from the cache, until the entry is forgotten
+	if _, err := d.AddConversationParticipants(ctx, f.Direct.ID, []int64{f.Carol.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if ids, _ := h.participantIDs(ctx, f.Direct.ID); len(ids) != 2 {
+		t.Errorf("%d participants before forgetting, want the cached 2", len(ids))
+	}
+	h.ForgetParticipants(f.Direct.ID)
+	if ids, _ := h.participantIDs(ctx, f.Direct.ID); len(ids) != 3 {
+		t.Errorf("%d participants after forgetting, want 3", len(ids))
+	}
+
+	// Priming loads several conversations in one go
+	n, err := h.PrimeParticipants(ctx, []int64{f.Group.ID, 9999})
+	if err != nil || n != 1 {
+		t.Fatalf("primed %d, %v; want the group only", n, err)
+	}
+	if ids, ok := h.participants.get(f.Group.ID, time.Now()); !ok || fmt.Sprint(ids) != fmt.Sprint([]int64{f.Alice.ID, f.Bob.ID, f.Carol.ID}) {
+		t.Errorf("primed group %v, %t", ids, ok)
+	}
+}
@@ -0,0 +1,190 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"messager/internal/db"
+	"messager/internal/errsink"
+	"messager/internal/models"
+)
+
+// Defaults used when WS_PERSIST_WORKERS or WS_PERSIST_QUEUE isn't positive
+const (
+	defaultPersistWorkers = 8
+	defaultPersistQueue   = 64
+)
+
+// persistJob is a message ReadPump handed off to be saved and fanned out.
+// clientID is the client_id of the frame it came in, for any error sent
+// back, since the client's own frameClientID has moved on by then.
+type persistJob struct {
+	client   *Client
+	clientID string
+	msg      models.IncomingMessage
+}
+
+// persistPool saves websocket messages off the connection goroutines. Jobs
+// are sharded by conversation onto one worker each, so a conversation's
+// messages are still saved and delivered in the order they arrived.
+type persistPool struct {
+	hub    *Hub
+	shards []chan persistJob
+
+	// mu guards closed, so nothing is queued to a shard after drain has
+	// closed it; workers is what drain waits on
+	mu       sync.RWMutex
+	closed   bool
+	workers  sync.WaitGroup
+	rejected atomic.Int64
+}
+
+func newPersistPool(h *Hub, workers, queue int) *persistPool {
+	p := &persistPool{hub: h, shards: make([]chan persistJob, workers)}
+	for i := range p.shards {
+		p.shards[i] = make(chan persistJob, queue)
+		p.workers.Add(1)
+		go p.work(p.shards[i])
+	}
+	return p
+}
+
+// submit queues job on its conversation's shard. It returns false without
+// waiting when the shard is full or the pool has been drained.
+func (p *persistPool) submit(job persistJob) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return false
+	}
+	select {
+	case p.shards[job.msg.ConversationID%int64(len(p.shards))] <- job:
+		return true
+	default:
+		p.rejected.Add(1)
+		return false
+	}
+}
+
+// depth is the number of jobs waiting across every shard
+func (p *persistPool) depth() int {
+	n := 0
+	for _, shard := range p.shards {
+		n += len(shard)
+	}
+	return n
+}
+
+func (p *persistPool) work(jobs <-chan persistJob) {
+	defer p.workers.Done()
+	for job := range jobs {
+		job.persist()
+	}
+}
+
+// drain stops taking jobs and waits for the queued ones to finish, or for
+// ctx to be done
+func (p *persistPool) drain(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		for _, shard := range p.shards {
+			close(shard)
+		}
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// handleMessage hands a message sent over the socket to the persist pool,
+// telling the client if the pool has no room for it
+func (c *Client) handleMessage(msg models.IncomingMessage) {
+	if allowed, retryAfter := c.hub.AllowSend(c.userID, c.isBot); !allowed {
+		c.sendError("rate_limited", "Too many messages, slow down", map[string]interface{}{
+			"retry_after": retryAfter.Seconds(),
+		})
+		return
+	}
+
+	if !c.hub.persist.submit(persistJob{client: c, clientID: c.frameClientID, msg: msg}) {
+		fields := map[string]interface{}{"conversation_id": msg.ConversationID}
+		if d := c.hub.errs.Swallow(errsink.Dropped, "ws.persist_queue", errors.New("persist queue full")); d != nil {
+			for k, v := range d.Fields() {
+				fields[k] = v
+			}
+		}
+		c.sendError("server_busy", "Server is busy, try again shortly", fields)
+	}
+}
+
+// persist saves the job's message and delivers it
+func (j persistJob) persist() {
+	c, msg := j.client, j.msg
+
+	isParticipant, err := c.isMember(msg.ConversationID)
+	if err != nil {
+		log.Printf("Failed to check membership: %v", err)
+		c.failFor(j.clientID, errsink.Store, "ws.membership", err, "save_failed", "Failed to save message")
+		return
+	}
+	if !isParticipant {
+		c.sendErrorFor(j.clientID, "forbidden", "Not a participant in this conversation", map[string]interface{}{
+			"conversation_id": msg.ConversationID,
+		})
+		return
+	}
+
+	savedMessage, duplicate, err := c.hub.CreateMessage(msg.ConversationID, c.userID, c.username, msg.Content)
+	if err != nil {
+		log.Printf("Failed to save message: %v", err)
+		switch {
+		case errors.Is(err, db.ErrReadOnly):
+			c.sendErrorFor(j.clientID, "read_only", "Server is in read-only mode, try again later", nil)
+		case errors.Is(err, db.ErrConversationGone):
+			c.sendErrorFor(j.clientID, "conversation_not_found", "The conversation has been deleted", map[string]interface{}{
+				"conversation_id": msg.ConversationID,
+			})
+		default:
+			c.failFor(j.clientID, errsink.Store, "ws.save_message", err, "save_failed", "Failed to save message")
+		}
+		return
+	}
+
+	// A resend of something already delivered needs no second fan-out, but
+	// the sender gets the original back in case the first copy never
+	// reached it
+	if duplicate {
+		c.queueEvent("ws.duplicate_message", models.WebSocketMessage{Type: "message", Payload: savedMessage})
+		return
+	}
+
+	// Send to all participants in the conversation
+	participants, err := c.hub.participantIDs(c.hub.ctx, msg.ConversationID)
+	if err != nil {
+		log.Printf("Failed to get conversation participants: %v", err)
+		c.failFor(j.clientID, errsink.Store, "ws.participants", err, "delivery_failed", "Message saved but not delivered")
+		return
+	}
+
+	if err := c.hub.DeliverMessage(savedMessage, participants); err != nil {
+		log.Printf("Failed to broadcast message: %v", err)
+		c.failFor(j.clientID, errsink.FanOut, "ws.deliver_message", err, "delivery_failed", "Message saved but not delivered")
+	}
+	if !c.isBot {
+		c.hub.RouteCommand(savedMessage, c.username, participants)
+	}
+}
@@ -0,0 +1,193 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// maxPollsPerUser caps the long polls a user can have parked at once
+	maxPollsPerUser = 2
+	// pollerIdle is how long after its last poll ends a user is still
+	// treated as polling, so events sent between two polls are kept for
+	// the next one instead of being lost
+	pollerIdle = time.Minute
+)
+
+var (
+	// ErrPollUnavailable is returned by Poll when the replay buffer is
+	// disabled, since long polls are answered from it
+	ErrPollUnavailable = errors.New("long polling needs the replay buffer")
+	// ErrPollConnected is returned by Poll while the user has a websocket
+	// open on this instance; events go to the socket, never to both
+	ErrPollConnected = errors.New("user has a websocket connection")
+	// ErrTooManyPolls is returned by Poll when the user already has
+	// maxPollsPerUser polls waiting
+	ErrTooManyPolls = errors.New("too many concurrent polls")
+)
+
+// PollResult is the answer to a long poll. Events are the stamped frames
+// the socket would have got, oldest first; LastEventID is what to pass as
+// since_event_id next time. ResyncRequired means events were missed and
+// the client has to sync from the REST API before polling again.
+type PollResult struct {
+	Events         []json.RawMessage `json:"events"`
+	LastEventID    int64             `json:"last_event_id"`
+	ResyncRequired bool              `json:"resync_required,omitempty"`
+}
+
+// pollRegistry tracks the users being served by long polls. Each user has
+// a wake channel that is closed, and replaced, whenever an event is kept
+// for them, so any number of parked polls can wait on it.
+type pollRegistry struct {
+	mu    sync.Mutex
+	users map[int64]*pollUser
+}
+
+type pollUser struct {
+	active   int
+	lastPoll time.Time
+	wake     chan struct{}
+}
+
+func newPollRegistry() *pollRegistry {
+	return &pollRegistry{users: make(map[int64]*pollUser)}
+}
+
+// join counts a new poll for the user, refusing it past maxPollsPerUser
+func (p *pollRegistry) join(userID int64) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	u, ok := p.users[userID]
+	if !ok {
+		u = &pollUser{wake: make(chan struct{})}
+		p.users[userID] = u
+	}
+	if u.active >= maxPollsPerUser {
+		return false
+	}
+	u.active++
+	return true
+}
+
+// leave ends a poll started with join
+func (p *pollRegistry) leave(userID int64, now time.Time) {
+	p.mu.Lock()
+	if u, ok := p.users[userID]; ok {
+		u.active--
+		u.lastPoll = now
+	}
+	p.mu.Unlock()
+}
+
+// waiter returns the channel that will be closed by the user's next event
+func (p *pollRegistry) waiter(userID int64) <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if u, ok := p.users[userID]; ok {
+		return u.wake
+	}
+	return nil
+}
+
+// wants reports whether events for the user should be kept for polls
+func (p *pollRegistry) wants(userID int64) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.users[userID]
+	return ok
+}
+
+// notify wakes the user's parked polls
+func (p *pollRegistry) notify(userID int64) {
+	p.mu.Lock()
+	if u, ok := p.users[userID]; ok {
+		close(u.wake)
+		u.wake = make(chan struct{})
+	}
+	p.mu.Unlock()
+}
+
+// prune forgets users that haven't polled for pollerIdle and returns them
+func (p *pollRegistry) prune(now time.Time) []int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var gone []int64
+	for userID, u := range p.users {
+		if u.active == 0 && now.Sub(u.lastPoll) > pollerIdle {
+			delete(p.users, userID)
+			gone = append(gone, userID)
+		}
+	}
+	return gone
+}
+
+// Poll waits up to timeout for events for userID after sinceID and returns
+// them. A sinceID of 0 starts from the user's latest event, so the first
+// poll only returns what's sent while it waits. Polls share the per-user
+// event IDs and replay buffer with websockets, so a client can switch
+// between the two and pick up where it left off.
+//
+// The websocket takes precedence: while the user has one open here, Poll
+// refuses with ErrPollConnected, and events are only kept for polls when
+// there's no socket to deliver them to. A socket that connects while a
+// poll is parked gets the events from then on and the poll times out empty.
+func (h *Hub) Poll(ctx context.Context, userID, sinceID int64, timeout time.Duration) (PollResult, error) {
+	if h.replay.size == 0 {
+		return PollResult{}, ErrPollUnavailable
+	}
+	if h.IsConnected(userID) {
+		return PollResult{}, ErrPollConnected
+	}
+	if !h.pollers.join(userID) {
+		return PollResult{}, ErrTooManyPolls
+	}
+	defer func() { h.pollers.leave(userID, time.Now()) }()
+	h.replay.connected(userID)
+
+	cursor := sinceID
+	if cursor == 0 {
+		cursor = h.replay.newest(userID)
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		// Take the channel before looking, so an event kept in between
+		// still wakes us
+		wake := h.pollers.waiter(userID)
+		frames, newest, ok := h.replay.after(userID, cursor)
+		if !ok {
+			return PollResult{Events: []json.RawMessage{}, LastEventID: newest, ResyncRequired: true}, nil
+		}
+		if len(frames) > 0 {
+			events := make([]json.RawMessage, len(frames))
+			for i, frame := range frames {
+				events[i] = frame
+			}
+			return PollResult{Events: events, LastEventID: newest}, nil
+		}
+
+		select {
+		case <-wake:
+		case <-timer.C:
+			return PollResult{Events: []json.RawMessage{}, LastEventID: cursor}, nil
+		case <-ctx.Done():
+			return PollResult{}, ctx.Err()
+		case <-h.released:
+			return PollResult{Events: []json.RawMessage{}, LastEventID: cursor}, nil
+		}
+	}
+}
+
+// prunePollers forgets users that stopped polling and starts the clock on
+// freeing their events, unless they've since opened a websocket
+func (h *Hub) prunePollers(now time.Time) {
+	for _, userID := range h.pollers.prune(now) {
+		if !h.IsConnected(userID) {
+			h.replay.disconnected(userID, now)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package websocket
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"messager/internal/models"
+)
+
+// presenceDebounce is how long a user's last connection must stay closed
+// before they're reported offline, so a quick reconnect doesn't flap
+const presenceDebounce = 5 * time.Second
+
+// presenceContactsTTL is how long the list of users who see someone's
+// presence is reused before asking the database again. A new conversation
+// partner may miss presence changes for up to this long.
+const presenceContactsTTL = time.Minute
+
+type cachedContacts struct {
+	ids []int64
+	at  time.Time
+}
+
+// presenceTracker decides when a user goes online or offline. A user is
+// online from their first connection until presenceDebounce after their
+// last one closes.
+type presenceTracker struct {
+	mu       sync.Mutex
+	online   map[int64]bool
+	pending  map[int64]*time.Timer
+	contacts map[int64]cachedContacts
+}
+
+func newPresenceTracker() *presenceTracker {
+	return &presenceTracker{
+		online:   make(map[int64]bool),
+		pending:  make(map[int64]*time.Timer),
+		contacts: make(map[int64]cachedContacts),
+	}
+}
+
+// connected records a user's first open connection and reports whether
+// that makes them newly online. A reconnect within the debounce window
+// cancels the pending offline instead.
+func (p *presenceTracker) connected(userID int64) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t, ok := p.pending[userID]; ok {
+		t.Stop()
+		delete(p.pending, userID)
+		return false
+	}
+	if p.online[userID] {
+		return false
+	}
+	p.online[userID] = true
+	return true
+}
+
+// disconnected records that a user's last connection closed and calls
+// offline after presenceDebounce unless they reconnect first
+func (p *presenceTracker) disconnected(userID int64, offline func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.pending[userID]; ok || !p.online[userID] {
+		return
+	}
+	var t *time.Timer
+	t = time.AfterFunc(presenceDebounce, func() {
+		p.mu.Lock()
+		if p.pending[userID] != t {
+			// Reconnected while this was waiting for the lock
+			p.mu.Unlock()
+			return
+		}
+		delete(p.pending, userID)
+		delete(p.online, userID)
+		p.mu.Unlock()
+		offline()
+	})
+	p.pending[userID] = t
+}
+
+func (p *presenceTracker) isOnline(userID int64) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.online[userID]
+}
+
+func (p *presenceTracker) snapshot() []int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ids := make([]int64, 0, len(p.online))
+	for id := range p.online {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// cachedContactIDs returns a user's contacts if they were looked up
+// recently enough
+func (p *presenceTracker) cachedContactIDs(userID int64, now time.Time) ([]int64, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	entry, ok := p.contacts[userID]
+	if !ok || now.Sub(entry.at) >= presenceContactsTTL {
+		return nil, false
+	}
+	return entry.ids, true
+}
+
+func (p *presenceTracker) storeContactIDs(userID int64, ids []int64, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.contacts[userID] = cachedContacts{ids: ids, at: now}
+}
+
+// prune drops expired contact lists and returns how many it removed
+func (p *presenceTracker) prune(now time.Time) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for userID, entry := range p.contacts {
+		if now.Sub(entry.at) >= presenceContactsTTL {
+			delete(p.contacts, userID)
+			n++
+		}
+	}
+	return n
+}
+
+// OnlineUserIDs returns the users who are currently online, in ID order
+func (h *Hub) OnlineUserIDs() []int64 {
+	return h.presence.snapshot()
+}
+
+// IsOnline reports whether a user is currently online
+func (h *Hub) IsOnline(userID int64) bool {
+	return h.presence.isOnline(userID)
+}
+
+// contactIDs returns the users who share a conversation with userID
+func (h *Hub) contactIDs(userID int64) ([]int64, error) {
+	now := time.Now()
+	if ids, ok := h.presence.cachedContactIDs(userID, now); ok {
+		return ids, nil
+	}
+	ids, err := h.db.GetContactIDs(h.ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	h.presence.storeContactIDs(userID, ids, now)
+	return ids, nil
+}
+
+// announcePresence sends a "presence" event about userID to everyone who
+// shares a conversation with them
+func (h *Hub) announcePresence(userID int64, status string) {
+	contacts, err := h.contactIDs(userID)
+	if err != nil {
+		h.logger.Printf("Failed to get contacts for presence: %v", err)
+		return
+	}
+	if len(contacts) == 0 {
+		return
+	}
+	h.sendToParticipants(0, models.WebSocketMessage{
+		Type: "presence",
+		Payload: map[string]interface{}{
+			"user_id":   userID,
+			"status":    status,
+			"timestamp": time.Now().UTC(),
+		},
+	}, contacts)
+}
+
+// userConnected is called when a user's first connection registers
+func (h *Hub) userConnected(userID int64) {
+	h.replay.connected(userID)
+	if h.presence.connected(userID) {
+		go h.announcePresence(userID, "online")
+	}
+}
+
+// userDisconnected is called when a user's last connection goes away
+func (h *Hub) userDisconnected(userID int64) {
+	if !h.streams.has(userID) {
+		h.replay.disconnected(userID, time.Now())
+	}
+	go h.RecordLastSeen([]int64{userID}, lastSeenMinGap)
+	h.presence.disconnected(userID, func() {
+		h.announcePresence(userID, "offline")
+	})
+}
@@ -0,0 +1,161 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"messager/internal/db"
+	"messager/internal/errsink"
+	"messager/internal/logsafe"
+	"messager/internal/models"
+)
+
+// handleActive records which conversation the client has on screen. While
+// one is active, every message in it that reaches this connection also
+// advances the user's read marker. A missing or zero conversation_id clears
+// it. The choice is per connection and is acknowledged with an "active"
+// event.
+func (c *Client) handleActive(event models.ActiveEvent) {
+	conversationID := event.ConversationID
+
+	if conversationID > 0 {
+		isParticipant, err := c.hub.db.IsConversationParticipant(c.ctx, conversationID, c.userID)
+		if err != nil {
+			c.hub.logger.Printf("Failed to check membership: %v", err)
+			return
+		}
+		if !isParticipant {
+			c.sendError("forbidden", "Not a participant in this conversation", map[string]interface{}{
+				"conversation_id": conversationID,
+			})
+			return
+		}
+	} else {
+		conversationID = 0
+	}
+	c.active.Store(conversationID)
+
+	data, err := json.Marshal(models.WebSocketMessage{
+		Type:    "active",
+		Payload: map[string]interface{}{"conversation_id": conversationID},
+	})
+	if err != nil {
+		c.hub.logger.Printf("Failed to marshal active event: %v", err)
+		c.hub.errs.Swallow(errsink.Marshal, "ws.active", err)
+		return
+	}
+	if !c.enqueue(data) {
+		c.hub.logger.Printf("Dropped active event for client: %s", logsafe.String(c.username))
+		c.hub.errs.Swallow(errsink.Dropped, "ws.active", fmt.Errorf("queue full for user %d", c.userID))
+	}
+}
+
+// handleMarkRead advances the user's read marker over the socket, the same
+// as POST /api/conversations/read. The resulting "read" event goes to the
+// conversation's participants, this connection included; a marker that
+// wouldn't move forward is left alone without an event.
+func (c *Client) handleMarkRead(req models.MarkReadRequest) {
+	if errs := req.Validate(); len(errs) > 0 {
+		c.sendError("invalid_mark_read", "conversation_id and message_id are required", nil)
+		return
+	}
+
+	isParticipant, err := c.hub.db.IsConversationParticipant(c.ctx, req.ConversationID, c.userID)
+	if err != nil {
+		c.fail(errsink.Store, "ws.mark_read", err, "mark_read_failed", "Failed to mark read")
+		return
+	}
+	if !isParticipant {
+		c.sendError("forbidden", "Not a participant in this conversation", map[string]interface{}{
+			"conversation_id": req.ConversationID,
+		})
+		return
+	}
+
+	if _, err := c.hub.MarkRead(req.ConversationID, c.userID, req.MessageID); err != nil {
+		switch {
+		case errors.Is(err, db.ErrNotFound):
+			c.sendError("message_not_found", "The message isn't in this conversation", map[string]interface{}{
+				"conversation_id": req.ConversationID,
+				"message_id":      req.MessageID,
+			})
+		case errors.Is(err, db.ErrReadOnly):
+			c.sendError("read_only", "Server is in read-only mode, try again later", nil)
+		default:
+			c.fail(errsink.Store, "ws.mark_read", err, "mark_read_failed", "Failed to mark read")
+		}
+	}
+}
+
+// advanceViewers marks message read for those recipients with a connection
+// that has its conversation active. A connection whose queue overflowed had
+// the conversation cleared while the message was being queued, so a marker
+// never passes a message that wasn't delivered.
+func (h *Hub) advanceViewers(message *models.Message, recipients []int64) {
+	var viewers []int64
+	h.mu.RLock()
+	for _, userID := range recipients {
+		for client := range h.userMap[userID] {
+			if client.active.Load() == message.ConversationID {
+				viewers = append(viewers, userID)
+				break
+			}
+		}
+	}
+	h.mu.RUnlock()
+	if len(viewers) == 0 {
+		return
+	}
+
+	go func() {
+		for _, userID := range viewers {
+			if _, err := h.MarkRead(message.ConversationID, userID, message.ID); err != nil {
+				h.logger.Printf("Failed to mark message %d read for user %d: %v", message.ID, userID, err)
+				h.errs.Swallow(errsink.Store, "hub.advance_read", err)
+			}
+		}
+	}()
+}
+
+// MarkRead advances a participant's read marker to messageID and, if it
+// moved, sends a "read" event to the conversation. Users who turned read
+// receipts off only have the event sent to themselves. Returns whether the
+// marker moved.
+func (h *Hub) MarkRead(conversationID, userID, messageID int64) (bool, error) {
+	advanced, err := h.db.MarkRead(h.ctx, conversationID, userID, messageID)
+	if err != nil || !advanced {
+		return advanced, err
+	}
+
+	event := models.WebSocketMessage{
+		Type: "read",
+		Payload: map[string]interface{}{
+			"user_id":         userID,
+			"conversation_id": conversationID,
+			"message_id":      messageID,
+		},
+	}
+
+	settings, err := h.db.GetPrivacySettings(h.ctx, userID)
+	if err != nil {
+		h.logger.Printf("Failed to load privacy settings for user %d: %v", userID, err)
+		return true, nil
+	}
+	if !settings.ReadReceipts {
+		if err := h.SendToUser(userID, event); err != nil {
+			h.logger.Printf("Failed to send read event: %v", err)
+		}
+		return true, nil
+	}
+
+	participants, err := h.participantIDs(h.ctx, conversationID)
+	if err != nil {
+		h.logger.Printf("Failed to get conversation participants: %v", err)
+		return true, nil
+	}
+	if err := h.SendToConversation(conversationID, event, participants); err != nil {
+		h.logger.Printf("Failed to send read event: %v", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,172 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+
+	"messager/internal/db"
+)
+
+// lastRead returns a participant's read marker, 0 for none
+func lastRead(t *testing.T, d *db.DB, conversationID, userID int64) int64 {
+	t.Helper()
+	var id int64
+	if err := d.QueryRow(`
+		SELECT COALESCE(last_read_message_id, 0) FROM conversation_participants WHERE conversation_id = ? AND user_id = ?
+	`, conversationID, userID).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func setActive(t *testing.T, conn *testConn, conversationID int64) {
+	t.Helper()
+	send(t, conn, "active", map[string]interface{}{"conversation_id": conversationID})
+	if got := readUntil(t, conn, "active"); got.Payload["conversation_id"] != float64(conversationID) {
+		t.Fatalf("active acknowledged as %v, want %d", got.Payload, conversationID)
+	}
+}
+
+// sendAndRead sends content and returns the id it was saved with, once the
+// sender has seen it
+func sendAndRead(t *testing.T, conn *testConn, conversationID int64, content string) int64 {
+	t.Helper()
+	sendMessage(t, conn, conversationID, content)
+	id, _ := readContent(t, conn, content).Payload["id"].(float64)
+	return int64(id)
+}
+
+func TestActiveConversationAdvancesReadMarker(t *testing.T) {
+	h, d, f := newTestHub(t, nil)
+	startHub(t, h)
+	alice, _ := dial(t, h, f.Alice)
+	bob, _ := dial(t, h, f.Bob)
+
+	setActive(t, bob, f.Group.ID)
+	id := sendAndRead(t, alice, f.Group.ID, "seen")
+	read := readUntil(t, alice, "read").Payload
+	if read["user_id"] != float64(f.Bob.ID) || read["message_id"] != float64(id) {
+		t.Fatalf("read event = %v, want bob at %d", read, id)
+	}
+	if got := lastRead(t, d, f.Group.ID, f.Bob.ID); got != id {
+		t.Errorf("bob's marker = %d, want %d", got, id)
+	}
+
+	// Another conversation isn't on screen
+	sendAndRead(t, alice, f.Direct.ID, "elsewhere")
+	expectNoFrameOfType(t, alice, "read", 100*time.Millisecond)
+	if got := lastRead(t, d, f.Direct.ID, f.Bob.ID); got != 0 {
+		t.Errorf("bob's direct marker moved to %d", got)
+	}
+
+	// Clearing stops it
+	setActive(t, bob, 0)
+	sendAndRead(t, alice, f.Group.ID, "unseen")
+	expectNoFrameOfType(t, alice, "read", 100*time.Millisecond)
+	if got := lastRead(t, d, f.Group.ID, f.Bob.ID); got != id {
+		t.Errorf("bob's marker moved to %d after clearing", got)
+	}
+
+	// Declaring a conversation you aren't in is refused
+	send(t, alice, "active", map[string]interface{}{"conversation_id": 9999})
+	if got := readUntil(t, alice, "error"); got.Payload["code"] != "forbidden" {
+		t.Errorf("got %v, want forbidden", got.Payload)
+	}
+}
+
+func TestActiveConversationPerDevice(t *testing.T) {
+	h, d, f := newTestHub(t, nil)
+	startHub(t, h)
+	alice, _ := dial(t, h, f.Alice)
+	phone, _ := dial(t, h, f.Bob)
+	laptop, _ := dial(t, h, f.Bob)
+
+	setActive(t, laptop, f.Direct.ID)
+
+	// Both of bob's devices get the message; neither has the group open
+	sendAndRead(t, alice, f.Group.ID, "to both devices")
+	readContent(t, phone, "to both devices")
+	readContent(t, laptop, "to both devices")
+	expectNoFrameOfType(t, alice, "read", 100*time.Millisecond)
+	if got := lastRead(t, d, f.Group.ID, f.Bob.ID); got != 0 {
+		t.Errorf("group marker moved to %d with no device viewing it", got)
+	}
+
+	// The laptop has the direct conversation open, so the marker moves
+	direct := sendAndRead(t, alice, f.Direct.ID, "on the laptop's screen")
+	readContent(t, phone, "on the laptop's screen")
+	readContent(t, laptop, "on the laptop's screen")
+	readUntil(t, alice, "read")
+	if got := lastRead(t, d, f.Direct.ID, f.Bob.ID); got != direct {
+		t.Errorf("direct marker = %d, want %d", got, direct)
+	}
+
+	// With the laptop gone, the phone still receives
+	laptop.Close()
+	waitFor(t, "the laptop to unregister", func() bool { return h.ClientCount() == 2 })
+	sendAndRead(t, alice, f.Group.ID, "to the phone")
+	readContent(t, phone, "to the phone")
+}
+
+// Each of a user's connections keeps its own active conversation, and a
+// message advances the marker if any connection that got it is viewing
+func TestActiveConversationOnTwoLiveConnections(t *testing.T) {
+	h, d, f := newTestHub(t, nil)
+	startHub(t, h)
+	alice, _ := dial(t, h, f.Alice)
+	phone, _ := dial(t, h, f.Bob)
+	laptop, _ := dial(t, h, f.Bob)
+
+	setActive(t, phone, f.Group.ID)
+	setActive(t, laptop, f.Direct.ID)
+
+	group := sendAndRead(t, alice, f.Group.ID, "on the phone's screen")
+	readUntil(t, alice, "read")
+	if got := lastRead(t, d, f.Group.ID, f.Bob.ID); got != group {
+		t.Errorf("group marker = %d, want %d", got, group)
+	}
+	direct := sendAndRead(t, alice, f.Direct.ID, "on the laptop's screen")
+	readUntil(t, alice, "read")
+	if got := lastRead(t, d, f.Direct.ID, f.Bob.ID); got != direct {
+		t.Errorf("direct marker = %d, want %d", got, direct)
+	}
+
+	// The phone moving away leaves the laptop's conversation alone
+	setActive(t, phone, 0)
+	sendAndRead(t, alice, f.Group.ID, "nobody has the group open")
+	expectNoFrameOfType(t, alice, "read", 100*time.Millisecond)
+	if got := lastRead(t, d, f.Group.ID, f.Bob.ID); got != group {
+		t.Errorf("group marker moved to %d with no device viewing it", got)
+	}
+	direct = sendAndRead(t, alice, f.Direct.ID, "still on the laptop")
+	readUntil(t, alice, "read")
+	if got := lastRead(t, d, f.Direct.ID, f.Bob.ID); got != direct {
+		t.Errorf("direct marker = %d, want %d", got, direct)
+	}
+}
+
+func TestReadMarkerNeverMovesBack(t *testing.T) {
+	h, d, f := newTestHub(t, nil)
+	startHub(t, h)
+	bob, _ := dial(t, h, f.Bob)
+	older, newer := f.Messages[2].ID, f.Messages[3].ID
+
+	send(t, bob, "mark_read", map[string]interface{}{"conversation_id": f.Group.ID, "message_id": newer})
+	if got := readUntil(t, bob, "read"); got.Payload["message_id"] != float64(newer) {
+		t.Fatalf("read event = %v", got.Payload)
+	}
+	send(t, bob, "mark_read", map[string]interface{}{"conversation_id": f.Group.ID, "message_id": older})
+	expectNoFrameOfType(t, bob, "read", 100*time.Millisecond)
+	if got := lastRead(t, d, f.Group.ID, f.Bob.ID); got != newer {
+		t.Errorf("marker = %d after marking an older message, want %d", got, newer)
+	}
+	if advanced, err := h.MarkRead(f.Group.ID, f.Bob.ID, older); err != nil || advanced {
+		t.Errorf("MarkRead(older) = %t, %v, want no move", advanced, err)
+	}
+
+	// A message from another conversation isn't a marker here
+	send(t, bob, "mark_read", map[string]interface{}{"conversation_id": f.Group.ID, "message_id": f.Messages[0].ID})
+	if got := readUntil(t, bob, "error"); got.Payload["code"] != "message_not_found" {
+		t.Errorf("got %v, want message_not_found", got.Payload)
+	}
+}
@@ -0,0 +1,173 @@
+package websocket
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"messager/internal/models"
+)
+
+// replayBuffer numbers the events sent to each user and keeps the last few
+// in memory, so a client that reconnects after a blip can "resume" from the
+// last event_id it got instead of syncing from the database. Ephemeral
+// events and replies to a single connection's frames aren't numbered.
+type replayBuffer struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	users map[int64]*userEvents
+}
+
+// userEvents holds a user's most recent events, the one with ID n at
+// frames[n%len(frames)]. IDs start from the clock rather than 1, so they
+// keep increasing after the buffer is freed or the server restarts.
+type userEvents struct {
+	first, last  int64
+	frames       [][]byte
+	offlineSince time.Time // zero while connected
+}
+
+func newReplayBuffer(size int, ttl time.Duration) *replayBuffer {
+	return &replayBuffer{size: size, ttl: ttl, users: make(map[int64]*userEvents)}
+}
+
+// stamp gives data the user's next event_id, remembers it, and returns the
+// stamped frame. With the buffer disabled data is returned as is.
+func (r *replayBuffer) stamp(userID int64, data []byte) []byte {
+	if r.size == 0 || len(data) < 2 || data[0] != '{' {
+		return data
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[userID]
+	if !ok {
+		start := time.Now().UnixMicro()
+		u = &userEvents{first: start, last: start - 1, frames: make([][]byte, r.size)}
+		r.users[userID] = u
+	}
+	u.last++
+	frame := make([]byte, 0, len(data)+32)
+	frame = append(frame, `{"event_id":`...)
+	frame = strconv.AppendInt(frame, u.last, 10)
+	frame = append(frame, ',')
+	frame = append(frame, data[1:]...)
+	u.frames[u.last%int64(r.size)] = frame
+	return frame
+}
+
+// since returns the user's events after lastID, oldest first, and the ID
+// of the newest. ok is false when they can't all be replayed: the buffer
+// has nothing for the user, lastID is from before what it holds, or it's
+// newer than anything sent.
+func (r *replayBuffer) since(userID, lastID int64) (frames [][]byte, newest int64, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, found := r.users[userID]
+	if !found {
+		return nil, 0, false
+	}
+	oldest := u.first
+	if held := u.last - int64(r.size) + 1; held > oldest {
+		oldest = held
+	}
+	if lastID < oldest-1 || lastID > u.last {
+		return nil, u.last, false
+	}
+	for id := lastID + 1; id <= u.last; id++ {
+		frames = append(frames, u.frames[id%int64(r.size)])
+	}
+	return frames, u.last, true
+}
+
+// after is since for long polls, where lastID 0 asks for every event held
+// and a user with no events yet is simply caught up
+func (r *replayBuffer) after(userID, lastID int64) (frames [][]byte, newest int64, ok bool) {
+	if lastID == 0 {
+		r.mu.Lock()
+		u, found := r.users[userID]
+		if found {
+			lastID = u.first - 1
+		}
+		r.mu.Unlock()
+		if !found {
+			return nil, 0, true
+		}
+	}
+	return r.since(userID, lastID)
+}
+
+// newest returns the ID of the user's latest event, or 0 if there's none
+func (r *replayBuffer) newest(userID int64) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if u, ok := r.users[userID]; ok {
+		return u.last
+	}
+	return 0
+}
+
+// connected keeps a returning user's events from being freed
+func (r *replayBuffer) connected(userID int64) {
+	r.mu.Lock()
+	if u, ok := r.users[userID]; ok {
+		u.offlineSince = time.Time{}
+	}
+	r.mu.Unlock()
+}
+
+// disconnected starts the clock on freeing a user's events
+func (r *replayBuffer) disconnected(userID int64, now time.Time) {
+	r.mu.Lock()
+	if u, ok := r.users[userID]; ok {
+		u.offlineSince = now
+	}
+	r.mu.Unlock()
+}
+
+// prune frees the events of users offline for longer than the TTL
+func (r *replayBuffer) prune(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for userID, u := range r.users {
+		if !u.offlineSince.IsZero() && now.Sub(u.offlineSince) > r.ttl {
+			delete(r.users, userID)
+		}
+	}
+}
+
+// handleResume replays the events the client missed since req.LastEventID
+// and answers with "resumed", or with "resume_failed" when the buffer no
+// longer covers the gap, in which case the client falls back to "sync".
+// Events sent while the replay is queued may arrive twice; clients drop any
+// event_id they've already seen.
+func (c *Client) handleResume(req models.ResumeRequest) {
+	if req.LastEventID <= 0 {
+		c.sendError("invalid_resume", "last_event_id is required", nil)
+		return
+	}
+	frames, newest, ok := c.hub.replay.since(c.userID, req.LastEventID)
+	if !ok {
+		c.queueEvent("ws.resume", models.WebSocketMessage{
+			Type: "resume_failed",
+			Payload: map[string]interface{}{
+				"last_event_id": newest,
+			},
+		})
+		return
+	}
+	for _, frame := range frames {
+		if !c.enqueue(frame) {
+			return
+		}
+	}
+	c.queueEvent("ws.resume", models.WebSocketMessage{
+		Type: "resumed",
+		Payload: map[string]interface{}{
+			"count":         len(frames),
+			"last_event_id": newest,
+		},
+	})
+}
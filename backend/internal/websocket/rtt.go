@@ -0,0 +1,67 @@
+package websocket
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// rttBucketsMS are the upper bounds, in milliseconds, of the heartbeat
+// round-trip histogram; anything slower lands in a final +Inf bucket
+var rttBucketsMS = [...]float64{10, 25, 50, 100, 250, 500, 1000, 2500}
+
+// rttHistogram counts the heartbeat round trips clients report, across
+// every connection, by latency
+type rttHistogram struct {
+	buckets [len(rttBucketsMS) + 1]atomic.Int64
+	count   atomic.Int64
+	sumUS   atomic.Int64 // microseconds
+}
+
+func (r *rttHistogram) observe(rtt time.Duration) {
+	if rtt < 0 {
+		return
+	}
+	ms := float64(rtt) / float64(time.Millisecond)
+	i := 0
+	for i < len(rttBucketsMS) && ms > rttBucketsMS[i] {
+		i++
+	}
+	r.buckets[i].Add(1)
+	r.count.Add(1)
+	r.sumUS.Add(rtt.Microseconds())
+}
+
+// RTTStats is the distribution of heartbeat round trips, laid out like a
+// Prometheus histogram so it can be exported as one
+type RTTStats struct {
+	Count int64   `json:"count"`
+	SumMS float64 `json:"sum_ms"`
+	// Buckets are cumulative: each counts the round trips at or under
+	// its bound, and the last, "+Inf", all of them
+	Buckets []RTTBucket `json:"buckets"`
+}
+
+// RTTBucket is one histogram bucket; LE is its bound in milliseconds
+type RTTBucket struct {
+	LE    string `json:"le"`
+	Count int64  `json:"count"`
+}
+
+func (r *rttHistogram) snapshot() RTTStats {
+	stats := RTTStats{
+		Count:   r.count.Load(),
+		SumMS:   float64(r.sumUS.Load()) / 1000,
+		Buckets: make([]RTTBucket, 0, len(r.buckets)),
+	}
+	var cumulative int64
+	for i := range r.buckets {
+		cumulative += r.buckets[i].Load()
+		le := "+Inf"
+		if i < len(rttBucketsMS) {
+			le = strconv.FormatFloat(rttBucketsMS[i], 'f', -1, 64)
+		}
+		stats.Buckets = append(stats.Buckets, RTTBucket{LE: le, Count: cumulative})
+	}
+	return stats
+}
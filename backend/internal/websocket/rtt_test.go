@@ -0,0 +1,51 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRTTHistogram(t *testing.T) {
+	var r rttHistogram
+	for _, ms := range []float64{5, 10, 10.5, 80, 4000} {
+		r.observe(time.Duration(ms * float64(time.Millisecond)))
+	}
+	r.observe(-time.Millisecond)
+
+	stats := r.snapshot()
+	if stats.Count != 5 || stats.SumMS != 4105.5 {
+		t.Errorf("count %d, sum %vms; want 5 and 4105.5ms, the negative one ignored", stats.Count, stats.SumMS)
+	}
+	want := map[string]int64{"10": 2, "25": 3, "50": 3, "100": 4, "2500": 4, "+Inf": 5}
+	if n := len(stats.Buckets); n != len(rttBucketsMS)+1 || stats.Buckets[n-1].LE != "+Inf" {
+		t.Fatalf("buckets %+v", stats.Buckets)
+	}
+	for _, b := range stats.Buckets {
+		if count, ok := want[b.LE]; ok && b.Count != count {
+			t.Errorf("le %s: %d, want %d", b.LE, b.Count, count)
+		}
+	}
+}
+
+func TestHeartbeatAckFeedsRTTStats(t *testing.T) {
+	h, _, f := newTestHub(t, nil)
+	startHub(t, h)
+	alice, aliceClient := dial(t, h, f.Alice)
+	bob, bobClient := dial(t, h, f.Bob)
+
+	send(t, alice, "heartbeat_ack", map[string]interface{}{"latency_ms": 20})
+	send(t, bob, "heartbeat_ack", map[string]interface{}{"latency_ms": 300})
+	waitFor(t, "both acks to be read", func() bool {
+		return aliceClient.framesReceived.Load() == 1 && bobClient.framesReceived.Load() == 1
+	})
+
+	rtt := h.Stats().HeartbeatRTT
+	if rtt.Count != 2 || rtt.SumMS != 320 {
+		t.Errorf("count %d, sum %vms; want both acks", rtt.Count, rtt.SumMS)
+	}
+	for _, b := range rtt.Buckets {
+		if b.LE == "25" && b.Count != 1 || b.LE == "500" && b.Count != 2 {
+			t.Errorf("le %s: %d", b.LE, b.Count)
+		}
+	}
+}
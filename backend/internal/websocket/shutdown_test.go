@@ -0,0 +1,90 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"messager/internal/models"
+)
+
+func TestShutdownDrainsThenCloses(t *testing.T) {
+	h, _, f := newTestHub(t, nil)
+	startHub(t, h)
+	alice, _ := dial(t, h, f.Alice)
+	bob, _ := dial(t, h, f.Bob)
+	readUntil(t, alice, "system")
+
+	// A frame queued before the shutdown still goes out ahead of the close
+	if err := h.SendToUser(f.Alice.ID, models.WebSocketMessage{Type: "notice"}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), frameWait)
+	defer cancel()
+	if err := h.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	readUntil(t, alice, "notice")
+	for name, conn := range map[string]*testConn{"alice": alice, "bob": bob} {
+		if code := readClose(t, conn); code != CloseServerRestart {
+			t.Errorf("%s closed with %d, want %d", name, code, CloseServerRestart)
+		}
+	}
+	if n := h.ClientCount(); n != 0 {
+		t.Errorf("%d clients still registered", n)
+	}
+
+	// Shutting down again is harmless
+	if err := h.Shutdown(ctx); err != nil {
+		t.Errorf("second Shutdown: %v", err)
+	}
+}
+
+func TestShutdownRefusesNewWork(t *testing.T) {
+	h, _, f := newTestHub(t, nil)
+	startHub(t, h)
+	ctx, cancel := context.WithTimeout(context.Background(), frameWait)
+	defer cancel()
+	if err := h.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.AddClient(NewClient(h, nil, f.Alice.ID, 0, f.Alice.Username, false)) {
+		t.Error("AddClient accepted a connection after shutdown")
+	}
+	// Nothing is reading broadcasts any more, which must not block
+	done := make(chan error, 1)
+	go func() { done <- h.BroadcastMessage(models.WebSocketMessage{Type: "notice"}) }()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrBroadcastDropped) {
+			t.Errorf("BroadcastMessage = %v, want ErrBroadcastDropped", err)
+		}
+	case <-time.After(frameWait):
+		t.Fatal("BroadcastMessage blocked after shutdown")
+	}
+}
+
+func TestShutdownGivesUpOnStuckWriter(t *testing.T) {
+	h, _, f := newTestHub(t, nil)
+	startHub(t, h)
+	alice, _ := dial(t, h, f.Alice)
+	// Bob's write pump never runs, so his queue can't drain
+	bob, _, _ := dialStalled(t, h, f.Bob)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := h.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown = %v, want the deadline to pass", err)
+	}
+	if code := readClose(t, alice); code != CloseServerRestart {
+		t.Errorf("alice closed with %d, want %d", code, CloseServerRestart)
+	}
+	// Bob's connection is closed outright rather than left open
+	select {
+	case <-bob.err:
+	case <-time.After(frameWait):
+		t.Fatal("the stuck connection was never closed")
+	}
+}
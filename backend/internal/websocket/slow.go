@@ -0,0 +1,89 @@
+package websocket
+
+import (
+	"encoding/json"
+
+	"messager/internal/models"
+)
+
+// What the hub does when a client's send queue is full
+const (
+	// slowClientDisconnect closes the connection with 1013 "client too
+	// slow"; the client reconnects and syncs
+	slowClientDisconnect = "disconnect"
+	// slowClientDropOldest discards the oldest queued frame to make room
+	// and tells the client to resync with a "resync_required" event
+	slowClientDropOldest = "drop-oldest"
+)
+
+// defaultSendBuffer is the per-connection send queue size used when
+// WS_SEND_BUFFER is out of range
+const defaultSendBuffer = 256
+
+// dropOldestAttempts bounds how often a sender frees a slot that another
+// sender then takes before giving up on its frame
+const dropOldestAttempts = 3
+
+// enqueue queues data for client under the slow-client policy. It reports
+// whether the frame was queued and whether the client has to be evicted,
+// which the caller does with h.evict once it has released h.mu. The caller
+// must hold h.mu, so the queue can't be closed underneath it.
+func (h *Hub) enqueue(client *Client, data []byte) (queued, evict bool) {
+	select {
+	case h.queue(client) <- data:
+		h.countSend(client, true)
+		return true, false
+	default:
+	}
+
+	if h.slowClientPolicy != slowClientDropOldest {
+		h.countSend(client, false)
+		return false, true
+	}
+
+	for i := 0; i < dropOldestAttempts; i++ {
+		select {
+		case <-client.send:
+			h.countSend(client, false)
+			// Whatever was lost may have been for the active conversation
+			client.active.Store(0)
+			client.resync.Store(true)
+		default:
+		}
+		select {
+		case h.queue(client) <- data:
+			h.countSend(client, true)
+			return true, false
+		default:
+		}
+	}
+	h.countSend(client, false)
+	return false, false
+}
+
+// enqueue queues a frame for this connection alone, such as a reply to
+// something it sent, and evicts it if the policy says so
+func (c *Client) enqueue(data []byte) bool {
+	h := c.hub
+	h.mu.RLock()
+	_, registered := h.clients[c]
+	var queued, evict bool
+	if registered {
+		queued, evict = h.enqueue(c, data)
+	}
+	h.mu.RUnlock()
+	if evict {
+		h.evict([]*Client{c})
+	}
+	return queued
+}
+
+// resyncFrame is written ahead of the next frame after queued ones were
+// discarded, so the client knows to sync and re-declare its active
+// conversation
+func resyncFrame() ([]byte, error) {
+	return json.Marshal(models.WebSocketMessage{
+		Type:    "resync_required",
+		Payload: map[string]interface{}{"reason": "queue_overflow"},
+	})
+}
@@ -0,0 +1,100 @@
+package websocket
+
+import (
+	"testing"
+
+	"messager/internal/config"
+)
+
+// slowHub returns a hub that isn't running, with one registered client
+// whose queue holds two frames and is never drained
+func slowHub(t *testing.T, policy string) (*Hub, *Client) {
+	t.Helper()
+	h, _, f := newTestHub(t, func(cfg *config.Config) {
+		cfg.WSSendBuffer = 2
+		cfg.WSSlowClientPolicy = policy
+	})
+	client := NewClient(h, nil, f.Alice.ID, 0, f.Alice.Username, false)
+	h.mu.Lock()
+	h.clients[client] = true
+	h.userMap[client.userID] = map[*Client]struct{}{client: {}}
+	h.mu.Unlock()
+	return h, client
+}
+
+func TestSlowClientPolicyFallback(t *testing.T) {
+	h, _, _ := newTestHub(t, func(cfg *config.Config) {
+		cfg.WSSendBuffer = 0
+		cfg.WSSlowClientPolicy = "ignore"
+	})
+	if h.sendBuffer != defaultSendBuffer || h.slowClientPolicy != slowClientDisconnect {
+		t.Errorf("buffer %d, policy %q, want %d and %q", h.sendBuffer, h.slowClientPolicy, defaultSendBuffer, slowClientDisconnect)
+	}
+}
+
+func TestEnqueueDisconnectPolicy(t *testing.T) {
+	h, client := slowHub(t, slowClientDisconnect)
+
+	h.mu.RLock()
+	for _, frame := range []string{"a", "b"} {
+		if queued, evict := h.enqueue(client, []byte(frame)); !queued || evict {
+			t.Errorf("frame %s: queued %t, evict %t", frame, queued, evict)
+		}
+	}
+	queued, evict := h.enqueue(client, []byte("c"))
+	h.mu.RUnlock()
+	if queued || !evict {
+		t.Errorf("frame into a full queue: queued %t, evict %t, want it refused and the client evicted", queued, evict)
+	}
+	if stats := h.Stats(); stats.Delivered != 2 || stats.Dropped != 1 {
+		t.Errorf("delivered %d, dropped %d, want 2 and 1", stats.Delivered, stats.Dropped)
+	}
+}
+
+func TestEnqueueDropOldestPolicy(t *testing.T) {
+	h, client := slowHub(t, slowClientDropOldest)
+	client.active.Store(42)
+
+	h.mu.RLock()
+	for _, frame := range []string{"a", "b", "c"} {
+		if queued, evict := h.enqueue(client, []byte(frame)); !queued || evict {
+			t.Errorf("frame %s: queued %t, evict %t", frame, queued, evict)
+		}
+	}
+	h.mu.RUnlock()
+
+	// The oldest frame made room for the newest, and the client is told to
+	// resync and pick its active conversation again
+	var left []string
+	for len(client.send) > 0 {
+		left = append(left, string(<-client.send))
+	}
+	if len(left) != 2 || left[0] != "b" || left[1] != "c" {
+		t.Errorf("queue holds %v, want [b c]", left)
+	}
+	if !client.resync.Load() || client.active.Load() != 0 {
+		t.Errorf("resync %t, active %d, want a resync and no active conversation", client.resync.Load(), client.active.Load())
+	}
+	if n := h.Stats().Evictions; n != 0 {
+		t.Errorf("%d evictions under drop-oldest", n)
+	}
+}
+
+func TestDirectReplyFollowsPolicy(t *testing.T) {
+	// A reply to the client itself, like an error, goes through the same
+	// policy as a broadcast
+	h, client := slowHub(t, slowClientDisconnect)
+	client.sendError("first", "", nil)
+	client.sendError("second", "", nil)
+	client.sendError("third", "", nil)
+
+	h.mu.RLock()
+	_, registered := h.clients[client]
+	h.mu.RUnlock()
+	if registered || !client.evicted.Load() {
+		t.Errorf("registered %t, evicted %t, want the client evicted", registered, client.evicted.Load())
+	}
+	if n := h.Stats().Evictions; n != 1 {
+		t.Errorf("%d evictions, want 1", n)
+	}
+}
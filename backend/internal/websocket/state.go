@@ -0,0 +1,267 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"hash/fnv"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"messager/internal/models"
+	"messager/internal/ratelimit"
+)
+
+// Ephemeral conversation state ("alice is viewing", "bob scrolled to message
+// 42") is relayed between participants and held in memory only until its TTL
+// runs out. Nothing here is persisted.
+const (
+	maxStateKeyLength   = 64
+	maxStateValueBytes  = 512
+	maxStatesPerUser    = 16 // live keys per user per conversation
+	defaultStateTTL     = 30 * time.Second
+	maxStateTTL         = 5 * time.Minute
+	stateUpdatesPerSec  = 5
+	stateUpdateBurst    = 10
+	stateLimiterIdle    = 5 * time.Minute
+	stateExpiredTTL     = "ttl"
+	stateExpiredCleared = "cleared"
+	stateExpiredGone    = "disconnected"
+)
+
+// stateKeyPattern requires a namespace, e.g. "presence.viewing" or
+// "cursor.message", so unrelated features can't trample each other's keys
+var stateKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]*(\.[a-z0-9_]+)+$`)
+
+type stateKey struct {
+	conversationID int64
+	userID         int64
+	key            string
+}
+
+type stateEntry struct {
+	value        json.RawMessage
+	expiresAt    time.Time
+	timer        *time.Timer
+	participants []int64
+}
+
+// stateRelay tracks live ephemeral state so it can announce expiry, and
+// limits how often each user may update each key
+type stateRelay struct {
+	hub     *Hub
+	limiter *ratelimit.Limiter
+
+	mu      sync.Mutex
+	entries map[stateKey]*stateEntry
+}
+
+func newStateRelay(h *Hub) *stateRelay {
+	return &stateRelay{
+		hub:     h,
+		limiter: ratelimit.New(stateUpdatesPerSec, stateUpdateBurst),
+		entries: make(map[stateKey]*stateEntry),
+	}
+}
+
+// limiterKey folds a state key into the int64 the shared limiter is keyed by
+func (k stateKey) limiterKey() int64 {
+	h := fnv.New64a()
+	h.Write([]byte(strconv.FormatInt(k.conversationID, 10)))
+	h.Write([]byte{0})
+	h.Write([]byte(strconv.FormatInt(k.userID, 10)))
+	h.Write([]byte{0})
+	h.Write([]byte(k.key))
+	return int64(h.Sum64())
+}
+
+// handleState validates a client's "state" event and relays it to the other
+// participants. A null value clears the key.
+func (c *Client) handleState(event models.StateEvent) {
+	conversationID, key := event.ConversationID, event.Key
+	if conversationID <= 0 || key == "" {
+		c.sendError("invalid_state", "conversation_id and key are required", nil)
+		return
+	}
+
+	if len(key) > maxStateKeyLength || !stateKeyPattern.MatchString(key) {
+		c.sendError("invalid_state", "key must be a namespaced identifier like \"presence.viewing\"", map[string]interface{}{
+			"key": key,
+		})
+		return
+	}
+
+	ttl := defaultStateTTL
+	if event.TTL > 0 {
+		ttl = time.Duration(event.TTL * float64(time.Second))
+		if ttl > maxStateTTL {
+			ttl = maxStateTTL
+		}
+	}
+
+	var value json.RawMessage
+	if len(event.Value) > 0 && string(event.Value) != "null" {
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, event.Value); err != nil || compact.Len() > maxStateValueBytes {
+			c.sendError("invalid_state", "value is too large", map[string]interface{}{
+				"key":       key,
+				"max_bytes": maxStateValueBytes,
+			})
+			return
+		}
+		value = compact.Bytes()
+	}
+
+	k := stateKey{conversationID: conversationID, userID: c.userID, key: key}
+	if allowed, retryAfter := c.hub.state.limiter.Allow(k.limiterKey()); !allowed {
+		c.sendError("rate_limited", "Too many state updates for this key", map[string]interface{}{
+			"key":         key,
+			"retry_after": retryAfter.Seconds(),
+		})
+		return
+	}
+
+	isParticipant, err := c.hub.db.IsConversationParticipant(c.ctx, conversationID, c.userID)
+	if err != nil {
+		c.hub.logger.Printf("Failed to check membership: %v", err)
+		return
+	}
+	if !isParticipant {
+		c.sendError("forbidden", "Not a participant in this conversation", map[string]interface{}{
+			"conversation_id": conversationID,
+		})
+		return
+	}
+
+	if value == nil {
+		c.hub.state.clear(k, stateExpiredCleared)
+		return
+	}
+
+	participants, err := c.hub.participantIDs(c.ctx, conversationID)
+	if err != nil {
+		c.hub.logger.Printf("Failed to get conversation participants: %v", err)
+		return
+	}
+	if !c.hub.state.set(k, value, ttl, participants) {
+		c.sendError("invalid_state", "Too many live state keys in this conversation", map[string]interface{}{
+			"max_keys": maxStatesPerUser,
+		})
+	}
+}
+
+// set stores or refreshes a key and relays it. It returns false when the
+// user already holds the maximum number of keys in the conversation.
+func (s *stateRelay) set(k stateKey, value json.RawMessage, ttl time.Duration, participants []int64) bool {
+	s.mu.Lock()
+	entry, exists := s.entries[k]
+	if !exists {
+		count := 0
+		for other := range s.entries {
+			if other.conversationID == k.conversationID && other.userID == k.userID {
+				count++
+			}
+		}
+		if count >= maxStatesPerUser {
+			s.mu.Unlock()
+			return false
+		}
+		entry = &stateEntry{}
+		s.entries[k] = entry
+		entry.timer = time.AfterFunc(ttl, func() { s.expire(k, entry) })
+	} else {
+		entry.timer.Reset(ttl)
+	}
+	entry.value = value
+	entry.expiresAt = time.Now().Add(ttl).UTC()
+	entry.participants = participants
+	expiresAt := entry.expiresAt
+	s.mu.Unlock()
+
+	s.hub.SendToConversation(k.conversationID, models.WebSocketMessage{
+		Type: "state",
+		Payload: map[string]interface{}{
+			"conversation_id": k.conversationID,
+			"user_id":         k.userID,
+			"key":             k.key,
+			"value":           value,
+			"ttl":             ttl.Seconds(),
+			"expires_at":      expiresAt,
+		},
+	}, others(participants, k.userID))
+	return true
+}
+
+// expire fires from the entry's timer. A refresh that raced the timer has
+// pushed expiresAt forward, in which case the entry stays.
+func (s *stateRelay) expire(k stateKey, entry *stateEntry) {
+	s.mu.Lock()
+	if s.entries[k] != entry || time.Now().Before(entry.expiresAt) {
+		s.mu.Unlock()
+		return
+	}
+	delete(s.entries, k)
+	s.mu.Unlock()
+	s.announceExpired(k, entry.participants, stateExpiredTTL)
+}
+
+func (s *stateRelay) clear(k stateKey, reason string) {
+	s.mu.Lock()
+	entry, ok := s.entries[k]
+	if ok {
+		entry.timer.Stop()
+		delete(s.entries, k)
+	}
+	s.mu.Unlock()
+	if ok {
+		s.announceExpired(k, entry.participants, reason)
+	}
+}
+
+// clearUser drops everything a user published, e.g. when they disconnect
+func (s *stateRelay) clearUser(userID int64) {
+	s.mu.Lock()
+	var dropped []stateKey
+	var entries []*stateEntry
+	for k, entry := range s.entries {
+		if k.userID == userID {
+			entry.timer.Stop()
+			delete(s.entries, k)
+			dropped = append(dropped, k)
+			entries = append(entries, entry)
+		}
+	}
+	s.mu.Unlock()
+
+	for i, k := range dropped {
+		s.announceExpired(k, entries[i].participants, stateExpiredGone)
+	}
+}
+
+func (s *stateRelay) announceExpired(k stateKey, participants []int64, reason string) {
+	s.hub.SendToConversation(k.conversationID, models.WebSocketMessage{
+		Type: "state_expired",
+		Payload: map[string]interface{}{
+			"conversation_id": k.conversationID,
+			"user_id":         k.userID,
+			"key":             k.key,
+			"reason":          reason,
+		},
+	}, others(participants, k.userID))
+}
+
+func (s *stateRelay) prune() {
+	s.limiter.Prune(stateLimiterIdle)
+}
+
+// others returns ids without userID
+func others(ids []int64, userID int64) []int64 {
+	out := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if id != userID {
+			out = append(out, id)
+		}
+	}
+	return out
+}
@@ -0,0 +1,117 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestStateIsRelayedToOthersAndExpires(t *testing.T) {
+	h, _, f := newTestHub(t, nil)
+	startHub(t, h)
+	alice, _ := dial(t, h, f.Alice)
+	bob, _ := dial(t, h, f.Bob)
+	readUntil(t, alice, "system")
+	readUntil(t, bob, "system")
+
+	send(t, alice, "state", map[string]interface{}{
+		"conversation_id": f.Direct.ID, "key": "presence.viewing", "value": map[string]bool{"on": true}, "ttl": 0.2,
+	})
+	got := readUntil(t, bob, "state")
+	if got.Payload["key"] != "presence.viewing" || got.Payload["user_id"] != float64(f.Alice.ID) || got.Payload["ttl"] != 0.2 {
+		t.Fatalf("relayed %+v", got.Payload)
+	}
+	if value, _ := json.Marshal(got.Payload["value"]); string(value) != `{"on":true}` {
+		t.Errorf("value = %s", value)
+	}
+
+	expired := readUntil(t, bob, "state_expired")
+	if expired.Payload["reason"] != stateExpiredTTL {
+		t.Errorf("expired with reason %v, want ttl", expired.Payload["reason"])
+	}
+	// The sender never hears its own state back
+	expectNoFrameOfType(t, alice, "state", 100*time.Millisecond)
+}
+
+func TestStateClearAndDisconnect(t *testing.T) {
+	h, _, f := newTestHub(t, nil)
+	startHub(t, h)
+	alice, _ := dial(t, h, f.Alice)
+	bob, _ := dial(t, h, f.Bob)
+	readUntil(t, bob, "system")
+
+	for _, key := range []string{"cursor.message", "presence.viewing"} {
+		send(t, alice, "state", map[string]interface{}{"conversation_id": f.Direct.ID, "key": key, "value": 1})
+		readUntil(t, bob, "state")
+	}
+	send(t, alice, "state", map[string]interface{}{"conversation_id": f.Direct.ID, "key": "cursor.message", "value": nil})
+	if got := readUntil(t, bob, "state_expired"); got.Payload["reason"] != stateExpiredCleared || got.Payload["key"] != "cursor.message" {
+		t.Fatalf("clearing sent %+v", got.Payload)
+	}
+
+	alice.Close()
+	if got := readUntil(t, bob, "state_expired"); got.Payload["reason"] != stateExpiredGone || got.Payload["key"] != "presence.viewing" {
+		t.Fatalf("disconnecting sent %+v", got.Payload)
+	}
+}
+
+func TestStateValidation(t *testing.T) {
+	h, _, f := newTestHub(t, nil)
+	startHub(t, h)
+	carol, _ := dial(t, h, f.Carol)
+	readUntil(t, carol, "system")
+
+	for _, tc := range []struct {
+		event map[string]interface{}
+		code  string
+	}{
+		{map[string]interface{}{"conversation_id": f.Group.ID, "key": "viewing", "value": 1}, "invalid_state"},
+		{map[string]interface{}{"conversation_id": f.Group.ID, "key": "presence.viewing", "value": string(make([]byte, maxStateValueBytes))}, "invalid_state"},
+		{map[string]interface{}{"conversation_id": f.Direct.ID, "key": "presence.viewing", "value": 1}, "forbidden"},
+	} {
+		send(t, carol, "state", tc.event)
+		if got := readUntil(t, carol, "error"); got.Payload["code"] != tc.code {
+			t.Errorf("%v: error %v, want %s", tc.event, got.Payload["code"], tc.code)
+		}
+	}
+
+	for i := 0; i < maxStatesPerUser; i++ {
+		send(t, carol, "state", map[string]interface{}{"conversation_id": f.Group.ID, "key": fmt.Sprintf("test.key%d", i), "value": i})
+	}
+	send(t, carol, "state", map[string]interface{}{"conversation_id": f.Group.ID, "key": "test.one_too_many", "value": 1})
+	if got := readUntil(t, carol, "error"); got.Payload["max_keys"] != float64(maxStatesPerUser) {
+		t.Errorf("key %d: %+v, want the per-user key limit", maxStatesPerUser+1, got.Payload)
+	}
+}
+
+// A refresh that lands while the old timer is firing must keep the entry
+func TestStateRefreshPushesExpiryBack(t *testing.T) {
+	h, _, f := newTestHub(t, nil)
+	k := stateKey{conversationID: f.Direct.ID, userID: f.Alice.ID, key: "presence.viewing"}
+	participants := []int64{f.Alice.ID, f.Bob.ID}
+
+	h.state.set(k, json.RawMessage(`1`), 50*time.Millisecond, participants)
+	time.Sleep(30 * time.Millisecond)
+	h.state.set(k, json.RawMessage(`2`), 200*time.Millisecond, participants)
+	h.state.mu.Lock()
+	entry := h.state.entries[k]
+	h.state.mu.Unlock()
+
+	// The old timer firing now finds the expiry moved on
+	h.state.expire(k, entry)
+	time.Sleep(50 * time.Millisecond)
+	h.state.mu.Lock()
+	_, live := h.state.entries[k]
+	h.state.mu.Unlock()
+	if !live {
+		t.Fatal("a refreshed key expired on its old TTL")
+	}
+
+	waitFor(t, "the refreshed key to expire", func() bool {
+		h.state.mu.Lock()
+		defer h.state.mu.Unlock()
+		_, live := h.state.entries[k]
+		return !live
+	})
+}
@@ -0,0 +1,90 @@
+package websocket
+
+import "sync/atomic"
+
+// Stats is a snapshot of the hub's connection and delivery counters.
+// Counters run from startup; ConnectedClients and UniqueUsers are current.
+type Stats struct {
+	ConnectedClients int `json:"connected_clients"`
+	UniqueUsers      int `json:"unique_users"`
+
+	Registrations   int64 `json:"registrations"`
+	Unregistrations int64 `json:"unregistrations"`
+
+	// Broadcasts counts messages sent to every client and
+	// BroadcastsDropped those lost to a full broadcast queue. Delivered
+	// counts every frame queued to a client, and Dropped every frame that
+	// wasn't because the client's send queue was full or was discarded
+	// from it to make room. Evictions counts clients disconnected over a
+	// full queue.
+	Broadcasts        int64 `json:"broadcasts"`
+	BroadcastsDropped int64 `json:"broadcasts_dropped"`
+	Delivered         int64 `json:"delivered"`
+	Dropped           int64 `json:"dropped"`
+	Evictions         int64 `json:"evictions"`
+
+	// Reaped counts connections closed for going quiet longer than
+	// WS_STALE_AFTER
+	Reaped int64 `json:"reaped"`
+
+	// OutboxRedelivered counts messages the outbox dispatcher fanned out
+	// because their first delivery never completed
+	OutboxRedelivered int64 `json:"outbox_redelivered"`
+
+	// PersistQueueDepth is how many websocket messages are waiting to be
+	// saved, and PersistRejected how many were refused for a full queue
+	PersistQueueDepth int   `json:"persist_queue_depth"`
+	PersistRejected   int64 `json:"persist_rejected"`
+
+	// HeartbeatRTT is the distribution of heartbeat round trips clients
+	// reported in their heartbeat_ack
+	HeartbeatRTT RTTStats `json:"heartbeat_rtt"`
+}
+
+type hubCounters struct {
+	registrations     atomic.Int64
+	unregistrations   atomic.Int64
+	broadcasts        atomic.Int64
+	broadcastsDropped atomic.Int64
+	delivered         atomic.Int64
+	dropped           atomic.Int64
+	evictions         atomic.Int64
+	reaped            atomic.Int64
+	outboxRedelivered atomic.Int64
+	heartbeatRTT      rttHistogram
+}
+
+// countSend records whether a frame made it into a client's queue
+func (h *Hub) countSend(client *Client, queued bool) {
+	if queued {
+		h.counters.delivered.Add(1)
+		client.recordQueued()
+	} else {
+		h.counters.dropped.Add(1)
+		client.dropped.Add(1)
+	}
+}
+
+// Stats returns the hub's current counters
+func (h *Hub) Stats() Stats {
+	h.mu.RLock()
+	clients, users := len(h.clients), len(h.userMap)
+	h.mu.RUnlock()
+
+	return Stats{
+		ConnectedClients:  clients,
+		UniqueUsers:       users,
+		Registrations:     h.counters.registrations.Load(),
+		Unregistrations:   h.counters.unregistrations.Load(),
+		Broadcasts:        h.counters.broadcasts.Load(),
+		BroadcastsDropped: h.counters.broadcastsDropped.Load(),
+		Delivered:         h.counters.delivered.Load(),
+		Dropped:           h.counters.dropped.Load(),
+		Evictions:         h.counters.evictions.Load(),
+		Reaped:            h.counters.reaped.Load(),
+		OutboxRedelivered: h.counters.outboxRedelivered.Load(),
+		PersistQueueDepth: h.persist.depth(),
+		PersistRejected:   h.persist.rejected.Load(),
+		HeartbeatRTT:      h.counters.heartbeatRTT.snapshot(),
+	}
+}
@@ -0,0 +1,162 @@
+package websocket
+
+import (
+	"bytes"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// streamBuffer is how many events a subscriber can fall behind by before
+// it's cut off
+const streamBuffer = 64
+
+// streamSet holds the subscribers to each user's events. A subscriber whose
+// channel is full is dropped and its channel closed, so a stalled reader
+// never holds up delivery to the user's other connections.
+type streamSet struct {
+	mu       sync.Mutex
+	users    map[int64]map[*stream]struct{}
+	released bool
+}
+
+type stream struct {
+	ch chan []byte
+}
+
+func newStreamSet() *streamSet {
+	return &streamSet{users: make(map[int64]map[*stream]struct{})}
+}
+
+// add subscribes to the user's events. Once the set is released the stream
+// comes back already closed.
+func (s *streamSet) add(userID int64) *stream {
+	st := &stream{ch: make(chan []byte, streamBuffer)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.released {
+		close(st.ch)
+		return st
+	}
+	if s.users[userID] == nil {
+		s.users[userID] = make(map[*stream]struct{})
+	}
+	s.users[userID][st] = struct{}{}
+	return st
+}
+
+// remove unsubscribes st, closing its channel if send hasn't already, and
+// reports whether the user has no subscribers left
+func (s *streamSet) remove(userID int64, st *stream) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[userID][st]; ok {
+		delete(s.users[userID], st)
+		close(st.ch)
+	}
+	if len(s.users[userID]) > 0 {
+		return false
+	}
+	delete(s.users, userID)
+	return true
+}
+
+// has reports whether anyone is subscribed to the user's events
+func (s *streamSet) has(userID int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.users[userID]) > 0
+}
+
+// send hands frame to the user's subscribers
+func (s *streamSet) send(userID int64, frame []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for st := range s.users[userID] {
+		select {
+		case st.ch <- frame:
+		default:
+			delete(s.users[userID], st)
+			close(st.ch)
+		}
+	}
+}
+
+// release closes every subscriber's channel and refuses new ones
+func (s *streamSet) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.released = true
+	for userID, streams := range s.users {
+		for st := range streams {
+			close(st.ch)
+		}
+		delete(s.users, userID)
+	}
+}
+
+// Subscribe streams the events sent to userID, numbered with their event_id
+// the way a websocket gets them, until cancel is called. Typing, state and
+// broadcast events aren't included. A subscriber that falls streamBuffer
+// events behind, or is still subscribed when the server shuts down, has its
+// channel closed; it should come back and catch up with EventsSince.
+func (h *Hub) Subscribe(userID int64) (<-chan []byte, func()) {
+	st := h.streams.add(userID)
+	h.replay.connected(userID)
+	var once sync.Once
+	return st.ch, func() {
+		once.Do(func() {
+			if h.streams.remove(userID, st) && !h.IsConnected(userID) && !h.pollers.wants(userID) {
+				h.replay.disconnected(userID, time.Now())
+			}
+		})
+	}
+}
+
+// EventsSince returns the user's events after lastID from the replay
+// buffer, like a websocket "resume". ok is false when they can't all be
+// replayed and the client has to sync instead; newest is then the latest
+// ID kept, or 0.
+func (h *Hub) EventsSince(userID, lastID int64) (frames [][]byte, newest int64, ok bool) {
+	return h.replay.since(userID, lastID)
+}
+
+// ReleaseListeners ends every event stream and long poll so the HTTP
+// server can shut down without waiting for them. Shutdown calls it too.
+func (h *Hub) ReleaseListeners() {
+	h.releaseOnce.Do(func() {
+		close(h.released)
+		h.streams.release()
+	})
+}
+
+// keepForListeners numbers data as the user's next event and hands it to
+// their event streams and long polls. It reports false when the user has
+// neither. Callers hold h.mu and have checked that the user has no
+// websocket here.
+func (h *Hub) keepForListeners(userID int64, data []byte) bool {
+	polling := h.replay.size > 0 && h.pollers.wants(userID)
+	if !polling && !h.streams.has(userID) {
+		return false
+	}
+	h.streams.send(userID, h.replay.stamp(userID, data))
+	if polling {
+		h.pollers.notify(userID)
+	}
+	return true
+}
+
+// EventID reads the event_id the hub put at the front of a frame
+func EventID(frame []byte) (int64, bool) {
+	const prefix = `{"event_id":`
+	if !bytes.HasPrefix(frame, []byte(prefix)) {
+		return 0, false
+	}
+	rest := frame[len(prefix):]
+	end := bytes.IndexByte(rest, ',')
+	if end < 0 {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(string(rest[:end]), 10, 64)
+	return id, err == nil
+}
@@ -0,0 +1,27 @@
+package websocket
+
+import "messager/internal/errsink"
+
+// SetErrSink routes the hub's swallowed errors through sink, which counts
+// them and, in strict mode, has them reported to the affected client. It
+// must be called before the hub starts serving.
+func (h *Hub) SetErrSink(sink *errsink.Sink) {
+	h.errs = sink
+}
+
+// fail records a failure that cost the client what it asked for and tells
+// it so with an "error" event. In strict mode the event also carries the
+// diagnostic fields (category, site, error).
+func (c *Client) fail(category errsink.Category, site string, err error, code, message string) {
+	c.failFor(c.frameClientID, category, site, err, code, message)
+}
+
+// failFor is fail for work done off ReadPump, answering the frame with
+// clientID
+func (c *Client) failFor(clientID string, category errsink.Category, site string, err error, code, message string) {
+	var fields map[string]interface{}
+	if d := c.hub.errs.Swallow(category, site, err); d != nil {
+		fields = d.Fields()
+	}
+	c.sendErrorFor(clientID, code, message, fields)
+}
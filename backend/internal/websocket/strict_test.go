@@ -0,0 +1,91 @@
+package websocket
+
+import (
+	"fmt"
+	"testing"
+
+	"messager/internal/chaos"
+	"messager/internal/config"
+	"messager/internal/errsink"
+	"messager/internal/models"
+)
+
+func TestMarshalFailureToUser(t *testing.T) {
+	t.Run("lenient", func(t *testing.T) {
+		h, _, f := newTestHub(t, nil)
+		sink := errsink.New(errsink.Options{})
+		h.SetErrSink(sink)
+		startHub(t, h)
+		conn, _ := dial(t, h, f.Alice)
+		readUntil(t, conn, "system")
+
+		bad := models.WebSocketMessage{Type: "notice", Payload: make(chan int)}
+		if err := h.SendToUser(f.Alice.ID, bad); err == nil {
+			t.Error("unencodable payload sent without an error")
+		}
+		if n := sink.Snapshot().Counts[errsink.Marshal]; n != 1 {
+			t.Errorf("marshal count = %d, want 1", n)
+		}
+
+		// The connection is untouched
+		if err := h.SendToUser(f.Alice.ID, models.WebSocketMessage{Type: "notice", Payload: map[string]string{"text": "ok"}}); err != nil {
+			t.Fatal(err)
+		}
+		readUntil(t, conn, "notice")
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		h, _, f := newTestHub(t, nil)
+		sink := errsink.New(errsink.Options{Strict: true, StrictPanic: true})
+		h.SetErrSink(sink)
+
+		defer func() {
+			if recover() == nil {
+				t.Error("unencodable payload didn't panic in strict mode")
+			}
+		}()
+		h.SendToUser(f.Alice.ID, models.WebSocketMessage{Type: "notice", Payload: make(chan int)})
+	})
+}
+
+func TestPersistQueueFull(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		t.Run(fmt.Sprintf("strict=%t", strict), func(t *testing.T) {
+			h, d, f := newTestHub(t, func(cfg *config.Config) {
+				cfg.WSPersistWorkers = 1
+				cfg.WSPersistQueue = 1
+			})
+			sink := errsink.New(errsink.Options{Strict: strict})
+			h.SetErrSink(sink)
+			injector := chaos.New()
+			d.SetChaos(injector)
+			startHub(t, h)
+			conn, _ := dial(t, h, f.Alice)
+			readUntil(t, conn, "system")
+
+			// One save holds the worker, one waits in the queue and the
+			// rest find it full
+			if err := injector.SetProfile(chaos.Profile{DB: map[string]chaos.DBFault{"insert_message": {LatencyMS: 300}}}); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 4; i++ {
+				sendMessage(t, conn, f.Group.ID, fmt.Sprintf("m%d", i))
+			}
+
+			busy := readUntil(t, conn, "error").Payload
+			if busy["code"] != "server_busy" {
+				t.Fatalf("error = %v, want server_busy", busy)
+			}
+			if site, surfaced := busy["site"]; surfaced != strict || (strict && (site != "ws.persist_queue" || busy["category"] != "dropped")) {
+				t.Errorf("strict=%t: error fields %v", strict, busy)
+			}
+			if n := sink.Snapshot().Counts[errsink.Dropped]; n == 0 {
+				t.Error("the dropped message wasn't counted")
+			}
+
+			// Either way the queued messages are still saved and delivered
+			readMessage(t, conn, "m0")
+			readMessage(t, conn, "m1")
+		})
+	}
+}
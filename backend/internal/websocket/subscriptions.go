@@ -0,0 +1,162 @@
+package websocket
+
+import (
+	"sort"
+	"sync"
+
+	"messager/internal/models"
+)
+
+// maxSubscriptions caps how many conversations a client may subscribe to,
+// or unsubscribe from while it receives all of them
+const maxSubscriptions = 500
+
+// ephemeralEvents are the event types a client only receives for the
+// conversations it's subscribed to. Messages, membership and conversation
+// changes are always delivered.
+var ephemeralEvents = map[string]bool{
+	"typing":        true,
+	"state":         true,
+	"state_expired": true,
+}
+
+// isEphemeral reports whether message is subject to subscriptions
+func isEphemeral(message interface{}) bool {
+	msg, ok := message.(models.WebSocketMessage)
+	return ok && ephemeralEvents[msg.Type]
+}
+
+// subscriptions is the set of conversations a connection wants ephemeral
+// events for. It starts out covering every conversation and is never
+// carried over to a new connection.
+type subscriptions struct {
+	mu sync.Mutex
+	// While explicit is false the client gets every conversation except
+	// those in ids; once it subscribes to specific ones it gets only ids
+	explicit bool
+	ids      map[int64]bool
+}
+
+// wants reports whether ephemeral events for conversationID should be
+// delivered
+func (s *subscriptions) wants(conversationID int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ids[conversationID] == s.explicit
+}
+
+// subscribe adds conversations, switching an unrestricted client to only
+// the ones it names. It reports false, changing nothing, when that would
+// exceed maxSubscriptions.
+func (s *subscriptions) subscribe(ids []int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.explicit {
+		if !fitsCap(nil, ids) {
+			return false
+		}
+		s.explicit, s.ids = true, make(map[int64]bool, len(ids))
+	} else if !fitsCap(s.ids, ids) {
+		return false
+	}
+	for _, id := range ids {
+		s.ids[id] = true
+	}
+	return true
+}
+
+// unsubscribe drops conversations, or excludes them while the client gets
+// everything. It reports false, changing nothing, when an exclusion would
+// exceed maxSubscriptions.
+func (s *subscriptions) unsubscribe(ids []int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.explicit {
+		for _, id := range ids {
+			delete(s.ids, id)
+		}
+		return true
+	}
+	if !fitsCap(s.ids, ids) {
+		return false
+	}
+	if s.ids == nil {
+		s.ids = make(map[int64]bool, len(ids))
+	}
+	for _, id := range ids {
+		s.ids[id] = true
+	}
+	return true
+}
+
+// reset goes back to every conversation
+func (s *subscriptions) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.explicit, s.ids = false, nil
+}
+
+// snapshot returns whether the client gets every conversation, and the
+// conversations subscribed to or, when it does, excluded
+func (s *subscriptions) snapshot() (bool, []int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]int64, 0, len(s.ids))
+	for id := range s.ids {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return !s.explicit, ids
+}
+
+// fitsCap reports whether adding ids to set stays within maxSubscriptions
+func fitsCap(set map[int64]bool, ids []int64) bool {
+	n := len(set)
+	seen := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		if !set[id] && !seen[id] {
+			seen[id] = true
+			n++
+		}
+	}
+	return n <= maxSubscriptions
+}
+
+// handleSubscription applies a "subscribe" or "unsubscribe" event and
+// answers with a "subscriptions" event describing the result
+func (c *Client) handleSubscription(event string, req models.SubscriptionRequest) {
+	for _, id := range req.ConversationIDs {
+		if id <= 0 {
+			c.sendError("invalid_subscription", "conversation_ids must be positive", nil)
+			return
+		}
+	}
+
+	ok := true
+	switch {
+	case event == "subscribe" && req.All:
+		c.subs.reset()
+	case len(req.ConversationIDs) == 0:
+		c.sendError("invalid_subscription", "conversation_ids or all is required", nil)
+		return
+	case event == "subscribe":
+		ok = c.subs.subscribe(req.ConversationIDs)
+	default:
+		ok = c.subs.unsubscribe(req.ConversationIDs)
+	}
+	if !ok {
+		c.sendError("too_many_subscriptions", "Too many conversations in the subscription", map[string]interface{}{
+			"max": maxSubscriptions,
+		})
+		return
+	}
+
+	all, ids := c.subs.snapshot()
+	payload := map[string]interface{}{"all": all}
+	if all {
+		payload["except"] = ids
+	} else {
+		payload["conversation_ids"] = ids
+	}
+	c.queueEvent("ws.subscriptions", models.WebSocketMessage{Type: "subscriptions", Payload: payload})
+}
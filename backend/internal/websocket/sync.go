@@ -0,0 +1,100 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"messager/internal/errsink"
+	"messager/internal/logsafe"
+	"messager/internal/models"
+)
+
+const (
+	// syncBacklogLimit is the most messages a "sync" replays; a client
+	// further behind is told to page through the REST history instead
+	syncBacklogLimit = 500
+
+	// syncBatchSize is how many messages go in each "sync_batch" event
+	syncBatchSize = 100
+)
+
+// handleSync replays what the client missed while disconnected: every
+// message newer than what it says it has, across all of the user's
+// conversations, oldest first in "sync_batch" events, then "sync_complete".
+// When more than syncBacklogLimit messages are waiting nothing is replayed
+// and "sync_complete" says truncated, so the client refetches over REST.
+func (c *Client) handleSync(req models.SyncRequest) {
+	messages, err := c.hub.db.GetMessagesSince(c.ctx, c.userID, req.LastMessageID, req.Conversations, syncBacklogLimit+1)
+	if err != nil {
+		c.hub.logger.Printf("Failed to load sync backlog for user %d: %v", c.userID, err)
+		c.fail(errsink.Store, "ws.sync", err, "sync_failed", "Failed to load missed messages")
+		return
+	}
+
+	if len(messages) > syncBacklogLimit {
+		c.queueEvent("ws.sync", models.WebSocketMessage{
+			Type: "sync_complete",
+			Payload: map[string]interface{}{
+				"count":     0,
+				"truncated": true,
+				"limit":     syncBacklogLimit,
+			},
+		})
+		return
+	}
+
+	for start := 0; start < len(messages); start += syncBatchSize {
+		end := start + syncBatchSize
+		if end > len(messages) {
+			end = len(messages)
+		}
+		if !c.queueEvent("ws.sync", models.WebSocketMessage{
+			Type:    "sync_batch",
+			Payload: map[string]interface{}{"messages": messages[start:end]},
+		}) {
+			return
+		}
+	}
+
+	var lastID int64
+	latest := make(map[int64]int64)
+	for _, message := range messages {
+		lastID = message.ID
+		if message.SenderID != c.userID {
+			latest[message.ConversationID] = message.ID
+		}
+	}
+	c.queueEvent("ws.sync", models.WebSocketMessage{
+		Type: "sync_complete",
+		Payload: map[string]interface{}{
+			"count":           len(messages),
+			"truncated":       false,
+			"last_message_id": lastID,
+		},
+	})
+
+	// What was replayed has now reached this user
+	for conversationID, messageID := range latest {
+		if err := c.hub.db.MarkDelivered(c.ctx, conversationID, messageID, []int64{c.userID}); err != nil {
+			c.hub.logger.Printf("Failed to record delivery of message %d: %v", messageID, err)
+			c.hub.errs.Swallow(errsink.Store, "ws.sync_delivered", err)
+		}
+	}
+}
+
+// queueEvent queues an event for this client only and reports whether it
+// fit in the send queue
+func (c *Client) queueEvent(site string, msg models.WebSocketMessage) bool {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		c.hub.logger.Printf("Failed to marshal %s event: %v", msg.Type, err)
+		c.hub.errs.Swallow(errsink.Marshal, site, err)
+		return false
+	}
+	if !c.enqueue(data) {
+		c.hub.logger.Printf("Dropped %s event for client: %s", msg.Type, logsafe.String(c.username))
+		c.hub.errs.Swallow(errsink.Dropped, site, fmt.Errorf("queue full for user %d", c.userID))
+		return false
+	}
+	return true
+}
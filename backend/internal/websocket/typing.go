@@ -0,0 +1,132 @@
+package websocket
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"messager/internal/models"
+)
+
+// typingTTL is how long a "typing" event counts without a refresh. Clients
+// don't reliably send is_typing false, so entries simply lapse.
+const typingTTL = 5 * time.Second
+
+// typingTracker remembers who is typing where, so a client opening a
+// conversation can be shown indicators it missed the events for. Expired
+// entries are dropped when read and by the hub's periodic prune.
+type typingTracker struct {
+	mu            sync.Mutex
+	conversations map[int64]map[int64]time.Time // conversation -> user -> last typing event
+}
+
+func newTypingTracker() *typingTracker {
+	return &typingTracker{conversations: make(map[int64]map[int64]time.Time)}
+}
+
+func (t *typingTracker) set(conversationID, userID int64, typing bool, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	users := t.conversations[conversationID]
+	if !typing {
+		delete(users, userID)
+		if len(users) == 0 {
+			delete(t.conversations, conversationID)
+		}
+		return
+	}
+	if users == nil {
+		users = make(map[int64]time.Time)
+		t.conversations[conversationID] = users
+	}
+	users[userID] = now
+}
+
+// snapshot returns who is typing in a conversation, longest-typing first
+func (t *typingTracker) snapshot(conversationID int64, now time.Time) []models.TypingUser {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	users := t.conversations[conversationID]
+	snap := make([]models.TypingUser, 0, len(users))
+	for userID, at := range users {
+		if now.Sub(at) >= typingTTL {
+			delete(users, userID)
+			continue
+		}
+		snap = append(snap, models.TypingUser{UserID: userID, LastTypingAt: at, ExpiresAt: at.Add(typingTTL)})
+	}
+	if len(users) == 0 {
+		delete(t.conversations, conversationID)
+	}
+	sort.Slice(snap, func(i, j int) bool { return snap[i].LastTypingAt.Before(snap[j].LastTypingAt) })
+	return snap
+}
+
+// clearUser drops a user's entries, e.g. when they disconnect
+func (t *typingTracker) clearUser(userID int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for conversationID, users := range t.conversations {
+		delete(users, userID)
+		if len(users) == 0 {
+			delete(t.conversations, conversationID)
+		}
+	}
+}
+
+// prune drops expired entries and returns how many went
+func (t *typingTracker) prune(now time.Time) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	for conversationID, users := range t.conversations {
+		for userID, at := range users {
+			if now.Sub(at) >= typingTTL {
+				delete(users, userID)
+				n++
+			}
+		}
+		if len(users) == 0 {
+			delete(t.conversations, conversationID)
+		}
+	}
+	return n
+}
+
+// recordTyping notes a client's "typing" event for the snapshot and relays
+// it to the conversation's other participants. Events for conversations the
+// user isn't in are ignored.
+func (c *Client) recordTyping(event models.TypingEvent) {
+	conversationID, isTyping := event.ConversationID, event.IsTyping
+	if conversationID <= 0 {
+		return
+	}
+
+	participants, err := c.hub.participantIDs(c.ctx, conversationID)
+	if err != nil {
+		c.hub.logger.Printf("Failed to get conversation participants: %v", err)
+		return
+	}
+	if !contains(participants, c.userID) {
+		// Someone removed mid-sentence can still stop typing
+		if !isTyping {
+			c.hub.typing.set(conversationID, c.userID, false, time.Now().UTC())
+		}
+		return
+	}
+	c.hub.typing.set(conversationID, c.userID, isTyping, time.Now().UTC())
+
+	c.hub.SendToConversation(conversationID, models.WebSocketMessage{
+		Type: "typing",
+		Payload: map[string]interface{}{
+			"user_id":         c.userID,
+			"conversation_id": conversationID,
+			"is_typing":       isTyping,
+		},
+	}, others(participants, c.userID))
+}
+
+// TypingUsers returns the participants currently typing in a conversation
+func (h *Hub) TypingUsers(conversationID int64) []models.TypingUser {
+	return h.typing.snapshot(conversationID, time.Now().UTC())
+}